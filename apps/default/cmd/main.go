@@ -10,6 +10,7 @@ import (
 	"github.com/pitabwire/frame/config"
 	"github.com/pitabwire/frame/datastore"
 	connectInterceptors "github.com/pitabwire/frame/security/interceptors/connect"
+	"github.com/pitabwire/frame/security/interceptors/httptor"
 	"github.com/pitabwire/util"
 
 	aconfig "github.com/antinvestor/service-commerce/apps/default/config"
@@ -49,10 +50,13 @@ func main() {
 	}
 
 	// Setup Connect server
-	connectHandler := setupConnectServer(ctx, svc)
+	connectHandler, implementation := setupConnectServer(ctx, svc)
 
-	// Setup HTTP handlers and start service
-	serviceOptions := []frame.Option{frame.WithHTTPHandler(connectHandler)}
+	// Setup HTTP handlers and background jobs, then start service
+	serviceOptions := []frame.Option{
+		frame.WithHTTPHandler(connectHandler),
+		frame.WithBackgroundConsumer(implementation.RunScheduledTasks),
+	}
 
 	// Initialize the service with all options
 	svc.Init(ctx, serviceOptions...)
@@ -75,13 +79,28 @@ func handleDatabaseMigration(
 		if err != nil {
 			util.Log(ctx).WithError(err).Fatal("main -- Could not migrate successfully")
 		}
+		backfillShopOwners(ctx, dbManager, cfg)
 		return true
 	}
 	return false
 }
 
+// backfillShopOwners gives every shop without an owner the configured admin
+// as its owner.
+func backfillShopOwners(ctx context.Context, dbManager datastore.Manager, cfg aconfig.CommerceConfig) {
+	if cfg.ShopOwnerProfileID == "" {
+		util.Log(ctx).Warn("main -- no shop owner profile configured, shops without an owner are left as they are")
+		return
+	}
+	backfilled, err := repository.BackfillShopOwners(ctx, dbManager, cfg.ShopOwnerProfileID)
+	if err != nil {
+		util.Log(ctx).WithError(err).Fatal("main -- Could not backfill shop owners")
+	}
+	util.Log(ctx).With("shops", backfilled).Info("main -- backfilled shop owners")
+}
+
 // setupConnectServer initializes and configures the gRPC server.
-func setupConnectServer(ctx context.Context, svc *frame.Service) http.Handler {
+func setupConnectServer(ctx context.Context, svc *frame.Service) (http.Handler, *handlers.CommerceServer) {
 	securityMan := svc.SecurityManager()
 	authenticator := securityMan.GetAuthenticator(ctx)
 
@@ -92,11 +111,150 @@ func setupConnectServer(ctx context.Context, svc *frame.Service) http.Handler {
 
 	implementation := handlers.NewCommerceServer(ctx, svc)
 
+	// Domain validation runs innermost, after the proto constraints and
+	// authentication.
+	defaultInterceptorList = append(defaultInterceptorList, handlers.NewValidationInterceptor())
 	_, serverHandler := commercev1connect.NewCommerceServiceHandler(
 		implementation, connect.WithInterceptors(defaultInterceptorList...))
 
 	mux := http.NewServeMux()
 	mux.Handle("/", serverHandler)
+	mux.HandleFunc(handlers.CarrierWebhookPattern, implementation.CarrierWebhook)
+	mux.HandleFunc(handlers.RedeemDownloadPattern, implementation.RedeemDownload)
+	mux.Handle(handlers.PickListPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.PickListDocument), authenticator))
+	mux.Handle(handlers.PackingSlipPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.PackingSlipDocument), authenticator))
+	mux.Handle(handlers.InvoicePattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.InvoiceDocument), authenticator))
+	mux.Handle(handlers.StartCatalogImportPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.StartCatalogImport), authenticator))
+	mux.Handle(handlers.CatalogImportStatusPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.CatalogImportStatus), authenticator))
+	mux.Handle(handlers.CatalogExportPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.CatalogExport), authenticator))
+	mux.Handle(handlers.OrderExportPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.OrderExport), authenticator))
+	mux.Handle(handlers.SalesReportPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.SalesReport), authenticator))
+	mux.Handle(handlers.ProductReportPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.ProductReport), authenticator))
+	mux.Handle(handlers.VariantReportPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.VariantReport), authenticator))
+	mux.Handle(handlers.MyOrdersPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.MyOrders), authenticator))
+	mux.Handle(handlers.ReorderPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.Reorder), authenticator))
+	mux.Handle(handlers.ListShopMembersPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.ListShopMembers), authenticator))
+	mux.Handle(handlers.SetShopMemberPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.SetShopMember), authenticator))
+	mux.Handle(handlers.RemoveShopMemberPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.RemoveShopMember), authenticator))
+	mux.Handle(handlers.ListProductOptionsPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.ListProductOptions), authenticator))
+	mux.Handle(handlers.SetProductOptionsPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.SetProductOptions), authenticator))
+	mux.Handle(handlers.AddProductOptionValuePattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.AddProductOptionValue), authenticator))
+	mux.Handle(handlers.RemoveProductOptionValuePattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.RemoveProductOptionValue), authenticator))
+	mux.Handle(handlers.GenerateVariantMatrixPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.GenerateVariantMatrix), authenticator))
+	mux.Handle(handlers.ListCategoriesPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.ListCategories), authenticator))
+	mux.Handle(handlers.CreateCategoryPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.CreateCategory), authenticator))
+	mux.Handle(handlers.UpdateCategoryPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.UpdateCategory), authenticator))
+	mux.Handle(handlers.CategoryProductsPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.CategoryProducts), authenticator))
+	mux.Handle(handlers.AssignProductCategoriesPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.AssignProductCategories), authenticator))
+	mux.Handle(handlers.ListCollectionsPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.ListCollections), authenticator))
+	mux.Handle(handlers.CreateCollectionPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.CreateCollection), authenticator))
+	mux.Handle(handlers.CollectionProductsPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.CollectionProducts), authenticator))
+	mux.Handle(handlers.SetCollectionProductsPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.SetCollectionProducts), authenticator))
+	mux.Handle(handlers.ShopBySlugPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.ShopBySlug), authenticator))
+	mux.Handle(handlers.ChangeShopSlugPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.ChangeShopSlug), authenticator))
+	mux.Handle(handlers.ProductBySlugPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.ProductBySlug), authenticator))
+	mux.Handle(handlers.RenameProductPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.RenameProduct), authenticator))
+	mux.Handle(handlers.ChangeProductSlugPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.ChangeProductSlug), authenticator))
+	mux.Handle(handlers.CapturePaymentPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.CapturePayment), authenticator))
+	mux.Handle(handlers.CreateSlotsPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.CreateSlots), authenticator))
+	mux.Handle(handlers.CloseSlotPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.CloseSlot), authenticator))
+	mux.Handle(handlers.AvailabilityPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.Availability), authenticator))
+	mux.Handle(handlers.AddBookingLinePattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.AddBookingLine), authenticator))
+	mux.Handle(handlers.CancelOrderBookingsPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.CancelOrderBookings), authenticator))
+	mux.Handle(handlers.SubscribePattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.Subscribe), authenticator))
+	mux.Handle(handlers.MySubscriptionsPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.MySubscriptions), authenticator))
+	mux.Handle(handlers.GetSubscriptionPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.GetSubscription), authenticator))
+	mux.Handle(handlers.PauseSubscriptionPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.PauseSubscription), authenticator))
+	mux.Handle(handlers.ResumeSubscriptionPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.ResumeSubscription), authenticator))
+	mux.Handle(handlers.SkipSubscriptionPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.SkipSubscription), authenticator))
+	mux.Handle(handlers.CancelSubscriptionPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.CancelSubscription), authenticator))
+	mux.Handle(handlers.ListBundleComponentsPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.ListBundleComponents), authenticator))
+	mux.Handle(handlers.SetBundleComponentsPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.SetBundleComponents), authenticator))
+	mux.Handle(handlers.ShippingQuotesPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.ShippingQuotes), authenticator))
+	mux.Handle(handlers.BuyLabelPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.BuyLabel), authenticator))
+	mux.Handle(handlers.TrackingPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.Tracking), authenticator))
+	mux.Handle(handlers.SyncTrackingPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.SyncTracking), authenticator))
+	mux.Handle(handlers.RefundPaymentPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.RefundPayment), authenticator))
+	mux.Handle(handlers.ListWebhookEndpointsPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.ListWebhookEndpoints), authenticator))
+	mux.Handle(handlers.CreateWebhookEndpointPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.CreateWebhookEndpoint), authenticator))
+	mux.Handle(handlers.GetWebhookEndpointPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.GetWebhookEndpoint), authenticator))
+	mux.Handle(handlers.DisableWebhookEndpointPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.DisableWebhookEndpoint), authenticator))
+	mux.Handle(handlers.DeleteWebhookEndpointPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.DeleteWebhookEndpoint), authenticator))
+	mux.Handle(handlers.ListWebhookDeliveriesPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.ListWebhookDeliveries), authenticator))
+	mux.Handle(handlers.GetWebhookDeliveryPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.GetWebhookDelivery), authenticator))
+	mux.Handle(handlers.RedeliverWebhookPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.RedeliverWebhook), authenticator))
+	mux.Handle(handlers.SetDigitalAssetPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.SetDigitalAsset), authenticator))
+	mux.Handle(handlers.ListEntitlementsPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.ListEntitlements), authenticator))
+	mux.Handle(handlers.IssueDownloadTokenPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.IssueDownloadToken), authenticator))
+	mux.Handle(handlers.SetVariantLocationPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.SetVariantLocation), authenticator))
+	mux.Handle(handlers.CancelOrderPattern,
+		httptor.AuthenticationMiddleware(http.HandlerFunc(implementation.CancelOrder), authenticator))
 
-	return mux
+	return mux, implementation
 }
@@ -6,4 +6,16 @@ import (
 
 type CommerceConfig struct {
 	config.ConfigurationDefault
+
+	// FakeCarrierWebhookSecret enables the local fake carrier, whose tracking
+	// webhooks are signed with this secret.
+	FakeCarrierWebhookSecret string `envDefault:"" env:"FAKE_CARRIER_WEBHOOK_SECRET" yaml:"fake_carrier_webhook_secret"`
+
+	// ShopOwnerProfileID is the admin profile that migration makes the owner
+	// of every shop left without one.
+	ShopOwnerProfileID string `envDefault:"" env:"SHOP_OWNER_PROFILE_ID" yaml:"shop_owner_profile_id"`
+
+	// WebhookAllowLoopback lets webhook endpoints on the loopback interface
+	// receive events, for local development. Off, they are refused.
+	WebhookAllowLoopback bool `envDefault:"false" env:"WEBHOOK_ALLOW_LOOPBACK" yaml:"webhook_allow_loopback"`
 }
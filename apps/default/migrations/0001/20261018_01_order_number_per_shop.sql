@@ -0,0 +1,4 @@
+-- Order numbers are allocated from a per-shop sequence, so they are only
+-- unique within a shop. Drop the legacy global unique index; GORM creates
+-- idx_order_shop_number on (shop_id, order_number).
+DROP INDEX IF EXISTS idx_orders_order_number;
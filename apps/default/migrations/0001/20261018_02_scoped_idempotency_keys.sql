@@ -0,0 +1,4 @@
+-- Idempotency keys are now tracked per shop and caller in idempotency_records.
+-- The key kept on orders is informational, so drop its global unique index;
+-- GORM creates the non-unique idx_order_idempotency_key.
+DROP INDEX IF EXISTS idx_orders_idempotency_key;
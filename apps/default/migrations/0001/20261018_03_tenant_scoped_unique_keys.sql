@@ -0,0 +1,11 @@
+-- Shop slugs and variant SKUs are unique per tenant rather than globally, so
+-- two tenants can both have a "main" shop or a "SKU-1" variant. Replace the
+-- global unique indexes with tenant scoped ones; soft deleted rows release
+-- their key.
+DROP INDEX IF EXISTS idx_shops_slug;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_shop_tenant_slug
+    ON shops (tenant_id, slug) WHERE deleted_at IS NULL;
+
+DROP INDEX IF EXISTS idx_product_variants_sku;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_variant_tenant_sku
+    ON product_variants (tenant_id, sku) WHERE deleted_at IS NULL;
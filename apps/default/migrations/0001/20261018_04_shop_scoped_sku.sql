@@ -0,0 +1,13 @@
+-- SKUs are unique within a shop. Variants now carry their shop, so backfill it
+-- from the owning product and rebuild the uniqueness index on (shop_id, sku).
+-- Empty SKUs from before SKU validation no longer block other variants.
+UPDATE product_variants v
+SET shop_id = p.shop_id
+FROM products p
+WHERE v.product_id = p.id
+  AND (v.shop_id IS NULL OR v.shop_id = '');
+
+DROP INDEX IF EXISTS idx_variant_tenant_sku;
+DROP INDEX IF EXISTS idx_product_variants_sku;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_variant_shop_sku
+    ON product_variants (shop_id, sku) WHERE deleted_at IS NULL AND sku <> '';
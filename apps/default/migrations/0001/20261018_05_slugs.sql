@@ -0,0 +1,12 @@
+-- Products gain slugs unique within their shop. Existing products get a slug
+-- derived from their name; the id suffix keeps the backfill collision free.
+UPDATE products
+SET slug = trim(both '-' from regexp_replace(lower(name), '[^a-z0-9]+', '-', 'g')) || '-' || id
+WHERE slug IS NULL OR slug = '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_product_shop_slug
+    ON products (shop_id, slug) WHERE deleted_at IS NULL AND slug <> '';
+
+-- A retired slug redirects to a single shop or product within its scope.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_slug_redirect_scope_slug
+    ON slug_redirects (tenant_id, entity_type, scope_id, slug) WHERE deleted_at IS NULL;
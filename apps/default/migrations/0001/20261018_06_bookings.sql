@@ -0,0 +1,3 @@
+-- The expiry sweep only looks at held reservations, so index just those.
+CREATE INDEX IF NOT EXISTS idx_slot_reservation_held_expiry
+    ON slot_reservations (expires_at) WHERE status = 1 AND deleted_at IS NULL;
@@ -0,0 +1,4 @@
+-- The sales rollup refresh looks for orders changed since it last ran, and
+-- recomputes a shop's day from the orders created in it.
+CREATE INDEX IF NOT EXISTS idx_order_modified_at ON orders (modified_at);
+CREATE INDEX IF NOT EXISTS idx_order_shop_created_at ON orders (shop_id, created_at);
@@ -0,0 +1,407 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame"
+	"github.com/pitabwire/frame/data"
+	money "google.golang.org/genproto/googleapis/type/money"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+const (
+	// TimezoneKey is the shop property naming the IANA time zone its days are
+	// reported in, such as "Africa/Nairobi". Without it days are UTC.
+	TimezoneKey = "timezone"
+
+	// MaxReportDays bounds the range of days a report covers.
+	MaxReportDays = 731
+
+	// DefaultReportDays is the range of a report not given one, ending today.
+	DefaultReportDays = 30
+
+	salesRollupName = "sales"
+
+	// rollupRefreshSlack re-reads orders changed shortly before the last
+	// refresh, catching transactions that committed after it had begun.
+	rollupRefreshSlack = 5 * time.Minute
+)
+
+// ReportPeriod is the length of the periods a sales report is broken into.
+type ReportPeriod string
+
+const (
+	ReportPeriodDay   ReportPeriod = "day"
+	ReportPeriodWeek  ReportPeriod = "week"
+	ReportPeriodMonth ReportPeriod = "month"
+)
+
+// ParseReportPeriod parses a period name; the empty name is a day.
+func ParseReportPeriod(name string) (ReportPeriod, error) {
+	switch period := ReportPeriod(strings.ToLower(strings.TrimSpace(name))); period {
+	case "":
+		return ReportPeriodDay, nil
+	case ReportPeriodDay, ReportPeriodWeek, ReportPeriodMonth:
+		return period, nil
+	default:
+		return "", fmt.Errorf("unknown report period %q", name)
+	}
+}
+
+// Start returns the first day of the period holding day. Weeks start on
+// Monday.
+func (p ReportPeriod) Start(day time.Time) time.Time {
+	switch p {
+	case ReportPeriodWeek:
+		offset := (int(day.Weekday()) + 6) % 7 //nolint:mnd // days since Monday
+		return day.AddDate(0, 0, -offset)
+	case ReportPeriodMonth:
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+// SalesPeriod summarises a shop's sales in one currency over one period.
+// Gross sales exclude cancelled orders; net sales also take out refunds. The
+// average order value and refund rate are over the orders not cancelled, the
+// cancellation rate over all orders placed.
+type SalesPeriod struct {
+	Start             time.Time
+	Currency          string
+	OrderCount        int64
+	CancelledCount    int64
+	RefundedCount     int64
+	UnitsSold         int64
+	Gross             *money.Money
+	Refunded          *money.Money
+	Net               *money.Money
+	AverageOrderValue *money.Money
+	CancellationRate  float64
+	RefundRate        float64
+}
+
+// SummariseSales adds daily rollups up into periods, one per period and
+// currency, in period then currency order.
+func SummariseSales(days []*models.SalesDailyRollup, period ReportPeriod) []*SalesPeriod {
+	type bucket struct {
+		start    time.Time
+		currency string
+	}
+	type totals struct {
+		orders, cancelled, refunded, units int64
+		gross, refunds                     *big.Int
+	}
+
+	var order []bucket
+	sums := map[bucket]*totals{}
+	for _, day := range days {
+		key := bucket{start: period.Start(day.Day.UTC()), currency: day.Currency}
+		sum, ok := sums[key]
+		if !ok {
+			sum = &totals{gross: new(big.Int), refunds: new(big.Int)}
+			sums[key] = sum
+			order = append(order, key)
+		}
+		sum.orders += day.OrderCount
+		sum.cancelled += day.CancelledCount
+		sum.refunded += day.RefundedCount
+		sum.units += day.UnitsSold
+		sum.gross.Add(sum.gross, toNanos(day.GrossUnits, day.GrossNanos))
+		sum.refunds.Add(sum.refunds, toNanos(day.RefundedUnits, day.RefundedNanos))
+	}
+	slices.SortFunc(order, func(a, b bucket) int {
+		if c := a.start.Compare(b.start); c != 0 {
+			return c
+		}
+		return strings.Compare(a.currency, b.currency)
+	})
+
+	periods := make([]*SalesPeriod, 0, len(order))
+	for _, key := range order {
+		sum := sums[key]
+		placed := sum.orders - sum.cancelled
+		summary := &SalesPeriod{
+			Start:             key.start,
+			Currency:          key.currency,
+			OrderCount:        sum.orders,
+			CancelledCount:    sum.cancelled,
+			RefundedCount:     sum.refunded,
+			UnitsSold:         sum.units,
+			Gross:             fromNanos(key.currency, sum.gross),
+			Refunded:          fromNanos(key.currency, sum.refunds),
+			Net:               fromNanos(key.currency, new(big.Int).Sub(sum.gross, sum.refunds)),
+			AverageOrderValue: fromNanos(key.currency, new(big.Int)),
+		}
+		if sum.orders > 0 {
+			summary.CancellationRate = float64(sum.cancelled) / float64(sum.orders)
+		}
+		if placed > 0 {
+			summary.RefundRate = float64(sum.refunded) / float64(placed)
+			summary.AverageOrderValue = fromNanos(key.currency, new(big.Int).Quo(sum.gross, big.NewInt(placed)))
+		}
+		periods = append(periods, summary)
+	}
+	return periods
+}
+
+// nanosPerUnit is the number of nanos in a currency unit.
+const nanosPerUnit = 1_000_000_000
+
+func toNanos(units int64, nanos int32) *big.Int {
+	total := new(big.Int).Mul(big.NewInt(units), big.NewInt(nanosPerUnit))
+	return total.Add(total, big.NewInt(int64(nanos)))
+}
+
+func fromNanos(currency string, total *big.Int) *money.Money {
+	units, nanos := new(big.Int).QuoRem(total, big.NewInt(nanosPerUnit), new(big.Int))
+	return &money.Money{CurrencyCode: currency, Units: units.Int64(), Nanos: int32(nanos.Int64())}
+}
+
+// ShopLocation returns the time zone the shop's days are reported in.
+func ShopLocation(shop *models.Shop) *time.Location {
+	if shop != nil {
+		if name, ok := shop.Properties[TimezoneKey].(string); ok && name != "" {
+			if loc, err := time.LoadLocation(name); err == nil {
+				return loc
+			}
+		}
+	}
+	return time.UTC
+}
+
+// calendarDay returns the date of t in loc as midnight UTC, the form days
+// are stored and compared in.
+func calendarDay(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// dayBounds returns the instants a calendar day starts and ends at in loc.
+func dayBounds(day time.Time, loc *time.Location) (time.Time, time.Time) {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// AnalyticsBusiness reports a shop's sales from daily rollups, which a
+// background refresh keeps up to date, rather than from its orders. Report
+// ranges are calendar days, both included, in the shop's time zone; a zero
+// end is today and a zero start DefaultReportDays before the end.
+type AnalyticsBusiness interface {
+	RefreshRollups(ctx context.Context, now time.Time) (int, error)
+	RebuildRollups(ctx context.Context, shopID string, from, to time.Time) (int, error)
+	SalesReport(ctx context.Context, shopID string, period ReportPeriod, from, to time.Time) ([]*SalesPeriod, error)
+	TopProducts(ctx context.Context, shopID string, from, to time.Time, limit int) ([]repository.ProductSales, error)
+	VariantSales(
+		ctx context.Context, shopID string, from, to time.Time, limit, offset int,
+	) ([]repository.VariantSales, error)
+}
+
+func NewAnalyticsBusiness(
+	_ context.Context,
+	rollupRepo repository.SalesRollupRepository,
+	shopRepo repository.ShopRepository,
+) AnalyticsBusiness {
+	return &analyticsBusiness{rollupRepo: rollupRepo, shopRepo: shopRepo}
+}
+
+type analyticsBusiness struct {
+	rollupRepo repository.SalesRollupRepository
+	shopRepo   repository.ShopRepository
+}
+
+// RefreshRollups recomputes the days, in each shop's time zone, holding
+// orders that changed since the last refresh, and returns how many it
+// recomputed. The first refresh computes every day that has orders.
+func (ab *analyticsBusiness) RefreshRollups(ctx context.Context, now time.Time) (int, error) {
+	watermark, err := ab.rollupRepo.GetWatermark(ctx, salesRollupName)
+	if err != nil {
+		return 0, data.ErrorConvertToAPI(err)
+	}
+	since := time.Time{}
+	if !watermark.IsZero() {
+		since = watermark.Add(-rollupRefreshSlack)
+	}
+	hours, err := ab.rollupRepo.ListChangedOrderHours(ctx, since)
+	if err != nil {
+		return 0, data.ErrorConvertToAPI(err)
+	}
+
+	// An hour lies within one day in whole hour time zones, but may span
+	// midnight in the others, so both of its ends are placed.
+	days := map[string]map[time.Time]bool{}
+	shops := map[string]*models.Shop{}
+	for _, hour := range hours {
+		shop, ok := shops[hour.ShopID]
+		if !ok {
+			// A shop deleted since its orders changed has nothing to report.
+			shop, err = ab.shopRepo.GetByID(ctx, hour.ShopID)
+			if err != nil {
+				if !frame.ErrorIsNotFound(err) {
+					return 0, data.ErrorConvertToAPI(err)
+				}
+				shop = nil
+			}
+			shops[hour.ShopID] = shop
+			days[hour.ShopID] = map[time.Time]bool{}
+		}
+		if shop == nil {
+			continue
+		}
+		loc := ShopLocation(shop)
+		days[hour.ShopID][calendarDay(hour.Hour, loc)] = true
+		days[hour.ShopID][calendarDay(hour.Hour.Add(time.Hour-time.Nanosecond), loc)] = true
+	}
+
+	refreshed := 0
+	for shopID, shopDays := range days {
+		shop := shops[shopID]
+		if shop == nil {
+			continue
+		}
+		for day := range shopDays {
+			if err = ab.refreshDay(ctx, shop, day); err != nil {
+				return refreshed, err
+			}
+			refreshed++
+		}
+	}
+
+	if err = ab.rollupRepo.AdvanceWatermark(ctx, salesRollupName, now); err != nil {
+		return refreshed, data.ErrorConvertToAPI(err)
+	}
+	return refreshed, nil
+}
+
+// RebuildRollups recomputes every day of the shop's range, for backfills
+// and corrections, and returns how many days it recomputed.
+func (ab *analyticsBusiness) RebuildRollups(ctx context.Context, shopID string, from, to time.Time) (int, error) {
+	if err := validateReportRange(from, to); err != nil {
+		return 0, err
+	}
+	shop, err := ab.shopRepo.GetByID(ctx, shopID)
+	if err != nil {
+		return 0, data.ErrorConvertToAPI(err)
+	}
+
+	refreshed := 0
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		if err = ab.refreshDay(ctx, shop, day); err != nil {
+			return refreshed, err
+		}
+		refreshed++
+	}
+	return refreshed, nil
+}
+
+func (ab *analyticsBusiness) refreshDay(ctx context.Context, shop *models.Shop, day time.Time) error {
+	start, end := dayBounds(day, ShopLocation(shop))
+	if err := ab.rollupRepo.RefreshDay(ctx, shop, day, start, end); err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return nil
+}
+
+func (ab *analyticsBusiness) SalesReport(
+	ctx context.Context,
+	shopID string,
+	period ReportPeriod,
+	from, to time.Time,
+) ([]*SalesPeriod, error) {
+	from, to, err := ab.reportRange(ctx, shopID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	days, err := ab.rollupRepo.ListDaily(ctx, shopID, from, to)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return SummariseSales(days, period), nil
+}
+
+// TopProducts lists the products that sold the most units over the range.
+func (ab *analyticsBusiness) TopProducts(
+	ctx context.Context,
+	shopID string,
+	from, to time.Time,
+	limit int,
+) ([]repository.ProductSales, error) {
+	from, to, err := ab.reportRange(ctx, shopID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	sales, err := ab.rollupRepo.ListProductSales(ctx, shopID, from, to, limit)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return sales, nil
+}
+
+// VariantSales lists the units each variant sold over the range, best
+// selling first.
+func (ab *analyticsBusiness) VariantSales(
+	ctx context.Context,
+	shopID string,
+	from, to time.Time,
+	limit, offset int,
+) ([]repository.VariantSales, error) {
+	from, to, err := ab.reportRange(ctx, shopID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	sales, err := ab.rollupRepo.ListVariantSales(ctx, shopID, from, to, limit, offset)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return sales, nil
+}
+
+// reportRange fills in the days left zero, taking today in the shop's time
+// zone, and validates the range.
+func (ab *analyticsBusiness) reportRange(
+	ctx context.Context,
+	shopID string,
+	from, to time.Time,
+) (time.Time, time.Time, error) {
+	if shopID == "" {
+		return from, to, connect.NewError(connect.CodeInvalidArgument, errors.New("shop_id is required"))
+	}
+	if from.IsZero() || to.IsZero() {
+		if to.IsZero() {
+			shop, err := ab.shopRepo.GetByID(ctx, shopID)
+			if err != nil {
+				return from, to, data.ErrorConvertToAPI(err)
+			}
+			to = calendarDay(time.Now(), ShopLocation(shop))
+		}
+		if from.IsZero() {
+			from = to.AddDate(0, 0, 1-DefaultReportDays)
+		}
+	}
+	return from, to, validateReportRange(from, to)
+}
+
+// validateReportRange checks from and to are calendar days, to no earlier
+// than from and the range no longer than MaxReportDays.
+func validateReportRange(from, to time.Time) error {
+	if !from.Equal(calendarDay(from, time.UTC)) || !to.Equal(calendarDay(to, time.UTC)) {
+		return connect.NewError(connect.CodeInvalidArgument, errors.New("report range must be whole days"))
+	}
+	if to.Before(from) {
+		return connect.NewError(connect.CodeInvalidArgument, errors.New("to must not be before from"))
+	}
+	if to.Sub(from) >= MaxReportDays*24*time.Hour {
+		return connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("report range must be at most %d days", MaxReportDays))
+	}
+	return nil
+}
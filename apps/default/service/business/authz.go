@@ -0,0 +1,286 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame"
+	"github.com/pitabwire/frame/data"
+	"github.com/pitabwire/frame/security"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+// Shop member roles, from the most to the least privileged.
+const (
+	RoleOwner           = "owner"
+	RoleManager         = "manager"
+	RoleStaff           = "staff"
+	RoleFulfilmentClerk = "fulfilment_clerk"
+
+	internalSystemRolePrefix = "system_internal"
+)
+
+// Permission names an operation that is checked against a caller's shop role.
+type Permission string
+
+const (
+	PermissionShopManage       Permission = "shop.manage"
+	PermissionMembersManage    Permission = "shop.members.manage"
+	PermissionCatalogManage    Permission = "catalog.manage"
+	PermissionCartsView        Permission = "carts.view"
+	PermissionCartsManage      Permission = "carts.manage"
+	PermissionOrdersView       Permission = "orders.view"
+	PermissionOrdersManage     Permission = "orders.manage"
+	PermissionFulfilmentView   Permission = "fulfilment.view"
+	PermissionFulfilmentManage Permission = "fulfilment.manage"
+)
+
+//nolint:gochecknoglobals // static role matrix
+var rolePermissions = map[string][]Permission{
+	RoleOwner: {
+		PermissionShopManage, PermissionMembersManage, PermissionCatalogManage,
+		PermissionCartsView, PermissionCartsManage, PermissionOrdersView, PermissionOrdersManage,
+		PermissionFulfilmentView, PermissionFulfilmentManage,
+	},
+	RoleManager: {
+		PermissionShopManage, PermissionCatalogManage,
+		PermissionCartsView, PermissionCartsManage, PermissionOrdersView, PermissionOrdersManage,
+		PermissionFulfilmentView, PermissionFulfilmentManage,
+	},
+	RoleStaff: {
+		PermissionCatalogManage,
+		PermissionCartsView, PermissionCartsManage, PermissionOrdersView, PermissionOrdersManage,
+		PermissionFulfilmentView, PermissionFulfilmentManage,
+	},
+	RoleFulfilmentClerk: {
+		PermissionOrdersView, PermissionFulfilmentView, PermissionFulfilmentManage,
+	},
+}
+
+// customerPermissions are granted to a customer on the carts and orders that
+// carry their own profile ID.
+//
+//nolint:gochecknoglobals // static role matrix
+var customerPermissions = []Permission{
+	PermissionCartsView, PermissionCartsManage, PermissionOrdersView, PermissionOrdersManage,
+	PermissionFulfilmentView,
+}
+
+// RoleHasPermission reports whether a shop member role grants the permission.
+func RoleHasPermission(role string, perm Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidRole reports whether role is a known shop member role.
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func isCustomerPermission(perm Permission) bool {
+	for _, granted := range customerPermissions {
+		if granted == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthzBusiness decides whether the caller in the context may act on a shop
+// or on a resource that belongs to one. Shop members are authorized through
+// their role; customers only reach the carts and orders carrying their own
+// profile ID, matched against the JWT subject.
+type AuthzBusiness interface {
+	// Authenticated returns the caller's profile ID, failing when there is none.
+	Authenticated(ctx context.Context) (string, error)
+	AuthorizeShop(ctx context.Context, shopID string, perm Permission) error
+	AuthorizeProduct(ctx context.Context, productID string, perm Permission) error
+	AuthorizeVariant(ctx context.Context, variantID string, perm Permission) error
+	AuthorizeCart(ctx context.Context, cartID string, perm Permission) error
+	AuthorizeOrder(ctx context.Context, orderID string, perm Permission) error
+	AuthorizeFulfilment(ctx context.Context, fulfilmentID string, perm Permission) error
+	// AuthorizeProfile checks that the caller may act in the shop on behalf of
+	// profileID, either as that customer or as a member holding perm.
+	AuthorizeProfile(ctx context.Context, shopID, profileID string, perm Permission) error
+	// AuthorizeInternal admits only other services acting with internal
+	// system credentials.
+	AuthorizeInternal(ctx context.Context) error
+}
+
+func NewAuthzBusiness(
+	_ context.Context,
+	memberRepo repository.ShopMemberRepository,
+	productRepo repository.ProductRepository,
+	variantRepo repository.ProductVariantRepository,
+	cartRepo repository.CartRepository,
+	orderRepo repository.OrderRepository,
+	fulfilmentRepo repository.FulfilmentRepository,
+) AuthzBusiness {
+	return &authzBusiness{
+		memberRepo:     memberRepo,
+		productRepo:    productRepo,
+		variantRepo:    variantRepo,
+		cartRepo:       cartRepo,
+		orderRepo:      orderRepo,
+		fulfilmentRepo: fulfilmentRepo,
+	}
+}
+
+type authzBusiness struct {
+	memberRepo     repository.ShopMemberRepository
+	productRepo    repository.ProductRepository
+	variantRepo    repository.ProductVariantRepository
+	cartRepo       repository.CartRepository
+	orderRepo      repository.OrderRepository
+	fulfilmentRepo repository.FulfilmentRepository
+}
+
+func (ab *authzBusiness) Authenticated(ctx context.Context) (string, error) {
+	profileID := callerProfileID(ctx)
+	if profileID == "" {
+		return "", connect.NewError(connect.CodeUnauthenticated, errors.New("authentication is required"))
+	}
+	return profileID, nil
+}
+
+func (ab *authzBusiness) AuthorizeShop(ctx context.Context, shopID string, perm Permission) error {
+	return ab.authorize(ctx, shopID, "", perm)
+}
+
+func (ab *authzBusiness) AuthorizeProduct(ctx context.Context, productID string, perm Permission) error {
+	if _, err := ab.Authenticated(ctx); err != nil {
+		return err
+	}
+
+	product, err := ab.productRepo.GetByID(ctx, productID)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return ab.authorize(ctx, product.ShopID, "", perm)
+}
+
+func (ab *authzBusiness) AuthorizeVariant(ctx context.Context, variantID string, perm Permission) error {
+	if _, err := ab.Authenticated(ctx); err != nil {
+		return err
+	}
+
+	variant, err := ab.variantRepo.GetByID(ctx, variantID)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return ab.AuthorizeProduct(ctx, variant.ProductID, perm)
+}
+
+func (ab *authzBusiness) AuthorizeCart(ctx context.Context, cartID string, perm Permission) error {
+	if _, err := ab.Authenticated(ctx); err != nil {
+		return err
+	}
+
+	cart, err := ab.cartRepo.GetByID(ctx, cartID)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return ab.authorize(ctx, cart.ShopID, cart.ProfileID, perm)
+}
+
+func (ab *authzBusiness) AuthorizeOrder(ctx context.Context, orderID string, perm Permission) error {
+	if _, err := ab.Authenticated(ctx); err != nil {
+		return err
+	}
+
+	order, err := ab.orderRepo.GetByID(ctx, orderID)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return ab.authorize(ctx, order.ShopID, order.ProfileID, perm)
+}
+
+func (ab *authzBusiness) AuthorizeFulfilment(ctx context.Context, fulfilmentID string, perm Permission) error {
+	if _, err := ab.Authenticated(ctx); err != nil {
+		return err
+	}
+
+	fulfilment, err := ab.fulfilmentRepo.GetByID(ctx, fulfilmentID)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return ab.AuthorizeOrder(ctx, fulfilment.OrderID, perm)
+}
+
+func (ab *authzBusiness) AuthorizeProfile(ctx context.Context, shopID, profileID string, perm Permission) error {
+	return ab.authorize(ctx, shopID, profileID, perm)
+}
+
+// authorize grants access when the caller is an internal system, is the
+// customer owning the resource, or holds a shop role granting perm.
+func (ab *authzBusiness) AuthorizeInternal(ctx context.Context) error {
+	if !isInternalCaller(ctx) {
+		return connect.NewError(connect.CodePermissionDenied, errors.New("only internal services may call this"))
+	}
+	return nil
+}
+
+func (ab *authzBusiness) authorize(ctx context.Context, shopID, ownerProfileID string, perm Permission) error {
+	callerID, err := ab.Authenticated(ctx)
+	if err != nil {
+		return err
+	}
+
+	if isInternalCaller(ctx) {
+		return nil
+	}
+
+	if ownerProfileID != "" && ownerProfileID == callerID && isCustomerPermission(perm) {
+		return nil
+	}
+
+	member, err := ab.memberRepo.GetByShopAndProfile(ctx, shopID, callerID)
+	if err != nil {
+		if frame.ErrorIsNotFound(err) {
+			return permissionDenied(perm)
+		}
+		return data.ErrorConvertToAPI(err)
+	}
+
+	if !RoleHasPermission(member.Role, perm) {
+		return permissionDenied(perm)
+	}
+	return nil
+}
+
+func permissionDenied(perm Permission) error {
+	return connect.NewError(connect.CodePermissionDenied,
+		errors.New("caller lacks permission "+string(perm)))
+}
+
+// callerProfileID returns the profile ID of the authenticated caller, if any.
+func callerProfileID(ctx context.Context) string {
+	claims := security.ClaimsFromContext(ctx)
+	if claims == nil {
+		return ""
+	}
+	return claims.GetProfileID()
+}
+
+// isInternalCaller reports whether the caller is another service acting with
+// internal system credentials.
+func isInternalCaller(ctx context.Context) bool {
+	claims := security.ClaimsFromContext(ctx)
+	if claims == nil {
+		return false
+	}
+	for _, role := range claims.GetRoles() {
+		if strings.HasPrefix(role, internalSystemRolePrefix) {
+			return true
+		}
+	}
+	return false
+}
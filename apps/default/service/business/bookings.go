@@ -0,0 +1,325 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame/data"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+const (
+	// BookingHoldDuration is how long a cart holds places in a slot before
+	// they are released back to the slot.
+	BookingHoldDuration = 15 * time.Minute
+
+	// MaxAvailabilityRange bounds the period a single availability query covers.
+	MaxAvailabilityRange = 93 * 24 * time.Hour
+
+	expiredHoldBatchSize = 100
+)
+
+// SlotInput schedules one slot of a booking variant.
+type SlotInput struct {
+	StartsAt time.Time
+	EndsAt   time.Time
+	Capacity int64
+}
+
+// SlotAvailability is one slot of an availability calendar.
+type SlotAvailability struct {
+	SlotID    string
+	VariantID string
+	StartsAt  time.Time
+	EndsAt    time.Time
+	Capacity  int64
+	Available int64
+}
+
+// AvailabilityDay groups the slots starting on one calendar day.
+type AvailabilityDay struct {
+	// Date is midnight of the day in the location the calendar was built for.
+	Date  time.Time
+	Slots []SlotAvailability
+}
+
+type BookingBusiness interface {
+	CreateSlots(ctx context.Context, variantID string, slots []SlotInput) ([]*models.BookingSlot, error)
+	GetSlot(ctx context.Context, slotID string) (*models.BookingSlot, error)
+	CloseSlot(ctx context.Context, slotID string) error
+	AddBookingLine(ctx context.Context, cartID, slotID string, quantity int64) (*commercev1.Cart, error)
+	CancelReservation(ctx context.Context, reservationID string) error
+	CancelOrderBookings(ctx context.Context, orderID string) (int, error)
+	ReleaseExpiredHolds(ctx context.Context, now time.Time) (int, error)
+	ListAvailability(ctx context.Context, productID string, from, to time.Time) ([]AvailabilityDay, error)
+}
+
+func NewBookingBusiness(
+	_ context.Context,
+	productRepo repository.ProductRepository,
+	variantRepo repository.ProductVariantRepository,
+	cartRepo repository.CartRepository,
+	slotRepo repository.BookingSlotRepository,
+	reservationRepo repository.SlotReservationRepository,
+) BookingBusiness {
+	return &bookingBusiness{
+		productRepo:     productRepo,
+		variantRepo:     variantRepo,
+		cartRepo:        cartRepo,
+		slotRepo:        slotRepo,
+		reservationRepo: reservationRepo,
+	}
+}
+
+type bookingBusiness struct {
+	productRepo     repository.ProductRepository
+	variantRepo     repository.ProductVariantRepository
+	cartRepo        repository.CartRepository
+	slotRepo        repository.BookingSlotRepository
+	reservationRepo repository.SlotReservationRepository
+}
+
+// CreateSlots schedules slots for a variant of a booking product. A slot that
+// starts when one of the variant's slots already starts is rejected.
+func (bb *bookingBusiness) CreateSlots(
+	ctx context.Context,
+	variantID string,
+	slots []SlotInput,
+) ([]*models.BookingSlot, error) {
+	if len(slots) == 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("at least one slot is required"))
+	}
+
+	variant, err := bb.variantRepo.GetByID(ctx, variantID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	product, err := bb.productRepo.GetByID(ctx, variant.ProductID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if product.FulfilmentType != models.FulfilmentTypeBooking {
+		return nil, connect.NewError(connect.CodeFailedPrecondition,
+			errors.New("slots can only be scheduled for booking products"))
+	}
+
+	for _, input := range slots {
+		if !input.EndsAt.After(input.StartsAt) {
+			return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("slot must end after it starts"))
+		}
+		if input.Capacity <= 0 {
+			return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("slot capacity must be positive"))
+		}
+	}
+
+	created := make([]*models.BookingSlot, 0, len(slots))
+	for _, input := range slots {
+		slot := &models.BookingSlot{
+			ShopID:           product.ShopID,
+			ProductID:        product.GetID(),
+			ProductVariantID: variant.GetID(),
+			StartsAt:         input.StartsAt.UTC(),
+			EndsAt:           input.EndsAt.UTC(),
+			Capacity:         input.Capacity,
+			Status:           models.SlotStatusOpen,
+		}
+		slot.CopyPartitionInfo(&product.BaseModel)
+
+		inserted, createErr := bb.slotRepo.TryCreate(ctx, slot)
+		if createErr != nil {
+			return nil, data.ErrorConvertToAPI(createErr)
+		}
+		if !inserted {
+			return nil, connect.NewError(connect.CodeAlreadyExists,
+				fmt.Errorf("variant already has a slot starting at %s", slot.StartsAt.Format(time.RFC3339)))
+		}
+		created = append(created, slot)
+	}
+	return created, nil
+}
+
+func (bb *bookingBusiness) GetSlot(ctx context.Context, slotID string) (*models.BookingSlot, error) {
+	slot, err := bb.slotRepo.GetByID(ctx, slotID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return slot, nil
+}
+
+// CloseSlot stops further reservations of the slot. Places already held or
+// booked are kept.
+func (bb *bookingBusiness) CloseSlot(ctx context.Context, slotID string) error {
+	slot, err := bb.slotRepo.GetByID(ctx, slotID)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	slot.Status = models.SlotStatusClosed
+	if _, err = bb.slotRepo.Update(ctx, slot, "status"); err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return nil
+}
+
+// AddBookingLine holds places in the slot for the cart for
+// BookingHoldDuration. Converting the cart within the hold confirms them.
+func (bb *bookingBusiness) AddBookingLine(
+	ctx context.Context,
+	cartID, slotID string,
+	quantity int64,
+) (*commercev1.Cart, error) {
+	if quantity <= 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("quantity must be positive"))
+	}
+
+	cart, err := bb.cartRepo.GetByID(ctx, cartID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if cart.Status != int32(commercev1.CartStatus_CART_STATUS_ACTIVE) {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("cart is not active"))
+	}
+
+	slot, err := bb.slotRepo.GetByID(ctx, slotID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if slot.ShopID != cart.ShopID {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("slot belongs to a different shop"))
+	}
+	if !slot.StartsAt.After(time.Now()) {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("slot has already started"))
+	}
+
+	_, err = bb.slotRepo.Reserve(ctx, cart.GetID(), slot, quantity, time.Now().Add(BookingHoldDuration))
+	if err != nil {
+		if errors.Is(err, repository.ErrSlotUnavailable) {
+			return nil, connect.NewError(connect.CodeFailedPrecondition,
+				fmt.Errorf("slot %s cannot take %d more places", slotID, quantity))
+		}
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	cart, err = bb.cartRepo.GetWithLines(ctx, cart.GetID())
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return cart.ToAPI(), nil
+}
+
+// CancelReservation returns the reservation's places to its slot. Cancelling
+// a released reservation is a no-op.
+func (bb *bookingBusiness) CancelReservation(ctx context.Context, reservationID string) error {
+	_, err := bb.reservationRepo.Release(ctx, reservationID,
+		[]int32{models.ReservationStatusHeld, models.ReservationStatusConfirmed})
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return nil
+}
+
+// CancelOrderBookings releases every slot booked by the order, reporting how
+// many reservations were released.
+func (bb *bookingBusiness) CancelOrderBookings(ctx context.Context, orderID string) (int, error) {
+	reservations, err := bb.reservationRepo.ListByOrderID(ctx, orderID)
+	if err != nil {
+		return 0, data.ErrorConvertToAPI(err)
+	}
+	return bb.release(ctx, reservations, models.ReservationStatusConfirmed)
+}
+
+// ReleaseExpiredHolds releases cart holds that ended before now, returning
+// their places to the slots and dropping the cart lines.
+func (bb *bookingBusiness) ReleaseExpiredHolds(ctx context.Context, now time.Time) (int, error) {
+	total := 0
+	for {
+		reservations, err := bb.reservationRepo.ListExpiredHolds(ctx, now, expiredHoldBatchSize)
+		if err != nil {
+			return total, data.ErrorConvertToAPI(err)
+		}
+		released, err := bb.release(ctx, reservations, models.ReservationStatusHeld)
+		total += released
+		if err != nil || len(reservations) < expiredHoldBatchSize {
+			return total, err
+		}
+	}
+}
+
+// release releases the reservations still in the given state. A reservation
+// that moved on concurrently, such as a hold confirmed by a checkout, is kept.
+func (bb *bookingBusiness) release(
+	ctx context.Context,
+	reservations []*models.SlotReservation,
+	status int32,
+) (int, error) {
+	released := 0
+	for _, reservation := range reservations {
+		ok, err := bb.reservationRepo.Release(ctx, reservation.GetID(), []int32{status})
+		if err != nil {
+			return released, data.ErrorConvertToAPI(err)
+		}
+		if ok {
+			released++
+		}
+	}
+	return released, nil
+}
+
+// ListAvailability builds the product's availability calendar for slots
+// starting within [from, to), grouped by UTC day. Closed slots are left out.
+func (bb *bookingBusiness) ListAvailability(
+	ctx context.Context,
+	productID string,
+	from, to time.Time,
+) ([]AvailabilityDay, error) {
+	if !to.After(from) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("range must end after it starts"))
+	}
+	if to.Sub(from) > MaxAvailabilityRange {
+		return nil, connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("range cannot exceed %d days", int(MaxAvailabilityRange/(hoursPerDay*time.Hour))))
+	}
+
+	if _, err := bb.productRepo.GetByID(ctx, productID); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	slots, err := bb.slotRepo.ListByProductID(ctx, productID, from, to)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return GroupAvailability(slots, time.UTC), nil
+}
+
+// GroupAvailability lays open slots out as calendar days in loc. Slots are
+// expected in start order; days without open slots are omitted.
+func GroupAvailability(slots []*models.BookingSlot, loc *time.Location) []AvailabilityDay {
+	var days []AvailabilityDay
+	for _, slot := range slots {
+		if slot.Status != models.SlotStatusOpen {
+			continue
+		}
+
+		start := slot.StartsAt.In(loc)
+		date := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+		if len(days) == 0 || !days[len(days)-1].Date.Equal(date) {
+			days = append(days, AvailabilityDay{Date: date})
+		}
+
+		day := &days[len(days)-1]
+		day.Slots = append(day.Slots, SlotAvailability{
+			SlotID:    slot.GetID(),
+			VariantID: slot.ProductVariantID,
+			StartsAt:  start,
+			EndsAt:    slot.EndsAt.In(loc),
+			Capacity:  slot.Capacity,
+			Available: slot.Available(),
+		})
+	}
+	return days
+}
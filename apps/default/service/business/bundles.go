@@ -0,0 +1,190 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame/data"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+// maxBundleComponents bounds how many variants a bundle may contain.
+const maxBundleComponents = 50
+
+// BundleComponentInput puts Quantity units of a variant in each bundle.
+type BundleComponentInput struct {
+	VariantID string
+	Quantity  int64
+}
+
+// BundleStock is how many bundles the components' stock can make up: the
+// smallest number of whole bundles any one component allows. Components must
+// have their variant loaded.
+func BundleStock(components []*models.BundleComponent) int64 {
+	if len(components) == 0 {
+		return 0
+	}
+
+	available := int64(-1)
+	for _, component := range components {
+		if component.ComponentVariant == nil || component.Quantity <= 0 {
+			return 0
+		}
+		bundles := max(component.ComponentVariant.StockQuantity, 0) / component.Quantity
+		if available < 0 || bundles < available {
+			available = bundles
+		}
+	}
+	return available
+}
+
+// bundleComposition snapshots the components for an order line.
+func bundleComposition(components []*models.BundleComponent) models.BundleComposition {
+	composition := make(models.BundleComposition, 0, len(components))
+	for _, component := range components {
+		item := models.BundleItem{VariantID: component.ComponentVariantID, Quantity: component.Quantity}
+		if variant := component.ComponentVariant; variant != nil {
+			item.SKU = variant.SKU
+			item.Name = variant.Name
+		}
+		composition = append(composition, item)
+	}
+	return composition
+}
+
+// SetBundleComponents makes the variant a bundle of the given components,
+// replacing any previous composition. Components must be standard variants of
+// the same shop; bundles do not nest.
+func (cb *catalogBusiness) SetBundleComponents(
+	ctx context.Context,
+	bundleVariantID string,
+	inputs []BundleComponentInput,
+) ([]*models.BundleComponent, error) {
+	if len(inputs) == 0 || len(inputs) > maxBundleComponents {
+		return nil, connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("a bundle must have between 1 and %d components", maxBundleComponents))
+	}
+
+	bundle, err := cb.variantRepo.GetByID(ctx, bundleVariantID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if err = cb.ensureStockedProduct(ctx, bundle); err != nil {
+		return nil, err
+	}
+
+	nested, err := cb.bundleRepo.ExistsForComponent(ctx, bundle.GetID())
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if nested {
+		return nil, connect.NewError(connect.CodeFailedPrecondition,
+			errors.New("variant is a component of another bundle and cannot be a bundle itself"))
+	}
+
+	seen := make(map[string]bool, len(inputs))
+	components := make([]*models.BundleComponent, 0, len(inputs))
+	for _, input := range inputs {
+		if input.Quantity <= 0 {
+			return nil, connect.NewError(connect.CodeInvalidArgument,
+				fmt.Errorf("quantity must be positive for component %s", input.VariantID))
+		}
+		if input.VariantID == bundle.GetID() || seen[input.VariantID] {
+			return nil, connect.NewError(connect.CodeInvalidArgument,
+				fmt.Errorf("component %s is repeated or is the bundle itself", input.VariantID))
+		}
+		seen[input.VariantID] = true
+
+		component, getErr := cb.variantRepo.GetByID(ctx, input.VariantID)
+		if getErr != nil {
+			return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("component %s not found", input.VariantID))
+		}
+		if component.ShopID != bundle.ShopID {
+			return nil, connect.NewError(connect.CodeInvalidArgument,
+				fmt.Errorf("component %s belongs to a different shop", input.VariantID))
+		}
+		if component.IsBundle() {
+			return nil, connect.NewError(connect.CodeInvalidArgument,
+				fmt.Errorf("component %s is a bundle; bundles do not nest", input.VariantID))
+		}
+		if stockedErr := cb.ensureStockedProduct(ctx, component); stockedErr != nil {
+			return nil, stockedErr
+		}
+
+		components = append(components, &models.BundleComponent{
+			ComponentVariantID: component.GetID(),
+			Quantity:           input.Quantity,
+			ComponentVariant:   component,
+		})
+	}
+
+	if err = cb.bundleRepo.Replace(ctx, bundle, components); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return components, nil
+}
+
+// ensureStockedProduct rejects variants of products not sold from stock,
+// which cannot take part in a bundle.
+func (cb *catalogBusiness) ensureStockedProduct(ctx context.Context, variant *models.ProductVariant) error {
+	product, err := cb.productRepo.GetByID(ctx, variant.ProductID)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	if product.FulfilmentType == models.FulfilmentTypeBooking {
+		return connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("variant %s is booked by time slot and cannot be bundled", variant.GetID()))
+	}
+	return nil
+}
+
+func (cb *catalogBusiness) ListBundleComponents(
+	ctx context.Context,
+	bundleVariantID string,
+) ([]*models.BundleComponent, error) {
+	components, err := cb.bundleRepo.ListByBundleID(ctx, bundleVariantID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return components, nil
+}
+
+// BundleAvailability is how many units of the bundle can currently be sold.
+func (cb *catalogBusiness) BundleAvailability(ctx context.Context, bundleVariantID string) (int64, error) {
+	variant, err := cb.variantRepo.GetByID(ctx, bundleVariantID)
+	if err != nil {
+		return 0, data.ErrorConvertToAPI(err)
+	}
+	if !variant.IsBundle() {
+		return 0, connect.NewError(connect.CodeFailedPrecondition, errors.New("variant is not a bundle"))
+	}
+
+	components, err := cb.ListBundleComponents(ctx, bundleVariantID)
+	if err != nil {
+		return 0, err
+	}
+	return BundleStock(components), nil
+}
+
+// variantToAPI converts the variant, reporting a bundle's derived stock as
+// its stock quantity.
+func (cb *catalogBusiness) variantToAPI(
+	ctx context.Context,
+	variant *models.ProductVariant,
+) (*commercev1.ProductVariant, error) {
+	if !variant.IsBundle() {
+		return variant.ToAPI(), nil
+	}
+
+	components, err := cb.ListBundleComponents(ctx, variant.GetID())
+	if err != nil {
+		return nil, err
+	}
+	apiVariant := variant.ToAPI()
+	apiVariant.StockQuantity = BundleStock(components)
+	return apiVariant, nil
+}
@@ -1,22 +1,49 @@
 package business_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	commonv1 "buf.build/gen/go/antinvestor/common/protocolbuffers/go/common/v1"
+	"buf.build/go/protovalidate"
+	"connectrpc.com/connect"
 	"github.com/pitabwire/frame"
+	"github.com/pitabwire/frame/data"
 	"github.com/pitabwire/frame/datastore"
 	"github.com/pitabwire/frame/frametests/definition"
+	"github.com/pitabwire/frame/security"
 	"github.com/pitabwire/util"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	money "google.golang.org/genproto/googleapis/type/money"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/antinvestor/service-commerce/apps/default/service/business"
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
 	"github.com/antinvestor/service-commerce/apps/default/service/repository"
 	"github.com/antinvestor/service-commerce/apps/default/tests"
+	"github.com/antinvestor/service-commerce/internal/errorutil"
 )
 
 type BusinessTestSuite struct {
@@ -28,13 +55,34 @@ func TestBusinessSuite(t *testing.T) {
 }
 
 type allBiz struct {
-	shopBiz       business.ShopBusiness
-	catalogBiz    business.CatalogBusiness
-	cartBiz       business.CartBusiness
-	orderBiz      business.OrderBusiness
-	fulfilmentBiz business.FulfilmentBusiness
+	shopBiz         business.ShopBusiness
+	catalogBiz      business.CatalogBusiness
+	cartBiz         business.CartBusiness
+	orderBiz        business.OrderBusiness
+	fulfilmentBiz   business.FulfilmentBusiness
+	authzBiz        business.AuthzBusiness
+	navigationBiz   business.NavigationBusiness
+	digitalBiz      business.DigitalBusiness
+	paymentBiz      business.PaymentBusiness
+	bookingBiz      business.BookingBusiness
+	subscriptionBiz business.SubscriptionBusiness
+	shippingBiz     business.ShippingBusiness
+	documentBiz     business.DocumentBusiness
+	invoiceBiz      business.InvoiceBusiness
+	webhookBiz      business.WebhookBusiness
+	importBiz       business.ImportBusiness
+	exportBiz       business.ExportBusiness
+	analyticsBiz    business.AnalyticsBusiness
 }
 
+// testCarrierSecret signs the fake carrier's webhooks in tests.
+const testCarrierSecret = "test-carrier-secret"
+
+// testWebhookPolicy retries failed webhooks without waiting so tests can
+// drive deliveries to the dead letters quickly, and lets them reach the
+// receivers listening on loopback.
+var testWebhookPolicy = business.WebhookPolicy{MaxAttempts: 3, Timeout: 5 * time.Second, AllowLoopback: true}
+
 func (bts *BusinessTestSuite) getBusiness(ctx context.Context, svc *frame.Service) allBiz {
 	dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
 	workMan := svc.WorkManager()
@@ -48,13 +96,78 @@ func (bts *BusinessTestSuite) getBusiness(ctx context.Context, svc *frame.Servic
 	orderLineRepo := repository.NewOrderLineRepository(ctx, dbPool, workMan)
 	fulfilmentRepo := repository.NewFulfilmentRepository(ctx, dbPool, workMan)
 	fulfilmentLineRepo := repository.NewFulfilmentLineRepository(ctx, dbPool, workMan)
+	sequenceRepo := repository.NewShopSequenceRepository(ctx, dbPool, workMan)
+	idempotencyRepo := repository.NewIdempotencyRepository(ctx, dbPool, workMan)
+	memberRepo := repository.NewShopMemberRepository(ctx, dbPool, workMan)
+	optionRepo := repository.NewProductOptionRepository(ctx, dbPool, workMan)
+	categoryRepo := repository.NewCategoryRepository(ctx, dbPool, workMan)
+	productCategoryRepo := repository.NewProductCategoryRepository(ctx, dbPool, workMan)
+	collectionRepo := repository.NewCollectionRepository(ctx, dbPool, workMan)
+	collectionProductRepo := repository.NewCollectionProductRepository(ctx, dbPool, workMan)
+	redirectRepo := repository.NewSlugRedirectRepository(ctx, dbPool, workMan)
+	assetRepo := repository.NewDigitalAssetRepository(ctx, dbPool, workMan)
+	entitlementRepo := repository.NewEntitlementRepository(ctx, dbPool, workMan)
+	slotRepo := repository.NewBookingSlotRepository(ctx, dbPool, workMan)
+	reservationRepo := repository.NewSlotReservationRepository(ctx, dbPool, workMan)
+	subscriptionRepo := repository.NewSubscriptionRepository(ctx, dbPool, workMan)
+	bundleRepo := repository.NewBundleComponentRepository(ctx, dbPool, workMan)
+	trackingRepo := repository.NewFulfilmentTrackingEventRepository(ctx, dbPool, workMan)
+	invoiceRepo := repository.NewInvoiceRepository(ctx, dbPool, workMan)
+	webhookEndpointRepo := repository.NewWebhookEndpointRepository(ctx, dbPool, workMan)
+	webhookDeliveryRepo := repository.NewWebhookDeliveryRepository(ctx, dbPool, workMan)
+	importRepo := repository.NewCatalogImportRepository(ctx, dbPool, workMan)
+	rollupRepo := repository.NewSalesRollupRepository(ctx, dbPool, workMan)
+
+	webhookBusiness := business.NewWebhookBusiness(
+		ctx, workMan, testWebhookPolicy, webhookEndpointRepo, webhookDeliveryRepo,
+	)
+	catalogBusiness := business.NewCatalogBusiness(
+		ctx, productRepo, variantRepo, shopRepo, sequenceRepo, optionRepo, redirectRepo, bundleRepo,
+	)
+	orderBusiness := business.NewOrderBusiness(
+		ctx, orderRepo, orderLineRepo, variantRepo, productRepo, shopRepo,
+		cartRepo, cartLineRepo, sequenceRepo, idempotencyRepo, bundleRepo, webhookBusiness,
+	)
+	digitalBusiness := business.NewDigitalBusiness(
+		ctx, orderRepo, productRepo, variantRepo, fulfilmentRepo, fulfilmentLineRepo, assetRepo, entitlementRepo,
+	)
+	invoiceBusiness := business.NewInvoiceBusiness(ctx, invoiceRepo, orderRepo, shopRepo)
 
 	return allBiz{
-		shopBiz:       business.NewShopBusiness(ctx, shopRepo),
-		catalogBiz:    business.NewCatalogBusiness(ctx, productRepo, variantRepo, shopRepo),
-		cartBiz:       business.NewCartBusiness(ctx, cartRepo, cartLineRepo, variantRepo),
-		orderBiz:      business.NewOrderBusiness(ctx, orderRepo, orderLineRepo, variantRepo, shopRepo, cartRepo, cartLineRepo),
-		fulfilmentBiz: business.NewFulfilmentBusiness(ctx, fulfilmentRepo, fulfilmentLineRepo, orderRepo, orderLineRepo),
+		shopBiz:    business.NewShopBusiness(ctx, shopRepo, memberRepo, redirectRepo),
+		catalogBiz: catalogBusiness,
+		cartBiz: business.NewCartBusiness(
+			ctx, cartRepo, cartLineRepo, variantRepo, productRepo, shopRepo, bundleRepo, reservationRepo,
+		),
+		orderBiz:      orderBusiness,
+		fulfilmentBiz: business.NewFulfilmentBusiness(ctx, fulfilmentRepo, fulfilmentLineRepo, orderRepo, orderLineRepo, idempotencyRepo, webhookBusiness),
+		authzBiz: business.NewAuthzBusiness(
+			ctx, memberRepo, productRepo, variantRepo, cartRepo, orderRepo, fulfilmentRepo,
+		),
+		navigationBiz: business.NewNavigationBusiness(
+			ctx, catalogBusiness, shopRepo, productRepo,
+			categoryRepo, productCategoryRepo, collectionRepo, collectionProductRepo,
+		),
+		digitalBiz: digitalBusiness,
+		paymentBiz: business.NewPaymentBusiness(ctx, orderRepo, digitalBusiness, invoiceBusiness, webhookBusiness),
+		bookingBiz: business.NewBookingBusiness(
+			ctx, productRepo, variantRepo, cartRepo, slotRepo, reservationRepo,
+		),
+		subscriptionBiz: business.NewSubscriptionBusiness(
+			ctx, orderBusiness, subscriptionRepo, orderRepo, productRepo, variantRepo,
+		),
+		shippingBiz: business.NewShippingBusiness(
+			ctx, business.NewCarrierRegistry(business.NewFakeCarrier(testCarrierSecret)),
+			fulfilmentRepo, fulfilmentLineRepo, trackingRepo, orderRepo, webhookBusiness,
+		),
+		documentBiz: business.NewDocumentBusiness(ctx, shopRepo, orderRepo, variantRepo, fulfilmentRepo),
+		invoiceBiz:  invoiceBusiness,
+		webhookBiz:  webhookBusiness,
+		importBiz: business.NewImportBusiness(
+			ctx, workMan, importRepo, shopRepo, productRepo, variantRepo, optionRepo, redirectRepo,
+		),
+		exportBiz:    business.NewExportBusiness(ctx, productRepo, orderRepo, bundleRepo),
+		analyticsBiz: business.NewAnalyticsBusiness(ctx, rollupRepo, shopRepo),
 	}
 }
 
@@ -151,6 +264,50 @@ func (bts *BusinessTestSuite) TestCreateShop_DuplicateSlug() {
 	})
 }
 
+func (bts *BusinessTestSuite) TestShopSlugs() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		name := "Café Über " + util.RandomAlphaNumericString(6)
+		first, err := biz.shopBiz.CreateShop(ctx, &commercev1.CreateShopRequest{Name: name})
+		require.NoError(t, err)
+		require.Equal(t, business.Slugify(name), first.GetSlug())
+		require.True(t, strings.HasPrefix(first.GetSlug(), "cafe-uber-"))
+
+		// The same name is deduped with a suffix.
+		second, err := biz.shopBiz.CreateShop(ctx, &commercev1.CreateShopRequest{Name: name})
+		require.NoError(t, err)
+		require.Equal(t, first.GetSlug()+"-2", second.GetSlug())
+
+		moved, err := biz.shopBiz.ChangeShopSlug(ctx, first.GetId(), "New Home "+util.RandomAlphaNumericString(6))
+		require.NoError(t, err)
+
+		match, err := biz.shopBiz.GetShopBySlug(ctx, moved.GetSlug())
+		require.NoError(t, err)
+		require.False(t, match.Redirected)
+		require.Equal(t, first.GetId(), match.Shop.GetId())
+
+		match, err = biz.shopBiz.GetShopBySlug(ctx, first.GetSlug())
+		require.NoError(t, err)
+		require.True(t, match.Redirected)
+		require.Equal(t, moved.GetSlug(), match.Shop.GetSlug())
+
+		// A retired slug stays reserved for its redirect.
+		_, err = biz.shopBiz.CreateShop(ctx, &commercev1.CreateShopRequest{Name: "Other", Slug: first.GetSlug()})
+		require.Equal(t, connect.CodeAlreadyExists, connect.CodeOf(err))
+
+		// Moving back reclaims the old slug.
+		_, err = biz.shopBiz.ChangeShopSlug(ctx, first.GetId(), first.GetSlug())
+		require.NoError(t, err)
+		match, err = biz.shopBiz.GetShopBySlug(ctx, first.GetSlug())
+		require.NoError(t, err)
+		require.False(t, match.Redirected)
+	})
+}
+
 func (bts *BusinessTestSuite) TestGetShop() {
 	t := bts.T()
 
@@ -246,6 +403,64 @@ func (bts *BusinessTestSuite) TestListProducts() {
 	})
 }
 
+func (bts *BusinessTestSuite) TestProductSlugs() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		create := func(name string) *commercev1.Product {
+			product, err := biz.catalogBiz.CreateProduct(ctx, &commercev1.CreateProductRequest{
+				ShopId: shop.GetId(),
+				Name:   name,
+			})
+			require.NoError(t, err)
+			return product
+		}
+
+		first := create("Crème Brûlée Mug")
+		second := create("Creme Brulee Mug")
+
+		match, err := biz.catalogBiz.GetProductBySlug(ctx, shop.GetId(), "creme-brulee-mug")
+		require.NoError(t, err)
+		require.Equal(t, first.GetId(), match.Product.GetId())
+
+		match, err = biz.catalogBiz.GetProductBySlug(ctx, shop.GetId(), "creme-brulee-mug-2")
+		require.NoError(t, err)
+		require.Equal(t, second.GetId(), match.Product.GetId())
+
+		// Slugs are unique per shop only.
+		otherShop := bts.createTestShop(ctx, biz)
+		other, err := biz.catalogBiz.CreateProduct(ctx, &commercev1.CreateProductRequest{
+			ShopId: otherShop.GetId(),
+			Name:   "Creme Brulee Mug",
+		})
+		require.NoError(t, err)
+		match, err = biz.catalogBiz.GetProductBySlug(ctx, otherShop.GetId(), "creme-brulee-mug")
+		require.NoError(t, err)
+		require.Equal(t, other.GetId(), match.Product.GetId())
+
+		renamed, err := biz.catalogBiz.RenameProduct(ctx, first.GetId(), "Espresso Cup")
+		require.NoError(t, err)
+		require.Equal(t, "espresso-cup", renamed.Slug)
+		require.Equal(t, "Espresso Cup", renamed.Product.GetName())
+
+		match, err = biz.catalogBiz.GetProductBySlug(ctx, shop.GetId(), "creme-brulee-mug")
+		require.NoError(t, err)
+		require.True(t, match.Redirected)
+		require.Equal(t, first.GetId(), match.Product.GetId())
+		require.Equal(t, "espresso-cup", match.Slug)
+
+		_, err = biz.catalogBiz.ChangeProductSlug(ctx, second.GetId(), "espresso-cup")
+		require.Equal(t, connect.CodeAlreadyExists, connect.CodeOf(err))
+
+		_, err = biz.catalogBiz.GetProductBySlug(ctx, shop.GetId(), "missing")
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+	})
+}
+
 func (bts *BusinessTestSuite) TestCreateProductVariant() {
 	t := bts.T()
 
@@ -289,44 +504,36 @@ func (bts *BusinessTestSuite) TestUpdateProductVariant() {
 	})
 }
 
-func (bts *BusinessTestSuite) TestListProductVariants() {
+func (bts *BusinessTestSuite) TestCreateProductVariant_SKUScopedPerShop() {
 	t := bts.T()
 
 	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
 		ctx, svc := bts.CreateService(t, dep)
 		biz := bts.getBusiness(ctx, svc)
 
-		shop := bts.createTestShop(ctx, biz)
+		shopA := bts.createTestShop(ctx, biz)
+		shopB := bts.createTestShop(ctx, biz)
+		productA, variantA := bts.createTestProductWithVariant(ctx, biz, shopA.GetId())
+		productB, _ := bts.createTestProductWithVariant(ctx, biz, shopB.GetId())
 
-		product, err := biz.catalogBiz.CreateProduct(ctx, &commercev1.CreateProductRequest{
-			ShopId: shop.GetId(),
-			Name:   "Product With Variants",
+		// The same SKU may be used by another shop.
+		_, err := biz.catalogBiz.CreateProductVariant(ctx, &commercev1.CreateProductVariantRequest{
+			ProductId: productB.GetId(),
+			Sku:       variantA.GetSku(),
+			Name:      "Shared SKU",
 		})
 		require.NoError(t, err)
 
-		for i := range 3 {
-			_, err := biz.catalogBiz.CreateProductVariant(ctx, &commercev1.CreateProductVariantRequest{
-				ProductId: product.GetId(),
-				Sku:       fmt.Sprintf("SKU-VAR-%d-%s", i, util.RandomAlphaNumericString(6)),
-				Name:      fmt.Sprintf("Variant %d", i),
-				Price: &money.Money{
-					CurrencyCode: "USD",
-					Units:        int64(10 + i),
-				},
-				StockQuantity: 50,
-			})
-			require.NoError(t, err)
-		}
-
-		variants, err := biz.catalogBiz.ListProductVariants(ctx, product.GetId())
-		require.NoError(t, err)
-		require.Len(t, variants, 3)
+		_, err = biz.catalogBiz.CreateProductVariant(ctx, &commercev1.CreateProductVariantRequest{
+			ProductId: productA.GetId(),
+			Sku:       variantA.GetSku(),
+			Name:      "Duplicate SKU",
+		})
+		require.Equal(t, connect.CodeAlreadyExists, connect.CodeOf(err))
 	})
 }
 
-// --- Cart Business Tests ---
-
-func (bts *BusinessTestSuite) TestCreateCart() {
+func (bts *BusinessTestSuite) TestCreateProductVariant_InvalidSKU() {
 	t := bts.T()
 
 	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
@@ -334,18 +541,27 @@ func (bts *BusinessTestSuite) TestCreateCart() {
 		biz := bts.getBusiness(ctx, svc)
 
 		shop := bts.createTestShop(ctx, biz)
+		product, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
 
-		cart, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{
-			ShopId:    shop.GetId(),
-			ProfileId: "profile-123",
+		for _, sku := range []string{"", "has space", "-leading", strings.Repeat("A", business.MaxSKULength+1)} {
+			_, err := biz.catalogBiz.CreateProductVariant(ctx, &commercev1.CreateProductVariantRequest{
+				ProductId: product.GetId(),
+				Sku:       sku,
+				Name:      "Invalid",
+			})
+			require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err), "sku %q", sku)
+		}
+
+		_, err := biz.catalogBiz.UpdateProductVariant(ctx, &commercev1.UpdateProductVariantRequest{
+			VariantId:  variant.GetId(),
+			Sku:        "bad/sku",
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"sku"}},
 		})
-		require.NoError(t, err)
-		require.NotEmpty(t, cart.GetId())
-		require.Equal(t, commercev1.CartStatus_CART_STATUS_ACTIVE, cart.GetStatus())
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
 	})
 }
 
-func (bts *BusinessTestSuite) TestAddCartLine() {
+func (bts *BusinessTestSuite) TestCreateProductVariant_InvalidPriceAndStock() {
 	t := bts.T()
 
 	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
@@ -353,25 +569,44 @@ func (bts *BusinessTestSuite) TestAddCartLine() {
 		biz := bts.getBusiness(ctx, svc)
 
 		shop := bts.createTestShop(ctx, biz)
-		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		product, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		tests := []struct {
+			name  string
+			price *money.Money
+			stock int64
+		}{
+			{"negative price", &money.Money{CurrencyCode: "USD", Units: -1}, 5},
+			{"unknown currency", &money.Money{CurrencyCode: "ZZZ", Units: 1}, 5},
+			{"negative stock", &money.Money{CurrencyCode: "USD", Units: 1}, -1},
+		}
+		for _, tt := range tests {
+			_, err := biz.catalogBiz.CreateProductVariant(ctx, &commercev1.CreateProductVariantRequest{
+				ProductId:     product.GetId(),
+				Sku:           "BAD-" + util.RandomAlphaNumericString(6),
+				Name:          tt.name,
+				Price:         tt.price,
+				StockQuantity: tt.stock,
+			})
+			require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err), tt.name)
+		}
 
-		cart, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{
-			ShopId: shop.GetId(),
+		_, err := biz.catalogBiz.UpdateProductVariant(ctx, &commercev1.UpdateProductVariantRequest{
+			VariantId:  variant.GetId(),
+			Price:      &money.Money{CurrencyCode: "USD", Units: 1, Nanos: -500_000_000},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"price"}},
 		})
-		require.NoError(t, err)
-
-		updatedCart, err := biz.cartBiz.AddCartLine(ctx, &commercev1.AddCartLineRequest{
-			CartId:           cart.GetId(),
-			ProductVariantId: variant.GetId(),
-			Quantity:         2,
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+		_, err = biz.catalogBiz.UpdateProductVariant(ctx, &commercev1.UpdateProductVariantRequest{
+			VariantId:     variant.GetId(),
+			StockQuantity: -3,
+			UpdateMask:    &fieldmaskpb.FieldMask{Paths: []string{"stock_quantity"}},
 		})
-		require.NoError(t, err)
-		require.Len(t, updatedCart.GetLines(), 1)
-		require.Equal(t, int64(2), updatedCart.GetLines()[0].GetQuantity())
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
 	})
 }
 
-func (bts *BusinessTestSuite) TestAddCartLine_MergesSameVariant() {
+func (bts *BusinessTestSuite) TestCreateProductVariant_GeneratedSKU() {
 	t := bts.T()
 
 	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
@@ -379,67 +614,71 @@ func (bts *BusinessTestSuite) TestAddCartLine_MergesSameVariant() {
 		biz := bts.getBusiness(ctx, svc)
 
 		shop := bts.createTestShop(ctx, biz)
-		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
-
-		cart, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{
-			ShopId: shop.GetId(),
+		extra, err := structpb.NewStruct(map[string]any{
+			business.SKUTemplateKey: "{product}-{options}",
 		})
 		require.NoError(t, err)
-
-		_, err = biz.cartBiz.AddCartLine(ctx, &commercev1.AddCartLineRequest{
-			CartId:           cart.GetId(),
-			ProductVariantId: variant.GetId(),
-			Quantity:         2,
+		_, err = biz.shopBiz.UpdateShop(ctx, &commercev1.UpdateShopRequest{
+			Id:    shop.GetId(),
+			Extra: extra,
 		})
 		require.NoError(t, err)
 
-		updatedCart, err := biz.cartBiz.AddCartLine(ctx, &commercev1.AddCartLineRequest{
-			CartId:           cart.GetId(),
-			ProductVariantId: variant.GetId(),
-			Quantity:         3,
+		product, err := biz.catalogBiz.CreateProduct(ctx, &commercev1.CreateProductRequest{
+			ShopId: shop.GetId(),
+			Name:   "Basic Tee",
 		})
 		require.NoError(t, err)
-		require.Len(t, updatedCart.GetLines(), 1)
-		require.Equal(t, int64(5), updatedCart.GetLines()[0].GetQuantity())
-	})
-}
-
-func (bts *BusinessTestSuite) TestRemoveCartLine() {
-	t := bts.T()
 
-	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
-		ctx, svc := bts.CreateService(t, dep)
-		biz := bts.getBusiness(ctx, svc)
+		create := func() (*commercev1.ProductVariant, error) {
+			return biz.catalogBiz.CreateProductVariant(ctx, &commercev1.CreateProductVariantRequest{
+				ProductId:  product.GetId(),
+				Name:       "Red / M",
+				Attributes: map[string]string{"size": "M", "colour": "Red"},
+			})
+		}
 
-		shop := bts.createTestShop(ctx, biz)
-		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		first, err := create()
+		require.NoError(t, err)
+		require.Equal(t, "BASIC-TEE-RED-M", first.GetSku())
 
-		cart, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{
-			ShopId: shop.GetId(),
-		})
+		second, err := create()
 		require.NoError(t, err)
+		require.Equal(t, "BASIC-TEE-RED-M-2", second.GetSku())
 
-		cartWithLine, err := biz.cartBiz.AddCartLine(ctx, &commercev1.AddCartLineRequest{
-			CartId:           cart.GetId(),
-			ProductVariantId: variant.GetId(),
-			Quantity:         2,
-		})
+		// The SKU follows the product's slug, not its display name.
+		_, err = biz.catalogBiz.ChangeProductSlug(ctx, product.GetId(), "tee-basic")
 		require.NoError(t, err)
-		require.Len(t, cartWithLine.GetLines(), 1)
 
-		lineID := cartWithLine.GetLines()[0].GetId()
-		updatedCart, err := biz.cartBiz.RemoveCartLine(ctx, &commercev1.RemoveCartLineRequest{
-			CartId:     cart.GetId(),
-			CartLineId: lineID,
-		})
+		third, err := create()
 		require.NoError(t, err)
-		require.Empty(t, updatedCart.GetLines())
+		require.Equal(t, "TEE-BASIC-RED-M", third.GetSku())
 	})
 }
 
-// --- Order Business Tests ---
+func (bts *BusinessTestSuite) createProductWithOptions(
+	ctx context.Context,
+	biz allBiz,
+	shopID string,
+) *commercev1.Product {
+	t := bts.T()
 
-func (bts *BusinessTestSuite) TestCreateOrder() {
+	product, err := biz.catalogBiz.CreateProduct(ctx, &commercev1.CreateProductRequest{
+		ShopId: shopID,
+		Name:   "Tee " + util.RandomAlphaNumericString(6),
+	})
+	require.NoError(t, err)
+
+	_, err = biz.catalogBiz.SetProductOptions(ctx, product.GetId(), []business.ProductOptionInput{
+		{Name: "Size", Values: []string{"S", "M"}},
+		{Name: "Colour", Values: []string{"Red", "Blue"}},
+	})
+	require.NoError(t, err)
+
+	return product
+}
+
+func (bts *BusinessTestSuite) TestGenerateVariantMatrix() {
 	t := bts.T()
 
 	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
@@ -447,31 +686,45 @@ func (bts *BusinessTestSuite) TestCreateOrder() {
 		biz := bts.getBusiness(ctx, svc)
 
 		shop := bts.createTestShop(ctx, biz)
-		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		product := bts.createProductWithOptions(ctx, biz, shop.GetId())
 
-		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
-			ShopId:    shop.GetId(),
-			ProfileId: "profile-123",
-			Lines: []*commercev1.CreateOrderLine{
-				{
-					VariantId: variant.GetId(),
-					Quantity:  2,
-				},
-			},
+		// Defaults a single variant could not be created with are refused.
+		_, err := biz.catalogBiz.GenerateVariantMatrix(ctx, product.GetId(), business.VariantDefaults{
+			Price:         &money.Money{CurrencyCode: "USD", Units: 15},
+			StockQuantity: -1,
 		})
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+		variants, err := biz.catalogBiz.ListProductVariants(ctx, product.GetId())
 		require.NoError(t, err)
-		require.NotEmpty(t, order.GetId())
-		require.NotEmpty(t, order.GetOrderNumber())
-		require.Equal(t, commercev1.OrderStatus_ORDER_STATUS_CONFIRMED, order.GetStatus())
-		require.Len(t, order.GetLines(), 1)
-		require.Equal(t, int64(2), order.GetLines()[0].GetQuantity())
-		// Price should be 2 * $10.50 = $21.00
-		require.Equal(t, int64(21), order.GetTotal().GetUnits())
-		require.Equal(t, int32(0), order.GetTotal().GetNanos())
+		require.Empty(t, variants)
+
+		defaults := business.VariantDefaults{
+			Price:         &money.Money{CurrencyCode: "USD", Units: 15},
+			StockQuantity: 10,
+		}
+
+		result, err := biz.catalogBiz.GenerateVariantMatrix(ctx, product.GetId(), defaults)
+		require.NoError(t, err)
+		require.Len(t, result.Created, 4)
+
+		skus := map[string]bool{}
+		for _, variant := range result.Created {
+			require.Equal(t, int64(15), variant.GetPrice().GetUnits())
+			require.Equal(t, int64(10), variant.GetStockQuantity())
+			require.NoError(t, business.ValidateSKU(variant.GetSku()))
+			skus[variant.GetSku()] = true
+		}
+		require.Len(t, skus, 4)
+
+		// Regenerating is a no-op once the matrix is complete.
+		result, err = biz.catalogBiz.GenerateVariantMatrix(ctx, product.GetId(), defaults)
+		require.NoError(t, err)
+		require.Empty(t, result.Created)
+		require.Empty(t, result.Disabled)
 	})
 }
 
-func (bts *BusinessTestSuite) TestCreateOrder_Idempotency() {
+func (bts *BusinessTestSuite) TestReconcileOptionValues() {
 	t := bts.T()
 
 	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
@@ -479,31 +732,40 @@ func (bts *BusinessTestSuite) TestCreateOrder_Idempotency() {
 		biz := bts.getBusiness(ctx, svc)
 
 		shop := bts.createTestShop(ctx, biz)
-		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		product := bts.createProductWithOptions(ctx, biz, shop.GetId())
+		defaults := business.VariantDefaults{Price: &money.Money{CurrencyCode: "USD", Units: 15}}
 
-		idemKey := "order-idem-" + util.RandomAlphaNumericString(10)
-		req := &commercev1.CreateOrderRequest{
-			ShopId:         shop.GetId(),
-			ProfileId:      "profile-123",
-			IdempotencyKey: idemKey,
-			Lines: []*commercev1.CreateOrderLine{
-				{
-					VariantId: variant.GetId(),
-					Quantity:  1,
-				},
-			},
+		_, err := biz.catalogBiz.GenerateVariantMatrix(ctx, product.GetId(), defaults)
+		require.NoError(t, err)
+
+		result, err := biz.catalogBiz.RemoveProductOptionValue(ctx, product.GetId(), "Size", "M")
+		require.NoError(t, err)
+		require.Len(t, result.Disabled, 2)
+		for _, variant := range result.Disabled {
+			require.Equal(t, "M", variant.GetAttributes()["Size"])
+			require.Equal(t, commercev1.ProductVariantStatus_PRODUCT_VARIANT_STATUS_DISABLED, variant.GetStatus())
 		}
 
-		order1, err := biz.orderBiz.CreateOrder(ctx, req)
+		// Restoring the value revives the disabled variants rather than duplicating them.
+		result, err = biz.catalogBiz.AddProductOptionValue(ctx, product.GetId(), "Size", "M", defaults)
 		require.NoError(t, err)
+		require.Empty(t, result.Created)
+		require.Len(t, result.Reactivated, 2)
 
-		order2, err := biz.orderBiz.CreateOrder(ctx, req)
+		result, err = biz.catalogBiz.AddProductOptionValue(ctx, product.GetId(), "Size", "L", defaults)
 		require.NoError(t, err)
-		require.Equal(t, order1.GetId(), order2.GetId())
+		require.Len(t, result.Created, 2)
+
+		variants, err := biz.catalogBiz.ListProductVariants(ctx, product.GetId())
+		require.NoError(t, err)
+		require.Len(t, variants, 6)
+
+		_, err = biz.catalogBiz.RemoveProductOptionValue(ctx, product.GetId(), "Size", "XL")
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
 	})
 }
 
-func (bts *BusinessTestSuite) TestCreateOrder_InsufficientStock() {
+func (bts *BusinessTestSuite) TestCreateProductVariant_ValidatesOptions() {
 	t := bts.T()
 
 	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
@@ -511,39 +773,32 @@ func (bts *BusinessTestSuite) TestCreateOrder_InsufficientStock() {
 		biz := bts.getBusiness(ctx, svc)
 
 		shop := bts.createTestShop(ctx, biz)
-		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		product := bts.createProductWithOptions(ctx, biz, shop.GetId())
 
-		_, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
-			ShopId: shop.GetId(),
-			Lines: []*commercev1.CreateOrderLine{
-				{
-					VariantId: variant.GetId(),
-					Quantity:  999, // Stock is 100
-				},
-			},
-		})
-		require.Error(t, err)
-	})
-}
+		create := func(attrs map[string]string) error {
+			_, err := biz.catalogBiz.CreateProductVariant(ctx, &commercev1.CreateProductVariantRequest{
+				ProductId:  product.GetId(),
+				Sku:        "SKU-" + util.RandomAlphaNumericString(8),
+				Name:       "Manual",
+				Attributes: attrs,
+			})
+			return err
+		}
 
-func (bts *BusinessTestSuite) TestCreateOrder_EmptyLines() {
-	t := bts.T()
+		require.NoError(t, create(map[string]string{"Size": "S", "Colour": "Red"}))
 
-	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
-		ctx, svc := bts.CreateService(t, dep)
-		biz := bts.getBusiness(ctx, svc)
+		err := create(map[string]string{"Size": "S", "Colour": "Red"})
+		require.Equal(t, connect.CodeAlreadyExists, connect.CodeOf(err))
 
-		shop := bts.createTestShop(ctx, biz)
+		err = create(map[string]string{"Size": "XXL", "Colour": "Red"})
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
 
-		_, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
-			ShopId: shop.GetId(),
-			Lines:  nil,
-		})
-		require.Error(t, err)
+		err = create(map[string]string{"Size": "M"})
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
 	})
 }
 
-func (bts *BusinessTestSuite) TestCreateOrderFromCart() {
+func (bts *BusinessTestSuite) TestListProductsByCategory() {
 	t := bts.T()
 
 	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
@@ -551,37 +806,110 @@ func (bts *BusinessTestSuite) TestCreateOrderFromCart() {
 		biz := bts.getBusiness(ctx, svc)
 
 		shop := bts.createTestShop(ctx, biz)
-		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		apparel, err := biz.navigationBiz.CreateCategory(ctx, shop.GetId(), business.CategoryInput{Name: "Apparel"})
+		require.NoError(t, err)
+		require.Equal(t, "apparel", apparel.Slug)
 
-		cart, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{
-			ShopId: shop.GetId(),
+		shirts, err := biz.navigationBiz.CreateCategory(ctx, shop.GetId(), business.CategoryInput{
+			Name:     "Shirts",
+			ParentID: apparel.GetID(),
 		})
 		require.NoError(t, err)
 
-		_, err = biz.cartBiz.AddCartLine(ctx, &commercev1.AddCartLineRequest{
-			CartId:           cart.GetId(),
-			ProductVariantId: variant.GetId(),
-			Quantity:         3,
-		})
+		_, err = biz.navigationBiz.CreateCategory(ctx, shop.GetId(), business.CategoryInput{Name: "Apparel"})
+		require.Equal(t, connect.CodeAlreadyExists, connect.CodeOf(err))
+
+		// A category cannot become its own descendant.
+		_, err = biz.navigationBiz.UpdateCategory(ctx, apparel.GetID(), business.CategoryInput{ParentID: shirts.GetID()})
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+		shirt, _ := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		hat, _ := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		require.NoError(t, biz.navigationBiz.AssignProductCategories(ctx, shirt.GetId(), []string{shirts.GetID()}))
+		require.NoError(t, biz.navigationBiz.AssignProductCategories(ctx, hat.GetId(), []string{apparel.GetID()}))
+
+		products, err := biz.navigationBiz.ListProductsByCategory(ctx, apparel.GetID(), false, 10, 0)
 		require.NoError(t, err)
+		require.Len(t, products, 1)
+		require.Equal(t, hat.GetId(), products[0].GetId())
 
-		order, err := biz.orderBiz.CreateOrderFromCart(ctx, &commercev1.CreateOrderFromCartRequest{
-			CartId:    cart.GetId(),
-			ProfileId: "profile-123",
+		extras, err := structpb.NewStruct(map[string]any{
+			business.ExtraCategoryID:         apparel.GetID(),
+			business.ExtraIncludeDescendants: true,
 		})
 		require.NoError(t, err)
-		require.NotEmpty(t, order.GetId())
-		require.Len(t, order.GetLines(), 1)
-		require.Equal(t, int64(3), order.GetLines()[0].GetQuantity())
+		products, err = biz.navigationBiz.ListProducts(ctx, &commercev1.ListProductsRequest{
+			ShopId: shop.GetId(),
+			Search: &commonv1.SearchRequest{Extras: extras},
+		})
+		require.NoError(t, err)
+		require.Len(t, products, 2)
 
-		// Cart should be converted
-		updatedCart, err := biz.cartBiz.GetCart(ctx, cart.GetId())
+		// Pagination walks the same listing one product at a time.
+		for page, want := range []int{1, 1, 0} {
+			products, err = biz.navigationBiz.ListProducts(ctx, &commercev1.ListProductsRequest{
+				ShopId: shop.GetId(),
+				Search: &commonv1.SearchRequest{
+					Extras: extras,
+					Cursor: &commonv1.PageCursor{Limit: 1, Page: fmt.Sprint(page + 1)},
+				},
+			})
+			require.NoError(t, err)
+			require.Len(t, products, want)
+		}
+	})
+}
+
+func (bts *BusinessTestSuite) TestManualCollection() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		first, _ := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		second, _ := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		collection, err := biz.navigationBiz.CreateCollection(ctx, shop.GetId(), business.CollectionInput{
+			Name: "Staff Picks",
+		})
 		require.NoError(t, err)
-		require.Equal(t, commercev1.CartStatus_CART_STATUS_CONVERTED, updatedCart.GetStatus())
+		require.Equal(t, business.CollectionTypeManual, collection.Type)
+
+		found, err := biz.navigationBiz.GetCollection(ctx, collection.GetID())
+		require.NoError(t, err)
+		require.Equal(t, shop.GetId(), found.ShopID)
+		collections, err := biz.navigationBiz.ListCollections(ctx, shop.GetId())
+		require.NoError(t, err)
+		require.Len(t, collections, 1)
+
+		require.NoError(t, biz.navigationBiz.SetCollectionProducts(
+			ctx, collection.GetID(), []string{second.GetId(), first.GetId()}))
+
+		products, err := biz.navigationBiz.ListProductsByCollection(ctx, collection.GetID(), 10, 0)
+		require.NoError(t, err)
+		require.Len(t, products, 2)
+		require.Equal(t, second.GetId(), products[0].GetId())
+		require.Equal(t, first.GetId(), products[1].GetId())
+
+		// Products of another shop cannot be added.
+		otherShop := bts.createTestShop(ctx, biz)
+		foreign, _ := bts.createTestProductWithVariant(ctx, biz, otherShop.GetId())
+		err = biz.navigationBiz.SetCollectionProducts(ctx, collection.GetID(), []string{foreign.GetId()})
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+		extras, err := structpb.NewStruct(map[string]any{business.ExtraCollectionID: collection.GetID()})
+		require.NoError(t, err)
+		_, err = biz.navigationBiz.ListProducts(ctx, &commercev1.ListProductsRequest{
+			ShopId: otherShop.GetId(),
+			Search: &commonv1.SearchRequest{Extras: extras},
+		})
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
 	})
 }
 
-func (bts *BusinessTestSuite) TestListOrders() {
+func (bts *BusinessTestSuite) TestRuleCollection() {
 	t := bts.T()
 
 	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
@@ -589,32 +917,118 @@ func (bts *BusinessTestSuite) TestListOrders() {
 		biz := bts.getBusiness(ctx, svc)
 
 		shop := bts.createTestShop(ctx, biz)
-		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		create := func(brand string, units int64) *commercev1.Product {
+			product, err := biz.catalogBiz.CreateProduct(ctx, &commercev1.CreateProductRequest{
+				ShopId:     shop.GetId(),
+				Name:       "Phone " + util.RandomAlphaNumericString(6),
+				Attributes: map[string]string{"brand": brand},
+			})
+			require.NoError(t, err)
+			_, err = biz.catalogBiz.CreateProductVariant(ctx, &commercev1.CreateProductVariantRequest{
+				ProductId:     product.GetId(),
+				Sku:           "SKU-" + util.RandomAlphaNumericString(8),
+				Name:          "Standard",
+				Price:         &money.Money{CurrencyCode: "USD", Units: units},
+				StockQuantity: 5,
+			})
+			require.NoError(t, err)
+			return product
+		}
 
-		for range 2 {
-			_, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
-				ShopId: shop.GetId(),
-				Lines: []*commercev1.CreateOrderLine{
-					{
-						VariantId: variant.GetId(),
-						Quantity:  1,
-					},
+		cheap := create("Acme", 500)
+		create("Acme", 1500)
+		create("Other", 500)
+
+		collection, err := biz.navigationBiz.CreateCollection(ctx, shop.GetId(), business.CollectionInput{
+			Name: "Affordable Acme",
+			Type: business.CollectionTypeRules,
+			Rules: []models.CollectionRule{
+				{Field: "attribute", Attribute: "brand", Operator: "eq", Value: "Acme"},
+				{Field: "price", Operator: "lt", Value: "1000"},
+			},
+		})
+		require.NoError(t, err)
+
+		products, err := biz.navigationBiz.ListProductsByCollection(ctx, collection.GetID(), 10, 0)
+		require.NoError(t, err)
+		require.Len(t, products, 1)
+		require.Equal(t, cheap.GetId(), products[0].GetId())
+
+		// Rule-based collections do not take an explicit product list.
+		err = biz.navigationBiz.SetCollectionProducts(ctx, collection.GetID(), []string{cheap.GetId()})
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+	})
+}
+
+func (bts *BusinessTestSuite) TestListProductVariants() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+
+		product, err := biz.catalogBiz.CreateProduct(ctx, &commercev1.CreateProductRequest{
+			ShopId: shop.GetId(),
+			Name:   "Product With Variants",
+		})
+		require.NoError(t, err)
+
+		for i := range 3 {
+			_, err := biz.catalogBiz.CreateProductVariant(ctx, &commercev1.CreateProductVariantRequest{
+				ProductId: product.GetId(),
+				Sku:       fmt.Sprintf("SKU-VAR-%d-%s", i, util.RandomAlphaNumericString(6)),
+				Name:      fmt.Sprintf("Variant %d", i),
+				Price: &money.Money{
+					CurrencyCode: "USD",
+					Units:        int64(10 + i),
 				},
+				StockQuantity: 50,
 			})
 			require.NoError(t, err)
 		}
 
-		orders, err := biz.orderBiz.ListOrders(ctx, &commercev1.ListOrdersRequest{
-			ShopId: shop.GetId(),
+		variants, err := biz.catalogBiz.ListProductVariants(ctx, product.GetId())
+		require.NoError(t, err)
+		require.Len(t, variants, 3)
+	})
+}
+
+// --- Cart Business Tests ---
+
+func (bts *BusinessTestSuite) TestCreateCart() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+
+		cart, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{
+			ShopId:    shop.GetId(),
+			ProfileId: "profile-123",
 		})
 		require.NoError(t, err)
-		require.Len(t, orders, 2)
+		require.NotEmpty(t, cart.GetId())
+		require.Equal(t, commercev1.CartStatus_CART_STATUS_ACTIVE, cart.GetStatus())
 	})
 }
 
-// --- Fulfilment Business Tests ---
+func (bts *BusinessTestSuite) TestCreateCart_InvalidShop() {
+	t := bts.T()
 
-func (bts *BusinessTestSuite) TestCreateFulfilment() {
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		_, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{ShopId: "nonexistent-shop"})
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+	})
+}
+
+func (bts *BusinessTestSuite) TestAddCartLine() {
 	t := bts.T()
 
 	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
@@ -624,36 +1038,23 @@ func (bts *BusinessTestSuite) TestCreateFulfilment() {
 		shop := bts.createTestShop(ctx, biz)
 		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
 
-		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+		cart, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{
 			ShopId: shop.GetId(),
-			Lines: []*commercev1.CreateOrderLine{
-				{
-					VariantId: variant.GetId(),
-					Quantity:  5,
-				},
-			},
 		})
 		require.NoError(t, err)
 
-		orderLineID := order.GetLines()[0].GetId()
-
-		fulfilment, err := biz.fulfilmentBiz.CreateFulfilment(ctx, &commercev1.CreateFulfilmentRequest{
-			OrderId: order.GetId(),
-			Lines: []*commercev1.FulfilmentLine{
-				{
-					OrderLineId: orderLineID,
-					Quantity:    3,
-				},
-			},
+		updatedCart, err := biz.cartBiz.AddCartLine(ctx, &commercev1.AddCartLineRequest{
+			CartId:           cart.GetId(),
+			ProductVariantId: variant.GetId(),
+			Quantity:         2,
 		})
 		require.NoError(t, err)
-		require.NotEmpty(t, fulfilment.GetId())
-		require.Len(t, fulfilment.GetLines(), 1)
-		require.Equal(t, int64(3), fulfilment.GetLines()[0].GetQuantity())
+		require.Len(t, updatedCart.GetLines(), 1)
+		require.Equal(t, int64(2), updatedCart.GetLines()[0].GetQuantity())
 	})
 }
 
-func (bts *BusinessTestSuite) TestCreateFulfilment_FullyFulfilled() {
+func (bts *BusinessTestSuite) TestAddCartLine_MergesSameVariant() {
 	t := bts.T()
 
 	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
@@ -663,39 +1064,30 @@ func (bts *BusinessTestSuite) TestCreateFulfilment_FullyFulfilled() {
 		shop := bts.createTestShop(ctx, biz)
 		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
 
-		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+		cart, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{
 			ShopId: shop.GetId(),
-			Lines: []*commercev1.CreateOrderLine{
-				{
-					VariantId: variant.GetId(),
-					Quantity:  5,
-				},
-			},
 		})
 		require.NoError(t, err)
 
-		orderLineID := order.GetLines()[0].GetId()
-
-		// Fulfil all 5 items
-		_, err = biz.fulfilmentBiz.CreateFulfilment(ctx, &commercev1.CreateFulfilmentRequest{
-			OrderId: order.GetId(),
-			Lines: []*commercev1.FulfilmentLine{
-				{
-					OrderLineId: orderLineID,
-					Quantity:    5,
-				},
-			},
+		_, err = biz.cartBiz.AddCartLine(ctx, &commercev1.AddCartLineRequest{
+			CartId:           cart.GetId(),
+			ProductVariantId: variant.GetId(),
+			Quantity:         2,
 		})
 		require.NoError(t, err)
 
-		// Check order status is fully fulfilled
-		updatedOrder, err := biz.orderBiz.GetOrder(ctx, order.GetId())
+		updatedCart, err := biz.cartBiz.AddCartLine(ctx, &commercev1.AddCartLineRequest{
+			CartId:           cart.GetId(),
+			ProductVariantId: variant.GetId(),
+			Quantity:         3,
+		})
 		require.NoError(t, err)
-		require.Equal(t, commercev1.OrderStatus_ORDER_STATUS_FULFILLED, updatedOrder.GetStatus())
+		require.Len(t, updatedCart.GetLines(), 1)
+		require.Equal(t, int64(5), updatedCart.GetLines()[0].GetQuantity())
 	})
 }
 
-func (bts *BusinessTestSuite) TestCreateFulfilment_ExceedsQuantity() {
+func (bts *BusinessTestSuite) TestRemoveCartLine() {
 	t := bts.T()
 
 	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
@@ -705,34 +1097,32 @@ func (bts *BusinessTestSuite) TestCreateFulfilment_ExceedsQuantity() {
 		shop := bts.createTestShop(ctx, biz)
 		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
 
-		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+		cart, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{
 			ShopId: shop.GetId(),
-			Lines: []*commercev1.CreateOrderLine{
-				{
-					VariantId: variant.GetId(),
-					Quantity:  5,
-				},
-			},
 		})
 		require.NoError(t, err)
 
-		orderLineID := order.GetLines()[0].GetId()
+		cartWithLine, err := biz.cartBiz.AddCartLine(ctx, &commercev1.AddCartLineRequest{
+			CartId:           cart.GetId(),
+			ProductVariantId: variant.GetId(),
+			Quantity:         2,
+		})
+		require.NoError(t, err)
+		require.Len(t, cartWithLine.GetLines(), 1)
 
-		// Try to fulfil more than ordered
-		_, err = biz.fulfilmentBiz.CreateFulfilment(ctx, &commercev1.CreateFulfilmentRequest{
-			OrderId: order.GetId(),
-			Lines: []*commercev1.FulfilmentLine{
-				{
-					OrderLineId: orderLineID,
-					Quantity:    10,
-				},
-			},
+		lineID := cartWithLine.GetLines()[0].GetId()
+		updatedCart, err := biz.cartBiz.RemoveCartLine(ctx, &commercev1.RemoveCartLineRequest{
+			CartId:     cart.GetId(),
+			CartLineId: lineID,
 		})
-		require.Error(t, err)
+		require.NoError(t, err)
+		require.Empty(t, updatedCart.GetLines())
 	})
 }
 
-func (bts *BusinessTestSuite) TestUpdateFulfilment() {
+// --- Order Business Tests ---
+
+func (bts *BusinessTestSuite) TestCreateOrder() {
 	t := bts.T()
 
 	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
@@ -743,38 +1133,3879 @@ func (bts *BusinessTestSuite) TestUpdateFulfilment() {
 		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
 
 		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
-			ShopId: shop.GetId(),
+			ShopId:    shop.GetId(),
+			ProfileId: "profile-123",
 			Lines: []*commercev1.CreateOrderLine{
 				{
 					VariantId: variant.GetId(),
-					Quantity:  5,
+					Quantity:  2,
 				},
 			},
 		})
 		require.NoError(t, err)
+		require.NotEmpty(t, order.GetId())
+		require.NotEmpty(t, order.GetOrderNumber())
+		require.Equal(t, commercev1.OrderStatus_ORDER_STATUS_CONFIRMED, order.GetStatus())
+		require.Len(t, order.GetLines(), 1)
+		require.Equal(t, int64(2), order.GetLines()[0].GetQuantity())
+		// Price should be 2 * $10.50 = $21.00
+		require.Equal(t, int64(21), order.GetTotal().GetUnits())
+		require.Equal(t, int32(0), order.GetTotal().GetNanos())
+	})
+}
 
-		orderLineID := order.GetLines()[0].GetId()
+func (bts *BusinessTestSuite) TestCreateOrder_Idempotency() {
+	t := bts.T()
 
-		fulfilment, err := biz.fulfilmentBiz.CreateFulfilment(ctx, &commercev1.CreateFulfilmentRequest{
-			OrderId: order.GetId(),
-			Lines: []*commercev1.FulfilmentLine{
-				{
-					OrderLineId: orderLineID,
-					Quantity:    5,
-				},
-			},
-		})
-		require.NoError(t, err)
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
 
-		updated, err := biz.fulfilmentBiz.UpdateFulfilment(ctx, &commercev1.UpdateFulfilmentRequest{
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		idemKey := "order-idem-" + util.RandomAlphaNumericString(10)
+		req := &commercev1.CreateOrderRequest{
+			ShopId:         shop.GetId(),
+			ProfileId:      "profile-123",
+			IdempotencyKey: idemKey,
+			Lines: []*commercev1.CreateOrderLine{
+				{
+					VariantId: variant.GetId(),
+					Quantity:  1,
+				},
+			},
+		}
+
+		order1, err := biz.orderBiz.CreateOrder(ctx, req)
+		require.NoError(t, err)
+
+		order2, err := biz.orderBiz.CreateOrder(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, order1.GetId(), order2.GetId())
+	})
+}
+
+func (bts *BusinessTestSuite) TestCreateOrder_IdempotencyPayloadMismatch() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		idemKey := "order-idem-" + util.RandomAlphaNumericString(10)
+		_, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId:         shop.GetId(),
+			IdempotencyKey: idemKey,
+			Lines:          []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 1}},
+		})
+		require.NoError(t, err)
+
+		_, err = biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId:         shop.GetId(),
+			IdempotencyKey: idemKey,
+			Lines:          []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 2}},
+		})
+		require.Error(t, err)
+		require.Equal(t, connect.CodeAlreadyExists, connect.CodeOf(err))
+	})
+}
+
+func (bts *BusinessTestSuite) TestCreateOrder_IdempotencyScopedPerShopAndCaller() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shopA := bts.createTestShop(ctx, biz)
+		_, variantA := bts.createTestProductWithVariant(ctx, biz, shopA.GetId())
+		shopB := bts.createTestShop(ctx, biz)
+		_, variantB := bts.createTestProductWithVariant(ctx, biz, shopB.GetId())
+
+		idemKey := "shared-key-" + util.RandomAlphaNumericString(10)
+
+		callerCtx := func(profileID string) context.Context {
+			claims := &security.AuthenticationClaims{}
+			claims.Subject = profileID
+			return claims.ClaimsToContext(ctx)
+		}
+		aliceCtx := callerCtx("alice")
+		bobCtx := callerCtx("bob")
+
+		reqA := &commercev1.CreateOrderRequest{
+			ShopId:         shopA.GetId(),
+			IdempotencyKey: idemKey,
+			Lines:          []*commercev1.CreateOrderLine{{VariantId: variantA.GetId(), Quantity: 1}},
+		}
+
+		aliceOrder, err := biz.orderBiz.CreateOrder(aliceCtx, reqA)
+		require.NoError(t, err)
+
+		// Another caller reusing the key in the same shop gets their own order.
+		bobOrder, err := biz.orderBiz.CreateOrder(bobCtx, reqA)
+		require.NoError(t, err)
+		require.NotEqual(t, aliceOrder.GetId(), bobOrder.GetId())
+
+		// The same caller reusing the key in another shop gets a new order too.
+		aliceOrderB, err := biz.orderBiz.CreateOrder(aliceCtx, &commercev1.CreateOrderRequest{
+			ShopId:         shopB.GetId(),
+			IdempotencyKey: idemKey,
+			Lines:          []*commercev1.CreateOrderLine{{VariantId: variantB.GetId(), Quantity: 1}},
+		})
+		require.NoError(t, err)
+		require.NotEqual(t, aliceOrder.GetId(), aliceOrderB.GetId())
+		require.Equal(t, shopB.GetId(), aliceOrderB.GetShopId())
+
+		// A genuine replay still returns the original order.
+		replayed, err := biz.orderBiz.CreateOrder(aliceCtx, reqA)
+		require.NoError(t, err)
+		require.Equal(t, aliceOrder.GetId(), replayed.GetId())
+	})
+}
+
+func (bts *BusinessTestSuite) TestCreateOrder_IdempotencyKeyExpires() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		idemKey := "order-idem-" + util.RandomAlphaNumericString(10)
+		req := &commercev1.CreateOrderRequest{
+			ShopId:         shop.GetId(),
+			IdempotencyKey: idemKey,
+			Lines:          []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 1}},
+		}
+
+		first, err := biz.orderBiz.CreateOrder(ctx, req)
+		require.NoError(t, err)
+
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		idempotencyRepo := repository.NewIdempotencyRepository(ctx, dbPool, svc.WorkManager())
+		record, err := idempotencyRepo.GetByScope(ctx, shop.GetId(), "", "create_order", idemKey)
+		require.NoError(t, err)
+		require.Equal(t, first.GetId(), record.ResourceID)
+
+		record.ExpiresAt = time.Now().Add(-time.Minute)
+		_, err = idempotencyRepo.Update(ctx, record, "expires_at")
+		require.NoError(t, err)
+
+		second, err := biz.orderBiz.CreateOrder(ctx, req)
+		require.NoError(t, err)
+		require.NotEqual(t, first.GetId(), second.GetId())
+	})
+}
+
+func (bts *BusinessTestSuite) TestCreateOrder_IdempotencyRecoversAbandonedClaim() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		idemKey := "order-idem-" + util.RandomAlphaNumericString(10)
+		req := &commercev1.CreateOrderRequest{
+			ShopId:         shop.GetId(),
+			IdempotencyKey: idemKey,
+			Lines:          []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 1}},
+		}
+
+		first, err := biz.orderBiz.CreateOrder(ctx, req)
+		require.NoError(t, err)
+
+		// A request that crashed after creating the order left its claim
+		// without the order and long untouched.
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		err = dbPool.DB(ctx, false).Model(&models.IdempotencyRecord{}).
+			Where("shop_id = ? AND idempotency_key = ?", shop.GetId(), idemKey).
+			UpdateColumns(map[string]any{"resource_id": "", "modified_at": time.Now().Add(-time.Hour)}).Error
+		require.NoError(t, err)
+
+		second, err := biz.orderBiz.CreateOrder(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, first.GetId(), second.GetId())
+
+		orders, err := biz.orderBiz.ListOrders(ctx, &commercev1.ListOrdersRequest{ShopId: shop.GetId()})
+		require.NoError(t, err)
+		require.Len(t, orders, 1)
+
+		// Another caller's claim on the same key, abandoned before its order
+		// was written, recovers nothing and never the first caller's order.
+		otherCtx := callerContext(ctx, "profile-other-caller")
+		other, err := biz.orderBiz.CreateOrder(otherCtx, req)
+		require.NoError(t, err)
+		require.NotEqual(t, first.GetId(), other.GetId())
+
+		err = dbPool.DB(ctx, false).Unscoped().Delete(&models.Order{}, "id = ?", other.GetId()).Error
+		require.NoError(t, err)
+		err = dbPool.DB(ctx, false).Model(&models.IdempotencyRecord{}).
+			Where("shop_id = ? AND caller_id = ? AND idempotency_key = ?", shop.GetId(), "profile-other-caller", idemKey).
+			UpdateColumns(map[string]any{"resource_id": "", "modified_at": time.Now().Add(-time.Hour)}).Error
+		require.NoError(t, err)
+
+		retried, err := biz.orderBiz.CreateOrder(otherCtx, req)
+		require.NoError(t, err)
+		require.NotEqual(t, first.GetId(), retried.GetId())
+	})
+}
+
+func (bts *BusinessTestSuite) TestCreateOrder_InsufficientStock() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		_, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines: []*commercev1.CreateOrderLine{
+				{
+					VariantId: variant.GetId(),
+					Quantity:  999, // Stock is 100
+				},
+			},
+		})
+		require.Error(t, err)
+	})
+}
+
+func (bts *BusinessTestSuite) TestCancelOrder_ReleasesStock() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		product, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		stockOf := func() int64 {
+			variants, err := biz.catalogBiz.ListProductVariants(ctx, product.GetId())
+			require.NoError(t, err)
+			require.Len(t, variants, 1)
+			return variants[0].GetStockQuantity()
+		}
+		orderLines := []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 2}}
+
+		unpaid, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(), Lines: orderLines,
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(98), stockOf())
+
+		cancelled, err := biz.orderBiz.CancelOrder(ctx, unpaid.GetId())
+		require.NoError(t, err)
+		require.Equal(t, commercev1.OrderStatus_ORDER_STATUS_CANCELLED, cancelled.GetStatus())
+		require.Equal(t, int64(100), stockOf())
+
+		// Cancelling again gives nothing back a second time.
+		_, err = biz.orderBiz.CancelOrder(ctx, unpaid.GetId())
+		require.NoError(t, err)
+		require.Equal(t, int64(100), stockOf())
+
+		// A paid order cannot be cancelled; refunding it gives the stock back.
+		paid, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(), Lines: orderLines,
+		})
+		require.NoError(t, err)
+		_, err = biz.paymentBiz.CapturePayment(ctx, paid.GetId())
+		require.NoError(t, err)
+
+		_, err = biz.orderBiz.CancelOrder(ctx, paid.GetId())
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+		require.Equal(t, int64(98), stockOf())
+
+		_, err = biz.paymentBiz.RefundPayment(ctx, paid.GetId())
+		require.NoError(t, err)
+		require.Equal(t, int64(100), stockOf())
+	})
+}
+
+func (bts *BusinessTestSuite) TestCreateOrder_EmptyLines() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+
+		_, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines:  nil,
+		})
+		require.Error(t, err)
+	})
+}
+
+func (bts *BusinessTestSuite) TestCreateOrder_SequentialOrderNumbers() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		year := time.Now().Year()
+		for i := 1; i <= 2; i++ {
+			order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+				ShopId: shop.GetId(),
+				Lines:  []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 1}},
+			})
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("ORD-%d-%06d", year, i), order.GetOrderNumber())
+		}
+	})
+}
+
+func (bts *BusinessTestSuite) TestCreateOrder_CustomOrderNumberFormat() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		extra, err := structpb.NewStruct(map[string]any{
+			business.OrderNumberPrefixKey:  "SHOP",
+			business.OrderNumberFormatKey:  "{prefix}/{yy}{mm}/{seq}",
+			business.OrderNumberPaddingKey: 4,
+		})
+		require.NoError(t, err)
+
+		_, err = biz.shopBiz.UpdateShop(ctx, &commercev1.UpdateShopRequest{
+			Id:    shop.GetId(),
+			Extra: extra,
+		})
+		require.NoError(t, err)
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines:  []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 1}},
+		})
+		require.NoError(t, err)
+
+		now := time.Now()
+		require.Equal(t, fmt.Sprintf("SHOP/%02d%02d/0001", now.Year()%100, int(now.Month())), order.GetOrderNumber())
+	})
+}
+
+func (bts *BusinessTestSuite) TestCreateOrder_ConcurrentOrderNumbers() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		// Occupy the first numbers so that concurrent requests must retry past them.
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		orderRepo := repository.NewOrderRepository(ctx, dbPool, svc.WorkManager())
+		year := time.Now().Year()
+		for i := 1; i <= 3; i++ {
+			inserted, err := orderRepo.CreateWithLines(ctx, &models.Order{
+				ShopID:         shop.GetId(),
+				OrderNumber:    fmt.Sprintf("ORD-%d-%06d", year, i),
+				IdempotencyKey: "legacy-" + util.RandomAlphaNumericString(10),
+				Status:         int32(commercev1.OrderStatus_ORDER_STATUS_CONFIRMED),
+			}, nil)
+			require.NoError(t, err)
+			require.True(t, inserted)
+		}
+
+		const workers = 10
+		numbers := make([]string, workers)
+		errs := make([]error, workers)
+
+		var wg sync.WaitGroup
+		for i := range workers {
+			wg.Add(1)
+			go func(idx int) {
+				defer wg.Done()
+				order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+					ShopId: shop.GetId(),
+					Lines:  []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 1}},
+				})
+				errs[idx] = err
+				numbers[idx] = order.GetOrderNumber()
+			}(i)
+		}
+		wg.Wait()
+
+		seen := make(map[string]struct{}, workers)
+		for i := range workers {
+			require.NoError(t, errs[i])
+			seen[numbers[i]] = struct{}{}
+		}
+		require.Len(t, seen, workers)
+		for i := 1; i <= 3; i++ {
+			require.NotContains(t, seen, fmt.Sprintf("ORD-%d-%06d", year, i))
+		}
+	})
+}
+
+func (bts *BusinessTestSuite) TestCreateOrderFromCart() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		cart, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{
+			ShopId: shop.GetId(),
+		})
+		require.NoError(t, err)
+
+		_, err = biz.cartBiz.AddCartLine(ctx, &commercev1.AddCartLineRequest{
+			CartId:           cart.GetId(),
+			ProductVariantId: variant.GetId(),
+			Quantity:         3,
+		})
+		require.NoError(t, err)
+
+		order, err := biz.orderBiz.CreateOrderFromCart(ctx, &commercev1.CreateOrderFromCartRequest{
+			CartId:    cart.GetId(),
+			ProfileId: "profile-123",
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, order.GetId())
+		require.Len(t, order.GetLines(), 1)
+		require.Equal(t, int64(3), order.GetLines()[0].GetQuantity())
+
+		// Cart should be converted
+		updatedCart, err := biz.cartBiz.GetCart(ctx, cart.GetId())
+		require.NoError(t, err)
+		require.Equal(t, commercev1.CartStatus_CART_STATUS_CONVERTED, updatedCart.GetStatus())
+	})
+}
+
+func (bts *BusinessTestSuite) TestCreateOrderFromCart_RetryReturnsSameOrder() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		product, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		cart, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{
+			ShopId: shop.GetId(),
+		})
+		require.NoError(t, err)
+
+		_, err = biz.cartBiz.AddCartLine(ctx, &commercev1.AddCartLineRequest{
+			CartId:           cart.GetId(),
+			ProductVariantId: variant.GetId(),
+			Quantity:         2,
+		})
+		require.NoError(t, err)
+
+		req := &commercev1.CreateOrderFromCartRequest{
+			CartId:    cart.GetId(),
+			ProfileId: "profile-123",
+		}
+
+		first, err := biz.orderBiz.CreateOrderFromCart(ctx, req)
+		require.NoError(t, err)
+
+		// A retry after a lost response must not create a second order.
+		second, err := biz.orderBiz.CreateOrderFromCart(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, first.GetId(), second.GetId())
+		require.Equal(t, first.GetOrderNumber(), second.GetOrderNumber())
+
+		// Stock is decremented once.
+		variants, err := biz.catalogBiz.ListProductVariants(ctx, product.GetId())
+		require.NoError(t, err)
+		require.Len(t, variants, 1)
+		require.Equal(t, int64(98), variants[0].GetStockQuantity())
+
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		orderRepo := repository.NewOrderRepository(ctx, dbPool, svc.WorkManager())
+		stored, err := orderRepo.GetByCartID(ctx, cart.GetId())
+		require.NoError(t, err)
+		require.Equal(t, first.GetId(), stored.GetID())
+		require.Equal(t, cart.GetId(), stored.CartID)
+	})
+}
+
+func (bts *BusinessTestSuite) TestCreateOrderFromCart_ConcurrentConversions() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		product, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		cart, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{
+			ShopId: shop.GetId(),
+		})
+		require.NoError(t, err)
+
+		_, err = biz.cartBiz.AddCartLine(ctx, &commercev1.AddCartLineRequest{
+			CartId:           cart.GetId(),
+			ProductVariantId: variant.GetId(),
+			Quantity:         1,
+		})
+		require.NoError(t, err)
+
+		const workers = 5
+		orderIDs := make([]string, workers)
+		var wg sync.WaitGroup
+		for i := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// Distinct keys bypass the idempotency record, leaving the cart to
+				// guard against a second conversion.
+				workerCtx := business.IdempotencyKeyToContext(ctx, util.RandomAlphaNumericString(12))
+				order, convertErr := biz.orderBiz.CreateOrderFromCart(workerCtx, &commercev1.CreateOrderFromCartRequest{
+					CartId: cart.GetId(),
+				})
+				if convertErr == nil {
+					orderIDs[i] = order.GetId()
+				}
+			}()
+		}
+		wg.Wait()
+
+		var converted string
+		for _, id := range orderIDs {
+			if id == "" {
+				continue
+			}
+			if converted == "" {
+				converted = id
+			}
+			require.Equal(t, converted, id)
+		}
+		require.NotEmpty(t, converted)
+
+		variants, err := biz.catalogBiz.ListProductVariants(ctx, product.GetId())
+		require.NoError(t, err)
+		require.Equal(t, int64(99), variants[0].GetStockQuantity())
+	})
+}
+
+func (bts *BusinessTestSuite) TestListOrders() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		for range 2 {
+			_, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+				ShopId: shop.GetId(),
+				Lines: []*commercev1.CreateOrderLine{
+					{
+						VariantId: variant.GetId(),
+						Quantity:  1,
+					},
+				},
+			})
+			require.NoError(t, err)
+		}
+
+		orders, err := biz.orderBiz.ListOrders(ctx, &commercev1.ListOrdersRequest{
+			ShopId: shop.GetId(),
+		})
+		require.NoError(t, err)
+		require.Len(t, orders, 2)
+	})
+}
+
+// --- Fulfilment Business Tests ---
+
+func (bts *BusinessTestSuite) TestCreateFulfilment() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines: []*commercev1.CreateOrderLine{
+				{
+					VariantId: variant.GetId(),
+					Quantity:  5,
+				},
+			},
+		})
+		require.NoError(t, err)
+
+		orderLineID := order.GetLines()[0].GetId()
+
+		fulfilment, err := biz.fulfilmentBiz.CreateFulfilment(ctx, &commercev1.CreateFulfilmentRequest{
+			OrderId: order.GetId(),
+			Lines: []*commercev1.FulfilmentLine{
+				{
+					OrderLineId: orderLineID,
+					Quantity:    3,
+				},
+			},
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, fulfilment.GetId())
+		require.Len(t, fulfilment.GetLines(), 1)
+		require.Equal(t, int64(3), fulfilment.GetLines()[0].GetQuantity())
+	})
+}
+
+func (bts *BusinessTestSuite) TestCreateFulfilment_Idempotency() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines:  []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 5}},
+		})
+		require.NoError(t, err)
+
+		keyCtx := business.IdempotencyKeyToContext(ctx, "fulfil-"+util.RandomAlphaNumericString(10))
+		req := &commercev1.CreateFulfilmentRequest{
+			OrderId: order.GetId(),
+			Lines:   []*commercev1.FulfilmentLine{{OrderLineId: order.GetLines()[0].GetId(), Quantity: 2}},
+		}
+
+		first, err := biz.fulfilmentBiz.CreateFulfilment(keyCtx, req)
+		require.NoError(t, err)
+
+		second, err := biz.fulfilmentBiz.CreateFulfilment(keyCtx, req)
+		require.NoError(t, err)
+		require.Equal(t, first.GetId(), second.GetId())
+	})
+}
+
+func (bts *BusinessTestSuite) TestCreateFulfilment_FullyFulfilled() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines: []*commercev1.CreateOrderLine{
+				{
+					VariantId: variant.GetId(),
+					Quantity:  5,
+				},
+			},
+		})
+		require.NoError(t, err)
+
+		orderLineID := order.GetLines()[0].GetId()
+
+		// Fulfil all 5 items
+		fulfilment, err := biz.fulfilmentBiz.CreateFulfilment(ctx, &commercev1.CreateFulfilmentRequest{
+			OrderId: order.GetId(),
+			Lines: []*commercev1.FulfilmentLine{
+				{
+					OrderLineId: orderLineID,
+					Quantity:    5,
+				},
+			},
+		})
+		require.NoError(t, err)
+
+		// Allocating the items does not fulfil the order
+		updatedOrder, err := biz.orderBiz.GetOrder(ctx, order.GetId())
+		require.NoError(t, err)
+		require.Equal(t, commercev1.OrderStatus_ORDER_STATUS_CONFIRMED, updatedOrder.GetStatus())
+		require.Equal(t, commercev1.FulfilmentStatus_FULFILMENT_STATUS_UNSPECIFIED, updatedOrder.GetFulfilmentStatus())
+
+		for _, status := range []commercev1.FulfilmentStatus{
+			commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED,
+			commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED,
+		} {
+			_, err = biz.fulfilmentBiz.UpdateFulfilment(ctx, &commercev1.UpdateFulfilmentRequest{
+				Id:         fulfilment.GetId(),
+				Status:     status,
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"status"}},
+			})
+			require.NoError(t, err)
+
+			updatedOrder, err = biz.orderBiz.GetOrder(ctx, order.GetId())
+			require.NoError(t, err)
+			require.Equal(t, status, updatedOrder.GetFulfilmentStatus())
+		}
+
+		// Check order status is fully fulfilled
+		require.Equal(t, commercev1.OrderStatus_ORDER_STATUS_FULFILLED, updatedOrder.GetStatus())
+	})
+}
+
+func (bts *BusinessTestSuite) TestShipping_TrackingWebhookAdvancesFulfilment() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines:  []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 2}},
+		})
+		require.NoError(t, err)
+
+		fulfilment, err := biz.fulfilmentBiz.CreateFulfilment(ctx, &commercev1.CreateFulfilmentRequest{
+			OrderId: order.GetId(),
+			Lines:   []*commercev1.FulfilmentLine{{OrderLineId: order.GetLines()[0].GetId(), Quantity: 2}},
+		})
+		require.NoError(t, err)
+
+		quotes, err := biz.shippingBiz.QuoteShipping(ctx, fulfilment.GetId(), business.FakeCarrierName)
+		require.NoError(t, err)
+		require.Len(t, quotes, 2)
+
+		label, err := biz.shippingBiz.BuyLabel(ctx, fulfilment.GetId(), business.FakeCarrierName, "standard")
+		require.NoError(t, err)
+		require.NotEmpty(t, label.TrackingNumber)
+
+		_, err = biz.shippingBiz.BuyLabel(ctx, fulfilment.GetId(), business.FakeCarrierName, "standard")
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		shippedAt := time.Now().Add(-2 * time.Hour).UTC().Truncate(time.Second)
+		deliveredAt := shippedAt.Add(time.Hour)
+		body, err := json.Marshal(business.FakeCarrierWebhook{Events: []business.FakeCarrierEvent{
+			{ID: "evt-1", TrackingNumber: label.TrackingNumber, Status: "in_transit", OccurredAt: shippedAt},
+			{ID: "evt-2", TrackingNumber: label.TrackingNumber, Status: "delivered", OccurredAt: deliveredAt},
+			{ID: "evt-3", TrackingNumber: "UNKNOWN", Status: "delivered", OccurredAt: deliveredAt},
+		}})
+		require.NoError(t, err)
+
+		carrier := business.NewFakeCarrier(testCarrierSecret)
+		header := http.Header{}
+		header.Set(business.FakeCarrierSignatureHeader, carrier.Sign(body))
+
+		forged := http.Header{}
+		forged.Set(business.FakeCarrierSignatureHeader, business.NewFakeCarrier("wrong").Sign(body))
+		_, err = biz.shippingBiz.ReceiveTrackingWebhook(ctx, business.FakeCarrierName, forged, body)
+		require.Equal(t, connect.CodeUnauthenticated, connect.CodeOf(err))
+
+		recorded, err := biz.shippingBiz.ReceiveTrackingWebhook(ctx, business.FakeCarrierName, header, body)
+		require.NoError(t, err)
+		require.Equal(t, 2, recorded)
+
+		// Redelivery records nothing new.
+		recorded, err = biz.shippingBiz.ReceiveTrackingWebhook(ctx, business.FakeCarrierName, header, body)
+		require.NoError(t, err)
+		require.Equal(t, 0, recorded)
+
+		delivered, err := biz.fulfilmentBiz.GetFulfilment(ctx, fulfilment.GetId())
+		require.NoError(t, err)
+		require.Equal(t, commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED, delivered.GetStatus())
+		require.True(t, shippedAt.Equal(delivered.GetShippedAt().AsTime()))
+
+		events, err := biz.shippingBiz.ListTrackingEvents(ctx, fulfilment.GetId())
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+
+		updatedOrder, err := biz.orderBiz.GetOrder(ctx, order.GetId())
+		require.NoError(t, err)
+		require.Equal(t, commercev1.OrderStatus_ORDER_STATUS_FULFILLED, updatedOrder.GetStatus())
+	})
+}
+
+func (bts *BusinessTestSuite) TestUpdateFulfilment_PersistsShippedAt() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines:  []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 1}},
+		})
+		require.NoError(t, err)
+
+		fulfilment, err := biz.fulfilmentBiz.CreateFulfilment(ctx, &commercev1.CreateFulfilmentRequest{
+			OrderId: order.GetId(),
+			Lines:   []*commercev1.FulfilmentLine{{OrderLineId: order.GetLines()[0].GetId(), Quantity: 1}},
+		})
+		require.NoError(t, err)
+		require.Nil(t, fulfilment.GetShippedAt())
+
+		shippedAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+		updated, err := biz.fulfilmentBiz.UpdateFulfilment(ctx, &commercev1.UpdateFulfilmentRequest{
+			Id:        fulfilment.GetId(),
+			Status:    commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED,
+			ShippedAt: timestamppb.New(shippedAt),
+			UpdateMask: &fieldmaskpb.FieldMask{
+				Paths: []string{"status", "shipped_at"},
+			},
+		})
+		require.NoError(t, err)
+		require.True(t, shippedAt.Equal(updated.GetShippedAt().AsTime()))
+	})
+}
+
+func (bts *BusinessTestSuite) TestDocuments_PickListAndPackingSlip() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		require.NoError(t, biz.catalogBiz.SetVariantLocation(ctx, variant.GetId(), "A-01"))
+
+		var fulfilmentIDs []string
+		for range 2 {
+			order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+				ShopId: shop.GetId(),
+				Lines:  []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 2}},
+			})
+			require.NoError(t, err)
+
+			fulfilment, err := biz.fulfilmentBiz.CreateFulfilment(ctx, &commercev1.CreateFulfilmentRequest{
+				OrderId: order.GetId(),
+				Lines:   []*commercev1.FulfilmentLine{{OrderLineId: order.GetLines()[0].GetId(), Quantity: 2}},
+			})
+			require.NoError(t, err)
+			fulfilmentIDs = append(fulfilmentIDs, fulfilment.GetId())
+		}
+
+		list, err := biz.documentBiz.PickList(ctx, shop.GetId(), nil)
+		require.NoError(t, err)
+		require.Len(t, list.OrderNumbers, 2)
+		require.Len(t, list.Items, 1)
+		require.Equal(t, "A-01", list.Items[0].Location)
+		require.Equal(t, int64(4), list.Items[0].Quantity)
+
+		// Shipped fulfilments have nothing left to pick.
+		_, err = biz.fulfilmentBiz.UpdateFulfilment(ctx, &commercev1.UpdateFulfilmentRequest{
+			Id:         fulfilmentIDs[0],
+			Status:     commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"status"}},
+		})
+		require.NoError(t, err)
+		_, err = biz.documentBiz.PickList(ctx, shop.GetId(), fulfilmentIDs)
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		slip, err := biz.documentBiz.PackingSlip(ctx, fulfilmentIDs[1])
+		require.NoError(t, err)
+		require.Len(t, slip.Lines, 1)
+		require.Equal(t, int64(2), slip.Lines[0].Quantity)
+	})
+}
+
+func (bts *BusinessTestSuite) TestCancelledFulfilment_ReleasesQuantity() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines:  []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 4}},
+		})
+		require.NoError(t, err)
+		orderLineID := order.GetLines()[0].GetId()
+
+		first, err := biz.fulfilmentBiz.CreateFulfilment(ctx, &commercev1.CreateFulfilmentRequest{
+			OrderId: order.GetId(),
+			Lines:   []*commercev1.FulfilmentLine{{OrderLineId: orderLineID, Quantity: 4}},
+		})
+		require.NoError(t, err)
+
+		_, err = biz.fulfilmentBiz.UpdateFulfilment(ctx, &commercev1.UpdateFulfilmentRequest{
+			Id:         first.GetId(),
+			Status:     commercev1.FulfilmentStatus_FULFILMENT_STATUS_CANCELLED,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"status"}},
+		})
+		require.NoError(t, err)
+
+		// Cancelled fulfilments are final.
+		_, err = biz.fulfilmentBiz.UpdateFulfilment(ctx, &commercev1.UpdateFulfilmentRequest{
+			Id:         first.GetId(),
+			Status:     commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"status"}},
+		})
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		// The cancelled quantity can be fulfilled again.
+		second, err := biz.fulfilmentBiz.CreateFulfilment(ctx, &commercev1.CreateFulfilmentRequest{
+			OrderId: order.GetId(),
+			Lines:   []*commercev1.FulfilmentLine{{OrderLineId: orderLineID, Quantity: 3}},
+		})
+		require.NoError(t, err)
+
+		_, err = biz.fulfilmentBiz.UpdateFulfilment(ctx, &commercev1.UpdateFulfilmentRequest{
+			Id:         second.GetId(),
+			Status:     commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"status"}},
+		})
+		require.NoError(t, err)
+
+		partial, err := biz.orderBiz.GetOrder(ctx, order.GetId())
+		require.NoError(t, err)
+		require.Equal(t, commercev1.OrderStatus_ORDER_STATUS_CONFIRMED, partial.GetStatus())
+		require.Equal(t, commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED, partial.GetFulfilmentStatus())
+	})
+}
+
+func (bts *BusinessTestSuite) TestCreateFulfilment_ExceedsQuantity() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines: []*commercev1.CreateOrderLine{
+				{
+					VariantId: variant.GetId(),
+					Quantity:  5,
+				},
+			},
+		})
+		require.NoError(t, err)
+
+		orderLineID := order.GetLines()[0].GetId()
+
+		// Try to fulfil more than ordered
+		_, err = biz.fulfilmentBiz.CreateFulfilment(ctx, &commercev1.CreateFulfilmentRequest{
+			OrderId: order.GetId(),
+			Lines: []*commercev1.FulfilmentLine{
+				{
+					OrderLineId: orderLineID,
+					Quantity:    10,
+				},
+				{
+					OrderLineId: "missing-line",
+					Quantity:    1,
+				},
+			},
+		})
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+		// Every refused line reaches the client as a detail.
+		problems, err := business.LineProblems(errorutil.CleanErr(data.ErrorConvertToAPI(err)))
+		require.NoError(t, err)
+		require.Len(t, problems, 2)
+		require.Equal(t, business.FulfilmentOverRemaining, problems[0].Reason)
+		require.Equal(t, orderLineID, problems[0].OrderLineID)
+		require.Equal(t, variant.GetId(), problems[0].VariantID)
+		require.EqualValues(t, 10, problems[0].Requested)
+		require.EqualValues(t, 5, problems[0].Available)
+		require.Equal(t, 1, problems[1].Line)
+		require.Equal(t, business.FulfilmentLineNotFound, problems[1].Reason)
+	})
+}
+
+func (bts *BusinessTestSuite) TestUpdateFulfilment() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines: []*commercev1.CreateOrderLine{
+				{
+					VariantId: variant.GetId(),
+					Quantity:  5,
+				},
+			},
+		})
+		require.NoError(t, err)
+
+		orderLineID := order.GetLines()[0].GetId()
+
+		fulfilment, err := biz.fulfilmentBiz.CreateFulfilment(ctx, &commercev1.CreateFulfilmentRequest{
+			OrderId: order.GetId(),
+			Lines: []*commercev1.FulfilmentLine{
+				{
+					OrderLineId: orderLineID,
+					Quantity:    5,
+				},
+			},
+		})
+		require.NoError(t, err)
+
+		updated, err := biz.fulfilmentBiz.UpdateFulfilment(ctx, &commercev1.UpdateFulfilmentRequest{
 			Id:             fulfilment.GetId(),
 			Status:         commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED,
 			Carrier:        "FedEx",
 			TrackingNumber: "TRACK-12345",
 		})
 		require.NoError(t, err)
-		require.Equal(t, commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED, updated.GetStatus())
-		require.Equal(t, "FedEx", updated.GetCarrier())
-		require.Equal(t, "TRACK-12345", updated.GetTrackingNumber())
+		require.Equal(t, commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED, updated.GetStatus())
+		require.Equal(t, "FedEx", updated.GetCarrier())
+		require.Equal(t, "TRACK-12345", updated.GetTrackingNumber())
+	})
+}
+
+// --- Authorization Business Tests ---
+
+func (bts *BusinessTestSuite) TestCreateProduct_FulfilmentType() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		physical, err := biz.catalogBiz.CreateProduct(ctx, &commercev1.CreateProductRequest{
+			ShopId: shop.GetId(),
+			Name:   "Mug",
+		})
+		require.NoError(t, err)
+		require.Equal(t, commercev1.FulfilmentType_FULFILMENT_TYPE_PHYSICAL, physical.GetFulfilmentType())
+
+		digital, err := biz.catalogBiz.CreateProduct(ctx, &commercev1.CreateProductRequest{
+			ShopId:     shop.GetId(),
+			Name:       "E-book",
+			Attributes: map[string]string{business.FulfilmentTypeAttribute: "digital", "format": "epub"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, commercev1.FulfilmentType_FULFILMENT_TYPE_DIGITAL, digital.GetFulfilmentType())
+		require.Equal(t, map[string]string{"format": "epub"}, digital.GetAttributes())
+
+		_, err = biz.catalogBiz.CreateProduct(ctx, &commercev1.CreateProductRequest{
+			ShopId:     shop.GetId(),
+			Name:       "Teleporter",
+			Attributes: map[string]string{business.FulfilmentTypeAttribute: "teleport"},
+		})
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	})
+}
+
+func (bts *BusinessTestSuite) TestCapturePayment_DeliversDigitalLines() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, physicalVariant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		ebook, err := biz.catalogBiz.CreateProduct(ctx, &commercev1.CreateProductRequest{
+			ShopId:     shop.GetId(),
+			Name:       "Field Guide",
+			Attributes: map[string]string{business.FulfilmentTypeAttribute: "digital"},
+		})
+		require.NoError(t, err)
+		ebookVariant, err := biz.catalogBiz.CreateProductVariant(ctx, &commercev1.CreateProductVariantRequest{
+			ProductId:     ebook.GetId(),
+			Sku:           "EBOOK-" + util.RandomAlphaNumericString(6),
+			Name:          "EPUB",
+			Price:         &money.Money{CurrencyCode: "USD", Units: 9},
+			StockQuantity: 1000,
+		})
+		require.NoError(t, err)
+
+		// Physical variants cannot carry files.
+		_, err = biz.digitalBiz.SetDigitalAsset(ctx, physicalVariant.GetId(), business.DigitalAssetInput{FileID: "file-1"})
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		_, err = biz.digitalBiz.SetDigitalAsset(ctx, ebookVariant.GetId(), business.DigitalAssetInput{
+			FileID:       "file-ebook",
+			MaxDownloads: 1,
+		})
+		require.NoError(t, err)
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId:    shop.GetId(),
+			ProfileId: "profile-digital",
+			Lines: []*commercev1.CreateOrderLine{
+				{VariantId: ebookVariant.GetId(), Quantity: 2},
+				{VariantId: physicalVariant.GetId(), Quantity: 1},
+			},
+		})
+		require.NoError(t, err)
+
+		// Nothing is delivered before payment.
+		_, err = biz.digitalBiz.FulfilOrder(ctx, order.GetId())
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		paid, err := biz.paymentBiz.CapturePayment(ctx, order.GetId())
+		require.NoError(t, err)
+		require.Equal(t, commercev1.PaymentStatus_PAYMENT_STATUS_PAID, paid.Order.GetPaymentStatus())
+		// The physical line is still outstanding.
+		require.Equal(t, commercev1.OrderStatus_ORDER_STATUS_CONFIRMED, paid.Order.GetStatus())
+		// The first capture hands back the tokens it delivered.
+		require.Len(t, paid.Downloads, 1)
+		require.Equal(t, "file-ebook", paid.Downloads[0].FileID)
+
+		entitlements, err := biz.digitalBiz.ListEntitlements(ctx, order.GetId())
+		require.NoError(t, err)
+		require.Len(t, entitlements, 1)
+		entitlement := entitlements[0]
+		require.Equal(t, "file-ebook", entitlement.FileID)
+		require.Equal(t, "profile-digital", entitlement.ProfileID)
+		require.Equal(t, int32(2), entitlement.MaxDownloads)
+		require.True(t, entitlement.ExpiresAt.After(time.Now().Add(29*24*time.Hour)))
+
+		// Capturing again neither duplicates entitlements nor fulfilments.
+		again, err := biz.paymentBiz.CapturePayment(ctx, order.GetId())
+		require.NoError(t, err)
+		require.Empty(t, again.Downloads)
+		entitlements, err = biz.digitalBiz.ListEntitlements(ctx, order.GetId())
+		require.NoError(t, err)
+		require.Len(t, entitlements, 1)
+
+		token, err := biz.digitalBiz.IssueDownloadToken(ctx, entitlement.GetID())
+		require.NoError(t, err)
+
+		for range 2 {
+			redeemed, redeemErr := biz.digitalBiz.RedeemDownloadToken(ctx, token.Token)
+			require.NoError(t, redeemErr)
+			require.Equal(t, "file-ebook", redeemed.FileID)
+		}
+		_, err = biz.digitalBiz.RedeemDownloadToken(ctx, token.Token)
+		require.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+
+		// Reissuing revokes the previous token.
+		_, err = biz.digitalBiz.IssueDownloadToken(ctx, entitlement.GetID())
+		require.NoError(t, err)
+		_, err = biz.digitalBiz.RedeemDownloadToken(ctx, token.Token)
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+	})
+}
+
+func (bts *BusinessTestSuite) TestCapturePayment_DigitalOnlyOrderDelivered() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		voucher, err := biz.catalogBiz.CreateProduct(ctx, &commercev1.CreateProductRequest{
+			ShopId:     shop.GetId(),
+			Name:       "Gift Voucher",
+			Attributes: map[string]string{business.FulfilmentTypeAttribute: "digital"},
+		})
+		require.NoError(t, err)
+		variant, err := biz.catalogBiz.CreateProductVariant(ctx, &commercev1.CreateProductVariantRequest{
+			ProductId:     voucher.GetId(),
+			Sku:           "VOUCHER-" + util.RandomAlphaNumericString(6),
+			Name:          "50",
+			Price:         &money.Money{CurrencyCode: "USD", Units: 50},
+			StockQuantity: 10,
+		})
+		require.NoError(t, err)
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId:    shop.GetId(),
+			ProfileId: "profile-voucher",
+			Lines:     []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 1}},
+		})
+		require.NoError(t, err)
+
+		// Without a file the capture is refused and the order stays unpaid.
+		_, err = biz.paymentBiz.CapturePayment(ctx, order.GetId())
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+		unpaid, err := biz.orderBiz.GetOrder(ctx, order.GetId())
+		require.NoError(t, err)
+		require.Equal(t, commercev1.PaymentStatus_PAYMENT_STATUS_PENDING, unpaid.GetPaymentStatus())
+
+		_, err = biz.digitalBiz.SetDigitalAsset(ctx, variant.GetId(), business.DigitalAssetInput{FileID: "file-voucher"})
+		require.NoError(t, err)
+
+		delivered, err := biz.paymentBiz.CapturePayment(ctx, order.GetId())
+		require.NoError(t, err)
+		require.Equal(t, commercev1.OrderStatus_ORDER_STATUS_FULFILLED, delivered.Order.GetStatus())
+		require.Equal(t, commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED, delivered.Order.GetFulfilmentStatus())
+	})
+}
+
+func (bts *BusinessTestSuite) TestPayments_InvoiceAndCreditNote() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		extra, err := structpb.NewStruct(map[string]any{
+			business.TaxNameKey: "VAT",
+			business.TaxRateKey: 16,
+		})
+		require.NoError(t, err)
+		_, err = biz.shopBiz.UpdateShop(ctx, &commercev1.UpdateShopRequest{Id: shop.GetId(), Extra: extra})
+		require.NoError(t, err)
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId:    shop.GetId(),
+			ProfileId: "profile-invoice",
+			Lines:     []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 2}},
+		})
+		require.NoError(t, err)
+
+		// An unpaid order cannot be refunded.
+		_, err = biz.paymentBiz.RefundPayment(ctx, order.GetId())
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		_, err = biz.paymentBiz.CapturePayment(ctx, order.GetId())
+		require.NoError(t, err)
+		// Capturing again does not issue a second invoice.
+		_, err = biz.paymentBiz.CapturePayment(ctx, order.GetId())
+		require.NoError(t, err)
+
+		invoices, err := biz.invoiceBiz.ListOrderInvoices(ctx, order.GetId())
+		require.NoError(t, err)
+		require.Len(t, invoices, 1)
+
+		invoice, err := biz.invoiceBiz.GetInvoice(ctx, invoices[0].GetID())
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("INV-%d-000001", invoice.IssuedAt.Year()), invoice.Number)
+		require.Equal(t, order.GetOrderNumber(), invoice.OrderNumber)
+		require.Equal(t, int64(21), invoice.TotalUnits)
+		require.Len(t, invoice.Lines, 1)
+		require.Equal(t, int64(2), invoice.Lines[0].Quantity)
+		require.Equal(t, models.InvoiceTaxLines{
+			{Name: "VAT", RateBasisPoints: 1600, Inclusive: true, Units: 2, Nanos: 900000000},
+		}, invoice.TaxLines)
+
+		refunded, err := biz.paymentBiz.RefundPayment(ctx, order.GetId())
+		require.NoError(t, err)
+		require.Equal(t, commercev1.PaymentStatus_PAYMENT_STATUS_REFUNDED, refunded.GetPaymentStatus())
+		// A retried refund finds the credit note already issued.
+		_, err = biz.paymentBiz.RefundPayment(ctx, order.GetId())
+		require.NoError(t, err)
+
+		invoices, err = biz.invoiceBiz.ListOrderInvoices(ctx, order.GetId())
+		require.NoError(t, err)
+		require.Len(t, invoices, 2)
+
+		var creditNote *models.Invoice
+		for _, inv := range invoices {
+			if inv.Kind == models.InvoiceKindCreditNote {
+				creditNote = inv
+			}
+		}
+		require.NotNil(t, creditNote)
+		require.Equal(t, fmt.Sprintf("CN-%d-000001", creditNote.IssuedAt.Year()), creditNote.Number)
+		require.Equal(t, invoice.GetID(), creditNote.CreditedInvoiceID)
+		require.Equal(t, invoice.TotalUnits, creditNote.TotalUnits)
+	})
+}
+
+// webhookReceiver is an httptest server standing in for a merchant's ERP. It
+// answers with the status in status and keeps the requests it verified.
+type webhookReceiver struct {
+	*httptest.Server
+	secret   atomic.Value
+	status   atomic.Int32
+	mu       sync.Mutex
+	received []business.WebhookEvent
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	receiver := &webhookReceiver{}
+	receiver.status.Store(http.StatusOK)
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		secret, _ := receiver.secret.Load().(string)
+		err = business.VerifyWebhookSignature(secret, r.Header.Get(business.WebhookSignatureHeader), body,
+			time.Now(), time.Minute)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		status := int(receiver.status.Load())
+		if status == http.StatusOK {
+			var event business.WebhookEvent
+			if json.Unmarshal(body, &event) == nil && event.Type == r.Header.Get(business.WebhookEventHeader) {
+				receiver.mu.Lock()
+				receiver.received = append(receiver.received, event)
+				receiver.mu.Unlock()
+			}
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+func (wr *webhookReceiver) events() []business.WebhookEvent {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	return append([]business.WebhookEvent(nil), wr.received...)
+}
+
+func (bts *BusinessTestSuite) TestWebhooks_SignedDeliveryOfSubscribedEvents() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+		receiver := newWebhookReceiver(t)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		endpoint, err := biz.webhookBiz.CreateEndpoint(ctx, shop.GetId(), receiver.URL,
+			[]string{business.EventOrderPaid, business.EventOrderCreated})
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(endpoint.Secret, "whsec_"))
+		receiver.secret.Store(endpoint.Secret)
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines:  []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 1}},
+		})
+		require.NoError(t, err)
+		_, err = biz.paymentBiz.CapturePayment(ctx, order.GetId())
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool { return len(receiver.events()) == 2 }, 10*time.Second, 50*time.Millisecond)
+
+		types := []string{}
+		for _, event := range receiver.events() {
+			require.Equal(t, shop.GetId(), event.ShopID)
+			var delivered commercev1.Order
+			require.NoError(t, protojson.Unmarshal(event.Data, &delivered))
+			require.Equal(t, order.GetId(), delivered.GetId())
+			types = append(types, event.Type)
+		}
+		require.ElementsMatch(t, []string{business.EventOrderCreated, business.EventOrderPaid}, types)
+
+		deliveries, err := biz.webhookBiz.ListDeliveries(ctx, endpoint.GetID(), models.WebhookDeliveryDelivered)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 2)
+
+		attempts, err := biz.webhookBiz.ListDeliveryAttempts(ctx, deliveries[0].GetID())
+		require.NoError(t, err)
+		require.Len(t, attempts, 1)
+		require.Equal(t, int32(http.StatusOK), attempts[0].StatusCode)
+	})
+}
+
+func (bts *BusinessTestSuite) TestWebhooks_RetriesDeadLetterAndRedeliver() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+		receiver := newWebhookReceiver(t)
+		receiver.status.Store(http.StatusServiceUnavailable)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		endpoint, err := biz.webhookBiz.CreateEndpoint(ctx, shop.GetId(), receiver.URL, nil)
+		require.NoError(t, err)
+		require.Equal(t, models.StringArray{business.EventAll}, endpoint.EventTypes)
+		receiver.secret.Store(endpoint.Secret)
+
+		_, err = biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines:  []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 1}},
+		})
+		require.NoError(t, err)
+
+		// Every failure is due again at once under the test policy, so each
+		// sweep makes another attempt until the delivery runs out of them.
+		require.Eventually(t, func() bool {
+			if _, retryErr := biz.webhookBiz.RetryDue(ctx, time.Now()); retryErr != nil {
+				return false
+			}
+			dead, listErr := biz.webhookBiz.ListDeliveries(ctx, endpoint.GetID(), models.WebhookDeliveryDead)
+			return listErr == nil && len(dead) == 1
+		}, 10*time.Second, 50*time.Millisecond)
+
+		dead, err := biz.webhookBiz.ListDeliveries(ctx, endpoint.GetID(), models.WebhookDeliveryDead)
+		require.NoError(t, err)
+		delivery := dead[0]
+		require.Equal(t, testWebhookPolicy.MaxAttempts, delivery.Attempts)
+		require.Equal(t, int32(http.StatusServiceUnavailable), delivery.LastStatusCode)
+		require.Empty(t, receiver.events())
+
+		// A dead delivery stays dead until it is redelivered by hand.
+		_, err = biz.webhookBiz.RetryDue(ctx, time.Now())
+		require.NoError(t, err)
+
+		receiver.status.Store(http.StatusOK)
+		_, err = biz.webhookBiz.Redeliver(ctx, delivery.GetID())
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			got, getErr := biz.webhookBiz.GetDelivery(ctx, delivery.GetID())
+			return getErr == nil && got.Status == models.WebhookDeliveryDelivered
+		}, 10*time.Second, 50*time.Millisecond)
+		require.Len(t, receiver.events(), 1)
+		require.Equal(t, business.EventOrderCreated, receiver.events()[0].Type)
+
+		attempts, err := biz.webhookBiz.ListDeliveryAttempts(ctx, delivery.GetID())
+		require.NoError(t, err)
+		require.Len(t, attempts, int(testWebhookPolicy.MaxAttempts)+1)
+		require.Equal(t, int32(http.StatusOK), attempts[len(attempts)-1].StatusCode)
+
+		// Disabled endpoints are queued nothing new.
+		_, err = biz.webhookBiz.SetEndpointDisabled(ctx, endpoint.GetID(), true)
+		require.NoError(t, err)
+		_, err = biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines:  []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 1}},
+		})
+		require.NoError(t, err)
+		deliveries, err := biz.webhookBiz.ListDeliveries(ctx, endpoint.GetID(), 0)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+	})
+}
+
+func (bts *BusinessTestSuite) TestWebhooks_CreateEndpointValidation() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+		shop := bts.createTestShop(ctx, biz)
+
+		_, err := biz.webhookBiz.CreateEndpoint(ctx, shop.GetId(), "http://erp.example.com/hooks", nil)
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+		_, err = biz.webhookBiz.CreateEndpoint(ctx, shop.GetId(), "https://erp.example.com/hooks",
+			[]string{"order.shipped"})
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+		endpoint, err := biz.webhookBiz.CreateEndpoint(ctx, shop.GetId(), "https://erp.example.com/hooks",
+			[]string{business.EventOrderPaid, business.EventOrderCreated, business.EventOrderPaid})
+		require.NoError(t, err)
+		require.Equal(t, models.StringArray{business.EventOrderCreated, business.EventOrderPaid}, endpoint.EventTypes)
+
+		endpoints, err := biz.webhookBiz.ListEndpoints(ctx, shop.GetId())
+		require.NoError(t, err)
+		require.Len(t, endpoints, 1)
+
+		require.NoError(t, biz.webhookBiz.DeleteEndpoint(ctx, endpoint.GetID()))
+		endpoints, err = biz.webhookBiz.ListEndpoints(ctx, shop.GetId())
+		require.NoError(t, err)
+		require.Empty(t, endpoints)
+	})
+}
+
+// awaitImport waits for the catalog import to finish and returns it.
+func (bts *BusinessTestSuite) awaitImport(ctx context.Context, biz allBiz, id string) *models.CatalogImport {
+	t := bts.T()
+	var job *models.CatalogImport
+	require.Eventually(t, func() bool {
+		current, err := biz.importBiz.GetImport(ctx, id)
+		if err != nil {
+			return false
+		}
+		job = current
+		return current.FinishedAt != nil
+	}, 10*time.Second, 50*time.Millisecond)
+	return job
+}
+
+func (bts *BusinessTestSuite) TestCatalogImport_CreatesAndUpsertsBySKU() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+		shop := bts.createTestShop(ctx, biz)
+		existing, existingVariant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		source := "product,description,attributes,sku,name,price,currency,stock,media_ids\n" +
+			"Linen Shirt,Breathable linen,material=linen,SHIRT-S,Small,25.00,usd,10,m1|m2\n" +
+			"Linen Shirt,,,SHIRT-M,Medium,25,USD,,\n" +
+			existing.GetName() + ",,,SHIRT-L,Large,26.5,USD,4,\n" +
+			"Renamed Elsewhere,,," + existingVariant.GetSku() + ",,12.75,USD,7,\n"
+
+		job, err := biz.importBiz.StartImport(ctx, shop.GetId(), business.ImportFormatCSV, []byte(source))
+		require.NoError(t, err)
+		require.Equal(t, models.ImportStatusQueued, job.Status)
+		require.EqualValues(t, 4, job.TotalRows)
+
+		job = bts.awaitImport(ctx, biz, job.GetID())
+		require.Equal(t, models.ImportStatusCompleted, job.Status, job.Message)
+		require.Empty(t, job.Errors)
+		require.EqualValues(t, 4, job.ProcessedRows)
+		require.EqualValues(t, 1, job.CreatedProducts)
+		require.EqualValues(t, 3, job.CreatedVariants)
+		require.EqualValues(t, 1, job.UpdatedVariants)
+
+		shirt, err := biz.catalogBiz.GetProductBySlug(ctx, shop.GetId(), "linen-shirt")
+		require.NoError(t, err)
+		require.Equal(t, "Breathable linen", shirt.Product.GetDescription())
+		require.Equal(t, "linen", shirt.Product.GetAttributes()["material"])
+		variants, err := biz.catalogBiz.ListProductVariants(ctx, shirt.Product.GetId())
+		require.NoError(t, err)
+		require.Len(t, variants, 2)
+		for _, variant := range variants {
+			require.EqualValues(t, 25, variant.GetPrice().GetUnits())
+			if variant.GetSku() == "SHIRT-S" {
+				require.EqualValues(t, 10, variant.GetStockQuantity())
+				require.Equal(t, []string{"m1", "m2"}, variant.GetMediaIds())
+			}
+		}
+
+		// New SKUs join the product of the same name; known SKUs are updated
+		// in place, whatever product name their row gives.
+		variants, err = biz.catalogBiz.ListProductVariants(ctx, existing.GetId())
+		require.NoError(t, err)
+		require.Len(t, variants, 2)
+		for _, variant := range variants {
+			switch variant.GetSku() {
+			case "SHIRT-L":
+				require.EqualValues(t, 26, variant.GetPrice().GetUnits())
+				require.EqualValues(t, 500000000, variant.GetPrice().GetNanos())
+			case existingVariant.GetSku():
+				require.EqualValues(t, 12, variant.GetPrice().GetUnits())
+				require.EqualValues(t, 750000000, variant.GetPrice().GetNanos())
+				require.EqualValues(t, 7, variant.GetStockQuantity())
+				require.Equal(t, "Test Variant", variant.GetName())
+			}
+		}
+
+		// Importing the same file again only updates.
+		job, err = biz.importBiz.StartImport(ctx, shop.GetId(), business.ImportFormatCSV, []byte(source))
+		require.NoError(t, err)
+		job = bts.awaitImport(ctx, biz, job.GetID())
+		require.Equal(t, models.ImportStatusCompleted, job.Status, job.Message)
+		require.Zero(t, job.CreatedProducts)
+		require.Zero(t, job.CreatedVariants)
+		require.EqualValues(t, 4, job.UpdatedVariants)
+	})
+}
+
+func (bts *BusinessTestSuite) TestCatalogImport_ReportsRowsWithoutPartialProducts() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+		shop := bts.createTestShop(ctx, biz)
+
+		source := `[
+			{"product": "Mug", "sku": "MUG-RED", "price": "8.00", "currency": "KES", "stock": 3},
+			{"product": "Mug", "sku": "MUG-BLUE", "price": "eight", "currency": "KES"},
+			{"product": "Plate", "sku": "PLATE-1", "price": 12, "currency": "KES", "media_ids": ["p1"]},
+			{"product": "Bowl", "sku": "PLATE-1", "price": 9, "currency": "KES"},
+			{"product": "Cup", "sku": "CUP-1", "price": "4.5", "currency": "shillings"}
+		]`
+
+		job, err := biz.importBiz.StartImport(ctx, shop.GetId(), business.ImportFormatJSON, []byte(source))
+		require.NoError(t, err)
+		job = bts.awaitImport(ctx, biz, job.GetID())
+		require.Equal(t, models.ImportStatusCompleted, job.Status, job.Message)
+		require.EqualValues(t, 5, job.ProcessedRows)
+		require.EqualValues(t, 1, job.CreatedProducts)
+		require.EqualValues(t, 1, job.CreatedVariants)
+		require.EqualValues(t, 4, job.FailedRows)
+
+		rows := map[int]string{}
+		for _, rowErr := range job.Errors {
+			rows[rowErr.Row] = rowErr.Message
+		}
+		require.Contains(t, rows[1], "another row")
+		require.Contains(t, rows[2], "price")
+		require.Contains(t, rows[4], "repeated from row 3")
+		require.Contains(t, rows[5], "currency")
+		require.NotContains(t, rows, 3)
+
+		// The mug had a bad row, so neither of its variants was created.
+		_, err = biz.catalogBiz.GetProductBySlug(ctx, shop.GetId(), "mug")
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+		plate, err := biz.catalogBiz.GetProductBySlug(ctx, shop.GetId(), "plate")
+		require.NoError(t, err)
+		require.Equal(t, "Plate", plate.Product.GetName())
+	})
+}
+
+func (bts *BusinessTestSuite) TestCatalogImport_RejectsUnreadableFiles() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+		shop := bts.createTestShop(ctx, biz)
+
+		_, err := biz.importBiz.StartImport(ctx, shop.GetId(), business.ImportFormatCSV, []byte("product,sku\nMug,M1\n"))
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+		_, err = biz.importBiz.StartImport(ctx, shop.GetId(), "xlsx", []byte("anything"))
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+		_, err = biz.importBiz.StartImport(ctx, util.IDString(), business.ImportFormatCSV,
+			[]byte("product,sku,price,currency\nMug,M1,1,USD\n"))
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+	})
+}
+
+func (bts *BusinessTestSuite) TestExports_CatalogAndOrders() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		product, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		empty, err := biz.catalogBiz.CreateProduct(ctx, &commercev1.CreateProductRequest{
+			ShopId: shop.GetId(),
+			Name:   "Coming Soon",
+		})
+		require.NoError(t, err)
+
+		var out bytes.Buffer
+		require.NoError(t, biz.exportBiz.ExportCatalog(ctx, shop.GetId(), business.ExportFormatCSV, &out))
+		records, err := csv.NewReader(&out).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		require.Equal(t, "product_id", records[0][0])
+		rows := map[string][]string{}
+		for _, record := range records[1:] {
+			rows[record[0]] = record
+		}
+		require.Equal(t, []string{
+			product.GetId(), product.GetName(), business.Slugify(product.GetName()), "active", "physical",
+			variant.GetId(), variant.GetSku(), "Test Variant", "active", "standard",
+			"USD", "10.50", "100", "",
+		}, rows[product.GetId()])
+		require.Equal(t, "Coming Soon", rows[empty.GetId()][1])
+		require.Empty(t, rows[empty.GetId()][5])
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId:    shop.GetId(),
+			ProfileId: "profile-export",
+			Lines:     []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 2}},
+		})
+		require.NoError(t, err)
+
+		exportOrders := func(filter repository.OrderExportFilter) []map[string]any {
+			filter.ShopID = shop.GetId()
+			var buf bytes.Buffer
+			require.NoError(t, biz.exportBiz.ExportOrders(ctx, filter, business.ExportFormatJSONL, &buf))
+			var lines []map[string]any
+			for line := range strings.Lines(buf.String()) {
+				var row map[string]any
+				require.NoError(t, json.Unmarshal([]byte(line), &row))
+				lines = append(lines, row)
+			}
+			return lines
+		}
+
+		lines := exportOrders(repository.OrderExportFilter{
+			From:     time.Now().Add(-time.Hour),
+			To:       time.Now().Add(time.Hour),
+			Statuses: []int32{int32(commercev1.OrderStatus_ORDER_STATUS_CONFIRMED)},
+		})
+		require.Len(t, lines, 1)
+		require.Equal(t, order.GetId(), lines[0]["order_id"])
+		require.Equal(t, "confirmed", lines[0]["status"])
+		require.Equal(t, "pending", lines[0]["payment_status"])
+		require.Equal(t, "21.00", lines[0]["total"])
+		require.Equal(t, variant.GetSku(), lines[0]["sku"])
+		require.EqualValues(t, 2, lines[0]["quantity"])
+		require.Equal(t, "10.50", lines[0]["unit_price"])
+
+		require.Empty(t, exportOrders(repository.OrderExportFilter{
+			Statuses: []int32{int32(commercev1.OrderStatus_ORDER_STATUS_CANCELLED)},
+		}))
+		require.Empty(t, exportOrders(repository.OrderExportFilter{To: time.Now().Add(-time.Hour)}))
+
+		err = biz.exportBiz.ExportOrders(ctx, repository.OrderExportFilter{
+			ShopID: shop.GetId(), From: time.Now(), To: time.Now().Add(-time.Hour),
+		}, business.ExportFormatCSV, &out)
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	})
+}
+
+func (bts *BusinessTestSuite) TestAnalytics_RefreshAndReport() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		product, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		_, other := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		var orders []*commercev1.Order
+		for _, lines := range [][]*commercev1.CreateOrderLine{
+			{{VariantId: variant.GetId(), Quantity: 2}},
+			{{VariantId: variant.GetId(), Quantity: 1}, {VariantId: other.GetId(), Quantity: 1}},
+			{{VariantId: variant.GetId(), Quantity: 1}},
+		} {
+			order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+				ShopId: shop.GetId(), ProfileId: "profile-analytics", Lines: lines,
+			})
+			require.NoError(t, err)
+			orders = append(orders, order)
+		}
+		_, err := biz.paymentBiz.CapturePayment(ctx, orders[2].GetId())
+		require.NoError(t, err)
+		_, err = biz.paymentBiz.RefundPayment(ctx, orders[2].GetId())
+		require.NoError(t, err)
+
+		// Reports read the rollups, which are empty until refreshed.
+		periods, err := biz.analyticsBiz.SalesReport(ctx, shop.GetId(), business.ReportPeriodDay, time.Time{}, time.Time{})
+		require.NoError(t, err)
+		require.Empty(t, periods)
+
+		refreshed, err := biz.analyticsBiz.RefreshRollups(ctx, time.Now())
+		require.NoError(t, err)
+		require.Positive(t, refreshed)
+
+		periods, err = biz.analyticsBiz.SalesReport(ctx, shop.GetId(), business.ReportPeriodMonth, time.Time{}, time.Time{})
+		require.NoError(t, err)
+		require.Len(t, periods, 1)
+		sales := periods[0]
+		require.Equal(t, "USD", sales.Currency)
+		require.EqualValues(t, 3, sales.OrderCount)
+		require.EqualValues(t, 1, sales.RefundedCount)
+		require.EqualValues(t, 5, sales.UnitsSold)
+		require.Equal(t, "52.50", business.DecimalAmount(sales.Gross.GetUnits(), sales.Gross.GetNanos()))
+		require.Equal(t, "10.50", business.DecimalAmount(sales.Refunded.GetUnits(), sales.Refunded.GetNanos()))
+		require.Equal(t, "42.00", business.DecimalAmount(sales.Net.GetUnits(), sales.Net.GetNanos()))
+		require.Equal(t, "17.50", business.DecimalAmount(
+			sales.AverageOrderValue.GetUnits(), sales.AverageOrderValue.GetNanos()))
+		require.InDelta(t, 1.0/3, sales.RefundRate, 1e-9)
+
+		top, err := biz.analyticsBiz.TopProducts(ctx, shop.GetId(), time.Time{}, time.Time{}, 10)
+		require.NoError(t, err)
+		require.Len(t, top, 2)
+		require.Equal(t, product.GetId(), top[0].ProductID)
+		require.EqualValues(t, 4, top[0].UnitsSold)
+		require.Equal(t, "42.00", business.DecimalAmount(top[0].RevenueUnits, int32(top[0].RevenueNanos)))
+
+		variants, err := biz.analyticsBiz.VariantSales(ctx, shop.GetId(), time.Time{}, time.Time{}, 1, 1)
+		require.NoError(t, err)
+		require.Len(t, variants, 1)
+		require.Equal(t, other.GetId(), variants[0].ProductVariantID)
+
+		// A refresh with nothing changed since the last leaves the rollups be.
+		again, err := biz.analyticsBiz.RefreshRollups(ctx, time.Now())
+		require.NoError(t, err)
+		require.LessOrEqual(t, again, refreshed)
+
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		_, err = biz.analyticsBiz.SalesReport(ctx, shop.GetId(), business.ReportPeriodDay, today, today.AddDate(0, 0, -1))
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	})
+}
+
+func (bts *BusinessTestSuite) TestListMyOrders_FiltersByShopAndStatus() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		profileID := "profile-" + util.RandomAlphaNumericString(8)
+		var shopIDs []string
+		for range 2 {
+			shop := bts.createTestShop(ctx, biz)
+			_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+			for _, owner := range []string{profileID, "someone-else"} {
+				_, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+					ShopId:    shop.GetId(),
+					ProfileId: owner,
+					Lines:     []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 1}},
+				})
+				require.NoError(t, err)
+			}
+			shopIDs = append(shopIDs, shop.GetId())
+		}
+
+		mine, err := biz.orderBiz.ListMyOrders(ctx, repository.OrderHistoryFilter{ProfileID: profileID}, 0, 0)
+		require.NoError(t, err)
+		require.Len(t, mine, 2)
+		require.Equal(t, shopIDs[1], mine[0].GetShopId())
+		require.Len(t, mine[0].GetLines(), 1)
+
+		page, err := biz.orderBiz.ListMyOrders(ctx, repository.OrderHistoryFilter{ProfileID: profileID}, 1, 1)
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		require.Equal(t, shopIDs[0], page[0].GetShopId())
+
+		inShop, err := biz.orderBiz.ListMyOrders(ctx,
+			repository.OrderHistoryFilter{ProfileID: profileID, ShopID: shopIDs[0]}, 10, 0)
+		require.NoError(t, err)
+		require.Len(t, inShop, 1)
+
+		cancelled, err := biz.orderBiz.ListMyOrders(ctx, repository.OrderHistoryFilter{
+			ProfileID: profileID,
+			Statuses:  []int32{int32(commercev1.OrderStatus_ORDER_STATUS_CANCELLED)},
+		}, 10, 0)
+		require.NoError(t, err)
+		require.Empty(t, cancelled)
+
+		_, err = biz.orderBiz.ListMyOrders(ctx, repository.OrderHistoryFilter{}, 10, 0)
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	})
+}
+
+func (bts *BusinessTestSuite) TestReorder_ReportsChangedLines() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, repriced := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		_, disabled := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		_, scarce := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId:    shop.GetId(),
+			ProfileId: "profile-reorder",
+			Lines: []*commercev1.CreateOrderLine{
+				{VariantId: repriced.GetId(), Quantity: 2},
+				{VariantId: disabled.GetId(), Quantity: 1},
+				{VariantId: scarce.GetId(), Quantity: 3},
+			},
+		})
+		require.NoError(t, err)
+
+		for _, update := range []*commercev1.UpdateProductVariantRequest{
+			{
+				VariantId:  repriced.GetId(),
+				Price:      &money.Money{CurrencyCode: "USD", Units: 12},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"price"}},
+			},
+			{
+				VariantId:  disabled.GetId(),
+				Status:     commercev1.ProductVariantStatus_PRODUCT_VARIANT_STATUS_DISABLED,
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"status"}},
+			},
+			{
+				VariantId:     scarce.GetId(),
+				StockQuantity: 1,
+				UpdateMask:    &fieldmaskpb.FieldMask{Paths: []string{"stock_quantity"}},
+			},
+		} {
+			_, err = biz.catalogBiz.UpdateProductVariant(ctx, update)
+			require.NoError(t, err)
+		}
+
+		result, err := biz.orderBiz.Reorder(ctx, order.GetId())
+		require.NoError(t, err)
+		require.Equal(t, "profile-reorder", result.Cart.GetProfileId())
+		quantities := map[string]int64{}
+		for _, line := range result.Cart.GetLines() {
+			quantities[line.GetProductVariantId()] = line.GetQuantity()
+		}
+		require.Equal(t, map[string]int64{repriced.GetId(): 2, scarce.GetId(): 1}, quantities)
+
+		problems := map[string]business.ReorderIssue{}
+		for _, issue := range result.Issues {
+			problems[issue.ProductVariantID] = issue
+		}
+		require.Len(t, problems, 3)
+		require.Equal(t, business.ReorderPriceChanged, problems[repriced.GetId()].Problem)
+		require.EqualValues(t, 10, problems[repriced.GetId()].PreviousPrice.GetUnits())
+		require.EqualValues(t, 12, problems[repriced.GetId()].CurrentPrice.GetUnits())
+		require.Equal(t, business.ReorderUnavailable, problems[disabled.GetId()].Problem)
+		require.Zero(t, problems[disabled.GetId()].AddedQuantity)
+		require.Equal(t, business.ReorderQuantityReduced, problems[scarce.GetId()].Problem)
+		require.EqualValues(t, 1, problems[scarce.GetId()].AddedQuantity)
+
+		// The cart checks out like any other.
+		_, err = biz.orderBiz.CreateOrderFromCart(ctx, &commercev1.CreateOrderFromCartRequest{
+			CartId: result.Cart.GetId(), ProfileId: "profile-reorder",
+		})
+		require.NoError(t, err)
+	})
+}
+
+func (bts *BusinessTestSuite) TestPurchaseRules_CartAndOrder() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		_, disabled := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		archivedProduct, archived := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		otherShop := bts.createTestShop(ctx, biz)
+		_, foreign := bts.createTestProductWithVariant(ctx, biz, otherShop.GetId())
+
+		_, err := biz.catalogBiz.UpdateProductVariant(ctx, &commercev1.UpdateProductVariantRequest{
+			VariantId:  disabled.GetId(),
+			Status:     commercev1.ProductVariantStatus_PRODUCT_VARIANT_STATUS_DISABLED,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"status"}},
+		})
+		require.NoError(t, err)
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		productRepo := repository.NewProductRepository(ctx, dbPool, svc.WorkManager())
+		product, err := productRepo.GetByID(ctx, archivedProduct.GetId())
+		require.NoError(t, err)
+		product.Status = int32(commercev1.ProductStatus_PRODUCT_STATUS_ARCHIVED)
+		_, err = productRepo.Update(ctx, product, "status")
+		require.NoError(t, err)
+
+		problemsOf := func(err error) []business.LineProblem {
+			var lineErr *business.LineError
+			require.ErrorAs(t, err, &lineErr)
+			return lineErr.Lines
+		}
+
+		cart, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{ShopId: shop.GetId()})
+		require.NoError(t, err)
+		addLine := func(variantID string, quantity int64) error {
+			_, addErr := biz.cartBiz.AddCartLine(ctx, &commercev1.AddCartLineRequest{
+				CartId: cart.GetId(), ProductVariantId: variantID, Quantity: quantity,
+			})
+			return addErr
+		}
+
+		err = addLine(foreign.GetId(), 1)
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+		require.Equal(t, business.PurchaseWrongShop, problemsOf(err)[0].Reason)
+
+		err = addLine(disabled.GetId(), 1)
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+		require.Equal(t, business.PurchaseVariantInactive, problemsOf(err)[0].Reason)
+
+		err = addLine(archived.GetId(), 1)
+		require.Equal(t, business.PurchaseProductInactive, problemsOf(err)[0].Reason)
+
+		err = addLine("missing-variant", 1)
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+		// The stock check counts what the cart already holds.
+		require.NoError(t, addLine(variant.GetId(), 60))
+		err = addLine(variant.GetId(), 50)
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+		stock := problemsOf(err)[0]
+		require.Equal(t, business.PurchaseInsufficientStock, stock.Reason)
+		require.EqualValues(t, 110, stock.Requested)
+		require.EqualValues(t, 100, stock.Available)
+
+		// The same problem reaches the client as a typed detail.
+		details, err := business.LineProblems(errorutil.CleanErr(err))
+		require.NoError(t, err)
+		require.Len(t, details, 1)
+		require.Equal(t, variant.GetId(), details[0].VariantID)
+		require.Equal(t, business.PurchaseInsufficientStock, details[0].Reason)
+		require.EqualValues(t, 110, details[0].Requested)
+		require.EqualValues(t, 100, details[0].Available)
+		require.True(t, proto.Equal(variant.GetPrice(), details[0].Price))
+
+		// Orders report every line that cannot be bought, by position.
+		_, err = biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines: []*commercev1.CreateOrderLine{
+				{VariantId: variant.GetId(), Quantity: 1},
+				{VariantId: disabled.GetId(), Quantity: 1},
+				{VariantId: foreign.GetId(), Quantity: 1},
+			},
+		})
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+		problems := problemsOf(err)
+		require.Len(t, problems, 2)
+		require.Equal(t, 1, problems[0].Line)
+		require.Equal(t, business.PurchaseVariantInactive, problems[0].Reason)
+		require.Equal(t, 2, problems[1].Line)
+		require.Equal(t, business.PurchaseWrongShop, problems[1].Reason)
+
+		// Stock found short only when it is taken is the same line problem.
+		_, err = biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines: []*commercev1.CreateOrderLine{
+				{VariantId: variant.GetId(), Quantity: 60},
+				{VariantId: variant.GetId(), Quantity: 60},
+			},
+		})
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+		problems = problemsOf(err)
+		require.Len(t, problems, 1)
+		require.Equal(t, 1, problems[0].Line)
+		require.Equal(t, variant.GetId(), problems[0].VariantID)
+		require.Equal(t, business.PurchaseInsufficientStock, problems[0].Reason)
+		require.EqualValues(t, 60, problems[0].Requested)
+		require.EqualValues(t, 40, problems[0].Available)
+
+		// A closed shop sells nothing, from a cart or directly.
+		_, err = biz.shopBiz.UpdateShop(ctx, &commercev1.UpdateShopRequest{
+			Id:         shop.GetId(),
+			Status:     commercev1.ShopStatus_SHOP_STATUS_DISABLED,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"status"}},
+		})
+		require.NoError(t, err)
+		err = addLine(variant.GetId(), 1)
+		require.Equal(t, business.PurchaseShopInactive, problemsOf(err)[0].Reason)
+		_, err = biz.orderBiz.CreateOrderFromCart(ctx, &commercev1.CreateOrderFromCartRequest{CartId: cart.GetId()})
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+	})
+}
+
+func (bts *BusinessTestSuite) createBookingProduct(ctx context.Context, biz allBiz, shopID string) *commercev1.ProductVariant {
+	t := bts.T()
+	product, err := biz.catalogBiz.CreateProduct(ctx, &commercev1.CreateProductRequest{
+		ShopId:     shopID,
+		Name:       "Haircut",
+		Attributes: map[string]string{business.FulfilmentTypeAttribute: "booking"},
+	})
+	require.NoError(t, err)
+	variant, err := biz.catalogBiz.CreateProductVariant(ctx, &commercev1.CreateProductVariantRequest{
+		ProductId: product.GetId(),
+		Sku:       "CUT-" + util.RandomAlphaNumericString(6),
+		Name:      "30 minutes",
+		Price:     &money.Money{CurrencyCode: "USD", Units: 25},
+	})
+	require.NoError(t, err)
+	return variant
+}
+
+func (bts *BusinessTestSuite) TestBooking_ReserveAndConvert() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		variant := bts.createBookingProduct(ctx, biz, shop.GetId())
+
+		start := time.Now().UTC().Add(48 * time.Hour).Truncate(24 * time.Hour).Add(10 * time.Hour)
+		slots, err := biz.bookingBiz.CreateSlots(ctx, variant.GetId(), []business.SlotInput{
+			{StartsAt: start, EndsAt: start.Add(30 * time.Minute), Capacity: 2},
+			{StartsAt: start.Add(time.Hour), EndsAt: start.Add(90 * time.Minute), Capacity: 1},
+		})
+		require.NoError(t, err)
+		require.Len(t, slots, 2)
+
+		slot, err := biz.bookingBiz.GetSlot(ctx, slots[0].GetID())
+		require.NoError(t, err)
+		require.Equal(t, shop.GetId(), slot.ShopID)
+
+		_, err = biz.bookingBiz.CreateSlots(ctx, variant.GetId(), []business.SlotInput{
+			{StartsAt: start, EndsAt: start.Add(30 * time.Minute), Capacity: 5},
+		})
+		require.Equal(t, connect.CodeAlreadyExists, connect.CodeOf(err))
+
+		cart, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{ShopId: shop.GetId()})
+		require.NoError(t, err)
+
+		// Booking products are only added against a slot.
+		_, err = biz.cartBiz.AddCartLine(ctx, &commercev1.AddCartLineRequest{
+			CartId: cart.GetId(), ProductVariantId: variant.GetId(), Quantity: 1,
+		})
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		_, err = biz.bookingBiz.AddBookingLine(ctx, cart.GetId(), slots[0].GetID(), 3)
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		updated, err := biz.bookingBiz.AddBookingLine(ctx, cart.GetId(), slots[0].GetID(), 2)
+		require.NoError(t, err)
+		require.Len(t, updated.GetLines(), 1)
+
+		days, err := biz.bookingBiz.ListAvailability(ctx, slots[0].ProductID, start.Add(-time.Hour), start.Add(24*time.Hour))
+		require.NoError(t, err)
+		require.Len(t, days, 1)
+		require.Len(t, days[0].Slots, 2)
+		require.Equal(t, int64(0), days[0].Slots[0].Available)
+		require.Equal(t, int64(1), days[0].Slots[1].Available)
+
+		// Ordering a booking variant directly has no slot to draw on.
+		_, err = biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines:  []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 1}},
+		})
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		order, err := biz.orderBiz.CreateOrderFromCart(ctx, &commercev1.CreateOrderFromCartRequest{CartId: cart.GetId()})
+		require.NoError(t, err)
+		require.Len(t, order.GetLines(), 1)
+		require.Equal(t, int64(2), order.GetLines()[0].GetQuantity())
+
+		// The booked places stay taken until the order's bookings are cancelled.
+		released, err := biz.bookingBiz.ReleaseExpiredHolds(ctx, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		require.Zero(t, released)
+
+		released, err = biz.bookingBiz.CancelOrderBookings(ctx, order.GetId())
+		require.NoError(t, err)
+		require.Equal(t, 1, released)
+
+		days, err = biz.bookingBiz.ListAvailability(ctx, slots[0].ProductID, start.Add(-time.Hour), start.Add(24*time.Hour))
+		require.NoError(t, err)
+		require.Equal(t, int64(2), days[0].Slots[0].Available)
+	})
+}
+
+func (bts *BusinessTestSuite) TestBooking_HoldsReleased() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		variant := bts.createBookingProduct(ctx, biz, shop.GetId())
+
+		start := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+		slots, err := biz.bookingBiz.CreateSlots(ctx, variant.GetId(), []business.SlotInput{
+			{StartsAt: start, EndsAt: start.Add(time.Hour), Capacity: 1},
+		})
+		require.NoError(t, err)
+		slotID := slots[0].GetID()
+
+		first, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{ShopId: shop.GetId()})
+		require.NoError(t, err)
+		second, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{ShopId: shop.GetId()})
+		require.NoError(t, err)
+
+		held, err := biz.bookingBiz.AddBookingLine(ctx, first.GetId(), slotID, 1)
+		require.NoError(t, err)
+		_, err = biz.bookingBiz.AddBookingLine(ctx, second.GetId(), slotID, 1)
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		// Removing the line gives the place back.
+		_, err = biz.cartBiz.RemoveCartLine(ctx, &commercev1.RemoveCartLineRequest{
+			CartId: first.GetId(), CartLineId: held.GetLines()[0].GetId(),
+		})
+		require.NoError(t, err)
+
+		_, err = biz.bookingBiz.AddBookingLine(ctx, second.GetId(), slotID, 1)
+		require.NoError(t, err)
+
+		// An expired hold is released and its cart line dropped.
+		released, err := biz.bookingBiz.ReleaseExpiredHolds(ctx, time.Now().Add(business.BookingHoldDuration+time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, 1, released)
+
+		emptied, err := biz.cartBiz.GetCart(ctx, second.GetId())
+		require.NoError(t, err)
+		require.Empty(t, emptied.GetLines())
+
+		_, err = biz.orderBiz.CreateOrderFromCart(ctx, &commercev1.CreateOrderFromCartRequest{CartId: second.GetId()})
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	})
+}
+
+func (bts *BusinessTestSuite) TestSubscription_RunDue() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		start := time.Now().Add(-time.Minute)
+		subscription, err := biz.subscriptionBiz.Subscribe(ctx, business.SubscriptionInput{
+			ShopID:    shop.GetId(),
+			ProfileID: "profile-weekly",
+			Interval:  business.SubscriptionInterval{Unit: business.IntervalWeek, Count: 1},
+			PriceLock: models.PriceLockFixed,
+			StartAt:   start,
+			Lines:     []business.SubscriptionLineInput{{VariantID: variant.GetId(), Quantity: 2}},
+		})
+		require.NoError(t, err)
+
+		// The price rises after subscribing; the fixed lock keeps the old one.
+		_, err = biz.catalogBiz.UpdateProductVariant(ctx, &commercev1.UpdateProductVariantRequest{
+			VariantId: variant.GetId(),
+			Price:     &money.Money{CurrencyCode: "USD", Units: 12},
+		})
+		require.NoError(t, err)
+
+		placed, err := biz.subscriptionBiz.RunDue(ctx, time.Now())
+		require.NoError(t, err)
+		require.Equal(t, 1, placed)
+
+		ran, err := biz.subscriptionBiz.GetSubscription(ctx, subscription.GetID())
+		require.NoError(t, err)
+		require.Equal(t, int64(1), ran.CycleCount)
+		require.True(t, ran.NextRunAt.After(time.Now()))
+
+		order, err := biz.orderBiz.GetOrder(ctx, ran.LastOrderID)
+		require.NoError(t, err)
+		require.Equal(t, "profile-weekly", order.GetProfileId())
+		require.Equal(t, int64(21), order.GetTotal().GetUnits())
+
+		// Nothing is due until the next week.
+		placed, err = biz.subscriptionBiz.RunDue(ctx, time.Now())
+		require.NoError(t, err)
+		require.Zero(t, placed)
+
+		skipped, err := biz.subscriptionBiz.Skip(ctx, subscription.GetID())
+		require.NoError(t, err)
+		require.True(t, ran.NextRunAt.AddDate(0, 0, 7).Equal(skipped.NextRunAt))
+
+		_, err = biz.subscriptionBiz.Pause(ctx, subscription.GetID())
+		require.NoError(t, err)
+		placed, err = biz.subscriptionBiz.RunDue(ctx, time.Now().AddDate(0, 1, 0))
+		require.NoError(t, err)
+		require.Zero(t, placed)
+
+		_, err = biz.subscriptionBiz.Resume(ctx, subscription.GetID())
+		require.NoError(t, err)
+		cancelled, err := biz.subscriptionBiz.Cancel(ctx, subscription.GetID())
+		require.NoError(t, err)
+		require.Equal(t, models.SubscriptionStatusCancelled, cancelled.Status)
+
+		_, err = biz.subscriptionBiz.Resume(ctx, subscription.GetID())
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+	})
+}
+
+func (bts *BusinessTestSuite) TestSubscription_RerunDoesNotDoubleBill() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		subscription, err := biz.subscriptionBiz.Subscribe(ctx, business.SubscriptionInput{
+			ShopID:    shop.GetId(),
+			ProfileID: "profile-monthly",
+			Interval:  business.SubscriptionInterval{Unit: business.IntervalMonth, Count: 1},
+			StartAt:   time.Now().Add(-time.Minute),
+			Lines:     []business.SubscriptionLineInput{{VariantID: variant.GetId(), Quantity: 1}},
+		})
+		require.NoError(t, err)
+
+		// A run that placed its order but crashed before advancing is replayed
+		// through the same idempotency key.
+		order, err := biz.orderBiz.CreateRecurringOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId:         shop.GetId(),
+			ProfileId:      "profile-monthly",
+			IdempotencyKey: fmt.Sprintf("subscription:%s:%d", subscription.GetID(), subscription.NextRunAt.UnixMicro()),
+			Lines:          []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 1}},
+		}, nil)
+		require.NoError(t, err)
+
+		placed, err := biz.subscriptionBiz.RunDue(ctx, time.Now())
+		require.NoError(t, err)
+		require.Zero(t, placed)
+
+		ran, err := biz.subscriptionBiz.GetSubscription(ctx, subscription.GetID())
+		require.NoError(t, err)
+		require.Equal(t, order.GetId(), ran.LastOrderID)
+
+		orders, err := biz.orderBiz.ListOrders(ctx, &commercev1.ListOrdersRequest{ShopId: shop.GetId()})
+		require.NoError(t, err)
+		require.Len(t, orders, 1)
+	})
+}
+
+func (bts *BusinessTestSuite) TestBundle_OrderDecrementsComponents() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, brush := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		_, paint := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+		kitProduct, kit := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		_, err := biz.catalogBiz.SetBundleComponents(ctx, kit.GetId(), []business.BundleComponentInput{
+			{VariantID: brush.GetId(), Quantity: 1},
+			{VariantID: paint.GetId(), Quantity: 3},
+		})
+		require.NoError(t, err)
+
+		// Bundles do not nest.
+		_, err = biz.catalogBiz.SetBundleComponents(ctx, brush.GetId(), []business.BundleComponentInput{
+			{VariantID: kit.GetId(), Quantity: 1},
+		})
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		available, err := biz.catalogBiz.BundleAvailability(ctx, kit.GetId())
+		require.NoError(t, err)
+		require.Equal(t, int64(33), available)
+
+		variants, err := biz.catalogBiz.ListProductVariants(ctx, kitProduct.GetId())
+		require.NoError(t, err)
+		require.Equal(t, int64(33), variants[0].GetStockQuantity())
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines:  []*commercev1.CreateOrderLine{{VariantId: kit.GetId(), Quantity: 10}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(105), order.GetTotal().GetUnits())
+
+		available, err = biz.catalogBiz.BundleAvailability(ctx, kit.GetId())
+		require.NoError(t, err)
+		require.Equal(t, int64(23), available)
+
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		lines, err := repository.NewOrderLineRepository(ctx, dbPool, svc.WorkManager()).GetByOrderID(ctx, order.GetId())
+		require.NoError(t, err)
+		require.Len(t, lines, 1)
+		require.Len(t, lines[0].BundleComposition, 2)
+		require.Equal(t, paint.GetSku(), lines[0].BundleComposition[1].SKU)
+		require.Equal(t, int64(3), lines[0].BundleComposition[1].Quantity)
+
+		// Paint runs out first: 70 left allows 23 more kits, not 24.
+		_, err = biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId: shop.GetId(),
+			Lines:  []*commercev1.CreateOrderLine{{VariantId: kit.GetId(), Quantity: 24}},
+		})
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+	})
+}
+
+func callerContext(ctx context.Context, profileID string) context.Context {
+	claims := &security.AuthenticationClaims{}
+	claims.Subject = profileID
+	return claims.ClaimsToContext(ctx)
+}
+
+func (bts *BusinessTestSuite) TestCreateShop_GrantsOwner() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		ownerCtx := callerContext(ctx, "owner-"+util.RandomAlphaNumericString(6))
+		shop := bts.createTestShop(ownerCtx, biz)
+
+		members, err := biz.shopBiz.ListShopMembers(ctx, shop.GetId())
+		require.NoError(t, err)
+		require.Len(t, members, 1)
+		require.Equal(t, business.RoleOwner, members[0].Role)
+
+		require.NoError(t, biz.authzBiz.AuthorizeShop(ownerCtx, shop.GetId(), business.PermissionMembersManage))
+
+		strangerCtx := callerContext(ctx, "stranger")
+		err = biz.authzBiz.AuthorizeShop(strangerCtx, shop.GetId(), business.PermissionShopManage)
+		require.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+	})
+}
+
+func (bts *BusinessTestSuite) TestAuthorizeShop_MemberRoles() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		product, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		staffID := "staff-" + util.RandomAlphaNumericString(6)
+		clerkID := "clerk-" + util.RandomAlphaNumericString(6)
+		_, err := biz.shopBiz.AddShopMember(ctx, shop.GetId(), staffID, business.RoleStaff)
+		require.NoError(t, err)
+		_, err = biz.shopBiz.AddShopMember(ctx, shop.GetId(), clerkID, business.RoleFulfilmentClerk)
+		require.NoError(t, err)
+
+		staffCtx := callerContext(ctx, staffID)
+		clerkCtx := callerContext(ctx, clerkID)
+
+		require.NoError(t, biz.authzBiz.AuthorizeProduct(staffCtx, product.GetId(), business.PermissionCatalogManage))
+		require.NoError(t, biz.authzBiz.AuthorizeVariant(staffCtx, variant.GetId(), business.PermissionCatalogManage))
+		err = biz.authzBiz.AuthorizeShop(staffCtx, shop.GetId(), business.PermissionShopManage)
+		require.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+
+		require.NoError(t, biz.authzBiz.AuthorizeShop(clerkCtx, shop.GetId(), business.PermissionOrdersView))
+		err = biz.authzBiz.AuthorizeProduct(clerkCtx, product.GetId(), business.PermissionCatalogManage)
+		require.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+
+		// Membership in one shop grants nothing in another.
+		otherShop := bts.createTestShop(ctx, biz)
+		err = biz.authzBiz.AuthorizeShop(staffCtx, otherShop.GetId(), business.PermissionOrdersView)
+		require.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+
+		_, err = biz.shopBiz.AddShopMember(ctx, shop.GetId(), staffID, "janitor")
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	})
+}
+
+func (bts *BusinessTestSuite) TestAuthorizeOrder_CustomerIsolation() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		shop := bts.createTestShop(ctx, biz)
+		_, variant := bts.createTestProductWithVariant(ctx, biz, shop.GetId())
+
+		customerID := "customer-" + util.RandomAlphaNumericString(6)
+		customerCtx := callerContext(ctx, customerID)
+		otherCtx := callerContext(ctx, "customer-"+util.RandomAlphaNumericString(6))
+
+		cart, err := biz.cartBiz.CreateCart(ctx, &commercev1.CreateCartRequest{
+			ShopId:    shop.GetId(),
+			ProfileId: customerID,
+		})
+		require.NoError(t, err)
+
+		order, err := biz.orderBiz.CreateOrder(ctx, &commercev1.CreateOrderRequest{
+			ShopId:    shop.GetId(),
+			ProfileId: customerID,
+			Lines:     []*commercev1.CreateOrderLine{{VariantId: variant.GetId(), Quantity: 1}},
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, biz.authzBiz.AuthorizeCart(customerCtx, cart.GetId(), business.PermissionCartsManage))
+		require.NoError(t, biz.authzBiz.AuthorizeOrder(customerCtx, order.GetId(), business.PermissionOrdersView))
+		require.NoError(t, biz.authzBiz.AuthorizeProfile(
+			customerCtx, shop.GetId(), customerID, business.PermissionOrdersManage))
+
+		err = biz.authzBiz.AuthorizeCart(otherCtx, cart.GetId(), business.PermissionCartsView)
+		require.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+		err = biz.authzBiz.AuthorizeOrder(otherCtx, order.GetId(), business.PermissionOrdersView)
+		require.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+		err = biz.authzBiz.AuthorizeProfile(otherCtx, shop.GetId(), customerID, business.PermissionOrdersManage)
+		require.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+
+		// Customers cannot manage fulfilment even on their own orders.
+		err = biz.authzBiz.AuthorizeOrder(customerCtx, order.GetId(), business.PermissionFulfilmentManage)
+		require.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+
+		err = biz.authzBiz.AuthorizeOrder(ctx, order.GetId(), business.PermissionOrdersView)
+		require.Equal(t, connect.CodeUnauthenticated, connect.CodeOf(err))
+	})
+}
+
+func (bts *BusinessTestSuite) TestRemoveShopMember_KeepsLastOwner() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		ownerID := "owner-" + util.RandomAlphaNumericString(6)
+		shop := bts.createTestShop(callerContext(ctx, ownerID), biz)
+
+		err := biz.shopBiz.RemoveShopMember(ctx, shop.GetId(), ownerID)
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		_, err = biz.shopBiz.AddShopMember(ctx, shop.GetId(), ownerID, business.RoleManager)
+		require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(err))
+
+		coOwnerID := "owner-" + util.RandomAlphaNumericString(6)
+		_, err = biz.shopBiz.AddShopMember(ctx, shop.GetId(), coOwnerID, business.RoleOwner)
+		require.NoError(t, err)
+
+		require.NoError(t, biz.shopBiz.RemoveShopMember(ctx, shop.GetId(), ownerID))
+
+		members, err := biz.shopBiz.ListShopMembers(ctx, shop.GetId())
+		require.NoError(t, err)
+		require.Len(t, members, 1)
+		require.Equal(t, coOwnerID, members[0].ProfileID)
+	})
+}
+
+func (bts *BusinessTestSuite) TestAuthorizeInternal() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		claims := &security.AuthenticationClaims{Roles: []string{"system_internal"}}
+		claims.Subject = "payment-service"
+		require.NoError(t, biz.authzBiz.AuthorizeInternal(claims.ClaimsToContext(ctx)))
+
+		err := biz.authzBiz.AuthorizeInternal(callerContext(ctx, "customer-"+util.RandomAlphaNumericString(6)))
+		require.Equal(t, connect.CodePermissionDenied, connect.CodeOf(err))
+	})
+}
+
+// --- Tenancy Business Tests ---
+
+func tenantContext(ctx context.Context, tenantID string) context.Context {
+	claims := &security.AuthenticationClaims{
+		TenantID:    tenantID,
+		PartitionID: tenantID + "-partition",
+	}
+	claims.Subject = "profile-" + tenantID
+	return claims.ClaimsToContext(ctx)
+}
+
+func (bts *BusinessTestSuite) TestTenantIsolation_Orders() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		// Tenant contexts must not inherit the test context's tenancy bypass.
+		tenantACtx := tenantContext(t.Context(), "tenant-a-"+util.RandomAlphaNumericString(6))
+		tenantBCtx := tenantContext(t.Context(), "tenant-b-"+util.RandomAlphaNumericString(6))
+
+		shopA := bts.createTestShop(tenantACtx, biz)
+		_, variantA := bts.createTestProductWithVariant(tenantACtx, biz, shopA.GetId())
+		orderA, err := biz.orderBiz.CreateOrder(tenantACtx, &commercev1.CreateOrderRequest{
+			ShopId: shopA.GetId(),
+			Lines:  []*commercev1.CreateOrderLine{{VariantId: variantA.GetId(), Quantity: 1}},
+		})
+		require.NoError(t, err)
+
+		_, err = biz.orderBiz.GetOrder(tenantACtx, orderA.GetId())
+		require.NoError(t, err)
+
+		_, err = biz.orderBiz.GetOrder(tenantBCtx, orderA.GetId())
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+		orders, err := biz.orderBiz.ListOrders(tenantBCtx, &commercev1.ListOrdersRequest{ShopId: shopA.GetId()})
+		require.NoError(t, err)
+		require.Empty(t, orders)
+
+		_, err = biz.shopBiz.GetShop(tenantBCtx, shopA.GetId())
+		require.Equal(t, connect.CodeNotFound, connect.CodeOf(err))
+
+		// Tenant B cannot place orders against tenant A's catalog.
+		shopB := bts.createTestShop(tenantBCtx, biz)
+		_, err = biz.orderBiz.CreateOrder(tenantBCtx, &commercev1.CreateOrderRequest{
+			ShopId: shopB.GetId(),
+			Lines:  []*commercev1.CreateOrderLine{{VariantId: variantA.GetId(), Quantity: 1}},
+		})
+		require.Error(t, err)
+	})
+}
+
+func (bts *BusinessTestSuite) TestTenantScopedSlugAndSKU() {
+	t := bts.T()
+
+	bts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := bts.CreateService(t, dep)
+		biz := bts.getBusiness(ctx, svc)
+
+		tenantACtx := tenantContext(t.Context(), "tenant-a-"+util.RandomAlphaNumericString(6))
+		tenantBCtx := tenantContext(t.Context(), "tenant-b-"+util.RandomAlphaNumericString(6))
+
+		createMain := func(tenantCtx context.Context) (*commercev1.Shop, error) {
+			return biz.shopBiz.CreateShop(tenantCtx, &commercev1.CreateShopRequest{Name: "Main", Slug: "main"})
+		}
+		createSKU := func(tenantCtx context.Context, productID string) error {
+			_, err := biz.catalogBiz.CreateProductVariant(tenantCtx, &commercev1.CreateProductVariantRequest{
+				ProductId: productID,
+				Sku:       "SKU-1",
+				Name:      "Default",
+			})
+			return err
+		}
+
+		shopA, err := createMain(tenantACtx)
+		require.NoError(t, err)
+		shopB, err := createMain(tenantBCtx)
+		require.NoError(t, err)
+		require.NotEqual(t, shopA.GetId(), shopB.GetId())
+
+		_, err = createMain(tenantACtx)
+		require.Equal(t, connect.CodeAlreadyExists, connect.CodeOf(err))
+
+		productA, err := biz.catalogBiz.CreateProduct(tenantACtx, &commercev1.CreateProductRequest{
+			ShopId: shopA.GetId(), Name: "Widget",
+		})
+		require.NoError(t, err)
+		productB, err := biz.catalogBiz.CreateProduct(tenantBCtx, &commercev1.CreateProductRequest{
+			ShopId: shopB.GetId(), Name: "Widget",
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, createSKU(tenantACtx, productA.GetId()))
+		require.NoError(t, createSKU(tenantBCtx, productB.GetId()))
+
+		err = createSKU(tenantACtx, productA.GetId())
+		require.Equal(t, connect.CodeAlreadyExists, connect.CodeOf(err))
+	})
+}
+
+func TestRoleHasPermission(t *testing.T) {
+	tests := []struct {
+		role string
+		perm business.Permission
+		want bool
+	}{
+		{business.RoleOwner, business.PermissionMembersManage, true},
+		{business.RoleManager, business.PermissionMembersManage, false},
+		{business.RoleManager, business.PermissionShopManage, true},
+		{business.RoleStaff, business.PermissionShopManage, false},
+		{business.RoleStaff, business.PermissionCatalogManage, true},
+		{business.RoleFulfilmentClerk, business.PermissionFulfilmentManage, true},
+		{business.RoleFulfilmentClerk, business.PermissionOrdersManage, false},
+		{"unknown", business.PermissionOrdersView, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.role+"/"+string(tt.perm), func(t *testing.T) {
+			require.Equal(t, tt.want, business.RoleHasPermission(tt.role, tt.perm))
+		})
+	}
+}
+
+func TestValidateSKU(t *testing.T) {
+	tests := []struct {
+		name    string
+		sku     string
+		wantErr bool
+	}{
+		{name: "simple", sku: "SKU-001"},
+		{name: "dots and underscores", sku: "tee_red.m"},
+		{name: "max length", sku: strings.Repeat("A", business.MaxSKULength)},
+		{name: "empty", sku: "", wantErr: true},
+		{name: "too long", sku: strings.Repeat("A", business.MaxSKULength+1), wantErr: true},
+		{name: "whitespace", sku: "SKU 001", wantErr: true},
+		{name: "leading dash", sku: "-SKU", wantErr: true},
+		{name: "slash", sku: "SKU/001", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := business.ValidateSKU(tt.sku)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestSKUTemplate_Render(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		product  string
+		options  map[string]string
+		seq      int64
+		want     string
+	}{
+		{
+			name:     "product and options ordered by name",
+			template: "{product}-{options}",
+			product:  "Basic Tee",
+			options:  map[string]string{"size": "XL", "colour": "Navy Blue"},
+			want:     "BASIC-TEE-NAVY-BLUE-XL",
+		},
+		{
+			name:     "sequence",
+			template: "TEE-{seq}",
+			seq:      42,
+			want:     "TEE-42",
+		},
+		{
+			name:     "no options",
+			template: "{product}-{options}",
+			product:  "Mug",
+			want:     "MUG",
+		},
+		{
+			name:     "invalid characters replaced",
+			template: "{product}",
+			product:  "Café & Co / 2",
+			want:     "CAF-CO-2",
+		},
+		{
+			name:     "truncated to max length",
+			template: "{product}",
+			product:  strings.Repeat("x", business.MaxSKULength+10),
+			want:     strings.Repeat("X", business.MaxSKULength),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := business.SKUTemplate{Template: tt.template}.Render(tt.product, tt.options, tt.seq)
+			require.Equal(t, tt.want, got)
+			require.NoError(t, business.ValidateSKU(got))
+		})
+	}
+}
+
+func TestValidateVariantAttributes(t *testing.T) {
+	options := []*models.ProductOption{
+		{Name: "Size", Values: models.StringArray{"S", "M"}},
+		{Name: "Colour", Values: models.StringArray{"Red"}},
+	}
+
+	tests := []struct {
+		name    string
+		options []*models.ProductOption
+		attrs   map[string]string
+		wantErr bool
+	}{
+		{name: "valid", options: options, attrs: map[string]string{"Size": "M", "Colour": "Red"}},
+		{name: "free form without options", attrs: map[string]string{"material": "cotton"}},
+		{name: "missing option", options: options, attrs: map[string]string{"Size": "M"}, wantErr: true},
+		{name: "undeclared value", options: options, attrs: map[string]string{"Size": "L", "Colour": "Red"}, wantErr: true},
+		{
+			name:    "undeclared option",
+			options: options,
+			attrs:   map[string]string{"Size": "S", "Colour": "Red", "Fit": "Slim"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := business.ValidateVariantAttributes(tt.options, tt.attrs)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestValidateCollectionRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    models.CollectionRule
+		wantErr bool
+	}{
+		{"attribute equals", models.CollectionRule{Field: "attribute", Attribute: "brand", Operator: "eq", Value: "X"}, false},
+		{"name contains", models.CollectionRule{Field: "name", Operator: "contains", Value: "tee"}, false},
+		{"price below", models.CollectionRule{Field: "price", Operator: "lt", Value: "999.99"}, false},
+		{"attribute without name", models.CollectionRule{Field: "attribute", Operator: "eq", Value: "X"}, true},
+		{"unknown field", models.CollectionRule{Field: "colour", Operator: "eq", Value: "red"}, true},
+		{"unknown operator", models.CollectionRule{Field: "name", Operator: "like", Value: "tee"}, true},
+		{"ordering on name", models.CollectionRule{Field: "name", Operator: "gt", Value: "a"}, true},
+		{"price contains", models.CollectionRule{Field: "price", Operator: "contains", Value: "9"}, true},
+		{"price not a number", models.CollectionRule{Field: "price", Operator: "lt", Value: "cheap"}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := business.ValidateCollectionRules([]models.CollectionRule{tc.rule})
+			if tc.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParseFulfilmentType(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    commercev1.FulfilmentType
+		wantErr bool
+	}{
+		{"", commercev1.FulfilmentType_FULFILMENT_TYPE_PHYSICAL, false},
+		{"physical", commercev1.FulfilmentType_FULFILMENT_TYPE_PHYSICAL, false},
+		{" Digital ", commercev1.FulfilmentType_FULFILMENT_TYPE_DIGITAL, false},
+		{"none", commercev1.FulfilmentType_FULFILMENT_TYPE_NONE, false},
+		{"booking", commercev1.FulfilmentType(models.FulfilmentTypeBooking), false},
+		{"teleport", commercev1.FulfilmentType_FULFILMENT_TYPE_UNSPECIFIED, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := business.ParseFulfilmentType(tc.in)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Basic Tee", "basic-tee"},
+		{"  Crème Brûlée!  ", "creme-brulee"},
+		{"Straße & Søn", "strasse-and-son"},
+		{"Łódź -- 2024", "lodz-2024"},
+		{"100% Cotton_T-Shirt", "100-cotton-t-shirt"},
+		{"日本", ""},
+		{strings.Repeat("ab ", 60), strings.TrimRight(strings.Repeat("ab-", 60)[:business.MaxSlugLength], "-")},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			require.Equal(t, tc.want, business.Slugify(tc.in))
+		})
+	}
+}
+
+func TestVariantCombinations(t *testing.T) {
+	options := []*models.ProductOption{
+		{Name: "Size", Values: models.StringArray{"S", "M", "L"}},
+		{Name: "Colour", Values: models.StringArray{"Red", "Blue"}},
+	}
+
+	combinations := business.VariantCombinations(options)
+	require.Len(t, combinations, 6)
+	require.Equal(t, map[string]string{"Size": "S", "Colour": "Red"}, combinations[0])
+	require.Equal(t, map[string]string{"Size": "L", "Colour": "Blue"}, combinations[5])
+
+	require.Empty(t, business.VariantCombinations(nil))
+}
+
+func TestNumberFormat(t *testing.T) {
+	at := time.Date(2026, time.March, 9, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		format business.NumberFormat
+		seq    int64
+		want   string
+	}{
+		{
+			name:   "default pattern",
+			format: business.NumberFormat{Prefix: "SHOP"},
+			seq:    123,
+			want:   "SHOP-2026-000123",
+		},
+		{
+			name:   "custom pattern and padding",
+			format: business.NumberFormat{Prefix: "INV", Pattern: "{prefix}{yy}{mm}-{seq}", Padding: 4},
+			seq:    7,
+			want:   "INV2603-0007",
+		},
+		{
+			name:   "pattern without sequence gets one appended",
+			format: business.NumberFormat{Prefix: "X", Pattern: "{prefix}-{yyyy}"},
+			seq:    1,
+			want:   "X-2026-000001",
+		},
+		{
+			name:   "empty prefix is trimmed",
+			format: business.NumberFormat{},
+			seq:    42,
+			want:   "2026-000042",
+		},
+		{
+			name:   "sequence wider than padding",
+			format: business.NumberFormat{Prefix: "ORD", Padding: 2},
+			seq:    12345,
+			want:   "ORD-2026-12345",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.format.Format(tt.seq, at))
+		})
+	}
+}
+
+func TestGroupAvailability(t *testing.T) {
+	day := time.Date(2026, time.March, 2, 0, 0, 0, 0, time.UTC)
+	slot := func(id string, start time.Time, status int32) *models.BookingSlot {
+		s := &models.BookingSlot{
+			StartsAt: start, EndsAt: start.Add(time.Hour), Capacity: 3, Reserved: 1, Status: status,
+		}
+		s.ID = id
+		return s
+	}
+
+	slots := []*models.BookingSlot{
+		slot("a", day.Add(9*time.Hour), models.SlotStatusOpen),
+		slot("b", day.Add(23*time.Hour), models.SlotStatusOpen),
+		slot("c", day.Add(25*time.Hour), models.SlotStatusClosed),
+		slot("d", day.Add(49*time.Hour), models.SlotStatusOpen),
+	}
+
+	days := business.GroupAvailability(slots, time.UTC)
+	require.Len(t, days, 2)
+	require.Equal(t, day, days[0].Date)
+	require.Len(t, days[0].Slots, 2)
+	require.Equal(t, int64(2), days[0].Slots[0].Available)
+	require.Equal(t, day.Add(48*time.Hour), days[1].Date)
+	require.Equal(t, "d", days[1].Slots[0].SlotID)
+
+	// Days follow the calendar of the requested location.
+	nairobi := time.FixedZone("EAT", 3*60*60)
+	days = business.GroupAvailability(slots, nairobi)
+	require.Len(t, days, 3)
+	require.Equal(t, "b", days[1].Slots[0].SlotID)
+}
+
+func TestSubscriptionInterval_NextAfter(t *testing.T) {
+	anchor := time.Date(2026, time.January, 31, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		interval business.SubscriptionInterval
+		after    time.Time
+		want     time.Time
+	}{
+		{"before anchor", business.SubscriptionInterval{Unit: business.IntervalDay, Count: 1},
+			anchor.Add(-time.Hour), anchor},
+		{"at anchor", business.SubscriptionInterval{Unit: business.IntervalDay, Count: 3},
+			anchor, anchor.AddDate(0, 0, 3)},
+		{"weekly", business.SubscriptionInterval{Unit: business.IntervalWeek, Count: 2},
+			anchor.AddDate(0, 0, 15), anchor.AddDate(0, 0, 28)},
+		{"month end clamps", business.SubscriptionInterval{Unit: business.IntervalMonth, Count: 1},
+			anchor, time.Date(2026, time.February, 28, 9, 0, 0, 0, time.UTC)},
+		{"month end does not drift", business.SubscriptionInterval{Unit: business.IntervalMonth, Count: 1},
+			time.Date(2026, time.February, 28, 9, 0, 0, 0, time.UTC),
+			time.Date(2026, time.March, 31, 9, 0, 0, 0, time.UTC)},
+		{"quarterly across years", business.SubscriptionInterval{Unit: business.IntervalMonth, Count: 3},
+			time.Date(2026, time.December, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2027, time.January, 31, 9, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, tc.interval.Validate())
+			require.Equal(t, tc.want, tc.interval.NextAfter(anchor, tc.after))
+		})
+	}
+
+	require.Error(t, business.SubscriptionInterval{Unit: "fortnight", Count: 1}.Validate())
+	require.Error(t, business.SubscriptionInterval{Unit: business.IntervalDay}.Validate())
+}
+
+func TestLockedUnitPrice(t *testing.T) {
+	locked := &money.Money{CurrencyCode: "USD", Units: 10, Nanos: 500000000}
+	higher := &money.Money{CurrencyCode: "USD", Units: 12}
+	lower := &money.Money{CurrencyCode: "USD", Units: 10, Nanos: 250000000}
+	other := &money.Money{CurrencyCode: "EUR", Units: 9}
+
+	tests := []struct {
+		name    string
+		rule    int32
+		current *money.Money
+		want    *money.Money
+	}{
+		{"none follows price", models.PriceLockNone, higher, higher},
+		{"fixed keeps locked", models.PriceLockFixed, lower, locked},
+		{"fixed ignores other currency", models.PriceLockFixed, other, other},
+		{"capped limits rise", models.PriceLockCapped, higher, locked},
+		{"capped passes drop", models.PriceLockCapped, lower, lower},
+		{"capped ignores other currency", models.PriceLockCapped, other, other},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, business.LockedUnitPrice(tc.rule, locked, tc.current))
+		})
+	}
+}
+
+func TestBundleStock(t *testing.T) {
+	component := func(stock, quantity int64) *models.BundleComponent {
+		return &models.BundleComponent{
+			Quantity:         quantity,
+			ComponentVariant: &models.ProductVariant{StockQuantity: stock},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		components []*models.BundleComponent
+		want       int64
+	}{
+		{"no components", nil, 0},
+		{"single", []*models.BundleComponent{component(10, 3)}, 3},
+		{"scarcest component wins", []*models.BundleComponent{component(100, 1), component(70, 3)}, 23},
+		{"out of stock", []*models.BundleComponent{component(5, 1), component(0, 1)}, 0},
+		{"negative stock", []*models.BundleComponent{component(-2, 1)}, 0},
+		{"unloaded variant", []*models.BundleComponent{{Quantity: 1}}, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, business.BundleStock(tc.components))
+		})
+	}
+}
+
+func TestValidateFulfilmentTransition(t *testing.T) {
+	const (
+		pending   = commercev1.FulfilmentStatus_FULFILMENT_STATUS_PENDING
+		preparing = commercev1.FulfilmentStatus_FULFILMENT_STATUS_PREPARING
+		packed    = commercev1.FulfilmentStatus_FULFILMENT_STATUS_PACKED
+		shipped   = commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED
+		delivered = commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED
+		cancelled = commercev1.FulfilmentStatus_FULFILMENT_STATUS_CANCELLED
+	)
+
+	tests := []struct {
+		from, to commercev1.FulfilmentStatus
+		wantErr  bool
+	}{
+		{pending, preparing, false},
+		{pending, shipped, false},
+		{packed, packed, false},
+		{shipped, delivered, false},
+		{preparing, cancelled, false},
+		{packed, cancelled, false},
+		{shipped, cancelled, true},
+		{shipped, packed, true},
+		{delivered, shipped, true},
+		{cancelled, pending, true},
+		{pending, commercev1.FulfilmentStatus_FULFILMENT_STATUS_UNSPECIFIED, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.from.String()+"->"+tc.to.String(), func(t *testing.T) {
+			err := business.ValidateFulfilmentTransition(tc.from, tc.to)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestAggregateFulfilmentStatus(t *testing.T) {
+	line := func(id string, quantity int64, slotID string) *models.OrderLine {
+		l := &models.OrderLine{Quantity: quantity, SlotID: slotID}
+		l.ID = id
+		return l
+	}
+	held := func(lineID string, status commercev1.FulfilmentStatus, quantity int64) repository.FulfilmentQuantity {
+		return repository.FulfilmentQuantity{OrderLineID: lineID, Status: int32(status), Quantity: quantity}
+	}
+	lines := []*models.OrderLine{line("a", 2, ""), line("b", 1, ""), line("c", 1, "slot")}
+
+	tests := []struct {
+		name       string
+		quantities []repository.FulfilmentQuantity
+		want       int32
+	}{
+		{"nothing", nil, int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_UNSPECIFIED)},
+		{"only allocated", []repository.FulfilmentQuantity{
+			held("a", commercev1.FulfilmentStatus_FULFILMENT_STATUS_PACKED, 2),
+			held("b", commercev1.FulfilmentStatus_FULFILMENT_STATUS_PENDING, 1),
+		}, int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_UNSPECIFIED)},
+		{"partly shipped", []repository.FulfilmentQuantity{
+			held("a", commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED, 1),
+		}, models.FulfilmentStatusPartial},
+		{"cancelled does not count", []repository.FulfilmentQuantity{
+			held("a", commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED, 2),
+			held("b", commercev1.FulfilmentStatus_FULFILMENT_STATUS_CANCELLED, 1),
+		}, models.FulfilmentStatusPartial},
+		{"all shipped", []repository.FulfilmentQuantity{
+			held("a", commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED, 2),
+			held("b", commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED, 1),
+		}, int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED)},
+		{"all delivered", []repository.FulfilmentQuantity{
+			held("a", commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED, 2),
+			held("b", commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED, 1),
+		}, int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, business.AggregateFulfilmentStatus(lines, tc.quantities))
+		})
+	}
+}
+
+func TestFakeCarrier_ParseWebhook(t *testing.T) {
+	carrier := business.NewFakeCarrier("secret")
+	body := []byte(`{"events":[{"id":"e1","tracking_number":"FK1","status":"in_transit"},` +
+		`{"id":"e2","tracking_number":"FK1","status":"exception"}]}`)
+
+	tests := []struct {
+		name      string
+		signature string
+		wantErr   error
+	}{
+		{"signed", carrier.Sign(body), nil},
+		{"missing", "", business.ErrInvalidWebhookSignature},
+		{"wrong secret", business.NewFakeCarrier("other").Sign(body), business.ErrInvalidWebhookSignature},
+		{"not hex", "sha256=zz", business.ErrInvalidWebhookSignature},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set(business.FakeCarrierSignatureHeader, tc.signature)
+
+			updates, err := carrier.ParseWebhook(header, body)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, updates, 2)
+			require.Equal(t, commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED, updates[0].Status)
+			require.Equal(t, commercev1.FulfilmentStatus_FULFILMENT_STATUS_UNSPECIFIED, updates[1].Status)
+		})
+	}
+}
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// documentFixture is two orders, one with a bundle, fulfilled by one
+// fulfilment each.
+func documentFixture() ([]*models.Fulfilment, map[string]*models.Order, map[string]string) {
+	orderLine := func(id, variantID, sku, name string, quantity int64) *models.OrderLine {
+		line := &models.OrderLine{ProductVariantID: variantID, SKUSnapshot: sku, NameSnapshot: name, Quantity: quantity}
+		line.ID = id
+		return line
+	}
+	order := func(id, number string, lines ...*models.OrderLine) *models.Order {
+		o := &models.Order{OrderNumber: number, Lines: lines}
+		o.ID = id
+		o.CreatedAt = time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)
+		return o
+	}
+	fulfilment := func(id, orderID string, lines ...*models.FulfilmentLine) *models.Fulfilment {
+		f := &models.Fulfilment{OrderID: orderID, Lines: lines}
+		f.ID = id
+		return f
+	}
+
+	giftSet := orderLine("ol-2", "v-gift", "GIFT-SET", "Tea & Honey <Gift> Set", 1)
+	giftSet.BundleComposition = models.BundleComposition{
+		{VariantID: "v-mug", SKU: "MUG-BLK", Name: "Black mug", Quantity: 1},
+		{VariantID: "v-tea", SKU: "TEA-01", Name: "Rooibos tea (100g)", Quantity: 2},
+	}
+	orders := map[string]*models.Order{
+		"o-1": order("o-1", "ORD-1001", orderLine("ol-1", "v-mug", "MUG-BLK", "Black mug", 2), giftSet),
+		"o-2": order("o-2", "ORD-1002",
+			orderLine("ol-3", "v-tea", "TEA-01", "Rooibos tea (100g)", 3),
+			orderLine("ol-4", "v-card", "CARD-01", "Carte cadeau été", 1)),
+	}
+	fulfilments := []*models.Fulfilment{
+		fulfilment("f-1", "o-1",
+			&models.FulfilmentLine{OrderLineID: "ol-1", Quantity: 2},
+			&models.FulfilmentLine{OrderLineID: "ol-2", Quantity: 1}),
+		fulfilment("f-2", "o-2",
+			&models.FulfilmentLine{OrderLineID: "ol-3", Quantity: 3},
+			&models.FulfilmentLine{OrderLineID: "ol-4", Quantity: 1}),
+	}
+	locations := map[string]string{"v-mug": "A-01", "v-tea": "B-07"}
+	return fulfilments, orders, locations
+}
+
+func TestBuildPickList(t *testing.T) {
+	fulfilments, orders, locations := documentFixture()
+	list := business.BuildPickList("Corner Shop", time.Now(), fulfilments, orders, locations)
+
+	require.Equal(t, []string{"ORD-1001", "ORD-1002"}, list.OrderNumbers)
+	require.Equal(t, []business.PickListItem{
+		{Location: "A-01", SKU: "MUG-BLK", Name: "Black mug", Quantity: 3, OrderNumbers: []string{"ORD-1001"}},
+		{Location: "B-07", SKU: "TEA-01", Name: "Rooibos tea (100g)", Quantity: 5,
+			OrderNumbers: []string{"ORD-1001", "ORD-1002"}},
+		{SKU: "CARD-01", Name: "Carte cadeau été", Quantity: 1, OrderNumbers: []string{"ORD-1002"}},
+	}, list.Items)
+}
+
+func TestRenderDocuments_Golden(t *testing.T) {
+	fulfilments, orders, locations := documentFixture()
+	generatedAt := time.Date(2026, 10, 18, 7, 45, 0, 0, time.UTC)
+	list := business.BuildPickList("Corner Shop", generatedAt, fulfilments, orders, locations)
+
+	fulfilments[0].Carrier = business.FakeCarrierName
+	fulfilments[0].TrackingNumber = "FK123456789012"
+	slip := business.BuildPackingSlip("Corner Shop", orders["o-1"], fulfilments[0])
+
+	tests := []struct {
+		name   string
+		render func(*bytes.Buffer, business.DocumentFormat) error
+	}{
+		{"pick_list", func(b *bytes.Buffer, f business.DocumentFormat) error { return business.RenderPickList(b, list, f) }},
+		{"packing_slip", func(b *bytes.Buffer, f business.DocumentFormat) error {
+			return business.RenderPackingSlip(b, slip, f)
+		}},
+	}
+
+	for _, tc := range tests {
+		for _, format := range []business.DocumentFormat{business.DocumentFormatHTML, business.DocumentFormatPDF} {
+			t.Run(tc.name+"."+string(format), func(t *testing.T) {
+				var got bytes.Buffer
+				require.NoError(t, tc.render(&got, format))
+
+				golden := filepath.Join("testdata", tc.name+"."+string(format)+".golden")
+				if *updateGolden {
+					require.NoError(t, os.MkdirAll("testdata", 0o755))
+					require.NoError(t, os.WriteFile(golden, got.Bytes(), 0o600))
+				}
+				want, err := os.ReadFile(golden)
+				require.NoError(t, err)
+				require.Equal(t, string(want), got.String())
+			})
+		}
+	}
+}
+
+func TestInclusiveTax(t *testing.T) {
+	tests := []struct {
+		name      string
+		units     int64
+		nanos     int32
+		rate      int64
+		wantUnits int64
+		wantNanos int32
+	}{
+		{name: "sixteen percent", units: 116, rate: 1600, wantUnits: 16},
+		{name: "rounds half up", units: 21, rate: 1600, wantUnits: 2, wantNanos: 900000000},
+		{name: "twenty percent with nanos", units: 10, nanos: 500000000, rate: 2000, wantUnits: 1, wantNanos: 750000000},
+		{name: "fractional rate", units: 100, rate: 750, wantUnits: 6, wantNanos: 980000000},
+		{name: "zero amount", rate: 1600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			units, nanos := business.InclusiveTax(tt.units, tt.nanos, tt.rate)
+			require.Equal(t, tt.wantUnits, units)
+			require.Equal(t, tt.wantNanos, nanos)
+		})
+	}
+}
+
+func TestRenderInvoice_Golden(t *testing.T) {
+	_, orders, _ := documentFixture()
+	order := orders["o-1"]
+	order.TotalCurrency = "USD"
+	order.Lines[0].UnitPriceUnits, order.Lines[0].TotalPriceUnits = 12, 24
+	order.Lines[1].UnitPriceUnits, order.Lines[1].UnitPriceNanos = 30, 500000000
+	order.Lines[1].TotalPriceUnits, order.Lines[1].TotalPriceNanos = 30, 500000000
+	order.SubtotalUnits, order.SubtotalNanos = 54, 500000000
+	order.TotalUnits, order.TotalNanos = 54, 500000000
+
+	shop := &models.Shop{Name: "Corner Shop", Properties: data.JSONMap{
+		business.TaxNameKey: "VAT",
+		business.TaxRateKey: "16",
+	}}
+	issuedAt := time.Date(2026, 10, 18, 7, 45, 0, 0, time.UTC)
+
+	invoice := business.BuildInvoice(shop, order, issuedAt)
+	invoice.Number = "INV-2026-000042"
+	creditNote := business.BuildCreditNote(invoice, issuedAt.Add(24*time.Hour))
+	creditNote.Number = "CN-2026-000007"
+
+	for name, doc := range map[string]*models.Invoice{"invoice": invoice, "credit_note": creditNote} {
+		for _, format := range []business.DocumentFormat{business.DocumentFormatHTML, business.DocumentFormatPDF} {
+			t.Run(name+"."+string(format), func(t *testing.T) {
+				var got bytes.Buffer
+				require.NoError(t, business.RenderInvoice(&got, doc, format))
+
+				golden := filepath.Join("testdata", name+"."+string(format)+".golden")
+				if *updateGolden {
+					require.NoError(t, os.WriteFile(golden, got.Bytes(), 0o600))
+				}
+				want, err := os.ReadFile(golden)
+				require.NoError(t, err)
+				require.Equal(t, string(want), got.String())
+			})
+		}
+	}
+}
+
+func TestWebhookPolicy_Backoff(t *testing.T) {
+	policy := business.WebhookPolicy{BaseDelay: time.Minute, MaxDelay: time.Hour}
+
+	tests := []struct {
+		failures int32
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{7, time.Hour},
+		{40, time.Hour},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, policy.Backoff(tt.failures), "after %d failures", tt.failures)
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"id":"evt-1","type":"order.paid"}`)
+	signedAt := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	signature := business.SignWebhook("whsec_test", signedAt, body)
+
+	tests := []struct {
+		name    string
+		secret  string
+		header  string
+		body    []byte
+		now     time.Time
+		wantErr bool
+	}{
+		{name: "valid", secret: "whsec_test", header: signature, body: body, now: signedAt.Add(time.Minute)},
+		{name: "wrong secret", secret: "whsec_other", header: signature, body: body, now: signedAt, wantErr: true},
+		{name: "tampered body", secret: "whsec_test", header: signature, body: []byte(`{}`), now: signedAt, wantErr: true},
+		{name: "too old", secret: "whsec_test", header: signature, body: body, now: signedAt.Add(time.Hour), wantErr: true},
+		{name: "malformed", secret: "whsec_test", header: "v1=abc", body: body, now: signedAt, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := business.VerifyWebhookSignature(tt.secret, tt.header, tt.body, tt.now, 5*time.Minute)
+			if tt.wantErr {
+				require.ErrorIs(t, err, business.ErrWebhookSignature)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url           string
+		allowLoopback bool
+		wantErr       bool
+	}{
+		{url: "https://erp.example.com/hooks"},
+		{url: "http://127.0.0.1:8080/hooks", allowLoopback: true},
+		{url: "http://localhost/hooks", allowLoopback: true},
+		{url: "http://127.0.0.1:8080/hooks", wantErr: true},
+		{url: "https://localhost/hooks", wantErr: true},
+		{url: "https://[::1]/hooks", wantErr: true},
+		{url: "https://10.0.0.5/hooks", wantErr: true},
+		{url: "https://192.168.1.10/hooks", allowLoopback: true, wantErr: true},
+		{url: "https://169.254.169.254/latest/meta-data", wantErr: true},
+		{url: "https://[fe80::1]/hooks", wantErr: true},
+		{url: "https://0.0.0.0/hooks", wantErr: true},
+		{url: "http://erp.example.com/hooks", wantErr: true},
+		{url: "ftp://erp.example.com/hooks", wantErr: true},
+		{url: "/hooks", wantErr: true},
+	}
+	for _, tt := range tests {
+		err := business.ValidateWebhookURL(tt.url, tt.allowLoopback)
+		require.Equal(t, tt.wantErr, err != nil, tt.url)
+	}
+}
+
+func TestParseImportFile(t *testing.T) {
+	rows, err := business.ParseImportFile(business.ImportFormatCSV, []byte(
+		"\uFEFFProduct, sku, price, currency, attributes, media_ids, stock\n"+
+			"Mug,M-1,8.50,KES,colour=red; size = large,a|b,3\n"+
+			"\"Mug, tall\",M-2,9,KES,,,\n"))
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	require.Equal(t, business.ImportRow{
+		Row: 2, Product: "Mug", SKU: "M-1", Price: "8.50", Currency: "KES",
+		Attributes: map[string]string{"colour": "red", "size": "large"},
+		MediaIDs:   []string{"a", "b"}, Stock: "3",
+	}, rows[0])
+	require.Equal(t, 3, rows[1].Row)
+	require.Equal(t, "Mug, tall", rows[1].Product)
+
+	rows, err = business.ParseImportFile(business.ImportFormatJSON, []byte(
+		`[{"product":"Mug","sku":"M-1","price":8.5,"currency":"KES","attributes":{"colour":"red"},"stock":3}]`))
+	require.NoError(t, err)
+	require.Equal(t, business.ImportRow{
+		Row: 1, Product: "Mug", SKU: "M-1", Price: "8.5", Currency: "KES",
+		Attributes: map[string]string{"colour": "red"}, Stock: "3",
+	}, rows[0])
+
+	invalid := []struct {
+		name   string
+		format string
+		source string
+	}{
+		{name: "unknown format", format: "xml", source: "<rows/>"},
+		{name: "empty csv", format: business.ImportFormatCSV, source: ""},
+		{name: "header only", format: business.ImportFormatCSV, source: "product,sku,price,currency\n"},
+		{name: "missing column", format: business.ImportFormatCSV, source: "product,sku,price\nMug,M-1,1\n"},
+		{name: "unknown column", format: business.ImportFormatCSV, source: "product,sku,price,currency,colour\n"},
+		{name: "ragged row", format: business.ImportFormatCSV, source: "product,sku,price,currency\nMug,M-1\n"},
+		{name: "not an array", format: business.ImportFormatJSON, source: `{"product":"Mug"}`},
+		{name: "unknown field", format: business.ImportFormatJSON, source: `[{"product":"Mug","colour":"red"}]`},
+		{name: "empty array", format: business.ImportFormatJSON, source: `[]`},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := business.ParseImportFile(tt.format, []byte(tt.source))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestParseDecimalAmount(t *testing.T) {
+	tests := []struct {
+		value   string
+		units   int64
+		nanos   int32
+		wantErr bool
+	}{
+		{value: "12", units: 12},
+		{value: "12.5", units: 12, nanos: 500000000},
+		{value: "0.01", nanos: 10000000},
+		{value: "3.000000001", units: 3, nanos: 1},
+		{value: "", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "1.", wantErr: true},
+		{value: ".5", wantErr: true},
+		{value: "1,50", wantErr: true},
+		{value: "1.0000000001", wantErr: true},
+		{value: "99999999999999999999", wantErr: true},
+	}
+	for _, tt := range tests {
+		units, nanos, err := business.ParseDecimalAmount(tt.value)
+		if tt.wantErr {
+			require.Error(t, err, tt.value)
+			continue
+		}
+		require.NoError(t, err, tt.value)
+		require.Equal(t, tt.units, units, tt.value)
+		require.Equal(t, tt.nanos, nanos, tt.value)
+	}
+}
+
+func TestValidateCurrencyCode(t *testing.T) {
+	for _, code := range []string{"USD", "KES", "EUR"} {
+		require.NoError(t, business.ValidateCurrencyCode(code), code)
+	}
+	for _, code := range []string{"", "usd", "US", "USDT", "U5D", "ZZZ"} {
+		require.Error(t, business.ValidateCurrencyCode(code), code)
+	}
+}
+
+func TestParseExportFormat(t *testing.T) {
+	for name, want := range map[string]business.ExportFormat{
+		"": business.ExportFormatCSV, "CSV": business.ExportFormatCSV, "jsonl": business.ExportFormatJSONL,
+	} {
+		format, err := business.ParseExportFormat(name)
+		require.NoError(t, err, name)
+		require.Equal(t, want, format, name)
+	}
+	_, err := business.ParseExportFormat("xlsx")
+	require.Error(t, err)
+}
+
+func TestParseOrderStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    commercev1.OrderStatus
+		wantErr bool
+	}{
+		{name: "confirmed", want: commercev1.OrderStatus_ORDER_STATUS_CONFIRMED},
+		{name: " Cancelled ", want: commercev1.OrderStatus_ORDER_STATUS_CANCELLED},
+		{name: "ORDER_STATUS_FULFILLED", want: commercev1.OrderStatus_ORDER_STATUS_FULFILLED},
+		{name: "unspecified", wantErr: true},
+		{name: "shipped", wantErr: true},
+		{name: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		status, err := business.ParseOrderStatus(tt.name)
+		if tt.wantErr {
+			require.Error(t, err, tt.name)
+			continue
+		}
+		require.NoError(t, err, tt.name)
+		require.Equal(t, int32(tt.want), status, tt.name)
+	}
+}
+
+func TestParseReportPeriod(t *testing.T) {
+	for name, want := range map[string]business.ReportPeriod{
+		"":       business.ReportPeriodDay,
+		"day":    business.ReportPeriodDay,
+		" Week ": business.ReportPeriodWeek,
+		"MONTH":  business.ReportPeriodMonth,
+	} {
+		got, err := business.ParseReportPeriod(name)
+		require.NoError(t, err, name)
+		require.Equal(t, want, got, name)
+	}
+	_, err := business.ParseReportPeriod("year")
+	require.Error(t, err)
+}
+
+func TestSummariseSales(t *testing.T) {
+	day := func(date string) time.Time {
+		d, err := time.Parse(time.DateOnly, date)
+		require.NoError(t, err)
+		return d
+	}
+	days := []*models.SalesDailyRollup{
+		// Sunday 2026-03-01 and Monday 2026-03-02 fall in different weeks.
+		{Day: day("2026-03-01"), Currency: "USD", OrderCount: 2, GrossUnits: 10, GrossNanos: 500000000, UnitsSold: 1},
+		{
+			Day: day("2026-03-02"), Currency: "USD", OrderCount: 4, CancelledCount: 1, RefundedCount: 1,
+			GrossUnits: 30, GrossNanos: 700000000, RefundedUnits: 5, UnitsSold: 3,
+		},
+		{Day: day("2026-03-03"), Currency: "KES", OrderCount: 1, GrossUnits: 100, UnitsSold: 2},
+		{Day: day("2026-02-28"), Currency: "USD", OrderCount: 1, CancelledCount: 1},
+	}
+
+	weeks := business.SummariseSales(days, business.ReportPeriodWeek)
+	require.Len(t, weeks, 3)
+	require.Equal(t, day("2026-02-23"), weeks[0].Start)
+	require.Equal(t, "USD", weeks[0].Currency)
+	require.EqualValues(t, 3, weeks[0].OrderCount)
+	require.InDelta(t, 1.0/3, weeks[0].CancellationRate, 1e-9)
+	require.Equal(t, day("2026-03-02"), weeks[1].Start)
+	require.Equal(t, "KES", weeks[1].Currency)
+	require.Equal(t, "USD", weeks[2].Currency)
+	require.Equal(t, "25.70", business.DecimalAmount(weeks[2].Net.GetUnits(), weeks[2].Net.GetNanos()))
+	// 30.70 over the three orders not cancelled.
+	require.Equal(t, "10.233333333", business.DecimalAmount(
+		weeks[2].AverageOrderValue.GetUnits(), weeks[2].AverageOrderValue.GetNanos()))
+	require.InDelta(t, 1.0/3, weeks[2].RefundRate, 1e-9)
+
+	months := business.SummariseSales(days, business.ReportPeriodMonth)
+	require.Len(t, months, 3)
+	require.Equal(t, day("2026-02-01"), months[0].Start)
+	require.Equal(t, day("2026-03-01"), months[1].Start)
+	require.EqualValues(t, 6, months[2].OrderCount)
+	require.Equal(t, "41.20", business.DecimalAmount(months[2].Gross.GetUnits(), months[2].Gross.GetNanos()))
+
+	// A period of only cancelled orders has no average and no refund rate.
+	require.Zero(t, months[0].RefundRate)
+	require.Zero(t, months[0].AverageOrderValue.GetUnits())
+
+	require.Len(t, business.SummariseSales(days, business.ReportPeriodDay), 4)
+}
+
+func TestLineError(t *testing.T) {
+	tests := []struct {
+		name     string
+		problems []business.LineReason
+		code     connect.Code
+	}{
+		{"stock", []business.LineReason{business.PurchaseInsufficientStock}, connect.CodeFailedPrecondition},
+		{"inactive", []business.LineReason{
+			business.PurchaseShopInactive, business.PurchaseVariantInactive,
+		}, connect.CodeFailedPrecondition},
+		{"missing", []business.LineReason{
+			business.PurchaseProductInactive, business.PurchaseVariantNotFound,
+		}, connect.CodeNotFound},
+		{"malformed", []business.LineReason{
+			business.PurchaseVariantNotFound, business.PurchaseInvalidQuantity,
+		}, connect.CodeInvalidArgument},
+		{"wrong shop", []business.LineReason{business.PurchaseWrongShop}, connect.CodeInvalidArgument},
+		{"over remaining", []business.LineReason{business.FulfilmentOverRemaining}, connect.CodeFailedPrecondition},
+		{"unknown order line", []business.LineReason{
+			business.FulfilmentOverRemaining, business.FulfilmentLineNotFound,
+		}, connect.CodeInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := &business.LineError{}
+			for i, problem := range tt.problems {
+				err.Lines = append(err.Lines, business.LineProblem{Line: i, VariantID: "v1", Reason: problem})
+			}
+			require.Equal(t, tt.code, err.Code())
+		})
+	}
+
+	err := &business.LineError{Lines: []business.LineProblem{
+		{VariantID: "v1", Reason: business.PurchaseInsufficientStock, Requested: 3, Available: 1},
+		{Line: 1, VariantID: "v2", Reason: business.PurchaseVariantNotFound},
+	}}
+	require.Equal(t, "insufficient stock for variant v1: requested 3, available 1; variant v2 not found", err.Error())
+
+	err = &business.LineError{Lines: []business.LineProblem{
+		{OrderLineID: "ol1", Reason: business.FulfilmentOverRemaining, Requested: 4, Available: 2},
+	}}
+	require.Equal(t, "quantity 4 exceeds remaining unfulfilled quantity 2 for order line ol1", err.Error())
+}
+
+func TestLineProblems_SurviveCleanErr(t *testing.T) {
+	cerr := connect.NewError(connect.CodeFailedPrecondition, errors.New("insufficient stock"))
+	detail, err := connect.NewErrorDetail(&errdetails.ErrorInfo{
+		Reason: "INSUFFICIENT_STOCK",
+		Domain: business.ErrorDomain,
+		Metadata: map[string]string{
+			"line": "2", "variant_id": "v1", "requested": "5", "available": "3",
+			"currency": "KES", "price": "12.50",
+		},
 	})
+	require.NoError(t, err)
+	cerr.AddDetail(detail)
+	other, err := connect.NewErrorDetail(&errdetails.ErrorInfo{Reason: "OTHER", Domain: "example.com"})
+	require.NoError(t, err)
+	cerr.AddDetail(other)
+
+	want := business.LineProblem{
+		Line:      2,
+		VariantID: "v1",
+		Reason:    business.PurchaseInsufficientStock,
+		Requested: 5,
+		Available: 3,
+		Price:     &money.Money{CurrencyCode: "KES", Units: 12, Nanos: 500_000_000},
+	}
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"as returned", cerr},
+		{"wrapped", fmt.Errorf("create order: %w", cerr)},
+		{"converted", data.ErrorConvertToAPI(cerr)},
+		{"converted twice", data.ErrorConvertToAPI(data.ErrorConvertToAPI(cerr))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleaned := errorutil.CleanErr(tt.err)
+			require.Equal(t, connect.CodeFailedPrecondition, connect.CodeOf(cleaned))
+			require.Len(t, cleaned.Details(), 2)
+
+			problems, problemsErr := business.LineProblems(cleaned)
+			require.NoError(t, problemsErr)
+			require.Len(t, problems, 1)
+			require.Equal(t, want.VariantID, problems[0].VariantID)
+			require.Equal(t, want.Reason, problems[0].Reason)
+			require.Equal(t, want.Line, problems[0].Line)
+			require.Equal(t, want.Requested, problems[0].Requested)
+			require.Equal(t, want.Available, problems[0].Available)
+			require.True(t, proto.Equal(want.Price, problems[0].Price))
+		})
+	}
+
+	problems, err := business.LineProblems(errors.New("plain"))
+	require.NoError(t, err)
+	require.Empty(t, problems)
+}
+
+func TestValidateID(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"d1a2b3c4e5f6g7h8i9j0", true},
+		{"nonexistent-shop", true},
+		{"profile_123", true},
+		{"", false},
+		{"ab", false},
+		{strings.Repeat("a", 41), false},
+		{"Upper-Case", false},
+		{"has space", false},
+		{"semi;colon", false},
+	}
+	for _, tt := range tests {
+		err := business.ValidateID(tt.id)
+		if tt.valid {
+			require.NoError(t, err, tt.id)
+		} else {
+			require.Error(t, err, tt.id)
+		}
+	}
+}
+
+func TestValidateQuantity(t *testing.T) {
+	tests := []struct {
+		quantity      int64
+		validQuantity bool
+		validStock    bool
+	}{
+		{1, true, true},
+		{1000, true, true},
+		{0, false, true},
+		{-1, false, false},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.validQuantity, business.ValidateQuantity(tt.quantity) == nil, tt.quantity)
+		require.Equal(t, tt.validStock, business.ValidateStockQuantity(tt.quantity) == nil, tt.quantity)
+	}
+}
+
+func TestValidateMoney(t *testing.T) {
+	tests := []struct {
+		name       string
+		amount     *money.Money
+		validMoney bool
+		validPrice bool
+	}{
+		{"whole", &money.Money{CurrencyCode: "USD", Units: 12}, true, true},
+		{"fraction", &money.Money{CurrencyCode: "KES", Units: 12, Nanos: 500_000_000}, true, true},
+		{"zero", &money.Money{CurrencyCode: "EUR"}, true, true},
+		{"negative", &money.Money{CurrencyCode: "USD", Units: -1, Nanos: -500_000_000}, true, false},
+		{"negative nanos", &money.Money{CurrencyCode: "USD", Nanos: -1}, true, false},
+		{"missing", nil, false, false},
+		{"no currency", &money.Money{Units: 1}, false, false},
+		{"lower case currency", &money.Money{CurrencyCode: "usd", Units: 1}, false, false},
+		{"unknown currency", &money.Money{CurrencyCode: "ZZZ", Units: 1}, false, false},
+		{"nanos out of range", &money.Money{CurrencyCode: "USD", Nanos: 1_000_000_000}, false, false},
+		{"mixed signs", &money.Money{CurrencyCode: "USD", Units: 1, Nanos: -1}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.validMoney, business.ValidateMoney(tt.amount) == nil)
+			require.Equal(t, tt.validPrice, business.ValidatePrice(tt.amount) == nil)
+		})
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	const id = "d1a2b3c4e5f6g7h8i9j0"
+	price := &money.Money{CurrencyCode: "USD", Units: 10}
+
+	tests := []struct {
+		name   string
+		req    proto.Message
+		fields []string
+	}{
+		{"create shop", &commercev1.CreateShopRequest{Name: "Shop"}, nil},
+		{"get shop", &commercev1.GetShopRequest{Id: id}, nil},
+		{"get shop bad id", &commercev1.GetShopRequest{Id: "Bad Id"}, []string{"id"}},
+		{"update shop", &commercev1.UpdateShopRequest{Id: id, Name: "Shop"}, nil},
+		{"update shop bad id", &commercev1.UpdateShopRequest{Id: "x;drop-table"}, []string{"id"}},
+		{"create product", &commercev1.CreateProductRequest{ShopId: id, Name: "Mug"}, nil},
+		{"create product no shop", &commercev1.CreateProductRequest{Name: "Mug"}, []string{"shop_id"}},
+		{"get product", &commercev1.GetProductRequest{Id: id}, nil},
+		{"get product bad id", &commercev1.GetProductRequest{Id: "ID"}, []string{"id"}},
+		{"list products", &commercev1.ListProductsRequest{ShopId: id}, nil},
+		{"list products bad shop", &commercev1.ListProductsRequest{ShopId: "Shop!"}, []string{"shop_id"}},
+		{"create variant", &commercev1.CreateProductVariantRequest{
+			ProductId: id, Name: "Large", Price: price, StockQuantity: 5,
+		}, nil},
+		{"create variant without price", &commercev1.CreateProductVariantRequest{ProductId: id}, nil},
+		{"create variant bad price and stock", &commercev1.CreateProductVariantRequest{
+			ProductId: id, Price: &money.Money{CurrencyCode: "USD", Units: -10}, StockQuantity: -1,
+		}, []string{"price", "stock_quantity"}},
+		{"create variant unknown currency", &commercev1.CreateProductVariantRequest{
+			ProductId: id, Price: &money.Money{CurrencyCode: "ZZZ", Units: 10},
+		}, []string{"price"}},
+		{"update variant", &commercev1.UpdateProductVariantRequest{
+			VariantId: id, Price: price, StockQuantity: 2,
+		}, nil},
+		{"update variant bad stock", &commercev1.UpdateProductVariantRequest{
+			VariantId: id, StockQuantity: -2,
+		}, []string{"stock_quantity"}},
+		{"update variant stock outside mask", &commercev1.UpdateProductVariantRequest{
+			VariantId:     id,
+			Name:          "Small",
+			StockQuantity: -2,
+			UpdateMask:    &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+		}, nil},
+		{"update variant bad price", &commercev1.UpdateProductVariantRequest{
+			VariantId:  id,
+			Price:      &money.Money{CurrencyCode: "USD", Units: 1, Nanos: -1},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"price"}},
+		}, []string{"price"}},
+		{"create cart", &commercev1.CreateCartRequest{ShopId: id, ProfileId: id}, nil},
+		{"create cart no shop", &commercev1.CreateCartRequest{}, []string{"shop_id"}},
+		{"create cart bad profile", &commercev1.CreateCartRequest{
+			ShopId: id, ProfileId: "Profile 1",
+		}, []string{"profile_id"}},
+		{"get cart", &commercev1.GetCartRequest{Id: id}, nil},
+		{"get cart bad id", &commercev1.GetCartRequest{Id: "c"}, []string{"id"}},
+		{"add cart line", &commercev1.AddCartLineRequest{CartId: id, ProductVariantId: id, Quantity: 1}, nil},
+		{"add cart line bad", &commercev1.AddCartLineRequest{CartId: id}, []string{"product_variant_id", "quantity"}},
+		{"remove cart line", &commercev1.RemoveCartLineRequest{CartId: id, CartLineId: id}, nil},
+		{"remove cart line bad line", &commercev1.RemoveCartLineRequest{
+			CartId: id, CartLineId: "Line_1",
+		}, []string{"cart_line_id"}},
+		{"order from cart", &commercev1.CreateOrderFromCartRequest{
+			CartId: id, ProfileId: id, ContactId: id, AddressId: id,
+		}, nil},
+		{"order from cart bad address", &commercev1.CreateOrderFromCartRequest{
+			CartId: id, AddressId: "Home Address",
+		}, []string{"address_id"}},
+		{"create order", &commercev1.CreateOrderRequest{
+			ShopId: id, ProfileId: id, ContactId: id, AddressId: id,
+			Lines: []*commercev1.CreateOrderLine{{VariantId: id, Quantity: 2}},
+		}, nil},
+		{"create order no lines", &commercev1.CreateOrderRequest{ShopId: id}, []string{"lines"}},
+		{"create order bad lines", &commercev1.CreateOrderRequest{
+			ShopId: id,
+			Lines: []*commercev1.CreateOrderLine{
+				{VariantId: id, Quantity: 1},
+				{VariantId: "", Quantity: 0},
+			},
+		}, []string{"lines[1].variant_id", "lines[1].quantity"}},
+		{"get order", &commercev1.GetOrderRequest{Id: id}, nil},
+		{"get order bad id", &commercev1.GetOrderRequest{Id: "ORDER-1"}, []string{"id"}},
+		{"list orders", &commercev1.ListOrdersRequest{ShopId: id}, nil},
+		{"list orders no shop", &commercev1.ListOrdersRequest{}, []string{"shop_id"}},
+		{"create fulfilment", &commercev1.CreateFulfilmentRequest{
+			OrderId: id, Lines: []*commercev1.FulfilmentLine{{OrderLineId: id, Quantity: 1}},
+		}, nil},
+		{"create fulfilment no lines", &commercev1.CreateFulfilmentRequest{OrderId: id}, []string{"lines"}},
+		{"create fulfilment bad quantity", &commercev1.CreateFulfilmentRequest{
+			OrderId: id, Lines: []*commercev1.FulfilmentLine{{OrderLineId: id, Quantity: -1}},
+		}, []string{"lines[0].quantity"}},
+		{"update fulfilment", &commercev1.UpdateFulfilmentRequest{Id: id, Carrier: "dhl"}, nil},
+		{"update fulfilment bad id", &commercev1.UpdateFulfilmentRequest{Id: "F 1"}, []string{"id"}},
+		{"get fulfilment", &commercev1.GetFulfilmentRequest{Id: id}, nil},
+		{"get fulfilment no id", &commercev1.GetFulfilmentRequest{}, []string{"id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := business.ValidateRequest(tt.req)
+			if len(tt.fields) == 0 {
+				require.NoError(t, err)
+				// Valid requests meet the proto's own constraints as well.
+				require.NoError(t, protovalidate.Validate(tt.req))
+				return
+			}
+
+			require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+			var cerr *connect.Error
+			require.ErrorAs(t, err, &cerr)
+			require.Len(t, cerr.Details(), 1)
+			value, valueErr := cerr.Details()[0].Value()
+			require.NoError(t, valueErr)
+			badRequest, ok := value.(*errdetails.BadRequest)
+			require.True(t, ok)
+			var fields []string
+			for _, violation := range badRequest.GetFieldViolations() {
+				fields = append(fields, violation.GetField())
+			}
+			require.Equal(t, tt.fields, fields)
+		})
+	}
 }
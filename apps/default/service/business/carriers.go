@@ -0,0 +1,230 @@
+package business
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"github.com/pitabwire/util"
+	money "google.golang.org/genproto/googleapis/type/money"
+)
+
+// ErrInvalidWebhookSignature is returned when a carrier webhook is not signed
+// by the carrier.
+var ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+
+// CarrierProvider integrates a shipping carrier: rate quotes, shipping labels
+// and tracking, both polled and pushed through the carrier's webhooks.
+type CarrierProvider interface {
+	// Name identifies the carrier; it is stored as the fulfilment's carrier.
+	Name() string
+	QuoteRates(ctx context.Context, shipment Shipment) ([]RateQuote, error)
+	CreateLabel(ctx context.Context, shipment Shipment, service string) (*ShippingLabel, error)
+	Track(ctx context.Context, trackingNumber string) ([]TrackingUpdate, error)
+	// ParseWebhook verifies a webhook delivery and returns the tracking
+	// updates it carries, or ErrInvalidWebhookSignature.
+	ParseWebhook(header http.Header, body []byte) ([]TrackingUpdate, error)
+}
+
+// Shipment describes a fulfilment to a carrier.
+type Shipment struct {
+	FulfilmentID string
+	AddressID    string
+	Items        int64
+	Currency     string
+}
+
+// RateQuote is a carrier's price for shipping with one of its services.
+type RateQuote struct {
+	Carrier       string
+	Service       string
+	Amount        *money.Money
+	EstimatedDays int32
+}
+
+// ShippingLabel is a label bought from a carrier.
+type ShippingLabel struct {
+	TrackingNumber string
+	Service        string
+	ContentType    string
+	Content        []byte
+}
+
+// TrackingUpdate is one step of a shipment's journey as a carrier reports it.
+// Status is UNSPECIFIED for updates, such as delivery exceptions, that do not
+// move the fulfilment along.
+type TrackingUpdate struct {
+	EventID        string
+	TrackingNumber string
+	Status         commercev1.FulfilmentStatus
+	Description    string
+	Location       string
+	OccurredAt     time.Time
+}
+
+// CarrierRegistry holds the configured carriers by name.
+type CarrierRegistry map[string]CarrierProvider
+
+func NewCarrierRegistry(providers ...CarrierProvider) CarrierRegistry {
+	registry := make(CarrierRegistry, len(providers))
+	for _, provider := range providers {
+		registry[provider.Name()] = provider
+	}
+	return registry
+}
+
+// FakeCarrierName is the name the fake carrier registers under.
+const FakeCarrierName = "fake"
+
+// FakeCarrierSignatureHeader carries the fake carrier's webhook signature:
+// "sha256=" followed by the hex HMAC-SHA256 of the body.
+const FakeCarrierSignatureHeader = "X-Fake-Carrier-Signature"
+
+// FakeCarrier is a local carrier for development and tests. It quotes fixed
+// rates, issues labels without contacting anyone and remembers the tracking
+// updates it is told about.
+type FakeCarrier struct {
+	secret []byte
+
+	mu      sync.Mutex
+	updates map[string][]TrackingUpdate
+}
+
+func NewFakeCarrier(webhookSecret string) *FakeCarrier {
+	return &FakeCarrier{secret: []byte(webhookSecret), updates: map[string][]TrackingUpdate{}}
+}
+
+func (fc *FakeCarrier) Name() string {
+	return FakeCarrierName
+}
+
+// QuoteRates offers a standard and an express service priced per item.
+func (fc *FakeCarrier) QuoteRates(_ context.Context, shipment Shipment) ([]RateQuote, error) {
+	if shipment.Currency == "" {
+		return nil, errors.New("shipment currency is required")
+	}
+	items := max(shipment.Items, 1)
+	return []RateQuote{
+		{
+			Carrier:       FakeCarrierName,
+			Service:       "standard",
+			Amount:        &money.Money{CurrencyCode: shipment.Currency, Units: 5 + items},
+			EstimatedDays: 5,
+		},
+		{
+			Carrier:       FakeCarrierName,
+			Service:       "express",
+			Amount:        &money.Money{CurrencyCode: shipment.Currency, Units: 15 + 2*items},
+			EstimatedDays: 1,
+		},
+	}, nil
+}
+
+func (fc *FakeCarrier) CreateLabel(_ context.Context, shipment Shipment, service string) (*ShippingLabel, error) {
+	if service != "standard" && service != "express" {
+		return nil, fmt.Errorf("unknown service %q", service)
+	}
+	trackingNumber := "FK" + strings.ToUpper(util.RandomAlphaNumericString(12))
+	return &ShippingLabel{
+		TrackingNumber: trackingNumber,
+		Service:        service,
+		ContentType:    "text/plain; charset=utf-8",
+		Content: fmt.Appendf(nil, "FAKE CARRIER %s\nTracking: %s\nFulfilment: %s\n",
+			strings.ToUpper(service), trackingNumber, shipment.FulfilmentID),
+	}, nil
+}
+
+func (fc *FakeCarrier) Track(_ context.Context, trackingNumber string) ([]TrackingUpdate, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return append([]TrackingUpdate(nil), fc.updates[trackingNumber]...), nil
+}
+
+// ParseWebhook verifies the signature and decodes a fake carrier webhook. The
+// updates are remembered so Track returns them as well.
+func (fc *FakeCarrier) ParseWebhook(header http.Header, body []byte) ([]TrackingUpdate, error) {
+	signature, ok := strings.CutPrefix(header.Get(FakeCarrierSignatureHeader), "sha256=")
+	if !ok || len(fc.secret) == 0 {
+		return nil, ErrInvalidWebhookSignature
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(got, fc.sign(body)) {
+		return nil, ErrInvalidWebhookSignature
+	}
+
+	var payload FakeCarrierWebhook
+	if err = json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("decode webhook: %w", err)
+	}
+
+	updates := make([]TrackingUpdate, 0, len(payload.Events))
+	for _, event := range payload.Events {
+		if event.ID == "" || event.TrackingNumber == "" {
+			return nil, errors.New("webhook event id and tracking number are required")
+		}
+		updates = append(updates, TrackingUpdate{
+			EventID:        event.ID,
+			TrackingNumber: event.TrackingNumber,
+			Status:         fakeCarrierStatus(event.Status),
+			Description:    event.Description,
+			Location:       event.Location,
+			OccurredAt:     event.OccurredAt,
+		})
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for _, update := range updates {
+		fc.updates[update.TrackingNumber] = append(fc.updates[update.TrackingNumber], update)
+	}
+	return updates, nil
+}
+
+// Sign returns the signature header value for a webhook body.
+func (fc *FakeCarrier) Sign(body []byte) string {
+	return "sha256=" + hex.EncodeToString(fc.sign(body))
+}
+
+func (fc *FakeCarrier) sign(body []byte) []byte {
+	mac := hmac.New(sha256.New, fc.secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// FakeCarrierWebhook is the fake carrier's webhook payload.
+type FakeCarrierWebhook struct {
+	Events []FakeCarrierEvent `json:"events"`
+}
+
+// FakeCarrierEvent is one tracking event in a fake carrier webhook. Status is
+// one of label_created, in_transit, out_for_delivery, delivered or exception.
+type FakeCarrierEvent struct {
+	ID             string    `json:"id"`
+	TrackingNumber string    `json:"tracking_number"`
+	Status         string    `json:"status"`
+	Description    string    `json:"description"`
+	Location       string    `json:"location"`
+	OccurredAt     time.Time `json:"occurred_at"`
+}
+
+func fakeCarrierStatus(status string) commercev1.FulfilmentStatus {
+	switch status {
+	case "label_created":
+		return commercev1.FulfilmentStatus_FULFILMENT_STATUS_PACKED
+	case "in_transit", "out_for_delivery":
+		return commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED
+	case "delivered":
+		return commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED
+	default:
+		return commercev1.FulfilmentStatus_FULFILMENT_STATUS_UNSPECIFIED
+	}
+}
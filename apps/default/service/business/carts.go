@@ -25,21 +25,31 @@ func NewCartBusiness(
 	cartRepo repository.CartRepository,
 	cartLineRepo repository.CartLineRepository,
 	variantRepo repository.ProductVariantRepository,
+	productRepo repository.ProductRepository,
+	shopRepo repository.ShopRepository,
+	bundleRepo repository.BundleComponentRepository,
+	reservationRepo repository.SlotReservationRepository,
 ) CartBusiness {
 	return &cartBusiness{
-		cartRepo:     cartRepo,
-		cartLineRepo: cartLineRepo,
-		variantRepo:  variantRepo,
+		cartRepo:        cartRepo,
+		cartLineRepo:    cartLineRepo,
+		reservationRepo: reservationRepo,
+		purchase:        newPurchaseRules(shopRepo, productRepo, variantRepo, bundleRepo),
 	}
 }
 
 type cartBusiness struct {
-	cartRepo     repository.CartRepository
-	cartLineRepo repository.CartLineRepository
-	variantRepo  repository.ProductVariantRepository
+	cartRepo        repository.CartRepository
+	cartLineRepo    repository.CartLineRepository
+	reservationRepo repository.SlotReservationRepository
+	purchase        *purchaseRules
 }
 
 func (cb *cartBusiness) CreateCart(ctx context.Context, req *commercev1.CreateCartRequest) (*commercev1.Cart, error) {
+	if _, err := cb.purchase.shop(ctx, req.GetShopId()); err != nil {
+		return nil, err
+	}
+
 	cart := &models.Cart{
 		ShopID:    req.GetShopId(),
 		Status:    int32(commercev1.CartStatus_CART_STATUS_ACTIVE),
@@ -72,33 +82,47 @@ func (cb *cartBusiness) AddCartLine(ctx context.Context, req *commercev1.AddCart
 		return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("cart is not active"))
 	}
 
-	// Validate variant exists
-	_, variantErr := cb.variantRepo.GetByID(ctx, req.GetProductVariantId())
-	if variantErr != nil {
-		return nil, connect.NewError(connect.CodeNotFound, errors.New("product variant not found"))
+	// The stock check covers what the cart already holds of the variant.
+	existing, findErr := cb.cartLineRepo.GetByCartAndVariant(ctx, req.GetCartId(), req.GetProductVariantId())
+	if findErr != nil && !frame.ErrorIsNotFound(findErr) {
+		return nil, data.ErrorConvertToAPI(findErr)
+	}
+	if findErr != nil {
+		existing = nil
+	}
+	requested := req.GetQuantity()
+	if existing != nil && requested > 0 {
+		requested += existing.Quantity
 	}
 
-	if req.GetQuantity() <= 0 {
-		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("quantity must be positive"))
+	shop, err := cb.purchase.shop(ctx, cart.ShopID)
+	if err != nil {
+		return nil, err
+	}
+	// Booking products are added against a slot through
+	// BookingBusiness.AddBookingLine, so here they need one.
+	_, problem, err := cb.purchase.check(ctx, shop, purchaseLine{
+		VariantID: req.GetProductVariantId(),
+		Quantity:  requested,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if problem != nil {
+		return nil, lineError([]LineProblem{*problem})
 	}
 
-	// Check if line already exists for this variant
-	existing, findErr := cb.cartLineRepo.GetByCartAndVariant(ctx, req.GetCartId(), req.GetProductVariantId())
-	if findErr == nil && existing != nil {
-		// Update existing line quantity
-		existing.Quantity += req.GetQuantity()
+	if existing != nil {
+		existing.Quantity = requested
 		_, updateErr := cb.cartLineRepo.Update(ctx, existing, "quantity")
 		if updateErr != nil {
 			return nil, data.ErrorConvertToAPI(updateErr)
 		}
-	} else if findErr != nil && !frame.ErrorIsNotFound(findErr) {
-		return nil, data.ErrorConvertToAPI(findErr)
 	} else {
-		// Create new line
 		line := &models.CartLine{
 			CartID:           req.GetCartId(),
 			ProductVariantID: req.GetProductVariantId(),
-			Quantity:         req.GetQuantity(),
+			Quantity:         requested,
 		}
 		if createErr := cb.cartLineRepo.Create(ctx, line); createErr != nil {
 			return nil, data.ErrorConvertToAPI(createErr)
@@ -118,8 +142,21 @@ func (cb *cartBusiness) RemoveCartLine(ctx context.Context, req *commercev1.Remo
 		return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("cart is not active"))
 	}
 
-	if deleteErr := cb.cartLineRepo.Delete(ctx, req.GetCartLineId()); deleteErr != nil {
-		return nil, data.ErrorConvertToAPI(deleteErr)
+	// A booked line gives its places back to the slot; releasing the
+	// reservation also drops the line.
+	reservation, findErr := cb.reservationRepo.GetByCartLineID(ctx, req.GetCartLineId())
+	switch {
+	case findErr == nil:
+		if _, releaseErr := cb.reservationRepo.Release(ctx, reservation.GetID(),
+			[]int32{models.ReservationStatusHeld}); releaseErr != nil {
+			return nil, data.ErrorConvertToAPI(releaseErr)
+		}
+	case frame.ErrorIsNotFound(findErr):
+		if deleteErr := cb.cartLineRepo.Delete(ctx, req.GetCartLineId()); deleteErr != nil {
+			return nil, data.ErrorConvertToAPI(deleteErr)
+		}
+	default:
+		return nil, data.ErrorConvertToAPI(findErr)
 	}
 
 	return cb.GetCart(ctx, req.GetCartId())
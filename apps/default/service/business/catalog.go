@@ -3,9 +3,13 @@ package business
 import (
 	"context"
 	"errors"
+	"fmt"
+	"maps"
+	"strings"
 
 	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
 	"connectrpc.com/connect"
+	"github.com/pitabwire/frame"
 	"github.com/pitabwire/frame/data"
 
 	"github.com/antinvestor/service-commerce/apps/default/service/models"
@@ -18,7 +22,32 @@ type CatalogBusiness interface {
 	ListProducts(ctx context.Context, req *commercev1.ListProductsRequest) ([]*commercev1.Product, error)
 	CreateProductVariant(ctx context.Context, req *commercev1.CreateProductVariantRequest) (*commercev1.ProductVariant, error)
 	UpdateProductVariant(ctx context.Context, req *commercev1.UpdateProductVariantRequest) (*commercev1.ProductVariant, error)
+	SetVariantLocation(ctx context.Context, variantID, location string) error
 	ListProductVariants(ctx context.Context, productID string) ([]*commercev1.ProductVariant, error)
+	ListProductOptions(ctx context.Context, productID string) ([]*models.ProductOption, error)
+	SetProductOptions(ctx context.Context, productID string, options []ProductOptionInput) ([]*models.ProductOption, error)
+	AddProductOptionValue(
+		ctx context.Context, productID, optionName, value string, defaults VariantDefaults,
+	) (*VariantMatrixResult, error)
+	RemoveProductOptionValue(ctx context.Context, productID, optionName, value string) (*VariantMatrixResult, error)
+	GenerateVariantMatrix(ctx context.Context, productID string, defaults VariantDefaults) (*VariantMatrixResult, error)
+	GetProductBySlug(ctx context.Context, shopID, slug string) (*ProductSlugMatch, error)
+	RenameProduct(ctx context.Context, productID, name string) (*ProductSlugMatch, error)
+	ChangeProductSlug(ctx context.Context, productID, slug string) (*ProductSlugMatch, error)
+	SetBundleComponents(
+		ctx context.Context, bundleVariantID string, components []BundleComponentInput,
+	) ([]*models.BundleComponent, error)
+	ListBundleComponents(ctx context.Context, bundleVariantID string) ([]*models.BundleComponent, error)
+	BundleAvailability(ctx context.Context, bundleVariantID string) (int64, error)
+}
+
+// ProductSlugMatch is a product with its current slug, which the product
+// proto does not carry. Redirected is set when a lookup matched a retired
+// slug; callers should redirect to Slug.
+type ProductSlugMatch struct {
+	Product    *commercev1.Product
+	Slug       string
+	Redirected bool
 }
 
 func NewCatalogBusiness(
@@ -26,18 +55,30 @@ func NewCatalogBusiness(
 	productRepo repository.ProductRepository,
 	variantRepo repository.ProductVariantRepository,
 	shopRepo repository.ShopRepository,
+	sequenceRepo repository.ShopSequenceRepository,
+	optionRepo repository.ProductOptionRepository,
+	redirectRepo repository.SlugRedirectRepository,
+	bundleRepo repository.BundleComponentRepository,
 ) CatalogBusiness {
 	return &catalogBusiness{
-		productRepo: productRepo,
-		variantRepo: variantRepo,
-		shopRepo:    shopRepo,
+		productRepo:  productRepo,
+		variantRepo:  variantRepo,
+		shopRepo:     shopRepo,
+		sequenceRepo: sequenceRepo,
+		optionRepo:   optionRepo,
+		redirectRepo: redirectRepo,
+		bundleRepo:   bundleRepo,
 	}
 }
 
 type catalogBusiness struct {
-	productRepo repository.ProductRepository
-	variantRepo repository.ProductVariantRepository
-	shopRepo    repository.ShopRepository
+	productRepo  repository.ProductRepository
+	variantRepo  repository.ProductVariantRepository
+	shopRepo     repository.ShopRepository
+	sequenceRepo repository.ShopSequenceRepository
+	optionRepo   repository.ProductOptionRepository
+	redirectRepo repository.SlugRedirectRepository
+	bundleRepo   repository.BundleComponentRepository
 }
 
 func (cb *catalogBusiness) CreateProduct(ctx context.Context, req *commercev1.CreateProductRequest) (*commercev1.Product, error) {
@@ -47,18 +88,39 @@ func (cb *catalogBusiness) CreateProduct(ctx context.Context, req *commercev1.Cr
 		return nil, connect.NewError(connect.CodeNotFound, errors.New("shop not found"))
 	}
 
+	attributes := maps.Clone(req.GetAttributes())
+	fulfilmentType, err := ParseFulfilmentType(attributes[FulfilmentTypeAttribute])
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	delete(attributes, FulfilmentTypeAttribute)
+
 	product := &models.Product{
 		ShopID:         req.GetShopId(),
 		Name:           req.GetName(),
 		Description:    req.GetDescription(),
-		Attributes:     models.MapToJSONMap(req.GetAttributes()),
-		FulfilmentType: 0,
+		Attributes:     models.MapToJSONMap(attributes),
+		FulfilmentType: int32(fulfilmentType),
 		Status:         int32(commercev1.ProductStatus_PRODUCT_STATUS_ACTIVE),
 		MediaIDs:       models.StringArray(req.GetMediaIds()),
 	}
 
-	if createErr := cb.productRepo.Create(ctx, product); createErr != nil {
-		return nil, data.ErrorConvertToAPI(createErr)
+	base := Slugify(product.Name)
+	if base == "" {
+		base = slugEntityProduct
+	}
+	taken := func(ctx context.Context, slug string) (bool, error) {
+		return cb.productSlugTaken(ctx, product.ShopID, slug, "")
+	}
+	if _, err = claimSlug(ctx, base, taken, func(ctx context.Context, slug string) (bool, error) {
+		product.Slug = slug
+		inserted, createErr := cb.productRepo.TryCreate(ctx, product)
+		if createErr != nil {
+			return false, data.ErrorConvertToAPI(createErr)
+		}
+		return inserted, nil
+	}); err != nil {
+		return nil, err
 	}
 
 	return product.ToAPI(), nil
@@ -73,38 +135,70 @@ func (cb *catalogBusiness) GetProduct(ctx context.Context, id string) (*commerce
 }
 
 func (cb *catalogBusiness) ListProducts(ctx context.Context, req *commercev1.ListProductsRequest) ([]*commercev1.Product, error) {
-	limit := 50
-	offset := 0
-	if req.GetSearch() != nil && req.GetSearch().GetCursor() != nil {
-		if req.GetSearch().GetCursor().GetLimit() > 0 {
-			limit = int(req.GetSearch().GetCursor().GetLimit())
-		}
-	}
+	cursor := req.GetSearch().GetCursor()
+	limit, offset := pageBounds(cursor.GetLimit(), cursor.GetPage())
 
 	products, err := cb.productRepo.ListByShopID(ctx, req.GetShopId(), limit, offset)
 	if err != nil {
 		return nil, data.ErrorConvertToAPI(err)
 	}
 
-	result := make([]*commercev1.Product, 0, len(products))
-	for _, p := range products {
-		result = append(result, p.ToAPI())
-	}
-	return result, nil
+	return productsToAPI(products), nil
 }
 
 func (cb *catalogBusiness) CreateProductVariant(ctx context.Context, req *commercev1.CreateProductVariantRequest) (*commercev1.ProductVariant, error) {
 	// Validate product exists
-	_, err := cb.productRepo.GetByID(ctx, req.GetProductId())
+	product, err := cb.productRepo.GetByID(ctx, req.GetProductId())
 	if err != nil {
 		return nil, connect.NewError(connect.CodeNotFound, errors.New("product not found"))
 	}
 
+	if req.GetPrice() != nil {
+		if priceErr := ValidatePrice(req.GetPrice()); priceErr != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, priceErr)
+		}
+	}
+	if stockErr := ValidateStockQuantity(req.GetStockQuantity()); stockErr != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, stockErr)
+	}
+
+	options, err := cb.optionRepo.ListByProductID(ctx, product.GetID())
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if attrErr := cb.ensureCombinationAvailable(ctx, options, req.GetProductId(), req.GetAttributes(), ""); attrErr != nil {
+		return nil, attrErr
+	}
+
+	sku := strings.TrimSpace(req.GetSku())
+	if sku == "" {
+		template, ok, templateErr := cb.shopSKUTemplate(ctx, product.ShopID)
+		if templateErr != nil {
+			return nil, templateErr
+		}
+		if !ok {
+			return nil, connect.NewError(connect.CodeInvalidArgument,
+				errors.New("sku is required because the shop has no sku template"))
+		}
+		sku, err = cb.generateSKU(ctx, product, req.GetAttributes(), template)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if skuErr := ValidateSKU(sku); skuErr != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, skuErr)
+		}
+		if skuErr := cb.ensureSKUAvailable(ctx, product.ShopID, sku, ""); skuErr != nil {
+			return nil, skuErr
+		}
+	}
+
 	currency, units, nanos := models.MoneyFromProto(req.GetPrice())
 
 	variant := &models.ProductVariant{
 		ProductID:     req.GetProductId(),
-		SKU:           req.GetSku(),
+		ShopID:        product.ShopID,
+		SKU:           sku,
 		Name:          req.GetName(),
 		CurrencyCode:  currency,
 		PriceUnits:    units,
@@ -115,9 +209,13 @@ func (cb *catalogBusiness) CreateProductVariant(ctx context.Context, req *commer
 		Status:        int32(commercev1.ProductVariantStatus_PRODUCT_VARIANT_STATUS_ACTIVE),
 	}
 
-	if createErr := cb.variantRepo.Create(ctx, variant); createErr != nil {
+	created, createErr := cb.variantRepo.TryCreate(ctx, variant)
+	if createErr != nil {
 		return nil, data.ErrorConvertToAPI(createErr)
 	}
+	if !created {
+		return nil, skuInUse(sku)
+	}
 
 	return variant.ToAPI(), nil
 }
@@ -130,7 +228,11 @@ func (cb *catalogBusiness) ListProductVariants(ctx context.Context, productID st
 
 	result := make([]*commercev1.ProductVariant, 0, len(variants))
 	for _, v := range variants {
-		result = append(result, v.ToAPI())
+		apiVariant, apiErr := cb.variantToAPI(ctx, v)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		result = append(result, apiVariant)
 	}
 	return result, nil
 }
@@ -150,8 +252,14 @@ func (cb *catalogBusiness) UpdateProductVariant(ctx context.Context, req *commer
 	for _, field := range fields {
 		switch field {
 		case "sku":
-			if req.GetSku() != "" {
-				variant.SKU = req.GetSku()
+			if sku := strings.TrimSpace(req.GetSku()); sku != "" {
+				if skuErr := ValidateSKU(sku); skuErr != nil {
+					return nil, connect.NewError(connect.CodeInvalidArgument, skuErr)
+				}
+				if skuErr := cb.ensureSKUAvailable(ctx, variant.ShopID, sku, variant.GetID()); skuErr != nil {
+					return nil, skuErr
+				}
+				variant.SKU = sku
 				updateColumns = append(updateColumns, "sku")
 			}
 		case "name":
@@ -161,6 +269,9 @@ func (cb *catalogBusiness) UpdateProductVariant(ctx context.Context, req *commer
 			}
 		case "price":
 			if req.GetPrice() != nil {
+				if priceErr := ValidatePrice(req.GetPrice()); priceErr != nil {
+					return nil, connect.NewError(connect.CodeInvalidArgument, priceErr)
+				}
 				currency, units, nanos := models.MoneyFromProto(req.GetPrice())
 				variant.CurrencyCode = currency
 				variant.PriceUnits = units
@@ -168,6 +279,13 @@ func (cb *catalogBusiness) UpdateProductVariant(ctx context.Context, req *commer
 				updateColumns = append(updateColumns, "currency_code", "price_units", "price_nanos")
 			}
 		case "stock_quantity":
+			// A bundle's stock is derived from its components.
+			if variant.IsBundle() {
+				continue
+			}
+			if stockErr := ValidateStockQuantity(req.GetStockQuantity()); stockErr != nil {
+				return nil, connect.NewError(connect.CodeInvalidArgument, stockErr)
+			}
 			variant.StockQuantity = req.GetStockQuantity()
 			updateColumns = append(updateColumns, "stock_quantity")
 		case "status":
@@ -176,6 +294,15 @@ func (cb *catalogBusiness) UpdateProductVariant(ctx context.Context, req *commer
 				updateColumns = append(updateColumns, "status")
 			}
 		case "attributes":
+			options, optionsErr := cb.optionRepo.ListByProductID(ctx, variant.ProductID)
+			if optionsErr != nil {
+				return nil, data.ErrorConvertToAPI(optionsErr)
+			}
+			if attrErr := cb.ensureCombinationAvailable(
+				ctx, options, variant.ProductID, req.GetAttributes(), variant.GetID(),
+			); attrErr != nil {
+				return nil, attrErr
+			}
 			variant.Attributes = models.MapToJSONMap(req.GetAttributes())
 			updateColumns = append(updateColumns, "attributes")
 		case "media_ids":
@@ -191,5 +318,110 @@ func (cb *catalogBusiness) UpdateProductVariant(ctx context.Context, req *commer
 		}
 	}
 
-	return variant.ToAPI(), nil
+	return cb.variantToAPI(ctx, variant)
+}
+
+// maxLocationLength bounds a variant's warehouse location code.
+const maxLocationLength = 100
+
+// SetVariantLocation records where the variant is kept in the warehouse. An
+// empty location clears it.
+func (cb *catalogBusiness) SetVariantLocation(ctx context.Context, variantID, location string) error {
+	location = strings.TrimSpace(location)
+	if len(location) > maxLocationLength {
+		return connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("location must be at most %d characters", maxLocationLength))
+	}
+
+	variant, err := cb.variantRepo.GetByID(ctx, variantID)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	variant.Location = location
+	if _, err = cb.variantRepo.Update(ctx, variant, "location"); err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return nil
+}
+
+// ensureSKUAvailable fails when another variant of the shop already uses sku.
+func (cb *catalogBusiness) ensureSKUAvailable(ctx context.Context, shopID, sku, variantID string) error {
+	taken, err := cb.skuTaken(ctx, shopID, sku, variantID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return skuInUse(sku)
+	}
+	return nil
+}
+
+func (cb *catalogBusiness) skuTaken(ctx context.Context, shopID, sku, variantID string) (bool, error) {
+	existing, err := cb.variantRepo.GetBySKU(ctx, shopID, sku)
+	if err != nil {
+		if frame.ErrorIsNotFound(err) {
+			return false, nil
+		}
+		return false, data.ErrorConvertToAPI(err)
+	}
+	return existing.GetID() != variantID, nil
+}
+
+// shopSKUTemplate returns the SKU template configured on the shop, if any.
+func (cb *catalogBusiness) shopSKUTemplate(ctx context.Context, shopID string) (SKUTemplate, bool, error) {
+	shop, err := cb.shopRepo.GetByID(ctx, shopID)
+	if err != nil {
+		return SKUTemplate{}, false, data.ErrorConvertToAPI(err)
+	}
+	template, ok := skuTemplateFromShop(shop)
+	return template, ok, nil
+}
+
+// generateSKU renders the SKU template for a new variant, suffixing the
+// result until it is unique within the shop. {product} is the product's slug
+// rather than its display name, so reworded names do not change the SKUs
+// later variants get.
+func (cb *catalogBusiness) generateSKU(
+	ctx context.Context,
+	product *models.Product,
+	options map[string]string,
+	template SKUTemplate,
+) (string, error) {
+	var seq int64
+	if strings.Contains(template.Template, "{seq}") {
+		var err error
+		seq, err = cb.sequenceRepo.NextValue(ctx, product.ShopID, skuSequence)
+		if err != nil {
+			return "", data.ErrorConvertToAPI(err)
+		}
+	}
+
+	handle := product.Slug
+	if handle == "" {
+		handle = Slugify(product.Name)
+	}
+	base := template.Render(handle, options, seq)
+	if ValidateSKU(base) != nil {
+		return "", connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("sku template %q produced an invalid sku", template.Template))
+	}
+
+	candidate := base
+	for attempt := range maxSKUAttempts {
+		taken, takenErr := cb.skuTaken(ctx, product.ShopID, candidate, "")
+		if takenErr != nil {
+			return "", takenErr
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = withSKUSuffix(base, attempt+2) //nolint:mnd // suffixes start at -2
+	}
+
+	return "", connect.NewError(connect.CodeAlreadyExists,
+		fmt.Errorf("could not generate a unique sku from %s", base))
+}
+
+func skuInUse(sku string) error {
+	return connect.NewError(connect.CodeAlreadyExists, fmt.Errorf("sku %s is already in use in this shop", sku))
 }
@@ -0,0 +1,404 @@
+package business
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame"
+	"github.com/pitabwire/frame/data"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+const (
+	// FulfilmentTypeAttribute is the product attribute choosing how a product
+	// is fulfilled, one of "physical", "digital", "booking" or "none", until the proto
+	// carries the field. Products without it are physical.
+	FulfilmentTypeAttribute = "fulfilment_type"
+
+	// DefaultMaxDownloads and DefaultAccessDays apply to digital assets that
+	// do not set their own download allowance.
+	DefaultMaxDownloads = 5
+	DefaultAccessDays   = 30
+
+	downloadTokenBytes = 32
+	hoursPerDay        = 24
+)
+
+// ParseFulfilmentType reads the value of FulfilmentTypeAttribute.
+func ParseFulfilmentType(value string) (commercev1.FulfilmentType, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "physical":
+		return commercev1.FulfilmentType_FULFILMENT_TYPE_PHYSICAL, nil
+	case "digital":
+		return commercev1.FulfilmentType_FULFILMENT_TYPE_DIGITAL, nil
+	case "none":
+		return commercev1.FulfilmentType_FULFILMENT_TYPE_NONE, nil
+	case "booking":
+		return commercev1.FulfilmentType(models.FulfilmentTypeBooking), nil
+	}
+	return commercev1.FulfilmentType_FULFILMENT_TYPE_UNSPECIFIED,
+		fmt.Errorf("unknown fulfilment type %q", value)
+}
+
+// DigitalAssetInput names the file delivered for a variant and the allowance
+// granted per unit bought. Zero values take the defaults.
+type DigitalAssetInput struct {
+	FileID       string
+	MaxDownloads int32
+	AccessDays   int32
+}
+
+// DownloadToken is a plaintext download token. Only its hash is stored, so it
+// is returned once, when issued.
+type DownloadToken struct {
+	EntitlementID string
+	FileID        string
+	Token         string
+	ExpiresAt     time.Time
+}
+
+// DigitalDelivery is the outcome of fulfilling an order's digital lines.
+// Tokens is empty when the lines had been delivered before.
+type DigitalDelivery struct {
+	Fulfilment   *commercev1.Fulfilment
+	Entitlements []*models.Entitlement
+	Tokens       []*DownloadToken
+}
+
+type DigitalBusiness interface {
+	SetDigitalAsset(ctx context.Context, variantID string, input DigitalAssetInput) (*models.DigitalAsset, error)
+	CheckDeliverable(ctx context.Context, orderID string) error
+	FulfilOrder(ctx context.Context, orderID string) (*DigitalDelivery, error)
+	GetEntitlement(ctx context.Context, entitlementID string) (*models.Entitlement, error)
+	ListEntitlements(ctx context.Context, orderID string) ([]*models.Entitlement, error)
+	IssueDownloadToken(ctx context.Context, entitlementID string) (*DownloadToken, error)
+	RedeemDownloadToken(ctx context.Context, token string) (*models.Entitlement, error)
+}
+
+func NewDigitalBusiness(
+	_ context.Context,
+	orderRepo repository.OrderRepository,
+	productRepo repository.ProductRepository,
+	variantRepo repository.ProductVariantRepository,
+	fulfilmentRepo repository.FulfilmentRepository,
+	fulfilmentLineRepo repository.FulfilmentLineRepository,
+	assetRepo repository.DigitalAssetRepository,
+	entitlementRepo repository.EntitlementRepository,
+) DigitalBusiness {
+	return &digitalBusiness{
+		orderRepo:          orderRepo,
+		productRepo:        productRepo,
+		variantRepo:        variantRepo,
+		fulfilmentRepo:     fulfilmentRepo,
+		fulfilmentLineRepo: fulfilmentLineRepo,
+		assetRepo:          assetRepo,
+		entitlementRepo:    entitlementRepo,
+	}
+}
+
+type digitalBusiness struct {
+	orderRepo          repository.OrderRepository
+	productRepo        repository.ProductRepository
+	variantRepo        repository.ProductVariantRepository
+	fulfilmentRepo     repository.FulfilmentRepository
+	fulfilmentLineRepo repository.FulfilmentLineRepository
+	assetRepo          repository.DigitalAssetRepository
+	entitlementRepo    repository.EntitlementRepository
+}
+
+// SetDigitalAsset attaches the file delivered for a variant of a digital product.
+func (db *digitalBusiness) SetDigitalAsset(
+	ctx context.Context,
+	variantID string,
+	input DigitalAssetInput,
+) (*models.DigitalAsset, error) {
+	fileID := strings.TrimSpace(input.FileID)
+	if fileID == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("file id is required"))
+	}
+	if input.MaxDownloads < 0 || input.AccessDays < 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument,
+			errors.New("download allowance cannot be negative"))
+	}
+
+	variant, err := db.variantRepo.GetByID(ctx, variantID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, errors.New("variant not found"))
+	}
+	product, err := db.productRepo.GetByID(ctx, variant.ProductID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, errors.New("product not found"))
+	}
+	if product.FulfilmentType != int32(commercev1.FulfilmentType_FULFILMENT_TYPE_DIGITAL) {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("product is not digital"))
+	}
+
+	maxDownloads := input.MaxDownloads
+	if maxDownloads == 0 {
+		maxDownloads = DefaultMaxDownloads
+	}
+	accessDays := input.AccessDays
+	if accessDays == 0 {
+		accessDays = DefaultAccessDays
+	}
+
+	asset, err := db.assetRepo.GetByVariantID(ctx, variantID)
+	if err != nil {
+		if !frame.ErrorIsNotFound(err) {
+			return nil, data.ErrorConvertToAPI(err)
+		}
+		asset = &models.DigitalAsset{
+			ProductVariantID: variantID,
+			FileID:           fileID,
+			MaxDownloads:     maxDownloads,
+			AccessDays:       accessDays,
+		}
+		if createErr := db.assetRepo.Create(ctx, asset); createErr != nil {
+			return nil, data.ErrorConvertToAPI(createErr)
+		}
+		return asset, nil
+	}
+
+	asset.FileID = fileID
+	asset.MaxDownloads = maxDownloads
+	asset.AccessDays = accessDays
+	if _, err = db.assetRepo.Update(ctx, asset, "file_id", "max_downloads", "access_days"); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return asset, nil
+}
+
+// CheckDeliverable fails when a digital line of the order has no file to
+// deliver, so payment is not taken for an order that cannot be fulfilled.
+func (db *digitalBusiness) CheckDeliverable(ctx context.Context, orderID string) error {
+	order, err := db.orderRepo.GetWithLines(ctx, orderID)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	_, err = db.digitalLines(ctx, order)
+	return err
+}
+
+// FulfilOrder entitles the buyer of a paid order to the files of its digital
+// lines and records a delivered fulfilment for those lines, without a
+// carrier. Fulfilling an order a second time returns the existing
+// entitlements.
+func (db *digitalBusiness) FulfilOrder(ctx context.Context, orderID string) (*DigitalDelivery, error) {
+	order, err := db.orderRepo.GetWithLines(ctx, orderID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if order.PaymentStatus != int32(commercev1.PaymentStatus_PAYMENT_STATUS_PAID) {
+		return nil, connect.NewError(connect.CodeFailedPrecondition,
+			errors.New("digital lines are delivered once payment is captured"))
+	}
+
+	lines, err := db.digitalLines(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return &DigitalDelivery{}, nil
+	}
+
+	now := time.Now()
+	fulfilment := &models.Fulfilment{
+		OrderID: order.GetID(),
+		Status:  int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED),
+	}
+	fulfilment.CopyPartitionInfo(&order.BaseModel)
+
+	entitlements := make([]*models.Entitlement, 0, len(lines))
+	tokens := make([]*DownloadToken, 0, len(lines))
+	fulfilmentLines := make([]*models.FulfilmentLine, 0, len(lines))
+	for _, line := range lines {
+		token, hash, tokenErr := newDownloadToken()
+		if tokenErr != nil {
+			return nil, tokenErr
+		}
+
+		entitlement := &models.Entitlement{
+			ShopID:           order.ShopID,
+			OrderID:          order.GetID(),
+			OrderLineID:      line.orderLine.GetID(),
+			ProfileID:        order.ProfileID,
+			ProductVariantID: line.orderLine.ProductVariantID,
+			FileID:           line.asset.FileID,
+			TokenHash:        hash,
+			ExpiresAt:        now.Add(time.Duration(line.asset.AccessDays) * hoursPerDay * time.Hour),
+			// Each unit bought carries the asset's full allowance.
+			MaxDownloads: line.asset.MaxDownloads * int32(line.orderLine.Quantity), //nolint:gosec // quantities are small
+		}
+		entitlement.CopyPartitionInfo(&order.BaseModel)
+		entitlements = append(entitlements, entitlement)
+
+		tokens = append(tokens, &DownloadToken{
+			FileID:    entitlement.FileID,
+			Token:     token,
+			ExpiresAt: entitlement.ExpiresAt,
+		})
+		fulfilmentLines = append(fulfilmentLines, &models.FulfilmentLine{
+			OrderLineID: line.orderLine.GetID(),
+			Quantity:    line.orderLine.Quantity,
+		})
+	}
+
+	created, err := db.entitlementRepo.CreateWithFulfilment(ctx, entitlements, fulfilment, fulfilmentLines)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if !created {
+		existing, listErr := db.entitlementRepo.ListByOrderID(ctx, order.GetID())
+		if listErr != nil {
+			return nil, data.ErrorConvertToAPI(listErr)
+		}
+		return &DigitalDelivery{Entitlements: existing}, nil
+	}
+
+	for i, entitlement := range entitlements {
+		tokens[i].EntitlementID = entitlement.GetID()
+	}
+	if refreshErr := refreshOrderFulfilmentStatus(ctx, db.orderRepo, db.fulfilmentLineRepo, order); refreshErr != nil {
+		return nil, refreshErr
+	}
+
+	fulfilment.Lines = fulfilmentLines
+	return &DigitalDelivery{Fulfilment: fulfilment.ToAPI(), Entitlements: entitlements, Tokens: tokens}, nil
+}
+
+type digitalLine struct {
+	orderLine *models.OrderLine
+	asset     *models.DigitalAsset
+}
+
+// digitalLines picks the order lines whose product is fulfilled digitally,
+// with the asset each one delivers.
+func (db *digitalBusiness) digitalLines(ctx context.Context, order *models.Order) ([]digitalLine, error) {
+	digitalProducts := map[string]bool{}
+	var lines []digitalLine
+	for _, orderLine := range order.Lines {
+		variant, err := db.variantRepo.GetByID(ctx, orderLine.ProductVariantID)
+		if err != nil {
+			return nil, data.ErrorConvertToAPI(err)
+		}
+
+		digital, seen := digitalProducts[variant.ProductID]
+		if !seen {
+			product, productErr := db.productRepo.GetByID(ctx, variant.ProductID)
+			if productErr != nil {
+				return nil, data.ErrorConvertToAPI(productErr)
+			}
+			digital = product.FulfilmentType == int32(commercev1.FulfilmentType_FULFILMENT_TYPE_DIGITAL)
+			digitalProducts[variant.ProductID] = digital
+		}
+		if !digital {
+			continue
+		}
+
+		asset, err := db.assetRepo.GetByVariantID(ctx, variant.GetID())
+		if err != nil {
+			if frame.ErrorIsNotFound(err) {
+				return nil, connect.NewError(connect.CodeFailedPrecondition,
+					fmt.Errorf("digital variant %s has no file", variant.GetID()))
+			}
+			return nil, data.ErrorConvertToAPI(err)
+		}
+		lines = append(lines, digitalLine{orderLine: orderLine, asset: asset})
+	}
+	return lines, nil
+}
+
+func (db *digitalBusiness) GetEntitlement(ctx context.Context, entitlementID string) (*models.Entitlement, error) {
+	entitlement, err := db.entitlementRepo.GetByID(ctx, entitlementID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return entitlement, nil
+}
+
+func (db *digitalBusiness) ListEntitlements(ctx context.Context, orderID string) ([]*models.Entitlement, error) {
+	entitlements, err := db.entitlementRepo.ListByOrderID(ctx, orderID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return entitlements, nil
+}
+
+// IssueDownloadToken replaces the entitlement's download token, revoking the
+// previous one. Downloads already used still count against the allowance.
+func (db *digitalBusiness) IssueDownloadToken(ctx context.Context, entitlementID string) (*DownloadToken, error) {
+	entitlement, err := db.entitlementRepo.GetByID(ctx, entitlementID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if !entitlement.ExpiresAt.After(time.Now()) {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("entitlement has expired"))
+	}
+
+	token, hash, err := newDownloadToken()
+	if err != nil {
+		return nil, err
+	}
+	entitlement.TokenHash = hash
+	if _, err = db.entitlementRepo.Update(ctx, entitlement, "token_hash"); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	return &DownloadToken{
+		EntitlementID: entitlement.GetID(),
+		FileID:        entitlement.FileID,
+		Token:         token,
+		ExpiresAt:     entitlement.ExpiresAt,
+	}, nil
+}
+
+// RedeemDownloadToken counts one download against the token's entitlement
+// and returns it, so the caller can serve its FileID.
+func (db *digitalBusiness) RedeemDownloadToken(ctx context.Context, token string) (*models.Entitlement, error) {
+	hash := hashDownloadToken(token)
+	consumed, err := db.entitlementRepo.ConsumeDownload(ctx, hash, time.Now())
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	entitlement, err := db.entitlementRepo.GetByTokenHash(ctx, hash)
+	if err != nil {
+		if frame.ErrorIsNotFound(err) {
+			return nil, connect.NewError(connect.CodeNotFound, errors.New("download token is not valid"))
+		}
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if consumed {
+		return entitlement, nil
+	}
+	if !entitlement.ExpiresAt.After(time.Now()) {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("download token has expired"))
+	}
+	return nil, connect.NewError(connect.CodeResourceExhausted, errors.New("download limit reached"))
+}
+
+// newDownloadToken returns a random token and the hash stored for it.
+func newDownloadToken() (string, string, error) {
+	raw := make([]byte, downloadTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", connect.NewError(connect.CodeInternal, err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashDownloadToken(token), nil
+}
+
+func hashDownloadToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,509 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame/data"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+	"github.com/antinvestor/service-commerce/internal/pdf"
+)
+
+// MaxPickListFulfilments caps how many fulfilments one pick list batches.
+const MaxPickListFulfilments = 200
+
+// DocumentFormat is the format a warehouse document is rendered in.
+type DocumentFormat string
+
+const (
+	DocumentFormatPDF  DocumentFormat = "pdf"
+	DocumentFormatHTML DocumentFormat = "html"
+)
+
+// ParseDocumentFormat parses a format name; the empty name is PDF.
+func ParseDocumentFormat(name string) (DocumentFormat, error) {
+	switch DocumentFormat(strings.ToLower(strings.TrimSpace(name))) {
+	case "", DocumentFormatPDF:
+		return DocumentFormatPDF, nil
+	case DocumentFormatHTML:
+		return DocumentFormatHTML, nil
+	default:
+		return "", fmt.Errorf("unknown document format %q", name)
+	}
+}
+
+func (f DocumentFormat) ContentType() string {
+	if f == DocumentFormatHTML {
+		return "text/html; charset=utf-8"
+	}
+	return "application/pdf"
+}
+
+// PickList batches the items of many fulfilments so they can be picked in
+// one walk through the warehouse, grouped by location and SKU.
+type PickList struct {
+	ShopName     string
+	GeneratedAt  time.Time
+	OrderNumbers []string
+	Items        []PickListItem
+}
+
+// PickListItem is the total quantity of one SKU to pick from one location,
+// and the orders it is for.
+type PickListItem struct {
+	Location     string
+	SKU          string
+	Name         string
+	Quantity     int64
+	OrderNumbers []string
+}
+
+// PackingSlip lists what one fulfilment's parcel contains.
+type PackingSlip struct {
+	ShopName       string
+	OrderNumber    string
+	OrderedAt      time.Time
+	FulfilmentID   string
+	Carrier        string
+	TrackingNumber string
+	Lines          []PackingSlipLine
+}
+
+// PackingSlipLine is an order line in the parcel. Bundles list the items
+// packed for them as Contents.
+type PackingSlipLine struct {
+	SKU      string
+	Name     string
+	Quantity int64
+	Ordered  int64
+	Contents []PackingSlipLine
+}
+
+// DocumentBusiness gathers the documents warehouse staff print.
+type DocumentBusiness interface {
+	PickList(ctx context.Context, shopID string, fulfilmentIDs []string) (*PickList, error)
+	PackingSlip(ctx context.Context, fulfilmentID string) (*PackingSlip, error)
+}
+
+func NewDocumentBusiness(
+	_ context.Context,
+	shopRepo repository.ShopRepository,
+	orderRepo repository.OrderRepository,
+	variantRepo repository.ProductVariantRepository,
+	fulfilmentRepo repository.FulfilmentRepository,
+) DocumentBusiness {
+	return &documentBusiness{
+		shopRepo:       shopRepo,
+		orderRepo:      orderRepo,
+		variantRepo:    variantRepo,
+		fulfilmentRepo: fulfilmentRepo,
+	}
+}
+
+type documentBusiness struct {
+	shopRepo       repository.ShopRepository
+	orderRepo      repository.OrderRepository
+	variantRepo    repository.ProductVariantRepository
+	fulfilmentRepo repository.FulfilmentRepository
+}
+
+// pickableStatuses are the fulfilment statuses still waiting to be picked.
+var pickableStatuses = []int32{
+	int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_PENDING),
+	int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_PREPARING),
+}
+
+// PickList builds a pick list for the given fulfilments of the shop, or for
+// all of its fulfilments waiting to be picked when none are given.
+func (dcb *documentBusiness) PickList(ctx context.Context, shopID string, fulfilmentIDs []string) (*PickList, error) {
+	shop, err := dcb.shopRepo.GetByID(ctx, shopID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if len(fulfilmentIDs) > MaxPickListFulfilments {
+		return nil, connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("a pick list covers at most %d fulfilments", MaxPickListFulfilments))
+	}
+
+	var fulfilments []*models.Fulfilment
+	if len(fulfilmentIDs) == 0 {
+		fulfilments, err = dcb.fulfilmentRepo.ListByShopAndStatus(ctx, shopID, pickableStatuses, MaxPickListFulfilments)
+		if err != nil {
+			return nil, data.ErrorConvertToAPI(err)
+		}
+	}
+	for _, id := range fulfilmentIDs {
+		fulfilment, getErr := dcb.fulfilmentRepo.GetWithLines(ctx, id)
+		if getErr != nil {
+			return nil, data.ErrorConvertToAPI(getErr)
+		}
+		status := commercev1.FulfilmentStatus(fulfilment.Status)
+		if status == commercev1.FulfilmentStatus_FULFILMENT_STATUS_CANCELLED ||
+			fulfilmentStage(status) >= fulfilmentStage(commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED) {
+			return nil, connect.NewError(connect.CodeFailedPrecondition,
+				fmt.Errorf("fulfilment %s is %s and has nothing to pick", id, status))
+		}
+		fulfilments = append(fulfilments, fulfilment)
+	}
+
+	orders, err := dcb.loadOrders(ctx, fulfilments)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		if order.ShopID != shopID {
+			return nil, connect.NewError(connect.CodeInvalidArgument,
+				errors.New("fulfilments must belong to the shop"))
+		}
+	}
+
+	locations, err := dcb.loadLocations(ctx, orders)
+	if err != nil {
+		return nil, err
+	}
+	return BuildPickList(shop.Name, time.Now(), fulfilments, orders, locations), nil
+}
+
+func (dcb *documentBusiness) PackingSlip(ctx context.Context, fulfilmentID string) (*PackingSlip, error) {
+	fulfilment, err := dcb.fulfilmentRepo.GetWithLines(ctx, fulfilmentID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	order, err := dcb.orderRepo.GetWithLines(ctx, fulfilment.OrderID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	shop, err := dcb.shopRepo.GetByID(ctx, order.ShopID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return BuildPackingSlip(shop.Name, order, fulfilment), nil
+}
+
+// loadOrders loads the orders of the fulfilments, with their lines, by ID.
+func (dcb *documentBusiness) loadOrders(
+	ctx context.Context,
+	fulfilments []*models.Fulfilment,
+) (map[string]*models.Order, error) {
+	orders := make(map[string]*models.Order)
+	for _, fulfilment := range fulfilments {
+		if _, ok := orders[fulfilment.OrderID]; ok {
+			continue
+		}
+		order, err := dcb.orderRepo.GetWithLines(ctx, fulfilment.OrderID)
+		if err != nil {
+			return nil, data.ErrorConvertToAPI(err)
+		}
+		orders[order.GetID()] = order
+	}
+	return orders, nil
+}
+
+// loadLocations maps the variants the orders' lines pick, bundle components
+// included, to their warehouse locations.
+func (dcb *documentBusiness) loadLocations(
+	ctx context.Context,
+	orders map[string]*models.Order,
+) (map[string]string, error) {
+	var variantIDs []string
+	for _, order := range orders {
+		for _, line := range order.Lines {
+			variantIDs = append(variantIDs, line.ProductVariantID)
+			for _, item := range line.BundleComposition {
+				variantIDs = append(variantIDs, item.VariantID)
+			}
+		}
+	}
+	slices.Sort(variantIDs)
+
+	variants, err := dcb.variantRepo.ListByIDs(ctx, slices.Compact(variantIDs))
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	locations := make(map[string]string, len(variants))
+	for _, variant := range variants {
+		locations[variant.GetID()] = variant.Location
+	}
+	return locations, nil
+}
+
+// BuildPickList totals the fulfilments' lines per location and SKU, from the
+// order line snapshots. Bundles are picked as their components. Items are
+// ordered by location, with unlocated items last, then by SKU.
+func BuildPickList(
+	shopName string,
+	generatedAt time.Time,
+	fulfilments []*models.Fulfilment,
+	orders map[string]*models.Order,
+	locations map[string]string,
+) *PickList {
+	type pickKey struct{ location, sku, variantID string }
+
+	list := &PickList{ShopName: shopName, GeneratedAt: generatedAt}
+	items := map[pickKey]*PickListItem{}
+	add := func(variantID, sku, name string, quantity int64, orderNumber string) {
+		key := pickKey{location: locations[variantID], sku: sku, variantID: variantID}
+		item, ok := items[key]
+		if !ok {
+			item = &PickListItem{Location: key.location, SKU: sku, Name: name}
+			items[key] = item
+		}
+		item.Quantity += quantity
+		if !slices.Contains(item.OrderNumbers, orderNumber) {
+			item.OrderNumbers = append(item.OrderNumbers, orderNumber)
+		}
+	}
+
+	for _, fulfilment := range fulfilments {
+		order, ok := orders[fulfilment.OrderID]
+		if !ok {
+			continue
+		}
+		if !slices.Contains(list.OrderNumbers, order.OrderNumber) {
+			list.OrderNumbers = append(list.OrderNumbers, order.OrderNumber)
+		}
+		for _, fulfilled := range fulfilment.Lines {
+			line := findOrderLine(order, fulfilled.OrderLineID)
+			if line == nil {
+				continue
+			}
+			if len(line.BundleComposition) == 0 {
+				add(line.ProductVariantID, line.SKUSnapshot, line.NameSnapshot, fulfilled.Quantity, order.OrderNumber)
+				continue
+			}
+			for _, component := range line.BundleComposition {
+				add(component.VariantID, component.SKU, component.Name,
+					fulfilled.Quantity*component.Quantity, order.OrderNumber)
+			}
+		}
+	}
+
+	for _, item := range items {
+		slices.Sort(item.OrderNumbers)
+		list.Items = append(list.Items, *item)
+	}
+	slices.SortFunc(list.Items, func(a, b PickListItem) int {
+		if (a.Location == "") != (b.Location == "") {
+			if a.Location == "" {
+				return 1
+			}
+			return -1
+		}
+		if c := strings.Compare(a.Location, b.Location); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.SKU, b.SKU); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	slices.Sort(list.OrderNumbers)
+	return list
+}
+
+// BuildPackingSlip lists the fulfilment's lines in order line order, from
+// the order line snapshots.
+func BuildPackingSlip(shopName string, order *models.Order, fulfilment *models.Fulfilment) *PackingSlip {
+	slip := &PackingSlip{
+		ShopName:       shopName,
+		OrderNumber:    order.OrderNumber,
+		OrderedAt:      order.CreatedAt,
+		FulfilmentID:   fulfilment.GetID(),
+		Carrier:        fulfilment.Carrier,
+		TrackingNumber: fulfilment.TrackingNumber,
+	}
+
+	packed := make(map[string]int64, len(fulfilment.Lines))
+	for _, line := range fulfilment.Lines {
+		packed[line.OrderLineID] += line.Quantity
+	}
+
+	for _, line := range order.Lines {
+		quantity := packed[line.GetID()]
+		if quantity == 0 {
+			continue
+		}
+		slipLine := PackingSlipLine{
+			SKU:      line.SKUSnapshot,
+			Name:     line.NameSnapshot,
+			Quantity: quantity,
+			Ordered:  line.Quantity,
+		}
+		for _, component := range line.BundleComposition {
+			slipLine.Contents = append(slipLine.Contents, PackingSlipLine{
+				SKU:      component.SKU,
+				Name:     component.Name,
+				Quantity: quantity * component.Quantity,
+				Ordered:  line.Quantity * component.Quantity,
+			})
+		}
+		slip.Lines = append(slip.Lines, slipLine)
+	}
+	return slip
+}
+
+func findOrderLine(order *models.Order, lineID string) *models.OrderLine {
+	for _, line := range order.Lines {
+		if line.GetID() == lineID {
+			return line
+		}
+	}
+	return nil
+}
+
+// documentTime is how times are printed on documents.
+func documentTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+var documentTemplates = template.Must(template.New("documents").
+	Funcs(template.FuncMap{
+		"time": documentTime,
+		"join": strings.Join,
+	}).
+	Parse(`{{define "style"}}<style>
+body { font-family: Helvetica, Arial, sans-serif; font-size: 12px; margin: 24px; }
+h1 { font-size: 20px; margin: 0 0 4px; }
+p { margin: 2px 0; }
+table { border-collapse: collapse; width: 100%; margin-top: 16px; }
+th, td { border-bottom: 1px solid #ccc; padding: 4px 6px; text-align: left; }
+td.qty, th.qty { text-align: right; }
+tr.contents td { color: #555; }
+</style>{{end}}
+{{define "pick_list"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Pick list - {{.ShopName}}</title>
+{{template "style"}}
+</head>
+<body>
+<h1>Pick list</h1>
+<p>{{.ShopName}}</p>
+<p>Generated {{time .GeneratedAt}}</p>
+<p>Orders: {{join .OrderNumbers ", "}}</p>
+<table>
+<thead><tr><th>Location</th><th>SKU</th><th>Item</th><th class="qty">Qty</th><th>Orders</th></tr></thead>
+<tbody>
+{{- range .Items}}
+<tr><td>{{.Location}}</td><td>{{.SKU}}</td><td>{{.Name}}</td><td class="qty">{{.Quantity}}</td><td>{{join .OrderNumbers ", "}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+</body>
+</html>
+{{end}}
+{{define "packing_slip"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Packing slip - {{.OrderNumber}}</title>
+{{template "style"}}
+</head>
+<body>
+<h1>Packing slip</h1>
+<p>{{.ShopName}}</p>
+<p>Order {{.OrderNumber}}, placed {{time .OrderedAt}}</p>
+<p>Fulfilment {{.FulfilmentID}}</p>
+{{- if .TrackingNumber}}
+<p>{{.Carrier}} tracking {{.TrackingNumber}}</p>
+{{- end}}
+<table>
+<thead><tr><th>SKU</th><th>Item</th><th class="qty">Packed</th><th class="qty">Ordered</th></tr></thead>
+<tbody>
+{{- range .Lines}}
+<tr><td>{{.SKU}}</td><td>{{.Name}}</td><td class="qty">{{.Quantity}}</td><td class="qty">{{.Ordered}}</td></tr>
+{{- range .Contents}}
+<tr class="contents"><td>{{.SKU}}</td><td>&nbsp;&nbsp;{{.Name}}</td><td class="qty">{{.Quantity}}</td><td class="qty">{{.Ordered}}</td></tr>
+{{- end}}
+{{- end}}
+</tbody>
+</table>
+</body>
+</html>
+{{end}}`))
+
+// RenderPickList writes the pick list in the format.
+func RenderPickList(w io.Writer, list *PickList, format DocumentFormat) error {
+	if format == DocumentFormatHTML {
+		return documentTemplates.ExecuteTemplate(w, "pick_list", list)
+	}
+
+	doc := pdf.New()
+	doc.Row(pdf.HelveticaBold, 18, pdf.Cell{X: pdf.Margin, Text: "Pick list"})
+	doc.Row(pdf.Helvetica, 11, pdf.Cell{X: pdf.Margin, Text: list.ShopName})
+	doc.Row(pdf.Helvetica, 10, pdf.Cell{X: pdf.Margin, Text: "Generated " + documentTime(list.GeneratedAt)})
+	doc.Row(pdf.Helvetica, 10, pdf.Cell{X: pdf.Margin, Text: fmt.Sprintf("%d orders", len(list.OrderNumbers))})
+	doc.Space(12)
+
+	doc.Row(pdf.HelveticaBold, 10,
+		pdf.Cell{X: 50, Text: "Location"}, pdf.Cell{X: 120, Text: "SKU"}, pdf.Cell{X: 230, Text: "Item"},
+		pdf.Cell{X: 400, Text: "Qty"}, pdf.Cell{X: 440, Text: "Orders"})
+	for _, item := range list.Items {
+		doc.Row(pdf.Helvetica, 10,
+			pdf.Cell{X: 50, Text: clip(item.Location, 12)}, pdf.Cell{X: 120, Text: clip(item.SKU, 18)},
+			pdf.Cell{X: 230, Text: clip(item.Name, 30)}, pdf.Cell{X: 400, Text: strconv.FormatInt(item.Quantity, 10)},
+			pdf.Cell{X: 440, Text: clip(strings.Join(item.OrderNumbers, ", "), 22)})
+	}
+
+	_, err := doc.WriteTo(w)
+	return err
+}
+
+// RenderPackingSlip writes the packing slip in the format.
+func RenderPackingSlip(w io.Writer, slip *PackingSlip, format DocumentFormat) error {
+	if format == DocumentFormatHTML {
+		return documentTemplates.ExecuteTemplate(w, "packing_slip", slip)
+	}
+
+	doc := pdf.New()
+	doc.Row(pdf.HelveticaBold, 18, pdf.Cell{X: pdf.Margin, Text: "Packing slip"})
+	doc.Row(pdf.Helvetica, 11, pdf.Cell{X: pdf.Margin, Text: slip.ShopName})
+	doc.Row(pdf.Helvetica, 10, pdf.Cell{X: pdf.Margin,
+		Text: fmt.Sprintf("Order %s, placed %s", slip.OrderNumber, documentTime(slip.OrderedAt))})
+	doc.Row(pdf.Helvetica, 10, pdf.Cell{X: pdf.Margin, Text: "Fulfilment " + slip.FulfilmentID})
+	if slip.TrackingNumber != "" {
+		doc.Row(pdf.Helvetica, 10, pdf.Cell{X: pdf.Margin,
+			Text: fmt.Sprintf("%s tracking %s", slip.Carrier, slip.TrackingNumber)})
+	}
+	doc.Space(12)
+
+	doc.Row(pdf.HelveticaBold, 10,
+		pdf.Cell{X: 50, Text: "SKU"}, pdf.Cell{X: 170, Text: "Item"},
+		pdf.Cell{X: 430, Text: "Packed"}, pdf.Cell{X: 490, Text: "Ordered"})
+	for _, line := range slip.Lines {
+		doc.Row(pdf.Helvetica, 10,
+			pdf.Cell{X: 50, Text: clip(line.SKU, 20)}, pdf.Cell{X: 170, Text: clip(line.Name, 45)},
+			pdf.Cell{X: 430, Text: strconv.FormatInt(line.Quantity, 10)},
+			pdf.Cell{X: 490, Text: strconv.FormatInt(line.Ordered, 10)})
+		for _, item := range line.Contents {
+			doc.Row(pdf.Helvetica, 9,
+				pdf.Cell{X: 60, Text: clip(item.SKU, 20)}, pdf.Cell{X: 180, Text: clip(item.Name, 45)},
+				pdf.Cell{X: 430, Text: strconv.FormatInt(item.Quantity, 10)},
+				pdf.Cell{X: 490, Text: strconv.FormatInt(item.Ordered, 10)})
+		}
+	}
+
+	_, err := doc.WriteTo(w)
+	return err
+}
+
+// clip shortens text to fit a column of n characters.
+func clip(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-3]) + "..."
+}
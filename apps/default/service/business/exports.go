@@ -0,0 +1,332 @@
+package business
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame/data"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+// ExportFormat names the encoding of an export.
+type ExportFormat string
+
+const (
+	ExportFormatCSV   ExportFormat = "csv"
+	ExportFormatJSONL ExportFormat = "jsonl"
+)
+
+// exportPageSize is how many products or orders an export reads at a time.
+// Each page is written out before the next is read, so an export holds one
+// page in memory whatever the size of the shop.
+const exportPageSize = 200
+
+// ParseExportFormat parses a format name; the empty name is CSV.
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch ExportFormat(strings.ToLower(strings.TrimSpace(name))) {
+	case "", ExportFormatCSV:
+		return ExportFormatCSV, nil
+	case ExportFormatJSONL:
+		return ExportFormatJSONL, nil
+	default:
+		return "", fmt.Errorf("unknown export format %q", name)
+	}
+}
+
+func (f ExportFormat) ContentType() string {
+	if f == ExportFormatJSONL {
+		return "application/jsonl; charset=utf-8"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// ParseOrderStatus parses an order status by its proto name, with or without
+// the ORDER_STATUS_ prefix and in any case, such as "confirmed".
+func ParseOrderStatus(name string) (int32, error) {
+	const prefix = "ORDER_STATUS_"
+	key := strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(key, prefix) {
+		key = prefix + key
+	}
+	status, ok := commercev1.OrderStatus_value[key]
+	if !ok || status == int32(commercev1.OrderStatus_ORDER_STATUS_UNSPECIFIED) {
+		return 0, fmt.Errorf("unknown order status %q", name)
+	}
+	return status, nil
+}
+
+// catalogExportColumns are the fields of a catalog export, one row per
+// variant. A product without variants has a row with only its own fields.
+//
+//nolint:gochecknoglobals // static column list
+var catalogExportColumns = []string{
+	"product_id", "product", "slug", "product_status", "fulfilment_type",
+	"variant_id", "sku", "name", "variant_status", "kind",
+	"currency", "price", "stock", "location",
+}
+
+// orderExportColumns are the fields of an order export, one row per order
+// line with the order's fields repeated.
+//
+//nolint:gochecknoglobals // static column list
+var orderExportColumns = []string{
+	"order_id", "order_number", "created_at", "status", "payment_status", "fulfilment_status",
+	"profile_id", "currency", "subtotal", "total",
+	"line_id", "variant_id", "sku", "name", "quantity", "unit_price", "line_total",
+}
+
+// ExportBusiness streams a shop's catalog and orders out as CSV or JSON
+// lines. Exports read from the read replica.
+type ExportBusiness interface {
+	ExportCatalog(ctx context.Context, shopID string, format ExportFormat, w io.Writer) error
+	ExportOrders(ctx context.Context, filter repository.OrderExportFilter, format ExportFormat, w io.Writer) error
+}
+
+func NewExportBusiness(
+	_ context.Context,
+	productRepo repository.ProductRepository,
+	orderRepo repository.OrderRepository,
+	bundleRepo repository.BundleComponentRepository,
+) ExportBusiness {
+	return &exportBusiness{productRepo: productRepo, orderRepo: orderRepo, bundleRepo: bundleRepo}
+}
+
+type exportBusiness struct {
+	productRepo repository.ProductRepository
+	orderRepo   repository.OrderRepository
+	bundleRepo  repository.BundleComponentRepository
+}
+
+// ExportCatalog writes the shop's products and variants with their price and
+// stock. A bundle's stock is what its components allow.
+func (eb *exportBusiness) ExportCatalog(ctx context.Context, shopID string, format ExportFormat, w io.Writer) error {
+	if shopID == "" {
+		return connect.NewError(connect.CodeInvalidArgument, errors.New("shop_id is required"))
+	}
+	enc, err := newExportEncoder(w, format, catalogExportColumns)
+	if err != nil {
+		return err
+	}
+
+	afterID := ""
+	for {
+		products, listErr := eb.productRepo.ListForExport(ctx, shopID, afterID, exportPageSize)
+		if listErr != nil {
+			return data.ErrorConvertToAPI(listErr)
+		}
+		for _, product := range products {
+			if err = eb.writeProduct(ctx, enc, product); err != nil {
+				return err
+			}
+		}
+		if err = enc.Flush(); err != nil {
+			return err
+		}
+		if len(products) < exportPageSize {
+			return nil
+		}
+		afterID = products[len(products)-1].GetID()
+	}
+}
+
+func (eb *exportBusiness) writeProduct(ctx context.Context, enc *exportEncoder, product *models.Product) error {
+	productValues := []any{
+		product.GetID(), product.Name, product.Slug,
+		enumName(commercev1.ProductStatus(product.Status).String(), "PRODUCT_STATUS_"),
+		fulfilmentTypeName(product.FulfilmentType),
+	}
+	if len(product.Variants) == 0 {
+		return enc.Encode(append(productValues, nil, nil, nil, nil, nil, nil, nil, nil, nil))
+	}
+
+	for _, variant := range product.Variants {
+		kind := "standard"
+		stock := variant.StockQuantity
+		if variant.IsBundle() {
+			kind = "bundle"
+			components, err := eb.bundleRepo.ListByBundleID(ctx, variant.GetID())
+			if err != nil {
+				return data.ErrorConvertToAPI(err)
+			}
+			stock = BundleStock(components)
+		}
+		values := append(productValues[:len(productValues):len(productValues)],
+			variant.GetID(), variant.SKU, variant.Name,
+			enumName(commercev1.ProductVariantStatus(variant.Status).String(), "PRODUCT_VARIANT_STATUS_"),
+			kind, variant.CurrencyCode, DecimalAmount(variant.PriceUnits, variant.PriceNanos), stock,
+			variant.Location,
+		)
+		if err := enc.Encode(values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportOrders writes the orders matching filter with their lines, oldest
+// first.
+func (eb *exportBusiness) ExportOrders(
+	ctx context.Context,
+	filter repository.OrderExportFilter,
+	format ExportFormat,
+	w io.Writer,
+) error {
+	if filter.ShopID == "" {
+		return connect.NewError(connect.CodeInvalidArgument, errors.New("shop_id is required"))
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.To.After(filter.From) {
+		return connect.NewError(connect.CodeInvalidArgument, errors.New("to must be after from"))
+	}
+	enc, err := newExportEncoder(w, format, orderExportColumns)
+	if err != nil {
+		return err
+	}
+
+	afterID := ""
+	for {
+		orders, listErr := eb.orderRepo.ListForExport(ctx, filter, afterID, exportPageSize)
+		if listErr != nil {
+			return data.ErrorConvertToAPI(listErr)
+		}
+		for _, order := range orders {
+			if err = writeOrder(enc, order); err != nil {
+				return err
+			}
+		}
+		if err = enc.Flush(); err != nil {
+			return err
+		}
+		if len(orders) < exportPageSize {
+			return nil
+		}
+		afterID = orders[len(orders)-1].GetID()
+	}
+}
+
+func writeOrder(enc *exportEncoder, order *models.Order) error {
+	fulfilmentStatus := enumName(commercev1.FulfilmentStatus(order.FulfilmentStatus).String(), "FULFILMENT_STATUS_")
+	if order.FulfilmentStatus == models.FulfilmentStatusPartial {
+		fulfilmentStatus = "partial"
+	}
+	orderValues := []any{
+		order.GetID(), order.OrderNumber, order.CreatedAt.UTC().Format(time.RFC3339),
+		enumName(commercev1.OrderStatus(order.Status).String(), "ORDER_STATUS_"),
+		enumName(commercev1.PaymentStatus(order.PaymentStatus).String(), "PAYMENT_STATUS_"),
+		fulfilmentStatus, order.ProfileID, order.TotalCurrency,
+		DecimalAmount(order.SubtotalUnits, order.SubtotalNanos),
+		DecimalAmount(order.TotalUnits, order.TotalNanos),
+	}
+	for _, line := range order.Lines {
+		values := append(orderValues[:len(orderValues):len(orderValues)],
+			line.GetID(), line.ProductVariantID, line.SKUSnapshot, line.NameSnapshot, line.Quantity,
+			DecimalAmount(line.UnitPriceUnits, line.UnitPriceNanos),
+			DecimalAmount(line.TotalPriceUnits, line.TotalPriceNanos),
+		)
+		if err := enc.Encode(values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enumName shortens a proto enum value name to its lower case suffix, such
+// as "paid" for PAYMENT_STATUS_PAID.
+func enumName(name, prefix string) string {
+	return strings.ToLower(strings.TrimPrefix(name, prefix))
+}
+
+func fulfilmentTypeName(fulfilmentType int32) string {
+	if fulfilmentType == models.FulfilmentTypeBooking {
+		return "booking"
+	}
+	return enumName(commercev1.FulfilmentType(fulfilmentType).String(), "FULFILMENT_TYPE_")
+}
+
+// exportEncoder writes export rows as CSV, under a header row, or as one JSON
+// object per line keyed by column. Rows are buffered until Flush.
+type exportEncoder struct {
+	columns []string
+	csv     *csv.Writer
+	buf     *bufio.Writer
+}
+
+func newExportEncoder(w io.Writer, format ExportFormat, columns []string) (*exportEncoder, error) {
+	enc := &exportEncoder{columns: columns}
+	switch format {
+	case ExportFormatCSV:
+		enc.csv = csv.NewWriter(w)
+		if err := enc.csv.Write(columns); err != nil {
+			return nil, err
+		}
+	case ExportFormatJSONL:
+		enc.buf = bufio.NewWriter(w)
+	default:
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unknown export format %q", format))
+	}
+	return enc, nil
+}
+
+// Encode writes a row of values in column order. A nil value is an empty CSV
+// field and a JSON null.
+func (e *exportEncoder) Encode(values []any) error {
+	if e.csv != nil {
+		record := make([]string, len(values))
+		for i, value := range values {
+			record[i] = csvField(value)
+		}
+		return e.csv.Write(record)
+	}
+
+	if err := e.buf.WriteByte('{'); err != nil {
+		return err
+	}
+	for i, value := range values {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			_ = e.buf.WriteByte(',')
+		}
+		_, _ = e.buf.WriteString(strconv.Quote(e.columns[i]))
+		_ = e.buf.WriteByte(':')
+		_, _ = e.buf.Write(encoded)
+	}
+	_, err := e.buf.WriteString("}\n")
+	return err
+}
+
+// Flush writes out the buffered rows.
+func (e *exportEncoder) Flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		return e.csv.Error()
+	}
+	return e.buf.Flush()
+}
+
+func csvField(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
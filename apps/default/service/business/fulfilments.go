@@ -4,11 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
 	"connectrpc.com/connect"
 	"github.com/pitabwire/frame/data"
-	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/antinvestor/service-commerce/apps/default/service/models"
 	"github.com/antinvestor/service-commerce/apps/default/service/repository"
@@ -26,12 +27,16 @@ func NewFulfilmentBusiness(
 	fulfilmentLineRepo repository.FulfilmentLineRepository,
 	orderRepo repository.OrderRepository,
 	orderLineRepo repository.OrderLineRepository,
+	idempotencyRepo repository.IdempotencyRepository,
+	webhooks WebhookPublisher,
 ) FulfilmentBusiness {
 	return &fulfilmentBusiness{
 		fulfilmentRepo:     fulfilmentRepo,
 		fulfilmentLineRepo: fulfilmentLineRepo,
 		orderRepo:          orderRepo,
 		orderLineRepo:      orderLineRepo,
+		idempotency:        newIdempotencyGuard(idempotencyRepo),
+		webhooks:           webhooks,
 	}
 }
 
@@ -40,6 +45,8 @@ type fulfilmentBusiness struct {
 	fulfilmentLineRepo repository.FulfilmentLineRepository
 	orderRepo          repository.OrderRepository
 	orderLineRepo      repository.OrderLineRepository
+	idempotency        *idempotencyGuard
+	webhooks           WebhookPublisher
 }
 
 func (fb *fulfilmentBusiness) CreateFulfilment(ctx context.Context, req *commercev1.CreateFulfilmentRequest) (*commercev1.Fulfilment, error) {
@@ -49,6 +56,40 @@ func (fb *fulfilmentBusiness) CreateFulfilment(ctx context.Context, req *commerc
 		return nil, data.ErrorConvertToAPI(err)
 	}
 
+	scope := idempotencyScope{
+		Operation: operationCreateFulfilment,
+		ShopID:    order.ShopID,
+		Key:       IdempotencyKeyFromContext(ctx),
+	}
+
+	created := false
+	fulfilmentID, err := fb.idempotency.Do(ctx, scope, req, nil, func(ctx context.Context, _ string) (string, error) {
+		fulfilment, createErr := fb.createFulfilment(ctx, order, req)
+		if createErr != nil {
+			return "", createErr
+		}
+		created = true
+		return fulfilment.GetID(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fulfilment, err := fb.GetFulfilment(ctx, fulfilmentID)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		fb.webhooks.Publish(ctx, order.ShopID, EventFulfilmentCreated, fulfilment)
+	}
+	return fulfilment, nil
+}
+
+func (fb *fulfilmentBusiness) createFulfilment(
+	ctx context.Context,
+	order *models.Order,
+	req *commercev1.CreateFulfilmentRequest,
+) (*models.Fulfilment, error) {
 	if order.Status == int32(commercev1.OrderStatus_ORDER_STATUS_CANCELLED) {
 		return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("cannot fulfil a cancelled order"))
 	}
@@ -63,12 +104,15 @@ func (fb *fulfilmentBusiness) CreateFulfilment(ctx context.Context, req *commerc
 		orderLineMap[ol.GetID()] = ol
 	}
 
-	// Validate fulfilment lines
-	for _, fl := range req.GetLines() {
+	// Validate fulfilment lines, reporting every line that cannot be fulfilled
+	var problems []LineProblem
+	for i, fl := range req.GetLines() {
 		ol, ok := orderLineMap[fl.GetOrderLineId()]
 		if !ok {
-			return nil, connect.NewError(connect.CodeInvalidArgument,
-				fmt.Errorf("order line %s not found in order", fl.GetOrderLineId()))
+			problems = append(problems, LineProblem{
+				Line: i, OrderLineID: fl.GetOrderLineId(), Reason: FulfilmentLineNotFound,
+			})
+			continue
 		}
 
 		// Check remaining unfulfilled quantity
@@ -79,11 +123,20 @@ func (fb *fulfilmentBusiness) CreateFulfilment(ctx context.Context, req *commerc
 
 		remaining := ol.Quantity - fulfilledQty
 		if fl.GetQuantity() > remaining {
-			return nil, connect.NewError(connect.CodeFailedPrecondition,
-				fmt.Errorf("quantity %d exceeds remaining unfulfilled quantity %d for order line %s",
-					fl.GetQuantity(), remaining, fl.GetOrderLineId()))
+			problems = append(problems, LineProblem{
+				Line:        i,
+				VariantID:   ol.ProductVariantID,
+				OrderLineID: ol.GetID(),
+				Reason:      FulfilmentOverRemaining,
+				Requested:   fl.GetQuantity(),
+				Available:   remaining,
+				Price:       models.MoneyToProto(ol.UnitPriceCurrency, ol.UnitPriceUnits, ol.UnitPriceNanos),
+			})
 		}
 	}
+	if err := lineError(problems); err != nil {
+		return nil, err
+	}
 
 	// Create fulfilment
 	fulfilment := &models.Fulfilment{
@@ -107,10 +160,7 @@ func (fb *fulfilmentBusiness) CreateFulfilment(ctx context.Context, req *commerc
 		}
 	}
 
-	// Check if order is fully fulfilled and update status
-	fb.updateOrderFulfilmentStatus(ctx, order)
-
-	return fb.GetFulfilment(ctx, fulfilment.GetID())
+	return fulfilment, nil
 }
 
 func (fb *fulfilmentBusiness) UpdateFulfilment(ctx context.Context, req *commercev1.UpdateFulfilmentRequest) (*commercev1.Fulfilment, error) {
@@ -129,8 +179,12 @@ func (fb *fulfilmentBusiness) UpdateFulfilment(ctx context.Context, req *commerc
 		switch field {
 		case "status":
 			if req.GetStatus() != commercev1.FulfilmentStatus_FULFILMENT_STATUS_UNSPECIFIED {
-				fulfilment.Status = int32(req.GetStatus())
-				updateColumns = append(updateColumns, "status")
+				transitionErr := ValidateFulfilmentTransition(commercev1.FulfilmentStatus(fulfilment.Status), req.GetStatus())
+				if transitionErr != nil {
+					return nil, connect.NewError(connect.CodeFailedPrecondition, transitionErr)
+				}
+				updateColumns = appendColumns(updateColumns,
+					advanceFulfilment(fulfilment, req.GetStatus(), time.Now())...)
 			}
 		case "carrier":
 			if req.GetCarrier() != "" {
@@ -143,9 +197,10 @@ func (fb *fulfilmentBusiness) UpdateFulfilment(ctx context.Context, req *commerc
 				updateColumns = append(updateColumns, "tracking_number")
 			}
 		case "shipped_at":
-			if req.GetShippedAt() != nil && req.GetShippedAt() != (&timestamppb.Timestamp{}) {
-				// shipped_at is tracked via the status transition to SHIPPED
-				updateColumns = append(updateColumns, "modified_at")
+			if req.GetShippedAt().IsValid() {
+				shippedAt := req.GetShippedAt().AsTime()
+				fulfilment.ShippedAt = &shippedAt
+				updateColumns = appendColumns(updateColumns, "shipped_at")
 			}
 		}
 	}
@@ -159,11 +214,21 @@ func (fb *fulfilmentBusiness) UpdateFulfilment(ctx context.Context, req *commerc
 
 	// If status changed, check if order fulfilment status needs updating
 	order, orderErr := fb.orderRepo.GetWithLines(ctx, fulfilment.OrderID)
-	if orderErr == nil {
-		fb.updateOrderFulfilmentStatus(ctx, order)
+	if orderErr != nil {
+		return nil, data.ErrorConvertToAPI(orderErr)
+	}
+	if refreshErr := refreshOrderFulfilmentStatus(ctx, fb.orderRepo, fb.fulfilmentLineRepo, order); refreshErr != nil {
+		return nil, refreshErr
 	}
 
-	return fb.GetFulfilment(ctx, req.GetId())
+	updated, err := fb.GetFulfilment(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if len(updateColumns) > 0 {
+		fb.webhooks.Publish(ctx, order.ShopID, EventFulfilmentUpdated, updated)
+	}
+	return updated, nil
 }
 
 func (fb *fulfilmentBusiness) GetFulfilment(ctx context.Context, id string) (*commercev1.Fulfilment, error) {
@@ -174,19 +239,140 @@ func (fb *fulfilmentBusiness) GetFulfilment(ctx context.Context, id string) (*co
 	return fulfilment.ToAPI(), nil
 }
 
-func (fb *fulfilmentBusiness) updateOrderFulfilmentStatus(ctx context.Context, order *models.Order) {
-	allFulfilled := true
-	for _, ol := range order.Lines {
-		fulfilledQty, err := fb.fulfilmentLineRepo.GetFulfilledQuantityByOrderLineID(ctx, ol.GetID())
-		if err != nil || fulfilledQty < ol.Quantity {
-			allFulfilled = false
-			break
+// advanceFulfilment moves the fulfilment to status, stamping the time it
+// shipped and was delivered the first time it gets there, and returns the
+// columns it changed. The transition must already be validated.
+func advanceFulfilment(fulfilment *models.Fulfilment, status commercev1.FulfilmentStatus, at time.Time) []string {
+	fulfilment.Status = int32(status)
+	columns := []string{"status"}
+
+	stage := fulfilmentStage(status)
+	if stage >= fulfilmentStage(commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED) && fulfilment.ShippedAt == nil {
+		fulfilment.ShippedAt = &at
+		columns = append(columns, "shipped_at")
+	}
+	if stage >= fulfilmentStage(commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED) && fulfilment.DeliveredAt == nil {
+		fulfilment.DeliveredAt = &at
+		columns = append(columns, "delivered_at")
+	}
+	return columns
+}
+
+// appendColumns appends the columns not already listed.
+func appendColumns(columns []string, more ...string) []string {
+	for _, column := range more {
+		if !slices.Contains(columns, column) {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// ValidateFulfilmentTransition checks a fulfilment may move from one status
+// to another. Fulfilments only move forward through preparing, packed,
+// shipped and delivered, possibly skipping steps, and can be cancelled until
+// they ship. Delivered and cancelled fulfilments are final.
+func ValidateFulfilmentTransition(from, to commercev1.FulfilmentStatus) error {
+	if from == to {
+		return nil
+	}
+
+	switch to {
+	case commercev1.FulfilmentStatus_FULFILMENT_STATUS_CANCELLED:
+		if fulfilmentStage(from) > 0 && fulfilmentStage(from) < fulfilmentStage(commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED) {
+			return nil
+		}
+	default:
+		if fulfilmentStage(from) > 0 && fulfilmentStage(to) > fulfilmentStage(from) {
+			return nil
+		}
+	}
+	return fmt.Errorf("fulfilment cannot move from %s to %s", from, to)
+}
+
+// fulfilmentStage orders the forward fulfilment statuses; other statuses are 0.
+func fulfilmentStage(status commercev1.FulfilmentStatus) int {
+	switch status {
+	case commercev1.FulfilmentStatus_FULFILMENT_STATUS_PENDING:
+		return 1
+	case commercev1.FulfilmentStatus_FULFILMENT_STATUS_PREPARING:
+		return 2
+	case commercev1.FulfilmentStatus_FULFILMENT_STATUS_PACKED:
+		return 3
+	case commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED:
+		return 4
+	case commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED:
+		return 5
+	default:
+		return 0
+	}
+}
+
+// AggregateFulfilmentStatus derives an order's fulfilment status from the
+// state of its fulfilments: unfulfilled (UNSPECIFIED) until something ships,
+// models.FulfilmentStatusPartial while only part of the order has shipped
+// (reported as SHIPPED through the API), then SHIPPED and finally DELIVERED.
+// Allocations in pending, preparing or packed fulfilments do not count, and
+// cancelled ones hold nothing. Booked lines are fulfilled by the booking
+// itself and are left out.
+func AggregateFulfilmentStatus(lines []*models.OrderLine, quantities []repository.FulfilmentQuantity) int32 {
+	shipped := make(map[string]int64, len(lines))
+	delivered := make(map[string]int64, len(lines))
+	for _, q := range quantities {
+		switch commercev1.FulfilmentStatus(q.Status) {
+		case commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED:
+			delivered[q.OrderLineID] += q.Quantity
+			shipped[q.OrderLineID] += q.Quantity
+		case commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED:
+			shipped[q.OrderLineID] += q.Quantity
+		default:
 		}
 	}
 
-	if allFulfilled {
-		order.Status = int32(commercev1.OrderStatus_ORDER_STATUS_FULFILLED)
-		order.FulfilmentStatus = int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED)
-		_, _ = fb.orderRepo.Update(ctx, order, "status", "fulfilment_status")
+	counted, anyShipped, allShipped, allDelivered := 0, false, true, true
+	for _, line := range lines {
+		if line.SlotID != "" {
+			continue
+		}
+		counted++
+		anyShipped = anyShipped || shipped[line.GetID()] > 0
+		allShipped = allShipped && shipped[line.GetID()] >= line.Quantity
+		allDelivered = allDelivered && delivered[line.GetID()] >= line.Quantity
+	}
+
+	switch {
+	case counted == 0 || !anyShipped:
+		return int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_UNSPECIFIED)
+	case allDelivered:
+		return int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED)
+	case allShipped:
+		return int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED)
+	default:
+		return models.FulfilmentStatusPartial
+	}
+}
+
+// refreshOrderFulfilmentStatus recomputes the order's aggregate fulfilment
+// status, marking the order fulfilled once everything is delivered.
+func refreshOrderFulfilmentStatus(
+	ctx context.Context,
+	orderRepo repository.OrderRepository,
+	fulfilmentLineRepo repository.FulfilmentLineRepository,
+	order *models.Order,
+) error {
+	quantities, err := fulfilmentLineRepo.ListQuantitiesByOrderID(ctx, order.GetID())
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+
+	status := AggregateFulfilmentStatus(order.Lines, quantities)
+	fulfilled := status == int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED)
+	if status == order.FulfilmentStatus && !fulfilled {
+		return nil
+	}
+
+	if err = orderRepo.SetFulfilmentStatus(ctx, order.GetID(), status, fulfilled); err != nil {
+		return data.ErrorConvertToAPI(err)
 	}
+	return nil
 }
@@ -0,0 +1,270 @@
+package business
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame"
+	"github.com/pitabwire/frame/data"
+	"github.com/pitabwire/util"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+// IdempotencyKeyHeader is the request header carrying the idempotency key for
+// operations whose request message has no idempotency_key field.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const (
+	// DefaultIdempotencyTTL is how long a completed request can be replayed.
+	DefaultIdempotencyTTL = 24 * time.Hour
+	// idempotencyLockTimeout is how long an unfinished claim blocks retries
+	// before it is considered abandoned by a crashed request.
+	idempotencyLockTimeout = 5 * time.Minute
+	// expiredIdempotencyBatchSize bounds how many expired claims one delete removes.
+	expiredIdempotencyBatchSize = 500
+
+	operationCreateOrder         = "create_order"
+	operationCreateOrderFromCart = "create_order_from_cart"
+	operationCreateFulfilment    = "create_fulfilment"
+)
+
+type idempotencyKeyCtxKey struct{}
+
+// IdempotencyKeyToContext stores an idempotency key supplied out of band,
+// typically through the Idempotency-Key header.
+func IdempotencyKeyToContext(ctx context.Context, key string) context.Context {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, idempotencyKeyCtxKey{}, key)
+}
+
+// IdempotencyKeyFromContext returns the idempotency key stored in the context, if any.
+func IdempotencyKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyCtxKey{}).(string)
+	return key
+}
+
+// idempotencyScope identifies a key: it is unique per shop, caller and operation.
+type idempotencyScope struct {
+	Operation string
+	ShopID    string
+	Key       string
+}
+
+// idempotencyGuard executes mutating operations at most once per scoped key.
+// A replay with the same payload returns the resource produced by the first
+// execution; a replay with a different payload is rejected.
+type idempotencyGuard struct {
+	repo repository.IdempotencyRepository
+	ttl  time.Duration
+}
+
+func newIdempotencyGuard(repo repository.IdempotencyRepository) *idempotencyGuard {
+	return &idempotencyGuard{repo: repo, ttl: DefaultIdempotencyTTL}
+}
+
+// idempotencyLookup finds the resource an earlier run under the claim
+// produced, given the ID the claim reserved for it, returning an empty ID when
+// there is none.
+type idempotencyLookup func(ctx context.Context, reservedID string) (string, error)
+
+// Do runs fn unless the scoped key was already used, returning the ID of the
+// resource fn produced. fn is given the ID its claim reserved for the
+// resource, empty when the key is empty and fn always runs. lookup, when
+// given, is asked for the resource before an abandoned claim is run again, so
+// a request that crashed after creating it but before recording it is
+// replayed rather than repeated.
+func (g *idempotencyGuard) Do(
+	ctx context.Context,
+	scope idempotencyScope,
+	req proto.Message,
+	lookup idempotencyLookup,
+	fn func(ctx context.Context, reservedID string) (string, error),
+) (string, error) {
+	if scope.Key == "" {
+		return fn(ctx, "")
+	}
+
+	requestHash, err := fingerprintRequest(req)
+	if err != nil {
+		return "", connect.NewError(connect.CodeInternal, err)
+	}
+
+	record, replayID, err := g.claim(ctx, scope, requestHash, lookup)
+	if err != nil {
+		return "", err
+	}
+	if replayID != "" {
+		return replayID, nil
+	}
+
+	resourceID, err := fn(ctx, record.ReservedID)
+	if err != nil {
+		// Let the caller retry a failed request with the same key.
+		if releaseErr := g.repo.Release(ctx, record.GetID()); releaseErr != nil {
+			util.Log(ctx).WithError(releaseErr).With("idempotency_key", scope.Key).
+				Warn("could not release idempotency claim")
+		}
+		return "", err
+	}
+
+	record.ResourceID = resourceID
+	if _, updateErr := g.repo.Update(ctx, record, "resource_id"); updateErr != nil {
+		return "", data.ErrorConvertToAPI(updateErr)
+	}
+
+	return resourceID, nil
+}
+
+// claim reserves the key for this request. It returns the ID of the stored
+// resource instead when the key was already used for the same payload.
+func (g *idempotencyGuard) claim(
+	ctx context.Context,
+	scope idempotencyScope,
+	requestHash string,
+	lookup idempotencyLookup,
+) (*models.IdempotencyRecord, string, error) {
+	callerID := callerProfileID(ctx)
+
+	// Two passes: the second runs after an expired or abandoned claim is released.
+	for range 2 {
+		record := &models.IdempotencyRecord{
+			ShopID:         scope.ShopID,
+			CallerID:       callerID,
+			Operation:      scope.Operation,
+			IdempotencyKey: scope.Key,
+			RequestHash:    requestHash,
+			ReservedID:     util.IDString(),
+			ExpiresAt:      time.Now().Add(g.ttl),
+		}
+
+		claimed, err := g.repo.TryCreate(ctx, record)
+		if err != nil {
+			return nil, "", data.ErrorConvertToAPI(err)
+		}
+		if claimed {
+			return record, "", nil
+		}
+
+		existing, err := g.repo.GetByScope(ctx, scope.ShopID, callerID, scope.Operation, scope.Key)
+		if err != nil {
+			if frame.ErrorIsNotFound(err) {
+				continue
+			}
+			return nil, "", data.ErrorConvertToAPI(err)
+		}
+
+		now := time.Now()
+		abandoned := existing.ResourceID == "" && existing.ModifiedAt.Before(now.Add(-idempotencyLockTimeout))
+		if abandoned && lookup != nil && !existing.ExpiresAt.Before(now) {
+			if recoverErr := g.recoverClaim(ctx, existing, lookup); recoverErr != nil {
+				return nil, "", recoverErr
+			}
+			abandoned = existing.ResourceID == ""
+		}
+		if existing.ExpiresAt.Before(now) || abandoned {
+			if releaseErr := g.repo.Release(ctx, existing.GetID()); releaseErr != nil {
+				return nil, "", data.ErrorConvertToAPI(releaseErr)
+			}
+			continue
+		}
+
+		if existing.RequestHash != requestHash {
+			return nil, "", connect.NewError(connect.CodeAlreadyExists,
+				errors.New("idempotency key was already used with a different request payload"))
+		}
+
+		if existing.ResourceID == "" {
+			return nil, "", connect.NewError(connect.CodeAborted,
+				errors.New("a request with this idempotency key is still being processed"))
+		}
+
+		return nil, existing.ResourceID, nil
+	}
+
+	return nil, "", connect.NewError(connect.CodeAborted,
+		errors.New("could not claim idempotency key, retry the request"))
+}
+
+// recoverClaim records on an abandoned claim the resource its request
+// produced before crashing, if lookup finds one. Claims made before IDs were
+// reserved have nothing to look for.
+func (g *idempotencyGuard) recoverClaim(
+	ctx context.Context,
+	record *models.IdempotencyRecord,
+	lookup idempotencyLookup,
+) error {
+	if record.ReservedID == "" {
+		return nil
+	}
+	resourceID, err := lookup(ctx, record.ReservedID)
+	if err != nil {
+		return err
+	}
+	if resourceID == "" {
+		return nil
+	}
+	record.ResourceID = resourceID
+	if _, err = g.repo.Update(ctx, record, "resource_id"); err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return nil
+}
+
+// IdempotencyBusiness looks after the stored idempotency claims.
+type IdempotencyBusiness interface {
+	PurgeExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+func NewIdempotencyBusiness(_ context.Context, repo repository.IdempotencyRepository) IdempotencyBusiness {
+	return &idempotencyBusiness{repo: repo}
+}
+
+type idempotencyBusiness struct {
+	repo repository.IdempotencyRepository
+}
+
+// PurgeExpired deletes the claims that expired before now, returning how many
+// were deleted. A key whose claim expired is free to be used again either way.
+func (ib *idempotencyBusiness) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	total := 0
+	for {
+		deleted, err := ib.repo.DeleteExpired(ctx, now, expiredIdempotencyBatchSize)
+		total += deleted
+		if err != nil {
+			return total, data.ErrorConvertToAPI(err)
+		}
+		if deleted < expiredIdempotencyBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// fingerprintRequest hashes the request payload so replays can be compared.
+func fingerprintRequest(req proto.Message) (string, error) {
+	payload, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// idempotencyKey prefers the key carried in the request message and falls
+// back to the one supplied through the request header.
+func idempotencyKey(ctx context.Context, requestKey string) string {
+	if key := strings.TrimSpace(requestKey); key != "" {
+		return key
+	}
+	return IdempotencyKeyFromContext(ctx)
+}
@@ -0,0 +1,830 @@
+package business
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame"
+	"github.com/pitabwire/frame/data"
+	"github.com/pitabwire/frame/workerpool"
+	"github.com/pitabwire/util"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+// Catalog import file formats.
+const (
+	ImportFormatCSV  = "csv"
+	ImportFormatJSON = "json"
+)
+
+const (
+	// MaxImportBytes caps the size of an import file.
+	MaxImportBytes = 16 << 20
+	// MaxImportRows caps the number of rows in an import file.
+	MaxImportRows = 20000
+
+	// importProgressRows is how many rows an import processes between saves
+	// of its progress.
+	importProgressRows = 100
+
+	maxImportNameLength = 200
+)
+
+// importColumns are the fields of an import row, in CSV header order. A CSV
+// file may order or omit the optional columns; product, sku, price and
+// currency are required.
+//
+//nolint:gochecknoglobals // static column list
+var importColumns = []string{
+	"product", "description", "attributes", "product_media_ids",
+	"sku", "name", "price", "currency", "stock", "media_ids",
+}
+
+//nolint:gochecknoglobals // static column list
+var requiredImportColumns = []string{"product", "sku", "price", "currency"}
+
+// ImportRow is one variant of an import file together with the product it
+// belongs to. Rows naming the same product are imported together.
+type ImportRow struct {
+	Row             int
+	Product         string
+	Description     string
+	Attributes      map[string]string
+	ProductMediaIDs []string
+	SKU             string
+	Name            string
+	Price           string
+	Currency        string
+	Stock           string
+	MediaIDs        []string
+}
+
+// importRecord is the JSON form of an import row.
+type importRecord struct {
+	Product         string            `json:"product"`
+	Description     string            `json:"description"`
+	Attributes      map[string]string `json:"attributes"`
+	ProductMediaIDs []string          `json:"product_media_ids"`
+	SKU             string            `json:"sku"`
+	Name            string            `json:"name"`
+	Price           json.Number       `json:"price"`
+	Currency        string            `json:"currency"`
+	Stock           json.Number       `json:"stock"`
+	MediaIDs        []string          `json:"media_ids"`
+}
+
+// ParseImportFile reads the rows of an import file. A CSV file starts with a
+// header naming its columns; attributes are written key=value;key=value and
+// media IDs are separated by |. A JSON file is an array of row objects. The
+// file is rejected as a whole when it cannot be read; the values of its rows
+// are validated when they are imported.
+func ParseImportFile(format string, source []byte) ([]ImportRow, error) {
+	var (
+		rows []ImportRow
+		err  error
+	)
+	switch format {
+	case ImportFormatCSV:
+		rows, err = parseImportCSV(source)
+	case ImportFormatJSON:
+		rows, err = parseImportJSON(source)
+	default:
+		return nil, fmt.Errorf("unknown import format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("import file has no rows")
+	}
+	if len(rows) > MaxImportRows {
+		return nil, fmt.Errorf("import file has more than %d rows", MaxImportRows)
+	}
+	return rows, nil
+}
+
+func parseImportCSV(source []byte) ([]ImportRow, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(source, []byte("\uFEFF"))))
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("import file is empty")
+		}
+		return nil, err
+	}
+	index := make(map[string]int, len(header))
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !slices.Contains(importColumns, column) {
+			return nil, fmt.Errorf("unknown column %q", column)
+		}
+		if _, dup := index[column]; dup {
+			return nil, fmt.Errorf("column %q appears twice", column)
+		}
+		index[column] = i
+	}
+	for _, column := range requiredImportColumns {
+		if _, ok := index[column]; !ok {
+			return nil, fmt.Errorf("missing column %q", column)
+		}
+	}
+
+	var rows []ImportRow
+	for {
+		record, readErr := reader.Read()
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+		if len(rows) == MaxImportRows {
+			return nil, fmt.Errorf("import file has more than %d rows", MaxImportRows)
+		}
+
+		line, _ := reader.FieldPos(0)
+		value := func(column string) string {
+			if i, ok := index[column]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		row := ImportRow{
+			Row:             line,
+			Product:         value("product"),
+			Description:     value("description"),
+			Attributes:      parseImportAttributes(value("attributes")),
+			ProductMediaIDs: splitImportList(value("product_media_ids")),
+			SKU:             value("sku"),
+			Name:            value("name"),
+			Price:           value("price"),
+			Currency:        value("currency"),
+			Stock:           value("stock"),
+			MediaIDs:        splitImportList(value("media_ids")),
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseImportJSON(source []byte) ([]ImportRow, error) {
+	decoder := json.NewDecoder(bytes.NewReader(source))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+
+	var records []importRecord
+	if err := decoder.Decode(&records); err != nil {
+		return nil, err
+	}
+	if len(records) > MaxImportRows {
+		return nil, fmt.Errorf("import file has more than %d rows", MaxImportRows)
+	}
+
+	rows := make([]ImportRow, 0, len(records))
+	for i, record := range records {
+		rows = append(rows, ImportRow{
+			Row:             i + 1,
+			Product:         strings.TrimSpace(record.Product),
+			Description:     strings.TrimSpace(record.Description),
+			Attributes:      record.Attributes,
+			ProductMediaIDs: record.ProductMediaIDs,
+			SKU:             strings.TrimSpace(record.SKU),
+			Name:            strings.TrimSpace(record.Name),
+			Price:           strings.TrimSpace(record.Price.String()),
+			Currency:        strings.TrimSpace(record.Currency),
+			Stock:           record.Stock.String(),
+			MediaIDs:        record.MediaIDs,
+		})
+	}
+	return rows, nil
+}
+
+// parseImportAttributes reads key=value pairs separated by semicolons. A pair
+// without a value keeps the key with an empty value.
+func parseImportAttributes(value string) map[string]string {
+	if value == "" {
+		return nil
+	}
+	attributes := map[string]string{}
+	for pair := range strings.SplitSeq(value, ";") {
+		key, val, _ := strings.Cut(pair, "=")
+		if key = strings.TrimSpace(key); key != "" {
+			attributes[key] = strings.TrimSpace(val)
+		}
+	}
+	return attributes
+}
+
+func splitImportList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	var items []string
+	for item := range strings.SplitSeq(value, "|") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ParseDecimalAmount reads a non-negative decimal amount such as "12" or
+// "12.50" into whole units and nanos, as stored in google.type.Money.
+func ParseDecimalAmount(value string) (int64, int32, error) {
+	whole, fraction, hasFraction := strings.Cut(value, ".")
+	if whole == "" || !isDigits(whole) || (hasFraction && (fraction == "" || !isDigits(fraction))) {
+		return 0, 0, fmt.Errorf("%q is not a decimal amount", value)
+	}
+	const nanoDigits = 9
+	if len(fraction) > nanoDigits {
+		return 0, 0, fmt.Errorf("%q has more than %d decimal places", value, nanoDigits)
+	}
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%q is too large", value)
+	}
+	var nanos int64
+	if fraction != "" {
+		nanos, err = strconv.ParseInt(fraction+strings.Repeat("0", nanoDigits-len(fraction)), 10, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%q is not a decimal amount", value)
+		}
+	}
+	return units, int32(nanos), nil
+}
+
+// addColumn appends column unless columns already holds it.
+func addColumn(columns []string, column string) []string {
+	if slices.Contains(columns, column) {
+		return columns
+	}
+	return append(columns, column)
+}
+
+func isDigits(value string) bool {
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ImportBusiness loads products and variants into a shop in bulk. An import
+// runs on the worker pool; its progress and the rows it could not import are
+// read back with GetImport.
+type ImportBusiness interface {
+	StartImport(ctx context.Context, shopID, format string, source []byte) (*models.CatalogImport, error)
+	GetImport(ctx context.Context, id string) (*models.CatalogImport, error)
+	RunImport(ctx context.Context, id string) error
+}
+
+func NewImportBusiness(
+	_ context.Context,
+	workMan workerpool.Manager,
+	importRepo repository.CatalogImportRepository,
+	shopRepo repository.ShopRepository,
+	productRepo repository.ProductRepository,
+	variantRepo repository.ProductVariantRepository,
+	optionRepo repository.ProductOptionRepository,
+	redirectRepo repository.SlugRedirectRepository,
+) ImportBusiness {
+	return &importBusiness{
+		workMan:      workMan,
+		importRepo:   importRepo,
+		shopRepo:     shopRepo,
+		productRepo:  productRepo,
+		variantRepo:  variantRepo,
+		optionRepo:   optionRepo,
+		redirectRepo: redirectRepo,
+	}
+}
+
+type importBusiness struct {
+	workMan      workerpool.Manager
+	importRepo   repository.CatalogImportRepository
+	shopRepo     repository.ShopRepository
+	productRepo  repository.ProductRepository
+	variantRepo  repository.ProductVariantRepository
+	optionRepo   repository.ProductOptionRepository
+	redirectRepo repository.SlugRedirectRepository
+}
+
+// StartImport checks the file can be read, stores it and queues it to be
+// imported. The returned import is queued; a file that cannot be read is
+// rejected without creating one.
+func (ib *importBusiness) StartImport(
+	ctx context.Context,
+	shopID, format string,
+	source []byte,
+) (*models.CatalogImport, error) {
+	if len(source) > MaxImportBytes {
+		return nil, connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("import file is larger than %d bytes", MaxImportBytes))
+	}
+	format = strings.ToLower(strings.TrimSpace(format))
+	rows, err := ParseImportFile(format, source)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	shop, err := ib.shopRepo.GetByID(ctx, shopID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	job := &models.CatalogImport{
+		ShopID:    shopID,
+		Format:    format,
+		Source:    string(source),
+		Status:    models.ImportStatusQueued,
+		TotalRows: int64(len(rows)),
+	}
+	job.CopyPartitionInfo(&shop.BaseModel)
+	if err = ib.importRepo.Create(ctx, job); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	// The import outlives the request that queued it, so it keeps the
+	// request's values but not its cancellation.
+	jobCtx := context.WithoutCancel(ctx)
+	importID := job.GetID()
+	run := workerpool.NewJob(func(ctx context.Context, _ workerpool.JobResultPipe[any]) error {
+		if runErr := ib.RunImport(ctx, importID); runErr != nil {
+			util.Log(ctx).WithError(runErr).With("import_id", importID).Error("catalog import failed")
+		}
+		return nil
+	})
+	if err = workerpool.SubmitJob(jobCtx, ib.workMan, run); err != nil {
+		now := time.Now()
+		job.Status = models.ImportStatusFailed
+		job.Message = "could not start the import"
+		job.FinishedAt = &now
+		if saveErr := ib.importRepo.SaveProgress(ctx, job); saveErr != nil {
+			util.Log(ctx).WithError(saveErr).With("import_id", importID).Warn("could not fail catalog import")
+		}
+		return nil, connect.NewError(connect.CodeUnavailable, errors.New("could not start the import"))
+	}
+
+	job.Source = ""
+	return job, nil
+}
+
+func (ib *importBusiness) GetImport(ctx context.Context, id string) (*models.CatalogImport, error) {
+	job, err := ib.importRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	job.Source = ""
+	return job, nil
+}
+
+// RunImport imports a queued import's rows, a product at a time. A product is
+// written together with all its variants or not at all: when any of its rows
+// is invalid none are imported and each is reported. Variants are matched to
+// existing ones by SKU and updated; others are created, on the product that
+// already holds the group's SKUs or on an existing product of the same name
+// where there is one. Existing products keep their name and slug.
+func (ib *importBusiness) RunImport(ctx context.Context, id string) error {
+	started, err := ib.importRepo.Start(ctx, id, time.Now())
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	if !started {
+		return nil
+	}
+
+	job, err := ib.importRepo.GetByID(ctx, id)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+
+	if runErr := ib.importRows(ctx, job); runErr != nil {
+		job.Status = models.ImportStatusFailed
+		job.Message = runErr.Error()
+	} else {
+		job.Status = models.ImportStatusCompleted
+	}
+	now := time.Now()
+	job.FinishedAt = &now
+	if err = ib.importRepo.SaveProgress(ctx, job); err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return nil
+}
+
+func (ib *importBusiness) importRows(ctx context.Context, job *models.CatalogImport) error {
+	rows, err := ParseImportFile(job.Format, []byte(job.Source))
+	if err != nil {
+		return err
+	}
+	shop, err := ib.shopRepo.GetByID(ctx, job.ShopID)
+	if err != nil {
+		return err
+	}
+
+	duplicates := duplicateSKURows(rows)
+	sinceSave := 0
+	for _, group := range groupImportRows(rows) {
+		rowErrors, groupErr := ib.importProduct(ctx, shop, job, group, duplicates)
+		if groupErr != nil {
+			return groupErr
+		}
+		job.Errors = append(job.Errors, rowErrors...)
+		job.FailedRows += int64(len(rowErrors))
+		job.ProcessedRows += int64(len(group))
+
+		sinceSave += len(group)
+		if sinceSave >= importProgressRows {
+			sinceSave = 0
+			if err = ib.importRepo.SaveProgress(ctx, job); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// duplicateSKURows reports the rows repeating a SKU given on an earlier row.
+func duplicateSKURows(rows []ImportRow) map[int]string {
+	problems := map[int]string{}
+	first := map[string]int{}
+	for _, row := range rows {
+		if row.SKU == "" {
+			continue
+		}
+		if earlier, ok := first[row.SKU]; ok {
+			problems[row.Row] = fmt.Sprintf("sku %s is repeated from row %d", row.SKU, earlier)
+			continue
+		}
+		first[row.SKU] = row.Row
+	}
+	return problems
+}
+
+// groupImportRows groups rows by product name, in the order each product is
+// first named.
+func groupImportRows(rows []ImportRow) [][]ImportRow {
+	var groups [][]ImportRow
+	index := map[string]int{}
+	for _, row := range rows {
+		i, ok := index[row.Product]
+		if !ok {
+			i = len(groups)
+			index[row.Product] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], row)
+	}
+	return groups
+}
+
+// importProduct writes one product's rows, returning the rows it could not
+// import. duplicates holds the file's rows that repeat a SKU. The error is set
+// only when the import cannot go on.
+func (ib *importBusiness) importProduct(
+	ctx context.Context,
+	shop *models.Shop,
+	job *models.CatalogImport,
+	rows []ImportRow,
+	duplicates map[int]string,
+) (models.ImportRowErrors, error) {
+	problems := map[int]string{}
+	variants := make([]*models.ProductVariant, len(rows))
+	for i, row := range rows {
+		variant, problem := importVariant(row)
+		if duplicate, ok := duplicates[row.Row]; ok && problem == "" {
+			problem = duplicate
+		}
+		if problem != "" {
+			problems[row.Row] = problem
+		}
+		variants[i] = variant
+	}
+	product, productColumns, problem := importProductFields(rows)
+	if problem != "" {
+		problems[rows[0].Row] = problem
+	}
+
+	// Match SKUs to the variants that already hold them. All of a product's
+	// existing SKUs must already belong to it.
+	existing := map[string]*models.ProductVariant{}
+	productID := ""
+	for _, row := range rows {
+		if problems[row.Row] != "" {
+			continue
+		}
+		current, err := ib.variantRepo.GetBySKU(ctx, shop.GetID(), row.SKU)
+		if err != nil {
+			if frame.ErrorIsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if productID != "" && current.ProductID != productID {
+			problems[row.Row] = fmt.Sprintf("sku %s belongs to a different product than the other skus of %q",
+				row.SKU, row.Product)
+			continue
+		}
+		productID = current.ProductID
+		existing[row.SKU] = current
+	}
+
+	target, err := ib.importTarget(ctx, shop, productID, rows[0].Product)
+	if err != nil {
+		return nil, err
+	}
+	if target != nil && len(existing) < len(rows) {
+		options, optionsErr := ib.optionRepo.ListByProductID(ctx, target.GetID())
+		if optionsErr != nil {
+			return nil, optionsErr
+		}
+		if len(options) > 0 {
+			for _, row := range rows {
+				if existing[row.SKU] == nil && problems[row.Row] == "" {
+					problems[row.Row] = "product has options; create its new variants individually"
+				}
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return importRowErrors(rows, problems), nil
+	}
+
+	// Merge the rows into the existing product and variants.
+	variantColumns := []string{"currency_code", "price_units", "price_nanos"}
+	if target != nil {
+		productColumns = mergeImportedProduct(target, product, productColumns)
+		product = target
+	} else {
+		product.ShopID = shop.GetID()
+		product.Name = rows[0].Product
+		product.Status = int32(commercev1.ProductStatus_PRODUCT_STATUS_ACTIVE)
+		product.CopyPartitionInfo(&shop.BaseModel)
+		if product.Slug, err = ib.freeProductSlug(ctx, shop.GetID(), product.Name); err != nil {
+			return importRowErrors(rows, map[int]string{rows[0].Row: err.Error()}), nil
+		}
+	}
+	for i, row := range rows {
+		current := existing[row.SKU]
+		if current == nil {
+			variants[i].ShopID = shop.GetID()
+			continue
+		}
+		current.CurrencyCode = variants[i].CurrencyCode
+		current.PriceUnits = variants[i].PriceUnits
+		current.PriceNanos = variants[i].PriceNanos
+		if row.Name != "" {
+			current.Name = row.Name
+			variantColumns = addColumn(variantColumns, "name")
+		}
+		if row.Stock != "" && !current.IsBundle() {
+			current.StockQuantity = variants[i].StockQuantity
+			variantColumns = addColumn(variantColumns, "stock_quantity")
+		}
+		if row.MediaIDs != nil {
+			current.MediaIDs = variants[i].MediaIDs
+			variantColumns = addColumn(variantColumns, "media_ids")
+		}
+		variants[i] = current
+	}
+
+	err = ib.productRepo.SaveWithVariants(ctx, product, productColumns, variants, variantColumns)
+	if errors.Is(err, repository.ErrCatalogConflict) {
+		return importRowErrors(rows, map[int]string{
+			rows[0].Row: "the product or one of its skus changed during the import; import it again",
+		}), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if target == nil {
+		job.CreatedProducts++
+	} else if len(productColumns) > 0 {
+		job.UpdatedProducts++
+	}
+	job.UpdatedVariants += int64(len(existing))
+	job.CreatedVariants += int64(len(rows) - len(existing))
+	return nil, nil
+}
+
+// importTarget finds the existing product a group imports into: the product
+// holding its SKUs, or else a product of the shop with the same name.
+func (ib *importBusiness) importTarget(
+	ctx context.Context,
+	shop *models.Shop,
+	productID, name string,
+) (*models.Product, error) {
+	if productID != "" {
+		return ib.productRepo.GetByID(ctx, productID)
+	}
+	slug := Slugify(name)
+	if slug == "" {
+		return nil, nil
+	}
+	product, err := ib.productRepo.GetBySlug(ctx, shop.GetID(), slug)
+	if err != nil {
+		if frame.ErrorIsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !strings.EqualFold(product.Name, name) {
+		return nil, nil
+	}
+	return product, nil
+}
+
+// freeProductSlug returns the first slug for name that no product of the
+// shop holds or has retired.
+func (ib *importBusiness) freeProductSlug(ctx context.Context, shopID, name string) (string, error) {
+	base := Slugify(name)
+	if base == "" {
+		base = slugEntityProduct
+	}
+	for n := 1; n <= maxSlugAttempts; n++ {
+		candidate := withSlugSuffix(base, n)
+		_, err := ib.productRepo.GetBySlug(ctx, shopID, candidate)
+		if err == nil {
+			continue
+		}
+		if !frame.ErrorIsNotFound(err) {
+			return "", err
+		}
+		retired, err := slugRedirectTaken(ctx, ib.redirectRepo, slugEntityProduct, shopID, candidate, "")
+		if err != nil {
+			return "", err
+		}
+		if !retired {
+			return candidate, nil
+		}
+	}
+	return "", errors.New("could not allocate a unique slug")
+}
+
+// importVariant validates a row's variant fields, returning the variant to
+// create or a description of what is wrong with the row.
+func importVariant(row ImportRow) (*models.ProductVariant, string) {
+	switch {
+	case row.Product == "":
+		return nil, "product is required"
+	case len(row.Product) > maxImportNameLength:
+		return nil, fmt.Sprintf("product must be at most %d characters", maxImportNameLength)
+	case len(row.Name) > maxImportNameLength:
+		return nil, fmt.Sprintf("name must be at most %d characters", maxImportNameLength)
+	}
+	var stock int64
+	if row.Stock != "" {
+		var err error
+		if stock, err = strconv.ParseInt(row.Stock, 10, 64); err != nil {
+			return nil, fmt.Sprintf("stock %q is not a whole number", row.Stock)
+		}
+		if stock < 0 {
+			return nil, "stock must not be negative"
+		}
+	}
+	if err := ValidateSKU(row.SKU); err != nil {
+		return nil, err.Error()
+	}
+	units, nanos, err := ParseDecimalAmount(row.Price)
+	if err != nil {
+		return nil, "price " + err.Error()
+	}
+	currency := strings.ToUpper(row.Currency)
+	if err = ValidateCurrencyCode(currency); err != nil {
+		return nil, err.Error()
+	}
+
+	variant := &models.ProductVariant{
+		SKU:          row.SKU,
+		Name:         row.Name,
+		CurrencyCode: currency,
+		PriceUnits:   units,
+		PriceNanos:   nanos,
+		MediaIDs:     models.StringArray(row.MediaIDs),
+		Status:       int32(commercev1.ProductVariantStatus_PRODUCT_VARIANT_STATUS_ACTIVE),
+	}
+	variant.StockQuantity = stock
+	return variant, ""
+}
+
+// importProductFields collects a group's product fields. Each may be given on
+// any of the rows but must not differ between them. It returns the product,
+// the columns the rows set and a description of what is wrong, if anything.
+func importProductFields(rows []ImportRow) (*models.Product, []string, string) {
+	var (
+		description string
+		attributes  map[string]string
+		mediaIDs    []string
+		columns     []string
+	)
+	for _, row := range rows {
+		if row.Description != "" {
+			if description != "" && description != row.Description {
+				return nil, nil, fmt.Sprintf("rows of %q give different descriptions", row.Product)
+			}
+			description = row.Description
+			columns = addColumn(columns, "description")
+		}
+		if row.Attributes != nil {
+			if attributes != nil && !maps.Equal(attributes, row.Attributes) {
+				return nil, nil, fmt.Sprintf("rows of %q give different attributes", row.Product)
+			}
+			attributes = row.Attributes
+			columns = addColumn(columns, "attributes")
+		}
+		if row.ProductMediaIDs != nil {
+			if mediaIDs != nil && !slices.Equal(mediaIDs, row.ProductMediaIDs) {
+				return nil, nil, fmt.Sprintf("rows of %q give different product media", row.Product)
+			}
+			mediaIDs = row.ProductMediaIDs
+			columns = addColumn(columns, "media_ids")
+		}
+	}
+
+	attributes = maps.Clone(attributes)
+	fulfilmentType, err := ParseFulfilmentType(attributes[FulfilmentTypeAttribute])
+	if err != nil {
+		return nil, nil, err.Error()
+	}
+	if _, ok := attributes[FulfilmentTypeAttribute]; ok {
+		delete(attributes, FulfilmentTypeAttribute)
+		columns = addColumn(columns, "fulfilment_type")
+	}
+
+	product := &models.Product{
+		Description:    description,
+		Attributes:     models.MapToJSONMap(attributes),
+		FulfilmentType: int32(fulfilmentType),
+		MediaIDs:       models.StringArray(mediaIDs),
+	}
+	return product, columns, ""
+}
+
+// mergeImportedProduct copies the imported columns onto the existing product
+// and returns those that changed.
+func mergeImportedProduct(target, imported *models.Product, columns []string) []string {
+	var changed []string
+	for _, column := range columns {
+		switch column {
+		case "description":
+			if target.Description == imported.Description {
+				continue
+			}
+			target.Description = imported.Description
+		case "attributes":
+			if maps.EqualFunc(target.Attributes, imported.Attributes, func(a, b any) bool {
+				return fmt.Sprint(a) == fmt.Sprint(b)
+			}) {
+				continue
+			}
+			target.Attributes = imported.Attributes
+		case "media_ids":
+			if slices.Equal(target.MediaIDs, imported.MediaIDs) {
+				continue
+			}
+			target.MediaIDs = imported.MediaIDs
+		case "fulfilment_type":
+			if target.FulfilmentType == imported.FulfilmentType {
+				continue
+			}
+			target.FulfilmentType = imported.FulfilmentType
+		}
+		changed = append(changed, column)
+	}
+	return changed
+}
+
+// importRowErrors reports every row of a product that was not imported,
+// giving rows without a problem of their own the reason for the product.
+func importRowErrors(rows []ImportRow, problems map[int]string) models.ImportRowErrors {
+	rowErrors := make(models.ImportRowErrors, 0, len(rows))
+	for _, row := range rows {
+		message, ok := problems[row.Row]
+		if !ok {
+			message = fmt.Sprintf("not imported: another row of %q is invalid", row.Product)
+		}
+		rowErrors = append(rowErrors, models.ImportRowError{Row: row.Row, SKU: row.SKU, Message: message})
+	}
+	return rowErrors
+}
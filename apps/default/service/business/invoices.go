@@ -0,0 +1,419 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame"
+	"github.com/pitabwire/frame/data"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+	"github.com/antinvestor/service-commerce/internal/pdf"
+)
+
+const (
+	// InvoiceNumberPrefixKey is the shop property holding the invoice number prefix.
+	InvoiceNumberPrefixKey = "invoice_number_prefix"
+	// InvoiceNumberFormatKey is the shop property holding the invoice number pattern.
+	InvoiceNumberFormatKey = "invoice_number_format"
+	// InvoiceNumberPaddingKey is the shop property holding the invoice number padding.
+	InvoiceNumberPaddingKey = "invoice_number_padding"
+	// CreditNoteNumberPrefixKey is the shop property holding the credit note number prefix.
+	CreditNoteNumberPrefixKey = "credit_note_number_prefix"
+	// CreditNoteNumberFormatKey is the shop property holding the credit note number pattern.
+	CreditNoteNumberFormatKey = "credit_note_number_format"
+	// CreditNoteNumberPaddingKey is the shop property holding the credit note number padding.
+	CreditNoteNumberPaddingKey = "credit_note_number_padding"
+
+	// TaxNameKey is the shop property naming the tax included in its prices,
+	// such as "VAT".
+	TaxNameKey = "tax_name"
+	// TaxRateKey is the shop property holding that tax's rate in percent.
+	TaxRateKey = "tax_rate"
+
+	invoiceNumberSequence    = "invoice"
+	creditNoteNumberSequence = "credit_note"
+
+	defaultInvoiceNumberPrefix    = "INV"
+	defaultCreditNoteNumberPrefix = "CN"
+)
+
+// InvoiceBusiness issues the tax documents for orders: an invoice once the
+// order is paid and a credit note reversing it when the payment is refunded.
+type InvoiceBusiness interface {
+	IssueInvoice(ctx context.Context, orderID string) (*models.Invoice, error)
+	IssueCreditNote(ctx context.Context, orderID string) (*models.Invoice, error)
+	GetInvoice(ctx context.Context, id string) (*models.Invoice, error)
+	ListOrderInvoices(ctx context.Context, orderID string) ([]*models.Invoice, error)
+}
+
+func NewInvoiceBusiness(
+	_ context.Context,
+	invoiceRepo repository.InvoiceRepository,
+	orderRepo repository.OrderRepository,
+	shopRepo repository.ShopRepository,
+) InvoiceBusiness {
+	return &invoiceBusiness{invoiceRepo: invoiceRepo, orderRepo: orderRepo, shopRepo: shopRepo}
+}
+
+type invoiceBusiness struct {
+	invoiceRepo repository.InvoiceRepository
+	orderRepo   repository.OrderRepository
+	shopRepo    repository.ShopRepository
+}
+
+// IssueInvoice invoices the order, freezing its lines and totals. Issuing is
+// idempotent: an order already invoiced returns its invoice.
+func (ib *invoiceBusiness) IssueInvoice(ctx context.Context, orderID string) (*models.Invoice, error) {
+	if existing, err := ib.findIssued(ctx, orderID, models.InvoiceKindInvoice); existing != nil || err != nil {
+		return existing, err
+	}
+
+	order, err := ib.orderRepo.GetWithLines(ctx, orderID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	shop, err := ib.shopRepo.GetByID(ctx, order.ShopID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	invoice := BuildInvoice(shop, order, time.Now())
+	return ib.issue(ctx, invoice, invoiceNumberSequence,
+		numberFormatFromShop(shop, InvoiceNumberPrefixKey, InvoiceNumberFormatKey, InvoiceNumberPaddingKey,
+			defaultInvoiceNumberPrefix))
+}
+
+// IssueCreditNote reverses the order's invoice in full, invoicing the order
+// first if it never was. Like IssueInvoice it is idempotent.
+func (ib *invoiceBusiness) IssueCreditNote(ctx context.Context, orderID string) (*models.Invoice, error) {
+	if existing, err := ib.findIssued(ctx, orderID, models.InvoiceKindCreditNote); existing != nil || err != nil {
+		return existing, err
+	}
+
+	invoice, err := ib.IssueInvoice(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	shop, err := ib.shopRepo.GetByID(ctx, invoice.ShopID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	creditNote := BuildCreditNote(invoice, time.Now())
+	return ib.issue(ctx, creditNote, creditNoteNumberSequence,
+		numberFormatFromShop(shop, CreditNoteNumberPrefixKey, CreditNoteNumberFormatKey, CreditNoteNumberPaddingKey,
+			defaultCreditNoteNumberPrefix))
+}
+
+func (ib *invoiceBusiness) GetInvoice(ctx context.Context, id string) (*models.Invoice, error) {
+	invoice, err := ib.invoiceRepo.GetWithLines(ctx, id)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return invoice, nil
+}
+
+func (ib *invoiceBusiness) ListOrderInvoices(ctx context.Context, orderID string) ([]*models.Invoice, error) {
+	invoices, err := ib.invoiceRepo.ListByOrderID(ctx, orderID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return invoices, nil
+}
+
+// findIssued returns the order's document of the kind, or nil if there is none.
+func (ib *invoiceBusiness) findIssued(ctx context.Context, orderID string, kind int32) (*models.Invoice, error) {
+	existing, err := ib.invoiceRepo.GetByOrderAndKind(ctx, orderID, kind)
+	if err == nil {
+		return existing, nil
+	}
+	if frame.ErrorIsNotFound(err) {
+		return nil, nil
+	}
+	return nil, data.ErrorConvertToAPI(err)
+}
+
+func (ib *invoiceBusiness) issue(
+	ctx context.Context,
+	invoice *models.Invoice,
+	sequence string,
+	format NumberFormat,
+) (*models.Invoice, error) {
+	created, err := ib.invoiceRepo.Issue(ctx, invoice, sequence, func(seq int64) string {
+		return format.Format(seq, invoice.IssuedAt)
+	})
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if !created {
+		// A concurrent call issued it first.
+		existing, getErr := ib.findIssued(ctx, invoice.OrderID, invoice.Kind)
+		if getErr != nil {
+			return nil, getErr
+		}
+		if existing == nil {
+			return nil, connect.NewError(connect.CodeAborted, errors.New("invoice issue conflicted, retry"))
+		}
+		return existing, nil
+	}
+	return invoice, nil
+}
+
+// BuildInvoice freezes the order into an unnumbered invoice issued at
+// issuedAt. Tax is taken from the shop's configured inclusive tax rate.
+func BuildInvoice(shop *models.Shop, order *models.Order, issuedAt time.Time) *models.Invoice {
+	invoice := &models.Invoice{
+		ShopID:        order.ShopID,
+		OrderID:       order.GetID(),
+		Kind:          models.InvoiceKindInvoice,
+		ProfileID:     order.ProfileID,
+		ShopName:      shop.Name,
+		OrderNumber:   order.OrderNumber,
+		IssuedAt:      issuedAt,
+		Currency:      order.TotalCurrency,
+		SubtotalUnits: order.SubtotalUnits,
+		SubtotalNanos: order.SubtotalNanos,
+		TotalUnits:    order.TotalUnits,
+		TotalNanos:    order.TotalNanos,
+	}
+	invoice.CopyPartitionInfo(&order.BaseModel)
+
+	for _, line := range order.Lines {
+		invoice.Lines = append(invoice.Lines, &models.InvoiceLine{
+			OrderLineID:    line.GetID(),
+			SKU:            line.SKUSnapshot,
+			Name:           line.NameSnapshot,
+			Quantity:       line.Quantity,
+			UnitPriceUnits: line.UnitPriceUnits,
+			UnitPriceNanos: line.UnitPriceNanos,
+			TotalUnits:     line.TotalPriceUnits,
+			TotalNanos:     line.TotalPriceNanos,
+		})
+	}
+
+	if name, rate, ok := shopTaxRate(shop); ok {
+		units, nanos := InclusiveTax(order.TotalUnits, order.TotalNanos, rate)
+		invoice.TaxLines = models.InvoiceTaxLines{{
+			Name: name, RateBasisPoints: rate, Inclusive: true, Units: units, Nanos: nanos,
+		}}
+	}
+	return invoice
+}
+
+// BuildCreditNote builds an unnumbered credit note reversing the invoice in
+// full. Its amounts are those of the invoice; the kind marks them as credited.
+func BuildCreditNote(invoice *models.Invoice, issuedAt time.Time) *models.Invoice {
+	creditNote := &models.Invoice{
+		ShopID:            invoice.ShopID,
+		OrderID:           invoice.OrderID,
+		Kind:              models.InvoiceKindCreditNote,
+		CreditedInvoiceID: invoice.GetID(),
+		ProfileID:         invoice.ProfileID,
+		ShopName:          invoice.ShopName,
+		OrderNumber:       invoice.OrderNumber,
+		IssuedAt:          issuedAt,
+		Currency:          invoice.Currency,
+		SubtotalUnits:     invoice.SubtotalUnits,
+		SubtotalNanos:     invoice.SubtotalNanos,
+		TotalUnits:        invoice.TotalUnits,
+		TotalNanos:        invoice.TotalNanos,
+		TaxLines:          append(models.InvoiceTaxLines(nil), invoice.TaxLines...),
+	}
+	creditNote.CopyPartitionInfo(&invoice.BaseModel)
+
+	for _, line := range invoice.Lines {
+		credited := *line
+		credited.BaseModel = data.BaseModel{}
+		creditNote.Lines = append(creditNote.Lines, &credited)
+	}
+	return creditNote
+}
+
+// shopTaxRate reads the shop's inclusive tax, with the rate in basis points.
+func shopTaxRate(shop *models.Shop) (string, int64, bool) {
+	if shop.Properties == nil {
+		return "", 0, false
+	}
+
+	var percent float64
+	switch v := shop.Properties[TaxRateKey].(type) {
+	case float64:
+		percent = v
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return "", 0, false
+		}
+		percent = parsed
+	default:
+		return "", 0, false
+	}
+	if percent <= 0 || percent >= 100 {
+		return "", 0, false
+	}
+
+	name := strings.TrimSpace(shop.Properties.GetString(TaxNameKey))
+	if name == "" {
+		name = "Tax"
+	}
+	return name, int64(percent*100 + 0.5), true //nolint:mnd // percent to basis points
+}
+
+// taxRounding is the precision tax is rounded to: hundredths of the currency
+// unit, in nanos.
+const taxRounding = 10_000_000
+
+// InclusiveTax returns the part of an amount that is tax at the rate, given
+// in basis points, for prices that include the tax: amount × rate ÷ (1 +
+// rate). The result is rounded half up to hundredths of the currency unit.
+func InclusiveTax(units int64, nanos int32, rateBasisPoints int64) (int64, int32) {
+	const basisPoints = 10_000
+
+	amount := new(big.Int).Mul(big.NewInt(units), big.NewInt(nanosPerUnit))
+	amount.Add(amount, big.NewInt(int64(nanos)))
+
+	tax := new(big.Rat).SetFrac(
+		new(big.Int).Mul(amount, big.NewInt(rateBasisPoints)),
+		big.NewInt(basisPoints+rateBasisPoints),
+	)
+	steps := new(big.Rat).Quo(tax, new(big.Rat).SetInt64(taxRounding))
+	rounded := new(big.Int).Quo(
+		new(big.Int).Add(new(big.Int).Mul(steps.Num(), big.NewInt(2)), steps.Denom()), //nolint:mnd // half up
+		new(big.Int).Mul(steps.Denom(), big.NewInt(2)),                                //nolint:mnd // half up
+	)
+	total := rounded.Int64() * taxRounding
+	return total / nanosPerUnit, int32(total % nanosPerUnit)
+}
+
+// formatAmount prints an amount with two decimals, and more only when the
+// amount needs them.
+func formatAmount(currency string, units int64, nanos int32) string {
+	return currency + " " + DecimalAmount(units, nanos)
+}
+
+// DecimalAmount prints an amount without its currency, as formatAmount does.
+func DecimalAmount(units int64, nanos int32) string {
+	sign := ""
+	if units < 0 || nanos < 0 {
+		sign = "-"
+		units, nanos = -units, -nanos
+	}
+	fraction := strings.TrimRight(fmt.Sprintf("%09d", nanos), "0")
+	for len(fraction) < 2 { //nolint:mnd // two decimals at least
+		fraction += "0"
+	}
+	return fmt.Sprintf("%s%d.%s", sign, units, fraction)
+}
+
+// invoiceTitle names the document for its kind.
+func invoiceTitle(invoice *models.Invoice) string {
+	if invoice.Kind == models.InvoiceKindCreditNote {
+		return "Credit note"
+	}
+	return "Receipt"
+}
+
+// invoiceTotalLabel labels the document's total for its kind.
+func invoiceTotalLabel(invoice *models.Invoice) string {
+	if invoice.Kind == models.InvoiceKindCreditNote {
+		return "Total credited"
+	}
+	return "Total paid"
+}
+
+var invoiceTemplates = template.Must(template.Must(documentTemplates.Clone()).
+	Funcs(template.FuncMap{
+		"amount": formatAmount,
+		"title":  invoiceTitle,
+		"total":  invoiceTotalLabel,
+		"percent": func(basisPoints int64) string {
+			return strconv.FormatFloat(float64(basisPoints)/100, 'f', -1, 64) //nolint:mnd // basis points
+		},
+	}).
+	Parse(`{{define "invoice"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{title .}} {{.Number}}</title>
+{{template "style"}}
+</head>
+<body>
+<h1>{{title .}} {{.Number}}</h1>
+<p>{{.ShopName}}</p>
+<p>Issued {{time .IssuedAt}} for order {{.OrderNumber}}</p>
+<table>
+<thead><tr><th>SKU</th><th>Item</th><th class="qty">Qty</th><th class="qty">Unit price</th><th class="qty">Total</th></tr></thead>
+<tbody>
+{{- $currency := .Currency}}
+{{- range .Lines}}
+<tr><td>{{.SKU}}</td><td>{{.Name}}</td><td class="qty">{{.Quantity}}</td><td class="qty">{{amount $currency .UnitPriceUnits .UnitPriceNanos}}</td><td class="qty">{{amount $currency .TotalUnits .TotalNanos}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+<table>
+<tbody>
+<tr><td>Subtotal</td><td class="qty">{{amount .Currency .SubtotalUnits .SubtotalNanos}}</td></tr>
+{{- range .TaxLines}}
+<tr><td>{{.Name}} {{percent .RateBasisPoints}}%{{if .Inclusive}} (included){{end}}</td><td class="qty">{{amount $currency .Units .Nanos}}</td></tr>
+{{- end}}
+<tr><th>{{total .}}</th><th class="qty">{{amount .Currency .TotalUnits .TotalNanos}}</th></tr>
+</tbody>
+</table>
+</body>
+</html>
+{{end}}`))
+
+// RenderInvoice writes the invoice, or credit note, as a receipt in the format.
+func RenderInvoice(w io.Writer, invoice *models.Invoice, format DocumentFormat) error {
+	if format == DocumentFormatHTML {
+		return invoiceTemplates.ExecuteTemplate(w, "invoice", invoice)
+	}
+
+	amount := func(units int64, nanos int32) string { return formatAmount(invoice.Currency, units, nanos) }
+
+	doc := pdf.New()
+	doc.Row(pdf.HelveticaBold, 18, pdf.Cell{X: pdf.Margin, Text: invoiceTitle(invoice) + " " + invoice.Number})
+	doc.Row(pdf.Helvetica, 11, pdf.Cell{X: pdf.Margin, Text: invoice.ShopName})
+	doc.Row(pdf.Helvetica, 10, pdf.Cell{X: pdf.Margin,
+		Text: fmt.Sprintf("Issued %s for order %s", documentTime(invoice.IssuedAt), invoice.OrderNumber)})
+	doc.Space(12)
+
+	doc.Row(pdf.HelveticaBold, 10,
+		pdf.Cell{X: 50, Text: "SKU"}, pdf.Cell{X: 150, Text: "Item"}, pdf.Cell{X: 340, Text: "Qty"},
+		pdf.Cell{X: 380, Text: "Unit price"}, pdf.Cell{X: 470, Text: "Total"})
+	for _, line := range invoice.Lines {
+		doc.Row(pdf.Helvetica, 10,
+			pdf.Cell{X: 50, Text: clip(line.SKU, 16)}, pdf.Cell{X: 150, Text: clip(line.Name, 32)},
+			pdf.Cell{X: 340, Text: strconv.FormatInt(line.Quantity, 10)},
+			pdf.Cell{X: 380, Text: amount(line.UnitPriceUnits, line.UnitPriceNanos)},
+			pdf.Cell{X: 470, Text: amount(line.TotalUnits, line.TotalNanos)})
+	}
+	doc.Space(8)
+
+	doc.Row(pdf.Helvetica, 10, pdf.Cell{X: 340, Text: "Subtotal"},
+		pdf.Cell{X: 470, Text: amount(invoice.SubtotalUnits, invoice.SubtotalNanos)})
+	for _, tax := range invoice.TaxLines {
+		label := fmt.Sprintf("%s %s%%", tax.Name,
+			strconv.FormatFloat(float64(tax.RateBasisPoints)/100, 'f', -1, 64)) //nolint:mnd // basis points
+		if tax.Inclusive {
+			label += " (included)"
+		}
+		doc.Row(pdf.Helvetica, 10, pdf.Cell{X: 340, Text: clip(label, 24)},
+			pdf.Cell{X: 470, Text: amount(tax.Units, tax.Nanos)})
+	}
+	doc.Row(pdf.HelveticaBold, 10, pdf.Cell{X: 340, Text: invoiceTotalLabel(invoice)},
+		pdf.Cell{X: 470, Text: amount(invoice.TotalUnits, invoice.TotalNanos)})
+
+	_, err := doc.WriteTo(w)
+	return err
+}
@@ -0,0 +1,523 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame"
+	"github.com/pitabwire/frame/data"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+const (
+	// CollectionTypeManual lists products explicitly, in the stored order.
+	CollectionTypeManual int32 = 1
+	// CollectionTypeRules matches products against the collection's rules.
+	CollectionTypeRules int32 = 2
+
+	// ExtraCategoryID and ExtraCollectionID narrow ListProducts through the
+	// search extras until the proto carries dedicated fields.
+	ExtraCategoryID   = "category_id"
+	ExtraCollectionID = "collection_id"
+	// ExtraIncludeDescendants also lists products of the category's subcategories.
+	ExtraIncludeDescendants = "include_descendants"
+
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+	maxCategoryDepth = 10
+)
+
+// CategoryInput describes a category to create or update.
+type CategoryInput struct {
+	ParentID    string
+	Name        string
+	Slug        string
+	Description string
+	Position    int32
+	MediaIDs    []string
+}
+
+// CollectionInput describes a collection. Rules apply only to rule-based collections.
+type CollectionInput struct {
+	Name        string
+	Slug        string
+	Description string
+	Type        int32
+	Rules       []models.CollectionRule
+	MatchAny    bool
+	MediaIDs    []string
+}
+
+// ValidateCollectionRules checks that every rule names a supported field and
+// operator. Ordering operators apply to prices only and need a decimal value.
+func ValidateCollectionRules(rules []models.CollectionRule) error {
+	for i, rule := range rules {
+		switch rule.Field {
+		case "attribute":
+			if strings.TrimSpace(rule.Attribute) == "" {
+				return fmt.Errorf("rule %d: attribute name is required", i)
+			}
+		case "name", "price":
+		default:
+			return fmt.Errorf("rule %d: unknown field %q", i, rule.Field)
+		}
+
+		switch rule.Operator {
+		case "eq", "neq":
+		case "contains":
+			if rule.Field == "price" {
+				return fmt.Errorf("rule %d: price does not support %q", i, rule.Operator)
+			}
+		case "lt", "lte", "gt", "gte":
+			if rule.Field != "price" {
+				return fmt.Errorf("rule %d: %s does not support %q", i, rule.Field, rule.Operator)
+			}
+		default:
+			return fmt.Errorf("rule %d: unknown operator %q", i, rule.Operator)
+		}
+
+		if rule.Field == "price" {
+			if _, err := strconv.ParseFloat(rule.Value, 64); err != nil {
+				return fmt.Errorf("rule %d: price %q is not a number", i, rule.Value)
+			}
+		}
+	}
+	return nil
+}
+
+type NavigationBusiness interface {
+	CreateCategory(ctx context.Context, shopID string, input CategoryInput) (*models.Category, error)
+	GetCategory(ctx context.Context, categoryID string) (*models.Category, error)
+	UpdateCategory(ctx context.Context, categoryID string, input CategoryInput) (*models.Category, error)
+	ListCategories(ctx context.Context, shopID string) ([]*models.Category, error)
+	AssignProductCategories(ctx context.Context, productID string, categoryIDs []string) error
+	CreateCollection(ctx context.Context, shopID string, input CollectionInput) (*models.Collection, error)
+	GetCollection(ctx context.Context, collectionID string) (*models.Collection, error)
+	ListCollections(ctx context.Context, shopID string) ([]*models.Collection, error)
+	SetCollectionProducts(ctx context.Context, collectionID string, productIDs []string) error
+	ListProductsByCategory(
+		ctx context.Context, categoryID string, includeDescendants bool, limit, offset int,
+	) ([]*commercev1.Product, error)
+	ListProductsByCollection(ctx context.Context, collectionID string, limit, offset int) ([]*commercev1.Product, error)
+	ListProducts(ctx context.Context, req *commercev1.ListProductsRequest) ([]*commercev1.Product, error)
+}
+
+func NewNavigationBusiness(
+	_ context.Context,
+	catalog CatalogBusiness,
+	shopRepo repository.ShopRepository,
+	productRepo repository.ProductRepository,
+	categoryRepo repository.CategoryRepository,
+	productCategoryRepo repository.ProductCategoryRepository,
+	collectionRepo repository.CollectionRepository,
+	collectionProductRepo repository.CollectionProductRepository,
+) NavigationBusiness {
+	return &navigationBusiness{
+		catalog:               catalog,
+		shopRepo:              shopRepo,
+		productRepo:           productRepo,
+		categoryRepo:          categoryRepo,
+		productCategoryRepo:   productCategoryRepo,
+		collectionRepo:        collectionRepo,
+		collectionProductRepo: collectionProductRepo,
+	}
+}
+
+type navigationBusiness struct {
+	catalog               CatalogBusiness
+	shopRepo              repository.ShopRepository
+	productRepo           repository.ProductRepository
+	categoryRepo          repository.CategoryRepository
+	productCategoryRepo   repository.ProductCategoryRepository
+	collectionRepo        repository.CollectionRepository
+	collectionProductRepo repository.CollectionProductRepository
+}
+
+func (nb *navigationBusiness) CreateCategory(
+	ctx context.Context,
+	shopID string,
+	input CategoryInput,
+) (*models.Category, error) {
+	if _, err := nb.shopRepo.GetByID(ctx, shopID); err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, errors.New("shop not found"))
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("category name is required"))
+	}
+
+	slug := Slugify(input.Slug)
+	if slug == "" {
+		slug = Slugify(name)
+	}
+	if _, err := nb.categoryRepo.GetBySlug(ctx, shopID, slug); err == nil {
+		return nil, connect.NewError(connect.CodeAlreadyExists, errors.New("category slug already in use"))
+	} else if !frame.ErrorIsNotFound(err) {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	if input.ParentID != "" {
+		if _, err := nb.shopCategory(ctx, shopID, input.ParentID); err != nil {
+			return nil, err
+		}
+	}
+
+	category := &models.Category{
+		ShopID:      shopID,
+		ParentID:    input.ParentID,
+		Name:        name,
+		Slug:        slug,
+		Description: input.Description,
+		Position:    input.Position,
+		MediaIDs:    models.StringArray(input.MediaIDs),
+	}
+	if err := nb.categoryRepo.Create(ctx, category); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return category, nil
+}
+
+func (nb *navigationBusiness) GetCategory(ctx context.Context, categoryID string) (*models.Category, error) {
+	category, err := nb.categoryRepo.GetByID(ctx, categoryID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, errors.New("category not found"))
+	}
+	return category, nil
+}
+
+func (nb *navigationBusiness) UpdateCategory(
+	ctx context.Context,
+	categoryID string,
+	input CategoryInput,
+) (*models.Category, error) {
+	category, err := nb.categoryRepo.GetByID(ctx, categoryID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, errors.New("category not found"))
+	}
+
+	if name := strings.TrimSpace(input.Name); name != "" {
+		category.Name = name
+	}
+	if slug := Slugify(input.Slug); slug != "" && slug != category.Slug {
+		if _, lookupErr := nb.categoryRepo.GetBySlug(ctx, category.ShopID, slug); lookupErr == nil {
+			return nil, connect.NewError(connect.CodeAlreadyExists, errors.New("category slug already in use"))
+		} else if !frame.ErrorIsNotFound(lookupErr) {
+			return nil, data.ErrorConvertToAPI(lookupErr)
+		}
+		category.Slug = slug
+	}
+
+	if input.ParentID != category.ParentID {
+		if cycleErr := nb.ensureNoCycle(ctx, category, input.ParentID); cycleErr != nil {
+			return nil, cycleErr
+		}
+		category.ParentID = input.ParentID
+	}
+
+	category.Description = input.Description
+	category.Position = input.Position
+	category.MediaIDs = models.StringArray(input.MediaIDs)
+
+	if _, err = nb.categoryRepo.Update(ctx, category); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return category, nil
+}
+
+// ensureNoCycle rejects moving a category under itself or one of its descendants.
+func (nb *navigationBusiness) ensureNoCycle(ctx context.Context, category *models.Category, parentID string) error {
+	for depth := 0; parentID != ""; depth++ {
+		if parentID == category.GetID() {
+			return connect.NewError(connect.CodeInvalidArgument,
+				errors.New("a category cannot be moved under itself or a descendant"))
+		}
+		if depth >= maxCategoryDepth {
+			return connect.NewError(connect.CodeInvalidArgument, errors.New("category tree is too deep"))
+		}
+
+		parent, err := nb.shopCategory(ctx, category.ShopID, parentID)
+		if err != nil {
+			return err
+		}
+		parentID = parent.ParentID
+	}
+	return nil
+}
+
+func (nb *navigationBusiness) shopCategory(ctx context.Context, shopID, categoryID string) (*models.Category, error) {
+	category, err := nb.categoryRepo.GetByID(ctx, categoryID)
+	if err != nil || category.ShopID != shopID {
+		return nil, connect.NewError(connect.CodeNotFound, errors.New("category not found"))
+	}
+	return category, nil
+}
+
+func (nb *navigationBusiness) ListCategories(ctx context.Context, shopID string) ([]*models.Category, error) {
+	categories, err := nb.categoryRepo.ListByShopID(ctx, shopID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return categories, nil
+}
+
+func (nb *navigationBusiness) AssignProductCategories(
+	ctx context.Context,
+	productID string,
+	categoryIDs []string,
+) error {
+	product, err := nb.productRepo.GetByID(ctx, productID)
+	if err != nil {
+		return connect.NewError(connect.CodeNotFound, errors.New("product not found"))
+	}
+
+	ids := slices.Compact(slices.Sorted(slices.Values(categoryIDs)))
+	for _, categoryID := range ids {
+		if _, err = nb.shopCategory(ctx, product.ShopID, categoryID); err != nil {
+			return err
+		}
+	}
+
+	if err = nb.productCategoryRepo.Replace(ctx, productID, ids); err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return nil
+}
+
+func (nb *navigationBusiness) CreateCollection(
+	ctx context.Context,
+	shopID string,
+	input CollectionInput,
+) (*models.Collection, error) {
+	if _, err := nb.shopRepo.GetByID(ctx, shopID); err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, errors.New("shop not found"))
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("collection name is required"))
+	}
+
+	collectionType := input.Type
+	if collectionType == 0 {
+		collectionType = CollectionTypeManual
+	}
+	switch collectionType {
+	case CollectionTypeManual:
+		if len(input.Rules) > 0 {
+			return nil, connect.NewError(connect.CodeInvalidArgument,
+				errors.New("manual collections do not take rules"))
+		}
+	case CollectionTypeRules:
+		if len(input.Rules) == 0 {
+			return nil, connect.NewError(connect.CodeInvalidArgument,
+				errors.New("rule-based collections need at least one rule"))
+		}
+		if err := ValidateCollectionRules(input.Rules); err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+	default:
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("unknown collection type"))
+	}
+
+	slug := Slugify(input.Slug)
+	if slug == "" {
+		slug = Slugify(name)
+	}
+	if _, err := nb.collectionRepo.GetBySlug(ctx, shopID, slug); err == nil {
+		return nil, connect.NewError(connect.CodeAlreadyExists, errors.New("collection slug already in use"))
+	} else if !frame.ErrorIsNotFound(err) {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	collection := &models.Collection{
+		ShopID:      shopID,
+		Name:        name,
+		Slug:        slug,
+		Description: input.Description,
+		Type:        collectionType,
+		Rules:       models.CollectionRules(input.Rules),
+		MatchAny:    input.MatchAny,
+		MediaIDs:    models.StringArray(input.MediaIDs),
+	}
+	if err := nb.collectionRepo.Create(ctx, collection); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return collection, nil
+}
+
+func (nb *navigationBusiness) GetCollection(ctx context.Context, collectionID string) (*models.Collection, error) {
+	collection, err := nb.collectionRepo.GetByID(ctx, collectionID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, errors.New("collection not found"))
+	}
+	return collection, nil
+}
+
+func (nb *navigationBusiness) ListCollections(ctx context.Context, shopID string) ([]*models.Collection, error) {
+	collections, err := nb.collectionRepo.ListByShopID(ctx, shopID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return collections, nil
+}
+
+func (nb *navigationBusiness) SetCollectionProducts(
+	ctx context.Context,
+	collectionID string,
+	productIDs []string,
+) error {
+	collection, err := nb.collectionRepo.GetByID(ctx, collectionID)
+	if err != nil {
+		return connect.NewError(connect.CodeNotFound, errors.New("collection not found"))
+	}
+	if collection.Type != CollectionTypeManual {
+		return connect.NewError(connect.CodeFailedPrecondition,
+			errors.New("products can only be set on manual collections"))
+	}
+
+	seen := make(map[string]bool, len(productIDs))
+	for _, productID := range productIDs {
+		if seen[productID] {
+			return connect.NewError(connect.CodeInvalidArgument,
+				fmt.Errorf("product %s is listed more than once", productID))
+		}
+		seen[productID] = true
+
+		product, getErr := nb.productRepo.GetByID(ctx, productID)
+		if getErr != nil || product.ShopID != collection.ShopID {
+			return connect.NewError(connect.CodeNotFound, fmt.Errorf("product %s not found", productID))
+		}
+	}
+
+	if err = nb.collectionProductRepo.Replace(ctx, collectionID, productIDs); err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return nil
+}
+
+func (nb *navigationBusiness) ListProductsByCategory(
+	ctx context.Context,
+	categoryID string,
+	includeDescendants bool,
+	limit, offset int,
+) ([]*commercev1.Product, error) {
+	category, err := nb.categoryRepo.GetByID(ctx, categoryID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, errors.New("category not found"))
+	}
+
+	categoryIDs := []string{category.GetID()}
+	if includeDescendants {
+		categories, listErr := nb.categoryRepo.ListByShopID(ctx, category.ShopID)
+		if listErr != nil {
+			return nil, data.ErrorConvertToAPI(listErr)
+		}
+		categoryIDs = descendantCategoryIDs(categories, category.GetID())
+	}
+
+	products, err := nb.productRepo.ListByCategoryIDs(ctx, category.ShopID, categoryIDs, limit, offset)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return productsToAPI(products), nil
+}
+
+func (nb *navigationBusiness) ListProductsByCollection(
+	ctx context.Context,
+	collectionID string,
+	limit, offset int,
+) ([]*commercev1.Product, error) {
+	collection, err := nb.collectionRepo.GetByID(ctx, collectionID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, errors.New("collection not found"))
+	}
+
+	var products []*models.Product
+	if collection.Type == CollectionTypeRules {
+		products, err = nb.productRepo.ListByRules(
+			ctx, collection.ShopID, collection.Rules, collection.MatchAny, limit, offset)
+	} else {
+		products, err = nb.productRepo.ListByCollectionID(ctx, collection.GetID(), limit, offset)
+	}
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return productsToAPI(products), nil
+}
+
+// ListProducts narrows the shop's products to a category or collection named
+// in the search extras, and otherwise lists the whole catalog.
+func (nb *navigationBusiness) ListProducts(
+	ctx context.Context,
+	req *commercev1.ListProductsRequest,
+) ([]*commercev1.Product, error) {
+	extras := req.GetSearch().GetExtras().GetFields()
+	categoryID := extras[ExtraCategoryID].GetStringValue()
+	collectionID := extras[ExtraCollectionID].GetStringValue()
+	if categoryID == "" && collectionID == "" {
+		return nb.catalog.ListProducts(ctx, req)
+	}
+
+	cursor := req.GetSearch().GetCursor()
+	limit, offset := pageBounds(cursor.GetLimit(), cursor.GetPage())
+
+	if collectionID != "" {
+		collection, err := nb.collectionRepo.GetByID(ctx, collectionID)
+		if err != nil || (req.GetShopId() != "" && collection.ShopID != req.GetShopId()) {
+			return nil, connect.NewError(connect.CodeNotFound, errors.New("collection not found"))
+		}
+		return nb.ListProductsByCollection(ctx, collectionID, limit, offset)
+	}
+
+	category, err := nb.categoryRepo.GetByID(ctx, categoryID)
+	if err != nil || (req.GetShopId() != "" && category.ShopID != req.GetShopId()) {
+		return nil, connect.NewError(connect.CodeNotFound, errors.New("category not found"))
+	}
+	return nb.ListProductsByCategory(ctx, categoryID, extras[ExtraIncludeDescendants].GetBoolValue(), limit, offset)
+}
+
+// descendantCategoryIDs returns rootID and the IDs of every category below it.
+func descendantCategoryIDs(categories []*models.Category, rootID string) []string {
+	children := make(map[string][]string, len(categories))
+	for _, category := range categories {
+		children[category.ParentID] = append(children[category.ParentID], category.GetID())
+	}
+
+	ids := []string{rootID}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, children[ids[i]]...)
+	}
+	return ids
+}
+
+func productsToAPI(products []*models.Product) []*commercev1.Product {
+	result := make([]*commercev1.Product, 0, len(products))
+	for _, p := range products {
+		result = append(result, p.ToAPI())
+	}
+	return result
+}
+
+// pageBounds converts a search cursor into a limit and offset. Pages are
+// numbered from 1; an empty or invalid page means the first page.
+func pageBounds(limit int32, page string) (int, int) {
+	size := defaultPageLimit
+	if limit > 0 {
+		size = min(int(limit), maxPageLimit)
+	}
+
+	number, err := strconv.Atoi(page)
+	if err != nil || number < 1 {
+		return size, 0
+	}
+	return size, (number - 1) * size
+}
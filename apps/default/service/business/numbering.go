@@ -0,0 +1,102 @@
+package business
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+const (
+	// OrderNumberPrefixKey is the shop property holding the order number prefix.
+	OrderNumberPrefixKey = "order_number_prefix"
+	// OrderNumberFormatKey is the shop property holding the order number pattern.
+	OrderNumberFormatKey = "order_number_format"
+	// OrderNumberPaddingKey is the shop property holding the zero padding width of the sequence.
+	OrderNumberPaddingKey = "order_number_padding"
+
+	orderNumberSequence = "order"
+
+	defaultOrderNumberPrefix = "ORD"
+	defaultNumberPattern     = "{prefix}-{yyyy}-{seq}"
+	defaultNumberPadding     = 6
+	maxNumberPadding         = 18
+)
+
+// NumberFormat renders a sequence value into a human-friendly document number.
+//
+// Pattern supports the placeholders {prefix}, {yyyy}, {yy}, {mm} and {seq};
+// {seq} is zero padded to Padding digits.
+type NumberFormat struct {
+	Prefix  string
+	Pattern string
+	Padding int
+}
+
+// Format renders seq using the format, taking date parts from at.
+func (nf NumberFormat) Format(seq int64, at time.Time) string {
+	pattern := nf.Pattern
+	if pattern == "" {
+		pattern = defaultNumberPattern
+	}
+	if !strings.Contains(pattern, "{seq}") {
+		// A pattern without the sequence would hand out the same number forever.
+		pattern += "-{seq}"
+	}
+
+	padding := nf.Padding
+	if padding <= 0 || padding > maxNumberPadding {
+		padding = defaultNumberPadding
+	}
+
+	replacer := strings.NewReplacer(
+		"{prefix}", nf.Prefix,
+		"{yyyy}", fmt.Sprintf("%04d", at.Year()),
+		"{yy}", fmt.Sprintf("%02d", at.Year()%100), //nolint:mnd // two digit year
+		"{mm}", fmt.Sprintf("%02d", int(at.Month())),
+		"{seq}", fmt.Sprintf("%0*d", padding, seq),
+	)
+
+	return strings.Trim(replacer.Replace(pattern), "-")
+}
+
+// orderNumberFormat reads the order number configuration from the shop properties.
+func orderNumberFormat(shop *models.Shop) NumberFormat {
+	return numberFormatFromShop(shop, OrderNumberPrefixKey, OrderNumberFormatKey, OrderNumberPaddingKey,
+		defaultOrderNumberPrefix)
+}
+
+func numberFormatFromShop(
+	shop *models.Shop,
+	prefixKey, patternKey, paddingKey string,
+	defaultPrefix string,
+) NumberFormat {
+	nf := NumberFormat{
+		Prefix:  defaultPrefix,
+		Pattern: defaultNumberPattern,
+		Padding: defaultNumberPadding,
+	}
+	if shop == nil || shop.Properties == nil {
+		return nf
+	}
+
+	if prefix := strings.TrimSpace(shop.Properties.GetString(prefixKey)); prefix != "" {
+		nf.Prefix = prefix
+	}
+	if pattern := strings.TrimSpace(shop.Properties.GetString(patternKey)); pattern != "" {
+		nf.Pattern = pattern
+	}
+
+	switch v := shop.Properties[paddingKey].(type) {
+	case float64:
+		nf.Padding = int(v)
+	case string:
+		if padding, err := strconv.Atoi(v); err == nil {
+			nf.Padding = padding
+		}
+	}
+
+	return nf
+}
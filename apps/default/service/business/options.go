@@ -0,0 +1,503 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame/data"
+	money "google.golang.org/genproto/googleapis/type/money"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+const (
+	// maxVariantMatrixSize caps the number of value combinations a product's
+	// options may describe.
+	maxVariantMatrixSize = 500
+
+	// defaultMatrixSKUTemplate names generated variants when the shop has no SKU template.
+	defaultMatrixSKUTemplate = "{product}-{options}"
+)
+
+// ProductOptionInput declares an option and its allowed values.
+type ProductOptionInput struct {
+	Name   string
+	Values []string
+}
+
+// VariantDefaults seeds the price and stock of variants created for missing
+// combinations of option values.
+type VariantDefaults struct {
+	Price         *money.Money
+	StockQuantity int64
+}
+
+// validate applies the price and stock rules of a single variant to the
+// defaults, before anything is written or a SKU drawn for them.
+func (d VariantDefaults) validate() error {
+	if d.Price != nil {
+		if err := ValidatePrice(d.Price); err != nil {
+			return connect.NewError(connect.CodeInvalidArgument, err)
+		}
+	}
+	if err := ValidateStockQuantity(d.StockQuantity); err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return nil
+}
+
+// VariantMatrixResult reports what a reconciliation of the variant matrix changed.
+type VariantMatrixResult struct {
+	Created     []*commercev1.ProductVariant
+	Reactivated []*commercev1.ProductVariant
+	Disabled    []*commercev1.ProductVariant
+}
+
+// ValidateVariantAttributes checks that attrs holds exactly one declared value
+// for every option. Products without options accept free-form attributes.
+func ValidateVariantAttributes(options []*models.ProductOption, attrs map[string]string) error {
+	if len(options) == 0 {
+		return nil
+	}
+
+	for _, option := range options {
+		value, ok := attrs[option.Name]
+		if !ok {
+			return fmt.Errorf("attribute %q is required", option.Name)
+		}
+		if !slices.Contains(option.Values, value) {
+			return fmt.Errorf("%q is not a value of option %q", value, option.Name)
+		}
+	}
+
+	if len(attrs) != len(options) {
+		for name := range attrs {
+			if !slices.ContainsFunc(options, func(o *models.ProductOption) bool { return o.Name == name }) {
+				return fmt.Errorf("attribute %q is not a declared option", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// VariantCombinations expands the options into every combination of values,
+// varying the last option fastest.
+func VariantCombinations(options []*models.ProductOption) []map[string]string {
+	if len(options) == 0 {
+		return nil
+	}
+
+	combinations := []map[string]string{{}}
+	for _, option := range options {
+		next := make([]map[string]string, 0, len(combinations)*len(option.Values))
+		for _, partial := range combinations {
+			for _, value := range option.Values {
+				combination := make(map[string]string, len(partial)+1)
+				for k, v := range partial {
+					combination[k] = v
+				}
+				combination[option.Name] = value
+				next = append(next, combination)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// combinationKey identifies a combination of option values independently of map ordering.
+func combinationKey(options []*models.ProductOption, attrs map[string]string) string {
+	parts := make([]string, 0, len(options))
+	for _, option := range options {
+		parts = append(parts, option.Name+"="+attrs[option.Name])
+	}
+	return strings.Join(parts, "|")
+}
+
+// combinationName names a generated variant after its option values.
+func combinationName(options []*models.ProductOption, attrs map[string]string) string {
+	values := make([]string, 0, len(options))
+	for _, option := range options {
+		values = append(values, attrs[option.Name])
+	}
+	return strings.Join(values, " / ")
+}
+
+// normaliseOptionInputs trims the declared options and rejects empty or
+// duplicate names and values, and matrices that would grow too large.
+func normaliseOptionInputs(inputs []ProductOptionInput) ([]ProductOptionInput, error) {
+	result := make([]ProductOptionInput, 0, len(inputs))
+	names := make(map[string]bool, len(inputs))
+	size := 1
+
+	for _, input := range inputs {
+		name := strings.TrimSpace(input.Name)
+		if name == "" {
+			return nil, errors.New("option name is required")
+		}
+		if names[name] {
+			return nil, fmt.Errorf("option %q is declared twice", name)
+		}
+		names[name] = true
+
+		values := make([]string, 0, len(input.Values))
+		for _, value := range input.Values {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				return nil, fmt.Errorf("option %q has an empty value", name)
+			}
+			if slices.Contains(values, value) {
+				return nil, fmt.Errorf("option %q lists %q twice", name, value)
+			}
+			values = append(values, value)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("option %q needs at least one value", name)
+		}
+
+		size *= len(values)
+		if size > maxVariantMatrixSize {
+			return nil, fmt.Errorf("options describe more than %d variants", maxVariantMatrixSize)
+		}
+
+		result = append(result, ProductOptionInput{Name: name, Values: values})
+	}
+
+	return result, nil
+}
+
+func (cb *catalogBusiness) ListProductOptions(ctx context.Context, productID string) ([]*models.ProductOption, error) {
+	options, err := cb.optionRepo.ListByProductID(ctx, productID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return options, nil
+}
+
+// SetProductOptions replaces the product's declared options. Existing
+// variants are left untouched until the matrix is reconciled.
+func (cb *catalogBusiness) SetProductOptions(
+	ctx context.Context,
+	productID string,
+	inputs []ProductOptionInput,
+) ([]*models.ProductOption, error) {
+	if _, err := cb.productRepo.GetByID(ctx, productID); err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, errors.New("product not found"))
+	}
+
+	normalised, err := normaliseOptionInputs(inputs)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	existing, err := cb.optionRepo.ListByProductID(ctx, productID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	byName := make(map[string]*models.ProductOption, len(existing))
+	for _, option := range existing {
+		byName[option.Name] = option
+	}
+
+	for position, input := range normalised {
+		option, ok := byName[input.Name]
+		if !ok {
+			option = &models.ProductOption{
+				ProductID: productID,
+				Name:      input.Name,
+				Position:  int32(position), //nolint:gosec // bounded by maxVariantMatrixSize
+				Values:    models.StringArray(input.Values),
+			}
+			if createErr := cb.optionRepo.Create(ctx, option); createErr != nil {
+				return nil, data.ErrorConvertToAPI(createErr)
+			}
+			continue
+		}
+
+		delete(byName, input.Name)
+		option.Position = int32(position) //nolint:gosec // bounded by maxVariantMatrixSize
+		option.Values = models.StringArray(input.Values)
+		if _, updateErr := cb.optionRepo.Update(ctx, option, "position", "values"); updateErr != nil {
+			return nil, data.ErrorConvertToAPI(updateErr)
+		}
+	}
+
+	for _, removed := range byName {
+		if removeErr := cb.optionRepo.Remove(ctx, removed.GetID()); removeErr != nil {
+			return nil, data.ErrorConvertToAPI(removeErr)
+		}
+	}
+
+	return cb.ListProductOptions(ctx, productID)
+}
+
+// AddProductOptionValue extends an option and creates the variants for the
+// new combinations. Variants disabled when the value was previously removed
+// are reactivated instead of duplicated.
+func (cb *catalogBusiness) AddProductOptionValue(
+	ctx context.Context,
+	productID, optionName, value string,
+	defaults VariantDefaults,
+) (*VariantMatrixResult, error) {
+	if err := defaults.validate(); err != nil {
+		return nil, err
+	}
+
+	product, options, option, err := cb.loadOption(ctx, productID, optionName)
+	if err != nil {
+		return nil, err
+	}
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("option value is required"))
+	}
+
+	if !slices.Contains(option.Values, value) {
+		option.Values = append(option.Values, value)
+		if _, sizeErr := normaliseOptionInputs(optionInputs(options)); sizeErr != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, sizeErr)
+		}
+		if _, updateErr := cb.optionRepo.Update(ctx, option, "values"); updateErr != nil {
+			return nil, data.ErrorConvertToAPI(updateErr)
+		}
+	}
+
+	return cb.reconcileVariants(ctx, product, options, &defaults, map[string]string{option.Name: value})
+}
+
+// RemoveProductOptionValue drops a value from an option and disables the
+// variants that used it.
+func (cb *catalogBusiness) RemoveProductOptionValue(
+	ctx context.Context,
+	productID, optionName, value string,
+) (*VariantMatrixResult, error) {
+	product, options, option, err := cb.loadOption(ctx, productID, optionName)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := slices.Index(option.Values, value)
+	if idx < 0 {
+		return nil, connect.NewError(connect.CodeNotFound,
+			fmt.Errorf("%q is not a value of option %q", value, option.Name))
+	}
+	if len(option.Values) == 1 {
+		return nil, connect.NewError(connect.CodeFailedPrecondition,
+			fmt.Errorf("%q is the last value of option %q, remove the option instead", value, option.Name))
+	}
+
+	option.Values = slices.Delete(option.Values, idx, idx+1)
+	if _, updateErr := cb.optionRepo.Update(ctx, option, "values"); updateErr != nil {
+		return nil, data.ErrorConvertToAPI(updateErr)
+	}
+
+	return cb.reconcileVariants(ctx, product, options, nil, nil)
+}
+
+// GenerateVariantMatrix creates a variant for every combination of option
+// values that has none yet and disables variants that no longer match the
+// declared options.
+func (cb *catalogBusiness) GenerateVariantMatrix(
+	ctx context.Context,
+	productID string,
+	defaults VariantDefaults,
+) (*VariantMatrixResult, error) {
+	if err := defaults.validate(); err != nil {
+		return nil, err
+	}
+
+	product, err := cb.productRepo.GetByID(ctx, productID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, errors.New("product not found"))
+	}
+
+	options, err := cb.optionRepo.ListByProductID(ctx, productID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if len(options) == 0 {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("product declares no options"))
+	}
+
+	return cb.reconcileVariants(ctx, product, options, &defaults, nil)
+}
+
+func (cb *catalogBusiness) loadOption(
+	ctx context.Context,
+	productID, optionName string,
+) (*models.Product, []*models.ProductOption, *models.ProductOption, error) {
+	product, err := cb.productRepo.GetByID(ctx, productID)
+	if err != nil {
+		return nil, nil, nil, connect.NewError(connect.CodeNotFound, errors.New("product not found"))
+	}
+
+	options, err := cb.optionRepo.ListByProductID(ctx, productID)
+	if err != nil {
+		return nil, nil, nil, data.ErrorConvertToAPI(err)
+	}
+
+	for _, option := range options {
+		if option.Name == optionName {
+			return product, options, option, nil
+		}
+	}
+	return nil, nil, nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("option %q not found", optionName))
+}
+
+// reconcileVariants aligns the product's variants with its options. Variants
+// whose attributes no longer match are disabled; disabled variants matching
+// revived are reactivated; when defaults is set, missing combinations are created.
+func (cb *catalogBusiness) reconcileVariants(
+	ctx context.Context,
+	product *models.Product,
+	options []*models.ProductOption,
+	defaults *VariantDefaults,
+	revived map[string]string,
+) (*VariantMatrixResult, error) {
+	variants, err := cb.variantRepo.ListByProductID(ctx, product.GetID())
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	active := int32(commercev1.ProductVariantStatus_PRODUCT_VARIANT_STATUS_ACTIVE)
+	disabled := int32(commercev1.ProductVariantStatus_PRODUCT_VARIANT_STATUS_DISABLED)
+
+	result := &VariantMatrixResult{}
+	existing := make(map[string]bool, len(variants))
+
+	for _, variant := range variants {
+		attrs := variant.ToAPI().GetAttributes()
+
+		if ValidateVariantAttributes(options, attrs) != nil {
+			if variant.Status == active {
+				variant.Status = disabled
+				if _, updateErr := cb.variantRepo.Update(ctx, variant, "status"); updateErr != nil {
+					return nil, data.ErrorConvertToAPI(updateErr)
+				}
+				result.Disabled = append(result.Disabled, variant.ToAPI())
+			}
+			continue
+		}
+
+		existing[combinationKey(options, attrs)] = true
+
+		if variant.Status == disabled && matchesAttributes(attrs, revived) {
+			variant.Status = active
+			if _, updateErr := cb.variantRepo.Update(ctx, variant, "status"); updateErr != nil {
+				return nil, data.ErrorConvertToAPI(updateErr)
+			}
+			result.Reactivated = append(result.Reactivated, variant.ToAPI())
+		}
+	}
+
+	if defaults == nil {
+		return result, nil
+	}
+
+	template, ok, err := cb.shopSKUTemplate(ctx, product.ShopID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		template = SKUTemplate{Template: defaultMatrixSKUTemplate}
+	}
+
+	currency, units, nanos := models.MoneyFromProto(defaults.Price)
+
+	for _, combination := range VariantCombinations(options) {
+		if existing[combinationKey(options, combination)] {
+			continue
+		}
+
+		sku, skuErr := cb.generateSKU(ctx, product, combination, template)
+		if skuErr != nil {
+			return nil, skuErr
+		}
+
+		variant := &models.ProductVariant{
+			ProductID:     product.GetID(),
+			ShopID:        product.ShopID,
+			SKU:           sku,
+			Name:          combinationName(options, combination),
+			CurrencyCode:  currency,
+			PriceUnits:    units,
+			PriceNanos:    nanos,
+			StockQuantity: defaults.StockQuantity,
+			Attributes:    models.MapToJSONMap(combination),
+			Status:        active,
+		}
+
+		created, createErr := cb.variantRepo.TryCreate(ctx, variant)
+		if createErr != nil {
+			return nil, data.ErrorConvertToAPI(createErr)
+		}
+		if !created {
+			return nil, skuInUse(sku)
+		}
+		result.Created = append(result.Created, variant.ToAPI())
+	}
+
+	return result, nil
+}
+
+// ensureCombinationAvailable validates attrs against the declared options and
+// fails when another variant of the product already has the same combination.
+func (cb *catalogBusiness) ensureCombinationAvailable(
+	ctx context.Context,
+	options []*models.ProductOption,
+	productID string,
+	attrs map[string]string,
+	variantID string,
+) error {
+	if len(options) == 0 {
+		return nil
+	}
+
+	if err := ValidateVariantAttributes(options, attrs); err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	variants, err := cb.variantRepo.ListByProductID(ctx, productID)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+
+	key := combinationKey(options, attrs)
+	for _, variant := range variants {
+		if variant.GetID() == variantID {
+			continue
+		}
+		if combinationKey(options, variant.ToAPI().GetAttributes()) == key {
+			return connect.NewError(connect.CodeAlreadyExists,
+				fmt.Errorf("variant %s already has these option values", variant.GetID()))
+		}
+	}
+	return nil
+}
+
+func matchesAttributes(attrs, want map[string]string) bool {
+	if len(want) == 0 {
+		return false
+	}
+	for name, value := range want {
+		if attrs[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func optionInputs(options []*models.ProductOption) []ProductOptionInput {
+	inputs := make([]ProductOptionInput, 0, len(options))
+	for _, option := range options {
+		inputs = append(inputs, ProductOptionInput{Name: option.Name, Values: option.Values})
+	}
+	return inputs
+}
@@ -10,16 +10,29 @@ import (
 	"connectrpc.com/connect"
 	"github.com/pitabwire/frame"
 	"github.com/pitabwire/frame/data"
+	money "google.golang.org/genproto/googleapis/type/money"
 
 	"github.com/antinvestor/service-commerce/apps/default/service/models"
 	"github.com/antinvestor/service-commerce/apps/default/service/repository"
 )
 
+// maxOrderNumberAttempts bounds how many sequence values are drawn when an
+// order number collides with an existing one.
+const maxOrderNumberAttempts = 5
+
 type OrderBusiness interface {
 	CreateOrder(ctx context.Context, req *commercev1.CreateOrderRequest) (*commercev1.Order, error)
 	CreateOrderFromCart(ctx context.Context, req *commercev1.CreateOrderFromCartRequest) (*commercev1.Order, error)
+	CreateRecurringOrder(
+		ctx context.Context, req *commercev1.CreateOrderRequest, unitPrices []*money.Money,
+	) (*commercev1.Order, error)
 	GetOrder(ctx context.Context, id string) (*commercev1.Order, error)
 	ListOrders(ctx context.Context, req *commercev1.ListOrdersRequest) ([]*commercev1.Order, error)
+	ListMyOrders(
+		ctx context.Context, filter repository.OrderHistoryFilter, limit, offset int,
+	) ([]*commercev1.Order, error)
+	Reorder(ctx context.Context, orderID string) (*ReorderResult, error)
+	CancelOrder(ctx context.Context, orderID string) (*commercev1.Order, error)
 }
 
 func NewOrderBusiness(
@@ -27,17 +40,28 @@ func NewOrderBusiness(
 	orderRepo repository.OrderRepository,
 	orderLineRepo repository.OrderLineRepository,
 	variantRepo repository.ProductVariantRepository,
+	productRepo repository.ProductRepository,
 	shopRepo repository.ShopRepository,
 	cartRepo repository.CartRepository,
 	cartLineRepo repository.CartLineRepository,
+	sequenceRepo repository.ShopSequenceRepository,
+	idempotencyRepo repository.IdempotencyRepository,
+	bundleRepo repository.BundleComponentRepository,
+	webhooks WebhookPublisher,
 ) OrderBusiness {
 	return &orderBusiness{
 		orderRepo:     orderRepo,
 		orderLineRepo: orderLineRepo,
 		variantRepo:   variantRepo,
+		productRepo:   productRepo,
 		shopRepo:      shopRepo,
 		cartRepo:      cartRepo,
 		cartLineRepo:  cartLineRepo,
+		sequenceRepo:  sequenceRepo,
+		idempotency:   newIdempotencyGuard(idempotencyRepo),
+		purchase:      newPurchaseRules(shopRepo, productRepo, variantRepo, bundleRepo),
+		bundleRepo:    bundleRepo,
+		webhooks:      webhooks,
 	}
 }
 
@@ -45,25 +69,116 @@ type orderBusiness struct {
 	orderRepo     repository.OrderRepository
 	orderLineRepo repository.OrderLineRepository
 	variantRepo   repository.ProductVariantRepository
+	productRepo   repository.ProductRepository
 	shopRepo      repository.ShopRepository
 	cartRepo      repository.CartRepository
 	cartLineRepo  repository.CartLineRepository
+	sequenceRepo  repository.ShopSequenceRepository
+	idempotency   *idempotencyGuard
+	purchase      *purchaseRules
+	bundleRepo    repository.BundleComponentRepository
+	webhooks      WebhookPublisher
 }
 
 func (ob *orderBusiness) CreateOrder(ctx context.Context, req *commercev1.CreateOrderRequest) (*commercev1.Order, error) {
-	// Idempotency check
-	if req.GetIdempotencyKey() != "" {
-		existing, err := ob.orderRepo.GetByIdempotencyKey(ctx, req.GetIdempotencyKey())
-		if err == nil && existing != nil {
-			return existing.ToAPI(), nil
+	return ob.createIdempotentOrder(ctx, req, orderSource{})
+}
+
+// CreateRecurringOrder creates an order like CreateOrder but charges the given
+// unit prices, indexed like the request lines, instead of the variants'
+// current prices. Nil entries take the current price. Replays of the same
+// idempotency key return the first order even when the prices changed since.
+func (ob *orderBusiness) CreateRecurringOrder(
+	ctx context.Context,
+	req *commercev1.CreateOrderRequest,
+	unitPrices []*money.Money,
+) (*commercev1.Order, error) {
+	return ob.createIdempotentOrder(ctx, req, orderSource{UnitPrices: unitPrices})
+}
+
+func (ob *orderBusiness) createIdempotentOrder(
+	ctx context.Context,
+	req *commercev1.CreateOrderRequest,
+	src orderSource,
+) (*commercev1.Order, error) {
+	scope := idempotencyScope{
+		Operation: operationCreateOrder,
+		ShopID:    req.GetShopId(),
+		Key:       idempotencyKey(ctx, req.GetIdempotencyKey()),
+	}
+
+	lookup := func(ctx context.Context, reservedID string) (string, error) {
+		return ob.orderIDFound(ob.orderRepo.GetByID(ctx, reservedID))
+	}
+	create := func(ctx context.Context, reservedID string) (string, error) {
+		src.OrderID = reservedID
+		src.IdempotencyKey = scope.Key
+		order, createErr := ob.createOrder(ctx, req, src)
+		if createErr != nil {
+			return "", createErr
 		}
-		if err != nil && !frame.ErrorIsNotFound(err) {
-			return nil, data.ErrorConvertToAPI(err)
+		return order.GetID(), nil
+	}
+	orderID, err := ob.idempotency.Do(ctx, scope, req, lookup, create)
+	if err != nil {
+		return nil, err
+	}
+
+	return ob.GetOrder(ctx, orderID)
+}
+
+// orderIDFound is the ID of an order looked up for an idempotency claim, or
+// empty when there is no such order.
+func (ob *orderBusiness) orderIDFound(order *models.Order, err error) (string, error) {
+	if err != nil {
+		if frame.ErrorIsNotFound(err) {
+			return "", nil
 		}
+		return "", data.ErrorConvertToAPI(err)
 	}
+	return order.GetID(), nil
+}
+
+// orderSource carries what an order is created from besides the request: the
+// ID reserved for it and the idempotency key it is stored under, the cart it converts and, for booking
+// lines of that cart, the slot reserved for each request line. Recurring
+// orders also carry the unit prices their price-lock rules settled on.
+type orderSource struct {
+	// OrderID is the ID the order is created with; empty draws a fresh one.
+	OrderID        string
+	IdempotencyKey string
+	CartID         string
+	// SlotIDs is indexed like the request lines; empty entries are not booked.
+	SlotIDs []string
+	// UnitPrices is indexed like the request lines; nil entries charge the
+	// variant's current price.
+	UnitPrices []*money.Money
+}
+
+func (src orderSource) slotID(lineIndex int) string {
+	if lineIndex < len(src.SlotIDs) {
+		return src.SlotIDs[lineIndex]
+	}
+	return ""
+}
 
+// unitPrice returns the price charged per unit of the line at lineIndex.
+func (src orderSource) unitPrice(lineIndex int, variant *models.ProductVariant) *money.Money {
+	if lineIndex < len(src.UnitPrices) && src.UnitPrices[lineIndex] != nil {
+		return src.UnitPrices[lineIndex]
+	}
+	return &money.Money{CurrencyCode: variant.CurrencyCode, Units: variant.PriceUnits, Nanos: variant.PriceNanos}
+}
+
+// createOrder validates the request, snapshots prices and persists the order
+// with its lines. Idempotency is the responsibility of the caller.
+func (ob *orderBusiness) createOrder(
+	ctx context.Context,
+	req *commercev1.CreateOrderRequest,
+	src orderSource,
+) (*models.Order, error) {
 	// Validate shop exists
-	_, shopErr := ob.shopRepo.GetByID(ctx, req.GetShopId())
+	shop, shopErr := ob.shopRepo.GetByID(ctx, req.GetShopId())
 	if shopErr != nil {
 		return nil, connect.NewError(connect.CodeNotFound, errors.New("shop not found"))
 	}
@@ -73,22 +188,16 @@ func (ob *orderBusiness) CreateOrder(ctx context.Context, req *commercev1.Create
 	}
 
 	// Validate all variants and snapshot prices
-	orderLines, subtotalCurrency, subtotalUnits, subtotalNanos, err := ob.buildOrderLines(ctx, req.GetShopId(), req.GetLines())
+	orderLines, subtotalCurrency, subtotalUnits, subtotalNanos, err := ob.buildOrderLines(ctx, req.GetShopId(), req.GetLines(), src)
 	if err != nil {
 		return nil, err
 	}
 
-	orderNumber := generateOrderNumber()
-
-	idempotencyKey := req.GetIdempotencyKey()
-	if idempotencyKey == "" {
-		idempotencyKey = orderNumber
-	}
-
 	order := &models.Order{
+		BaseModel:        data.BaseModel{ID: src.OrderID},
 		ShopID:           req.GetShopId(),
-		OrderNumber:      orderNumber,
-		IdempotencyKey:   idempotencyKey,
+		IdempotencyKey:   src.IdempotencyKey,
+		CartID:           src.CartID,
 		Status:           int32(commercev1.OrderStatus_ORDER_STATUS_CONFIRMED),
 		PaymentStatus:    int32(commercev1.PaymentStatus_PAYMENT_STATUS_PENDING),
 		FulfilmentStatus: int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_UNSPECIFIED),
@@ -102,33 +211,69 @@ func (ob *orderBusiness) CreateOrder(ctx context.Context, req *commercev1.Create
 		TotalUnits:       subtotalUnits,
 		TotalNanos:       subtotalNanos,
 	}
+	// Orders placed by background jobs carry no claims to take tenancy from.
+	order.CopyPartitionInfo(&shop.BaseModel)
 
-	if createErr := ob.orderRepo.Create(ctx, order); createErr != nil {
-		return nil, data.ErrorConvertToAPI(createErr)
+	return ob.insertNumberedOrder(ctx, shop, order, orderLines)
+}
+
+// CreateOrderFromCart converts the cart into an order exactly once. Retries
+// without an explicit idempotency key are keyed on the cart, and a cart that
+// was already converted returns the order it produced.
+func (ob *orderBusiness) CreateOrderFromCart(ctx context.Context, req *commercev1.CreateOrderFromCartRequest) (*commercev1.Order, error) {
+	cart, err := ob.cartRepo.GetWithLines(ctx, req.GetCartId())
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
 	}
 
-	// Create order lines and decrement stock
-	for _, line := range orderLines {
-		line.OrderID = order.GetID()
-		if lineErr := ob.orderLineRepo.Create(ctx, line); lineErr != nil {
-			return nil, data.ErrorConvertToAPI(lineErr)
+	if cart.Status == int32(commercev1.CartStatus_CART_STATUS_CONVERTED) {
+		existing, lookupErr := ob.orderRepo.GetByCartID(ctx, cart.GetID())
+		if lookupErr == nil {
+			return existing.ToAPI(), nil
 		}
-
-		if stockErr := ob.variantRepo.DecrementStock(ctx, line.ProductVariantID, line.Quantity); stockErr != nil {
-			return nil, connect.NewError(connect.CodeFailedPrecondition,
-				fmt.Errorf("insufficient stock for variant %s", line.ProductVariantID))
+		if !frame.ErrorIsNotFound(lookupErr) {
+			return nil, data.ErrorConvertToAPI(lookupErr)
 		}
 	}
 
-	return ob.GetOrder(ctx, order.GetID())
-}
+	key := IdempotencyKeyFromContext(ctx)
+	if key == "" {
+		key = cartIdempotencyKey(cart.GetID())
+	}
 
-func (ob *orderBusiness) CreateOrderFromCart(ctx context.Context, req *commercev1.CreateOrderFromCartRequest) (*commercev1.Order, error) {
-	cart, err := ob.cartRepo.GetWithLines(ctx, req.GetCartId())
+	scope := idempotencyScope{
+		Operation: operationCreateOrderFromCart,
+		ShopID:    cart.ShopID,
+		Key:       key,
+	}
+
+	// A cart converts to one order at most, whoever's claim created it.
+	lookup := func(ctx context.Context, _ string) (string, error) {
+		return ob.orderIDFound(ob.orderRepo.GetByCartID(ctx, cart.GetID()))
+	}
+	convert := func(ctx context.Context, reservedID string) (string, error) {
+		order, convertErr := ob.convertCart(ctx, cart, req, orderSource{OrderID: reservedID, IdempotencyKey: scope.Key})
+		if convertErr != nil {
+			return "", convertErr
+		}
+		return order.GetID(), nil
+	}
+	orderID, err := ob.idempotency.Do(ctx, scope, req, lookup, convert)
 	if err != nil {
-		return nil, data.ErrorConvertToAPI(err)
+		return nil, err
 	}
 
+	return ob.GetOrder(ctx, orderID)
+}
+
+// convertCart creates the order for the cart; the cart is marked converted in
+// the same transaction that persists the order.
+func (ob *orderBusiness) convertCart(
+	ctx context.Context,
+	cart *models.Cart,
+	req *commercev1.CreateOrderFromCartRequest,
+	src orderSource,
+) (*models.Order, error) {
 	if cart.Status != int32(commercev1.CartStatus_CART_STATUS_ACTIVE) {
 		return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("cart is not active"))
 	}
@@ -138,12 +283,14 @@ func (ob *orderBusiness) CreateOrderFromCart(ctx context.Context, req *commercev
 	}
 
 	// Build CreateOrderLine from cart lines
+	src.CartID = cart.GetID()
 	var createLines []*commercev1.CreateOrderLine
 	for _, cartLine := range cart.Lines {
 		createLines = append(createLines, &commercev1.CreateOrderLine{
 			VariantId: cartLine.ProductVariantID,
 			Quantity:  cartLine.Quantity,
 		})
+		src.SlotIDs = append(src.SlotIDs, cartLine.SlotID)
 	}
 
 	orderReq := &commercev1.CreateOrderRequest{
@@ -154,19 +301,7 @@ func (ob *orderBusiness) CreateOrderFromCart(ctx context.Context, req *commercev
 		Lines:     createLines,
 	}
 
-	order, orderErr := ob.CreateOrder(ctx, orderReq)
-	if orderErr != nil {
-		return nil, orderErr
-	}
-
-	// Mark cart as converted
-	cart.Status = int32(commercev1.CartStatus_CART_STATUS_CONVERTED)
-	_, updateErr := ob.cartRepo.Update(ctx, cart, "status")
-	if updateErr != nil {
-		return nil, data.ErrorConvertToAPI(updateErr)
-	}
-
-	return order, nil
+	return ob.createOrder(ctx, orderReq, src)
 }
 
 func (ob *orderBusiness) GetOrder(ctx context.Context, id string) (*commercev1.Order, error) {
@@ -177,6 +312,33 @@ func (ob *orderBusiness) GetOrder(ctx context.Context, id string) (*commercev1.O
 	return order.ToAPI(), nil
 }
 
+// CancelOrder cancels an order that is neither paid nor shipped and gives
+// back the stock it took. Cancelling a cancelled order only makes sure its
+// stock was given back. A paid order is refunded instead, which also gives
+// its stock back.
+func (ob *orderBusiness) CancelOrder(ctx context.Context, orderID string) (*commercev1.Order, error) {
+	wasCancelled, err := ob.orderRepo.Cancel(ctx, orderID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	order, err := ob.orderRepo.GetWithLines(ctx, orderID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if order.Status != int32(commercev1.OrderStatus_ORDER_STATUS_CANCELLED) {
+		return nil, connect.NewError(connect.CodeFailedPrecondition,
+			errors.New("only an unpaid order with nothing shipped can be cancelled"))
+	}
+
+	if _, err = ob.orderRepo.ReleaseStock(ctx, orderID); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if wasCancelled {
+		ob.webhooks.Publish(ctx, order.ShopID, EventOrderCancelled, order.ToAPI())
+	}
+	return ob.GetOrder(ctx, orderID)
+}
+
 func (ob *orderBusiness) ListOrders(ctx context.Context, req *commercev1.ListOrdersRequest) ([]*commercev1.Order, error) {
 	limit := 50
 	offset := 0
@@ -202,44 +364,49 @@ func (ob *orderBusiness) buildOrderLines(
 	ctx context.Context,
 	shopID string,
 	lines []*commercev1.CreateOrderLine,
+	src orderSource,
 ) ([]*models.OrderLine, string, int64, int32, error) {
 	var orderLines []*models.OrderLine
 	var subtotalCurrency string
 	var subtotalUnits int64
 	var subtotalNanos int32
 
-	for _, line := range lines {
-		if line.GetQuantity() <= 0 {
-			return nil, "", 0, 0, connect.NewError(connect.CodeInvalidArgument,
-				fmt.Errorf("quantity must be positive for variant %s", line.GetVariantId()))
-		}
+	shop, err := ob.purchase.shop(ctx, shopID)
+	if err != nil {
+		return nil, "", 0, 0, err
+	}
 
-		variant, err := ob.variantRepo.GetByID(ctx, line.GetVariantId())
-		if err != nil {
-			return nil, "", 0, 0, connect.NewError(connect.CodeNotFound,
-				fmt.Errorf("variant %s not found", line.GetVariantId()))
+	// Every line is checked so the caller learns all that is wrong at once.
+	var problems []LineProblem
+	for i, line := range lines {
+		item, problem, checkErr := ob.purchase.check(ctx, shop, purchaseLine{
+			Index:     i,
+			VariantID: line.GetVariantId(),
+			Quantity:  line.GetQuantity(),
+			SlotID:    src.slotID(i),
+		})
+		if checkErr != nil {
+			return nil, "", 0, 0, checkErr
 		}
-
-		// Validate variant belongs to a product in this shop
-		product, prodErr := variant.Product, error(nil)
-		if product == nil {
-			// Need to load the product to check shop_id
-			_, prodErr = ob.shopRepo.GetByID(ctx, shopID)
-			if prodErr != nil {
-				return nil, "", 0, 0, connect.NewError(connect.CodeNotFound, errors.New("shop not found"))
-			}
+		if problem != nil {
+			problems = append(problems, *problem)
+			continue
+		}
+		if len(problems) > 0 {
+			continue
 		}
+		variant := item.variant
 
-		// Check stock
-		if variant.StockQuantity < line.GetQuantity() {
-			return nil, "", 0, 0, connect.NewError(connect.CodeFailedPrecondition,
-				fmt.Errorf("insufficient stock for variant %s: requested %d, available %d",
-					line.GetVariantId(), line.GetQuantity(), variant.StockQuantity))
+		// Bundles are sold from their components' stock
+		var composition models.BundleComposition
+		if variant.IsBundle() {
+			composition = bundleComposition(item.components)
 		}
 
 		// Compute line total
-		lineTotalUnits := variant.PriceUnits * line.GetQuantity()
-		lineTotalNanos := int32(int64(variant.PriceNanos) * line.GetQuantity())
+		price := src.unitPrice(i, variant)
+		lineTotalUnits := price.GetUnits() * line.GetQuantity()
+		lineTotalNanos := int32(int64(price.GetNanos()) * line.GetQuantity())
 		// Handle nanos overflow
 		lineTotalUnits += int64(lineTotalNanos / 1_000_000_000)
 		lineTotalNanos = lineTotalNanos % 1_000_000_000
@@ -248,19 +415,21 @@ func (ob *orderBusiness) buildOrderLines(
 			ProductVariantID:   variant.GetID(),
 			SKUSnapshot:        variant.SKU,
 			NameSnapshot:       variant.Name,
-			UnitPriceCurrency:  variant.CurrencyCode,
-			UnitPriceUnits:     variant.PriceUnits,
-			UnitPriceNanos:     variant.PriceNanos,
+			UnitPriceCurrency:  price.GetCurrencyCode(),
+			UnitPriceUnits:     price.GetUnits(),
+			UnitPriceNanos:     price.GetNanos(),
 			Quantity:           line.GetQuantity(),
-			TotalPriceCurrency: variant.CurrencyCode,
+			TotalPriceCurrency: price.GetCurrencyCode(),
 			TotalPriceUnits:    lineTotalUnits,
 			TotalPriceNanos:    lineTotalNanos,
+			SlotID:             src.slotID(i),
+			BundleComposition:  composition,
 		}
 		orderLines = append(orderLines, orderLine)
 
 		// Accumulate subtotal
 		if subtotalCurrency == "" {
-			subtotalCurrency = variant.CurrencyCode
+			subtotalCurrency = price.GetCurrencyCode()
 		}
 		subtotalUnits += lineTotalUnits
 		subtotalNanos += lineTotalNanos
@@ -268,9 +437,79 @@ func (ob *orderBusiness) buildOrderLines(
 		subtotalNanos = subtotalNanos % 1_000_000_000
 	}
 
+	if err = lineError(problems); err != nil {
+		return nil, "", 0, 0, err
+	}
 	return orderLines, subtotalCurrency, subtotalUnits, subtotalNanos, nil
 }
 
-func generateOrderNumber() string {
-	return fmt.Sprintf("ORD-%d", time.Now().UnixNano())
+// insertNumberedOrder allocates the next order number for the shop and
+// persists the order with its lines, drawing a fresh number whenever the
+// insert collides with an existing order number. An order converted from a
+// cart that lost the race to a concurrent conversion resolves to the winner.
+func (ob *orderBusiness) insertNumberedOrder(
+	ctx context.Context,
+	shop *models.Shop,
+	order *models.Order,
+	lines []*models.OrderLine,
+) (*models.Order, error) {
+	format := orderNumberFormat(shop)
+
+	for range maxOrderNumberAttempts {
+		seq, err := ob.sequenceRepo.NextValue(ctx, shop.GetID(), orderNumberSequence)
+		if err != nil {
+			return nil, data.ErrorConvertToAPI(err)
+		}
+
+		order.OrderNumber = format.Format(seq, time.Now())
+
+		inserted, err := ob.orderRepo.CreateWithLines(ctx, order, lines)
+		if err != nil {
+			return nil, orderCreateError(err)
+		}
+		if inserted {
+			order.Lines = lines
+			ob.webhooks.Publish(ctx, order.ShopID, EventOrderCreated, order.ToAPI())
+			return order, nil
+		}
+
+		if order.CartID != "" {
+			existing, lookupErr := ob.orderRepo.GetByCartID(ctx, order.CartID)
+			if lookupErr == nil {
+				return existing, nil
+			}
+			if !frame.ErrorIsNotFound(lookupErr) {
+				return nil, data.ErrorConvertToAPI(lookupErr)
+			}
+		}
+	}
+
+	return nil, connect.NewError(connect.CodeAborted,
+		fmt.Errorf("could not allocate a unique order number after %d attempts", maxOrderNumberAttempts))
+}
+
+// orderCreateError maps repository failures while persisting an order to API
+// errors. Stock taken between the checks and the insert is reported as the
+// line's stock problem, the same as one the checks found.
+func orderCreateError(err error) error {
+	var stockErr *repository.InsufficientStockError
+	switch {
+	case errors.As(err, &stockErr):
+		return lineError([]LineProblem{{
+			Line:      stockErr.Line,
+			VariantID: stockErr.VariantID,
+			Reason:    PurchaseInsufficientStock,
+			Requested: stockErr.Requested,
+			Available: stockErr.Available,
+		}})
+	case errors.Is(err, repository.ErrCartNotActive), errors.Is(err, repository.ErrReservationNotHeld):
+		return connect.NewError(connect.CodeFailedPrecondition, err)
+	default:
+		return data.ErrorConvertToAPI(err)
+	}
+}
+
+// cartIdempotencyKey derives the conversion key used when the caller supplied none.
+func cartIdempotencyKey(cartID string) string {
+	return "cart:" + cartID
 }
@@ -0,0 +1,137 @@
+package business
+
+import (
+	"context"
+	"errors"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame/data"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+// PaymentCapture is the outcome of capturing an order's payment. Downloads
+// holds the tokens of its digital lines when this capture delivered them; a
+// retried capture has none, and the buyer is issued fresh ones per
+// entitlement.
+type PaymentCapture struct {
+	Order     *commercev1.Order
+	Downloads []*DownloadToken
+}
+
+type PaymentBusiness interface {
+	CapturePayment(ctx context.Context, orderID string) (*PaymentCapture, error)
+	RefundPayment(ctx context.Context, orderID string) (*commercev1.Order, error)
+}
+
+func NewPaymentBusiness(
+	_ context.Context,
+	orderRepo repository.OrderRepository,
+	digital DigitalBusiness,
+	invoices InvoiceBusiness,
+	webhooks WebhookPublisher,
+) PaymentBusiness {
+	return &paymentBusiness{orderRepo: orderRepo, digital: digital, invoices: invoices, webhooks: webhooks}
+}
+
+type paymentBusiness struct {
+	orderRepo repository.OrderRepository
+	digital   DigitalBusiness
+	invoices  InvoiceBusiness
+	webhooks  WebhookPublisher
+}
+
+// CapturePayment records that the order has been paid, invoices it and
+// delivers its digital lines. An order whose digital lines have no file is
+// refused before it is marked paid. Capturing an already paid order repeats
+// only the invoicing and delivery, which are themselves idempotent, so a
+// capture interrupted part way can be retried.
+func (pb *paymentBusiness) CapturePayment(ctx context.Context, orderID string) (*PaymentCapture, error) {
+	order, err := pb.orderRepo.GetByID(ctx, orderID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if order.Status == int32(commercev1.OrderStatus_ORDER_STATUS_CANCELLED) {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("cannot capture a cancelled order"))
+	}
+
+	paid := int32(commercev1.PaymentStatus_PAYMENT_STATUS_PAID)
+	if order.PaymentStatus != paid {
+		if err = pb.digital.CheckDeliverable(ctx, orderID); err != nil {
+			return nil, err
+		}
+
+		capturable := []int32{
+			int32(commercev1.PaymentStatus_PAYMENT_STATUS_PENDING),
+			int32(commercev1.PaymentStatus_PAYMENT_STATUS_FAILED),
+		}
+		captured, transitionErr := pb.orderRepo.TransitionPaymentStatus(ctx, orderID, capturable, paid)
+		if transitionErr != nil {
+			return nil, data.ErrorConvertToAPI(transitionErr)
+		}
+
+		order, err = pb.orderRepo.GetWithLines(ctx, orderID)
+		if err != nil {
+			return nil, data.ErrorConvertToAPI(err)
+		}
+		if order.PaymentStatus != paid {
+			return nil, connect.NewError(connect.CodeFailedPrecondition,
+				errors.New("order payment cannot be captured in its current state"))
+		}
+		if captured {
+			pb.webhooks.Publish(ctx, order.ShopID, EventOrderPaid, order.ToAPI())
+		}
+	}
+
+	if _, err = pb.invoices.IssueInvoice(ctx, orderID); err != nil {
+		return nil, err
+	}
+	delivery, err := pb.digital.FulfilOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err = pb.orderRepo.GetWithLines(ctx, orderID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return &PaymentCapture{Order: order.ToAPI(), Downloads: delivery.Tokens}, nil
+}
+
+// RefundPayment records that the order's payment was refunded in full and
+// issues the credit note reversing its invoice. The stock the order took and
+// did not ship is given back. Like CapturePayment it can be retried: refunding
+// a refunded order only makes sure its stock and credit note were handled.
+func (pb *paymentBusiness) RefundPayment(ctx context.Context, orderID string) (*commercev1.Order, error) {
+	paid := int32(commercev1.PaymentStatus_PAYMENT_STATUS_PAID)
+	refunded := int32(commercev1.PaymentStatus_PAYMENT_STATUS_REFUNDED)
+
+	wasRefunded, err := pb.orderRepo.TransitionPaymentStatus(ctx, orderID, []int32{paid}, refunded)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	order, err := pb.orderRepo.GetWithLines(ctx, orderID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if order.PaymentStatus != refunded {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("only a paid order can be refunded"))
+	}
+	if wasRefunded {
+		pb.webhooks.Publish(ctx, order.ShopID, EventOrderRefunded, order.ToAPI())
+	}
+
+	if _, err = pb.orderRepo.ReleaseStock(ctx, orderID); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if _, err = pb.invoices.IssueCreditNote(ctx, orderID); err != nil {
+		return nil, err
+	}
+
+	order, err = pb.orderRepo.GetWithLines(ctx, orderID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return order.ToAPI(), nil
+}
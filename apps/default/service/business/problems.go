@@ -0,0 +1,199 @@
+package business
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"connectrpc.com/connect"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	money "google.golang.org/genproto/googleapis/type/money"
+)
+
+// ErrorDomain is the domain of the error details this service attaches.
+const ErrorDomain = "commerce.antinvestor.com"
+
+// LineReason names the rule a cart, order or fulfilment line broke.
+type LineReason string
+
+// Fulfilment reasons a fulfilment line is refused.
+const (
+	FulfilmentLineNotFound  LineReason = "order_line_not_found"
+	FulfilmentOverRemaining LineReason = "quantity_exceeds_remaining"
+)
+
+// LineProblem is why one line of a request cannot be carried out. Line is the
+// line's position in the request. Requested and Available are set for stock
+// and remaining quantity problems. Price is the variant's price now once the
+// variant was found, or for a fulfilment line the price it was sold at.
+type LineProblem struct {
+	Line        int
+	VariantID   string
+	OrderLineID string
+	Reason      LineReason
+	Requested   int64
+	Available   int64
+	Price       *money.Money
+}
+
+func (p LineProblem) String() string {
+	switch p.Reason {
+	case PurchaseInvalidQuantity:
+		return fmt.Sprintf("quantity must be positive for variant %s", p.VariantID)
+	case PurchaseVariantNotFound:
+		return fmt.Sprintf("variant %s not found", p.VariantID)
+	case PurchaseVariantInactive:
+		return fmt.Sprintf("variant %s is not for sale", p.VariantID)
+	case PurchaseProductInactive:
+		return fmt.Sprintf("product of variant %s is not for sale", p.VariantID)
+	case PurchaseWrongShop:
+		return fmt.Sprintf("variant %s belongs to a different shop", p.VariantID)
+	case PurchaseShopInactive:
+		return fmt.Sprintf("shop selling variant %s is not active", p.VariantID)
+	case PurchaseSlotRequired:
+		return fmt.Sprintf("variant %s is booked by time slot and must be bought with a slot", p.VariantID)
+	case PurchaseInsufficientStock:
+		return fmt.Sprintf("insufficient stock for variant %s: requested %d, available %d",
+			p.VariantID, p.Requested, p.Available)
+	case FulfilmentLineNotFound:
+		return fmt.Sprintf("order line %s not found in order", p.OrderLineID)
+	case FulfilmentOverRemaining:
+		return fmt.Sprintf("quantity %d exceeds remaining unfulfilled quantity %d for order line %s",
+			p.Requested, p.Available, p.OrderLineID)
+	}
+	return fmt.Sprintf("line %d cannot be carried out: %s", p.Line, p.Reason)
+}
+
+// detail is the problem as a google.rpc.ErrorInfo. The reason is the upper
+// case reason code and the metadata holds the line's fields, those not set
+// being left out.
+func (p LineProblem) detail() *errdetails.ErrorInfo {
+	metadata := map[string]string{"line": strconv.Itoa(p.Line)}
+	if p.VariantID != "" {
+		metadata["variant_id"] = p.VariantID
+	}
+	if p.OrderLineID != "" {
+		metadata["order_line_id"] = p.OrderLineID
+	}
+	if p.Requested != 0 || p.Available != 0 {
+		metadata["requested"] = strconv.FormatInt(p.Requested, 10)
+		metadata["available"] = strconv.FormatInt(p.Available, 10)
+	}
+	if p.Price != nil {
+		metadata["currency"] = p.Price.GetCurrencyCode()
+		metadata["price"] = DecimalAmount(p.Price.GetUnits(), p.Price.GetNanos())
+	}
+	return &errdetails.ErrorInfo{
+		Reason:   strings.ToUpper(string(p.Reason)),
+		Domain:   ErrorDomain,
+		Metadata: metadata,
+	}
+}
+
+// lineProblemFromDetail reverses detail.
+func lineProblemFromDetail(info *errdetails.ErrorInfo) (LineProblem, error) {
+	metadata := info.GetMetadata()
+	problem := LineProblem{
+		VariantID:   metadata["variant_id"],
+		OrderLineID: metadata["order_line_id"],
+		Reason:      LineReason(strings.ToLower(info.GetReason())),
+	}
+	var err error
+	if problem.Line, err = strconv.Atoi(metadata["line"]); err != nil {
+		return problem, fmt.Errorf("line: %w", err)
+	}
+	if value, ok := metadata["requested"]; ok {
+		if problem.Requested, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return problem, fmt.Errorf("requested: %w", err)
+		}
+	}
+	if value, ok := metadata["available"]; ok {
+		if problem.Available, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return problem, fmt.Errorf("available: %w", err)
+		}
+	}
+	if value, ok := metadata["price"]; ok {
+		units, nanos, parseErr := ParseDecimalAmount(value)
+		if parseErr != nil {
+			return problem, fmt.Errorf("price: %w", parseErr)
+		}
+		problem.Price = &money.Money{CurrencyCode: metadata["currency"], Units: units, Nanos: nanos}
+	}
+	return problem, nil
+}
+
+// LineError reports every line of a request that cannot be carried out. It
+// reaches callers wrapped in a connect error, from which errors.As recovers
+// it, with one ErrorInfo detail per line for clients that only see the wire.
+type LineError struct {
+	Lines []LineProblem
+}
+
+func (e *LineError) Error() string {
+	messages := make([]string, 0, len(e.Lines))
+	for _, line := range e.Lines {
+		messages = append(messages, line.String())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Code is the status the error is reported with: a malformed line makes the
+// request invalid, a missing variant is not found, and otherwise the catalog
+// or order is not in a state to carry out the lines.
+func (e *LineError) Code() connect.Code {
+	code := connect.CodeFailedPrecondition
+	for _, line := range e.Lines {
+		switch line.Reason {
+		case PurchaseInvalidQuantity, PurchaseWrongShop, FulfilmentLineNotFound:
+			return connect.CodeInvalidArgument
+		case PurchaseVariantNotFound:
+			code = connect.CodeNotFound
+		}
+	}
+	return code
+}
+
+// lineError wraps the problems for the API with their details, or returns
+// nil when there are none.
+func lineError(problems []LineProblem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	err := &LineError{Lines: problems}
+	cerr := connect.NewError(err.Code(), err)
+	for _, problem := range problems {
+		detail, detailErr := connect.NewErrorDetail(problem.detail())
+		if detailErr != nil {
+			continue
+		}
+		cerr.AddDetail(detail)
+	}
+	return cerr
+}
+
+// LineProblems reads the line problems from an error's details, as a client
+// holding only the connect error would. It returns nil when there are none.
+func LineProblems(err error) ([]LineProblem, error) {
+	var cerr *connect.Error
+	if !errors.As(err, &cerr) {
+		return nil, nil
+	}
+	var problems []LineProblem
+	for _, detail := range cerr.Details() {
+		value, valueErr := detail.Value()
+		if valueErr != nil {
+			return nil, valueErr
+		}
+		info, ok := value.(*errdetails.ErrorInfo)
+		if !ok || info.GetDomain() != ErrorDomain {
+			continue
+		}
+		problem, parseErr := lineProblemFromDetail(info)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		problems = append(problems, problem)
+	}
+	return problems, nil
+}
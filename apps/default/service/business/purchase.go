@@ -0,0 +1,184 @@
+package business
+
+import (
+	"context"
+	"errors"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame"
+	"github.com/pitabwire/frame/data"
+	money "google.golang.org/genproto/googleapis/type/money"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+// Purchase rule reasons a cart or order line is refused.
+const (
+	PurchaseInvalidQuantity   LineReason = "invalid_quantity"
+	PurchaseVariantNotFound   LineReason = "variant_not_found"
+	PurchaseVariantInactive   LineReason = "variant_inactive"
+	PurchaseProductInactive   LineReason = "product_inactive"
+	PurchaseWrongShop         LineReason = "wrong_shop"
+	PurchaseShopInactive      LineReason = "shop_inactive"
+	PurchaseSlotRequired      LineReason = "slot_required"
+	PurchaseInsufficientStock LineReason = "insufficient_stock"
+)
+
+// purchaseLine is a line to be checked against the purchase rules. SlotID is
+// set for booked lines, which draw on the slot rather than on stock.
+type purchaseLine struct {
+	Index     int
+	VariantID string
+	Quantity  int64
+	SlotID    string
+}
+
+// purchasable is a variant that passed the purchase rules, with what was
+// loaded to check it.
+type purchasable struct {
+	variant    *models.ProductVariant
+	product    *models.Product
+	components []*models.BundleComponent
+	available  int64
+}
+
+func (p *purchasable) booking() bool {
+	return p.product.FulfilmentType == models.FulfilmentTypeBooking
+}
+
+func (p *purchasable) price() *money.Money {
+	return models.MoneyToProto(p.variant.CurrencyCode, p.variant.PriceUnits, p.variant.PriceNanos)
+}
+
+// purchaseRules decide whether a variant may be bought from a shop: the shop
+// must be active, the variant and its product active and sold by that shop,
+// and enough in stock, a bundle's stock being what its components allow.
+// Carts apply them as lines are added and orders again as they are placed.
+type purchaseRules struct {
+	shopRepo    repository.ShopRepository
+	productRepo repository.ProductRepository
+	variantRepo repository.ProductVariantRepository
+	bundleRepo  repository.BundleComponentRepository
+}
+
+func newPurchaseRules(
+	shopRepo repository.ShopRepository,
+	productRepo repository.ProductRepository,
+	variantRepo repository.ProductVariantRepository,
+	bundleRepo repository.BundleComponentRepository,
+) *purchaseRules {
+	return &purchaseRules{
+		shopRepo:    shopRepo,
+		productRepo: productRepo,
+		variantRepo: variantRepo,
+		bundleRepo:  bundleRepo,
+	}
+}
+
+// shop loads the shop lines are bought from.
+func (r *purchaseRules) shop(ctx context.Context, shopID string) (*models.Shop, error) {
+	shop, err := r.shopRepo.GetByID(ctx, shopID)
+	if err != nil {
+		if frame.ErrorIsNotFound(err) {
+			return nil, connect.NewError(connect.CodeNotFound, errors.New("shop not found"))
+		}
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return shop, nil
+}
+
+// check applies every rule to the line. A broken rule is returned as a
+// problem, with the variant when it was found; the error is for failures to
+// load.
+func (r *purchaseRules) check(
+	ctx context.Context,
+	shop *models.Shop,
+	line purchaseLine,
+) (*purchasable, *LineProblem, error) {
+	if line.Quantity <= 0 {
+		return nil, &LineProblem{Line: line.Index, VariantID: line.VariantID, Reason: PurchaseInvalidQuantity}, nil
+	}
+	item, problem, err := r.lookup(ctx, shop, line.Index, line.VariantID)
+	if err != nil || problem != nil {
+		return item, problem, err
+	}
+
+	if item.booking() {
+		if line.SlotID == "" {
+			return item, &LineProblem{
+				Line:      line.Index,
+				VariantID: line.VariantID,
+				Reason:    PurchaseSlotRequired,
+				Price:     item.price(),
+			}, nil
+		}
+		return item, nil, nil
+	}
+	if item.available < line.Quantity {
+		return item, &LineProblem{
+			Line:      line.Index,
+			VariantID: line.VariantID,
+			Reason:    PurchaseInsufficientStock,
+			Requested: line.Quantity,
+			Available: item.available,
+			Price:     item.price(),
+		}, nil
+	}
+	return item, nil, nil
+}
+
+// lookup applies the rules but for quantity and stock, reporting how many of
+// the variant are in stock for callers that size lines to it.
+func (r *purchaseRules) lookup(
+	ctx context.Context,
+	shop *models.Shop,
+	index int,
+	variantID string,
+) (*purchasable, *LineProblem, error) {
+	var item *purchasable
+	problem := func(reason LineReason) *LineProblem {
+		p := &LineProblem{Line: index, VariantID: variantID, Reason: reason}
+		if item != nil {
+			p.Price = item.price()
+		}
+		return p
+	}
+
+	variant, err := r.variantRepo.GetByID(ctx, variantID)
+	if err != nil {
+		if frame.ErrorIsNotFound(err) {
+			return nil, problem(PurchaseVariantNotFound), nil
+		}
+		return nil, nil, data.ErrorConvertToAPI(err)
+	}
+	product, err := r.productRepo.GetByID(ctx, variant.ProductID)
+	if err != nil {
+		if frame.ErrorIsNotFound(err) {
+			return nil, problem(PurchaseVariantNotFound), nil
+		}
+		return nil, nil, data.ErrorConvertToAPI(err)
+	}
+	item = &purchasable{variant: variant, product: product, available: variant.StockQuantity}
+
+	switch {
+	case product.ShopID != shop.GetID():
+		return item, problem(PurchaseWrongShop), nil
+	case shop.Status != int32(commercev1.ShopStatus_SHOP_STATUS_ACTIVE):
+		return item, problem(PurchaseShopInactive), nil
+	case product.Status != int32(commercev1.ProductStatus_PRODUCT_STATUS_ACTIVE):
+		return item, problem(PurchaseProductInactive), nil
+	case variant.Status != int32(commercev1.ProductVariantStatus_PRODUCT_VARIANT_STATUS_ACTIVE):
+		return item, problem(PurchaseVariantInactive), nil
+	}
+
+	if variant.IsBundle() {
+		item.components, err = r.bundleRepo.ListByBundleID(ctx, variant.GetID())
+		if err != nil {
+			return nil, nil, data.ErrorConvertToAPI(err)
+		}
+		item.available = BundleStock(item.components)
+	}
+	return item, nil, nil
+}
@@ -0,0 +1,199 @@
+package business
+
+import (
+	"context"
+	"errors"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame/data"
+	money "google.golang.org/genproto/googleapis/type/money"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+const (
+	defaultOrderHistoryLimit = 50
+	maxOrderHistoryLimit     = 200
+)
+
+// ReorderProblem names why a past order line was not reordered as it was.
+type ReorderProblem string
+
+const (
+	// ReorderUnavailable marks a line whose variant is gone, disabled, no
+	// longer sold by the shop, or booked by time slot, so it was left out.
+	ReorderUnavailable ReorderProblem = "unavailable"
+	// ReorderOutOfStock marks a line left out because none is in stock.
+	ReorderOutOfStock ReorderProblem = "out_of_stock"
+	// ReorderQuantityReduced marks a line added with less than was ordered,
+	// all that is in stock.
+	ReorderQuantityReduced ReorderProblem = "quantity_reduced"
+	// ReorderPriceChanged marks a line added whose price is no longer the
+	// one paid.
+	ReorderPriceChanged ReorderProblem = "price_changed"
+)
+
+// ReorderIssue reports a past order line that could not be reordered as it
+// was; a line with two problems is reported once for each. Prices are set
+// only for ReorderPriceChanged.
+type ReorderIssue struct {
+	OrderLineID      string
+	ProductVariantID string
+	SKU              string
+	Name             string
+	Problem          ReorderProblem
+	Quantity         int64
+	AddedQuantity    int64
+	PreviousPrice    *money.Money
+	CurrentPrice     *money.Money
+}
+
+// ReorderResult is the cart a reorder built and the lines it could not
+// carry over unchanged.
+type ReorderResult struct {
+	Cart   *commercev1.Cart
+	Issues []ReorderIssue
+}
+
+// ListMyOrders lists a customer's orders, newest first, across shops or in
+// the one the filter names.
+func (ob *orderBusiness) ListMyOrders(
+	ctx context.Context,
+	filter repository.OrderHistoryFilter,
+	limit, offset int,
+) ([]*commercev1.Order, error) {
+	if filter.ProfileID == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("profile_id is required"))
+	}
+	if limit <= 0 {
+		limit = defaultOrderHistoryLimit
+	}
+	limit = min(limit, maxOrderHistoryLimit)
+	offset = max(offset, 0)
+
+	orders, err := ob.orderRepo.ListByProfile(ctx, filter, limit, offset)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	result := make([]*commercev1.Order, 0, len(orders))
+	for _, o := range orders {
+		result = append(result, o.ToAPI())
+	}
+	return result, nil
+}
+
+// Reorder starts a new cart for the order's customer holding the order's
+// lines at today's prices. Lines no longer sold are left out and lines short
+// of stock are cut to what remains; those, and lines whose price changed,
+// are reported with the cart. Booked lines are left out since their slot has
+// passed; they are booked again through BookingBusiness.
+func (ob *orderBusiness) Reorder(ctx context.Context, orderID string) (*ReorderResult, error) {
+	order, err := ob.orderRepo.GetWithLines(ctx, orderID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	shop, err := ob.shopRepo.GetByID(ctx, order.ShopID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if shop.Status != int32(commercev1.ShopStatus_SHOP_STATUS_ACTIVE) {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("shop is not active"))
+	}
+
+	var issues []ReorderIssue
+	var variantIDs []string
+	quantities := map[string]int64{}
+	for i, line := range order.Lines {
+		variant, available, problem, checkErr := ob.reorderAvailability(ctx, shop, i, line)
+		if checkErr != nil {
+			return nil, checkErr
+		}
+		issue := ReorderIssue{
+			OrderLineID:      line.GetID(),
+			ProductVariantID: line.ProductVariantID,
+			SKU:              line.SKUSnapshot,
+			Name:             line.NameSnapshot,
+			Quantity:         line.Quantity,
+		}
+		if problem != "" {
+			issue.Problem = problem
+			issues = append(issues, issue)
+			continue
+		}
+
+		// Stock is shared by every line of the variant.
+		added := min(line.Quantity, available-quantities[variant.GetID()])
+		if added <= 0 {
+			issue.Problem = ReorderOutOfStock
+			issues = append(issues, issue)
+			continue
+		}
+		if _, seen := quantities[variant.GetID()]; !seen {
+			variantIDs = append(variantIDs, variant.GetID())
+		}
+		quantities[variant.GetID()] += added
+		issue.AddedQuantity = added
+		if added < line.Quantity {
+			issue.Problem = ReorderQuantityReduced
+			issues = append(issues, issue)
+		}
+
+		if variant.CurrencyCode != line.UnitPriceCurrency || variant.PriceUnits != line.UnitPriceUnits ||
+			variant.PriceNanos != line.UnitPriceNanos {
+			issue.Problem = ReorderPriceChanged
+			issue.PreviousPrice = &money.Money{
+				CurrencyCode: line.UnitPriceCurrency, Units: line.UnitPriceUnits, Nanos: line.UnitPriceNanos,
+			}
+			issue.CurrentPrice = &money.Money{
+				CurrencyCode: variant.CurrencyCode, Units: variant.PriceUnits, Nanos: variant.PriceNanos,
+			}
+			issues = append(issues, issue)
+		}
+	}
+
+	cart := &models.Cart{
+		ShopID:    order.ShopID,
+		Status:    int32(commercev1.CartStatus_CART_STATUS_ACTIVE),
+		ProfileID: order.ProfileID,
+		ContactID: order.ContactID,
+	}
+	if err = ob.cartRepo.Create(ctx, cart); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	for _, variantID := range variantIDs {
+		line := &models.CartLine{CartID: cart.GetID(), ProductVariantID: variantID, Quantity: quantities[variantID]}
+		if err = ob.cartLineRepo.Create(ctx, line); err != nil {
+			return nil, data.ErrorConvertToAPI(err)
+		}
+	}
+
+	cart, err = ob.cartRepo.GetWithLines(ctx, cart.GetID())
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return &ReorderResult{Cart: cart.ToAPI(), Issues: issues}, nil
+}
+
+// reorderAvailability loads the line's variant and how many of it may be
+// ordered, or the problem that keeps it from being reordered at all. A line
+// breaking any purchase rule but stock is unavailable.
+func (ob *orderBusiness) reorderAvailability(
+	ctx context.Context,
+	shop *models.Shop,
+	index int,
+	line *models.OrderLine,
+) (*models.ProductVariant, int64, ReorderProblem, error) {
+	if line.SlotID != "" {
+		return nil, 0, ReorderUnavailable, nil
+	}
+	item, problem, err := ob.purchase.lookup(ctx, shop, index, line.ProductVariantID)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if problem != nil || item.booking() {
+		return nil, 0, ReorderUnavailable, nil
+	}
+	return item.variant, item.available, "", nil
+}
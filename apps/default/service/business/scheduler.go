@@ -0,0 +1,48 @@
+package business
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pitabwire/util"
+)
+
+// ScheduledTask is background work the service repeats on an interval.
+type ScheduledTask struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// RunScheduler runs every task on its own interval until ctx is done. A
+// failing run is logged and retried on the next tick, so one task cannot stop
+// the others or the service.
+func RunScheduler(ctx context.Context, tasks ...ScheduledTask) error {
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runScheduledTask(ctx, task)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func runScheduledTask(ctx context.Context, task ScheduledTask) {
+	ticker := time.NewTicker(task.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := task.Run(ctx); err != nil {
+				util.Log(ctx).WithError(err).With("task", task.Name).Error("scheduled task failed")
+			}
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame"
+	"github.com/pitabwire/frame/data"
+	"github.com/pitabwire/util"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+// ShippingBusiness connects fulfilments to carriers: it quotes and buys
+// labels, and records the carriers' tracking updates against the fulfilments
+// they belong to.
+type ShippingBusiness interface {
+	QuoteShipping(ctx context.Context, fulfilmentID, carrier string) ([]RateQuote, error)
+	BuyLabel(ctx context.Context, fulfilmentID, carrier, service string) (*ShippingLabel, error)
+	SyncTracking(ctx context.Context, fulfilmentID string) (int, error)
+	ReceiveTrackingWebhook(ctx context.Context, carrier string, header http.Header, body []byte) (int, error)
+	RecordTrackingUpdates(ctx context.Context, carrier string, updates []TrackingUpdate) (int, error)
+	ListTrackingEvents(ctx context.Context, fulfilmentID string) ([]*models.FulfilmentTrackingEvent, error)
+}
+
+func NewShippingBusiness(
+	_ context.Context,
+	carriers CarrierRegistry,
+	fulfilmentRepo repository.FulfilmentRepository,
+	fulfilmentLineRepo repository.FulfilmentLineRepository,
+	trackingRepo repository.FulfilmentTrackingEventRepository,
+	orderRepo repository.OrderRepository,
+	webhooks WebhookPublisher,
+) ShippingBusiness {
+	return &shippingBusiness{
+		carriers:           carriers,
+		fulfilmentRepo:     fulfilmentRepo,
+		fulfilmentLineRepo: fulfilmentLineRepo,
+		trackingRepo:       trackingRepo,
+		orderRepo:          orderRepo,
+		webhooks:           webhooks,
+	}
+}
+
+type shippingBusiness struct {
+	carriers           CarrierRegistry
+	fulfilmentRepo     repository.FulfilmentRepository
+	fulfilmentLineRepo repository.FulfilmentLineRepository
+	trackingRepo       repository.FulfilmentTrackingEventRepository
+	orderRepo          repository.OrderRepository
+	webhooks           WebhookPublisher
+}
+
+func (sb *shippingBusiness) carrier(name string) (CarrierProvider, error) {
+	provider, ok := sb.carriers[name]
+	if !ok {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("carrier %q is not configured", name))
+	}
+	return provider, nil
+}
+
+func (sb *shippingBusiness) QuoteShipping(ctx context.Context, fulfilmentID, carrier string) ([]RateQuote, error) {
+	provider, err := sb.carrier(carrier)
+	if err != nil {
+		return nil, err
+	}
+	_, shipment, err := sb.shipment(ctx, fulfilmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	quotes, err := provider.QuoteRates(ctx, shipment)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeUnavailable, fmt.Errorf("quote rates: %w", err))
+	}
+	return quotes, nil
+}
+
+// BuyLabel buys a shipping label and records the carrier and tracking number
+// on the fulfilment. A fulfilment gets one label; cancelled fulfilments and
+// those already on their way get none.
+func (sb *shippingBusiness) BuyLabel(
+	ctx context.Context,
+	fulfilmentID, carrier, service string,
+) (*ShippingLabel, error) {
+	provider, err := sb.carrier(carrier)
+	if err != nil {
+		return nil, err
+	}
+	fulfilment, shipment, err := sb.shipment(ctx, fulfilmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := commercev1.FulfilmentStatus(fulfilment.Status)
+	if status == commercev1.FulfilmentStatus_FULFILMENT_STATUS_CANCELLED ||
+		fulfilmentStage(status) >= fulfilmentStage(commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED) {
+		return nil, connect.NewError(connect.CodeFailedPrecondition,
+			fmt.Errorf("cannot buy a label for a %s fulfilment", status))
+	}
+	if fulfilment.TrackingNumber != "" {
+		return nil, connect.NewError(connect.CodeFailedPrecondition,
+			errors.New("fulfilment already has a tracking number"))
+	}
+
+	label, err := provider.CreateLabel(ctx, shipment, service)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeUnavailable, fmt.Errorf("create label: %w", err))
+	}
+
+	fulfilment.Carrier = provider.Name()
+	fulfilment.TrackingNumber = label.TrackingNumber
+	if _, err = sb.fulfilmentRepo.Update(ctx, fulfilment, "carrier", "tracking_number"); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return label, nil
+}
+
+// SyncTracking polls the fulfilment's carrier for tracking updates, for
+// carriers whose webhooks may have been missed.
+func (sb *shippingBusiness) SyncTracking(ctx context.Context, fulfilmentID string) (int, error) {
+	fulfilment, err := sb.fulfilmentRepo.GetByID(ctx, fulfilmentID)
+	if err != nil {
+		return 0, data.ErrorConvertToAPI(err)
+	}
+	if fulfilment.TrackingNumber == "" {
+		return 0, connect.NewError(connect.CodeFailedPrecondition, errors.New("fulfilment has no tracking number"))
+	}
+	provider, err := sb.carrier(fulfilment.Carrier)
+	if err != nil {
+		return 0, err
+	}
+
+	updates, err := provider.Track(ctx, fulfilment.TrackingNumber)
+	if err != nil {
+		return 0, connect.NewError(connect.CodeUnavailable, fmt.Errorf("track shipment: %w", err))
+	}
+	return sb.RecordTrackingUpdates(ctx, provider.Name(), updates)
+}
+
+// ReceiveTrackingWebhook verifies and records a carrier's tracking webhook.
+func (sb *shippingBusiness) ReceiveTrackingWebhook(
+	ctx context.Context,
+	carrier string,
+	header http.Header,
+	body []byte,
+) (int, error) {
+	provider, err := sb.carrier(carrier)
+	if err != nil {
+		return 0, err
+	}
+
+	updates, err := provider.ParseWebhook(header, body)
+	if errors.Is(err, ErrInvalidWebhookSignature) {
+		return 0, connect.NewError(connect.CodeUnauthenticated, err)
+	}
+	if err != nil {
+		return 0, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return sb.RecordTrackingUpdates(ctx, provider.Name(), updates)
+}
+
+// RecordTrackingUpdates stores the carrier's updates against the fulfilments
+// they track and returns how many were new. An update moves its fulfilment
+// forward when the state machine allows it; updates arriving out of order,
+// or for steps the fulfilment has passed, are kept only as history. Updates
+// for unknown tracking numbers are skipped.
+//
+// Fulfilments move before their events are stored, so an update that failed
+// part way is applied in full when the carrier delivers it again.
+func (sb *shippingBusiness) RecordTrackingUpdates(
+	ctx context.Context,
+	carrier string,
+	updates []TrackingUpdate,
+) (int, error) {
+	recorded := 0
+	// advanced holds the fulfilments moved forward, by order.
+	advanced := map[string][]string{}
+
+	for _, update := range updates {
+		fulfilment, err := sb.fulfilmentRepo.GetByTrackingNumber(ctx, carrier, update.TrackingNumber)
+		if frame.ErrorIsNotFound(err) {
+			util.Log(ctx).With("carrier", carrier).With("tracking_number", update.TrackingNumber).
+				Warn("tracking update for an unknown shipment")
+			continue
+		}
+		if err != nil {
+			return recorded, data.ErrorConvertToAPI(err)
+		}
+
+		occurredAt := update.OccurredAt
+		if occurredAt.IsZero() {
+			occurredAt = time.Now()
+		}
+
+		current := commercev1.FulfilmentStatus(fulfilment.Status)
+		if fulfilmentStage(update.Status) > fulfilmentStage(current) &&
+			ValidateFulfilmentTransition(current, update.Status) == nil {
+			columns := advanceFulfilment(fulfilment, update.Status, occurredAt)
+			if _, err = sb.fulfilmentRepo.Update(ctx, fulfilment, columns...); err != nil {
+				return recorded, data.ErrorConvertToAPI(err)
+			}
+			if !slices.Contains(advanced[fulfilment.OrderID], fulfilment.GetID()) {
+				advanced[fulfilment.OrderID] = append(advanced[fulfilment.OrderID], fulfilment.GetID())
+			}
+		}
+
+		event := &models.FulfilmentTrackingEvent{
+			FulfilmentID:   fulfilment.GetID(),
+			Carrier:        carrier,
+			EventID:        update.EventID,
+			TrackingNumber: update.TrackingNumber,
+			Status:         int32(update.Status),
+			Description:    update.Description,
+			Location:       update.Location,
+			OccurredAt:     occurredAt,
+		}
+		event.CopyPartitionInfo(&fulfilment.BaseModel)
+
+		created, err := sb.trackingRepo.TryCreate(ctx, event)
+		if err != nil {
+			return recorded, data.ErrorConvertToAPI(err)
+		}
+		if created {
+			recorded++
+		}
+	}
+
+	for orderID, fulfilmentIDs := range advanced {
+		order, err := sb.orderRepo.GetWithLines(ctx, orderID)
+		if err != nil {
+			return recorded, data.ErrorConvertToAPI(err)
+		}
+		if err = refreshOrderFulfilmentStatus(ctx, sb.orderRepo, sb.fulfilmentLineRepo, order); err != nil {
+			return recorded, err
+		}
+		for _, fulfilmentID := range fulfilmentIDs {
+			fulfilment, getErr := sb.fulfilmentRepo.GetWithLines(ctx, fulfilmentID)
+			if getErr != nil {
+				return recorded, data.ErrorConvertToAPI(getErr)
+			}
+			sb.webhooks.Publish(ctx, order.ShopID, EventFulfilmentUpdated, fulfilment.ToAPI())
+		}
+	}
+	return recorded, nil
+}
+
+func (sb *shippingBusiness) ListTrackingEvents(
+	ctx context.Context,
+	fulfilmentID string,
+) ([]*models.FulfilmentTrackingEvent, error) {
+	events, err := sb.trackingRepo.ListByFulfilmentID(ctx, fulfilmentID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return events, nil
+}
+
+// shipment describes the fulfilment to a carrier.
+func (sb *shippingBusiness) shipment(ctx context.Context, fulfilmentID string) (*models.Fulfilment, Shipment, error) {
+	fulfilment, err := sb.fulfilmentRepo.GetWithLines(ctx, fulfilmentID)
+	if err != nil {
+		return nil, Shipment{}, data.ErrorConvertToAPI(err)
+	}
+	order, err := sb.orderRepo.GetByID(ctx, fulfilment.OrderID)
+	if err != nil {
+		return nil, Shipment{}, data.ErrorConvertToAPI(err)
+	}
+
+	var items int64
+	for _, line := range fulfilment.Lines {
+		items += line.Quantity
+	}
+	return fulfilment, Shipment{
+		FulfilmentID: fulfilment.GetID(),
+		AddressID:    order.AddressID,
+		Items:        items,
+		Currency:     order.TotalCurrency,
+	}, nil
+}
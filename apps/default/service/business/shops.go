@@ -3,6 +3,7 @@ package business
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
 
 	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
@@ -18,14 +19,33 @@ type ShopBusiness interface {
 	CreateShop(ctx context.Context, req *commercev1.CreateShopRequest) (*commercev1.Shop, error)
 	GetShop(ctx context.Context, id string) (*commercev1.Shop, error)
 	UpdateShop(ctx context.Context, req *commercev1.UpdateShopRequest) (*commercev1.Shop, error)
+	AddShopMember(ctx context.Context, shopID, profileID, role string) (*models.ShopMember, error)
+	RemoveShopMember(ctx context.Context, shopID, profileID string) error
+	ListShopMembers(ctx context.Context, shopID string) ([]*models.ShopMember, error)
+	GetShopBySlug(ctx context.Context, slug string) (*ShopSlugMatch, error)
+	ChangeShopSlug(ctx context.Context, shopID, slug string) (*commercev1.Shop, error)
 }
 
-func NewShopBusiness(_ context.Context, shopRepo repository.ShopRepository) ShopBusiness {
-	return &shopBusiness{shopRepo: shopRepo}
+// ShopSlugMatch is the shop a slug resolves to. Redirected is set when the
+// slug is a retired one; callers should redirect to Shop.Slug.
+type ShopSlugMatch struct {
+	Shop       *commercev1.Shop
+	Redirected bool
+}
+
+func NewShopBusiness(
+	_ context.Context,
+	shopRepo repository.ShopRepository,
+	memberRepo repository.ShopMemberRepository,
+	redirectRepo repository.SlugRedirectRepository,
+) ShopBusiness {
+	return &shopBusiness{shopRepo: shopRepo, memberRepo: memberRepo, redirectRepo: redirectRepo}
 }
 
 type shopBusiness struct {
-	shopRepo repository.ShopRepository
+	shopRepo     repository.ShopRepository
+	memberRepo   repository.ShopMemberRepository
+	redirectRepo repository.SlugRedirectRepository
 }
 
 func (sb *shopBusiness) CreateShop(ctx context.Context, req *commercev1.CreateShopRequest) (*commercev1.Shop, error) {
@@ -34,33 +54,143 @@ func (sb *shopBusiness) CreateShop(ctx context.Context, req *commercev1.CreateSh
 		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("shop name is required"))
 	}
 
-	slug := strings.TrimSpace(req.GetSlug())
+	shop := &models.Shop{
+		Name:        name,
+		Description: req.GetDescription(),
+		Status:      int32(commercev1.ShopStatus_SHOP_STATUS_ACTIVE),
+		MediaIDs:    models.StringArray(req.GetMediaIds()),
+		Properties:  data.JSONMap{},
+	}
+
+	// A requested slug is taken as is; one derived from the name is deduped.
+	if requested := strings.TrimSpace(req.GetSlug()); requested != "" {
+		if err := sb.createWithSlug(ctx, shop, requested); err != nil {
+			return nil, err
+		}
+	} else {
+		base := Slugify(name)
+		if base == "" {
+			base = slugEntityShop
+		}
+		if _, err := claimSlug(ctx, base, sb.slugTaken, func(ctx context.Context, slug string) (bool, error) {
+			shop.Slug = slug
+			return sb.insertShop(ctx, shop)
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return shop.ToAPI(), nil
+}
+
+func (sb *shopBusiness) createWithSlug(ctx context.Context, shop *models.Shop, requested string) error {
+	slug := Slugify(requested)
 	if slug == "" {
-		slug = strings.ToLower(strings.ReplaceAll(name, " ", "-"))
+		return connect.NewError(connect.CodeInvalidArgument, errors.New("shop slug must contain letters or digits"))
+	}
+
+	taken, err := sb.slugTaken(ctx, slug)
+	if err != nil {
+		return err
+	}
+	if !taken {
+		shop.Slug = slug
+		inserted, insertErr := sb.insertShop(ctx, shop)
+		if insertErr != nil {
+			return insertErr
+		}
+		taken = !inserted
+	}
+	if taken {
+		return connect.NewError(connect.CodeAlreadyExists, errors.New("shop with this slug already exists"))
+	}
+	return nil
+}
+
+// insertShop writes the shop together with its creator's owner membership,
+// so a shop is never left without an owner.
+func (sb *shopBusiness) insertShop(ctx context.Context, shop *models.Shop) (bool, error) {
+	var owner *models.ShopMember
+	if profileID := callerProfileID(ctx); profileID != "" {
+		owner = &models.ShopMember{ProfileID: profileID, Role: RoleOwner}
+	}
+	inserted, err := sb.shopRepo.TryCreate(ctx, shop, owner)
+	if err != nil {
+		return false, data.ErrorConvertToAPI(err)
 	}
+	return inserted, nil
+}
 
-	// Check slug uniqueness
+// slugTaken reports whether a shop in the tenant holds or has retired slug.
+func (sb *shopBusiness) slugTaken(ctx context.Context, slug string) (bool, error) {
+	return sb.slugTakenBy(ctx, slug, "")
+}
+
+func (sb *shopBusiness) slugTakenBy(ctx context.Context, slug, shopID string) (bool, error) {
 	existing, err := sb.shopRepo.GetBySlug(ctx, slug)
-	if err == nil && existing != nil {
-		return nil, connect.NewError(connect.CodeAlreadyExists, errors.New("shop with this slug already exists"))
+	if err == nil {
+		return existing.GetID() != shopID, nil
 	}
-	if err != nil && !frame.ErrorIsNotFound(err) {
-		return nil, err
+	if !frame.ErrorIsNotFound(err) {
+		return false, data.ErrorConvertToAPI(err)
 	}
+	return slugRedirectTaken(ctx, sb.redirectRepo, slugEntityShop, "", slug, shopID)
+}
 
-	shop := &models.Shop{
-		Name:        name,
-		Slug:        slug,
-		Description: req.GetDescription(),
-		Status:      int32(commercev1.ShopStatus_SHOP_STATUS_ACTIVE),
-		MediaIDs:    models.StringArray(req.GetMediaIds()),
-		Properties:  data.JSONMap{},
+// GetShopBySlug resolves a current or retired shop slug.
+func (sb *shopBusiness) GetShopBySlug(ctx context.Context, slug string) (*ShopSlugMatch, error) {
+	shop, err := sb.shopRepo.GetBySlug(ctx, slug)
+	if err == nil {
+		return &ShopSlugMatch{Shop: shop.ToAPI()}, nil
+	}
+	if !frame.ErrorIsNotFound(err) {
+		return nil, data.ErrorConvertToAPI(err)
 	}
 
-	if createErr := sb.shopRepo.Create(ctx, shop); createErr != nil {
-		return nil, data.ErrorConvertToAPI(createErr)
+	redirect, err := sb.redirectRepo.Find(ctx, slugEntityShop, "", slug)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	shop, err = sb.shopRepo.GetByID(ctx, redirect.EntityID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return &ShopSlugMatch{Shop: shop.ToAPI(), Redirected: true}, nil
+}
+
+// ChangeShopSlug moves the shop to a new slug. The old slug keeps resolving
+// to the shop through GetShopBySlug.
+func (sb *shopBusiness) ChangeShopSlug(ctx context.Context, shopID, requested string) (*commercev1.Shop, error) {
+	shop, err := sb.shopRepo.GetByID(ctx, shopID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
 	}
 
+	slug := Slugify(requested)
+	if slug == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("shop slug must contain letters or digits"))
+	}
+	if slug == shop.Slug {
+		return shop.ToAPI(), nil
+	}
+
+	taken, err := sb.slugTakenBy(ctx, slug, shop.GetID())
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, connect.NewError(connect.CodeAlreadyExists, errors.New("shop with this slug already exists"))
+	}
+
+	// Retire the old slug first: should the update fail, the old slug is
+	// still current and wins over its own redirect.
+	if err = retireSlug(ctx, sb.redirectRepo, slugEntityShop, "", shop.GetID(), shop.Slug, slug); err != nil {
+		return nil, err
+	}
+	shop.Slug = slug
+	if _, err = sb.shopRepo.Update(ctx, shop, "slug"); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
 	return shop.ToAPI(), nil
 }
 
@@ -121,3 +251,82 @@ func (sb *shopBusiness) UpdateShop(ctx context.Context, req *commercev1.UpdateSh
 
 	return shop.ToAPI(), nil
 }
+
+// AddShopMember grants the profile a role in the shop, replacing any role it
+// already holds.
+func (sb *shopBusiness) AddShopMember(ctx context.Context, shopID, profileID, role string) (*models.ShopMember, error) {
+	if strings.TrimSpace(profileID) == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("profile id is required"))
+	}
+	if !IsValidRole(role) {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unknown shop role %q", role))
+	}
+
+	existing, err := sb.memberRepo.GetByShopAndProfile(ctx, shopID, profileID)
+	if err == nil {
+		if existing.Role == role {
+			return existing, nil
+		}
+		if existing.Role == RoleOwner {
+			if ownerErr := sb.ensureAnotherOwner(ctx, shopID, profileID); ownerErr != nil {
+				return nil, ownerErr
+			}
+		}
+		existing.Role = role
+		if _, updateErr := sb.memberRepo.Update(ctx, existing, "role"); updateErr != nil {
+			return nil, data.ErrorConvertToAPI(updateErr)
+		}
+		return existing, nil
+	}
+	if !frame.ErrorIsNotFound(err) {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	member := &models.ShopMember{ShopID: shopID, ProfileID: profileID, Role: role}
+	if createErr := sb.memberRepo.Create(ctx, member); createErr != nil {
+		return nil, data.ErrorConvertToAPI(createErr)
+	}
+	return member, nil
+}
+
+// RemoveShopMember revokes the profile's role in the shop. The last owner
+// cannot be removed.
+func (sb *shopBusiness) RemoveShopMember(ctx context.Context, shopID, profileID string) error {
+	existing, err := sb.memberRepo.GetByShopAndProfile(ctx, shopID, profileID)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+
+	if existing.Role == RoleOwner {
+		if ownerErr := sb.ensureAnotherOwner(ctx, shopID, profileID); ownerErr != nil {
+			return ownerErr
+		}
+	}
+
+	if removeErr := sb.memberRepo.Remove(ctx, shopID, profileID); removeErr != nil {
+		return data.ErrorConvertToAPI(removeErr)
+	}
+	return nil
+}
+
+func (sb *shopBusiness) ListShopMembers(ctx context.Context, shopID string) ([]*models.ShopMember, error) {
+	members, err := sb.memberRepo.ListByShopID(ctx, shopID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return members, nil
+}
+
+// ensureAnotherOwner fails unless the shop keeps an owner other than profileID.
+func (sb *shopBusiness) ensureAnotherOwner(ctx context.Context, shopID, profileID string) error {
+	members, err := sb.memberRepo.ListByShopID(ctx, shopID)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	for _, member := range members {
+		if member.Role == RoleOwner && member.ProfileID != profileID {
+			return nil
+		}
+	}
+	return connect.NewError(connect.CodeFailedPrecondition, errors.New("a shop must keep at least one owner"))
+}
@@ -0,0 +1,115 @@
+package business
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+const (
+	// SKUTemplateKey is the shop property holding the template used to
+	// generate a SKU when a variant is created without one. Shops without a
+	// template must supply SKUs explicitly.
+	SKUTemplateKey = "sku_template"
+
+	// MaxSKULength is the longest SKU accepted.
+	MaxSKULength = 64
+
+	skuSequence = "sku"
+	// maxSKUAttempts bounds how many suffixes are tried when a generated SKU collides.
+	maxSKUAttempts = 20
+)
+
+//nolint:gochecknoglobals // compiled once
+var (
+	skuPattern        = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	skuInvalidChars   = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+	skuRepeatedDashes = regexp.MustCompile(`-{2,}`)
+)
+
+// ValidateSKU checks that sku is non-empty, at most MaxSKULength characters
+// and made of letters, digits, '.', '_' and '-', starting with a letter or digit.
+func ValidateSKU(sku string) error {
+	if sku == "" {
+		return errors.New("sku is required")
+	}
+	if len(sku) > MaxSKULength {
+		return fmt.Errorf("sku must be at most %d characters", MaxSKULength)
+	}
+	if !skuPattern.MatchString(sku) {
+		return errors.New("sku may only contain letters, digits, '.', '_' and '-' and must start with a letter or digit")
+	}
+	return nil
+}
+
+// SKUTemplate renders SKUs for generated variants.
+//
+// Template supports the placeholders {product}, {options} and {seq}: the
+// product slug, the variant's option values ordered by option name and a
+// per-shop sequence number. The result is upper cased and stripped of
+// characters a SKU cannot hold.
+type SKUTemplate struct {
+	Template string
+}
+
+// Render produces a SKU from the template.
+func (st SKUTemplate) Render(product string, options map[string]string, seq int64) string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make([]string, 0, len(names))
+	for _, name := range names {
+		if value := strings.TrimSpace(options[name]); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	replacer := strings.NewReplacer(
+		"{product}", product,
+		"{options}", strings.Join(values, "-"),
+		"{seq}", strconv.FormatInt(seq, 10),
+	)
+
+	return normaliseSKU(replacer.Replace(st.Template))
+}
+
+// normaliseSKU upper cases the value and replaces anything a SKU cannot hold.
+func normaliseSKU(value string) string {
+	sku := strings.ToUpper(strings.TrimSpace(value))
+	sku = skuInvalidChars.ReplaceAllString(sku, "-")
+	sku = skuRepeatedDashes.ReplaceAllString(sku, "-")
+	sku = strings.Trim(sku, "-._")
+	if len(sku) > MaxSKULength {
+		sku = strings.TrimRight(sku[:MaxSKULength], "-._")
+	}
+	return sku
+}
+
+// withSKUSuffix appends a disambiguating suffix while staying within MaxSKULength.
+func withSKUSuffix(sku string, n int) string {
+	suffix := "-" + strconv.Itoa(n)
+	if len(sku)+len(suffix) > MaxSKULength {
+		sku = strings.TrimRight(sku[:MaxSKULength-len(suffix)], "-._")
+	}
+	return sku + suffix
+}
+
+// skuTemplateFromShop returns the shop's SKU template, if it configured one.
+func skuTemplateFromShop(shop *models.Shop) (SKUTemplate, bool) {
+	if shop == nil || shop.Properties == nil {
+		return SKUTemplate{}, false
+	}
+	template := strings.TrimSpace(shop.Properties.GetString(SKUTemplateKey))
+	if template == "" {
+		return SKUTemplate{}, false
+	}
+	return SKUTemplate{Template: template}, true
+}
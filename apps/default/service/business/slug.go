@@ -0,0 +1,288 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame"
+	"github.com/pitabwire/frame/data"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+const (
+	// MaxSlugLength is the longest slug generated or accepted.
+	MaxSlugLength = 100
+
+	slugEntityShop    = "shop"
+	slugEntityProduct = "product"
+
+	// maxSlugAttempts bounds how many dedupe suffixes are tried for a slug.
+	maxSlugAttempts = 50
+)
+
+// slugTransliterations spells out letters that do not decompose into an
+// ASCII base letter plus combining marks.
+//
+//nolint:gochecknoglobals // static lookup table
+var slugTransliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'þ': "th",
+	'ł': "l", 'ı': "i", 'ħ': "h", 'ŋ': "n", '&': " and ",
+}
+
+// Slugify turns value into a lower case, dash separated slug made of ASCII
+// letters and digits. Accented letters are transliterated to their base
+// letter; characters with no ASCII spelling are dropped. The result is at
+// most MaxSlugLength characters and may be empty.
+func Slugify(value string) string {
+	var b strings.Builder
+	pendingDash := false
+	write := func(r rune) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if pendingDash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			pendingDash = false
+			b.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+			// Combining marks left over from decomposing an accented letter.
+		default:
+			pendingDash = true
+		}
+	}
+
+	for _, r := range norm.NFKD.String(strings.ToLower(value)) {
+		if spelled, ok := slugTransliterations[r]; ok {
+			for _, sr := range spelled {
+				write(sr)
+			}
+			continue
+		}
+		write(r)
+	}
+
+	return truncateSlug(b.String(), MaxSlugLength)
+}
+
+// truncateSlug cuts slug to at most limit characters without leaving a
+// trailing dash.
+func truncateSlug(slug string, limit int) string {
+	if len(slug) <= limit {
+		return slug
+	}
+	return strings.TrimRight(slug[:limit], "-")
+}
+
+// withSlugSuffix appends "-n" to slug for n > 1, truncating slug so the
+// result stays within MaxSlugLength.
+func withSlugSuffix(slug string, n int) string {
+	if n <= 1 {
+		return slug
+	}
+	suffix := "-" + strconv.Itoa(n)
+	return truncateSlug(slug, MaxSlugLength-len(suffix)) + suffix
+}
+
+// claimSlug inserts an entity under the first free slug among base, base-2,
+// base-3 and so on. taken reports slugs held by other entities; insert
+// reports false when it lost a race for the slug.
+func claimSlug(
+	ctx context.Context,
+	base string,
+	taken func(ctx context.Context, slug string) (bool, error),
+	insert func(ctx context.Context, slug string) (bool, error),
+) (string, error) {
+	for n := 1; n <= maxSlugAttempts; n++ {
+		candidate := withSlugSuffix(base, n)
+		inUse, err := taken(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if inUse {
+			continue
+		}
+
+		inserted, err := insert(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if inserted {
+			return candidate, nil
+		}
+	}
+	return "", connect.NewError(connect.CodeAborted, errors.New("could not allocate a unique slug"))
+}
+
+// slugRedirectTaken reports whether slug is retired by an entity other than
+// entityID. Retired slugs stay reserved so their redirects keep working.
+func slugRedirectTaken(
+	ctx context.Context,
+	redirectRepo repository.SlugRedirectRepository,
+	entityType, scopeID, slug, entityID string,
+) (bool, error) {
+	redirect, err := redirectRepo.Find(ctx, entityType, scopeID, slug)
+	if err != nil {
+		if frame.ErrorIsNotFound(err) {
+			return false, nil
+		}
+		return false, data.ErrorConvertToAPI(err)
+	}
+	return redirect.EntityID != entityID, nil
+}
+
+// retireSlug records oldSlug as a redirect to the entity and releases any
+// redirect the entity held on newSlug, which becomes current again.
+func retireSlug(
+	ctx context.Context,
+	redirectRepo repository.SlugRedirectRepository,
+	entityType, scopeID, entityID, oldSlug, newSlug string,
+) error {
+	if current, err := redirectRepo.Find(ctx, entityType, scopeID, newSlug); err == nil {
+		if removeErr := redirectRepo.Remove(ctx, current); removeErr != nil {
+			return data.ErrorConvertToAPI(removeErr)
+		}
+	} else if !frame.ErrorIsNotFound(err) {
+		return data.ErrorConvertToAPI(err)
+	}
+
+	if oldSlug == "" {
+		return nil
+	}
+	redirect := &models.SlugRedirect{
+		EntityType: entityType,
+		ScopeID:    scopeID,
+		Slug:       oldSlug,
+		EntityID:   entityID,
+	}
+	if err := redirectRepo.Create(ctx, redirect); err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return nil
+}
+
+// productSlugTaken reports whether another product of the shop holds or has
+// retired slug.
+func (cb *catalogBusiness) productSlugTaken(ctx context.Context, shopID, slug, productID string) (bool, error) {
+	existing, err := cb.productRepo.GetBySlug(ctx, shopID, slug)
+	if err == nil {
+		return existing.GetID() != productID, nil
+	}
+	if !frame.ErrorIsNotFound(err) {
+		return false, data.ErrorConvertToAPI(err)
+	}
+	return slugRedirectTaken(ctx, cb.redirectRepo, slugEntityProduct, shopID, slug, productID)
+}
+
+// GetProductBySlug resolves a current or retired product slug within a shop.
+func (cb *catalogBusiness) GetProductBySlug(ctx context.Context, shopID, slug string) (*ProductSlugMatch, error) {
+	product, err := cb.productRepo.GetBySlug(ctx, shopID, slug)
+	if err == nil {
+		return &ProductSlugMatch{Product: product.ToAPI(), Slug: product.Slug}, nil
+	}
+	if !frame.ErrorIsNotFound(err) {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+
+	redirect, err := cb.redirectRepo.Find(ctx, slugEntityProduct, shopID, slug)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	product, err = cb.productRepo.GetByID(ctx, redirect.EntityID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return &ProductSlugMatch{Product: product.ToAPI(), Slug: product.Slug, Redirected: true}, nil
+}
+
+// RenameProduct changes the product's name and moves it to a slug generated
+// from the new name. The old slug redirects to the product.
+func (cb *catalogBusiness) RenameProduct(ctx context.Context, productID, name string) (*ProductSlugMatch, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("product name is required"))
+	}
+
+	product, err := cb.productRepo.GetByID(ctx, productID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, errors.New("product not found"))
+	}
+
+	base := Slugify(name)
+	if base == "" {
+		base = slugEntityProduct
+	}
+
+	product.Name = name
+	return cb.moveProductSlug(ctx, product, base, false)
+}
+
+// ChangeProductSlug moves the product to the requested slug. The old slug
+// redirects to the product.
+func (cb *catalogBusiness) ChangeProductSlug(ctx context.Context, productID, requested string) (*ProductSlugMatch, error) {
+	slug := Slugify(requested)
+	if slug == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument,
+			errors.New("product slug must contain letters or digits"))
+	}
+
+	product, err := cb.productRepo.GetByID(ctx, productID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeNotFound, errors.New("product not found"))
+	}
+	return cb.moveProductSlug(ctx, product, slug, true)
+}
+
+// moveProductSlug saves the product under base, or under the first free
+// dedupe suffix of base unless exact is set, retiring its previous slug.
+func (cb *catalogBusiness) moveProductSlug(
+	ctx context.Context,
+	product *models.Product,
+	base string,
+	exact bool,
+) (*ProductSlugMatch, error) {
+	attempts := maxSlugAttempts
+	if exact {
+		attempts = 1
+	}
+
+	slug := ""
+	for n := 1; n <= attempts && slug == ""; n++ {
+		candidate := withSlugSuffix(base, n)
+		if candidate == product.Slug {
+			slug = candidate
+			break
+		}
+		taken, err := cb.productSlugTaken(ctx, product.ShopID, candidate, product.GetID())
+		if err != nil {
+			return nil, err
+		}
+		if !taken {
+			slug = candidate
+		}
+	}
+	if slug == "" {
+		return nil, connect.NewError(connect.CodeAlreadyExists, errors.New("product slug already in use"))
+	}
+
+	columns := []string{"name"}
+	if slug != product.Slug {
+		err := retireSlug(ctx, cb.redirectRepo, slugEntityProduct, product.ShopID, product.GetID(), product.Slug, slug)
+		if err != nil {
+			return nil, err
+		}
+		product.Slug = slug
+		columns = append(columns, "slug")
+	}
+
+	if _, err := cb.productRepo.Update(ctx, product, columns...); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return &ProductSlugMatch{Product: product.ToAPI(), Slug: product.Slug}, nil
+}
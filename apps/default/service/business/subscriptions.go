@@ -0,0 +1,526 @@
+package business
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame"
+	"github.com/pitabwire/frame/data"
+	money "google.golang.org/genproto/googleapis/type/money"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+// Subscription interval units.
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+
+	// maxIntervalCount bounds an interval to a little over a year of days.
+	maxIntervalCount = 400
+
+	dueSubscriptionBatchSize = 50
+	daysPerWeek              = 7
+	monthsPerYear            = 12
+)
+
+// SubscriptionInterval is the time between two runs of a subscription, for
+// example two weeks.
+type SubscriptionInterval struct {
+	Unit  string
+	Count int32
+}
+
+// Validate checks the interval has a known unit and a positive count.
+func (i SubscriptionInterval) Validate() error {
+	switch i.Unit {
+	case IntervalDay, IntervalWeek, IntervalMonth:
+	default:
+		return fmt.Errorf("unknown interval unit %q", i.Unit)
+	}
+	if i.Count <= 0 || i.Count > maxIntervalCount {
+		return fmt.Errorf("interval count must be between 1 and %d", maxIntervalCount)
+	}
+	return nil
+}
+
+// Occurrence returns the anchor moved on by n intervals. Monthly intervals
+// keep the anchor's day of month, clamped to the length of shorter months.
+func (i SubscriptionInterval) Occurrence(anchor time.Time, n int) time.Time {
+	count := int(i.Count) * n
+	switch i.Unit {
+	case IntervalWeek:
+		return anchor.AddDate(0, 0, count*daysPerWeek)
+	case IntervalMonth:
+		year, month, day := anchor.Date()
+		firstOfMonth := time.Date(year, month+time.Month(count), 1,
+			anchor.Hour(), anchor.Minute(), anchor.Second(), anchor.Nanosecond(), anchor.Location())
+		lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+		return firstOfMonth.AddDate(0, 0, min(day, lastDay)-1)
+	default:
+		return anchor.AddDate(0, 0, count)
+	}
+}
+
+// NextAfter returns the first occurrence strictly after the given time.
+func (i SubscriptionInterval) NextAfter(anchor, after time.Time) time.Time {
+	if after.Before(anchor) {
+		return anchor
+	}
+
+	// Estimate how many intervals have passed, then step to the first one
+	// past "after"; the estimate is never more than one interval too far.
+	var n int
+	switch i.Unit {
+	case IntervalMonth:
+		months := (after.Year()-anchor.Year())*monthsPerYear + int(after.Month()-anchor.Month())
+		n = months / int(i.Count)
+	case IntervalWeek:
+		n = int(after.Sub(anchor) / (time.Duration(i.Count) * daysPerWeek * hoursPerDay * time.Hour))
+	default:
+		n = int(after.Sub(anchor) / (time.Duration(i.Count) * hoursPerDay * time.Hour))
+	}
+
+	n = max(n-1, 0)
+	for !i.Occurrence(anchor, n).After(after) {
+		n++
+	}
+	return i.Occurrence(anchor, n)
+}
+
+// LockedUnitPrice applies a price-lock rule to a subscription line, given the
+// price captured when subscribing and the variant's current price. A locked
+// price in another currency than the variant is now sold in no longer
+// applies, so the current price is charged.
+func LockedUnitPrice(rule int32, locked, current *money.Money) *money.Money {
+	switch rule {
+	case models.PriceLockFixed:
+		if locked.GetCurrencyCode() == current.GetCurrencyCode() {
+			return locked
+		}
+		return current
+	case models.PriceLockCapped:
+		if locked.GetCurrencyCode() == current.GetCurrencyCode() && compareMoney(locked, current) < 0 {
+			return locked
+		}
+		return current
+	default:
+		return current
+	}
+}
+
+// compareMoney orders two amounts of the same currency.
+func compareMoney(a, b *money.Money) int {
+	switch {
+	case a.GetUnits() != b.GetUnits():
+		if a.GetUnits() < b.GetUnits() {
+			return -1
+		}
+		return 1
+	case a.GetNanos() < b.GetNanos():
+		return -1
+	case a.GetNanos() > b.GetNanos():
+		return 1
+	default:
+		return 0
+	}
+}
+
+// SubscriptionLineInput is one variant a subscription orders every run.
+type SubscriptionLineInput struct {
+	VariantID string
+	Quantity  int64
+}
+
+// SubscriptionInput describes a new subscription. PriceLock defaults to
+// models.PriceLockNone and StartAt, the first run, defaults to now.
+type SubscriptionInput struct {
+	ShopID    string
+	ProfileID string
+	ContactID string
+	AddressID string
+	Interval  SubscriptionInterval
+	PriceLock int32
+	StartAt   time.Time
+	Lines     []SubscriptionLineInput
+}
+
+type SubscriptionBusiness interface {
+	Subscribe(ctx context.Context, input SubscriptionInput) (*models.Subscription, error)
+	GetSubscription(ctx context.Context, id string) (*models.Subscription, error)
+	ListSubscriptions(ctx context.Context, profileID string, limit int32, page string) ([]*models.Subscription, error)
+	Pause(ctx context.Context, id string) (*models.Subscription, error)
+	Resume(ctx context.Context, id string) (*models.Subscription, error)
+	Skip(ctx context.Context, id string) (*models.Subscription, error)
+	Cancel(ctx context.Context, id string) (*models.Subscription, error)
+	RunDue(ctx context.Context, now time.Time) (int, error)
+}
+
+func NewSubscriptionBusiness(
+	_ context.Context,
+	orders OrderBusiness,
+	subscriptionRepo repository.SubscriptionRepository,
+	orderRepo repository.OrderRepository,
+	productRepo repository.ProductRepository,
+	variantRepo repository.ProductVariantRepository,
+) SubscriptionBusiness {
+	return &subscriptionBusiness{
+		orders:           orders,
+		subscriptionRepo: subscriptionRepo,
+		orderRepo:        orderRepo,
+		productRepo:      productRepo,
+		variantRepo:      variantRepo,
+	}
+}
+
+type subscriptionBusiness struct {
+	orders           OrderBusiness
+	subscriptionRepo repository.SubscriptionRepository
+	orderRepo        repository.OrderRepository
+	productRepo      repository.ProductRepository
+	variantRepo      repository.ProductVariantRepository
+}
+
+// Subscribe creates an active subscription, capturing each variant's current
+// price for the price-lock rules.
+func (sb *subscriptionBusiness) Subscribe(ctx context.Context, input SubscriptionInput) (*models.Subscription, error) {
+	if input.ProfileID == "" {
+		input.ProfileID = callerProfileID(ctx)
+	}
+	if input.ShopID == "" || input.ProfileID == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("shop and profile are required"))
+	}
+	if err := input.Interval.Validate(); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if input.PriceLock == 0 {
+		input.PriceLock = models.PriceLockNone
+	}
+	if input.PriceLock < models.PriceLockNone || input.PriceLock > models.PriceLockCapped {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unknown price lock %d", input.PriceLock))
+	}
+	if len(input.Lines) == 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("subscription must have at least one line"))
+	}
+
+	lines, err := sb.subscriptionLines(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	start := input.StartAt
+	if start.IsZero() {
+		start = time.Now()
+	}
+	start = start.UTC().Truncate(time.Microsecond)
+
+	subscription := &models.Subscription{
+		ShopID:        input.ShopID,
+		ProfileID:     input.ProfileID,
+		ContactID:     input.ContactID,
+		AddressID:     input.AddressID,
+		IntervalUnit:  input.Interval.Unit,
+		IntervalCount: input.Interval.Count,
+		PriceLock:     input.PriceLock,
+		Status:        models.SubscriptionStatusActive,
+		AnchorAt:      start,
+		NextRunAt:     start,
+	}
+	if err = sb.subscriptionRepo.CreateWithLines(ctx, subscription, lines); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return subscription, nil
+}
+
+func (sb *subscriptionBusiness) subscriptionLines(
+	ctx context.Context,
+	input SubscriptionInput,
+) ([]*models.SubscriptionLine, error) {
+	seen := make(map[string]bool, len(input.Lines))
+	lines := make([]*models.SubscriptionLine, 0, len(input.Lines))
+	for _, line := range input.Lines {
+		if line.Quantity <= 0 {
+			return nil, connect.NewError(connect.CodeInvalidArgument,
+				fmt.Errorf("quantity must be positive for variant %s", line.VariantID))
+		}
+		if seen[line.VariantID] {
+			return nil, connect.NewError(connect.CodeInvalidArgument,
+				fmt.Errorf("variant %s is listed more than once", line.VariantID))
+		}
+		seen[line.VariantID] = true
+
+		variant, err := sb.variantRepo.GetByID(ctx, line.VariantID)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("variant %s not found", line.VariantID))
+		}
+		if variant.ShopID != input.ShopID {
+			return nil, connect.NewError(connect.CodeInvalidArgument,
+				fmt.Errorf("variant %s belongs to a different shop", line.VariantID))
+		}
+		product, err := sb.productRepo.GetByID(ctx, variant.ProductID)
+		if err != nil {
+			return nil, data.ErrorConvertToAPI(err)
+		}
+		if product.FulfilmentType == models.FulfilmentTypeBooking {
+			return nil, connect.NewError(connect.CodeInvalidArgument,
+				fmt.Errorf("variant %s is booked by time slot and cannot be subscribed to", line.VariantID))
+		}
+
+		lines = append(lines, &models.SubscriptionLine{
+			ProductVariantID:    variant.GetID(),
+			Quantity:            line.Quantity,
+			LockedPriceCurrency: variant.CurrencyCode,
+			LockedPriceUnits:    variant.PriceUnits,
+			LockedPriceNanos:    variant.PriceNanos,
+		})
+	}
+	return lines, nil
+}
+
+func (sb *subscriptionBusiness) GetSubscription(ctx context.Context, id string) (*models.Subscription, error) {
+	subscription, err := sb.subscriptionRepo.GetWithLines(ctx, id)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return subscription, nil
+}
+
+func (sb *subscriptionBusiness) ListSubscriptions(
+	ctx context.Context,
+	profileID string,
+	limit int32,
+	page string,
+) ([]*models.Subscription, error) {
+	if profileID == "" {
+		profileID = callerProfileID(ctx)
+	}
+	if profileID == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("profile is required"))
+	}
+
+	pageLimit, offset := pageBounds(limit, page)
+	subscriptions, err := sb.subscriptionRepo.ListByProfileID(ctx, profileID, pageLimit, offset)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return subscriptions, nil
+}
+
+// Pause stops the subscription from running until it is resumed.
+func (sb *subscriptionBusiness) Pause(ctx context.Context, id string) (*models.Subscription, error) {
+	return sb.setStatus(ctx, id, models.SubscriptionStatusActive, models.SubscriptionStatusPaused)
+}
+
+// Resume reactivates a paused subscription. Runs missed while it was paused
+// are not made up: the next run is the first one from now on.
+func (sb *subscriptionBusiness) Resume(ctx context.Context, id string) (*models.Subscription, error) {
+	subscription, err := sb.subscriptionRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if subscription.Status != models.SubscriptionStatusPaused {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("subscription is not paused"))
+	}
+
+	now := time.Now()
+	if subscription.NextRunAt.Before(now) {
+		subscription.NextRunAt = subscriptionInterval(subscription).NextAfter(subscription.AnchorAt, now)
+	}
+	subscription.Status = models.SubscriptionStatusActive
+	if _, err = sb.subscriptionRepo.Update(ctx, subscription, "status", "next_run_at"); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return sb.GetSubscription(ctx, id)
+}
+
+// Skip moves an active or paused subscription past its next run without
+// placing an order.
+func (sb *subscriptionBusiness) Skip(ctx context.Context, id string) (*models.Subscription, error) {
+	subscription, err := sb.subscriptionRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if subscription.Status == models.SubscriptionStatusCancelled {
+		return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("subscription is cancelled"))
+	}
+
+	next := subscriptionInterval(subscription).NextAfter(subscription.AnchorAt, subscription.NextRunAt)
+	moved, err := sb.subscriptionRepo.AdvanceCycle(ctx, id, subscription.NextRunAt, next, "")
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if !moved {
+		return nil, connect.NewError(connect.CodeAborted, errors.New("subscription ran concurrently, retry the skip"))
+	}
+	return sb.GetSubscription(ctx, id)
+}
+
+// Cancel ends the subscription for good.
+func (sb *subscriptionBusiness) Cancel(ctx context.Context, id string) (*models.Subscription, error) {
+	subscription, err := sb.subscriptionRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if subscription.Status == models.SubscriptionStatusCancelled {
+		return sb.GetSubscription(ctx, id)
+	}
+	return sb.setStatus(ctx, id, subscription.Status, models.SubscriptionStatusCancelled)
+}
+
+func (sb *subscriptionBusiness) setStatus(ctx context.Context, id string, from, to int32) (*models.Subscription, error) {
+	subscription, err := sb.subscriptionRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if subscription.Status != from {
+		return nil, connect.NewError(connect.CodeFailedPrecondition,
+			fmt.Errorf("subscription cannot move from status %d to %d", subscription.Status, to))
+	}
+
+	subscription.Status = to
+	if _, err = sb.subscriptionRepo.Update(ctx, subscription, "status"); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return sb.GetSubscription(ctx, id)
+}
+
+// RunDue places the order of every active subscription due at now and moves
+// each on to its next run, returning how many orders were placed. Each run is
+// keyed on the subscription and its scheduled time, so a rerun after a crash
+// finds the order it already placed instead of billing again. A subscription
+// that was not run for several intervals is billed once.
+func (sb *subscriptionBusiness) RunDue(ctx context.Context, now time.Time) (int, error) {
+	placed := 0
+	var runErrs []error
+	for {
+		due, err := sb.subscriptionRepo.ListDue(ctx, now, dueSubscriptionBatchSize)
+		if err != nil {
+			return placed, data.ErrorConvertToAPI(err)
+		}
+
+		progressed := 0
+		for _, subscription := range due {
+			ordered, advanced, runErr := sb.runCycle(ctx, subscription, now)
+			if ordered {
+				placed++
+			}
+			if advanced {
+				progressed++
+			}
+			if runErr != nil {
+				runErrs = append(runErrs, fmt.Errorf("subscription %s: %w", subscription.GetID(), runErr))
+			}
+		}
+
+		// Stop once the due list is drained or only holds runs that must be retried later.
+		if len(due) < dueSubscriptionBatchSize || progressed == 0 {
+			return placed, errors.Join(runErrs...)
+		}
+	}
+}
+
+// runCycle places the order for the subscription's due run and advances it.
+// Orders the shop cannot fulfil, such as for out of stock or removed
+// variants, miss the run; other failures leave it due to be retried.
+func (sb *subscriptionBusiness) runCycle(
+	ctx context.Context,
+	subscription *models.Subscription,
+	now time.Time,
+) (bool, bool, error) {
+	key := subscriptionCycleKey(subscription)
+
+	orderID, placed := "", false
+	var runErr error
+	existing, err := sb.orderRepo.GetByIdempotencyKey(ctx, subscription.ShopID, key)
+	switch {
+	case err == nil:
+		orderID = existing.GetID()
+	case !frame.ErrorIsNotFound(err):
+		return false, false, data.ErrorConvertToAPI(err)
+	default:
+		order, createErr := sb.placeOrder(ctx, subscription, key)
+		switch {
+		case createErr == nil:
+			orderID, placed = order.GetId(), true
+		case missesRun(createErr):
+			runErr = fmt.Errorf("run missed: %w", createErr)
+		default:
+			return false, false, createErr
+		}
+	}
+
+	next := subscriptionInterval(subscription).NextAfter(subscription.AnchorAt, now)
+	advanced, err := sb.subscriptionRepo.AdvanceCycle(ctx, subscription.GetID(), subscription.NextRunAt, next, orderID)
+	if err != nil {
+		return placed, false, data.ErrorConvertToAPI(err)
+	}
+	return placed, advanced, runErr
+}
+
+// placeOrder creates the run's order through the CreateOrder path, at the
+// prices the subscription's price-lock rule settles on.
+func (sb *subscriptionBusiness) placeOrder(
+	ctx context.Context,
+	subscription *models.Subscription,
+	key string,
+) (*commercev1.Order, error) {
+	req := &commercev1.CreateOrderRequest{
+		ShopId:         subscription.ShopID,
+		ProfileId:      subscription.ProfileID,
+		ContactId:      subscription.ContactID,
+		AddressId:      subscription.AddressID,
+		IdempotencyKey: key,
+	}
+
+	var prices []*money.Money
+	for _, line := range subscription.Lines {
+		req.Lines = append(req.Lines, &commercev1.CreateOrderLine{
+			VariantId: line.ProductVariantID,
+			Quantity:  line.Quantity,
+		})
+
+		if subscription.PriceLock == models.PriceLockNone {
+			continue
+		}
+		variant, err := sb.variantRepo.GetByID(ctx, line.ProductVariantID)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeNotFound,
+				fmt.Errorf("variant %s not found", line.ProductVariantID))
+		}
+		locked := &money.Money{
+			CurrencyCode: line.LockedPriceCurrency,
+			Units:        line.LockedPriceUnits,
+			Nanos:        line.LockedPriceNanos,
+		}
+		current := &money.Money{CurrencyCode: variant.CurrencyCode, Units: variant.PriceUnits, Nanos: variant.PriceNanos}
+		prices = append(prices, LockedUnitPrice(subscription.PriceLock, locked, current))
+	}
+
+	return sb.orders.CreateRecurringOrder(ctx, req, prices)
+}
+
+// missesRun reports whether an order failure is down to the shop's catalogue
+// rather than a transient fault, so retrying the run would not help.
+func missesRun(err error) bool {
+	switch connect.CodeOf(err) {
+	case connect.CodeFailedPrecondition, connect.CodeNotFound, connect.CodeInvalidArgument:
+		return true
+	default:
+		return false
+	}
+}
+
+func subscriptionInterval(subscription *models.Subscription) SubscriptionInterval {
+	return SubscriptionInterval{Unit: subscription.IntervalUnit, Count: subscription.IntervalCount}
+}
+
+// subscriptionCycleKey is the idempotency key of the subscription's due run.
+func subscriptionCycleKey(subscription *models.Subscription) string {
+	return fmt.Sprintf("subscription:%s:%d", subscription.GetID(), subscription.NextRunAt.UnixMicro())
+}
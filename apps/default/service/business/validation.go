@@ -0,0 +1,222 @@
+package business
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"connectrpc.com/connect"
+	"golang.org/x/text/currency"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	money "google.golang.org/genproto/googleapis/type/money"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// idPattern is the shape of the IDs the service issues and is given: the
+// pattern the proto declares for IDs, held to the whole value.
+//
+//nolint:gochecknoglobals // compiled once
+var idPattern = regexp.MustCompile(`^[0-9a-z_-]{3,40}$`)
+
+// maxMoneyNanos is the largest nanos google.type.Money allows.
+const maxMoneyNanos = 999_999_999
+
+// ValidateID checks id is present and has the shape of an ID.
+func ValidateID(id string) error {
+	if id == "" {
+		return errors.New("id is required")
+	}
+	if !idPattern.MatchString(id) {
+		return fmt.Errorf("%q is not a valid id", id)
+	}
+	return nil
+}
+
+// validateOptionalID checks id has the shape of an ID when it is given.
+func validateOptionalID(id string) error {
+	if id == "" {
+		return nil
+	}
+	return ValidateID(id)
+}
+
+// ValidateQuantity checks a quantity bought or fulfilled is positive.
+func ValidateQuantity(quantity int64) error {
+	if quantity <= 0 {
+		return fmt.Errorf("quantity %d must be positive", quantity)
+	}
+	return nil
+}
+
+// ValidateStockQuantity checks a stock level is not negative.
+func ValidateStockQuantity(quantity int64) error {
+	if quantity < 0 {
+		return fmt.Errorf("stock %d must not be negative", quantity)
+	}
+	return nil
+}
+
+// ValidateCurrencyCode checks code is an ISO 4217 currency code, written as
+// three upper case letters.
+func ValidateCurrencyCode(code string) error {
+	const codeLength = 3
+	if len(code) != codeLength {
+		return fmt.Errorf("currency %q is not a three letter code", code)
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return fmt.Errorf("currency %q is not a three letter code", code)
+		}
+	}
+	if _, err := currency.ParseISO(code); err != nil {
+		return fmt.Errorf("currency %q is not an ISO 4217 code", code)
+	}
+	return nil
+}
+
+// ValidateMoney checks m is an amount in an ISO 4217 currency whose units and
+// nanos agree in sign, as google.type.Money requires.
+func ValidateMoney(m *money.Money) error {
+	if m == nil {
+		return errors.New("amount is required")
+	}
+	if err := ValidateCurrencyCode(m.GetCurrencyCode()); err != nil {
+		return err
+	}
+	if m.GetNanos() > maxMoneyNanos || m.GetNanos() < -maxMoneyNanos {
+		return fmt.Errorf("nanos %d is out of range", m.GetNanos())
+	}
+	if (m.GetUnits() > 0 && m.GetNanos() < 0) || (m.GetUnits() < 0 && m.GetNanos() > 0) {
+		return errors.New("units and nanos must have the same sign")
+	}
+	return nil
+}
+
+// ValidatePrice checks m is a valid amount that is not negative.
+func ValidatePrice(m *money.Money) error {
+	if err := ValidateMoney(m); err != nil {
+		return err
+	}
+	if m.GetUnits() < 0 || m.GetNanos() < 0 {
+		return errors.New("price must not be negative")
+	}
+	return nil
+}
+
+// requestViolations gathers the fields of a request that break a rule.
+type requestViolations []*errdetails.BadRequest_FieldViolation
+
+func (v *requestViolations) check(field string, err error) {
+	if err != nil {
+		*v = append(*v, &errdetails.BadRequest_FieldViolation{Field: field, Description: err.Error()})
+	}
+}
+
+// err reports the violations as an invalid argument, with a
+// google.rpc.BadRequest detail naming each field, or nil when there are none.
+func (v requestViolations) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(v))
+	for _, violation := range v {
+		messages = append(messages, violation.GetField()+": "+violation.GetDescription())
+	}
+	cerr := connect.NewError(connect.CodeInvalidArgument, errors.New(strings.Join(messages, "; ")))
+	if detail, err := connect.NewErrorDetail(&errdetails.BadRequest{FieldViolations: v}); err == nil {
+		cerr.AddDetail(detail)
+	}
+	return cerr
+}
+
+// ValidateRequest holds an API request to the rules on IDs, quantities and
+// money that the proto cannot express, reporting every field that breaks
+// one. Whether the things named exist is left to the business.
+func ValidateRequest(msg proto.Message) error {
+	var v requestViolations
+	switch req := msg.(type) {
+	case *commercev1.GetShopRequest:
+		v.check("id", ValidateID(req.GetId()))
+	case *commercev1.UpdateShopRequest:
+		v.check("id", ValidateID(req.GetId()))
+	case *commercev1.CreateProductRequest:
+		v.check("shop_id", ValidateID(req.GetShopId()))
+	case *commercev1.GetProductRequest:
+		v.check("id", ValidateID(req.GetId()))
+	case *commercev1.ListProductsRequest:
+		v.check("shop_id", ValidateID(req.GetShopId()))
+	case *commercev1.CreateProductVariantRequest:
+		v.check("product_id", ValidateID(req.GetProductId()))
+		if req.GetPrice() != nil {
+			v.check("price", ValidatePrice(req.GetPrice()))
+		}
+		v.check("stock_quantity", ValidateStockQuantity(req.GetStockQuantity()))
+	case *commercev1.UpdateProductVariantRequest:
+		v.check("variant_id", ValidateID(req.GetVariantId()))
+		if req.GetPrice() != nil && masked(req.GetUpdateMask(), "price") {
+			v.check("price", ValidatePrice(req.GetPrice()))
+		}
+		if masked(req.GetUpdateMask(), "stock_quantity") {
+			v.check("stock_quantity", ValidateStockQuantity(req.GetStockQuantity()))
+		}
+	case *commercev1.CreateCartRequest:
+		v.check("shop_id", ValidateID(req.GetShopId()))
+		v.check("profile_id", validateOptionalID(req.GetProfileId()))
+		v.check("contact_id", validateOptionalID(req.GetContactId()))
+	case *commercev1.GetCartRequest:
+		v.check("id", ValidateID(req.GetId()))
+	case *commercev1.AddCartLineRequest:
+		v.check("cart_id", ValidateID(req.GetCartId()))
+		v.check("product_variant_id", ValidateID(req.GetProductVariantId()))
+		v.check("quantity", ValidateQuantity(req.GetQuantity()))
+	case *commercev1.RemoveCartLineRequest:
+		v.check("cart_id", ValidateID(req.GetCartId()))
+		v.check("cart_line_id", ValidateID(req.GetCartLineId()))
+	case *commercev1.CreateOrderFromCartRequest:
+		v.check("cart_id", ValidateID(req.GetCartId()))
+		v.check("profile_id", validateOptionalID(req.GetProfileId()))
+		v.check("contact_id", validateOptionalID(req.GetContactId()))
+		v.check("address_id", validateOptionalID(req.GetAddressId()))
+	case *commercev1.CreateOrderRequest:
+		v.check("shop_id", ValidateID(req.GetShopId()))
+		v.check("profile_id", validateOptionalID(req.GetProfileId()))
+		v.check("contact_id", validateOptionalID(req.GetContactId()))
+		v.check("address_id", validateOptionalID(req.GetAddressId()))
+		if len(req.GetLines()) == 0 {
+			v.check("lines", errors.New("at least one line is required"))
+		}
+		for i, line := range req.GetLines() {
+			v.check(fmt.Sprintf("lines[%d].variant_id", i), ValidateID(line.GetVariantId()))
+			v.check(fmt.Sprintf("lines[%d].quantity", i), ValidateQuantity(line.GetQuantity()))
+		}
+	case *commercev1.GetOrderRequest:
+		v.check("id", ValidateID(req.GetId()))
+	case *commercev1.ListOrdersRequest:
+		v.check("shop_id", ValidateID(req.GetShopId()))
+	case *commercev1.CreateFulfilmentRequest:
+		v.check("order_id", ValidateID(req.GetOrderId()))
+		if len(req.GetLines()) == 0 {
+			v.check("lines", errors.New("at least one line is required"))
+		}
+		for i, line := range req.GetLines() {
+			v.check(fmt.Sprintf("lines[%d].order_line_id", i), ValidateID(line.GetOrderLineId()))
+			v.check(fmt.Sprintf("lines[%d].quantity", i), ValidateQuantity(line.GetQuantity()))
+		}
+	case *commercev1.UpdateFulfilmentRequest:
+		v.check("id", ValidateID(req.GetId()))
+	case *commercev1.GetFulfilmentRequest:
+		v.check("id", ValidateID(req.GetId()))
+	}
+	return v.err()
+}
+
+// masked reports whether an update touches field; an empty mask touches
+// every field.
+func masked(mask *fieldmaskpb.FieldMask, field string) bool {
+	paths := mask.GetPaths()
+	return len(paths) == 0 || slices.Contains(paths, field)
+}
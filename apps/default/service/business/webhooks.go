@@ -0,0 +1,595 @@
+package business
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/frame"
+	"github.com/pitabwire/frame/data"
+	"github.com/pitabwire/frame/workerpool"
+	"github.com/pitabwire/util"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+// Webhook event types.
+const (
+	EventOrderCreated      = "order.created"
+	EventOrderPaid         = "order.paid"
+	EventOrderRefunded     = "order.refunded"
+	EventOrderCancelled    = "order.cancelled"
+	EventFulfilmentCreated = "fulfilment.created"
+	EventFulfilmentUpdated = "fulfilment.updated"
+	// EventAll subscribes an endpoint to every event type.
+	EventAll = "*"
+)
+
+var webhookEventTypes = []string{
+	EventOrderCreated, EventOrderPaid, EventOrderRefunded, EventOrderCancelled, EventFulfilmentCreated,
+	EventFulfilmentUpdated,
+}
+
+// Webhook request headers. The signature is "t=" followed by the unix time
+// of the attempt and ",v1=" followed by the hex HMAC-SHA256, keyed with the
+// endpoint secret, of the time, a dot and the body.
+const (
+	WebhookSignatureHeader = "X-Commerce-Signature"
+	WebhookEventHeader     = "X-Commerce-Event"
+	WebhookDeliveryHeader  = "X-Commerce-Delivery"
+)
+
+// ErrWebhookSignature is returned for webhooks whose signature does not verify.
+var ErrWebhookSignature = errors.New("webhook signature does not verify")
+
+const (
+	webhookSecretPrefix = "whsec_"
+	webhookSecretLength = 32
+	// webhookLeaseMargin is added to the request timeout when claiming a
+	// delivery, covering the bookkeeping around the request.
+	webhookLeaseMargin = 30 * time.Second
+	// webhookResponseLimit is how much of a response body is read before the
+	// connection is released.
+	webhookResponseLimit = 64 << 10
+	// MaxWebhookDeliveryList caps how many deliveries are listed at once.
+	MaxWebhookDeliveryList = 100
+	// webhookRetryBatch is how many due deliveries one retry sweep queues.
+	webhookRetryBatch = 100
+	// webhookDialTimeout bounds connecting to an endpoint.
+	webhookDialTimeout = 10 * time.Second
+)
+
+// WebhookPolicy controls how deliveries are attempted. After the nth failed
+// attempt a delivery waits BaseDelay·2ⁿ⁻¹, at most MaxDelay, and is dead once
+// MaxAttempts attempts have failed. AllowLoopback lets endpoints on the
+// loopback interface, over plain http too, receive webhooks, for local
+// development only.
+type WebhookPolicy struct {
+	MaxAttempts   int32
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	Timeout       time.Duration
+	AllowLoopback bool
+}
+
+// DefaultWebhookPolicy retries for about a day before giving up.
+func DefaultWebhookPolicy() WebhookPolicy {
+	return WebhookPolicy{
+		MaxAttempts: 10,
+		BaseDelay:   time.Minute,
+		MaxDelay:    6 * time.Hour,
+		Timeout:     10 * time.Second,
+	}
+}
+
+// Backoff is the wait after the given number of failed attempts.
+func (p WebhookPolicy) Backoff(failures int32) time.Duration {
+	delay := p.BaseDelay
+	for i := int32(1); i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// WebhookPublisher queues events for the shops' webhook endpoints.
+type WebhookPublisher interface {
+	// Publish queues the event for each of the shop's endpoints subscribed to
+	// its type. Failures are logged rather than returned: the change the event
+	// reports has already been made and must not be undone by its webhook.
+	Publish(ctx context.Context, shopID, eventType string, payload proto.Message)
+}
+
+// WebhookBusiness manages shops' webhook endpoints and delivers their events.
+// Each delivery is attempted on the worker pool as soon as it is queued;
+// failed attempts are retried by RetryDue with exponential backoff.
+type WebhookBusiness interface {
+	WebhookPublisher
+	CreateEndpoint(ctx context.Context, shopID, endpointURL string, eventTypes []string) (*models.WebhookEndpoint, error)
+	GetEndpoint(ctx context.Context, id string) (*models.WebhookEndpoint, error)
+	ListEndpoints(ctx context.Context, shopID string) ([]*models.WebhookEndpoint, error)
+	SetEndpointDisabled(ctx context.Context, id string, disabled bool) (*models.WebhookEndpoint, error)
+	DeleteEndpoint(ctx context.Context, id string) error
+	GetDelivery(ctx context.Context, id string) (*models.WebhookDelivery, error)
+	ListDeliveries(ctx context.Context, endpointID string, status int32) ([]*models.WebhookDelivery, error)
+	ListDeliveryAttempts(ctx context.Context, deliveryID string) ([]*models.WebhookAttempt, error)
+	Redeliver(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error)
+	Deliver(ctx context.Context, deliveryID string) error
+	RetryDue(ctx context.Context, now time.Time) (int, error)
+}
+
+func NewWebhookBusiness(
+	_ context.Context,
+	workMan workerpool.Manager,
+	policy WebhookPolicy,
+	endpointRepo repository.WebhookEndpointRepository,
+	deliveryRepo repository.WebhookDeliveryRepository,
+) WebhookBusiness {
+	return &webhookBusiness{
+		workMan: workMan,
+		policy:  policy,
+		client: &http.Client{
+			Transport: webhookTransport(policy.AllowLoopback),
+			// A redirect is reported as the failure it is for a webhook,
+			// instead of replaying the signed body somewhere else.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		endpointRepo: endpointRepo,
+		deliveryRepo: deliveryRepo,
+	}
+}
+
+type webhookBusiness struct {
+	workMan      workerpool.Manager
+	policy       WebhookPolicy
+	client       *http.Client
+	endpointRepo repository.WebhookEndpointRepository
+	deliveryRepo repository.WebhookDeliveryRepository
+}
+
+// CreateEndpoint subscribes the URL to the event types, all of them when none
+// are given. The endpoint is returned with the secret its deliveries are
+// signed with.
+func (wb *webhookBusiness) CreateEndpoint(
+	ctx context.Context,
+	shopID, endpointURL string,
+	eventTypes []string,
+) (*models.WebhookEndpoint, error) {
+	if err := ValidateWebhookURL(endpointURL, wb.policy.AllowLoopback); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if len(eventTypes) == 0 {
+		eventTypes = []string{EventAll}
+	}
+	for _, eventType := range eventTypes {
+		if eventType != EventAll && !slices.Contains(webhookEventTypes, eventType) {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unknown event type %q", eventType))
+		}
+	}
+
+	endpoint := &models.WebhookEndpoint{
+		ShopID:     shopID,
+		URL:        endpointURL,
+		Secret:     webhookSecretPrefix + util.RandomAlphaNumericString(webhookSecretLength),
+		EventTypes: models.StringArray(slices.Compact(slices.Sorted(slices.Values(eventTypes)))),
+	}
+	if err := wb.endpointRepo.Create(ctx, endpoint); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return endpoint, nil
+}
+
+func (wb *webhookBusiness) GetEndpoint(ctx context.Context, id string) (*models.WebhookEndpoint, error) {
+	endpoint, err := wb.endpointRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return endpoint, nil
+}
+
+func (wb *webhookBusiness) ListEndpoints(ctx context.Context, shopID string) ([]*models.WebhookEndpoint, error) {
+	endpoints, err := wb.endpointRepo.ListByShopID(ctx, shopID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return endpoints, nil
+}
+
+// SetEndpointDisabled pauses or resumes an endpoint. A disabled endpoint is
+// queued no new events, and its pending deliveries are dead-lettered when
+// they come up for their next attempt.
+func (wb *webhookBusiness) SetEndpointDisabled(
+	ctx context.Context,
+	id string,
+	disabled bool,
+) (*models.WebhookEndpoint, error) {
+	endpoint, err := wb.endpointRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	endpoint.Disabled = disabled
+	if _, err = wb.endpointRepo.Update(ctx, endpoint, "disabled"); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return endpoint, nil
+}
+
+func (wb *webhookBusiness) DeleteEndpoint(ctx context.Context, id string) error {
+	if err := wb.endpointRepo.Delete(ctx, id); err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return nil
+}
+
+func (wb *webhookBusiness) GetDelivery(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	delivery, err := wb.deliveryRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return delivery, nil
+}
+
+// ListDeliveries lists the endpoint's latest deliveries, newest first. A
+// zero status lists them all; WebhookDeliveryDead lists the dead letters.
+func (wb *webhookBusiness) ListDeliveries(
+	ctx context.Context,
+	endpointID string,
+	status int32,
+) ([]*models.WebhookDelivery, error) {
+	deliveries, err := wb.deliveryRepo.ListByEndpointID(ctx, endpointID, status, MaxWebhookDeliveryList)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return deliveries, nil
+}
+
+func (wb *webhookBusiness) ListDeliveryAttempts(
+	ctx context.Context,
+	deliveryID string,
+) ([]*models.WebhookAttempt, error) {
+	attempts, err := wb.deliveryRepo.ListAttempts(ctx, deliveryID)
+	if err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	return attempts, nil
+}
+
+// Redeliver queues the delivery again with a full set of attempts, whether it
+// is dead, delivered or still pending, and attempts it straight away.
+func (wb *webhookBusiness) Redeliver(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error) {
+	if _, err := wb.deliveryRepo.GetByID(ctx, deliveryID); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	if err := wb.deliveryRepo.Requeue(ctx, deliveryID, time.Now()); err != nil {
+		return nil, data.ErrorConvertToAPI(err)
+	}
+	wb.submit(ctx, deliveryID)
+	return wb.GetDelivery(ctx, deliveryID)
+}
+
+func (wb *webhookBusiness) Publish(ctx context.Context, shopID, eventType string, payload proto.Message) {
+	log := util.Log(ctx).With("shop_id", shopID).With("event_type", eventType)
+
+	endpoints, err := wb.endpointRepo.ListByShopID(ctx, shopID)
+	if err != nil {
+		log.WithError(err).Error("could not list webhook endpoints")
+		return
+	}
+	endpoints = slices.DeleteFunc(endpoints, func(endpoint *models.WebhookEndpoint) bool {
+		return endpoint.Disabled || !subscribes(endpoint, eventType)
+	})
+	if len(endpoints) == 0 {
+		return
+	}
+
+	now := time.Now()
+	event := WebhookEvent{ID: util.IDString(), Type: eventType, ShopID: shopID, CreatedAt: now}
+	if event.Data, err = protojson.Marshal(payload); err != nil {
+		log.WithError(err).Error("could not encode webhook event")
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.WithError(err).Error("could not encode webhook event")
+		return
+	}
+
+	for _, endpoint := range endpoints {
+		delivery := &models.WebhookDelivery{
+			EndpointID:    endpoint.GetID(),
+			ShopID:        shopID,
+			EventID:       event.ID,
+			EventType:     eventType,
+			Payload:       string(body),
+			Status:        models.WebhookDeliveryPending,
+			NextAttemptAt: now,
+		}
+		delivery.CopyPartitionInfo(&endpoint.BaseModel)
+
+		created, createErr := wb.deliveryRepo.TryCreate(ctx, delivery)
+		if createErr != nil {
+			log.WithError(createErr).With("endpoint_id", endpoint.GetID()).Error("could not queue webhook delivery")
+			continue
+		}
+		if created {
+			wb.submit(ctx, delivery.GetID())
+		}
+	}
+}
+
+// WebhookEvent is the body of every webhook. Data is the JSON encoding of the
+// resource the event is about, such as the order or the fulfilment.
+type WebhookEvent struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	ShopID    string          `json:"shop_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func subscribes(endpoint *models.WebhookEndpoint, eventType string) bool {
+	return slices.Contains(endpoint.EventTypes, EventAll) || slices.Contains(endpoint.EventTypes, eventType)
+}
+
+// submit attempts the delivery on the worker pool. The job outlives the
+// request that queued it, so it keeps the request's values but not its
+// cancellation. A delivery that cannot be submitted is left for RetryDue.
+func (wb *webhookBusiness) submit(ctx context.Context, deliveryID string) {
+	jobCtx := context.WithoutCancel(ctx)
+	job := workerpool.NewJob(func(ctx context.Context, _ workerpool.JobResultPipe[any]) error {
+		if err := wb.Deliver(ctx, deliveryID); err != nil {
+			util.Log(ctx).WithError(err).With("delivery_id", deliveryID).Error("webhook delivery failed")
+		}
+		return nil
+	})
+	if err := workerpool.SubmitJob(jobCtx, wb.workMan, job); err != nil {
+		util.Log(ctx).WithError(err).With("delivery_id", deliveryID).Warn("could not submit webhook delivery")
+	}
+}
+
+// RetryDue queues every pending delivery whose next attempt is due and
+// returns how many were queued.
+func (wb *webhookBusiness) RetryDue(ctx context.Context, now time.Time) (int, error) {
+	due, err := wb.deliveryRepo.ListDue(ctx, now, webhookRetryBatch)
+	if err != nil {
+		return 0, data.ErrorConvertToAPI(err)
+	}
+	for _, delivery := range due {
+		wb.submit(ctx, delivery.GetID())
+	}
+	return len(due), nil
+}
+
+// Deliver makes one attempt at the delivery if it is pending and due, and
+// records the outcome. Deliveries to deleted or disabled endpoints are
+// dead-lettered without a request.
+func (wb *webhookBusiness) Deliver(ctx context.Context, deliveryID string) error {
+	now := time.Now()
+	claimed, err := wb.deliveryRepo.Claim(ctx, deliveryID, now, wb.policy.Timeout+webhookLeaseMargin)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	if !claimed {
+		return nil
+	}
+
+	delivery, err := wb.deliveryRepo.GetByID(ctx, deliveryID)
+	if err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	attempt := &models.WebhookAttempt{Attempt: delivery.Attempts + 1, AttemptedAt: now}
+
+	final := true
+	endpoint, err := wb.endpointRepo.GetByID(ctx, delivery.EndpointID)
+	switch {
+	case frame.ErrorIsNotFound(err):
+		attempt.Error = "endpoint was deleted"
+	case err != nil:
+		// The claim lapses and the delivery is attempted again.
+		return data.ErrorConvertToAPI(err)
+	case endpoint.Disabled:
+		attempt.Error = "endpoint is disabled"
+	default:
+		final = false
+		wb.send(ctx, endpoint, delivery, attempt)
+	}
+
+	settleWebhookDelivery(delivery, attempt, wb.policy, final)
+	if err = wb.deliveryRepo.RecordAttempt(ctx, delivery, attempt); err != nil {
+		return data.ErrorConvertToAPI(err)
+	}
+	return nil
+}
+
+// send posts the delivery and fills in the attempt's outcome. Any response
+// outside 2xx is a failure.
+func (wb *webhookBusiness) send(
+	ctx context.Context,
+	endpoint *models.WebhookEndpoint,
+	delivery *models.WebhookDelivery,
+	attempt *models.WebhookAttempt,
+) {
+	ctx, cancel := context.WithTimeout(ctx, wb.policy.Timeout)
+	defer cancel()
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookDeliveryHeader, delivery.GetID())
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(endpoint.Secret, attempt.AttemptedAt, body))
+
+	started := time.Now()
+	resp, err := wb.client.Do(req)
+	attempt.DurationMs = time.Since(started).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, webhookResponseLimit))
+
+	attempt.StatusCode = int32(resp.StatusCode)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		attempt.Error = "unexpected response status " + resp.Status
+	}
+}
+
+// settleWebhookDelivery moves the delivery on after the attempt: delivered
+// when it succeeded, dead when it was final or the last one allowed, and
+// otherwise pending until its backoff has passed.
+func settleWebhookDelivery(
+	delivery *models.WebhookDelivery,
+	attempt *models.WebhookAttempt,
+	policy WebhookPolicy,
+	final bool,
+) {
+	delivery.Attempts = attempt.Attempt
+	delivery.LastAttemptAt = &attempt.AttemptedAt
+	delivery.LastStatusCode = attempt.StatusCode
+	delivery.LastError = attempt.Error
+
+	switch {
+	case attempt.Error == "":
+		delivery.Status = models.WebhookDeliveryDelivered
+		delivery.DeliveredAt = &attempt.AttemptedAt
+	case final || delivery.Attempts >= policy.MaxAttempts:
+		delivery.Status = models.WebhookDeliveryDead
+	default:
+		delivery.Status = models.WebhookDeliveryPending
+		delivery.NextAttemptAt = attempt.AttemptedAt.Add(policy.Backoff(delivery.Attempts))
+	}
+}
+
+// ValidateWebhookURL accepts absolute https URLs whose host is not an
+// internal address. With allowLoopback, for local development, URLs on the
+// loopback interface are accepted too, over plain http as well. Host names
+// are checked again once resolved, when a delivery connects.
+func ValidateWebhookURL(endpointURL string, allowLoopback bool) error {
+	parsed, err := url.Parse(endpointURL)
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("webhook url %q is not an absolute url", endpointURL)
+	}
+
+	host := parsed.Hostname()
+	ip := net.ParseIP(host)
+	loopback := host == "localhost" || (ip != nil && ip.IsLoopback())
+	if loopback && !allowLoopback {
+		return fmt.Errorf("webhook url host %q is not reachable from the internet", host)
+	}
+	if ip != nil && webhookAddressBlocked(ip, allowLoopback) {
+		return fmt.Errorf("webhook url host %q is not reachable from the internet", host)
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if loopback {
+			return nil
+		}
+		return errors.New("webhook url must use https")
+	default:
+		return fmt.Errorf("webhook url scheme %q is not supported", parsed.Scheme)
+	}
+}
+
+// webhookAddressBlocked reports whether webhooks may not be sent to ip: a
+// loopback address unless allowed, or a private, link-local, unspecified or
+// multicast one.
+func webhookAddressBlocked(ip net.IP, allowLoopback bool) bool {
+	if ip.IsLoopback() {
+		return !allowLoopback
+	}
+	return ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// webhookTransport connects only to addresses webhooks may be sent to. The
+// check runs on the resolved address as the connection is made, so a host
+// name that later resolves to an internal address is refused all the same.
+// Proxies are not used, as the address dialled would then be the proxy's.
+func webhookTransport(allowLoopback bool) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: webhookDialTimeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || webhookAddressBlocked(ip, allowLoopback) {
+				return fmt.Errorf("webhook address %s is not reachable from the internet", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:errcheck // always a *http.Transport
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// SignWebhook returns the signature header value for a body sent at the time.
+func SignWebhook(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(webhookMAC(secret, timestamp, body))
+}
+
+// VerifyWebhookSignature checks a signature header against the body, for
+// receivers of the webhooks. Signatures older than tolerance are rejected so
+// a captured request cannot be replayed later.
+func VerifyWebhookSignature(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp, signature string
+	for part := range strings.SplitSeq(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrWebhookSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return ErrWebhookSignature
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(got, webhookMAC(secret, timestamp, body)) {
+		return ErrWebhookSignature
+	}
+	return nil
+}
+
+func webhookMAC(secret, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
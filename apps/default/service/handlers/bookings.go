@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+// Booking routes. Slots are scheduled on a booking variant and closed to
+// further reservations one at a time. Availability takes from and to as
+// RFC 3339 times and answers with the open slots grouped by UTC day. A
+// booking line holds places in a slot for a cart, answering with the cart;
+// cancelling an order's bookings returns its places to their slots.
+const (
+	CreateSlotsPattern         = "POST /catalog/variants/{variant_id}/slots"
+	CloseSlotPattern           = "POST /catalog/slots/{slot_id}/close"
+	AvailabilityPattern        = "GET /catalog/products/{product_id}/availability"
+	AddBookingLinePattern      = "POST /carts/{cart_id}/bookings"
+	CancelOrderBookingsPattern = "POST /orders/{order_id}/bookings/cancel"
+)
+
+// bookingSlotView is the JSON form of a booking slot.
+type bookingSlotView struct {
+	ID        string    `json:"id"`
+	ProductID string    `json:"product_id"`
+	VariantID string    `json:"variant_id"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Capacity  int64     `json:"capacity"`
+	Reserved  int64     `json:"reserved"`
+	Open      bool      `json:"open"`
+}
+
+type bookingSlotsView struct {
+	Slots []bookingSlotView `json:"slots"`
+}
+
+// availabilityView is the JSON form of an availability calendar.
+type availabilityView struct {
+	Days []availabilityDayView `json:"days"`
+}
+
+type availabilityDayView struct {
+	Date  string                 `json:"date"`
+	Slots []slotAvailabilityView `json:"slots"`
+}
+
+type slotAvailabilityView struct {
+	SlotID    string    `json:"slot_id"`
+	VariantID string    `json:"variant_id"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Capacity  int64     `json:"capacity"`
+	Available int64     `json:"available"`
+}
+
+type cancelledBookingsView struct {
+	Released int `json:"released"`
+}
+
+type slotBody struct {
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+	Capacity int64     `json:"capacity"`
+}
+
+type createSlotsBody struct {
+	Slots []slotBody `json:"slots"`
+}
+
+type bookingLineBody struct {
+	SlotID   string `json:"slot_id"`
+	Quantity int64  `json:"quantity"`
+}
+
+func (cs *CommerceServer) CreateSlots(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	variantID := r.PathValue("variant_id")
+
+	if err := cs.authzBusiness.AuthorizeVariant(ctx, variantID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body createSlotsBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	inputs := make([]business.SlotInput, 0, len(body.Slots))
+	for _, slot := range body.Slots {
+		inputs = append(inputs, business.SlotInput{StartsAt: slot.StartsAt, EndsAt: slot.EndsAt, Capacity: slot.Capacity})
+	}
+
+	slots, err := cs.bookingBusiness.CreateSlots(ctx, variantID, inputs)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := bookingSlotsView{Slots: make([]bookingSlotView, 0, len(slots))}
+	for _, slot := range slots {
+		view.Slots = append(view.Slots, newBookingSlotView(slot))
+	}
+	writeJSON(w, r, http.StatusCreated, view)
+}
+
+func (cs *CommerceServer) CloseSlot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	slot, err := cs.bookingBusiness.GetSlot(ctx, r.PathValue("slot_id"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	if err = cs.authzBusiness.AuthorizeShop(ctx, slot.ShopID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	if err = cs.bookingBusiness.CloseSlot(ctx, slot.GetID()); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs *CommerceServer) Availability(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	from, to, err := availabilityRange(r)
+	if err != nil {
+		writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+
+	days, err := cs.bookingBusiness.ListAvailability(ctx, r.PathValue("product_id"), from, to)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := availabilityView{Days: make([]availabilityDayView, 0, len(days))}
+	for _, day := range days {
+		dayView := availabilityDayView{
+			Date:  day.Date.Format(time.DateOnly),
+			Slots: make([]slotAvailabilityView, 0, len(day.Slots)),
+		}
+		for _, slot := range day.Slots {
+			dayView.Slots = append(dayView.Slots, slotAvailabilityView{
+				SlotID:    slot.SlotID,
+				VariantID: slot.VariantID,
+				StartsAt:  slot.StartsAt,
+				EndsAt:    slot.EndsAt,
+				Capacity:  slot.Capacity,
+				Available: slot.Available,
+			})
+		}
+		view.Days = append(view.Days, dayView)
+	}
+	writeJSON(w, r, http.StatusOK, view)
+}
+
+func (cs *CommerceServer) AddBookingLine(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cartID := r.PathValue("cart_id")
+
+	if err := cs.authzBusiness.AuthorizeCart(ctx, cartID, business.PermissionCartsManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body bookingLineBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	cart, err := cs.bookingBusiness.AddBookingLine(ctx, cartID, body.SlotID, body.Quantity)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view, err := protojson.Marshal(cart)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSONBody(w, r, http.StatusOK, view)
+}
+
+func (cs *CommerceServer) CancelOrderBookings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	orderID := r.PathValue("order_id")
+
+	if err := cs.authzBusiness.AuthorizeOrder(ctx, orderID, business.PermissionOrdersManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	released, err := cs.bookingBusiness.CancelOrderBookings(ctx, orderID)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, cancelledBookingsView{Released: released})
+}
+
+// availabilityRange reads the required from and to times.
+func availabilityRange(r *http.Request) (time.Time, time.Time, error) {
+	query := r.URL.Query()
+	if query.Get("from") == "" || query.Get("to") == "" {
+		return time.Time{}, time.Time{}, errors.New("from and to are required")
+	}
+	from, err := time.Parse(time.RFC3339, query.Get("from"))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err := time.Parse(time.RFC3339, query.Get("to"))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return from, to, nil
+}
+
+func newBookingSlotView(slot *models.BookingSlot) bookingSlotView {
+	return bookingSlotView{
+		ID:        slot.GetID(),
+		ProductID: slot.ProductID,
+		VariantID: slot.ProductVariantID,
+		StartsAt:  slot.StartsAt,
+		EndsAt:    slot.EndsAt,
+		Capacity:  slot.Capacity,
+		Reserved:  slot.Reserved,
+		Open:      slot.Status == models.SlotStatusOpen,
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+// Bundle routes. Setting the components replaces a bundle variant's whole
+// composition. Both routes answer with the components and the number of
+// bundles their stock makes up.
+const (
+	ListBundleComponentsPattern = "GET /catalog/variants/{variant_id}/components"
+	SetBundleComponentsPattern  = "PUT /catalog/variants/{variant_id}/components"
+)
+
+// bundleView is the JSON form of a bundle's composition.
+type bundleView struct {
+	VariantID  string                `json:"variant_id"`
+	Available  int64                 `json:"available"`
+	Components []bundleComponentView `json:"components"`
+}
+
+type bundleComponentView struct {
+	VariantID string `json:"variant_id"`
+	Quantity  int64  `json:"quantity"`
+}
+
+type bundleComponentBody struct {
+	VariantID string `json:"variant_id"`
+	Quantity  int64  `json:"quantity"`
+}
+
+type setBundleComponentsBody struct {
+	Components []bundleComponentBody `json:"components"`
+}
+
+func (cs *CommerceServer) ListBundleComponents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	variantID := r.PathValue("variant_id")
+
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	components, err := cs.catalogBusiness.ListBundleComponents(ctx, variantID)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	cs.writeBundle(w, r, variantID, components)
+}
+
+func (cs *CommerceServer) SetBundleComponents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	variantID := r.PathValue("variant_id")
+
+	if err := cs.authzBusiness.AuthorizeVariant(ctx, variantID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body setBundleComponentsBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	inputs := make([]business.BundleComponentInput, 0, len(body.Components))
+	for _, component := range body.Components {
+		inputs = append(inputs, business.BundleComponentInput{VariantID: component.VariantID, Quantity: component.Quantity})
+	}
+
+	components, err := cs.catalogBusiness.SetBundleComponents(ctx, variantID, inputs)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	cs.writeBundle(w, r, variantID, components)
+}
+
+func (cs *CommerceServer) writeBundle(
+	w http.ResponseWriter,
+	r *http.Request,
+	variantID string,
+	components []*models.BundleComponent,
+) {
+	available, err := cs.catalogBusiness.BundleAvailability(r.Context(), variantID)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := bundleView{
+		VariantID:  variantID,
+		Available:  available,
+		Components: make([]bundleComponentView, 0, len(components)),
+	}
+	for _, component := range components {
+		view.Components = append(view.Components, bundleComponentView{
+			VariantID: component.ComponentVariantID,
+			Quantity:  component.Quantity,
+		})
+	}
+	writeJSON(w, r, http.StatusOK, view)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"time"
 
 	"buf.build/gen/go/antinvestor/commerce/connectrpc/go/commerce/v1/commercev1connect"
 	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
@@ -9,6 +10,7 @@ import (
 	"github.com/pitabwire/frame"
 	"github.com/pitabwire/frame/datastore"
 
+	aconfig "github.com/antinvestor/service-commerce/apps/default/config"
 	"github.com/antinvestor/service-commerce/apps/default/service/business"
 	"github.com/antinvestor/service-commerce/apps/default/service/repository"
 	"github.com/antinvestor/service-commerce/internal/errorutil"
@@ -20,6 +22,20 @@ type CommerceServer struct {
 	cartBusiness      business.CartBusiness
 	orderBusiness     business.OrderBusiness
 	fulfilmentBusiness business.FulfilmentBusiness
+	authzBusiness      business.AuthzBusiness
+	navigationBusiness business.NavigationBusiness
+	digitalBusiness    business.DigitalBusiness
+	paymentBusiness    business.PaymentBusiness
+	bookingBusiness    business.BookingBusiness
+	subscriptionBusiness business.SubscriptionBusiness
+	shippingBusiness     business.ShippingBusiness
+	documentBusiness     business.DocumentBusiness
+	invoiceBusiness      business.InvoiceBusiness
+	webhookBusiness      business.WebhookBusiness
+	importBusiness       business.ImportBusiness
+	exportBusiness       business.ExportBusiness
+	analyticsBusiness    business.AnalyticsBusiness
+	idempotencyBusiness  business.IdempotencyBusiness
 
 	commercev1connect.UnimplementedCommerceServiceHandler
 }
@@ -37,16 +53,156 @@ func NewCommerceServer(ctx context.Context, svc *frame.Service) *CommerceServer
 	orderLineRepo := repository.NewOrderLineRepository(ctx, dbPool, workMan)
 	fulfilmentRepo := repository.NewFulfilmentRepository(ctx, dbPool, workMan)
 	fulfilmentLineRepo := repository.NewFulfilmentLineRepository(ctx, dbPool, workMan)
+	sequenceRepo := repository.NewShopSequenceRepository(ctx, dbPool, workMan)
+	idempotencyRepo := repository.NewIdempotencyRepository(ctx, dbPool, workMan)
+	memberRepo := repository.NewShopMemberRepository(ctx, dbPool, workMan)
+	optionRepo := repository.NewProductOptionRepository(ctx, dbPool, workMan)
+	categoryRepo := repository.NewCategoryRepository(ctx, dbPool, workMan)
+	productCategoryRepo := repository.NewProductCategoryRepository(ctx, dbPool, workMan)
+	collectionRepo := repository.NewCollectionRepository(ctx, dbPool, workMan)
+	collectionProductRepo := repository.NewCollectionProductRepository(ctx, dbPool, workMan)
+	redirectRepo := repository.NewSlugRedirectRepository(ctx, dbPool, workMan)
+	assetRepo := repository.NewDigitalAssetRepository(ctx, dbPool, workMan)
+	entitlementRepo := repository.NewEntitlementRepository(ctx, dbPool, workMan)
+	slotRepo := repository.NewBookingSlotRepository(ctx, dbPool, workMan)
+	reservationRepo := repository.NewSlotReservationRepository(ctx, dbPool, workMan)
+	subscriptionRepo := repository.NewSubscriptionRepository(ctx, dbPool, workMan)
+	bundleRepo := repository.NewBundleComponentRepository(ctx, dbPool, workMan)
+	trackingRepo := repository.NewFulfilmentTrackingEventRepository(ctx, dbPool, workMan)
+	invoiceRepo := repository.NewInvoiceRepository(ctx, dbPool, workMan)
+	webhookEndpointRepo := repository.NewWebhookEndpointRepository(ctx, dbPool, workMan)
+	webhookDeliveryRepo := repository.NewWebhookDeliveryRepository(ctx, dbPool, workMan)
+	importRepo := repository.NewCatalogImportRepository(ctx, dbPool, workMan)
+	rollupRepo := repository.NewSalesRollupRepository(ctx, dbPool, workMan)
+
+	webhookBusiness := business.NewWebhookBusiness(
+		ctx, workMan, webhookPolicy(svc), webhookEndpointRepo, webhookDeliveryRepo,
+	)
+	catalogBusiness := business.NewCatalogBusiness(
+		ctx, productRepo, variantRepo, shopRepo, sequenceRepo, optionRepo, redirectRepo, bundleRepo,
+	)
+	orderBusiness := business.NewOrderBusiness(
+		ctx, orderRepo, orderLineRepo, variantRepo, productRepo, shopRepo,
+		cartRepo, cartLineRepo, sequenceRepo, idempotencyRepo, bundleRepo, webhookBusiness,
+	)
+	digitalBusiness := business.NewDigitalBusiness(
+		ctx, orderRepo, productRepo, variantRepo, fulfilmentRepo, fulfilmentLineRepo, assetRepo, entitlementRepo,
+	)
+	invoiceBusiness := business.NewInvoiceBusiness(ctx, invoiceRepo, orderRepo, shopRepo)
 
 	return &CommerceServer{
-		shopBusiness:    business.NewShopBusiness(ctx, shopRepo),
-		catalogBusiness: business.NewCatalogBusiness(ctx, productRepo, variantRepo, shopRepo),
-		cartBusiness:    business.NewCartBusiness(ctx, cartRepo, cartLineRepo, variantRepo),
-		orderBusiness:   business.NewOrderBusiness(ctx, orderRepo, orderLineRepo, variantRepo, shopRepo, cartRepo, cartLineRepo),
-		fulfilmentBusiness: business.NewFulfilmentBusiness(ctx, fulfilmentRepo, fulfilmentLineRepo, orderRepo, orderLineRepo),
+		shopBusiness:    business.NewShopBusiness(ctx, shopRepo, memberRepo, redirectRepo),
+		catalogBusiness: catalogBusiness,
+		cartBusiness:    business.NewCartBusiness(
+			ctx, cartRepo, cartLineRepo, variantRepo, productRepo, shopRepo, bundleRepo, reservationRepo,
+		),
+		orderBusiness:   orderBusiness,
+		fulfilmentBusiness: business.NewFulfilmentBusiness(ctx, fulfilmentRepo, fulfilmentLineRepo, orderRepo, orderLineRepo, idempotencyRepo, webhookBusiness),
+		authzBusiness: business.NewAuthzBusiness(
+			ctx, memberRepo, productRepo, variantRepo, cartRepo, orderRepo, fulfilmentRepo,
+		),
+		navigationBusiness: business.NewNavigationBusiness(
+			ctx, catalogBusiness, shopRepo, productRepo,
+			categoryRepo, productCategoryRepo, collectionRepo, collectionProductRepo,
+		),
+		digitalBusiness: digitalBusiness,
+		paymentBusiness: business.NewPaymentBusiness(ctx, orderRepo, digitalBusiness, invoiceBusiness, webhookBusiness),
+		bookingBusiness: business.NewBookingBusiness(
+			ctx, productRepo, variantRepo, cartRepo, slotRepo, reservationRepo,
+		),
+		subscriptionBusiness: business.NewSubscriptionBusiness(
+			ctx, orderBusiness, subscriptionRepo, orderRepo, productRepo, variantRepo,
+		),
+		shippingBusiness: business.NewShippingBusiness(
+			ctx, configuredCarriers(svc), fulfilmentRepo, fulfilmentLineRepo, trackingRepo, orderRepo, webhookBusiness,
+		),
+		documentBusiness: business.NewDocumentBusiness(ctx, shopRepo, orderRepo, variantRepo, fulfilmentRepo),
+		invoiceBusiness:  invoiceBusiness,
+		webhookBusiness:  webhookBusiness,
+		importBusiness: business.NewImportBusiness(
+			ctx, workMan, importRepo, shopRepo, productRepo, variantRepo, optionRepo, redirectRepo,
+		),
+		exportBusiness:    business.NewExportBusiness(ctx, productRepo, orderRepo, bundleRepo),
+		analyticsBusiness: business.NewAnalyticsBusiness(ctx, rollupRepo, shopRepo),
+		idempotencyBusiness: business.NewIdempotencyBusiness(ctx, idempotencyRepo),
 	}
 }
 
+// configuredCarriers builds the carrier integrations enabled in the config.
+func configuredCarriers(svc *frame.Service) business.CarrierRegistry {
+	var providers []business.CarrierProvider
+	if cfg, ok := svc.Config().(*aconfig.CommerceConfig); ok && cfg.FakeCarrierWebhookSecret != "" {
+		providers = append(providers, business.NewFakeCarrier(cfg.FakeCarrierWebhookSecret))
+	}
+	return business.NewCarrierRegistry(providers...)
+}
+
+// webhookPolicy is the default webhook policy, with loopback endpoints
+// allowed when the config enables them.
+func webhookPolicy(svc *frame.Service) business.WebhookPolicy {
+	policy := business.DefaultWebhookPolicy()
+	if cfg, ok := svc.Config().(*aconfig.CommerceConfig); ok {
+		policy.AllowLoopback = cfg.WebhookAllowLoopback
+	}
+	return policy
+}
+
+const (
+	// expiredHoldSweepInterval is how often held slot places are checked for expiry.
+	expiredHoldSweepInterval = time.Minute
+	// dueSubscriptionSweepInterval is how often subscriptions are checked for due runs.
+	dueSubscriptionSweepInterval = 5 * time.Minute
+	// webhookRetrySweepInterval is how often failed webhook deliveries are
+	// checked for a due retry.
+	webhookRetrySweepInterval = 30 * time.Second
+	// salesRollupRefreshInterval is how often the sales rollups catch up with
+	// changed orders, and so how stale a sales report may be.
+	salesRollupRefreshInterval = 10 * time.Minute
+	// idempotencyPurgeInterval is how often expired idempotency claims are deleted.
+	idempotencyPurgeInterval = time.Hour
+)
+
+// RunScheduledTasks runs the service's periodic background work until ctx
+// is done.
+func (cs *CommerceServer) RunScheduledTasks(ctx context.Context) error {
+	return business.RunScheduler(ctx, business.ScheduledTask{
+		Name:     "release_expired_booking_holds",
+		Interval: expiredHoldSweepInterval,
+		Run: func(ctx context.Context) error {
+			_, err := cs.bookingBusiness.ReleaseExpiredHolds(ctx, time.Now())
+			return err
+		},
+	}, business.ScheduledTask{
+		Name:     "run_due_subscriptions",
+		Interval: dueSubscriptionSweepInterval,
+		Run: func(ctx context.Context) error {
+			_, err := cs.subscriptionBusiness.RunDue(ctx, time.Now())
+			return err
+		},
+	}, business.ScheduledTask{
+		Name:     "retry_webhook_deliveries",
+		Interval: webhookRetrySweepInterval,
+		Run: func(ctx context.Context) error {
+			_, err := cs.webhookBusiness.RetryDue(ctx, time.Now())
+			return err
+		},
+	}, business.ScheduledTask{
+		Name:     "refresh_sales_rollups",
+		Interval: salesRollupRefreshInterval,
+		Run: func(ctx context.Context) error {
+			_, err := cs.analyticsBusiness.RefreshRollups(ctx, time.Now())
+			return err
+		},
+	}, business.ScheduledTask{
+		Name:     "purge_expired_idempotency_keys",
+		Interval: idempotencyPurgeInterval,
+		Run: func(ctx context.Context) error {
+			_, err := cs.idempotencyBusiness.PurgeExpired(ctx, time.Now())
+			return err
+		},
+	})
+}
+
 // ----------------------
 // Shop
 // ----------------------
@@ -55,6 +211,9 @@ func (cs *CommerceServer) CreateShop(
 	ctx context.Context,
 	req *connect.Request[commercev1.CreateShopRequest],
 ) (*connect.Response[commercev1.CreateShopResponse], error) {
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
 	shop, err := cs.shopBusiness.CreateShop(ctx, req.Msg)
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -66,6 +225,9 @@ func (cs *CommerceServer) GetShop(
 	ctx context.Context,
 	req *connect.Request[commercev1.GetShopRequest],
 ) (*connect.Response[commercev1.GetShopResponse], error) {
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
 	shop, err := cs.shopBusiness.GetShop(ctx, req.Msg.GetId())
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -77,6 +239,9 @@ func (cs *CommerceServer) UpdateShop(
 	ctx context.Context,
 	req *connect.Request[commercev1.UpdateShopRequest],
 ) (*connect.Response[commercev1.UpdateShopResponse], error) {
+	if err := cs.authzBusiness.AuthorizeShop(ctx, req.Msg.GetId(), business.PermissionShopManage); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
 	shop, err := cs.shopBusiness.UpdateShop(ctx, req.Msg)
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -84,6 +249,12 @@ func (cs *CommerceServer) UpdateShop(
 	return connect.NewResponse(&commercev1.UpdateShopResponse{Shop: shop}), nil
 }
 
+// Shop membership is served over plain HTTP routes, see members.go, guarded
+// by PermissionMembersManage.
+//
+// Shop slugs are resolved by ShopBySlug and moved by ChangeShopSlug, plain
+// HTTP routes in slugs.go; retired slugs are kept as redirects.
+
 // ----------------------
 // Catalog
 // ----------------------
@@ -92,6 +263,9 @@ func (cs *CommerceServer) CreateProduct(
 	ctx context.Context,
 	req *connect.Request[commercev1.CreateProductRequest],
 ) (*connect.Response[commercev1.CreateProductResponse], error) {
+	if err := cs.authzBusiness.AuthorizeShop(ctx, req.Msg.GetShopId(), business.PermissionCatalogManage); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
 	product, err := cs.catalogBusiness.CreateProduct(ctx, req.Msg)
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -103,6 +277,9 @@ func (cs *CommerceServer) GetProduct(
 	ctx context.Context,
 	req *connect.Request[commercev1.GetProductRequest],
 ) (*connect.Response[commercev1.GetProductResponse], error) {
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
 	product, err := cs.catalogBusiness.GetProduct(ctx, req.Msg.GetId())
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -114,7 +291,10 @@ func (cs *CommerceServer) ListProducts(
 	ctx context.Context,
 	req *connect.Request[commercev1.ListProductsRequest],
 ) (*connect.Response[commercev1.ListProductsResponse], error) {
-	products, err := cs.catalogBusiness.ListProducts(ctx, req.Msg)
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
+	products, err := cs.navigationBusiness.ListProducts(ctx, req.Msg)
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
 	}
@@ -124,11 +304,32 @@ func (cs *CommerceServer) ListProducts(
 // ListProductVariants handler will be wired here once the proto is updated with:
 //   rpc ListProductVariants(ListProductVariantsRequest) returns (ListProductVariantsResponse)
 // The business logic is already implemented in CatalogBusiness.ListProductVariants().
+//
+// Product options and the variant matrix are managed over plain HTTP routes,
+// see options.go, guarded by PermissionCatalogManage on the product.
+//
+// Product slugs are resolved by ProductBySlug and moved by RenameProduct and
+// ChangeProductSlug, plain HTTP routes guarded by PermissionCatalogManage.
+//
+// Bundles are composed over plain HTTP routes, see bundles.go, under
+// PermissionCatalogManage. Their stock is derived from the components, which
+// UpdateProductVariant and ListProductVariants already report.
+//
+// Warehouse locations, which order pick lists, are set over a plain HTTP
+// route, see locations.go, under PermissionCatalogManage.
+//
+// Categories and collections are managed over plain HTTP routes, see
+// navigation.go, guarded by PermissionCatalogManage on their shop.
+// ListProducts also narrows to a category or collection through the search
+// extras "category_id" and "collection_id".
 
 func (cs *CommerceServer) CreateProductVariant(
 	ctx context.Context,
 	req *connect.Request[commercev1.CreateProductVariantRequest],
 ) (*connect.Response[commercev1.CreateProductVariantResponse], error) {
+	if err := cs.authzBusiness.AuthorizeProduct(ctx, req.Msg.GetProductId(), business.PermissionCatalogManage); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
 	variant, err := cs.catalogBusiness.CreateProductVariant(ctx, req.Msg)
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -140,6 +341,9 @@ func (cs *CommerceServer) UpdateProductVariant(
 	ctx context.Context,
 	req *connect.Request[commercev1.UpdateProductVariantRequest],
 ) (*connect.Response[commercev1.UpdateProductVariantResponse], error) {
+	if err := cs.authzBusiness.AuthorizeVariant(ctx, req.Msg.GetVariantId(), business.PermissionCatalogManage); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
 	variant, err := cs.catalogBusiness.UpdateProductVariant(ctx, req.Msg)
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -155,6 +359,11 @@ func (cs *CommerceServer) CreateCart(
 	ctx context.Context,
 	req *connect.Request[commercev1.CreateCartRequest],
 ) (*connect.Response[commercev1.CreateCartResponse], error) {
+	if err := cs.authzBusiness.AuthorizeProfile(
+		ctx, req.Msg.GetShopId(), req.Msg.GetProfileId(), business.PermissionCartsManage,
+	); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
 	cart, err := cs.cartBusiness.CreateCart(ctx, req.Msg)
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -166,6 +375,9 @@ func (cs *CommerceServer) GetCart(
 	ctx context.Context,
 	req *connect.Request[commercev1.GetCartRequest],
 ) (*connect.Response[commercev1.GetCartResponse], error) {
+	if err := cs.authzBusiness.AuthorizeCart(ctx, req.Msg.GetId(), business.PermissionCartsView); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
 	cart, err := cs.cartBusiness.GetCart(ctx, req.Msg.GetId())
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -177,6 +389,9 @@ func (cs *CommerceServer) AddCartLine(
 	ctx context.Context,
 	req *connect.Request[commercev1.AddCartLineRequest],
 ) (*connect.Response[commercev1.AddCartLineResponse], error) {
+	if err := cs.authzBusiness.AuthorizeCart(ctx, req.Msg.GetCartId(), business.PermissionCartsManage); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
 	cart, err := cs.cartBusiness.AddCartLine(ctx, req.Msg)
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -188,6 +403,9 @@ func (cs *CommerceServer) RemoveCartLine(
 	ctx context.Context,
 	req *connect.Request[commercev1.RemoveCartLineRequest],
 ) (*connect.Response[commercev1.RemoveCartLineResponse], error) {
+	if err := cs.authzBusiness.AuthorizeCart(ctx, req.Msg.GetCartId(), business.PermissionCartsManage); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
 	cart, err := cs.cartBusiness.RemoveCartLine(ctx, req.Msg)
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -195,6 +413,17 @@ func (cs *CommerceServer) RemoveCartLine(
 	return connect.NewResponse(&commercev1.RemoveCartLineResponse{Cart: cart}), nil
 }
 
+// Subscriptions are served over plain HTTP routes, see subscriptions.go.
+// Subscribe, Pause, Resume, Skip and Cancel manage a profile's recurring
+// orders, allowed to the profile itself or to shop members holding
+// PermissionOrdersManage; RunScheduledTasks places the due runs.
+
+// Bookings are served over plain HTTP routes, see bookings.go. AddBookingLine
+// holds slot places in a cart under PermissionCartsManage; CreateSlots and
+// CloseSlot manage a booking product's calendar under PermissionCatalogManage
+// and Availability publishes it. CancelOrderBookings returns an order's places
+// to their slots under PermissionOrdersManage.
+
 // ----------------------
 // Orders
 // ----------------------
@@ -203,6 +432,10 @@ func (cs *CommerceServer) CreateOrderFromCart(
 	ctx context.Context,
 	req *connect.Request[commercev1.CreateOrderFromCartRequest],
 ) (*connect.Response[commercev1.CreateOrderFromCartResponse], error) {
+	if err := cs.authorizeCartConversion(ctx, req.Msg); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
+	ctx = business.IdempotencyKeyToContext(ctx, req.Header().Get(business.IdempotencyKeyHeader))
 	order, err := cs.orderBusiness.CreateOrderFromCart(ctx, req.Msg)
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -214,6 +447,12 @@ func (cs *CommerceServer) CreateOrder(
 	ctx context.Context,
 	req *connect.Request[commercev1.CreateOrderRequest],
 ) (*connect.Response[commercev1.CreateOrderResponse], error) {
+	if err := cs.authzBusiness.AuthorizeProfile(
+		ctx, req.Msg.GetShopId(), req.Msg.GetProfileId(), business.PermissionOrdersManage,
+	); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
+	ctx = business.IdempotencyKeyToContext(ctx, req.Header().Get(business.IdempotencyKeyHeader))
 	order, err := cs.orderBusiness.CreateOrder(ctx, req.Msg)
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -225,6 +464,9 @@ func (cs *CommerceServer) GetOrder(
 	ctx context.Context,
 	req *connect.Request[commercev1.GetOrderRequest],
 ) (*connect.Response[commercev1.GetOrderResponse], error) {
+	if err := cs.authzBusiness.AuthorizeOrder(ctx, req.Msg.GetId(), business.PermissionOrdersView); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
 	order, err := cs.orderBusiness.GetOrder(ctx, req.Msg.GetId())
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -236,6 +478,9 @@ func (cs *CommerceServer) ListOrders(
 	ctx context.Context,
 	req *connect.Request[commercev1.ListOrdersRequest],
 ) (*connect.Response[commercev1.ListOrdersResponse], error) {
+	if err := cs.authzBusiness.AuthorizeShop(ctx, req.Msg.GetShopId(), business.PermissionOrdersView); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
 	orders, err := cs.orderBusiness.ListOrders(ctx, req.Msg)
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -243,6 +488,22 @@ func (cs *CommerceServer) ListOrders(
 	return connect.NewResponse(&commercev1.ListOrdersResponse{Orders: orders}), nil
 }
 
+// Customers read their own orders from MyOrders, a plain HTTP route keyed by
+// the caller's profile, and start a cart from a past order with Reorder,
+// guarded by PermissionCartsManage on the order. CancelOrder, see orders.go,
+// cancels an unpaid, unshipped order under PermissionOrdersManage and gives
+// back its stock and booking places.
+
+// Payments are reported by the payment service to CapturePayment and
+// RefundPayment, plain HTTP routes that only internal callers may use.
+// Capture marks the order paid, invoices it and delivers its digital lines;
+// refund marks it refunded, gives back its unshipped stock and issues a
+// credit note.
+//
+// Invoices and credit notes are streamed as PDF or HTML receipts by
+// InvoiceDocument, a plain HTTP route guarded by PermissionOrdersView on the
+// invoiced order, so both the customer and the shop can fetch them.
+
 // ----------------------
 // Fulfilment
 // ----------------------
@@ -251,6 +512,10 @@ func (cs *CommerceServer) CreateFulfilment(
 	ctx context.Context,
 	req *connect.Request[commercev1.CreateFulfilmentRequest],
 ) (*connect.Response[commercev1.CreateFulfilmentResponse], error) {
+	if err := cs.authzBusiness.AuthorizeOrder(ctx, req.Msg.GetOrderId(), business.PermissionFulfilmentManage); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
+	ctx = business.IdempotencyKeyToContext(ctx, req.Header().Get(business.IdempotencyKeyHeader))
 	fulfilment, err := cs.fulfilmentBusiness.CreateFulfilment(ctx, req.Msg)
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -262,6 +527,9 @@ func (cs *CommerceServer) UpdateFulfilment(
 	ctx context.Context,
 	req *connect.Request[commercev1.UpdateFulfilmentRequest],
 ) (*connect.Response[commercev1.UpdateFulfilmentResponse], error) {
+	if err := cs.authzBusiness.AuthorizeFulfilment(ctx, req.Msg.GetId(), business.PermissionFulfilmentManage); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
 	fulfilment, err := cs.fulfilmentBusiness.UpdateFulfilment(ctx, req.Msg)
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
@@ -273,9 +541,58 @@ func (cs *CommerceServer) GetFulfilment(
 	ctx context.Context,
 	req *connect.Request[commercev1.GetFulfilmentRequest],
 ) (*connect.Response[commercev1.GetFulfilmentResponse], error) {
+	if err := cs.authzBusiness.AuthorizeFulfilment(ctx, req.Msg.GetId(), business.PermissionFulfilmentView); err != nil {
+		return nil, errorutil.CleanErr(err)
+	}
 	fulfilment, err := cs.fulfilmentBusiness.GetFulfilment(ctx, req.Msg.GetId())
 	if err != nil {
 		return nil, errorutil.CleanErr(err)
 	}
 	return connect.NewResponse(&commercev1.GetFulfilmentResponse{Fulfilment: fulfilment}), nil
 }
+
+// Shipping is served over plain HTTP routes, see shipping.go. Quotes, labels
+// and tracking syncs are guarded by PermissionFulfilmentManage on the
+// fulfilment and reading its tracking by PermissionFulfilmentView. Carriers
+// push tracking updates to CarrierWebhook instead.
+//
+// Pick lists and packing slips are streamed as PDF or HTML by PickListDocument
+// and PackingSlipDocument, plain HTTP routes guarded by PermissionFulfilmentView.
+
+// Webhook endpoints, their deliveries and redelivery are served over plain
+// HTTP routes, see webhook_endpoints.go, guarded by PermissionShopManage on
+// the endpoint's shop.
+
+// Catalog imports are uploaded to StartCatalogImport and followed with
+// CatalogImportStatus, plain HTTP routes guarded by PermissionCatalogManage.
+//
+// Exports are streamed by CatalogExport, guarded by PermissionCatalogManage,
+// and OrderExport, guarded by PermissionOrdersView.
+//
+// Sales reports are read from SalesReport, ProductReport and VariantReport,
+// plain HTTP routes guarded by PermissionOrdersView. They come from rollups
+// that the refresh_sales_rollups task keeps up to date;
+// AnalyticsBusiness.RebuildRollups recomputes a shop's range when backfilling.
+
+// Digital delivery is served over plain HTTP routes, see digital.go.
+// ListEntitlements and IssueDownloadToken are guarded by
+// PermissionFulfilmentView on the entitlement's order; SetDigitalAsset by
+// PermissionCatalogManage. Download tokens are redeemed at RedeemDownload, a
+// plain HTTP route that needs only the token.
+
+// authorizeCartConversion checks that the caller may convert the cart and
+// place the resulting order for the requested profile.
+func (cs *CommerceServer) authorizeCartConversion(
+	ctx context.Context,
+	req *commercev1.CreateOrderFromCartRequest,
+) error {
+	if err := cs.authzBusiness.AuthorizeCart(ctx, req.GetCartId(), business.PermissionCartsManage); err != nil {
+		return err
+	}
+
+	cart, err := cs.cartBusiness.GetCart(ctx, req.GetCartId())
+	if err != nil {
+		return err
+	}
+	return cs.authzBusiness.AuthorizeProfile(ctx, cart.GetShopId(), req.GetProfileId(), business.PermissionOrdersManage)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+// Digital delivery routes. A digital variant is given the file it delivers;
+// the buyer then lists the entitlements of a paid order and is issued a
+// download token for one, revoking any token issued before. Tokens are
+// redeemed at RedeemDownloadPattern.
+const (
+	SetDigitalAssetPattern    = "PUT /catalog/variants/{variant_id}/digital-asset"
+	ListEntitlementsPattern   = "GET /orders/{order_id}/entitlements"
+	IssueDownloadTokenPattern = "POST /entitlements/{entitlement_id}/token"
+)
+
+// digitalAssetView is the JSON form of the file a digital variant delivers.
+type digitalAssetView struct {
+	VariantID    string `json:"variant_id"`
+	FileID       string `json:"file_id"`
+	MaxDownloads int32  `json:"max_downloads"`
+	AccessDays   int32  `json:"access_days"`
+}
+
+// entitlementView is the JSON form of a buyer's entitlement to a file.
+type entitlementView struct {
+	ID                 string    `json:"id"`
+	OrderID            string    `json:"order_id"`
+	OrderLineID        string    `json:"order_line_id"`
+	VariantID          string    `json:"variant_id"`
+	FileID             string    `json:"file_id"`
+	DownloadsRemaining int32     `json:"downloads_remaining"`
+	ExpiresAt          time.Time `json:"expires_at"`
+}
+
+type entitlementsView struct {
+	Entitlements []entitlementView `json:"entitlements"`
+}
+
+// downloadTokenView is the JSON form of a download token, shown only when it
+// is issued.
+type downloadTokenView struct {
+	EntitlementID string    `json:"entitlement_id"`
+	FileID        string    `json:"file_id"`
+	Token         string    `json:"token"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+type setDigitalAssetBody struct {
+	FileID       string `json:"file_id"`
+	MaxDownloads int32  `json:"max_downloads"`
+	AccessDays   int32  `json:"access_days"`
+}
+
+func (cs *CommerceServer) SetDigitalAsset(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	variantID := r.PathValue("variant_id")
+
+	if err := cs.authzBusiness.AuthorizeVariant(ctx, variantID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body setDigitalAssetBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	asset, err := cs.digitalBusiness.SetDigitalAsset(ctx, variantID, business.DigitalAssetInput{
+		FileID:       body.FileID,
+		MaxDownloads: body.MaxDownloads,
+		AccessDays:   body.AccessDays,
+	})
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, digitalAssetView{
+		VariantID:    asset.ProductVariantID,
+		FileID:       asset.FileID,
+		MaxDownloads: asset.MaxDownloads,
+		AccessDays:   asset.AccessDays,
+	})
+}
+
+func (cs *CommerceServer) ListEntitlements(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	orderID := r.PathValue("order_id")
+
+	if err := cs.authzBusiness.AuthorizeOrder(ctx, orderID, business.PermissionFulfilmentView); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	entitlements, err := cs.digitalBusiness.ListEntitlements(ctx, orderID)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := entitlementsView{Entitlements: make([]entitlementView, 0, len(entitlements))}
+	for _, entitlement := range entitlements {
+		view.Entitlements = append(view.Entitlements, newEntitlementView(entitlement))
+	}
+	writeJSON(w, r, http.StatusOK, view)
+}
+
+func (cs *CommerceServer) IssueDownloadToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	entitlement, err := cs.digitalBusiness.GetEntitlement(ctx, r.PathValue("entitlement_id"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	if err = cs.authzBusiness.AuthorizeOrder(ctx, entitlement.OrderID, business.PermissionFulfilmentView); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	token, err := cs.digitalBusiness.IssueDownloadToken(ctx, entitlement.GetID())
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, newDownloadTokenView(token))
+}
+
+func newEntitlementView(entitlement *models.Entitlement) entitlementView {
+	return entitlementView{
+		ID:                 entitlement.GetID(),
+		OrderID:            entitlement.OrderID,
+		OrderLineID:        entitlement.OrderLineID,
+		VariantID:          entitlement.ProductVariantID,
+		FileID:             entitlement.FileID,
+		DownloadsRemaining: max(entitlement.MaxDownloads-entitlement.DownloadCount, 0),
+		ExpiresAt:          entitlement.ExpiresAt,
+	}
+}
+
+func newDownloadTokenView(token *business.DownloadToken) downloadTokenView {
+	return downloadTokenView{
+		EntitlementID: token.EntitlementID,
+		FileID:        token.FileID,
+		Token:         token.Token,
+		ExpiresAt:     token.ExpiresAt,
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/util"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+)
+
+// Warehouse document routes. Both take a format query parameter, pdf (the
+// default) or html; a pick list may name fulfilment_id once per fulfilment
+// to batch, and otherwise covers every fulfilment waiting to be picked.
+const (
+	PickListPattern    = "GET /documents/shops/{shop_id}/pick-list"
+	PackingSlipPattern = "GET /documents/fulfilments/{fulfilment_id}/packing-slip"
+)
+
+// InvoicePattern serves an invoice or credit note as a receipt, in the same
+// formats as the warehouse documents.
+const InvoicePattern = "GET /documents/invoices/{invoice_id}"
+
+func (cs *CommerceServer) PickListDocument(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	shopID := r.PathValue("shop_id")
+
+	format, err := documentFormat(r)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	if err = cs.authzBusiness.AuthorizeShop(ctx, shopID, business.PermissionFulfilmentView); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	list, err := cs.documentBusiness.PickList(ctx, shopID, r.URL.Query()["fulfilment_id"])
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	writeDocumentHeaders(w, format, "pick-list")
+	if err = business.RenderPickList(w, list, format); err != nil {
+		logDocumentError(r, err)
+	}
+}
+
+func (cs *CommerceServer) PackingSlipDocument(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	fulfilmentID := r.PathValue("fulfilment_id")
+
+	format, err := documentFormat(r)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	err = cs.authzBusiness.AuthorizeFulfilment(ctx, fulfilmentID, business.PermissionFulfilmentView)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	slip, err := cs.documentBusiness.PackingSlip(ctx, fulfilmentID)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	writeDocumentHeaders(w, format, "packing-slip-"+slip.OrderNumber)
+	if err = business.RenderPackingSlip(w, slip, format); err != nil {
+		logDocumentError(r, err)
+	}
+}
+
+func (cs *CommerceServer) InvoiceDocument(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	format, err := documentFormat(r)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	if _, err = cs.authzBusiness.Authenticated(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	invoice, err := cs.invoiceBusiness.GetInvoice(ctx, r.PathValue("invoice_id"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	if err = cs.authzBusiness.AuthorizeOrder(ctx, invoice.OrderID, business.PermissionOrdersView); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	writeDocumentHeaders(w, format, invoice.Number)
+	if err = business.RenderInvoice(w, invoice, format); err != nil {
+		logDocumentError(r, err)
+	}
+}
+
+func documentFormat(r *http.Request) (business.DocumentFormat, error) {
+	format, err := business.ParseDocumentFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		return "", connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	return format, nil
+}
+
+func writeDocumentHeaders(w http.ResponseWriter, format business.DocumentFormat, name string) {
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", name+"."+string(format)))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// logDocumentError logs a failure part way through streaming a document,
+// when the status has already been sent.
+func logDocumentError(r *http.Request, err error) {
+	util.Log(r.Context()).WithError(err).With("path", r.URL.Path).Error("could not render document")
+}
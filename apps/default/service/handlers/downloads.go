@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+)
+
+// RedeemDownloadPattern redeems a download token for the file it grants. The
+// token, given as {"token": ...}, is the only credential, so the route needs
+// no authentication; each redemption counts against the download allowance.
+const RedeemDownloadPattern = "POST /downloads/redeem"
+
+// downloadView is the JSON form of a redeemed download.
+type downloadView struct {
+	EntitlementID      string    `json:"entitlement_id"`
+	FileID             string    `json:"file_id"`
+	DownloadsRemaining int32     `json:"downloads_remaining"`
+	ExpiresAt          time.Time `json:"expires_at"`
+}
+
+type redeemDownloadBody struct {
+	Token string `json:"token"`
+}
+
+func (cs *CommerceServer) RedeemDownload(w http.ResponseWriter, r *http.Request) {
+	var body redeemDownloadBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	entitlement, err := cs.digitalBusiness.RedeemDownloadToken(r.Context(), body.Token)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, downloadView{
+		EntitlementID:      entitlement.GetID(),
+		FileID:             entitlement.FileID,
+		DownloadsRemaining: max(entitlement.MaxDownloads-entitlement.DownloadCount, 0),
+		ExpiresAt:          entitlement.ExpiresAt,
+	})
+}
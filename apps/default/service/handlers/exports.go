@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/util"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+// Export routes. Both take a format query parameter, csv (the default) or
+// jsonl, and stream the export as it is read. The order export also takes
+// from and to, as RFC 3339 times or dates, and status once per order status
+// to include.
+const (
+	CatalogExportPattern = "GET /exports/shops/{shop_id}/catalog"
+	OrderExportPattern   = "GET /exports/shops/{shop_id}/orders"
+)
+
+func (cs *CommerceServer) CatalogExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	shopID := r.PathValue("shop_id")
+
+	format, err := business.ParseExportFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	if err = cs.authzBusiness.AuthorizeShop(ctx, shopID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	stream := newExportStream(w, format, "catalog")
+	stream.finish(r, cs.exportBusiness.ExportCatalog(ctx, shopID, format, stream))
+}
+
+func (cs *CommerceServer) OrderExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	shopID := r.PathValue("shop_id")
+
+	format, err := business.ParseExportFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	filter, err := orderExportFilter(r, shopID)
+	if err != nil {
+		writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	if err = cs.authzBusiness.AuthorizeShop(ctx, shopID, business.PermissionOrdersView); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	stream := newExportStream(w, format, "orders")
+	stream.finish(r, cs.exportBusiness.ExportOrders(ctx, filter, format, stream))
+}
+
+func orderExportFilter(r *http.Request, shopID string) (repository.OrderExportFilter, error) {
+	query := r.URL.Query()
+	filter := repository.OrderExportFilter{ShopID: shopID}
+
+	var err error
+	if filter.From, err = parseExportTime(query.Get("from")); err != nil {
+		return filter, fmt.Errorf("from: %w", err)
+	}
+	if filter.To, err = parseExportTime(query.Get("to")); err != nil {
+		return filter, fmt.Errorf("to: %w", err)
+	}
+	for _, value := range query["status"] {
+		for name := range strings.SplitSeq(value, ",") {
+			status, parseErr := business.ParseOrderStatus(name)
+			if parseErr != nil {
+				return filter, parseErr
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+	return filter, nil
+}
+
+// parseExportTime reads an RFC 3339 time or a date, which is midnight UTC.
+func parseExportTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// exportStream sends an export to the client as it is written, flushing each
+// write so the response is chunked rather than buffered.
+type exportStream struct {
+	w     http.ResponseWriter
+	rc    *http.ResponseController
+	wrote bool
+}
+
+func newExportStream(w http.ResponseWriter, format business.ExportFormat, name string) *exportStream {
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+string(format)))
+	w.Header().Set("Cache-Control", "no-store")
+	return &exportStream{w: w, rc: http.NewResponseController(w)}
+}
+
+func (s *exportStream) Write(p []byte) (int, error) {
+	s.wrote = true
+	n, err := s.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if err = s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return n, err
+	}
+	return n, nil
+}
+
+// finish reports an export that failed. Before anything was sent the client
+// gets the error status; after, the response is cut short and only logged.
+func (s *exportStream) finish(r *http.Request, err error) {
+	if err == nil {
+		return
+	}
+	if !s.wrote {
+		s.w.Header().Del("Content-Disposition")
+		writeHTTPError(s.w, r, err)
+		return
+	}
+	util.Log(r.Context()).WithError(err).With("path", r.URL.Path).Error("export failed part way")
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"connectrpc.com/connect"
+	"github.com/pitabwire/util"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+	"github.com/antinvestor/service-commerce/apps/default/service/repository"
+)
+
+// Order history routes. MyOrders lists the caller's own orders, newest
+// first, and takes shop_id to keep to one shop, status once per order status
+// to include, and limit and offset. Reorder starts a cart from a past order
+// and answers with the cart and the lines that did not carry over as they
+// were.
+const (
+	MyOrdersPattern = "GET /orders/mine"
+	ReorderPattern  = "POST /orders/{order_id}/reorder"
+)
+
+// reorderView is the JSON form of a reorder. The cart is in its proto JSON
+// form.
+type reorderView struct {
+	Cart   json.RawMessage    `json:"cart"`
+	Issues []reorderIssueView `json:"issues"`
+}
+
+type reorderIssueView struct {
+	OrderLineID      string `json:"order_line_id"`
+	ProductVariantID string `json:"product_variant_id"`
+	SKU              string `json:"sku"`
+	Name             string `json:"name"`
+	Problem          string `json:"problem"`
+	Quantity         int64  `json:"quantity"`
+	AddedQuantity    int64  `json:"added_quantity"`
+	Currency         string `json:"currency,omitempty"`
+	PreviousPrice    string `json:"previous_price,omitempty"`
+	CurrentPrice     string `json:"current_price,omitempty"`
+}
+
+func (cs *CommerceServer) MyOrders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	profileID, err := cs.authzBusiness.Authenticated(ctx)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := repository.OrderHistoryFilter{ProfileID: profileID, ShopID: query.Get("shop_id")}
+	for _, value := range query["status"] {
+		for name := range strings.SplitSeq(value, ",") {
+			status, parseErr := business.ParseOrderStatus(name)
+			if parseErr != nil {
+				writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, parseErr))
+				return
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+	limit, offset, err := historyPage(r)
+	if err != nil {
+		writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+
+	orders, err := cs.orderBusiness.ListMyOrders(ctx, filter, limit, offset)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	body, err := protojson.Marshal(&commercev1.ListOrdersResponse{Orders: orders})
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSONBody(w, r, http.StatusOK, body)
+}
+
+func (cs *CommerceServer) Reorder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	orderID := r.PathValue("order_id")
+
+	if err := cs.authzBusiness.AuthorizeOrder(ctx, orderID, business.PermissionCartsManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	result, err := cs.orderBusiness.Reorder(ctx, orderID)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	cart, err := protojson.Marshal(result.Cart)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := reorderView{Cart: cart, Issues: make([]reorderIssueView, 0, len(result.Issues))}
+	for _, issue := range result.Issues {
+		issueView := reorderIssueView{
+			OrderLineID:      issue.OrderLineID,
+			ProductVariantID: issue.ProductVariantID,
+			SKU:              issue.SKU,
+			Name:             issue.Name,
+			Problem:          string(issue.Problem),
+			Quantity:         issue.Quantity,
+			AddedQuantity:    issue.AddedQuantity,
+		}
+		if issue.CurrentPrice != nil {
+			issueView.Currency = issue.CurrentPrice.GetCurrencyCode()
+			issueView.PreviousPrice = moneyAmount(issue.PreviousPrice)
+			issueView.CurrentPrice = moneyAmount(issue.CurrentPrice)
+		}
+		view.Issues = append(view.Issues, issueView)
+	}
+
+	body, err := json.Marshal(view)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSONBody(w, r, http.StatusCreated, body)
+}
+
+// historyPage reads the limit and offset; the business applies the defaults.
+func historyPage(r *http.Request) (int, int, error) {
+	query := r.URL.Query()
+	var limit, offset int
+	var err error
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil {
+			return 0, 0, err
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil {
+			return 0, 0, err
+		}
+	}
+	return limit, offset, nil
+}
+
+func writeJSONBody(w http.ResponseWriter, r *http.Request, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		util.Log(r.Context()).WithError(err).With("path", r.URL.Path).Error("could not write response")
+	}
+}
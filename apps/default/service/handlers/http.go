@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/util"
+	money "google.golang.org/genproto/googleapis/type/money"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+)
+
+// maxJSONBodyBytes bounds the JSON bodies the plain HTTP routes accept.
+const maxJSONBodyBytes = 1 << 20
+
+// readJSON decodes the request's JSON body into into, refusing fields it does
+// not know. A malformed body is an invalid argument.
+func readJSON(w http.ResponseWriter, r *http.Request, into any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(into); err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("invalid request body: %w", err))
+	}
+	if decoder.More() {
+		return connect.NewError(connect.CodeInvalidArgument, errors.New("invalid request body: trailing data"))
+	}
+	return nil
+}
+
+// parsePrice reads a price given as a currency and a decimal amount such as
+// "12.50". A malformed or negative price is an invalid argument.
+func parsePrice(currency, amount string) (*money.Money, error) {
+	units, nanos, err := business.ParseDecimalAmount(amount)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("price: %w", err))
+	}
+	price := &money.Money{CurrencyCode: currency, Units: units, Nanos: nanos}
+	if err = business.ValidatePrice(price); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("price: %w", err))
+	}
+	return price, nil
+}
+
+// protoJSONList renders each message in its proto JSON form, for views that
+// embed API messages.
+func protoJSONList[T proto.Message](messages []T) ([]json.RawMessage, error) {
+	list := make([]json.RawMessage, 0, len(messages))
+	for _, message := range messages {
+		body, err := protojson.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, body)
+	}
+	return list, nil
+}
+
+// writeJSON answers with view encoded as JSON.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, view any) {
+	body, err := json.Marshal(view)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSONBody(w, r, status, body)
+}
+
+// writeHTTPError answers a plain HTTP request that failed with err, logging
+// the cause and sending only the status.
+func writeHTTPError(w http.ResponseWriter, r *http.Request, err error) {
+	status := httpStatus(connect.CodeOf(err))
+	util.Log(r.Context()).WithError(err).With("path", r.URL.Path).Warn("http request failed")
+	http.Error(w, http.StatusText(status), status)
+}
+
+// httpStatus maps a business error code to an HTTP status.
+func httpStatus(code connect.Code) int {
+	switch code {
+	case connect.CodeInvalidArgument:
+		return http.StatusBadRequest
+	case connect.CodeUnauthenticated:
+		return http.StatusUnauthorized
+	case connect.CodePermissionDenied:
+		return http.StatusForbidden
+	case connect.CodeNotFound:
+		return http.StatusNotFound
+	case connect.CodeFailedPrecondition, connect.CodeAlreadyExists, connect.CodeAborted:
+		return http.StatusConflict
+	case connect.CodeUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
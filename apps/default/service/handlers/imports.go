@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/pitabwire/util"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+// Catalog import routes. The upload body is the CSV or JSON file; its format
+// is named by the format query parameter or else by the Content-Type. The
+// upload answers 202 with the queued import, whose progress and row errors
+// are then read from the status route.
+const (
+	StartCatalogImportPattern  = "POST /catalog/shops/{shop_id}/imports"
+	CatalogImportStatusPattern = "GET /catalog/imports/{import_id}"
+)
+
+// catalogImportView is the JSON form of a catalog import.
+type catalogImportView struct {
+	ID              string                 `json:"id"`
+	ShopID          string                 `json:"shop_id"`
+	Format          string                 `json:"format"`
+	Status          string                 `json:"status"`
+	TotalRows       int64                  `json:"total_rows"`
+	ProcessedRows   int64                  `json:"processed_rows"`
+	CreatedProducts int64                  `json:"created_products"`
+	UpdatedProducts int64                  `json:"updated_products"`
+	CreatedVariants int64                  `json:"created_variants"`
+	UpdatedVariants int64                  `json:"updated_variants"`
+	FailedRows      int64                  `json:"failed_rows"`
+	Errors          models.ImportRowErrors `json:"errors"`
+	Message         string                 `json:"message,omitempty"`
+	CreatedAt       time.Time              `json:"created_at"`
+	StartedAt       *time.Time             `json:"started_at,omitempty"`
+	FinishedAt      *time.Time             `json:"finished_at,omitempty"`
+}
+
+func (cs *CommerceServer) StartCatalogImport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	shopID := r.PathValue("shop_id")
+
+	if err := cs.authzBusiness.AuthorizeShop(ctx, shopID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, business.MaxImportBytes))
+	if err != nil {
+		http.Error(w, "could not read body", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	job, err := cs.importBusiness.StartImport(ctx, shopID, importFormat(r), body)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeCatalogImport(w, r, http.StatusAccepted, job)
+}
+
+func (cs *CommerceServer) CatalogImportStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	job, err := cs.importBusiness.GetImport(ctx, r.PathValue("import_id"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	if err = cs.authzBusiness.AuthorizeShop(ctx, job.ShopID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeCatalogImport(w, r, http.StatusOK, job)
+}
+
+// importFormat names the upload's format from the query or the Content-Type.
+func importFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return business.ImportFormatCSV
+	case "application/json":
+		return business.ImportFormatJSON
+	}
+	return ""
+}
+
+func writeCatalogImport(w http.ResponseWriter, r *http.Request, status int, job *models.CatalogImport) {
+	view := catalogImportView{
+		ID:              job.GetID(),
+		ShopID:          job.ShopID,
+		Format:          job.Format,
+		Status:          importStatusName(job.Status),
+		TotalRows:       job.TotalRows,
+		ProcessedRows:   job.ProcessedRows,
+		CreatedProducts: job.CreatedProducts,
+		UpdatedProducts: job.UpdatedProducts,
+		CreatedVariants: job.CreatedVariants,
+		UpdatedVariants: job.UpdatedVariants,
+		FailedRows:      job.FailedRows,
+		Errors:          job.Errors,
+		Message:         job.Message,
+		CreatedAt:       job.CreatedAt,
+		StartedAt:       job.StartedAt,
+		FinishedAt:      job.FinishedAt,
+	}
+	if view.Errors == nil {
+		view.Errors = models.ImportRowErrors{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(view); err != nil {
+		util.Log(r.Context()).WithError(err).With("path", r.URL.Path).Error("could not write catalog import")
+	}
+}
+
+func importStatusName(status int32) string {
+	switch status {
+	case models.ImportStatusQueued:
+		return "queued"
+	case models.ImportStatusRunning:
+		return "running"
+	case models.ImportStatusCompleted:
+		return "completed"
+	case models.ImportStatusFailed:
+		return "failed"
+	}
+	return "unknown"
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+)
+
+// SetVariantLocationPattern records where a variant is kept in the warehouse,
+// given as {"location": ...}, which pick lists are grouped and ordered by. An
+// empty location clears it. The route answers with no content.
+const SetVariantLocationPattern = "PUT /catalog/variants/{variant_id}/location"
+
+type setVariantLocationBody struct {
+	Location string `json:"location"`
+}
+
+func (cs *CommerceServer) SetVariantLocation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	variantID := r.PathValue("variant_id")
+
+	if err := cs.authzBusiness.AuthorizeVariant(ctx, variantID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body setVariantLocationBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	if err := cs.catalogBusiness.SetVariantLocation(ctx, variantID, body.Location); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
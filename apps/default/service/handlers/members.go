@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+// Shop membership routes. Setting a member takes {"role": ...} and grants
+// or changes the profile's role; removing one revokes it. A shop always keeps
+// an owner, so its last owner can be neither removed nor demoted.
+const (
+	ListShopMembersPattern  = "GET /shops/{shop_id}/members"
+	SetShopMemberPattern    = "PUT /shops/{shop_id}/members/{profile_id}"
+	RemoveShopMemberPattern = "DELETE /shops/{shop_id}/members/{profile_id}"
+)
+
+// shopMemberView is the JSON form of a shop member.
+type shopMemberView struct {
+	ShopID    string    `json:"shop_id"`
+	ProfileID string    `json:"profile_id"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type shopMembersView struct {
+	Members []shopMemberView `json:"members"`
+}
+
+type setShopMemberBody struct {
+	Role string `json:"role"`
+}
+
+func (cs *CommerceServer) ListShopMembers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	shopID := r.PathValue("shop_id")
+
+	if err := cs.authzBusiness.AuthorizeShop(ctx, shopID, business.PermissionMembersManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	members, err := cs.shopBusiness.ListShopMembers(ctx, shopID)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := shopMembersView{Members: make([]shopMemberView, 0, len(members))}
+	for _, member := range members {
+		view.Members = append(view.Members, newShopMemberView(member))
+	}
+	writeJSON(w, r, http.StatusOK, view)
+}
+
+func (cs *CommerceServer) SetShopMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	shopID := r.PathValue("shop_id")
+
+	if err := cs.authzBusiness.AuthorizeShop(ctx, shopID, business.PermissionMembersManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body setShopMemberBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	member, err := cs.shopBusiness.AddShopMember(ctx, shopID, r.PathValue("profile_id"), body.Role)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, newShopMemberView(member))
+}
+
+func (cs *CommerceServer) RemoveShopMember(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	shopID := r.PathValue("shop_id")
+
+	if err := cs.authzBusiness.AuthorizeShop(ctx, shopID, business.PermissionMembersManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	if err := cs.shopBusiness.RemoveShopMember(ctx, shopID, r.PathValue("profile_id")); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newShopMemberView(member *models.ShopMember) shopMemberView {
+	return shopMemberView{
+		ShopID:    member.ShopID,
+		ProfileID: member.ProfileID,
+		Role:      member.Role,
+		CreatedAt: member.CreatedAt,
+	}
+}
@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"connectrpc.com/connect"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+// Category and collection routes. Categories and collections are created in
+// a shop and listed from it; a category's products take include_descendants
+// to list its subcategories' products too, and both product listings take
+// limit and offset. A product's categories and a manual collection's
+// products are replaced as a whole.
+const (
+	ListCategoriesPattern          = "GET /catalog/shops/{shop_id}/categories"
+	CreateCategoryPattern          = "POST /catalog/shops/{shop_id}/categories"
+	UpdateCategoryPattern          = "PUT /catalog/categories/{category_id}"
+	CategoryProductsPattern        = "GET /catalog/categories/{category_id}/products"
+	AssignProductCategoriesPattern = "PUT /catalog/products/{product_id}/categories"
+	ListCollectionsPattern         = "GET /catalog/shops/{shop_id}/collections"
+	CreateCollectionPattern        = "POST /catalog/shops/{shop_id}/collections"
+	CollectionProductsPattern      = "GET /catalog/collections/{collection_id}/products"
+	SetCollectionProductsPattern   = "PUT /catalog/collections/{collection_id}/products"
+)
+
+// categoryView is the JSON form of a category.
+type categoryView struct {
+	ID          string    `json:"id"`
+	ShopID      string    `json:"shop_id"`
+	ParentID    string    `json:"parent_id,omitempty"`
+	Name        string    `json:"name"`
+	Slug        string    `json:"slug"`
+	Description string    `json:"description,omitempty"`
+	Position    int32     `json:"position"`
+	MediaIDs    []string  `json:"media_ids"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type categoriesView struct {
+	Categories []categoryView `json:"categories"`
+}
+
+// collectionView is the JSON form of a collection.
+type collectionView struct {
+	ID          string                  `json:"id"`
+	ShopID      string                  `json:"shop_id"`
+	Name        string                  `json:"name"`
+	Slug        string                  `json:"slug"`
+	Description string                  `json:"description,omitempty"`
+	Type        string                  `json:"type"`
+	Rules       []models.CollectionRule `json:"rules"`
+	MatchAny    bool                    `json:"match_any"`
+	MediaIDs    []string                `json:"media_ids"`
+	CreatedAt   time.Time               `json:"created_at"`
+}
+
+type collectionsView struct {
+	Collections []collectionView `json:"collections"`
+}
+
+// productsView lists products in their proto JSON form.
+type productsView struct {
+	Products []json.RawMessage `json:"products"`
+}
+
+type categoryBody struct {
+	ParentID    string   `json:"parent_id"`
+	Name        string   `json:"name"`
+	Slug        string   `json:"slug"`
+	Description string   `json:"description"`
+	Position    int32    `json:"position"`
+	MediaIDs    []string `json:"media_ids"`
+}
+
+type collectionBody struct {
+	Name        string                  `json:"name"`
+	Slug        string                  `json:"slug"`
+	Description string                  `json:"description"`
+	Type        string                  `json:"type"`
+	Rules       []models.CollectionRule `json:"rules"`
+	MatchAny    bool                    `json:"match_any"`
+	MediaIDs    []string                `json:"media_ids"`
+}
+
+type productCategoriesBody struct {
+	CategoryIDs []string `json:"category_ids"`
+}
+
+type collectionProductsBody struct {
+	ProductIDs []string `json:"product_ids"`
+}
+
+func (cs *CommerceServer) ListCategories(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	categories, err := cs.navigationBusiness.ListCategories(ctx, r.PathValue("shop_id"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := categoriesView{Categories: make([]categoryView, 0, len(categories))}
+	for _, category := range categories {
+		view.Categories = append(view.Categories, newCategoryView(category))
+	}
+	writeJSON(w, r, http.StatusOK, view)
+}
+
+func (cs *CommerceServer) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	shopID := r.PathValue("shop_id")
+
+	if err := cs.authzBusiness.AuthorizeShop(ctx, shopID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body categoryBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	category, err := cs.navigationBusiness.CreateCategory(ctx, shopID, body.input())
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, newCategoryView(category))
+}
+
+func (cs *CommerceServer) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	category, err := cs.navigationBusiness.GetCategory(ctx, r.PathValue("category_id"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	if err = cs.authzBusiness.AuthorizeShop(ctx, category.ShopID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body categoryBody
+	if err = readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	category, err = cs.navigationBusiness.UpdateCategory(ctx, category.GetID(), body.input())
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, newCategoryView(category))
+}
+
+func (cs *CommerceServer) CategoryProducts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	limit, offset, err := historyPage(r)
+	if err != nil {
+		writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	includeDescendants := false
+	if value := r.URL.Query().Get("include_descendants"); value != "" {
+		if includeDescendants, err = strconv.ParseBool(value); err != nil {
+			writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, err))
+			return
+		}
+	}
+
+	products, err := cs.navigationBusiness.ListProductsByCategory(
+		ctx, r.PathValue("category_id"), includeDescendants, limit, offset)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeProducts(w, r, products)
+}
+
+func (cs *CommerceServer) AssignProductCategories(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	productID := r.PathValue("product_id")
+
+	if err := cs.authzBusiness.AuthorizeProduct(ctx, productID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body productCategoriesBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	if err := cs.navigationBusiness.AssignProductCategories(ctx, productID, body.CategoryIDs); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs *CommerceServer) ListCollections(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	collections, err := cs.navigationBusiness.ListCollections(ctx, r.PathValue("shop_id"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := collectionsView{Collections: make([]collectionView, 0, len(collections))}
+	for _, collection := range collections {
+		view.Collections = append(view.Collections, newCollectionView(collection))
+	}
+	writeJSON(w, r, http.StatusOK, view)
+}
+
+func (cs *CommerceServer) CreateCollection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	shopID := r.PathValue("shop_id")
+
+	if err := cs.authzBusiness.AuthorizeShop(ctx, shopID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body collectionBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	input, err := body.input()
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	collection, err := cs.navigationBusiness.CreateCollection(ctx, shopID, input)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, newCollectionView(collection))
+}
+
+func (cs *CommerceServer) CollectionProducts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	limit, offset, err := historyPage(r)
+	if err != nil {
+		writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+
+	products, err := cs.navigationBusiness.ListProductsByCollection(ctx, r.PathValue("collection_id"), limit, offset)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeProducts(w, r, products)
+}
+
+func (cs *CommerceServer) SetCollectionProducts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	collection, err := cs.navigationBusiness.GetCollection(ctx, r.PathValue("collection_id"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	if err = cs.authzBusiness.AuthorizeShop(ctx, collection.ShopID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body collectionProductsBody
+	if err = readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	if err = cs.navigationBusiness.SetCollectionProducts(ctx, collection.GetID(), body.ProductIDs); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b categoryBody) input() business.CategoryInput {
+	return business.CategoryInput{
+		ParentID:    b.ParentID,
+		Name:        b.Name,
+		Slug:        b.Slug,
+		Description: b.Description,
+		Position:    b.Position,
+		MediaIDs:    b.MediaIDs,
+	}
+}
+
+func (b collectionBody) input() (business.CollectionInput, error) {
+	input := business.CollectionInput{
+		Name:        b.Name,
+		Slug:        b.Slug,
+		Description: b.Description,
+		Rules:       b.Rules,
+		MatchAny:    b.MatchAny,
+		MediaIDs:    b.MediaIDs,
+	}
+	switch b.Type {
+	case "", "manual":
+		input.Type = business.CollectionTypeManual
+	case "rules":
+		input.Type = business.CollectionTypeRules
+	default:
+		return input, connect.NewError(connect.CodeInvalidArgument, errors.New("type must be manual or rules"))
+	}
+	return input, nil
+}
+
+func writeProducts(w http.ResponseWriter, r *http.Request, products []*commercev1.Product) {
+	list, err := protoJSONList(products)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, productsView{Products: list})
+}
+
+func newCategoryView(category *models.Category) categoryView {
+	return categoryView{
+		ID:          category.GetID(),
+		ShopID:      category.ShopID,
+		ParentID:    category.ParentID,
+		Name:        category.Name,
+		Slug:        category.Slug,
+		Description: category.Description,
+		Position:    category.Position,
+		MediaIDs:    nonNilStrings(category.MediaIDs),
+		CreatedAt:   category.CreatedAt,
+	}
+}
+
+func newCollectionView(collection *models.Collection) collectionView {
+	view := collectionView{
+		ID:          collection.GetID(),
+		ShopID:      collection.ShopID,
+		Name:        collection.Name,
+		Slug:        collection.Slug,
+		Description: collection.Description,
+		Type:        "manual",
+		Rules:       collection.Rules,
+		MatchAny:    collection.MatchAny,
+		MediaIDs:    nonNilStrings(collection.MediaIDs),
+		CreatedAt:   collection.CreatedAt,
+	}
+	if collection.Type == business.CollectionTypeRules {
+		view.Type = "rules"
+	}
+	if view.Rules == nil {
+		view.Rules = []models.CollectionRule{}
+	}
+	return view
+}
+
+// nonNilStrings keeps an empty list an empty JSON array.
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+// Product option routes. Setting the options takes the full list of options
+// and their values. Adding a value, removing one and generating the matrix
+// answer with the variants created, reactivated and disabled; adding a value
+// and generating take the price and stock of the variants they create.
+const (
+	ListProductOptionsPattern       = "GET /catalog/products/{product_id}/options"
+	SetProductOptionsPattern        = "PUT /catalog/products/{product_id}/options"
+	AddProductOptionValuePattern    = "POST /catalog/products/{product_id}/options/{option}/values"
+	RemoveProductOptionValuePattern = "DELETE /catalog/products/{product_id}/options/{option}/values/{value}"
+	GenerateVariantMatrixPattern    = "POST /catalog/products/{product_id}/variant-matrix"
+)
+
+// productOptionView is the JSON form of a product option.
+type productOptionView struct {
+	ID        string    `json:"id"`
+	ProductID string    `json:"product_id"`
+	Name      string    `json:"name"`
+	Position  int32     `json:"position"`
+	Values    []string  `json:"values"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type productOptionsView struct {
+	Options []productOptionView `json:"options"`
+}
+
+// variantMatrixView is the JSON form of a matrix reconciliation. The
+// variants are in their proto JSON form.
+type variantMatrixView struct {
+	Created     []json.RawMessage `json:"created"`
+	Reactivated []json.RawMessage `json:"reactivated"`
+	Disabled    []json.RawMessage `json:"disabled"`
+}
+
+type productOptionBody struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+type setProductOptionsBody struct {
+	Options []productOptionBody `json:"options"`
+}
+
+// variantDefaultsBody is the price and stock of the variants a request creates.
+type variantDefaultsBody struct {
+	Currency      string `json:"currency"`
+	Price         string `json:"price"`
+	StockQuantity int64  `json:"stock_quantity"`
+}
+
+type addProductOptionValueBody struct {
+	Value string `json:"value"`
+	variantDefaultsBody
+}
+
+func (cs *CommerceServer) ListProductOptions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	options, err := cs.catalogBusiness.ListProductOptions(ctx, r.PathValue("product_id"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeProductOptions(w, r, options)
+}
+
+func (cs *CommerceServer) SetProductOptions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	productID := r.PathValue("product_id")
+
+	if err := cs.authzBusiness.AuthorizeProduct(ctx, productID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body setProductOptionsBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	inputs := make([]business.ProductOptionInput, 0, len(body.Options))
+	for _, option := range body.Options {
+		inputs = append(inputs, business.ProductOptionInput{Name: option.Name, Values: option.Values})
+	}
+
+	options, err := cs.catalogBusiness.SetProductOptions(ctx, productID, inputs)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeProductOptions(w, r, options)
+}
+
+func (cs *CommerceServer) AddProductOptionValue(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	productID := r.PathValue("product_id")
+
+	if err := cs.authzBusiness.AuthorizeProduct(ctx, productID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body addProductOptionValueBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	defaults, err := body.defaults()
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	result, err := cs.catalogBusiness.AddProductOptionValue(ctx, productID, r.PathValue("option"), body.Value, defaults)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeVariantMatrix(w, r, result)
+}
+
+func (cs *CommerceServer) RemoveProductOptionValue(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	productID := r.PathValue("product_id")
+
+	if err := cs.authzBusiness.AuthorizeProduct(ctx, productID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	result, err := cs.catalogBusiness.RemoveProductOptionValue(
+		ctx, productID, r.PathValue("option"), r.PathValue("value"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeVariantMatrix(w, r, result)
+}
+
+func (cs *CommerceServer) GenerateVariantMatrix(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	productID := r.PathValue("product_id")
+
+	if err := cs.authzBusiness.AuthorizeProduct(ctx, productID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body variantDefaultsBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	defaults, err := body.defaults()
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	result, err := cs.catalogBusiness.GenerateVariantMatrix(ctx, productID, defaults)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeVariantMatrix(w, r, result)
+}
+
+func (b variantDefaultsBody) defaults() (business.VariantDefaults, error) {
+	price, err := parsePrice(b.Currency, b.Price)
+	if err != nil {
+		return business.VariantDefaults{}, err
+	}
+	return business.VariantDefaults{Price: price, StockQuantity: b.StockQuantity}, nil
+}
+
+func writeProductOptions(w http.ResponseWriter, r *http.Request, options []*models.ProductOption) {
+	view := productOptionsView{Options: make([]productOptionView, 0, len(options))}
+	for _, option := range options {
+		view.Options = append(view.Options, productOptionView{
+			ID:        option.GetID(),
+			ProductID: option.ProductID,
+			Name:      option.Name,
+			Position:  option.Position,
+			Values:    option.Values,
+			CreatedAt: option.CreatedAt,
+		})
+	}
+	writeJSON(w, r, http.StatusOK, view)
+}
+
+func writeVariantMatrix(w http.ResponseWriter, r *http.Request, result *business.VariantMatrixResult) {
+	var view variantMatrixView
+	var err error
+	if view.Created, err = protoJSONList(result.Created); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	if view.Reactivated, err = protoJSONList(result.Reactivated); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	if view.Disabled, err = protoJSONList(result.Disabled); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, view)
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+)
+
+// CancelOrderPattern cancels an order that is neither paid nor shipped,
+// giving back the stock it took and the booking places it held. The route
+// answers with the cancelled order.
+const CancelOrderPattern = "POST /orders/{order_id}/cancel"
+
+func (cs *CommerceServer) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	orderID := r.PathValue("order_id")
+
+	if err := cs.authzBusiness.AuthorizeOrder(ctx, orderID, business.PermissionOrdersManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	order, err := cs.orderBusiness.CancelOrder(ctx, orderID)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	if _, err = cs.bookingBusiness.CancelOrderBookings(ctx, orderID); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeOrder(w, r, order)
+}
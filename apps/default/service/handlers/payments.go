@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Payment routes, where the payment service reports an order paid or
+// refunded. Only internal callers may use them; they answer with the order,
+// and a capture also with the download tokens of the digital lines it
+// delivered, for the payment service to pass on to the buyer.
+const (
+	CapturePaymentPattern = "POST /payments/orders/{order_id}/capture"
+	RefundPaymentPattern  = "POST /payments/orders/{order_id}/refund"
+)
+
+// captureView is the JSON form of a captured payment.
+type captureView struct {
+	Order     json.RawMessage     `json:"order"`
+	Downloads []downloadTokenView `json:"downloads"`
+}
+
+func (cs *CommerceServer) CapturePayment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := cs.authzBusiness.AuthorizeInternal(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	capture, err := cs.paymentBusiness.CapturePayment(ctx, r.PathValue("order_id"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	order, err := protojson.Marshal(capture.Order)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := captureView{Order: order, Downloads: make([]downloadTokenView, 0, len(capture.Downloads))}
+	for _, token := range capture.Downloads {
+		view.Downloads = append(view.Downloads, newDownloadTokenView(token))
+	}
+	writeJSON(w, r, http.StatusOK, view)
+}
+
+func (cs *CommerceServer) RefundPayment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := cs.authzBusiness.AuthorizeInternal(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	order, err := cs.paymentBusiness.RefundPayment(ctx, r.PathValue("order_id"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeOrder(w, r, order)
+}
+
+func writeOrder(w http.ResponseWriter, r *http.Request, order *commercev1.Order) {
+	body, err := protojson.Marshal(order)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSONBody(w, r, http.StatusOK, body)
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/pitabwire/util"
+	money "google.golang.org/genproto/googleapis/type/money"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+)
+
+// Sales report routes. Each takes from and to, as dates in the shop's time
+// zone with both included, defaulting to the last 30 days. The sales report
+// also takes period, day (the default), week or month; the product and
+// variant reports take limit and the variant report offset.
+const (
+	SalesReportPattern   = "GET /reports/shops/{shop_id}/sales"
+	ProductReportPattern = "GET /reports/shops/{shop_id}/products"
+	VariantReportPattern = "GET /reports/shops/{shop_id}/variants"
+)
+
+const (
+	defaultReportLimit = 20
+	maxReportLimit     = 500
+)
+
+// salesPeriodView is the JSON form of a period of a sales report. Amounts are
+// decimals in the currency's units.
+type salesPeriodView struct {
+	Start             string  `json:"start"`
+	Currency          string  `json:"currency"`
+	OrderCount        int64   `json:"order_count"`
+	CancelledCount    int64   `json:"cancelled_count"`
+	RefundedCount     int64   `json:"refunded_count"`
+	UnitsSold         int64   `json:"units_sold"`
+	Gross             string  `json:"gross"`
+	Refunded          string  `json:"refunded"`
+	Net               string  `json:"net"`
+	AverageOrderValue string  `json:"average_order_value"`
+	CancellationRate  float64 `json:"cancellation_rate"`
+	RefundRate        float64 `json:"refund_rate"`
+}
+
+// productSalesView is the JSON form of a product's or variant's sales.
+type productSalesView struct {
+	ProductID        string `json:"product_id"`
+	ProductName      string `json:"product_name"`
+	ProductVariantID string `json:"product_variant_id,omitempty"`
+	SKU              string `json:"sku,omitempty"`
+	VariantName      string `json:"variant_name,omitempty"`
+	Currency         string `json:"currency"`
+	UnitsSold        int64  `json:"units_sold"`
+	Revenue          string `json:"revenue"`
+}
+
+func (cs *CommerceServer) SalesReport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	shopID := r.PathValue("shop_id")
+
+	period, err := business.ParseReportPeriod(r.URL.Query().Get("period"))
+	if err != nil {
+		writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	from, to, err := reportRange(r)
+	if err != nil {
+		writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	if err = cs.authzBusiness.AuthorizeShop(ctx, shopID, business.PermissionOrdersView); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	periods, err := cs.analyticsBusiness.SalesReport(ctx, shopID, period, from, to)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	views := make([]salesPeriodView, 0, len(periods))
+	for _, p := range periods {
+		views = append(views, salesPeriodView{
+			Start:             p.Start.Format(time.DateOnly),
+			Currency:          p.Currency,
+			OrderCount:        p.OrderCount,
+			CancelledCount:    p.CancelledCount,
+			RefundedCount:     p.RefundedCount,
+			UnitsSold:         p.UnitsSold,
+			Gross:             moneyAmount(p.Gross),
+			Refunded:          moneyAmount(p.Refunded),
+			Net:               moneyAmount(p.Net),
+			AverageOrderValue: moneyAmount(p.AverageOrderValue),
+			CancellationRate:  p.CancellationRate,
+			RefundRate:        p.RefundRate,
+		})
+	}
+	writeReport(w, r, views)
+}
+
+func (cs *CommerceServer) ProductReport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	shopID := r.PathValue("shop_id")
+
+	from, to, err := reportRange(r)
+	if err != nil {
+		writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	limit, _, err := reportPage(r)
+	if err != nil {
+		writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	if err = cs.authzBusiness.AuthorizeShop(ctx, shopID, business.PermissionOrdersView); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	sales, err := cs.analyticsBusiness.TopProducts(ctx, shopID, from, to, limit)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	views := make([]productSalesView, 0, len(sales))
+	for _, s := range sales {
+		views = append(views, productSalesView{
+			ProductID:   s.ProductID,
+			ProductName: s.ProductName,
+			Currency:    s.Currency,
+			UnitsSold:   s.UnitsSold,
+			Revenue:     business.DecimalAmount(s.RevenueUnits, int32(s.RevenueNanos)),
+		})
+	}
+	writeReport(w, r, views)
+}
+
+func (cs *CommerceServer) VariantReport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	shopID := r.PathValue("shop_id")
+
+	from, to, err := reportRange(r)
+	if err != nil {
+		writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	limit, offset, err := reportPage(r)
+	if err != nil {
+		writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, err))
+		return
+	}
+	if err = cs.authzBusiness.AuthorizeShop(ctx, shopID, business.PermissionOrdersView); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	sales, err := cs.analyticsBusiness.VariantSales(ctx, shopID, from, to, limit, offset)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	views := make([]productSalesView, 0, len(sales))
+	for _, s := range sales {
+		views = append(views, productSalesView{
+			ProductID:        s.ProductID,
+			ProductName:      s.ProductName,
+			ProductVariantID: s.ProductVariantID,
+			SKU:              s.SKU,
+			VariantName:      s.VariantName,
+			Currency:         s.Currency,
+			UnitsSold:        s.UnitsSold,
+			Revenue:          business.DecimalAmount(s.RevenueUnits, int32(s.RevenueNanos)),
+		})
+	}
+	writeReport(w, r, views)
+}
+
+// reportRange reads the from and to dates; those not given are zero.
+func reportRange(r *http.Request) (time.Time, time.Time, error) {
+	query := r.URL.Query()
+	var from, to time.Time
+	var err error
+	if value := query.Get("from"); value != "" {
+		if from, err = time.Parse(time.DateOnly, value); err != nil {
+			return from, to, fmt.Errorf("from: %w", err)
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if to, err = time.Parse(time.DateOnly, value); err != nil {
+			return from, to, fmt.Errorf("to: %w", err)
+		}
+	}
+	return from, to, nil
+}
+
+// reportPage reads the limit and offset.
+func reportPage(r *http.Request) (int, int, error) {
+	query := r.URL.Query()
+	limit, offset := defaultReportLimit, 0
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxReportLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxReportLimit)
+		}
+		limit = n
+	}
+	if value := query.Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("offset must not be negative")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+func moneyAmount(m *money.Money) string {
+	return business.DecimalAmount(m.GetUnits(), m.GetNanos())
+}
+
+func writeReport(w http.ResponseWriter, r *http.Request, view any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(view); err != nil {
+		util.Log(r.Context()).WithError(err).With("path", r.URL.Path).Error("could not write report")
+	}
+}
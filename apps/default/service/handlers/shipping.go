@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+)
+
+// Shipping routes. Quotes name the carrier in the carrier query parameter.
+// Buying a label takes the carrier and
+// service quoted and answers with the label, its content base64 encoded.
+// Tracking is read as recorded, or synced from the carrier first.
+const (
+	ShippingQuotesPattern = "GET /fulfilments/{fulfilment_id}/shipping/quotes"
+	BuyLabelPattern       = "POST /fulfilments/{fulfilment_id}/shipping/label"
+	TrackingPattern       = "GET /fulfilments/{fulfilment_id}/shipping/tracking"
+	SyncTrackingPattern   = "POST /fulfilments/{fulfilment_id}/shipping/tracking/sync"
+)
+
+// rateQuoteView is the JSON form of a carrier's rate quote.
+type rateQuoteView struct {
+	Carrier       string `json:"carrier"`
+	Service       string `json:"service"`
+	Currency      string `json:"currency"`
+	Amount        string `json:"amount"`
+	EstimatedDays int32  `json:"estimated_days"`
+}
+
+type rateQuotesView struct {
+	Quotes []rateQuoteView `json:"quotes"`
+}
+
+// shippingLabelView is the JSON form of a bought label.
+type shippingLabelView struct {
+	TrackingNumber string `json:"tracking_number"`
+	Service        string `json:"service"`
+	ContentType    string `json:"content_type"`
+	Content        []byte `json:"content"`
+}
+
+// trackingEventView is the JSON form of a recorded tracking event.
+type trackingEventView struct {
+	Carrier        string    `json:"carrier"`
+	EventID        string    `json:"event_id"`
+	TrackingNumber string    `json:"tracking_number"`
+	Status         string    `json:"status"`
+	Description    string    `json:"description,omitempty"`
+	Location       string    `json:"location,omitempty"`
+	OccurredAt     time.Time `json:"occurred_at"`
+}
+
+type trackingView struct {
+	Events []trackingEventView `json:"events"`
+}
+
+type syncedTrackingView struct {
+	Recorded int `json:"recorded"`
+}
+
+type buyLabelBody struct {
+	Carrier string `json:"carrier"`
+	Service string `json:"service"`
+}
+
+func (cs *CommerceServer) ShippingQuotes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	fulfilmentID := r.PathValue("fulfilment_id")
+
+	if err := cs.authzBusiness.AuthorizeFulfilment(ctx, fulfilmentID, business.PermissionFulfilmentManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	quotes, err := cs.shippingBusiness.QuoteShipping(ctx, fulfilmentID, r.URL.Query().Get("carrier"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := rateQuotesView{Quotes: make([]rateQuoteView, 0, len(quotes))}
+	for _, quote := range quotes {
+		view.Quotes = append(view.Quotes, rateQuoteView{
+			Carrier:       quote.Carrier,
+			Service:       quote.Service,
+			Currency:      quote.Amount.GetCurrencyCode(),
+			Amount:        moneyAmount(quote.Amount),
+			EstimatedDays: quote.EstimatedDays,
+		})
+	}
+	writeJSON(w, r, http.StatusOK, view)
+}
+
+func (cs *CommerceServer) BuyLabel(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	fulfilmentID := r.PathValue("fulfilment_id")
+
+	if err := cs.authzBusiness.AuthorizeFulfilment(ctx, fulfilmentID, business.PermissionFulfilmentManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body buyLabelBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	label, err := cs.shippingBusiness.BuyLabel(ctx, fulfilmentID, body.Carrier, body.Service)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, shippingLabelView{
+		TrackingNumber: label.TrackingNumber,
+		Service:        label.Service,
+		ContentType:    label.ContentType,
+		Content:        label.Content,
+	})
+}
+
+func (cs *CommerceServer) Tracking(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	fulfilmentID := r.PathValue("fulfilment_id")
+
+	if err := cs.authzBusiness.AuthorizeFulfilment(ctx, fulfilmentID, business.PermissionFulfilmentView); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	events, err := cs.shippingBusiness.ListTrackingEvents(ctx, fulfilmentID)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := trackingView{Events: make([]trackingEventView, 0, len(events))}
+	for _, event := range events {
+		view.Events = append(view.Events, trackingEventView{
+			Carrier:        event.Carrier,
+			EventID:        event.EventID,
+			TrackingNumber: event.TrackingNumber,
+			Status:         commercev1.FulfilmentStatus(event.Status).String(),
+			Description:    event.Description,
+			Location:       event.Location,
+			OccurredAt:     event.OccurredAt,
+		})
+	}
+	writeJSON(w, r, http.StatusOK, view)
+}
+
+func (cs *CommerceServer) SyncTracking(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	fulfilmentID := r.PathValue("fulfilment_id")
+
+	if err := cs.authzBusiness.AuthorizeFulfilment(ctx, fulfilmentID, business.PermissionFulfilmentManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	recorded, err := cs.shippingBusiness.SyncTracking(ctx, fulfilmentID)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, syncedTrackingView{Recorded: recorded})
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+)
+
+// Slug routes. A lookup by a retired slug still finds the shop or product,
+// with redirected set so the caller moves to the current slug. Renaming a
+// product moves its slug with the name; either change keeps the old slug as
+// a redirect.
+const (
+	ShopBySlugPattern        = "GET /slugs/shops/{slug}"
+	ChangeShopSlugPattern    = "PUT /shops/{shop_id}/slug"
+	ProductBySlugPattern     = "GET /slugs/shops/{shop_id}/products/{slug}"
+	RenameProductPattern     = "PUT /catalog/products/{product_id}/name"
+	ChangeProductSlugPattern = "PUT /catalog/products/{product_id}/slug"
+)
+
+// shopSlugView is the JSON form of a shop slug lookup. The shop is in its
+// proto JSON form.
+type shopSlugView struct {
+	Shop       json.RawMessage `json:"shop"`
+	Slug       string          `json:"slug"`
+	Redirected bool            `json:"redirected"`
+}
+
+// productSlugView is the JSON form of a product slug lookup or change. The
+// product is in its proto JSON form.
+type productSlugView struct {
+	Product    json.RawMessage `json:"product"`
+	Slug       string          `json:"slug"`
+	Redirected bool            `json:"redirected"`
+}
+
+type slugBody struct {
+	Slug string `json:"slug"`
+}
+
+type renameBody struct {
+	Name string `json:"name"`
+}
+
+func (cs *CommerceServer) ShopBySlug(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	match, err := cs.shopBusiness.GetShopBySlug(ctx, r.PathValue("slug"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	shop, err := protojson.Marshal(match.Shop)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, shopSlugView{Shop: shop, Slug: match.Shop.GetSlug(), Redirected: match.Redirected})
+}
+
+func (cs *CommerceServer) ChangeShopSlug(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	shopID := r.PathValue("shop_id")
+
+	if err := cs.authzBusiness.AuthorizeShop(ctx, shopID, business.PermissionShopManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body slugBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	shop, err := cs.shopBusiness.ChangeShopSlug(ctx, shopID, body.Slug)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view, err := protojson.Marshal(shop)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, shopSlugView{Shop: view, Slug: shop.GetSlug()})
+}
+
+func (cs *CommerceServer) ProductBySlug(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	match, err := cs.catalogBusiness.GetProductBySlug(ctx, r.PathValue("shop_id"), r.PathValue("slug"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeProductSlug(w, r, match)
+}
+
+func (cs *CommerceServer) RenameProduct(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	productID := r.PathValue("product_id")
+
+	if err := cs.authzBusiness.AuthorizeProduct(ctx, productID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body renameBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	match, err := cs.catalogBusiness.RenameProduct(ctx, productID, body.Name)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeProductSlug(w, r, match)
+}
+
+func (cs *CommerceServer) ChangeProductSlug(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	productID := r.PathValue("product_id")
+
+	if err := cs.authzBusiness.AuthorizeProduct(ctx, productID, business.PermissionCatalogManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body slugBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	match, err := cs.catalogBusiness.ChangeProductSlug(ctx, productID, body.Slug)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeProductSlug(w, r, match)
+}
+
+func writeProductSlug(w http.ResponseWriter, r *http.Request, match *business.ProductSlugMatch) {
+	product, err := protojson.Marshal(match.Product)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, productSlugView{Product: product, Slug: match.Slug, Redirected: match.Redirected})
+}
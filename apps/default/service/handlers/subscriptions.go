@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"connectrpc.com/connect"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+// Subscription routes. A subscription is taken out for the caller unless a
+// shop member names another profile. The caller's own subscriptions are
+// listed with limit and a page number. Pausing, resuming, skipping the next
+// run and cancelling each answer with the subscription as it now stands.
+const (
+	SubscribePattern          = "POST /subscriptions"
+	MySubscriptionsPattern    = "GET /subscriptions/mine"
+	GetSubscriptionPattern    = "GET /subscriptions/{subscription_id}"
+	PauseSubscriptionPattern  = "POST /subscriptions/{subscription_id}/pause"
+	ResumeSubscriptionPattern = "POST /subscriptions/{subscription_id}/resume"
+	SkipSubscriptionPattern   = "POST /subscriptions/{subscription_id}/skip"
+	CancelSubscriptionPattern = "POST /subscriptions/{subscription_id}/cancel"
+)
+
+// Price-lock rule names as the routes take and report them.
+const (
+	priceLockNone   = "none"
+	priceLockFixed  = "fixed"
+	priceLockCapped = "capped"
+)
+
+// subscriptionView is the JSON form of a subscription.
+type subscriptionView struct {
+	ID            string                 `json:"id"`
+	ShopID        string                 `json:"shop_id"`
+	ProfileID     string                 `json:"profile_id"`
+	ContactID     string                 `json:"contact_id,omitempty"`
+	AddressID     string                 `json:"address_id,omitempty"`
+	IntervalUnit  string                 `json:"interval_unit"`
+	IntervalCount int32                  `json:"interval_count"`
+	PriceLock     string                 `json:"price_lock"`
+	Status        string                 `json:"status"`
+	NextRunAt     time.Time              `json:"next_run_at"`
+	CycleCount    int64                  `json:"cycle_count"`
+	LastOrderID   string                 `json:"last_order_id,omitempty"`
+	Lines         []subscriptionLineView `json:"lines"`
+}
+
+type subscriptionLineView struct {
+	VariantID   string `json:"variant_id"`
+	Quantity    int64  `json:"quantity"`
+	Currency    string `json:"currency"`
+	LockedPrice string `json:"locked_price"`
+}
+
+type subscriptionsView struct {
+	Subscriptions []subscriptionView `json:"subscriptions"`
+}
+
+type subscriptionLineBody struct {
+	VariantID string `json:"variant_id"`
+	Quantity  int64  `json:"quantity"`
+}
+
+type subscribeBody struct {
+	ShopID        string                 `json:"shop_id"`
+	ProfileID     string                 `json:"profile_id"`
+	ContactID     string                 `json:"contact_id"`
+	AddressID     string                 `json:"address_id"`
+	IntervalUnit  string                 `json:"interval_unit"`
+	IntervalCount int32                  `json:"interval_count"`
+	PriceLock     string                 `json:"price_lock"`
+	StartAt       time.Time              `json:"start_at"`
+	Lines         []subscriptionLineBody `json:"lines"`
+}
+
+func (cs *CommerceServer) Subscribe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	callerID, err := cs.authzBusiness.Authenticated(ctx)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body subscribeBody
+	if err = readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	input, err := body.input()
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	if input.ProfileID == "" {
+		input.ProfileID = callerID
+	}
+	if err = cs.authzBusiness.AuthorizeProfile(
+		ctx, input.ShopID, input.ProfileID, business.PermissionOrdersManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	subscription, err := cs.subscriptionBusiness.Subscribe(ctx, input)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusCreated, newSubscriptionView(subscription))
+}
+
+func (cs *CommerceServer) MySubscriptions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	profileID, err := cs.authzBusiness.Authenticated(ctx)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+	var limit int64
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.ParseInt(value, 10, 32); err != nil {
+			writeHTTPError(w, r, connect.NewError(connect.CodeInvalidArgument, err))
+			return
+		}
+	}
+
+	subscriptions, err := cs.subscriptionBusiness.ListSubscriptions(ctx, profileID, int32(limit), query.Get("page"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := subscriptionsView{Subscriptions: make([]subscriptionView, 0, len(subscriptions))}
+	for _, subscription := range subscriptions {
+		view.Subscriptions = append(view.Subscriptions, newSubscriptionView(subscription))
+	}
+	writeJSON(w, r, http.StatusOK, view)
+}
+
+func (cs *CommerceServer) GetSubscription(w http.ResponseWriter, r *http.Request) {
+	subscription, err := cs.authorizedSubscription(r, business.PermissionOrdersView)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, newSubscriptionView(subscription))
+}
+
+func (cs *CommerceServer) PauseSubscription(w http.ResponseWriter, r *http.Request) {
+	cs.changeSubscription(w, r, cs.subscriptionBusiness.Pause)
+}
+
+func (cs *CommerceServer) ResumeSubscription(w http.ResponseWriter, r *http.Request) {
+	cs.changeSubscription(w, r, cs.subscriptionBusiness.Resume)
+}
+
+func (cs *CommerceServer) SkipSubscription(w http.ResponseWriter, r *http.Request) {
+	cs.changeSubscription(w, r, cs.subscriptionBusiness.Skip)
+}
+
+func (cs *CommerceServer) CancelSubscription(w http.ResponseWriter, r *http.Request) {
+	cs.changeSubscription(w, r, cs.subscriptionBusiness.Cancel)
+}
+
+// changeSubscription applies change to the subscription in the path once the
+// caller is found to own it or to manage the shop's orders.
+func (cs *CommerceServer) changeSubscription(
+	w http.ResponseWriter,
+	r *http.Request,
+	change func(ctx context.Context, id string) (*models.Subscription, error),
+) {
+	subscription, err := cs.authorizedSubscription(r, business.PermissionOrdersManage)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	subscription, err = change(r.Context(), subscription.GetID())
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, newSubscriptionView(subscription))
+}
+
+// authorizedSubscription loads the subscription in the path and checks the
+// caller may act on it with perm.
+func (cs *CommerceServer) authorizedSubscription(
+	r *http.Request,
+	perm business.Permission,
+) (*models.Subscription, error) {
+	ctx := r.Context()
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		return nil, err
+	}
+	subscription, err := cs.subscriptionBusiness.GetSubscription(ctx, r.PathValue("subscription_id"))
+	if err != nil {
+		return nil, err
+	}
+	if err = cs.authzBusiness.AuthorizeProfile(ctx, subscription.ShopID, subscription.ProfileID, perm); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func (b subscribeBody) input() (business.SubscriptionInput, error) {
+	input := business.SubscriptionInput{
+		ShopID:    b.ShopID,
+		ProfileID: b.ProfileID,
+		ContactID: b.ContactID,
+		AddressID: b.AddressID,
+		Interval:  business.SubscriptionInterval{Unit: b.IntervalUnit, Count: b.IntervalCount},
+		StartAt:   b.StartAt,
+		Lines:     make([]business.SubscriptionLineInput, 0, len(b.Lines)),
+	}
+	switch b.PriceLock {
+	case "", priceLockNone:
+		input.PriceLock = models.PriceLockNone
+	case priceLockFixed:
+		input.PriceLock = models.PriceLockFixed
+	case priceLockCapped:
+		input.PriceLock = models.PriceLockCapped
+	default:
+		return input, connect.NewError(connect.CodeInvalidArgument,
+			errors.New("price_lock must be none, fixed or capped"))
+	}
+	for _, line := range b.Lines {
+		input.Lines = append(input.Lines, business.SubscriptionLineInput{VariantID: line.VariantID, Quantity: line.Quantity})
+	}
+	return input, nil
+}
+
+func newSubscriptionView(subscription *models.Subscription) subscriptionView {
+	view := subscriptionView{
+		ID:            subscription.GetID(),
+		ShopID:        subscription.ShopID,
+		ProfileID:     subscription.ProfileID,
+		ContactID:     subscription.ContactID,
+		AddressID:     subscription.AddressID,
+		IntervalUnit:  subscription.IntervalUnit,
+		IntervalCount: subscription.IntervalCount,
+		PriceLock:     priceLockName(subscription.PriceLock),
+		Status:        subscriptionStatusName(subscription.Status),
+		NextRunAt:     subscription.NextRunAt,
+		CycleCount:    subscription.CycleCount,
+		LastOrderID:   subscription.LastOrderID,
+		Lines:         make([]subscriptionLineView, 0, len(subscription.Lines)),
+	}
+	for _, line := range subscription.Lines {
+		view.Lines = append(view.Lines, subscriptionLineView{
+			VariantID:   line.ProductVariantID,
+			Quantity:    line.Quantity,
+			Currency:    line.LockedPriceCurrency,
+			LockedPrice: business.DecimalAmount(line.LockedPriceUnits, line.LockedPriceNanos),
+		})
+	}
+	return view
+}
+
+func priceLockName(rule int32) string {
+	switch rule {
+	case models.PriceLockFixed:
+		return priceLockFixed
+	case models.PriceLockCapped:
+		return priceLockCapped
+	}
+	return priceLockNone
+}
+
+func subscriptionStatusName(status int32) string {
+	switch status {
+	case models.SubscriptionStatusActive:
+		return "active"
+	case models.SubscriptionStatusPaused:
+		return "paused"
+	case models.SubscriptionStatusCancelled:
+		return "cancelled"
+	}
+	return "unknown"
+}
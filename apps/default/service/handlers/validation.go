@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+)
+
+// NewValidationInterceptor holds every RPC request to the rules of
+// business.ValidateRequest. The default interceptors already enforce the
+// constraints the proto declares; this one goes after them, so requests it
+// sees are well formed and authenticated.
+func NewValidationInterceptor() connect.Interceptor {
+	return connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if msg, ok := req.Any().(proto.Message); ok {
+				if err := business.ValidateRequest(msg); err != nil {
+					return nil, err
+				}
+			}
+			return next(ctx, req)
+		}
+	})
+}
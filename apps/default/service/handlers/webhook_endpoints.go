@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"connectrpc.com/connect"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/business"
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+// Webhook endpoint routes. An endpoint's signing secret is shown once, when
+// it is created. Deliveries take status, one of pending, delivered or dead,
+// to list only those; a delivery is read with its attempts, and redelivering
+// queues it again whatever became of it.
+const (
+	ListWebhookEndpointsPattern   = "GET /webhooks/shops/{shop_id}/endpoints"
+	CreateWebhookEndpointPattern  = "POST /webhooks/shops/{shop_id}/endpoints"
+	GetWebhookEndpointPattern     = "GET /webhooks/endpoints/{endpoint_id}"
+	DisableWebhookEndpointPattern = "PUT /webhooks/endpoints/{endpoint_id}/disabled"
+	DeleteWebhookEndpointPattern  = "DELETE /webhooks/endpoints/{endpoint_id}"
+	ListWebhookDeliveriesPattern  = "GET /webhooks/endpoints/{endpoint_id}/deliveries"
+	GetWebhookDeliveryPattern     = "GET /webhooks/deliveries/{delivery_id}"
+	RedeliverWebhookPattern       = "POST /webhooks/deliveries/{delivery_id}/redeliver"
+)
+
+// Delivery status names as the routes take and report them.
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryDead      = "dead"
+)
+
+// webhookEndpointView is the JSON form of a webhook endpoint.
+type webhookEndpointView struct {
+	ID         string    `json:"id"`
+	ShopID     string    `json:"shop_id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"`
+	EventTypes []string  `json:"event_types"`
+	Disabled   bool      `json:"disabled"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type webhookEndpointsView struct {
+	Endpoints []webhookEndpointView `json:"endpoints"`
+}
+
+// webhookDeliveryView is the JSON form of a webhook delivery. Attempts are
+// only filled in when one delivery is read.
+type webhookDeliveryView struct {
+	ID             string               `json:"id"`
+	EndpointID     string               `json:"endpoint_id"`
+	EventID        string               `json:"event_id"`
+	EventType      string               `json:"event_type"`
+	Status         string               `json:"status"`
+	Attempts       int32                `json:"attempts"`
+	NextAttemptAt  time.Time            `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time           `json:"last_attempt_at,omitempty"`
+	LastStatusCode int32                `json:"last_status_code,omitempty"`
+	LastError      string               `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time           `json:"delivered_at,omitempty"`
+	History        []webhookAttemptView `json:"history,omitempty"`
+}
+
+type webhookDeliveriesView struct {
+	Deliveries []webhookDeliveryView `json:"deliveries"`
+}
+
+type webhookAttemptView struct {
+	Attempt     int32     `json:"attempt"`
+	StatusCode  int32     `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+type createWebhookEndpointBody struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+}
+
+type disableWebhookEndpointBody struct {
+	Disabled bool `json:"disabled"`
+}
+
+func (cs *CommerceServer) ListWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	shopID := r.PathValue("shop_id")
+
+	if err := cs.authzBusiness.AuthorizeShop(ctx, shopID, business.PermissionShopManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	endpoints, err := cs.webhookBusiness.ListEndpoints(ctx, shopID)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := webhookEndpointsView{Endpoints: make([]webhookEndpointView, 0, len(endpoints))}
+	for _, endpoint := range endpoints {
+		view.Endpoints = append(view.Endpoints, newWebhookEndpointView(endpoint))
+	}
+	writeJSON(w, r, http.StatusOK, view)
+}
+
+func (cs *CommerceServer) CreateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	shopID := r.PathValue("shop_id")
+
+	if err := cs.authzBusiness.AuthorizeShop(ctx, shopID, business.PermissionShopManage); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body createWebhookEndpointBody
+	if err := readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	endpoint, err := cs.webhookBusiness.CreateEndpoint(ctx, shopID, body.URL, body.EventTypes)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := newWebhookEndpointView(endpoint)
+	view.Secret = endpoint.Secret
+	writeJSON(w, r, http.StatusCreated, view)
+}
+
+func (cs *CommerceServer) GetWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	endpoint, err := cs.authorizedWebhookEndpoint(r)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, newWebhookEndpointView(endpoint))
+}
+
+func (cs *CommerceServer) DisableWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	endpoint, err := cs.authorizedWebhookEndpoint(r)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	var body disableWebhookEndpointBody
+	if err = readJSON(w, r, &body); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	endpoint, err = cs.webhookBusiness.SetEndpointDisabled(r.Context(), endpoint.GetID(), body.Disabled)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, newWebhookEndpointView(endpoint))
+}
+
+func (cs *CommerceServer) DeleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	endpoint, err := cs.authorizedWebhookEndpoint(r)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	if err = cs.webhookBusiness.DeleteEndpoint(r.Context(), endpoint.GetID()); err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cs *CommerceServer) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	endpoint, err := cs.authorizedWebhookEndpoint(r)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	status, err := parseDeliveryStatus(r.URL.Query().Get("status"))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	deliveries, err := cs.webhookBusiness.ListDeliveries(r.Context(), endpoint.GetID(), status)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := webhookDeliveriesView{Deliveries: make([]webhookDeliveryView, 0, len(deliveries))}
+	for _, delivery := range deliveries {
+		view.Deliveries = append(view.Deliveries, newWebhookDeliveryView(delivery))
+	}
+	writeJSON(w, r, http.StatusOK, view)
+}
+
+func (cs *CommerceServer) GetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	delivery, err := cs.authorizedWebhookDelivery(r)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	attempts, err := cs.webhookBusiness.ListDeliveryAttempts(r.Context(), delivery.GetID())
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	view := newWebhookDeliveryView(delivery)
+	view.History = make([]webhookAttemptView, 0, len(attempts))
+	for _, attempt := range attempts {
+		view.History = append(view.History, webhookAttemptView{
+			Attempt:     attempt.Attempt,
+			StatusCode:  attempt.StatusCode,
+			Error:       attempt.Error,
+			DurationMs:  attempt.DurationMs,
+			AttemptedAt: attempt.AttemptedAt,
+		})
+	}
+	writeJSON(w, r, http.StatusOK, view)
+}
+
+func (cs *CommerceServer) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	delivery, err := cs.authorizedWebhookDelivery(r)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	delivery, err = cs.webhookBusiness.Redeliver(r.Context(), delivery.GetID())
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusAccepted, newWebhookDeliveryView(delivery))
+}
+
+// authorizedWebhookEndpoint loads the endpoint in the path and checks the
+// caller manages its shop.
+func (cs *CommerceServer) authorizedWebhookEndpoint(r *http.Request) (*models.WebhookEndpoint, error) {
+	ctx := r.Context()
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		return nil, err
+	}
+	endpoint, err := cs.webhookBusiness.GetEndpoint(ctx, r.PathValue("endpoint_id"))
+	if err != nil {
+		return nil, err
+	}
+	if err = cs.authzBusiness.AuthorizeShop(ctx, endpoint.ShopID, business.PermissionShopManage); err != nil {
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+// authorizedWebhookDelivery loads the delivery in the path and checks the
+// caller manages its shop.
+func (cs *CommerceServer) authorizedWebhookDelivery(r *http.Request) (*models.WebhookDelivery, error) {
+	ctx := r.Context()
+	if _, err := cs.authzBusiness.Authenticated(ctx); err != nil {
+		return nil, err
+	}
+	delivery, err := cs.webhookBusiness.GetDelivery(ctx, r.PathValue("delivery_id"))
+	if err != nil {
+		return nil, err
+	}
+	if err = cs.authzBusiness.AuthorizeShop(ctx, delivery.ShopID, business.PermissionShopManage); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// parseDeliveryStatus reads a delivery status name; empty lists every status.
+func parseDeliveryStatus(name string) (int32, error) {
+	switch name {
+	case "":
+		return 0, nil
+	case deliveryPending:
+		return models.WebhookDeliveryPending, nil
+	case deliveryDelivered:
+		return models.WebhookDeliveryDelivered, nil
+	case deliveryDead:
+		return models.WebhookDeliveryDead, nil
+	}
+	return 0, connect.NewError(connect.CodeInvalidArgument, errors.New("status must be pending, delivered or dead"))
+}
+
+func deliveryStatusName(status int32) string {
+	switch status {
+	case models.WebhookDeliveryPending:
+		return deliveryPending
+	case models.WebhookDeliveryDelivered:
+		return deliveryDelivered
+	case models.WebhookDeliveryDead:
+		return deliveryDead
+	}
+	return "unknown"
+}
+
+func newWebhookEndpointView(endpoint *models.WebhookEndpoint) webhookEndpointView {
+	return webhookEndpointView{
+		ID:         endpoint.GetID(),
+		ShopID:     endpoint.ShopID,
+		URL:        endpoint.URL,
+		EventTypes: nonNilStrings(endpoint.EventTypes),
+		Disabled:   endpoint.Disabled,
+		CreatedAt:  endpoint.CreatedAt,
+	}
+}
+
+func newWebhookDeliveryView(delivery *models.WebhookDelivery) webhookDeliveryView {
+	return webhookDeliveryView{
+		ID:             delivery.GetID(),
+		EndpointID:     delivery.EndpointID,
+		EventID:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Status:         deliveryStatusName(delivery.Status),
+		Attempts:       delivery.Attempts,
+		NextAttemptAt:  delivery.NextAttemptAt,
+		LastAttemptAt:  delivery.LastAttemptAt,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		DeliveredAt:    delivery.DeliveredAt,
+	}
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+)
+
+// CarrierWebhookPattern is where carriers deliver tracking webhooks; the last
+// path segment names the carrier.
+const CarrierWebhookPattern = "POST /webhooks/carriers/{carrier}"
+
+// maxCarrierWebhookBytes caps the size of a carrier webhook body.
+const maxCarrierWebhookBytes = 1 << 20
+
+// CarrierWebhook records a carrier's tracking updates. Carriers authenticate
+// by signing the body, so the request carries no caller claims.
+func (cs *CommerceServer) CarrierWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCarrierWebhookBytes))
+	if err != nil {
+		http.Error(w, "could not read body", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	_, err = cs.shippingBusiness.ReceiveTrackingWebhook(ctx, r.PathValue("carrier"), r.Header, body)
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
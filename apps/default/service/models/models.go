@@ -4,6 +4,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
 	"github.com/pitabwire/frame/data"
@@ -53,11 +54,98 @@ func (StringArray) GormDBDataType(db *gorm.DB, _ *schema.Field) string {
 	}
 }
 
+// CollectionRule matches products for a rule-based collection, for example
+// the product attribute brand equal to X or a variant price below 1000.
+type CollectionRule struct {
+	// Field is one of "attribute", "name" or "price".
+	Field string `json:"field"`
+	// Attribute names the product attribute when Field is "attribute".
+	Attribute string `json:"attribute,omitempty"`
+	// Operator is one of "eq", "neq", "lt", "lte", "gt", "gte" or "contains".
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// CollectionRules stores collection rules as JSONB in PostgreSQL.
+type CollectionRules []CollectionRule
+
+func (r CollectionRules) Value() (driver.Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return json.Marshal(r)
+}
+
+func (r *CollectionRules) Scan(value any) error {
+	if value == nil {
+		*r = nil
+		return nil
+	}
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("CollectionRules.Scan: expected []byte, got %T", value)
+	}
+	return json.Unmarshal(b, r)
+}
+
+func (CollectionRules) GormDataType() string { return "jsonb" }
+
+func (CollectionRules) GormDBDataType(db *gorm.DB, _ *schema.Field) string {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return "JSONB"
+	default:
+		return "JSON"
+	}
+}
+
+// BundleItem records one component of a bundle as it was when the bundle
+// was ordered. Quantity is per bundle.
+type BundleItem struct {
+	VariantID string `json:"variant_id"`
+	SKU       string `json:"sku"`
+	Name      string `json:"name"`
+	Quantity  int64  `json:"quantity"`
+}
+
+// BundleComposition stores a bundle's components as JSONB in PostgreSQL.
+type BundleComposition []BundleItem
+
+func (c BundleComposition) Value() (driver.Value, error) {
+	if c == nil {
+		return nil, nil
+	}
+	return json.Marshal(c)
+}
+
+func (c *BundleComposition) Scan(value any) error {
+	if value == nil {
+		*c = nil
+		return nil
+	}
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("BundleComposition.Scan: expected []byte, got %T", value)
+	}
+	return json.Unmarshal(b, c)
+}
+
+func (BundleComposition) GormDataType() string { return "jsonb" }
+
+func (BundleComposition) GormDBDataType(db *gorm.DB, _ *schema.Field) string {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return "JSONB"
+	default:
+		return "JSON"
+	}
+}
+
 // Shop represents a storefront entity.
 type Shop struct {
 	data.BaseModel
 	Name        string `gorm:"type:varchar(255)"`
-	Slug        string `gorm:"type:varchar(255);uniqueIndex"`
+	Slug        string `gorm:"type:varchar(255);index:idx_shop_slug"`
 	Description string `gorm:"type:text"`
 	Status      int32  `gorm:"default:1"`
 	MediaIDs    StringArray
@@ -82,37 +170,60 @@ type Product struct {
 	data.BaseModel
 	ShopID         string `gorm:"type:varchar(50);index:idx_product_shop_id"`
 	Name           string `gorm:"type:varchar(255)"`
+	Slug           string `gorm:"type:varchar(255);index:idx_product_slug"`
 	Description    string `gorm:"type:text"`
 	Attributes     data.JSONMap
 	FulfilmentType int32 `gorm:"default:0"`
 	Status         int32 `gorm:"default:1"`
 	MediaIDs       StringArray
 
-	Shop     *Shop            `gorm:"foreignKey:ShopID"`
+	Shop     *Shop             `gorm:"foreignKey:ShopID"`
 	Variants []*ProductVariant `gorm:"foreignKey:ProductID"`
+	Options  []*ProductOption  `gorm:"foreignKey:ProductID"`
 }
 
+// FulfilmentTypeBooking marks products sold as time slots, such as
+// appointments or event tickets. The proto has no value for it yet, so the
+// API reports these products as FULFILMENT_TYPE_NONE: nothing is shipped.
+const FulfilmentTypeBooking int32 = 4
+
 func (p *Product) ToAPI() *commercev1.Product {
 	attrs := mapFromJSONMap(p.Attributes)
 
+	fulfilmentType := commercev1.FulfilmentType(p.FulfilmentType)
+	if p.FulfilmentType == FulfilmentTypeBooking {
+		fulfilmentType = commercev1.FulfilmentType_FULFILMENT_TYPE_NONE
+	}
+
 	return &commercev1.Product{
 		Id:             p.ID,
 		ShopId:         p.ShopID,
 		Name:           p.Name,
 		Description:    p.Description,
 		Attributes:     attrs,
-		FulfilmentType: commercev1.FulfilmentType(p.FulfilmentType),
+		FulfilmentType: fulfilmentType,
 		Status:         commercev1.ProductStatus(p.Status),
 		MediaIds:       p.MediaIDs.ToStringSlice(),
 		CreatedAt:      timestamppb.New(p.CreatedAt),
 	}
 }
 
+// ProductOption declares one dimension a product varies on, such as Size with
+// the values S, M and L. A product's variants take one value per option.
+type ProductOption struct {
+	data.BaseModel
+	ProductID string `gorm:"type:varchar(50);uniqueIndex:idx_product_option_name"`
+	Name      string `gorm:"type:varchar(100);uniqueIndex:idx_product_option_name"`
+	Position  int32
+	Values    StringArray
+}
+
 // ProductVariant represents a specific variant of a product.
 type ProductVariant struct {
 	data.BaseModel
 	ProductID     string `gorm:"type:varchar(50);index:idx_variant_product_id"`
-	SKU           string `gorm:"type:varchar(255);uniqueIndex"`
+	ShopID        string `gorm:"type:varchar(50);index:idx_variant_shop_id"`
+	SKU           string `gorm:"type:varchar(255);index:idx_variant_sku"`
 	Name          string `gorm:"type:varchar(255)"`
 	CurrencyCode  string `gorm:"type:varchar(3)"`
 	PriceUnits    int64
@@ -121,10 +232,38 @@ type ProductVariant struct {
 	Attributes    data.JSONMap
 	MediaIDs      StringArray
 	Status        int32 `gorm:"default:1"`
+	// Kind is VariantKindStandard or VariantKindBundle. A bundle keeps no
+	// stock of its own: it is sold from the stock of its components.
+	Kind int32 `gorm:"default:1"`
+	// Location is where the variant is kept in the warehouse, such as a bin
+	// or shelf code. Pick lists are walked in location order.
+	Location string `gorm:"type:varchar(100)"`
 
 	Product *Product `gorm:"foreignKey:ProductID"`
 }
 
+// Variant kinds.
+const (
+	VariantKindStandard int32 = 1
+	VariantKindBundle   int32 = 2
+)
+
+// IsBundle reports whether the variant is a bundle of other variants.
+func (pv *ProductVariant) IsBundle() bool {
+	return pv.Kind == VariantKindBundle
+}
+
+// BundleComponent is Quantity units of a variant contained in one unit of a
+// bundle variant.
+type BundleComponent struct {
+	data.BaseModel
+	BundleVariantID    string `gorm:"type:varchar(50);uniqueIndex:idx_bundle_component_pair"`
+	ComponentVariantID string `gorm:"type:varchar(50);uniqueIndex:idx_bundle_component_pair;index:idx_bundle_component_component_id"`
+	Quantity           int64
+
+	ComponentVariant *ProductVariant `gorm:"foreignKey:ComponentVariantID"`
+}
+
 func (pv *ProductVariant) ToAPI() *commercev1.ProductVariant {
 	attrs := mapFromJSONMap(pv.Attributes)
 
@@ -178,6 +317,8 @@ type CartLine struct {
 	CartID           string `gorm:"type:varchar(50);index:idx_cartline_cart_id"`
 	ProductVariantID string `gorm:"type:varchar(50)"`
 	Quantity         int64
+	// SlotID is the booking slot reserved by the line, for booking products.
+	SlotID string `gorm:"type:varchar(50)"`
 
 	Cart           *Cart           `gorm:"foreignKey:CartID"`
 	ProductVariant *ProductVariant `gorm:"foreignKey:ProductVariantID"`
@@ -194,39 +335,55 @@ func (cl *CartLine) ToAPI() *commercev1.CartLine {
 // Order represents a completed order.
 type Order struct {
 	data.BaseModel
-	ShopID           string `gorm:"type:varchar(50);index:idx_order_shop_id"`
-	OrderNumber      string `gorm:"type:varchar(100);uniqueIndex"`
-	IdempotencyKey   string `gorm:"type:varchar(255);uniqueIndex"`
+	ShopID           string `gorm:"type:varchar(50);index:idx_order_shop_id;uniqueIndex:idx_order_shop_number"`
+	OrderNumber      string `gorm:"type:varchar(100);uniqueIndex:idx_order_shop_number"`
+	IdempotencyKey   string `gorm:"type:varchar(255);index:idx_order_idempotency_key"`
 	Status           int32  `gorm:"default:1"`
 	PaymentStatus    int32  `gorm:"default:1"`
 	FulfilmentStatus int32  `gorm:"default:0"`
 	ProfileID        string `gorm:"type:varchar(50);index:idx_order_profile_id"`
 	ContactID        string `gorm:"type:varchar(50)"`
 	AddressID        string `gorm:"type:varchar(50)"`
+	CartID           string `gorm:"type:varchar(50);uniqueIndex:idx_order_cart_id,where:cart_id <> ''"`
 	SubtotalCurrency string `gorm:"type:varchar(3)"`
 	SubtotalUnits    int64
 	SubtotalNanos    int32
 	TotalCurrency    string `gorm:"type:varchar(3)"`
 	TotalUnits       int64
 	TotalNanos       int32
+	// StockReleased is set once the stock the order took has been given back,
+	// on cancellation or refund, so that it is only given back once.
+	StockReleased bool `gorm:"default:false"`
 
 	Lines []*OrderLine `gorm:"foreignKey:OrderID"`
 	Shop  *Shop        `gorm:"foreignKey:ShopID"`
 }
 
+// FulfilmentStatusPartial is the order fulfilment status once some, but not
+// all, of an order has shipped. The proto has no value for it yet, so the API
+// reports it as FULFILMENT_STATUS_SHIPPED, as something is on its way; the
+// order only becomes FULFILLED once everything is delivered. Order exports
+// keep the distinction and list it as "partial".
+const FulfilmentStatusPartial int32 = 7
+
 func (o *Order) ToAPI() *commercev1.Order {
 	var lines []*commercev1.OrderLine
 	for _, line := range o.Lines {
 		lines = append(lines, line.ToAPI())
 	}
 
+	fulfilmentStatus := commercev1.FulfilmentStatus(o.FulfilmentStatus)
+	if o.FulfilmentStatus == FulfilmentStatusPartial {
+		fulfilmentStatus = commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED
+	}
+
 	return &commercev1.Order{
 		Id:               o.ID,
 		ShopId:           o.ShopID,
 		OrderNumber:      o.OrderNumber,
 		Status:           commercev1.OrderStatus(o.Status),
 		PaymentStatus:    commercev1.PaymentStatus(o.PaymentStatus),
-		FulfilmentStatus: commercev1.FulfilmentStatus(o.FulfilmentStatus),
+		FulfilmentStatus: fulfilmentStatus,
 		ProfileId:        o.ProfileID,
 		ContactId:        o.ContactID,
 		AddressId:        o.AddressID,
@@ -251,6 +408,10 @@ type OrderLine struct {
 	TotalPriceCurrency string `gorm:"type:varchar(3)"`
 	TotalPriceUnits    int64
 	TotalPriceNanos    int32
+	SlotID             string `gorm:"type:varchar(50)"`
+	// BundleComposition snapshots the components of a bundle line; ordering
+	// the bundle took their stock.
+	BundleComposition BundleComposition
 
 	Order *Order `gorm:"foreignKey:OrderID"`
 }
@@ -272,8 +433,10 @@ type Fulfilment struct {
 	data.BaseModel
 	OrderID        string `gorm:"type:varchar(50);index:idx_fulfilment_order_id"`
 	Status         int32  `gorm:"default:1"`
-	Carrier        string `gorm:"type:varchar(255)"`
-	TrackingNumber string `gorm:"type:varchar(255)"`
+	Carrier        string `gorm:"type:varchar(255);index:idx_fulfilment_tracking"`
+	TrackingNumber string `gorm:"type:varchar(255);index:idx_fulfilment_tracking"`
+	ShippedAt      *time.Time
+	DeliveredAt    *time.Time
 
 	Lines []*FulfilmentLine `gorm:"foreignKey:FulfilmentID"`
 	Order *Order            `gorm:"foreignKey:OrderID"`
@@ -285,7 +448,7 @@ func (f *Fulfilment) ToAPI() *commercev1.Fulfilment {
 		lines = append(lines, line.ToAPI())
 	}
 
-	return &commercev1.Fulfilment{
+	fulfilment := &commercev1.Fulfilment{
 		Id:             f.ID,
 		OrderId:        f.OrderID,
 		Status:         commercev1.FulfilmentStatus(f.Status),
@@ -294,6 +457,25 @@ func (f *Fulfilment) ToAPI() *commercev1.Fulfilment {
 		Lines:          lines,
 		CreatedAt:      timestamppb.New(f.CreatedAt),
 	}
+	if f.ShippedAt != nil {
+		fulfilment.ShippedAt = timestamppb.New(*f.ShippedAt)
+	}
+	return fulfilment
+}
+
+// FulfilmentTrackingEvent is a tracking update reported by a carrier for a
+// fulfilment's shipment. EventID is the carrier's own identifier, so a
+// redelivered update is recorded once.
+type FulfilmentTrackingEvent struct {
+	data.BaseModel
+	FulfilmentID   string `gorm:"type:varchar(50);index:idx_tracking_event_fulfilment_id"`
+	Carrier        string `gorm:"type:varchar(255);uniqueIndex:idx_tracking_event_carrier_event"`
+	EventID        string `gorm:"type:varchar(255);uniqueIndex:idx_tracking_event_carrier_event"`
+	TrackingNumber string `gorm:"type:varchar(255)"`
+	Status         int32
+	Description    string `gorm:"type:text"`
+	Location       string `gorm:"type:varchar(255)"`
+	OccurredAt     time.Time
 }
 
 // FulfilmentLine represents a line item in a fulfilment.
@@ -313,6 +495,468 @@ func (fl *FulfilmentLine) ToAPI() *commercev1.FulfilmentLine {
 	}
 }
 
+// Category groups products within a shop. Categories form a tree through
+// ParentID; root categories have an empty ParentID.
+type Category struct {
+	data.BaseModel
+	ShopID      string `gorm:"type:varchar(50);uniqueIndex:idx_category_shop_slug"`
+	ParentID    string `gorm:"type:varchar(50);index:idx_category_parent_id"`
+	Name        string `gorm:"type:varchar(255)"`
+	Slug        string `gorm:"type:varchar(255);uniqueIndex:idx_category_shop_slug"`
+	Description string `gorm:"type:text"`
+	Position    int32
+	MediaIDs    StringArray
+}
+
+// ProductCategory places a product in a category.
+type ProductCategory struct {
+	data.BaseModel
+	ProductID  string `gorm:"type:varchar(50);uniqueIndex:idx_product_category"`
+	CategoryID string `gorm:"type:varchar(50);uniqueIndex:idx_product_category;index:idx_product_category_category_id"`
+}
+
+// Collection is a curated set of products. Manual collections list their
+// products explicitly; rule-based collections match products by Rules.
+type Collection struct {
+	data.BaseModel
+	ShopID      string `gorm:"type:varchar(50);uniqueIndex:idx_collection_shop_slug"`
+	Name        string `gorm:"type:varchar(255)"`
+	Slug        string `gorm:"type:varchar(255);uniqueIndex:idx_collection_shop_slug"`
+	Description string `gorm:"type:text"`
+	Type        int32  `gorm:"default:1"`
+	Rules       CollectionRules
+	// MatchAny includes products matching any rule instead of all of them.
+	MatchAny bool
+	MediaIDs StringArray
+}
+
+// CollectionProduct places a product in a manual collection at a position.
+type CollectionProduct struct {
+	data.BaseModel
+	CollectionID string `gorm:"type:varchar(50);uniqueIndex:idx_collection_product"`
+	ProductID    string `gorm:"type:varchar(50);uniqueIndex:idx_collection_product"`
+	Position     int32
+}
+
+// DigitalAsset is the file delivered for a digital product variant, with the
+// download allowance granted to each buyer.
+type DigitalAsset struct {
+	data.BaseModel
+	ProductVariantID string `gorm:"type:varchar(50);uniqueIndex:idx_digital_asset_variant_id"`
+	FileID           string `gorm:"type:varchar(50)"`
+	MaxDownloads     int32
+	AccessDays       int32
+}
+
+// Entitlement grants the buyer of a digital order line access to a file. The
+// download token is stored hashed; it expires with the entitlement and is
+// good for MaxDownloads downloads.
+type Entitlement struct {
+	data.BaseModel
+	ShopID           string `gorm:"type:varchar(50);index:idx_entitlement_shop_id"`
+	OrderID          string `gorm:"type:varchar(50);index:idx_entitlement_order_id"`
+	OrderLineID      string `gorm:"type:varchar(50);uniqueIndex:idx_entitlement_order_line_id"`
+	ProfileID        string `gorm:"type:varchar(50);index:idx_entitlement_profile_id"`
+	ProductVariantID string `gorm:"type:varchar(50)"`
+	FileID           string `gorm:"type:varchar(50)"`
+	TokenHash        string `gorm:"type:varchar(64);uniqueIndex:idx_entitlement_token_hash"`
+	ExpiresAt        time.Time
+	MaxDownloads     int32
+	DownloadCount    int32
+}
+
+// Booking slot and reservation states.
+const (
+	SlotStatusOpen   int32 = 1
+	SlotStatusClosed int32 = 2
+
+	ReservationStatusHeld      int32 = 1
+	ReservationStatusConfirmed int32 = 2
+	ReservationStatusReleased  int32 = 3
+)
+
+// BookingSlot is a schedulable time slot of a booking variant. Capacity
+// replaces the variant's stock: Reserved counts places held by carts and
+// confirmed by orders.
+type BookingSlot struct {
+	data.BaseModel
+	ShopID           string    `gorm:"type:varchar(50);index:idx_booking_slot_shop_id"`
+	ProductID        string    `gorm:"type:varchar(50);index:idx_booking_slot_product_id"`
+	ProductVariantID string    `gorm:"type:varchar(50);uniqueIndex:idx_booking_slot_variant_start"`
+	StartsAt         time.Time `gorm:"uniqueIndex:idx_booking_slot_variant_start"`
+	EndsAt           time.Time
+	Capacity         int64
+	Reserved         int64
+	Status           int32 `gorm:"default:1"`
+}
+
+// Available is the number of places left in the slot.
+func (s *BookingSlot) Available() int64 {
+	return max(s.Capacity-s.Reserved, 0)
+}
+
+// SlotReservation holds places in a slot for a cart line. Held reservations
+// expire unless the cart is converted, which confirms them against the order.
+type SlotReservation struct {
+	data.BaseModel
+	SlotID     string `gorm:"type:varchar(50);index:idx_slot_reservation_slot_id"`
+	CartID     string `gorm:"type:varchar(50);index:idx_slot_reservation_cart_id"`
+	CartLineID string `gorm:"type:varchar(50);uniqueIndex:idx_slot_reservation_cart_line_id"`
+	OrderID    string `gorm:"type:varchar(50);index:idx_slot_reservation_order_id"`
+	Quantity   int64
+	Status     int32 `gorm:"default:1"`
+	ExpiresAt  time.Time
+}
+
+// Subscription states and price-lock rules. PriceLockNone charges each cycle
+// at the variant's current price, PriceLockFixed keeps charging the price
+// captured when subscribing, and PriceLockCapped charges the current price but
+// never more than the captured one.
+const (
+	SubscriptionStatusActive    int32 = 1
+	SubscriptionStatusPaused    int32 = 2
+	SubscriptionStatusCancelled int32 = 3
+
+	PriceLockNone   int32 = 1
+	PriceLockFixed  int32 = 2
+	PriceLockCapped int32 = 3
+)
+
+// Subscription orders a set of variants for a profile every interval. Each
+// run creates an order and moves NextRunAt on by one interval.
+type Subscription struct {
+	data.BaseModel
+	ShopID        string `gorm:"type:varchar(50);index:idx_subscription_shop_id"`
+	ProfileID     string `gorm:"type:varchar(50);index:idx_subscription_profile_id"`
+	ContactID     string `gorm:"type:varchar(50)"`
+	AddressID     string `gorm:"type:varchar(50)"`
+	IntervalUnit  string `gorm:"type:varchar(10)"`
+	IntervalCount int32
+	PriceLock     int32 `gorm:"default:1"`
+	Status        int32 `gorm:"default:1"`
+	// AnchorAt is the first run; every run falls a whole number of intervals
+	// after it, so month-end subscriptions do not drift.
+	AnchorAt    time.Time
+	NextRunAt   time.Time `gorm:"index:idx_subscription_next_run_at"`
+	CycleCount  int64
+	LastOrderID string `gorm:"type:varchar(50)"`
+
+	Lines []*SubscriptionLine `gorm:"foreignKey:SubscriptionID"`
+}
+
+// SubscriptionLine is one variant of a subscription, with the unit price
+// captured when subscribing for the price-lock rules.
+type SubscriptionLine struct {
+	data.BaseModel
+	SubscriptionID      string `gorm:"type:varchar(50);index:idx_subscription_line_subscription_id"`
+	ProductVariantID    string `gorm:"type:varchar(50)"`
+	Quantity            int64
+	LockedPriceCurrency string `gorm:"type:varchar(3)"`
+	LockedPriceUnits    int64
+	LockedPriceNanos    int32
+}
+
+// SlugRedirect keeps a retired slug resolving to the shop or product that
+// used it, so old links can be redirected to the current slug. ScopeID is the
+// shop for product slugs and empty for shop slugs.
+type SlugRedirect struct {
+	data.BaseModel
+	EntityType string `gorm:"type:varchar(20)"`
+	ScopeID    string `gorm:"type:varchar(50)"`
+	Slug       string `gorm:"type:varchar(255)"`
+	EntityID   string `gorm:"type:varchar(50);index:idx_slug_redirect_entity_id"`
+}
+
+// ShopSequence is a per-shop counter row used to allocate human-friendly
+// sequential numbers such as order numbers. Values are gap-tolerant: a number
+// allocated by a failed request is never reused.
+type ShopSequence struct {
+	data.BaseModel
+	ShopID string `gorm:"type:varchar(50);uniqueIndex:idx_shop_sequence_shop_name"`
+	Name   string `gorm:"type:varchar(50);uniqueIndex:idx_shop_sequence_shop_name"`
+	Value  int64
+}
+
+// ShopMember grants a profile a role within a shop. The role decides which
+// shop operations the profile may perform.
+type ShopMember struct {
+	data.BaseModel
+	ShopID    string `gorm:"type:varchar(50);uniqueIndex:idx_shop_member_shop_profile"`
+	ProfileID string `gorm:"type:varchar(50);uniqueIndex:idx_shop_member_shop_profile;index:idx_shop_member_profile_id"`
+	Role      string `gorm:"type:varchar(30)"`
+}
+
+// IdempotencyRecord remembers the outcome of a mutating request so that a
+// retry carrying the same key replays it instead of repeating side effects.
+// Keys are scoped to a shop, the calling profile and the operation.
+// ReservedID is the ID the claiming request creates its resource under, so a
+// claim abandoned by a crash finds exactly the resource its own request made.
+type IdempotencyRecord struct {
+	data.BaseModel
+	ShopID         string    `gorm:"type:varchar(50);uniqueIndex:idx_idempotency_scope"`
+	CallerID       string    `gorm:"type:varchar(50);uniqueIndex:idx_idempotency_scope"`
+	Operation      string    `gorm:"type:varchar(100);uniqueIndex:idx_idempotency_scope"`
+	IdempotencyKey string    `gorm:"type:varchar(255);uniqueIndex:idx_idempotency_scope"`
+	RequestHash    string    `gorm:"type:varchar(64)"`
+	ReservedID     string    `gorm:"type:varchar(50)"`
+	ResourceID     string    `gorm:"type:varchar(50)"`
+	ExpiresAt      time.Time `gorm:"index:idx_idempotency_expires_at"`
+}
+
+// Invoice kinds. A credit note reverses the invoice of the same order.
+const (
+	InvoiceKindInvoice    int32 = 1
+	InvoiceKindCreditNote int32 = 2
+)
+
+// Invoice is a tax document issued for an order. Everything printed on it is
+// frozen when it is issued, so later changes to the order or the shop do not
+// alter it. Number is sequential per shop and kind.
+type Invoice struct {
+	data.BaseModel
+	ShopID            string `gorm:"type:varchar(50);uniqueIndex:idx_invoice_shop_number"`
+	OrderID           string `gorm:"type:varchar(50);uniqueIndex:idx_invoice_order_kind"`
+	Kind              int32  `gorm:"uniqueIndex:idx_invoice_order_kind;uniqueIndex:idx_invoice_shop_number"`
+	Number            string `gorm:"type:varchar(100);uniqueIndex:idx_invoice_shop_number"`
+	CreditedInvoiceID string `gorm:"type:varchar(50)"`
+	ProfileID         string `gorm:"type:varchar(50);index:idx_invoice_profile_id"`
+	ShopName          string `gorm:"type:varchar(255)"`
+	OrderNumber       string `gorm:"type:varchar(100)"`
+	IssuedAt          time.Time
+	Currency          string `gorm:"type:varchar(3)"`
+	SubtotalUnits     int64
+	SubtotalNanos     int32
+	TotalUnits        int64
+	TotalNanos        int32
+	TaxLines          InvoiceTaxLines
+
+	Lines []*InvoiceLine `gorm:"foreignKey:InvoiceID"`
+}
+
+// InvoiceLine is an order line as it was invoiced.
+type InvoiceLine struct {
+	data.BaseModel
+	InvoiceID      string `gorm:"type:varchar(50);index:idx_invoice_line_invoice_id"`
+	OrderLineID    string `gorm:"type:varchar(50)"`
+	SKU            string `gorm:"type:varchar(255)"`
+	Name           string `gorm:"type:varchar(255)"`
+	Quantity       int64
+	UnitPriceUnits int64
+	UnitPriceNanos int32
+	TotalUnits     int64
+	TotalNanos     int32
+}
+
+// InvoiceTaxLine is a tax charged on an invoice. Inclusive taxes are part of
+// the invoice total rather than added to it.
+type InvoiceTaxLine struct {
+	Name            string `json:"name"`
+	RateBasisPoints int64  `json:"rate_basis_points"`
+	Inclusive       bool   `json:"inclusive"`
+	Units           int64  `json:"units"`
+	Nanos           int32  `json:"nanos"`
+}
+
+// InvoiceTaxLines stores an invoice's tax lines as JSONB in PostgreSQL.
+type InvoiceTaxLines []InvoiceTaxLine
+
+func (t InvoiceTaxLines) Value() (driver.Value, error) {
+	if t == nil {
+		return nil, nil
+	}
+	return json.Marshal(t)
+}
+
+func (t *InvoiceTaxLines) Scan(value any) error {
+	if value == nil {
+		*t = nil
+		return nil
+	}
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("InvoiceTaxLines.Scan: expected []byte, got %T", value)
+	}
+	return json.Unmarshal(b, t)
+}
+
+func (InvoiceTaxLines) GormDataType() string { return "jsonb" }
+
+func (InvoiceTaxLines) GormDBDataType(db *gorm.DB, _ *schema.Field) string {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return "JSONB"
+	default:
+		return "JSON"
+	}
+}
+
+// SalesDailyRollup aggregates the orders a shop placed on one day, in the
+// shop's time zone, in one currency. Gross sales exclude cancelled orders and
+// include refunded ones, whose totals are also summed as refunds.
+type SalesDailyRollup struct {
+	data.BaseModel
+	ShopID         string    `gorm:"type:varchar(50);uniqueIndex:idx_sales_rollup_shop_day"`
+	Day            time.Time `gorm:"type:date;uniqueIndex:idx_sales_rollup_shop_day"`
+	Currency       string    `gorm:"type:varchar(3);uniqueIndex:idx_sales_rollup_shop_day"`
+	OrderCount     int64
+	CancelledCount int64
+	RefundedCount  int64
+	GrossUnits     int64
+	GrossNanos     int32
+	RefundedUnits  int64
+	RefundedNanos  int32
+	UnitsSold      int64
+}
+
+// VariantSalesRollup aggregates the units of a variant sold on one day, in
+// the shop's time zone, by orders that were not cancelled. The names are
+// those the variant and its product had when the rollup was refreshed.
+type VariantSalesRollup struct {
+	data.BaseModel
+	ShopID           string    `gorm:"type:varchar(50);uniqueIndex:idx_variant_sales_rollup_day"`
+	Day              time.Time `gorm:"type:date;uniqueIndex:idx_variant_sales_rollup_day"`
+	ProductVariantID string    `gorm:"type:varchar(50);uniqueIndex:idx_variant_sales_rollup_day"`
+	Currency         string    `gorm:"type:varchar(3);uniqueIndex:idx_variant_sales_rollup_day"`
+	ProductID        string    `gorm:"type:varchar(50);index:idx_variant_sales_rollup_product"`
+	ProductName      string    `gorm:"type:varchar(255)"`
+	SKU              string    `gorm:"type:varchar(255)"`
+	VariantName      string    `gorm:"type:varchar(255)"`
+	UnitsSold        int64
+	RevenueUnits     int64
+	RevenueNanos     int32
+}
+
+// RollupWatermark records how far a rollup has been refreshed: orders
+// changed before RefreshedTo are reflected in it.
+type RollupWatermark struct {
+	data.BaseModel
+	Name        string `gorm:"type:varchar(100);uniqueIndex:idx_rollup_watermark_name"`
+	RefreshedTo time.Time
+}
+
+// Catalog import states.
+const (
+	ImportStatusQueued    int32 = 1
+	ImportStatusRunning   int32 = 2
+	ImportStatusCompleted int32 = 3
+	ImportStatusFailed    int32 = 4
+)
+
+// CatalogImport is a bulk import of products and variants into a shop, run in
+// the background. Source is the uploaded file, kept until the import has run.
+// Rows that were not imported are reported in Errors; Message says why an
+// import failed as a whole.
+type CatalogImport struct {
+	data.BaseModel
+	ShopID          string `gorm:"type:varchar(50);index:idx_catalog_import_shop_id"`
+	Format          string `gorm:"type:varchar(10)"`
+	Source          string `gorm:"type:text"`
+	Status          int32  `gorm:"default:1"`
+	TotalRows       int64
+	ProcessedRows   int64
+	CreatedProducts int64
+	UpdatedProducts int64
+	CreatedVariants int64
+	UpdatedVariants int64
+	FailedRows      int64
+	Errors          ImportRowErrors
+	Message         string `gorm:"type:text"`
+	StartedAt       *time.Time
+	FinishedAt      *time.Time
+}
+
+// ImportRowError reports why a row of an import was not imported. Row counts
+// from 1: the line of a CSV file, the element of a JSON array.
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	SKU     string `json:"sku,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportRowErrors stores an import's row errors as JSONB in PostgreSQL.
+type ImportRowErrors []ImportRowError
+
+func (e ImportRowErrors) Value() (driver.Value, error) {
+	if e == nil {
+		return nil, nil
+	}
+	return json.Marshal(e)
+}
+
+func (e *ImportRowErrors) Scan(value any) error {
+	if value == nil {
+		*e = nil
+		return nil
+	}
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("ImportRowErrors.Scan: expected []byte, got %T", value)
+	}
+	return json.Unmarshal(b, e)
+}
+
+func (ImportRowErrors) GormDataType() string { return "jsonb" }
+
+func (ImportRowErrors) GormDBDataType(db *gorm.DB, _ *schema.Field) string {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return "JSONB"
+	default:
+		return "JSON"
+	}
+}
+
+// Webhook delivery states. Pending deliveries are retried with exponential
+// backoff until they are delivered or run out of attempts, which leaves them
+// dead until redelivered by hand.
+const (
+	WebhookDeliveryPending   int32 = 1
+	WebhookDeliveryDelivered int32 = 2
+	WebhookDeliveryDead      int32 = 3
+)
+
+// WebhookEndpoint is a URL a shop has subscribed to event notifications.
+// Deliveries are signed with Secret. EventTypes lists the event types sent to
+// it, "*" standing for all of them.
+type WebhookEndpoint struct {
+	data.BaseModel
+	ShopID     string `gorm:"type:varchar(50);index:idx_webhook_endpoint_shop_id"`
+	URL        string `gorm:"type:text"`
+	Secret     string `gorm:"type:varchar(100)"`
+	EventTypes StringArray
+	Disabled   bool
+}
+
+// WebhookDelivery is one event queued for one endpoint. Payload is the exact
+// body sent on every attempt, so retries are byte-for-byte identical.
+type WebhookDelivery struct {
+	data.BaseModel
+	EndpointID     string `gorm:"type:varchar(50);uniqueIndex:idx_webhook_delivery_endpoint_event"`
+	ShopID         string `gorm:"type:varchar(50);index:idx_webhook_delivery_shop_id"`
+	EventID        string `gorm:"type:varchar(50);uniqueIndex:idx_webhook_delivery_endpoint_event"`
+	EventType      string `gorm:"type:varchar(100)"`
+	Payload        string `gorm:"type:text"`
+	Status         int32  `gorm:"default:1;index:idx_webhook_delivery_due,priority:1"`
+	Attempts       int32
+	NextAttemptAt  time.Time `gorm:"index:idx_webhook_delivery_due,priority:2"`
+	LastAttemptAt  *time.Time
+	LastStatusCode int32
+	LastError      string `gorm:"type:text"`
+	DeliveredAt    *time.Time
+}
+
+// WebhookAttempt logs one attempt to deliver a webhook. StatusCode is zero
+// when no response was received.
+type WebhookAttempt struct {
+	data.BaseModel
+	DeliveryID  string `gorm:"type:varchar(50);index:idx_webhook_attempt_delivery_id"`
+	Attempt     int32
+	StatusCode  int32
+	Error       string `gorm:"type:text"`
+	DurationMs  int64
+	AttemptedAt time.Time
+}
+
 // MoneyToProto converts currency/units/nanos to google.type.Money.
 func MoneyToProto(currencyCode string, units int64, nanos int32) *money.Money {
 	if currencyCode == "" {
@@ -0,0 +1,320 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
+	"github.com/pitabwire/frame/datastore"
+	"github.com/pitabwire/frame/datastore/pool"
+	"github.com/pitabwire/frame/workerpool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+const nanosPerUnit = 1_000_000_000
+
+// OrderHour is an hour in which orders of a shop were created.
+type OrderHour struct {
+	ShopID string
+	Hour   time.Time
+}
+
+// VariantSales totals a variant's sales in one currency over a range of days.
+// Revenue nanos are normalised below one unit.
+type VariantSales struct {
+	ProductVariantID string
+	ProductID        string
+	ProductName      string
+	SKU              string
+	VariantName      string
+	Currency         string
+	UnitsSold        int64
+	RevenueUnits     int64
+	RevenueNanos     int64
+}
+
+// ProductSales totals a product's sales in one currency over a range of days.
+// Revenue nanos are normalised below one unit.
+type ProductSales struct {
+	ProductID    string
+	ProductName  string
+	Currency     string
+	UnitsSold    int64
+	RevenueUnits int64
+	RevenueNanos int64
+}
+
+type salesRollupRepository struct {
+	datastore.BaseRepository[*models.SalesDailyRollup]
+}
+
+func NewSalesRollupRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) SalesRollupRepository {
+	return &salesRollupRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.SalesDailyRollup](
+			ctx, dbPool, workMan, func() *models.SalesDailyRollup { return &models.SalesDailyRollup{} },
+		),
+	}
+}
+
+// ListChangedOrderHours lists, per shop, the hours in which the orders
+// changed at or after since were created. It reads the primary so that a
+// refresh does not miss changes the replica has yet to see.
+func (r *salesRollupRepository) ListChangedOrderHours(ctx context.Context, since time.Time) ([]OrderHour, error) {
+	var hours []OrderHour
+	err := r.Pool().DB(ctx, false).
+		Table("orders").
+		Where("orders.modified_at >= ?", since).
+		Select("DISTINCT orders.shop_id AS shop_id, date_trunc('hour', orders.created_at) AS hour").
+		Scan(&hours).Error
+	return hours, err
+}
+
+// salesTotals is a day's order totals in one currency as summed by the
+// database, before nanos are carried into units.
+type salesTotals struct {
+	Currency       string
+	OrderCount     int64
+	CancelledCount int64
+	RefundedCount  int64
+	GrossUnits     int64
+	GrossNanos     int64
+	RefundedUnits  int64
+	RefundedNanos  int64
+}
+
+// variantTotals is a day's sales of one variant in one currency as summed by
+// the database.
+type variantTotals struct {
+	ProductVariantID string
+	Currency         string
+	ProductID        string
+	ProductName      string
+	SKU              string
+	VariantName      string
+	UnitsSold        int64
+	RevenueUnits     int64
+	RevenueNanos     int64
+}
+
+// RefreshDay recomputes the shop's rollups for day from the orders created
+// in [start, end), the bounds of the day in the shop's time zone. The old
+// rollups are replaced in one transaction, so readers see either.
+func (r *salesRollupRepository) RefreshDay(
+	ctx context.Context,
+	shop *models.Shop,
+	day, start, end time.Time,
+) error {
+	cancelled := int32(commercev1.OrderStatus_ORDER_STATUS_CANCELLED)
+	refunded := int32(commercev1.PaymentStatus_PAYMENT_STATUS_REFUNDED)
+
+	return r.Pool().DB(ctx, false).Transaction(func(tx *gorm.DB) error {
+		var totals []salesTotals
+		err := tx.Table("orders").
+			Select(`orders.total_currency AS currency,
+				COUNT(*) AS order_count,
+				COUNT(*) FILTER (WHERE orders.status = @cancelled) AS cancelled_count,
+				COUNT(*) FILTER (WHERE orders.status <> @cancelled AND orders.payment_status = @refunded) AS refunded_count,
+				COALESCE(SUM(orders.total_units) FILTER (WHERE orders.status <> @cancelled), 0) AS gross_units,
+				COALESCE(SUM(orders.total_nanos) FILTER (WHERE orders.status <> @cancelled), 0) AS gross_nanos,
+				COALESCE(SUM(orders.total_units) FILTER (
+					WHERE orders.status <> @cancelled AND orders.payment_status = @refunded), 0) AS refunded_units,
+				COALESCE(SUM(orders.total_nanos) FILTER (
+					WHERE orders.status <> @cancelled AND orders.payment_status = @refunded), 0) AS refunded_nanos`,
+				map[string]any{"cancelled": cancelled, "refunded": refunded}).
+			Where("orders.shop_id = ? AND orders.created_at >= ? AND orders.created_at < ?", shop.GetID(), start, end).
+			Where("orders.deleted_at IS NULL").
+			Group("orders.total_currency").
+			Scan(&totals).Error
+		if err != nil {
+			return err
+		}
+
+		var variants []variantTotals
+		err = tx.Table("order_lines").
+			Joins("JOIN orders ON orders.id = order_lines.order_id").
+			Joins("LEFT JOIN product_variants ON product_variants.id = order_lines.product_variant_id").
+			Joins("LEFT JOIN products ON products.id = product_variants.product_id").
+			Select(`order_lines.product_variant_id AS product_variant_id,
+				order_lines.total_price_currency AS currency,
+				COALESCE(MAX(product_variants.product_id), '') AS product_id,
+				COALESCE(MAX(products.name), '') AS product_name,
+				MAX(order_lines.sku_snapshot) AS sku,
+				MAX(order_lines.name_snapshot) AS variant_name,
+				SUM(order_lines.quantity) AS units_sold,
+				SUM(order_lines.total_price_units) AS revenue_units,
+				SUM(order_lines.total_price_nanos) AS revenue_nanos`).
+			Where("orders.shop_id = ? AND orders.created_at >= ? AND orders.created_at < ?", shop.GetID(), start, end).
+			Where("orders.status <> ? AND orders.deleted_at IS NULL AND order_lines.deleted_at IS NULL", cancelled).
+			Group("order_lines.product_variant_id, order_lines.total_price_currency").
+			Scan(&variants).Error
+		if err != nil {
+			return err
+		}
+
+		if err = tx.Unscoped().Where("shop_id = ? AND day = ?", shop.GetID(), day).
+			Delete(&models.SalesDailyRollup{}).Error; err != nil {
+			return err
+		}
+		if err = tx.Unscoped().Where("shop_id = ? AND day = ?", shop.GetID(), day).
+			Delete(&models.VariantSalesRollup{}).Error; err != nil {
+			return err
+		}
+
+		unitsSold := map[string]int64{}
+		variantRollups := make([]*models.VariantSalesRollup, 0, len(variants))
+		for _, v := range variants {
+			units, nanos := carryNanos(v.RevenueUnits, v.RevenueNanos)
+			rollup := &models.VariantSalesRollup{
+				ShopID:           shop.GetID(),
+				Day:              day,
+				ProductVariantID: v.ProductVariantID,
+				Currency:         v.Currency,
+				ProductID:        v.ProductID,
+				ProductName:      v.ProductName,
+				SKU:              v.SKU,
+				VariantName:      v.VariantName,
+				UnitsSold:        v.UnitsSold,
+				RevenueUnits:     units,
+				RevenueNanos:     int32(nanos),
+			}
+			rollup.CopyPartitionInfo(&shop.BaseModel)
+			variantRollups = append(variantRollups, rollup)
+			unitsSold[v.Currency] += v.UnitsSold
+		}
+
+		salesRollups := make([]*models.SalesDailyRollup, 0, len(totals))
+		for _, t := range totals {
+			grossUnits, grossNanos := carryNanos(t.GrossUnits, t.GrossNanos)
+			refundedUnits, refundedNanos := carryNanos(t.RefundedUnits, t.RefundedNanos)
+			rollup := &models.SalesDailyRollup{
+				ShopID:         shop.GetID(),
+				Day:            day,
+				Currency:       t.Currency,
+				OrderCount:     t.OrderCount,
+				CancelledCount: t.CancelledCount,
+				RefundedCount:  t.RefundedCount,
+				GrossUnits:     grossUnits,
+				GrossNanos:     int32(grossNanos),
+				RefundedUnits:  refundedUnits,
+				RefundedNanos:  int32(refundedNanos),
+				UnitsSold:      unitsSold[t.Currency],
+			}
+			rollup.CopyPartitionInfo(&shop.BaseModel)
+			salesRollups = append(salesRollups, rollup)
+		}
+
+		if len(salesRollups) > 0 {
+			if err = tx.Create(salesRollups).Error; err != nil {
+				return err
+			}
+		}
+		if len(variantRollups) > 0 {
+			if err = tx.Create(variantRollups).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListDaily lists the shop's daily rollups from the day from through the day
+// to, in day then currency order.
+func (r *salesRollupRepository) ListDaily(
+	ctx context.Context,
+	shopID string,
+	from, to time.Time,
+) ([]*models.SalesDailyRollup, error) {
+	var rollups []*models.SalesDailyRollup
+	err := r.Pool().DB(ctx, true).
+		Where("shop_id = ? AND day >= ? AND day <= ?", shopID, from, to).
+		Order("day ASC, currency ASC").
+		Find(&rollups).Error
+	return rollups, err
+}
+
+// ListVariantSales totals each variant's sales from the day from through the
+// day to, best selling first.
+func (r *salesRollupRepository) ListVariantSales(
+	ctx context.Context,
+	shopID string,
+	from, to time.Time,
+	limit, offset int,
+) ([]VariantSales, error) {
+	var sales []VariantSales
+	query := r.Pool().DB(ctx, true).
+		Model(&models.VariantSalesRollup{}).
+		Select(`product_variant_id, currency,
+			MAX(product_id) AS product_id, MAX(product_name) AS product_name,
+			MAX(sku) AS sku, MAX(variant_name) AS variant_name,
+			SUM(units_sold) AS units_sold, SUM(revenue_units) AS revenue_units, SUM(revenue_nanos) AS revenue_nanos`).
+		Where("shop_id = ? AND day >= ? AND day <= ?", shopID, from, to).
+		Group("product_variant_id, currency").
+		Order("units_sold DESC, product_variant_id ASC")
+	err := paginate(query, limit, offset).Scan(&sales).Error
+	for i := range sales {
+		sales[i].RevenueUnits, sales[i].RevenueNanos = carryNanos(sales[i].RevenueUnits, sales[i].RevenueNanos)
+	}
+	return sales, err
+}
+
+// ListProductSales totals each product's sales from the day from through the
+// day to, best selling first.
+func (r *salesRollupRepository) ListProductSales(
+	ctx context.Context,
+	shopID string,
+	from, to time.Time,
+	limit int,
+) ([]ProductSales, error) {
+	var sales []ProductSales
+	query := r.Pool().DB(ctx, true).
+		Model(&models.VariantSalesRollup{}).
+		Select(`product_id, currency, MAX(product_name) AS product_name,
+			SUM(units_sold) AS units_sold, SUM(revenue_units) AS revenue_units, SUM(revenue_nanos) AS revenue_nanos`).
+		Where("shop_id = ? AND day >= ? AND day <= ?", shopID, from, to).
+		Group("product_id, currency").
+		Order("units_sold DESC, product_id ASC")
+	err := paginate(query, limit, 0).Scan(&sales).Error
+	for i := range sales {
+		sales[i].RevenueUnits, sales[i].RevenueNanos = carryNanos(sales[i].RevenueUnits, sales[i].RevenueNanos)
+	}
+	return sales, err
+}
+
+// GetWatermark returns how far the named rollup has been refreshed, or the
+// zero time when it never was.
+func (r *salesRollupRepository) GetWatermark(ctx context.Context, name string) (time.Time, error) {
+	watermark := &models.RollupWatermark{}
+	err := r.Pool().DB(ctx, false).First(watermark, "name = ?", name).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	}
+	return watermark.RefreshedTo, err
+}
+
+// AdvanceWatermark moves the named rollup's watermark forward to refreshedTo.
+// A watermark already past it is left alone.
+func (r *salesRollupRepository) AdvanceWatermark(ctx context.Context, name string, refreshedTo time.Time) error {
+	watermark := &models.RollupWatermark{Name: name, RefreshedTo: refreshedTo}
+	return r.Pool().DB(ctx, false).
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "name"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"refreshed_to": gorm.Expr("GREATEST(rollup_watermarks.refreshed_to, excluded.refreshed_to)"),
+				"modified_at":  time.Now(),
+			}),
+		}).
+		Create(watermark).Error
+}
+
+// carryNanos moves whole units out of a summed nanos amount.
+func carryNanos(units, nanos int64) (int64, int64) {
+	return units + nanos/nanosPerUnit, nanos % nanosPerUnit
+}
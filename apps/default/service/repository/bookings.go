@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/pitabwire/frame/datastore"
+	"github.com/pitabwire/frame/datastore/pool"
+	"github.com/pitabwire/frame/workerpool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+type bookingSlotRepository struct {
+	datastore.BaseRepository[*models.BookingSlot]
+}
+
+func NewBookingSlotRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) BookingSlotRepository {
+	return &bookingSlotRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.BookingSlot](
+			ctx, dbPool, workMan, func() *models.BookingSlot { return &models.BookingSlot{} },
+		),
+	}
+}
+
+// TryCreate inserts the slot and reports whether a row was written. It returns
+// false without an error when the variant already has a slot at that start.
+func (r *bookingSlotRepository) TryCreate(ctx context.Context, slot *models.BookingSlot) (bool, error) {
+	result := r.Pool().DB(ctx, false).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(slot)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListByProductID lists the product's slots starting within [from, to).
+func (r *bookingSlotRepository) ListByProductID(
+	ctx context.Context,
+	productID string,
+	from, to time.Time,
+) ([]*models.BookingSlot, error) {
+	var slots []*models.BookingSlot
+	err := r.Pool().DB(ctx, true).
+		Where("product_id = ? AND starts_at >= ? AND starts_at < ?", productID, from, to).
+		Order("starts_at ASC, product_variant_id ASC").
+		Find(&slots).Error
+	return slots, err
+}
+
+// Reserve holds quantity places of the slot for the cart until holdUntil,
+// adding them to the cart's line for the slot or creating that line. The
+// capacity check, the cart line and the reservation are written in one
+// transaction; ErrSlotUnavailable is returned when the slot cannot take them.
+func (r *bookingSlotRepository) Reserve(
+	ctx context.Context,
+	cartID string,
+	slot *models.BookingSlot,
+	quantity int64,
+	holdUntil time.Time,
+) (*models.CartLine, error) {
+	line := &models.CartLine{}
+	err := r.Pool().DB(ctx, false).Transaction(func(tx *gorm.DB) error {
+		held := tx.Model(&models.BookingSlot{}).
+			Where("id = ? AND status = ? AND reserved + ? <= capacity", slot.GetID(), models.SlotStatusOpen, quantity).
+			UpdateColumn("reserved", gorm.Expr("reserved + ?", quantity))
+		if held.Error != nil {
+			return held.Error
+		}
+		if held.RowsAffected == 0 {
+			return ErrSlotUnavailable
+		}
+
+		findErr := tx.Where("cart_id = ? AND slot_id = ?", cartID, slot.GetID()).First(line).Error
+		if findErr != nil && !errors.Is(findErr, gorm.ErrRecordNotFound) {
+			return findErr
+		}
+
+		if findErr == nil {
+			if err := tx.Model(line).
+				UpdateColumn("quantity", gorm.Expr("quantity + ?", quantity)).Error; err != nil {
+				return err
+			}
+			line.Quantity += quantity
+			return tx.Model(&models.SlotReservation{}).
+				Where("cart_line_id = ?", line.GetID()).
+				UpdateColumns(map[string]any{
+					"quantity":   gorm.Expr("quantity + ?", quantity),
+					"expires_at": holdUntil,
+				}).Error
+		}
+
+		line = &models.CartLine{
+			CartID:           cartID,
+			ProductVariantID: slot.ProductVariantID,
+			Quantity:         quantity,
+			SlotID:           slot.GetID(),
+		}
+		line.CopyPartitionInfo(&slot.BaseModel)
+		if err := tx.Create(line).Error; err != nil {
+			return err
+		}
+		reservation := &models.SlotReservation{
+			SlotID:     slot.GetID(),
+			CartID:     cartID,
+			CartLineID: line.GetID(),
+			Quantity:   quantity,
+			Status:     models.ReservationStatusHeld,
+			ExpiresAt:  holdUntil,
+		}
+		reservation.CopyPartitionInfo(&slot.BaseModel)
+		return tx.Create(reservation).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return line, nil
+}
+
+type slotReservationRepository struct {
+	datastore.BaseRepository[*models.SlotReservation]
+}
+
+func NewSlotReservationRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) SlotReservationRepository {
+	return &slotReservationRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.SlotReservation](
+			ctx, dbPool, workMan, func() *models.SlotReservation { return &models.SlotReservation{} },
+		),
+	}
+}
+
+func (r *slotReservationRepository) GetByCartLineID(
+	ctx context.Context,
+	cartLineID string,
+) (*models.SlotReservation, error) {
+	reservation := &models.SlotReservation{}
+	err := r.Pool().DB(ctx, false).First(reservation, "cart_line_id = ?", cartLineID).Error
+	return reservation, err
+}
+
+func (r *slotReservationRepository) ListByOrderID(
+	ctx context.Context,
+	orderID string,
+) ([]*models.SlotReservation, error) {
+	var reservations []*models.SlotReservation
+	err := r.Pool().DB(ctx, false).Where("order_id = ?", orderID).Find(&reservations).Error
+	return reservations, err
+}
+
+// ListExpiredHolds lists held reservations whose hold ended before now.
+func (r *slotReservationRepository) ListExpiredHolds(
+	ctx context.Context,
+	now time.Time,
+	limit int,
+) ([]*models.SlotReservation, error) {
+	var reservations []*models.SlotReservation
+	err := r.Pool().DB(ctx, false).
+		Where("status = ? AND expires_at < ?", models.ReservationStatusHeld, now).
+		Order("expires_at ASC").
+		Limit(limit).
+		Find(&reservations).Error
+	return reservations, err
+}
+
+// Release returns the reservation's places to its slot when the reservation
+// is in one of the "from" states, reporting whether it was released. A held
+// reservation also drops the cart line it was made for.
+func (r *slotReservationRepository) Release(ctx context.Context, reservationID string, from []int32) (bool, error) {
+	released := false
+	err := r.Pool().DB(ctx, false).Transaction(func(tx *gorm.DB) error {
+		reservation := &models.SlotReservation{}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(reservation, "id = ?", reservationID).Error; err != nil {
+			return err
+		}
+		if !slices.Contains(from, reservation.Status) {
+			return nil
+		}
+
+		if err := tx.Model(reservation).UpdateColumns(map[string]any{
+			"status":      models.ReservationStatusReleased,
+			"modified_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		released = true
+
+		if err := tx.Model(&models.BookingSlot{}).
+			Where("id = ?", reservation.SlotID).
+			UpdateColumn("reserved", gorm.Expr("GREATEST(reserved - ?, 0)", reservation.Quantity)).Error; err != nil {
+			return err
+		}
+
+		if reservation.Status != models.ReservationStatusHeld {
+			return nil
+		}
+		return tx.Where("id = ?", reservation.CartLineID).Delete(&models.CartLine{}).Error
+	})
+	return released, err
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/pitabwire/frame/datastore"
+	"github.com/pitabwire/frame/datastore/pool"
+	"github.com/pitabwire/frame/workerpool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+type bundleComponentRepository struct {
+	datastore.BaseRepository[*models.BundleComponent]
+}
+
+func NewBundleComponentRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) BundleComponentRepository {
+	return &bundleComponentRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.BundleComponent](
+			ctx, dbPool, workMan, func() *models.BundleComponent { return &models.BundleComponent{} },
+		),
+	}
+}
+
+// ListByBundleID lists the bundle's components with their variants loaded.
+func (r *bundleComponentRepository) ListByBundleID(
+	ctx context.Context,
+	bundleVariantID string,
+) ([]*models.BundleComponent, error) {
+	var components []*models.BundleComponent
+	err := r.Pool().DB(ctx, true).
+		Preload(clause.Associations).
+		Where("bundle_variant_id = ?", bundleVariantID).
+		Order("created_at ASC").
+		Find(&components).Error
+	return components, err
+}
+
+// ExistsForComponent reports whether the variant is a component of any bundle.
+func (r *bundleComponentRepository) ExistsForComponent(ctx context.Context, variantID string) (bool, error) {
+	var count int64
+	err := r.Pool().DB(ctx, true).
+		Model(&models.BundleComponent{}).
+		Where("component_variant_id = ?", variantID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Replace makes components the bundle's full composition and marks the
+// variant as a bundle, in one transaction.
+func (r *bundleComponentRepository) Replace(
+	ctx context.Context,
+	bundle *models.ProductVariant,
+	components []*models.BundleComponent,
+) error {
+	return r.Pool().DB(ctx, false).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("bundle_variant_id = ?", bundle.GetID()).
+			Delete(&models.BundleComponent{}).Error; err != nil {
+			return err
+		}
+
+		for _, component := range components {
+			component.BundleVariantID = bundle.GetID()
+			component.CopyPartitionInfo(&bundle.BaseModel)
+		}
+		if err := tx.Omit(clause.Associations).Create(components).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.ProductVariant{}).
+			Where("id = ?", bundle.GetID()).
+			UpdateColumns(map[string]any{
+				"kind":           models.VariantKindBundle,
+				"stock_quantity": 0,
+				"modified_at":    time.Now(),
+				"version":        gorm.Expr("version + 1"),
+			}).Error
+	})
+}
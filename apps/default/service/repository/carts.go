@@ -52,7 +52,7 @@ func (r *cartLineRepository) GetByCartID(ctx context.Context, cartID string) ([]
 func (r *cartLineRepository) GetByCartAndVariant(ctx context.Context, cartID, variantID string) (*models.CartLine, error) {
 	line := &models.CartLine{}
 	err := r.Pool().DB(ctx, true).
-		Where("cart_id = ? AND product_variant_id = ?", cartID, variantID).
+		Where("cart_id = ? AND product_variant_id = ? AND slot_id = ''", cartID, variantID).
 		First(line).Error
 	return line, err
 }
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/pitabwire/frame/datastore"
+	"github.com/pitabwire/frame/datastore/pool"
+	"github.com/pitabwire/frame/workerpool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+type digitalAssetRepository struct {
+	datastore.BaseRepository[*models.DigitalAsset]
+}
+
+func NewDigitalAssetRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) DigitalAssetRepository {
+	return &digitalAssetRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.DigitalAsset](
+			ctx, dbPool, workMan, func() *models.DigitalAsset { return &models.DigitalAsset{} },
+		),
+	}
+}
+
+func (r *digitalAssetRepository) GetByVariantID(ctx context.Context, variantID string) (*models.DigitalAsset, error) {
+	asset := &models.DigitalAsset{}
+	err := r.Pool().DB(ctx, false).First(asset, "product_variant_id = ?", variantID).Error
+	return asset, err
+}
+
+type entitlementRepository struct {
+	datastore.BaseRepository[*models.Entitlement]
+}
+
+func NewEntitlementRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) EntitlementRepository {
+	return &entitlementRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.Entitlement](
+			ctx, dbPool, workMan, func() *models.Entitlement { return &models.Entitlement{} },
+		),
+	}
+}
+
+func (r *entitlementRepository) ListByOrderID(ctx context.Context, orderID string) ([]*models.Entitlement, error) {
+	var entitlements []*models.Entitlement
+	err := r.Pool().DB(ctx, false).
+		Where("order_id = ?", orderID).
+		Order("created_at ASC").
+		Find(&entitlements).Error
+	return entitlements, err
+}
+
+func (r *entitlementRepository) ListByProfileID(
+	ctx context.Context,
+	profileID string,
+	limit, offset int,
+) ([]*models.Entitlement, error) {
+	var entitlements []*models.Entitlement
+	query := r.Pool().DB(ctx, true).Where("profile_id = ?", profileID).Order("created_at DESC")
+	err := paginate(query, limit, offset).Find(&entitlements).Error
+	return entitlements, err
+}
+
+func (r *entitlementRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*models.Entitlement, error) {
+	entitlement := &models.Entitlement{}
+	err := r.Pool().DB(ctx, false).First(entitlement, "token_hash = ?", tokenHash).Error
+	return entitlement, err
+}
+
+// ConsumeDownload counts a download against the entitlement holding the
+// token. It reports false when the token is unknown, expired at now or has
+// no downloads left.
+func (r *entitlementRepository) ConsumeDownload(ctx context.Context, tokenHash string, now time.Time) (bool, error) {
+	result := r.Pool().DB(ctx, false).
+		Model(&models.Entitlement{}).
+		Where("token_hash = ? AND expires_at > ? AND download_count < max_downloads", tokenHash, now).
+		UpdateColumns(map[string]any{
+			"download_count": gorm.Expr("download_count + 1"),
+			"modified_at":    now,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// errAlreadyEntitled rolls back CreateWithFulfilment when an order line
+// already carries an entitlement.
+var errAlreadyEntitled = errors.New("order line is already entitled")
+
+// CreateWithFulfilment persists the entitlements together with the
+// fulfilment delivering them in one transaction. It returns false without an
+// error when any of the order lines already has an entitlement.
+func (r *entitlementRepository) CreateWithFulfilment(
+	ctx context.Context,
+	entitlements []*models.Entitlement,
+	fulfilment *models.Fulfilment,
+	lines []*models.FulfilmentLine,
+) (bool, error) {
+	err := r.Pool().DB(ctx, false).Transaction(func(tx *gorm.DB) error {
+		for _, entitlement := range entitlements {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(entitlement)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errAlreadyEntitled
+			}
+		}
+
+		if err := tx.Create(fulfilment).Error; err != nil {
+			return err
+		}
+		for _, line := range lines {
+			line.FulfilmentID = fulfilment.GetID()
+			line.CopyPartitionInfo(&fulfilment.BaseModel)
+		}
+		if len(lines) > 0 {
+			return tx.Create(lines).Error
+		}
+		return nil
+	})
+
+	if errors.Is(err, errAlreadyEntitled) {
+		return false, nil
+	}
+	return err == nil, err
+}
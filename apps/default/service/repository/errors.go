@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCartNotActive is returned when a cart can no longer be converted into an order.
+var ErrCartNotActive = errors.New("cart is not active")
+
+// ErrCatalogConflict is returned when an imported product's slug or one of
+// its SKUs was taken, or an updated row changed, by a concurrent write.
+var ErrCatalogConflict = errors.New("product slug or sku is already in use")
+
+// ErrSlotUnavailable is returned when a booking slot is closed or lacks the
+// capacity for a reservation.
+var ErrSlotUnavailable = errors.New("booking slot is unavailable")
+
+// ErrReservationNotHeld is returned when a cart's slot reservation was
+// released before the cart could be converted.
+var ErrReservationNotHeld = errors.New("slot reservation is no longer held")
+
+// InsufficientStockError is returned when a stock decrement would take a
+// variant below zero. Line is the position of the order line that took the
+// stock and Available what the variant held when the decrement failed.
+type InsufficientStockError struct {
+	Line      int
+	VariantID string
+	Requested int64
+	Available int64
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("insufficient stock for variant %s: requested %d, available %d",
+		e.VariantID, e.Requested, e.Available)
+}
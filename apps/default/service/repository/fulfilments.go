@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
 	"github.com/pitabwire/frame/datastore"
 	"github.com/pitabwire/frame/datastore/pool"
 	"github.com/pitabwire/frame/workerpool"
@@ -39,6 +40,39 @@ func (r *fulfilmentRepository) ListByOrderID(ctx context.Context, orderID string
 	return fulfilments, err
 }
 
+// GetByTrackingNumber finds the fulfilment shipped with the carrier under the
+// tracking number.
+func (r *fulfilmentRepository) GetByTrackingNumber(
+	ctx context.Context,
+	carrier, trackingNumber string,
+) (*models.Fulfilment, error) {
+	fulfilment := &models.Fulfilment{}
+	err := r.Pool().DB(ctx, false).
+		Where("carrier = ? AND tracking_number = ?", carrier, trackingNumber).
+		Order("created_at DESC").
+		First(fulfilment).Error
+	return fulfilment, err
+}
+
+// ListByShopAndStatus lists the shop's fulfilments in the given statuses with
+// their lines, oldest first.
+func (r *fulfilmentRepository) ListByShopAndStatus(
+	ctx context.Context,
+	shopID string,
+	statuses []int32,
+	limit int,
+) ([]*models.Fulfilment, error) {
+	var fulfilments []*models.Fulfilment
+	shopOrders := r.Pool().DB(ctx, true).Model(&models.Order{}).Select("id").Where("shop_id = ?", shopID)
+	err := r.Pool().DB(ctx, true).
+		Preload("Lines").
+		Where("order_id IN (?) AND status IN ?", shopOrders, statuses).
+		Order("created_at ASC").
+		Limit(limit).
+		Find(&fulfilments).Error
+	return fulfilments, err
+}
+
 type fulfilmentLineRepository struct {
 	datastore.BaseRepository[*models.FulfilmentLine]
 }
@@ -57,12 +91,88 @@ func (r *fulfilmentLineRepository) GetByFulfilmentID(ctx context.Context, fulfil
 	return lines, err
 }
 
+// GetFulfilledQuantityByOrderLineID sums the quantity of the order line held
+// by fulfilments. Cancelled fulfilments release their quantity. The query
+// names its table, rather than its model, so that frame's tenancy scope
+// qualifies tenant_id, which both joined tables carry.
 func (r *fulfilmentLineRepository) GetFulfilledQuantityByOrderLineID(ctx context.Context, orderLineID string) (int64, error) {
 	var total int64
 	err := r.Pool().DB(ctx, true).
-		Model(&models.FulfilmentLine{}).
-		Where("order_line_id = ?", orderLineID).
-		Select("COALESCE(SUM(quantity), 0)").
+		Table("fulfilment_lines").
+		Joins("JOIN fulfilments ON fulfilments.id = fulfilment_lines.fulfilment_id").
+		Where("fulfilment_lines.order_line_id = ? AND fulfilments.status <> ?",
+			orderLineID, int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_CANCELLED)).
+		Where("fulfilments.deleted_at IS NULL AND fulfilment_lines.deleted_at IS NULL").
+		Select("COALESCE(SUM(fulfilment_lines.quantity), 0)").
 		Scan(&total).Error
 	return total, err
 }
+
+// FulfilmentQuantity is the quantity of an order line held by fulfilments in
+// one status.
+type FulfilmentQuantity struct {
+	OrderLineID string
+	Status      int32
+	Quantity    int64
+}
+
+// ListQuantitiesByOrderID sums the order's fulfilment line quantities per
+// order line and fulfilment status.
+func (r *fulfilmentLineRepository) ListQuantitiesByOrderID(
+	ctx context.Context,
+	orderID string,
+) ([]FulfilmentQuantity, error) {
+	var quantities []FulfilmentQuantity
+	err := r.Pool().DB(ctx, false).
+		Table("fulfilment_lines").
+		Joins("JOIN fulfilments ON fulfilments.id = fulfilment_lines.fulfilment_id").
+		Where("fulfilments.order_id = ? AND fulfilments.deleted_at IS NULL", orderID).
+		Where("fulfilment_lines.deleted_at IS NULL").
+		Select("fulfilment_lines.order_line_id AS order_line_id, fulfilments.status AS status, " +
+			"SUM(fulfilment_lines.quantity) AS quantity").
+		Group("fulfilment_lines.order_line_id, fulfilments.status").
+		Scan(&quantities).Error
+	return quantities, err
+}
+
+type fulfilmentTrackingEventRepository struct {
+	datastore.BaseRepository[*models.FulfilmentTrackingEvent]
+}
+
+func NewFulfilmentTrackingEventRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) FulfilmentTrackingEventRepository {
+	return &fulfilmentTrackingEventRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.FulfilmentTrackingEvent](
+			ctx, dbPool, workMan, func() *models.FulfilmentTrackingEvent { return &models.FulfilmentTrackingEvent{} },
+		),
+	}
+}
+
+// TryCreate inserts the event and reports whether a row was written. It
+// returns false without an error when the carrier already reported the event.
+func (r *fulfilmentTrackingEventRepository) TryCreate(
+	ctx context.Context,
+	event *models.FulfilmentTrackingEvent,
+) (bool, error) {
+	result := r.Pool().DB(ctx, false).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(event)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListByFulfilmentID lists the fulfilment's tracking events in the order they
+// happened.
+func (r *fulfilmentTrackingEventRepository) ListByFulfilmentID(
+	ctx context.Context,
+	fulfilmentID string,
+) ([]*models.FulfilmentTrackingEvent, error) {
+	var events []*models.FulfilmentTrackingEvent
+	err := r.Pool().DB(ctx, true).
+		Where("fulfilment_id = ?", fulfilmentID).
+		Order("occurred_at ASC").
+		Find(&events).Error
+	return events, err
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/pitabwire/frame/datastore"
+	"github.com/pitabwire/frame/datastore/pool"
+	"github.com/pitabwire/frame/workerpool"
+	"gorm.io/gorm/clause"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+type idempotencyRepository struct {
+	datastore.BaseRepository[*models.IdempotencyRecord]
+}
+
+func NewIdempotencyRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) IdempotencyRepository {
+	return &idempotencyRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.IdempotencyRecord](
+			ctx, dbPool, workMan, func() *models.IdempotencyRecord { return &models.IdempotencyRecord{} },
+		),
+	}
+}
+
+// GetByScope reads from the primary so that a retry racing the original
+// request never misses a freshly claimed key on a lagging replica.
+func (r *idempotencyRepository) GetByScope(
+	ctx context.Context,
+	shopID, callerID, operation, key string,
+) (*models.IdempotencyRecord, error) {
+	record := &models.IdempotencyRecord{}
+	err := r.Pool().DB(ctx, false).
+		Where("shop_id = ? AND caller_id = ? AND operation = ? AND idempotency_key = ?",
+			shopID, callerID, operation, key).
+		First(record).Error
+	return record, err
+}
+
+// TryCreate claims the key and reports whether this caller won the claim.
+func (r *idempotencyRepository) TryCreate(ctx context.Context, record *models.IdempotencyRecord) (bool, error) {
+	result := r.Pool().DB(ctx, false).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(record)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Release hard deletes a claim so that the key can be claimed again.
+func (r *idempotencyRepository) Release(ctx context.Context, id string) error {
+	return r.Pool().DB(ctx, false).
+		Unscoped().
+		Where("id = ?", id).
+		Delete(&models.IdempotencyRecord{}).Error
+}
+
+// DeleteExpired hard deletes up to limit claims that expired before the given
+// time, returning how many were deleted. Expired claims are never replayed,
+// so they only take up space.
+func (r *idempotencyRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	expired := r.Pool().DB(ctx, false).
+		Model(&models.IdempotencyRecord{}).
+		Unscoped().
+		Select("id").
+		Where("expires_at < ?", before).
+		Limit(limit)
+	result := r.Pool().DB(ctx, false).
+		Unscoped().
+		Where("id IN (?)", expired).
+		Delete(&models.IdempotencyRecord{})
+	return int(result.RowsAffected), result.Error
+}
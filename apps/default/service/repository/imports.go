@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/pitabwire/frame/datastore"
+	"github.com/pitabwire/frame/datastore/pool"
+	"github.com/pitabwire/frame/workerpool"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+type catalogImportRepository struct {
+	datastore.BaseRepository[*models.CatalogImport]
+}
+
+func NewCatalogImportRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) CatalogImportRepository {
+	return &catalogImportRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.CatalogImport](
+			ctx, dbPool, workMan, func() *models.CatalogImport { return &models.CatalogImport{} },
+		),
+	}
+}
+
+// Start moves a queued import to running. It returns false when the import
+// is not queued, so a job that is submitted twice runs once.
+func (r *catalogImportRepository) Start(ctx context.Context, id string, now time.Time) (bool, error) {
+	result := r.Pool().DB(ctx, false).
+		Model(&models.CatalogImport{}).
+		Where("id = ? AND status = ?", id, models.ImportStatusQueued).
+		Updates(map[string]any{
+			"status":     models.ImportStatusRunning,
+			"started_at": now,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// SaveProgress stores the import's status, counters and row errors. Once the
+// import has finished its source is cleared.
+func (r *catalogImportRepository) SaveProgress(ctx context.Context, job *models.CatalogImport) error {
+	columns := map[string]any{
+		"status":           job.Status,
+		"processed_rows":   job.ProcessedRows,
+		"created_products": job.CreatedProducts,
+		"updated_products": job.UpdatedProducts,
+		"created_variants": job.CreatedVariants,
+		"updated_variants": job.UpdatedVariants,
+		"failed_rows":      job.FailedRows,
+		"errors":           job.Errors,
+		"message":          job.Message,
+		"finished_at":      job.FinishedAt,
+	}
+	if job.FinishedAt != nil {
+		columns["source"] = ""
+	}
+	return r.Pool().DB(ctx, false).
+		Model(&models.CatalogImport{}).
+		Where("id = ?", job.GetID()).
+		Updates(columns).Error
+}
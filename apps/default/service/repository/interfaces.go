@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/pitabwire/frame/datastore"
 
@@ -11,18 +12,92 @@ import (
 type ShopRepository interface {
 	datastore.BaseRepository[*models.Shop]
 	GetBySlug(ctx context.Context, slug string) (*models.Shop, error)
+	TryCreate(ctx context.Context, shop *models.Shop, owner *models.ShopMember) (bool, error)
+}
+
+type ShopSequenceRepository interface {
+	datastore.BaseRepository[*models.ShopSequence]
+	NextValue(ctx context.Context, shopID, name string) (int64, error)
+}
+
+type ShopMemberRepository interface {
+	datastore.BaseRepository[*models.ShopMember]
+	GetByShopAndProfile(ctx context.Context, shopID, profileID string) (*models.ShopMember, error)
+	ListByShopID(ctx context.Context, shopID string) ([]*models.ShopMember, error)
+	Remove(ctx context.Context, shopID, profileID string) error
 }
 
 type ProductRepository interface {
 	datastore.BaseRepository[*models.Product]
 	ListByShopID(ctx context.Context, shopID string, limit, offset int) ([]*models.Product, error)
+	ListForExport(ctx context.Context, shopID, afterID string, limit int) ([]*models.Product, error)
+	GetBySlug(ctx context.Context, shopID, slug string) (*models.Product, error)
+	TryCreate(ctx context.Context, product *models.Product) (bool, error)
+	SaveWithVariants(
+		ctx context.Context, product *models.Product, productColumns []string,
+		variants []*models.ProductVariant, variantColumns []string,
+	) error
+	ListByCategoryIDs(ctx context.Context, shopID string, categoryIDs []string, limit, offset int) ([]*models.Product, error)
+	ListByCollectionID(ctx context.Context, collectionID string, limit, offset int) ([]*models.Product, error)
+	ListByRules(
+		ctx context.Context, shopID string, rules models.CollectionRules, matchAny bool, limit, offset int,
+	) ([]*models.Product, error)
+}
+
+type CategoryRepository interface {
+	datastore.BaseRepository[*models.Category]
+	GetBySlug(ctx context.Context, shopID, slug string) (*models.Category, error)
+	ListByShopID(ctx context.Context, shopID string) ([]*models.Category, error)
+}
+
+type ProductCategoryRepository interface {
+	datastore.BaseRepository[*models.ProductCategory]
+	ListCategoryIDs(ctx context.Context, productID string) ([]string, error)
+	Replace(ctx context.Context, productID string, categoryIDs []string) error
+}
+
+type CollectionRepository interface {
+	datastore.BaseRepository[*models.Collection]
+	GetBySlug(ctx context.Context, shopID, slug string) (*models.Collection, error)
+	ListByShopID(ctx context.Context, shopID string) ([]*models.Collection, error)
+}
+
+type CollectionProductRepository interface {
+	datastore.BaseRepository[*models.CollectionProduct]
+	Replace(ctx context.Context, collectionID string, productIDs []string) error
+}
+
+type ProductOptionRepository interface {
+	datastore.BaseRepository[*models.ProductOption]
+	ListByProductID(ctx context.Context, productID string) ([]*models.ProductOption, error)
+	Remove(ctx context.Context, id string) error
 }
 
 type ProductVariantRepository interface {
 	datastore.BaseRepository[*models.ProductVariant]
 	ListByProductID(ctx context.Context, productID string) ([]*models.ProductVariant, error)
+	GetBySKU(ctx context.Context, shopID, sku string) (*models.ProductVariant, error)
+	TryCreate(ctx context.Context, variant *models.ProductVariant) (bool, error)
 	DecrementStock(ctx context.Context, variantID string, quantity int64) error
 	IncrementStock(ctx context.Context, variantID string, quantity int64) error
+	ListByIDs(ctx context.Context, ids []string) ([]*models.ProductVariant, error)
+}
+
+type CatalogImportRepository interface {
+	datastore.BaseRepository[*models.CatalogImport]
+	Start(ctx context.Context, id string, now time.Time) (bool, error)
+	SaveProgress(ctx context.Context, job *models.CatalogImport) error
+}
+
+type SalesRollupRepository interface {
+	datastore.BaseRepository[*models.SalesDailyRollup]
+	ListChangedOrderHours(ctx context.Context, since time.Time) ([]OrderHour, error)
+	RefreshDay(ctx context.Context, shop *models.Shop, day, start, end time.Time) error
+	ListDaily(ctx context.Context, shopID string, from, to time.Time) ([]*models.SalesDailyRollup, error)
+	ListVariantSales(ctx context.Context, shopID string, from, to time.Time, limit, offset int) ([]VariantSales, error)
+	ListProductSales(ctx context.Context, shopID string, from, to time.Time, limit int) ([]ProductSales, error)
+	GetWatermark(ctx context.Context, name string) (time.Time, error)
+	AdvanceWatermark(ctx context.Context, name string, refreshedTo time.Time) error
 }
 
 type CartRepository interface {
@@ -39,8 +114,16 @@ type CartLineRepository interface {
 type OrderRepository interface {
 	datastore.BaseRepository[*models.Order]
 	GetWithLines(ctx context.Context, id string) (*models.Order, error)
-	GetByIdempotencyKey(ctx context.Context, key string) (*models.Order, error)
+	GetByIdempotencyKey(ctx context.Context, shopID, key string) (*models.Order, error)
+	GetByCartID(ctx context.Context, cartID string) (*models.Order, error)
+	CreateWithLines(ctx context.Context, order *models.Order, lines []*models.OrderLine) (bool, error)
 	ListByShopID(ctx context.Context, shopID string, limit, offset int) ([]*models.Order, error)
+	ListByProfile(ctx context.Context, filter OrderHistoryFilter, limit, offset int) ([]*models.Order, error)
+	ListForExport(ctx context.Context, filter OrderExportFilter, afterID string, limit int) ([]*models.Order, error)
+	SetFulfilmentStatus(ctx context.Context, orderID string, fulfilmentStatus int32, fulfilled bool) error
+	TransitionPaymentStatus(ctx context.Context, orderID string, from []int32, to int32) (bool, error)
+	Cancel(ctx context.Context, orderID string) (bool, error)
+	ReleaseStock(ctx context.Context, orderID string) (bool, error)
 }
 
 type OrderLineRepository interface {
@@ -52,10 +135,109 @@ type FulfilmentRepository interface {
 	datastore.BaseRepository[*models.Fulfilment]
 	GetWithLines(ctx context.Context, id string) (*models.Fulfilment, error)
 	ListByOrderID(ctx context.Context, orderID string) ([]*models.Fulfilment, error)
+	GetByTrackingNumber(ctx context.Context, carrier, trackingNumber string) (*models.Fulfilment, error)
+	ListByShopAndStatus(ctx context.Context, shopID string, statuses []int32, limit int) ([]*models.Fulfilment, error)
+}
+
+type FulfilmentTrackingEventRepository interface {
+	datastore.BaseRepository[*models.FulfilmentTrackingEvent]
+	TryCreate(ctx context.Context, event *models.FulfilmentTrackingEvent) (bool, error)
+	ListByFulfilmentID(ctx context.Context, fulfilmentID string) ([]*models.FulfilmentTrackingEvent, error)
 }
 
 type FulfilmentLineRepository interface {
 	datastore.BaseRepository[*models.FulfilmentLine]
 	GetByFulfilmentID(ctx context.Context, fulfilmentID string) ([]*models.FulfilmentLine, error)
 	GetFulfilledQuantityByOrderLineID(ctx context.Context, orderLineID string) (int64, error)
+	ListQuantitiesByOrderID(ctx context.Context, orderID string) ([]FulfilmentQuantity, error)
+}
+
+type InvoiceRepository interface {
+	datastore.BaseRepository[*models.Invoice]
+	GetWithLines(ctx context.Context, id string) (*models.Invoice, error)
+	GetByOrderAndKind(ctx context.Context, orderID string, kind int32) (*models.Invoice, error)
+	ListByOrderID(ctx context.Context, orderID string) ([]*models.Invoice, error)
+	Issue(ctx context.Context, invoice *models.Invoice, sequence string, number func(seq int64) string) (bool, error)
+}
+
+type WebhookEndpointRepository interface {
+	datastore.BaseRepository[*models.WebhookEndpoint]
+	ListByShopID(ctx context.Context, shopID string) ([]*models.WebhookEndpoint, error)
+}
+
+type WebhookDeliveryRepository interface {
+	datastore.BaseRepository[*models.WebhookDelivery]
+	TryCreate(ctx context.Context, delivery *models.WebhookDelivery) (bool, error)
+	Claim(ctx context.Context, id string, now time.Time, lease time.Duration) (bool, error)
+	RecordAttempt(ctx context.Context, delivery *models.WebhookDelivery, attempt *models.WebhookAttempt) error
+	Requeue(ctx context.Context, id string, now time.Time) error
+	ListDue(ctx context.Context, now time.Time, limit int) ([]*models.WebhookDelivery, error)
+	ListByEndpointID(ctx context.Context, endpointID string, status int32, limit int) ([]*models.WebhookDelivery, error)
+	ListAttempts(ctx context.Context, deliveryID string) ([]*models.WebhookAttempt, error)
+}
+
+type IdempotencyRepository interface {
+	datastore.BaseRepository[*models.IdempotencyRecord]
+	GetByScope(ctx context.Context, shopID, callerID, operation, key string) (*models.IdempotencyRecord, error)
+	TryCreate(ctx context.Context, record *models.IdempotencyRecord) (bool, error)
+	Release(ctx context.Context, id string) error
+	DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+type SlugRedirectRepository interface {
+	datastore.BaseRepository[*models.SlugRedirect]
+	Find(ctx context.Context, entityType, scopeID, slug string) (*models.SlugRedirect, error)
+	Remove(ctx context.Context, redirect *models.SlugRedirect) error
+}
+
+type DigitalAssetRepository interface {
+	datastore.BaseRepository[*models.DigitalAsset]
+	GetByVariantID(ctx context.Context, variantID string) (*models.DigitalAsset, error)
+}
+
+type EntitlementRepository interface {
+	datastore.BaseRepository[*models.Entitlement]
+	ListByOrderID(ctx context.Context, orderID string) ([]*models.Entitlement, error)
+	ListByProfileID(ctx context.Context, profileID string, limit, offset int) ([]*models.Entitlement, error)
+	GetByTokenHash(ctx context.Context, tokenHash string) (*models.Entitlement, error)
+	ConsumeDownload(ctx context.Context, tokenHash string, now time.Time) (bool, error)
+	CreateWithFulfilment(
+		ctx context.Context,
+		entitlements []*models.Entitlement,
+		fulfilment *models.Fulfilment,
+		lines []*models.FulfilmentLine,
+	) (bool, error)
+}
+
+type BookingSlotRepository interface {
+	datastore.BaseRepository[*models.BookingSlot]
+	TryCreate(ctx context.Context, slot *models.BookingSlot) (bool, error)
+	ListByProductID(ctx context.Context, productID string, from, to time.Time) ([]*models.BookingSlot, error)
+	Reserve(
+		ctx context.Context, cartID string, slot *models.BookingSlot, quantity int64, holdUntil time.Time,
+	) (*models.CartLine, error)
+}
+
+type SlotReservationRepository interface {
+	datastore.BaseRepository[*models.SlotReservation]
+	GetByCartLineID(ctx context.Context, cartLineID string) (*models.SlotReservation, error)
+	ListByOrderID(ctx context.Context, orderID string) ([]*models.SlotReservation, error)
+	ListExpiredHolds(ctx context.Context, now time.Time, limit int) ([]*models.SlotReservation, error)
+	Release(ctx context.Context, reservationID string, from []int32) (bool, error)
+}
+
+type SubscriptionRepository interface {
+	datastore.BaseRepository[*models.Subscription]
+	GetWithLines(ctx context.Context, id string) (*models.Subscription, error)
+	CreateWithLines(ctx context.Context, subscription *models.Subscription, lines []*models.SubscriptionLine) error
+	ListByProfileID(ctx context.Context, profileID string, limit, offset int) ([]*models.Subscription, error)
+	ListDue(ctx context.Context, now time.Time, limit int) ([]*models.Subscription, error)
+	AdvanceCycle(ctx context.Context, id string, from, to time.Time, orderID string) (bool, error)
+}
+
+type BundleComponentRepository interface {
+	datastore.BaseRepository[*models.BundleComponent]
+	ListByBundleID(ctx context.Context, bundleVariantID string) ([]*models.BundleComponent, error)
+	ExistsForComponent(ctx context.Context, variantID string) (bool, error)
+	Replace(ctx context.Context, bundle *models.ProductVariant, components []*models.BundleComponent) error
 }
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/pitabwire/frame/datastore"
+	"github.com/pitabwire/frame/datastore/pool"
+	"github.com/pitabwire/frame/workerpool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+type invoiceRepository struct {
+	datastore.BaseRepository[*models.Invoice]
+}
+
+func NewInvoiceRepository(ctx context.Context, dbPool pool.Pool, workMan workerpool.Manager) InvoiceRepository {
+	return &invoiceRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.Invoice](
+			ctx, dbPool, workMan, func() *models.Invoice { return &models.Invoice{} },
+		),
+	}
+}
+
+func (r *invoiceRepository) GetWithLines(ctx context.Context, id string) (*models.Invoice, error) {
+	invoice := &models.Invoice{}
+	err := r.Pool().DB(ctx, true).
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC") }).
+		First(invoice, "id = ?", id).Error
+	return invoice, err
+}
+
+func (r *invoiceRepository) GetByOrderAndKind(ctx context.Context, orderID string, kind int32) (*models.Invoice, error) {
+	invoice := &models.Invoice{}
+	err := r.Pool().DB(ctx, false).
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC") }).
+		First(invoice, "order_id = ? AND kind = ?", orderID, kind).Error
+	return invoice, err
+}
+
+func (r *invoiceRepository) ListByOrderID(ctx context.Context, orderID string) ([]*models.Invoice, error) {
+	var invoices []*models.Invoice
+	err := r.Pool().DB(ctx, true).
+		Where("order_id = ?", orderID).
+		Order("issued_at ASC").
+		Find(&invoices).Error
+	return invoices, err
+}
+
+// errInvoiceIssued rolls back an Issue that lost the race to another.
+var errInvoiceIssued = errors.New("invoice already issued")
+
+// Issue numbers the invoice from the shop's sequence and stores it with its
+// lines in one transaction, so a failed issue leaves no gap in the numbers.
+// It returns false, storing nothing, when the order already has an invoice
+// of the same kind.
+func (r *invoiceRepository) Issue(
+	ctx context.Context,
+	invoice *models.Invoice,
+	sequence string,
+	number func(seq int64) string,
+) (bool, error) {
+	err := r.Pool().DB(ctx, false).Transaction(func(tx *gorm.DB) error {
+		var existing int64
+		err := tx.Model(&models.Invoice{}).
+			Where("order_id = ? AND kind = ?", invoice.OrderID, invoice.Kind).
+			Count(&existing).Error
+		if err != nil {
+			return err
+		}
+		if existing > 0 {
+			return errInvoiceIssued
+		}
+
+		seq, err := nextSequenceValue(tx, invoice.ShopID, sequence)
+		if err != nil {
+			return err
+		}
+		invoice.Number = number(seq)
+
+		result := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(invoice)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvoiceIssued
+		}
+
+		for _, line := range invoice.Lines {
+			line.InvoiceID = invoice.GetID()
+			line.CopyPartitionInfo(&invoice.BaseModel)
+			if err = tx.Create(line).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errInvoiceIssued) {
+		return false, nil
+	}
+	return err == nil, err
+}
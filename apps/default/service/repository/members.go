@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/pitabwire/frame/datastore"
+	"github.com/pitabwire/frame/datastore/pool"
+	"github.com/pitabwire/frame/workerpool"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+type shopMemberRepository struct {
+	datastore.BaseRepository[*models.ShopMember]
+}
+
+func NewShopMemberRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) ShopMemberRepository {
+	return &shopMemberRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.ShopMember](
+			ctx, dbPool, workMan, func() *models.ShopMember { return &models.ShopMember{} },
+		),
+	}
+}
+
+// GetByShopAndProfile reads from the primary so a freshly granted role is
+// honoured immediately.
+func (r *shopMemberRepository) GetByShopAndProfile(
+	ctx context.Context,
+	shopID, profileID string,
+) (*models.ShopMember, error) {
+	member := &models.ShopMember{}
+	err := r.Pool().DB(ctx, false).
+		Where("shop_id = ? AND profile_id = ?", shopID, profileID).
+		First(member).Error
+	return member, err
+}
+
+func (r *shopMemberRepository) ListByShopID(ctx context.Context, shopID string) ([]*models.ShopMember, error) {
+	var members []*models.ShopMember
+	err := r.Pool().DB(ctx, true).
+		Where("shop_id = ?", shopID).
+		Order("created_at ASC").
+		Find(&members).Error
+	return members, err
+}
+
+// Remove hard deletes the membership so the profile can be invited again.
+func (r *shopMemberRepository) Remove(ctx context.Context, shopID, profileID string) error {
+	return r.Pool().DB(ctx, false).
+		Unscoped().
+		Where("shop_id = ? AND profile_id = ?", shopID, profileID).
+		Delete(&models.ShopMember{}).Error
+}
@@ -2,20 +2,70 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/pitabwire/frame/datastore"
+	"gorm.io/gorm/clause"
 
 	"github.com/antinvestor/service-commerce/apps/default/service/models"
 )
 
+// shopOwnerRole is the member role that owns a shop.
+const shopOwnerRole = "owner"
+
 func Migrate(ctx context.Context, dbManager datastore.Manager, migrationPath string) error {
 	dbPool := dbManager.GetPool(ctx, datastore.DefaultMigrationPoolName)
 
 	return dbManager.Migrate(ctx, dbPool, migrationPath,
-		&models.Shop{},
-		&models.Product{}, &models.ProductVariant{},
+		&models.Shop{}, &models.ShopSequence{}, &models.ShopMember{},
+		&models.Product{}, &models.ProductOption{}, &models.ProductVariant{}, &models.BundleComponent{},
+		&models.CatalogImport{},
+		&models.Category{}, &models.ProductCategory{},
+		&models.Collection{}, &models.CollectionProduct{}, &models.SlugRedirect{},
 		&models.Cart{}, &models.CartLine{},
 		&models.Order{}, &models.OrderLine{},
-		&models.Fulfilment{}, &models.FulfilmentLine{},
+		&models.Fulfilment{}, &models.FulfilmentLine{}, &models.FulfilmentTrackingEvent{},
+		&models.DigitalAsset{}, &models.Entitlement{},
+		&models.BookingSlot{}, &models.SlotReservation{},
+		&models.Subscription{}, &models.SubscriptionLine{},
+		&models.Invoice{}, &models.InvoiceLine{},
+		&models.WebhookEndpoint{}, &models.WebhookDelivery{}, &models.WebhookAttempt{},
+		&models.SalesDailyRollup{}, &models.VariantSalesRollup{}, &models.RollupWatermark{},
+		&models.IdempotencyRecord{},
 	)
 }
+
+// BackfillShopOwners makes profileID the owner of every shop left without
+// one. Shops created before memberships existed never recorded their creator,
+// so they are handed to the configured admin, who can then invite the real
+// owners. It returns how many shops were given an owner.
+func BackfillShopOwners(ctx context.Context, dbManager datastore.Manager, profileID string) (int, error) {
+	db := dbManager.GetPool(ctx, datastore.DefaultMigrationPoolName).DB(ctx, false)
+
+	var shops []*models.Shop
+	err := db.Where("NOT EXISTS (?)",
+		db.Model(&models.ShopMember{}).Select("1").
+			Where("shop_members.shop_id = shops.id AND shop_members.role = ?", shopOwnerRole),
+	).Find(&shops).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for _, shop := range shops {
+		member := &models.ShopMember{ShopID: shop.GetID(), ProfileID: profileID, Role: shopOwnerRole}
+		member.GenID(ctx)
+		member.TenantID = shop.TenantID
+		member.PartitionID = shop.PartitionID
+		member.AccessID = shop.AccessID
+
+		// An admin already holding another role in the shop is promoted.
+		err = db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "shop_id"}, {Name: "profile_id"}},
+			DoUpdates: clause.Assignments(map[string]any{"role": shopOwnerRole, "modified_at": time.Now()}),
+		}).Create(member).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(shops), nil
+}
@@ -0,0 +1,285 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pitabwire/frame/datastore"
+	"github.com/pitabwire/frame/datastore/pool"
+	"github.com/pitabwire/frame/workerpool"
+	"gorm.io/gorm"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+// ErrUnsupportedCollectionRule is returned for a rule the product query cannot express.
+var ErrUnsupportedCollectionRule = errors.New("unsupported collection rule")
+
+type categoryRepository struct {
+	datastore.BaseRepository[*models.Category]
+}
+
+func NewCategoryRepository(ctx context.Context, dbPool pool.Pool, workMan workerpool.Manager) CategoryRepository {
+	return &categoryRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.Category](
+			ctx, dbPool, workMan, func() *models.Category { return &models.Category{} },
+		),
+	}
+}
+
+func (r *categoryRepository) GetBySlug(ctx context.Context, shopID, slug string) (*models.Category, error) {
+	category := &models.Category{}
+	err := r.Pool().DB(ctx, true).First(category, "shop_id = ? AND slug = ?", shopID, slug).Error
+	return category, err
+}
+
+func (r *categoryRepository) ListByShopID(ctx context.Context, shopID string) ([]*models.Category, error) {
+	var categories []*models.Category
+	err := r.Pool().DB(ctx, true).
+		Where("shop_id = ?", shopID).
+		Order("position ASC, name ASC").
+		Find(&categories).Error
+	return categories, err
+}
+
+type productCategoryRepository struct {
+	datastore.BaseRepository[*models.ProductCategory]
+}
+
+func NewProductCategoryRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) ProductCategoryRepository {
+	return &productCategoryRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.ProductCategory](
+			ctx, dbPool, workMan, func() *models.ProductCategory { return &models.ProductCategory{} },
+		),
+	}
+}
+
+func (r *productCategoryRepository) ListCategoryIDs(ctx context.Context, productID string) ([]string, error) {
+	var ids []string
+	err := r.Pool().DB(ctx, true).
+		Model(&models.ProductCategory{}).
+		Where("product_id = ?", productID).
+		Pluck("category_id", &ids).Error
+	return ids, err
+}
+
+// Replace sets the product's categories in one transaction.
+func (r *productCategoryRepository) Replace(ctx context.Context, productID string, categoryIDs []string) error {
+	return r.Pool().DB(ctx, false).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().
+			Where("product_id = ?", productID).
+			Delete(&models.ProductCategory{}).Error; err != nil {
+			return err
+		}
+
+		for _, categoryID := range categoryIDs {
+			link := &models.ProductCategory{ProductID: productID, CategoryID: categoryID}
+			if err := tx.Create(link).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type collectionRepository struct {
+	datastore.BaseRepository[*models.Collection]
+}
+
+func NewCollectionRepository(ctx context.Context, dbPool pool.Pool, workMan workerpool.Manager) CollectionRepository {
+	return &collectionRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.Collection](
+			ctx, dbPool, workMan, func() *models.Collection { return &models.Collection{} },
+		),
+	}
+}
+
+func (r *collectionRepository) GetBySlug(ctx context.Context, shopID, slug string) (*models.Collection, error) {
+	collection := &models.Collection{}
+	err := r.Pool().DB(ctx, true).First(collection, "shop_id = ? AND slug = ?", shopID, slug).Error
+	return collection, err
+}
+
+func (r *collectionRepository) ListByShopID(ctx context.Context, shopID string) ([]*models.Collection, error) {
+	var collections []*models.Collection
+	err := r.Pool().DB(ctx, true).
+		Where("shop_id = ?", shopID).
+		Order("name ASC").
+		Find(&collections).Error
+	return collections, err
+}
+
+type collectionProductRepository struct {
+	datastore.BaseRepository[*models.CollectionProduct]
+}
+
+func NewCollectionProductRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) CollectionProductRepository {
+	return &collectionProductRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.CollectionProduct](
+			ctx, dbPool, workMan, func() *models.CollectionProduct { return &models.CollectionProduct{} },
+		),
+	}
+}
+
+// Replace sets the products of a manual collection, in order, in one transaction.
+func (r *collectionProductRepository) Replace(ctx context.Context, collectionID string, productIDs []string) error {
+	return r.Pool().DB(ctx, false).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().
+			Where("collection_id = ?", collectionID).
+			Delete(&models.CollectionProduct{}).Error; err != nil {
+			return err
+		}
+
+		for position, productID := range productIDs {
+			entry := &models.CollectionProduct{
+				CollectionID: collectionID,
+				ProductID:    productID,
+				Position:     int32(position), //nolint:gosec // collections are far smaller than MaxInt32
+			}
+			if err := tx.Create(entry).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *productRepository) ListByCategoryIDs(
+	ctx context.Context,
+	shopID string,
+	categoryIDs []string,
+	limit, offset int,
+) ([]*models.Product, error) {
+	var products []*models.Product
+	query := r.Pool().DB(ctx, true).
+		Where("shop_id = ?", shopID).
+		Where("id IN (SELECT product_id FROM product_categories WHERE category_id IN ? AND deleted_at IS NULL)",
+			categoryIDs).
+		Order("created_at DESC")
+	err := paginate(query, limit, offset).Find(&products).Error
+	return products, err
+}
+
+func (r *productRepository) ListByCollectionID(
+	ctx context.Context,
+	collectionID string,
+	limit, offset int,
+) ([]*models.Product, error) {
+	var products []*models.Product
+	// Naming the table lets the tenancy scope qualify tenant_id in the join.
+	query := r.Pool().DB(ctx, true).
+		Table("products").
+		Joins("JOIN collection_products ON collection_products.product_id = products.id "+
+			"AND collection_products.deleted_at IS NULL").
+		Where("collection_products.collection_id = ?", collectionID).
+		Order("collection_products.position ASC")
+	err := paginate(query, limit, offset).Find(&products).Error
+	return products, err
+}
+
+// ListByRules lists the shop's products matching all rules, or any of them
+// when matchAny is set.
+func (r *productRepository) ListByRules(
+	ctx context.Context,
+	shopID string,
+	rules models.CollectionRules,
+	matchAny bool,
+	limit, offset int,
+) ([]*models.Product, error) {
+	conditions := make([]string, 0, len(rules))
+	var args []any
+	for _, rule := range rules {
+		condition, ruleArgs, err := collectionRuleCondition(rule)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, "("+condition+")")
+		args = append(args, ruleArgs...)
+	}
+
+	joiner := " AND "
+	if matchAny {
+		joiner = " OR "
+	}
+
+	var products []*models.Product
+	query := r.Pool().DB(ctx, true).Where("shop_id = ?", shopID)
+	if len(conditions) > 0 {
+		query = query.Where(strings.Join(conditions, joiner), args...)
+	}
+	err := paginate(query.Order("created_at DESC"), limit, offset).Find(&products).Error
+	return products, err
+}
+
+//nolint:gochecknoglobals // static operator table
+var collectionRuleOperators = map[string]string{
+	"eq":  "=",
+	"neq": "<>",
+	"lt":  "<",
+	"lte": "<=",
+	"gt":  ">",
+	"gte": ">=",
+}
+
+// collectionRuleCondition translates a rule into a SQL condition on products.
+// Prices match when any active variant of the product satisfies the rule.
+func collectionRuleCondition(rule models.CollectionRule) (string, []any, error) {
+	if rule.Operator == "contains" {
+		pattern := "%" + escapeLike(rule.Value) + "%"
+		switch rule.Field {
+		case "attribute":
+			return "products.attributes ->> ? ILIKE ?", []any{rule.Attribute, pattern}, nil
+		case "name":
+			return "products.name ILIKE ?", []any{pattern}, nil
+		}
+		return "", nil, fmt.Errorf("%w: %s contains", ErrUnsupportedCollectionRule, rule.Field)
+	}
+
+	op, ok := collectionRuleOperators[rule.Operator]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: operator %q", ErrUnsupportedCollectionRule, rule.Operator)
+	}
+
+	switch rule.Field {
+	case "attribute":
+		if op != "=" && op != "<>" {
+			return "", nil, fmt.Errorf("%w: attribute %s", ErrUnsupportedCollectionRule, rule.Operator)
+		}
+		return "COALESCE(products.attributes ->> ?, '') " + op + " ?", []any{rule.Attribute, rule.Value}, nil
+	case "name":
+		if op != "=" && op != "<>" {
+			return "", nil, fmt.Errorf("%w: name %s", ErrUnsupportedCollectionRule, rule.Operator)
+		}
+		return "products.name " + op + " ?", []any{rule.Value}, nil
+	case "price":
+		return "EXISTS (SELECT 1 FROM product_variants pv WHERE pv.product_id = products.id " +
+				"AND pv.deleted_at IS NULL AND pv.status = 1 " +
+				"AND (pv.price_units::numeric + pv.price_nanos::numeric / 1000000000) " + op + " ?::numeric)",
+			[]any{rule.Value}, nil
+	}
+	return "", nil, fmt.Errorf("%w: field %q", ErrUnsupportedCollectionRule, rule.Field)
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+func paginate(query *gorm.DB, limit, offset int) *gorm.DB {
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if offset > 0 {
+		query = query.Offset(offset)
+	}
+	return query
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/pitabwire/frame/datastore"
+	"github.com/pitabwire/frame/datastore/pool"
+	"github.com/pitabwire/frame/workerpool"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+type productOptionRepository struct {
+	datastore.BaseRepository[*models.ProductOption]
+}
+
+func NewProductOptionRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) ProductOptionRepository {
+	return &productOptionRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.ProductOption](
+			ctx, dbPool, workMan, func() *models.ProductOption { return &models.ProductOption{} },
+		),
+	}
+}
+
+// ListByProductID reads from the primary so reconciliation always sees the
+// options it has just written.
+func (r *productOptionRepository) ListByProductID(
+	ctx context.Context,
+	productID string,
+) ([]*models.ProductOption, error) {
+	var options []*models.ProductOption
+	err := r.Pool().DB(ctx, false).
+		Where("product_id = ?", productID).
+		Order("position ASC, name ASC").
+		Find(&options).Error
+	return options, err
+}
+
+// Remove hard deletes the option so that its name can be declared again.
+func (r *productOptionRepository) Remove(ctx context.Context, id string) error {
+	return r.Pool().DB(ctx, false).
+		Unscoped().
+		Where("id = ?", id).
+		Delete(&models.ProductOption{}).Error
+}
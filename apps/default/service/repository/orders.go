@@ -2,10 +2,14 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"time"
 
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
 	"github.com/pitabwire/frame/datastore"
 	"github.com/pitabwire/frame/datastore/pool"
 	"github.com/pitabwire/frame/workerpool"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 
 	"github.com/antinvestor/service-commerce/apps/default/service/models"
@@ -31,14 +35,219 @@ func (r *orderRepository) GetWithLines(ctx context.Context, id string) (*models.
 	return order, err
 }
 
-func (r *orderRepository) GetByIdempotencyKey(ctx context.Context, key string) (*models.Order, error) {
+// OrderExportFilter selects the orders of a shop created in [From, To). A
+// zero bound is open; an empty Statuses matches every status.
+type OrderExportFilter struct {
+	ShopID   string
+	From     time.Time
+	To       time.Time
+	Statuses []int32
+}
+
+// ListForExport lists the orders matching filter after afterID in ID order,
+// with their lines, so an export can page through them with a steady cursor.
+func (r *orderRepository) ListForExport(
+	ctx context.Context,
+	filter OrderExportFilter,
+	afterID string,
+	limit int,
+) ([]*models.Order, error) {
+	query := r.Pool().DB(ctx, true).
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC, id ASC") }).
+		Where("shop_id = ? AND id > ?", filter.ShopID, afterID)
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+
+	var orders []*models.Order
+	err := query.Order("id ASC").Limit(limit).Find(&orders).Error
+	return orders, err
+}
+
+func (r *orderRepository) GetByIdempotencyKey(
+	ctx context.Context,
+	shopID, key string,
+) (*models.Order, error) {
 	order := &models.Order{}
-	err := r.Pool().DB(ctx, true).
+	err := r.Pool().DB(ctx, false).
 		Preload(clause.Associations).
-		First(order, "idempotency_key = ?", key).Error
+		First(order, "shop_id = ? AND idempotency_key = ?", shopID, key).Error
 	return order, err
 }
 
+func (r *orderRepository) GetByCartID(ctx context.Context, cartID string) (*models.Order, error) {
+	order := &models.Order{}
+	err := r.Pool().DB(ctx, false).
+		Preload(clause.Associations).
+		First(order, "cart_id = ?", cartID).Error
+	return order, err
+}
+
+// errOrderConflict rolls back CreateWithLines when the order insert collides.
+var errOrderConflict = errors.New("order conflicts with an existing order")
+
+// CreateWithLines persists the order, its lines and the matching stock
+// decrements in one transaction. Bundle lines decrement their components, and
+// lines booked against a slot confirm the cart's held reservation instead of
+// decrementing stock. When the order carries a CartID the cart is
+// marked converted in the same transaction. It returns false without an error
+// when the order collides with an existing order number or cart conversion.
+func (r *orderRepository) CreateWithLines(
+	ctx context.Context,
+	order *models.Order,
+	lines []*models.OrderLine,
+) (bool, error) {
+	err := r.Pool().DB(ctx, false).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(order)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errOrderConflict
+		}
+
+		for _, line := range lines {
+			line.OrderID = order.GetID()
+			line.CopyPartitionInfo(&order.BaseModel)
+		}
+		if len(lines) > 0 {
+			if createErr := tx.Create(lines).Error; createErr != nil {
+				return createErr
+			}
+		}
+
+		for i, line := range lines {
+			if line.SlotID != "" {
+				confirmErr := confirmSlotReservation(tx, order, line)
+				if confirmErr != nil {
+					return confirmErr
+				}
+				continue
+			}
+			if len(line.BundleComposition) == 0 {
+				if stockErr := decrementStock(tx, i, line.ProductVariantID, line.Quantity); stockErr != nil {
+					return stockErr
+				}
+				continue
+			}
+			for _, item := range line.BundleComposition {
+				if stockErr := decrementStock(tx, i, item.VariantID, item.Quantity*line.Quantity); stockErr != nil {
+					return stockErr
+				}
+			}
+		}
+
+		if order.CartID == "" {
+			return nil
+		}
+
+		converted := tx.Model(&models.Cart{}).
+			Where("id = ? AND status = ?", order.CartID, int32(commercev1.CartStatus_CART_STATUS_ACTIVE)).
+			UpdateColumns(map[string]any{
+				"status":      int32(commercev1.CartStatus_CART_STATUS_CONVERTED),
+				"modified_at": time.Now(),
+				"version":     gorm.Expr("version + 1"),
+			})
+		if converted.Error != nil {
+			return converted.Error
+		}
+		if converted.RowsAffected == 0 {
+			return ErrCartNotActive
+		}
+		return nil
+	})
+
+	if errors.Is(err, errOrderConflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// decrementStock takes quantity units of the variant's stock for the order
+// line at position line, failing with an InsufficientStockError carrying the
+// stock left rather than going below zero.
+func decrementStock(tx *gorm.DB, line int, variantID string, quantity int64) error {
+	stock := tx.Model(&models.ProductVariant{}).
+		Where("id = ? AND stock_quantity >= ?", variantID, quantity).
+		UpdateColumn("stock_quantity", gorm.Expr("stock_quantity - ?", quantity))
+	if stock.Error != nil {
+		return stock.Error
+	}
+	if stock.RowsAffected == 0 {
+		var available int64
+		err := tx.Model(&models.ProductVariant{}).
+			Where("id = ?", variantID).
+			Select("stock_quantity").
+			Scan(&available).Error
+		if err != nil {
+			return err
+		}
+		return &InsufficientStockError{Line: line, VariantID: variantID, Requested: quantity, Available: available}
+	}
+	return nil
+}
+
+// confirmSlotReservation converts the cart's held reservation for the line's
+// slot into a confirmed reservation against the order.
+func confirmSlotReservation(tx *gorm.DB, order *models.Order, line *models.OrderLine) error {
+	confirmed := tx.Model(&models.SlotReservation{}).
+		Where("cart_id = ? AND slot_id = ? AND status = ?", order.CartID, line.SlotID, models.ReservationStatusHeld).
+		UpdateColumns(map[string]any{
+			"status":      models.ReservationStatusConfirmed,
+			"order_id":    order.GetID(),
+			"modified_at": time.Now(),
+		})
+	if confirmed.Error != nil {
+		return confirmed.Error
+	}
+	if confirmed.RowsAffected == 0 {
+		return ErrReservationNotHeld
+	}
+	return nil
+}
+
+// OrderHistoryFilter selects a customer's orders, in one shop when ShopID is
+// set and across shops otherwise. An empty Statuses matches every status.
+type OrderHistoryFilter struct {
+	ProfileID string
+	ShopID    string
+	Statuses  []int32
+}
+
+// ListByProfile lists the orders matching filter with their lines, newest
+// first.
+func (r *orderRepository) ListByProfile(
+	ctx context.Context,
+	filter OrderHistoryFilter,
+	limit, offset int,
+) ([]*models.Order, error) {
+	query := r.Pool().DB(ctx, true).
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC, id ASC") }).
+		Where("profile_id = ?", filter.ProfileID)
+	if filter.ShopID != "" {
+		query = query.Where("shop_id = ?", filter.ShopID)
+	}
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if offset > 0 {
+		query = query.Offset(offset)
+	}
+
+	var orders []*models.Order
+	err := query.Order("created_at DESC, id DESC").Find(&orders).Error
+	return orders, err
+}
+
 func (r *orderRepository) ListByShopID(ctx context.Context, shopID string, limit, offset int) ([]*models.Order, error) {
 	var orders []*models.Order
 	query := r.Pool().DB(ctx, true).
@@ -55,6 +264,157 @@ func (r *orderRepository) ListByShopID(ctx context.Context, shopID string, limit
 	return orders, err
 }
 
+// SetFulfilmentStatus records the order's aggregate fulfilment status. When
+// fulfilled is set, a confirmed order also moves to FULFILLED.
+func (r *orderRepository) SetFulfilmentStatus(
+	ctx context.Context,
+	orderID string,
+	fulfilmentStatus int32,
+	fulfilled bool,
+) error {
+	columns := map[string]any{
+		"fulfilment_status": fulfilmentStatus,
+		"modified_at":       time.Now(),
+		"version":           gorm.Expr("version + 1"),
+	}
+	if fulfilled {
+		columns["status"] = gorm.Expr("CASE WHEN status = ? THEN ? ELSE status END",
+			int32(commercev1.OrderStatus_ORDER_STATUS_CONFIRMED), int32(commercev1.OrderStatus_ORDER_STATUS_FULFILLED))
+	}
+	return r.Pool().DB(ctx, false).
+		Model(&models.Order{}).
+		Where("id = ?", orderID).
+		UpdateColumns(columns).Error
+}
+
+// TransitionPaymentStatus moves the order's payment status to "to" when it is
+// currently one of "from", reporting whether the order changed.
+func (r *orderRepository) TransitionPaymentStatus(
+	ctx context.Context,
+	orderID string,
+	from []int32,
+	to int32,
+) (bool, error) {
+	result := r.Pool().DB(ctx, false).
+		Model(&models.Order{}).
+		Where("id = ? AND payment_status IN ?", orderID, from).
+		UpdateColumns(map[string]any{
+			"payment_status": to,
+			"modified_at":    time.Now(),
+			"version":        gorm.Expr("version + 1"),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Cancel moves a confirmed order that is neither paid nor shipped to
+// CANCELLED, reporting whether the order changed.
+func (r *orderRepository) Cancel(ctx context.Context, orderID string) (bool, error) {
+	unpaid := []int32{
+		int32(commercev1.PaymentStatus_PAYMENT_STATUS_UNSPECIFIED),
+		int32(commercev1.PaymentStatus_PAYMENT_STATUS_PENDING),
+		int32(commercev1.PaymentStatus_PAYMENT_STATUS_FAILED),
+	}
+	result := r.Pool().DB(ctx, false).
+		Model(&models.Order{}).
+		Where("id = ? AND status = ? AND payment_status IN ? AND fulfilment_status = ?",
+			orderID, int32(commercev1.OrderStatus_ORDER_STATUS_CONFIRMED), unpaid,
+			int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_UNSPECIFIED)).
+		UpdateColumns(map[string]any{
+			"status":      int32(commercev1.OrderStatus_ORDER_STATUS_CANCELLED),
+			"modified_at": time.Now(),
+			"version":     gorm.Expr("version + 1"),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReleaseStock gives back, once, the stock the order took and did not send
+// out: each line's quantity less what shipped or delivered fulfilments carry.
+// Bundle lines give back their components and booked lines took no stock. It
+// reports false when the order's stock had already been released.
+func (r *orderRepository) ReleaseStock(ctx context.Context, orderID string) (bool, error) {
+	released := false
+	err := r.Pool().DB(ctx, false).Transaction(func(tx *gorm.DB) error {
+		claimed := tx.Model(&models.Order{}).
+			Where("id = ? AND stock_released = ?", orderID, false).
+			UpdateColumns(map[string]any{
+				"stock_released": true,
+				"modified_at":    time.Now(),
+				"version":        gorm.Expr("version + 1"),
+			})
+		if claimed.Error != nil {
+			return claimed.Error
+		}
+		if claimed.RowsAffected == 0 {
+			return nil
+		}
+
+		var lines []*models.OrderLine
+		if err := tx.Where("order_id = ?", orderID).Find(&lines).Error; err != nil {
+			return err
+		}
+		sent, err := sentQuantities(tx, orderID)
+		if err != nil {
+			return err
+		}
+
+		for _, line := range lines {
+			remaining := line.Quantity - sent[line.GetID()]
+			if line.SlotID != "" || remaining <= 0 {
+				continue
+			}
+			if len(line.BundleComposition) == 0 {
+				if err = incrementStock(tx, line.ProductVariantID, remaining); err != nil {
+					return err
+				}
+				continue
+			}
+			for _, item := range line.BundleComposition {
+				if err = incrementStock(tx, item.VariantID, item.Quantity*remaining); err != nil {
+					return err
+				}
+			}
+		}
+		released = true
+		return nil
+	})
+	return released, err
+}
+
+// sentQuantities sums, per order line, the quantities of the order's shipped
+// and delivered fulfilments.
+func sentQuantities(tx *gorm.DB, orderID string) (map[string]int64, error) {
+	var rows []struct {
+		OrderLineID string
+		Quantity    int64
+	}
+	err := tx.Table("fulfilment_lines").
+		Joins("JOIN fulfilments ON fulfilments.id = fulfilment_lines.fulfilment_id").
+		Where("fulfilments.order_id = ? AND fulfilments.deleted_at IS NULL", orderID).
+		Where("fulfilment_lines.deleted_at IS NULL").
+		Where("fulfilments.status IN ?", []int32{
+			int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_SHIPPED),
+			int32(commercev1.FulfilmentStatus_FULFILMENT_STATUS_DELIVERED),
+		}).
+		Select("fulfilment_lines.order_line_id AS order_line_id, SUM(fulfilment_lines.quantity) AS quantity").
+		Group("fulfilment_lines.order_line_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	sent := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		sent[row.OrderLineID] = row.Quantity
+	}
+	return sent, nil
+}
+
+// incrementStock gives quantity units back to the variant's stock.
+func incrementStock(tx *gorm.DB, variantID string, quantity int64) error {
+	return tx.Model(&models.ProductVariant{}).
+		Where("id = ?", variantID).
+		UpdateColumn("stock_quantity", gorm.Expr("stock_quantity + ?", quantity)).Error
+}
+
 type orderLineRepository struct {
 	datastore.BaseRepository[*models.OrderLine]
 }
@@ -3,10 +3,12 @@ package repository
 import (
 	"context"
 
+	"github.com/pitabwire/frame/data"
 	"github.com/pitabwire/frame/datastore"
 	"github.com/pitabwire/frame/datastore/pool"
 	"github.com/pitabwire/frame/workerpool"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/antinvestor/service-commerce/apps/default/service/models"
 )
@@ -26,14 +28,98 @@ func NewProductRepository(ctx context.Context, dbPool pool.Pool, workMan workerp
 func (r *productRepository) ListByShopID(ctx context.Context, shopID string, limit, offset int) ([]*models.Product, error) {
 	var products []*models.Product
 	query := r.Pool().DB(ctx, true).Where("shop_id = ?", shopID).Order("created_at DESC")
-	if limit > 0 {
-		query = query.Limit(limit)
+	err := paginate(query, limit, offset).Find(&products).Error
+	return products, err
+}
+
+// ListForExport lists the shop's products after afterID in ID order, with
+// their variants, so an export can page through a shop with a steady cursor.
+func (r *productRepository) ListForExport(
+	ctx context.Context,
+	shopID, afterID string,
+	limit int,
+) ([]*models.Product, error) {
+	var products []*models.Product
+	err := r.Pool().DB(ctx, true).
+		Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC, id ASC") }).
+		Where("shop_id = ? AND id > ?", shopID, afterID).
+		Order("id ASC").
+		Limit(limit).
+		Find(&products).Error
+	return products, err
+}
+
+// GetBySlug finds a product by its current slug within a shop.
+func (r *productRepository) GetBySlug(ctx context.Context, shopID, slug string) (*models.Product, error) {
+	product := &models.Product{}
+	err := r.Pool().DB(ctx, true).First(product, "shop_id = ? AND slug = ?", shopID, slug).Error
+	return product, err
+}
+
+// TryCreate inserts the product and reports whether a row was written. It
+// returns false without an error when the slug is already taken in the shop.
+func (r *productRepository) TryCreate(ctx context.Context, product *models.Product) (bool, error) {
+	result := r.Pool().DB(ctx, false).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(product)
+	return result.RowsAffected > 0, result.Error
+}
+
+// SaveWithVariants writes a product and its variants in one transaction. A
+// product or variant without an ID is created; one with an ID has only the
+// given columns updated, guarded by its version. It returns
+// ErrCatalogConflict, writing nothing, when a created product's slug or a
+// created variant's SKU is already taken or an updated row changed meanwhile.
+func (r *productRepository) SaveWithVariants(
+	ctx context.Context,
+	product *models.Product,
+	productColumns []string,
+	variants []*models.ProductVariant,
+	variantColumns []string,
+) error {
+	return r.Pool().DB(ctx, false).Transaction(func(tx *gorm.DB) error {
+		if err := saveImported(tx, product, product.GetID() == "", productColumns); err != nil {
+			return err
+		}
+		for _, variant := range variants {
+			created := variant.GetID() == ""
+			variant.ProductID = product.GetID()
+			if created {
+				variant.CopyPartitionInfo(&product.BaseModel)
+			}
+			if err := saveImported(tx, variant, created, variantColumns); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func saveImported(tx *gorm.DB, entity data.BaseModelI, create bool, columns []string) error {
+	if create {
+		result := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(entity)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrCatalogConflict
+		}
+		return nil
 	}
-	if offset > 0 {
-		query = query.Offset(offset)
+	if len(columns) == 0 {
+		return nil
 	}
-	err := query.Find(&products).Error
-	return products, err
+	result := tx.Model(entity).
+		Where("id = ? AND version = ?", entity.GetID(), entity.GetVersion()).
+		Select(columns).
+		Updates(entity)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrCatalogConflict
+	}
+	return nil
 }
 
 type productVariantRepository struct {
@@ -54,6 +140,22 @@ func (r *productVariantRepository) ListByProductID(ctx context.Context, productI
 	return variants, err
 }
 
+// GetBySKU finds a variant within a shop; SKUs are only unique per shop.
+func (r *productVariantRepository) GetBySKU(ctx context.Context, shopID, sku string) (*models.ProductVariant, error) {
+	variant := &models.ProductVariant{}
+	err := r.Pool().DB(ctx, false).First(variant, "shop_id = ? AND sku = ?", shopID, sku).Error
+	return variant, err
+}
+
+// TryCreate inserts the variant and reports whether a row was written. It
+// returns false without an error when the SKU is already taken in the shop.
+func (r *productVariantRepository) TryCreate(ctx context.Context, variant *models.ProductVariant) (bool, error) {
+	result := r.Pool().DB(ctx, false).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(variant)
+	return result.RowsAffected > 0, result.Error
+}
+
 func (r *productVariantRepository) DecrementStock(ctx context.Context, variantID string, quantity int64) error {
 	return r.Pool().DB(ctx, false).
 		Model(&models.ProductVariant{}).
@@ -67,3 +169,12 @@ func (r *productVariantRepository) IncrementStock(ctx context.Context, variantID
 		Where("id = ?", variantID).
 		UpdateColumn("stock_quantity", gorm.Expr("stock_quantity + ?", quantity)).Error
 }
+
+func (r *productVariantRepository) ListByIDs(ctx context.Context, ids []string) ([]*models.ProductVariant, error) {
+	var variants []*models.ProductVariant
+	if len(ids) == 0 {
+		return variants, nil
+	}
+	err := r.Pool().DB(ctx, true).Where("id IN ?", ids).Find(&variants).Error
+	return variants, err
+}
@@ -2,11 +2,16 @@ package repository_test
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
+	commercev1 "buf.build/gen/go/antinvestor/commerce/protocolbuffers/go/commerce/v1"
 	"github.com/pitabwire/frame"
 	"github.com/pitabwire/frame/datastore"
 	"github.com/pitabwire/frame/frametests/definition"
+	"github.com/pitabwire/frame/security"
 	"github.com/pitabwire/util"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -143,6 +148,43 @@ func (rts *RepositoryTestSuite) TestShopRepository_GetBySlug() {
 	})
 }
 
+func (rts *RepositoryTestSuite) TestShopRepository_GetBySlugPerTenant() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		shopRepo, _, _, _, _, _, _, _, _ := rts.getRepos(ctx, svc)
+
+		tenantCtx := func(tenantID string) context.Context {
+			claims := &security.AuthenticationClaims{TenantID: tenantID, PartitionID: tenantID}
+			claims.Subject = "profile-" + tenantID
+			return claims.ClaimsToContext(t.Context())
+		}
+		tenantACtx := tenantCtx("tenant-a-" + util.RandomAlphaNumericString(6))
+		tenantBCtx := tenantCtx("tenant-b-" + util.RandomAlphaNumericString(6))
+
+		slug := "main-" + util.RandomAlphaNumericString(6)
+		shopA := &models.Shop{Name: "Main", Slug: slug, Status: 1}
+		shopA.GenID(tenantACtx)
+		require.NoError(t, shopRepo.Create(tenantACtx, shopA))
+
+		_, err := shopRepo.GetBySlug(tenantBCtx, slug)
+		require.Error(t, err)
+
+		shopB := &models.Shop{Name: "Main", Slug: slug, Status: 1}
+		shopB.GenID(tenantBCtx)
+		require.NoError(t, shopRepo.Create(tenantBCtx, shopB))
+
+		found, err := shopRepo.GetBySlug(tenantBCtx, slug)
+		require.NoError(t, err)
+		require.Equal(t, shopB.GetID(), found.GetID())
+
+		found, err = shopRepo.GetBySlug(tenantACtx, slug)
+		require.NoError(t, err)
+		require.Equal(t, shopA.GetID(), found.GetID())
+	})
+}
+
 func (rts *RepositoryTestSuite) TestShopRepository_Update() {
 	t := rts.T()
 
@@ -447,9 +489,185 @@ func (rts *RepositoryTestSuite) TestOrderRepository_GetByIdempotencyKey() {
 		err := orderRepo.Create(ctx, order)
 		require.NoError(t, err)
 
-		found, err := orderRepo.GetByIdempotencyKey(ctx, idemKey)
+		found, err := orderRepo.GetByIdempotencyKey(ctx, shop.GetID(), idemKey)
 		require.NoError(t, err)
 		require.Equal(t, order.GetID(), found.GetID())
+
+		otherShop := rts.createTestShop(ctx, shopRepo)
+		_, err = orderRepo.GetByIdempotencyKey(ctx, otherShop.GetID(), idemKey)
+		require.Error(t, err)
+	})
+}
+
+func (rts *RepositoryTestSuite) TestIdempotencyRepository_DeleteExpired() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		idempotencyRepo := repository.NewIdempotencyRepository(
+			ctx, svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName), svc.WorkManager())
+
+		shopID := "shop-" + util.RandomAlphaNumericString(10)
+		claim := func(expiresAt time.Time) *models.IdempotencyRecord {
+			record := &models.IdempotencyRecord{
+				ShopID:         shopID,
+				CallerID:       "profile-idem",
+				Operation:      "create_order",
+				IdempotencyKey: "idem-" + util.RandomAlphaNumericString(10),
+				ExpiresAt:      expiresAt,
+			}
+			claimed, err := idempotencyRepo.TryCreate(ctx, record)
+			require.NoError(t, err)
+			require.True(t, claimed)
+			return record
+		}
+
+		now := time.Now()
+		expired := claim(now.Add(-time.Minute))
+		live := claim(now.Add(time.Hour))
+
+		deleted, err := idempotencyRepo.DeleteExpired(ctx, now, 10)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, deleted, 1)
+
+		_, err = idempotencyRepo.GetByScope(ctx, shopID, expired.CallerID, expired.Operation, expired.IdempotencyKey)
+		require.Error(t, err)
+		_, err = idempotencyRepo.GetByScope(ctx, shopID, live.CallerID, live.Operation, live.IdempotencyKey)
+		require.NoError(t, err)
+	})
+}
+
+func (rts *RepositoryTestSuite) TestOrderRepository_CreateWithLinesConvertsCart() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		shopRepo, productRepo, variantRepo, cartRepo, _, orderRepo, _, _, _ := rts.getRepos(ctx, svc)
+
+		shop := rts.createTestShop(ctx, shopRepo)
+		product := rts.createTestProduct(ctx, productRepo, shop.GetID())
+		variant := rts.createTestVariant(ctx, variantRepo, product.GetID())
+
+		cart := &models.Cart{ShopID: shop.GetID(), Status: int32(commercev1.CartStatus_CART_STATUS_ACTIVE)}
+		cart.GenID(ctx)
+		require.NoError(t, cartRepo.Create(ctx, cart))
+
+		order := &models.Order{
+			ShopID:      shop.GetID(),
+			OrderNumber: "ORD-" + util.RandomAlphaNumericString(10),
+			CartID:      cart.GetID(),
+			Status:      1,
+		}
+		lines := []*models.OrderLine{{ProductVariantID: variant.GetID(), Quantity: 4}}
+
+		inserted, err := orderRepo.CreateWithLines(ctx, order, lines)
+		require.NoError(t, err)
+		require.True(t, inserted)
+
+		stored, err := orderRepo.GetByCartID(ctx, cart.GetID())
+		require.NoError(t, err)
+		require.Equal(t, order.GetID(), stored.GetID())
+		require.Len(t, stored.Lines, 1)
+
+		updatedCart, err := cartRepo.GetByID(ctx, cart.GetID())
+		require.NoError(t, err)
+		require.Equal(t, int32(commercev1.CartStatus_CART_STATUS_CONVERTED), updatedCart.Status)
+
+		// A second order for the same cart is rejected as a conflict.
+		duplicate := &models.Order{
+			ShopID:      shop.GetID(),
+			OrderNumber: "ORD-" + util.RandomAlphaNumericString(10),
+			CartID:      cart.GetID(),
+			Status:      1,
+		}
+		inserted, err = orderRepo.CreateWithLines(ctx, duplicate, nil)
+		require.NoError(t, err)
+		require.False(t, inserted)
+	})
+}
+
+func (rts *RepositoryTestSuite) TestOrderRepository_CreateWithLinesRollsBack() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		shopRepo, productRepo, variantRepo, _, _, orderRepo, _, _, _ := rts.getRepos(ctx, svc)
+
+		shop := rts.createTestShop(ctx, shopRepo)
+		product := rts.createTestProduct(ctx, productRepo, shop.GetID())
+		inStock := rts.createTestVariant(ctx, variantRepo, product.GetID())
+		scarce := rts.createTestVariant(ctx, variantRepo, product.GetID())
+
+		order := &models.Order{
+			ShopID:      shop.GetID(),
+			OrderNumber: "ORD-" + util.RandomAlphaNumericString(10),
+			Status:      1,
+		}
+		lines := []*models.OrderLine{
+			{ProductVariantID: inStock.GetID(), Quantity: 1},
+			{ProductVariantID: scarce.GetID(), Quantity: scarce.StockQuantity + 1},
+		}
+
+		inserted, err := orderRepo.CreateWithLines(ctx, order, lines)
+		require.False(t, inserted)
+		var stockErr *repository.InsufficientStockError
+		require.ErrorAs(t, err, &stockErr)
+		require.Equal(t, scarce.GetID(), stockErr.VariantID)
+		require.Equal(t, 1, stockErr.Line)
+		require.Equal(t, scarce.StockQuantity+1, stockErr.Requested)
+		require.Equal(t, scarce.StockQuantity, stockErr.Available)
+
+		// Nothing from the failed order is persisted.
+		_, err = orderRepo.GetByID(ctx, order.GetID())
+		require.Error(t, err)
+
+		reloaded, err := variantRepo.GetByID(ctx, inStock.GetID())
+		require.NoError(t, err)
+		require.Equal(t, inStock.StockQuantity, reloaded.StockQuantity)
+	})
+}
+
+func (rts *RepositoryTestSuite) TestOrderRepository_ReleaseStock() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		shopRepo, productRepo, variantRepo, _, _, orderRepo, _, _, _ := rts.getRepos(ctx, svc)
+
+		shop := rts.createTestShop(ctx, shopRepo)
+		product := rts.createTestProduct(ctx, productRepo, shop.GetID())
+		variant := rts.createTestVariant(ctx, variantRepo, product.GetID())
+
+		order := &models.Order{
+			ShopID:      shop.GetID(),
+			OrderNumber: "ORD-" + util.RandomAlphaNumericString(10),
+			Status:      1,
+		}
+		lines := []*models.OrderLine{{ProductVariantID: variant.GetID(), Quantity: 3}}
+		inserted, err := orderRepo.CreateWithLines(ctx, order, lines)
+		require.NoError(t, err)
+		require.True(t, inserted)
+
+		taken, err := variantRepo.GetByID(ctx, variant.GetID())
+		require.NoError(t, err)
+		require.Equal(t, variant.StockQuantity-3, taken.StockQuantity)
+
+		released, err := orderRepo.ReleaseStock(ctx, order.GetID())
+		require.NoError(t, err)
+		require.True(t, released)
+
+		restored, err := variantRepo.GetByID(ctx, variant.GetID())
+		require.NoError(t, err)
+		require.Equal(t, variant.StockQuantity, restored.StockQuantity)
+
+		// The stock is only given back once.
+		released, err = orderRepo.ReleaseStock(ctx, order.GetID())
+		require.NoError(t, err)
+		require.False(t, released)
+
+		restored, err = variantRepo.GetByID(ctx, variant.GetID())
+		require.NoError(t, err)
+		require.Equal(t, variant.StockQuantity, restored.StockQuantity)
 	})
 }
 
@@ -568,11 +786,14 @@ func (rts *RepositoryTestSuite) TestFulfilmentLineRepository_GetFulfilledQuantit
 		err = orderLineRepo.Create(ctx, orderLine)
 		require.NoError(t, err)
 
-		// Create two fulfilments with partial quantities
-		for _, qty := range []int64{3, 4} {
+		// Create two fulfilments with partial quantities and a cancelled one
+		for _, fq := range []struct {
+			status int32
+			qty    int64
+		}{{1, 3}, {4, 4}, {6, 2}} {
 			f := &models.Fulfilment{
 				OrderID: order.GetID(),
-				Status:  1,
+				Status:  fq.status,
 			}
 			f.GenID(ctx)
 			err = fulfilmentRepo.Create(ctx, f)
@@ -581,7 +802,7 @@ func (rts *RepositoryTestSuite) TestFulfilmentLineRepository_GetFulfilledQuantit
 			fl := &models.FulfilmentLine{
 				FulfilmentID: f.GetID(),
 				OrderLineID:  orderLine.GetID(),
-				Quantity:     qty,
+				Quantity:     fq.qty,
 			}
 			fl.GenID(ctx)
 			err = fulfilmentLineRepo.Create(ctx, fl)
@@ -591,6 +812,187 @@ func (rts *RepositoryTestSuite) TestFulfilmentLineRepository_GetFulfilledQuantit
 		total, err := fulfilmentLineRepo.GetFulfilledQuantityByOrderLineID(ctx, orderLine.GetID())
 		require.NoError(t, err)
 		require.Equal(t, int64(7), total)
+
+		quantities, err := fulfilmentLineRepo.ListQuantitiesByOrderID(ctx, order.GetID())
+		require.NoError(t, err)
+		require.Len(t, quantities, 3)
+	})
+}
+
+// --- Shop Sequence Repository Tests ---
+
+func (rts *RepositoryTestSuite) TestShopSequenceRepository_NextValue() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		shopRepo, _, _, _, _, _, _, _, _ := rts.getRepos(ctx, svc)
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		sequenceRepo := repository.NewShopSequenceRepository(ctx, dbPool, svc.WorkManager())
+
+		shopA := rts.createTestShop(ctx, shopRepo)
+		shopB := rts.createTestShop(ctx, shopRepo)
+
+		for want := int64(1); want <= 3; want++ {
+			got, err := sequenceRepo.NextValue(ctx, shopA.GetID(), "order")
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+		}
+
+		// Sequences are independent per shop and per name.
+		got, err := sequenceRepo.NextValue(ctx, shopB.GetID(), "order")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), got)
+
+		got, err = sequenceRepo.NextValue(ctx, shopA.GetID(), "invoice")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), got)
+	})
+}
+
+func (rts *RepositoryTestSuite) TestShopSequenceRepository_NextValueConcurrent() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		shopRepo, _, _, _, _, _, _, _, _ := rts.getRepos(ctx, svc)
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		sequenceRepo := repository.NewShopSequenceRepository(ctx, dbPool, svc.WorkManager())
+
+		shop := rts.createTestShop(ctx, shopRepo)
+
+		const workers = 20
+		values := make([]int64, workers)
+		errs := make([]error, workers)
+
+		var wg sync.WaitGroup
+		for i := range workers {
+			wg.Add(1)
+			go func(idx int) {
+				defer wg.Done()
+				values[idx], errs[idx] = sequenceRepo.NextValue(ctx, shop.GetID(), "order")
+			}(i)
+		}
+		wg.Wait()
+
+		seen := make(map[int64]struct{}, workers)
+		for i := range workers {
+			require.NoError(t, errs[i])
+			seen[values[i]] = struct{}{}
+		}
+		require.Len(t, seen, workers)
+		for want := int64(1); want <= workers; want++ {
+			require.Contains(t, seen, want)
+		}
+	})
+}
+
+func (rts *RepositoryTestSuite) TestInvoiceRepository_Issue() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		shopRepo, _, _, _, _, _, _, _, _ := rts.getRepos(ctx, svc)
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		invoiceRepo := repository.NewInvoiceRepository(ctx, dbPool, svc.WorkManager())
+
+		shop := rts.createTestShop(ctx, shopRepo)
+		number := func(seq int64) string { return fmt.Sprintf("INV-%06d", seq) }
+		newInvoice := func(orderID string) *models.Invoice {
+			return &models.Invoice{
+				ShopID:   shop.GetID(),
+				OrderID:  orderID,
+				Kind:     models.InvoiceKindInvoice,
+				IssuedAt: time.Now(),
+				Currency: "USD",
+				Lines: []*models.InvoiceLine{
+					{OrderLineID: "line-1", SKU: "SKU-1", Name: "Item", Quantity: 2, TotalUnits: 20},
+				},
+			}
+		}
+
+		first := newInvoice("order-1")
+		created, err := invoiceRepo.Issue(ctx, first, "invoice", number)
+		require.NoError(t, err)
+		require.True(t, created)
+		require.Equal(t, "INV-000001", first.Number)
+
+		// A second invoice for the same order is refused without using a number.
+		created, err = invoiceRepo.Issue(ctx, newInvoice("order-1"), "invoice", number)
+		require.NoError(t, err)
+		require.False(t, created)
+
+		second := newInvoice("order-2")
+		created, err = invoiceRepo.Issue(ctx, second, "invoice", number)
+		require.NoError(t, err)
+		require.True(t, created)
+		require.Equal(t, "INV-000002", second.Number)
+
+		stored, err := invoiceRepo.GetWithLines(ctx, first.GetID())
+		require.NoError(t, err)
+		require.Len(t, stored.Lines, 1)
+		require.Equal(t, int64(2), stored.Lines[0].Quantity)
+	})
+}
+
+func (rts *RepositoryTestSuite) TestWebhookDeliveryRepository_ClaimAndRecord() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		dbPool := svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName)
+		deliveryRepo := repository.NewWebhookDeliveryRepository(ctx, dbPool, svc.WorkManager())
+
+		now := time.Now()
+		delivery := &models.WebhookDelivery{
+			EndpointID:    "endpoint-1",
+			ShopID:        "shop-1",
+			EventID:       "event-1",
+			EventType:     "order.created",
+			Payload:       `{}`,
+			Status:        models.WebhookDeliveryPending,
+			NextAttemptAt: now,
+		}
+		created, err := deliveryRepo.TryCreate(ctx, delivery)
+		require.NoError(t, err)
+		require.True(t, created)
+
+		// The same event is queued once per endpoint.
+		created, err = deliveryRepo.TryCreate(ctx, &models.WebhookDelivery{
+			EndpointID: "endpoint-1", EventID: "event-1", Status: models.WebhookDeliveryPending, NextAttemptAt: now,
+		})
+		require.NoError(t, err)
+		require.False(t, created)
+
+		claimed, err := deliveryRepo.Claim(ctx, delivery.GetID(), now, time.Minute)
+		require.NoError(t, err)
+		require.True(t, claimed)
+		claimed, err = deliveryRepo.Claim(ctx, delivery.GetID(), now, time.Minute)
+		require.NoError(t, err)
+		require.False(t, claimed, "a claimed delivery is not due until its lease runs out")
+
+		delivery.Status = models.WebhookDeliveryDead
+		delivery.Attempts = 1
+		delivery.LastStatusCode = 500
+		err = deliveryRepo.RecordAttempt(ctx, delivery, &models.WebhookAttempt{
+			Attempt: 1, StatusCode: 500, Error: "unexpected response status", AttemptedAt: now,
+		})
+		require.NoError(t, err)
+
+		due, err := deliveryRepo.ListDue(ctx, now.Add(time.Hour), 10)
+		require.NoError(t, err)
+		require.Empty(t, due)
+
+		require.NoError(t, deliveryRepo.Requeue(ctx, delivery.GetID(), now))
+		due, err = deliveryRepo.ListDue(ctx, now, 10)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		require.Equal(t, int32(0), due[0].Attempts)
+
+		attempts, err := deliveryRepo.ListAttempts(ctx, delivery.GetID())
+		require.NoError(t, err)
+		require.Len(t, attempts, 1)
+		require.Equal(t, int32(500), attempts[0].StatusCode)
 	})
 }
 
@@ -604,3 +1006,249 @@ func (rts *RepositoryTestSuite) TestMigrate() {
 		require.NoError(t, err)
 	})
 }
+
+func (rts *RepositoryTestSuite) TestShopRepository_TryCreateWithOwner() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		shopRepo, _, _, _, _, _, _, _, _ := rts.getRepos(ctx, svc)
+		memberRepo := repository.NewShopMemberRepository(
+			ctx, svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName), svc.WorkManager())
+
+		slug := "owned-" + util.RandomAlphaNumericString(6)
+		shop := &models.Shop{Name: "Owned", Slug: slug, Status: 1}
+		inserted, err := shopRepo.TryCreate(ctx, shop, &models.ShopMember{ProfileID: "creator-1", Role: "owner"})
+		require.NoError(t, err)
+		require.True(t, inserted)
+
+		member, err := memberRepo.GetByShopAndProfile(ctx, shop.GetID(), "creator-1")
+		require.NoError(t, err)
+		require.Equal(t, "owner", member.Role)
+
+		// A taken slug writes neither the shop nor the membership.
+		clash := &models.Shop{Name: "Clash", Slug: slug, Status: 1}
+		inserted, err = shopRepo.TryCreate(ctx, clash, &models.ShopMember{ProfileID: "creator-2", Role: "owner"})
+		require.NoError(t, err)
+		require.False(t, inserted)
+
+		members, err := memberRepo.ListByShopID(ctx, clash.GetID())
+		require.NoError(t, err)
+		require.Empty(t, members)
+	})
+}
+
+func (rts *RepositoryTestSuite) TestBackfillShopOwners() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		shopRepo, _, _, _, _, _, _, _, _ := rts.getRepos(ctx, svc)
+		memberRepo := repository.NewShopMemberRepository(
+			ctx, svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName), svc.WorkManager())
+
+		ownerless := rts.createTestShop(ctx, shopRepo)
+		managed := rts.createTestShop(ctx, shopRepo)
+		owned := rts.createTestShop(ctx, shopRepo)
+
+		admin := "admin-" + util.RandomAlphaNumericString(8)
+		seed := []*models.ShopMember{
+			{ShopID: managed.GetID(), ProfileID: admin, Role: "manager"},
+			{ShopID: owned.GetID(), ProfileID: "owner-" + util.RandomAlphaNumericString(8), Role: "owner"},
+		}
+		for _, member := range seed {
+			member.GenID(ctx)
+			require.NoError(t, memberRepo.Create(ctx, member))
+		}
+
+		_, err := repository.BackfillShopOwners(ctx, svc.DatastoreManager(), admin)
+		require.NoError(t, err)
+
+		for _, shopID := range []string{ownerless.GetID(), managed.GetID()} {
+			member, getErr := memberRepo.GetByShopAndProfile(ctx, shopID, admin)
+			require.NoError(t, getErr)
+			require.Equal(t, "owner", member.Role)
+		}
+		_, err = memberRepo.GetByShopAndProfile(ctx, owned.GetID(), admin)
+		require.Error(t, err)
+
+		// Running it again finds nothing left to do.
+		_, err = repository.BackfillShopOwners(ctx, svc.DatastoreManager(), admin)
+		require.NoError(t, err)
+		members, err := memberRepo.ListByShopID(ctx, ownerless.GetID())
+		require.NoError(t, err)
+		require.Len(t, members, 1)
+	})
+}
+
+func (rts *RepositoryTestSuite) TestProductRepository_SaveWithVariantsIsAtomic() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		_, productRepo, variantRepo, _, _, _, _, _, _ := rts.getRepos(ctx, svc)
+
+		product := &models.Product{ShopID: "shop-1", Name: "Mug", Slug: "mug"}
+		variants := []*models.ProductVariant{
+			{ShopID: "shop-1", SKU: "MUG-1", CurrencyCode: "USD", PriceUnits: 5},
+			{ShopID: "shop-1", SKU: "MUG-2", CurrencyCode: "USD", PriceUnits: 6},
+		}
+		require.NoError(t, productRepo.SaveWithVariants(ctx, product, nil, variants, nil))
+		saved, err := variantRepo.ListByProductID(ctx, product.GetID())
+		require.NoError(t, err)
+		require.Len(t, saved, 2)
+
+		// A taken SKU rolls back the whole product.
+		plate := &models.Product{ShopID: "shop-1", Name: "Plate", Slug: "plate"}
+		err = productRepo.SaveWithVariants(ctx, plate, nil, []*models.ProductVariant{
+			{ShopID: "shop-1", SKU: "PLATE-1", CurrencyCode: "USD", PriceUnits: 9},
+			{ShopID: "shop-1", SKU: "MUG-2", CurrencyCode: "USD", PriceUnits: 9},
+		}, nil)
+		require.ErrorIs(t, err, repository.ErrCatalogConflict)
+		_, err = productRepo.GetBySlug(ctx, "shop-1", "plate")
+		require.Error(t, err)
+		_, err = variantRepo.GetBySKU(ctx, "shop-1", "PLATE-1")
+		require.Error(t, err)
+
+		// Updates write only the named columns and are guarded by version.
+		first := saved[0]
+		first.PriceUnits = 7
+		first.Name = "ignored"
+		require.NoError(t, productRepo.SaveWithVariants(ctx, product, nil,
+			[]*models.ProductVariant{first}, []string{"price_units"}))
+		reloaded, err := variantRepo.GetByID(ctx, first.GetID())
+		require.NoError(t, err)
+		require.EqualValues(t, 7, reloaded.PriceUnits)
+		require.Empty(t, reloaded.Name)
+
+		stale := *reloaded
+		stale.Version--
+		err = productRepo.SaveWithVariants(ctx, product, nil,
+			[]*models.ProductVariant{&stale}, []string{"price_units"})
+		require.ErrorIs(t, err, repository.ErrCatalogConflict)
+	})
+}
+
+func (rts *RepositoryTestSuite) TestSalesRollupRepository_RefreshDayAndWatermark() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		shopRepo, _, _, _, _, orderRepo, _, _, _ := rts.getRepos(ctx, svc)
+		rollupRepo := repository.NewSalesRollupRepository(
+			ctx, svc.DatastoreManager().GetPool(ctx, datastore.DefaultPoolName), svc.WorkManager())
+
+		shop := rts.createTestShop(ctx, shopRepo)
+		for _, status := range []int32{1, 1, 2} {
+			order := &models.Order{
+				ShopID:        shop.GetID(),
+				OrderNumber:   "ORD-" + util.RandomAlphaNumericString(10),
+				Status:        status,
+				TotalCurrency: "USD",
+				TotalUnits:    3,
+				TotalNanos:    600000000,
+			}
+			require.NoError(t, orderRepo.Create(ctx, order))
+		}
+
+		now := time.Now().UTC()
+		day := now.Truncate(24 * time.Hour)
+		hours, err := rollupRepo.ListChangedOrderHours(ctx, now.Add(-time.Minute))
+		require.NoError(t, err)
+		require.Contains(t, hours, repository.OrderHour{ShopID: shop.GetID(), Hour: now.Truncate(time.Hour)})
+
+		// Refreshing twice replaces the day's rollups rather than adding to them.
+		for range 2 {
+			require.NoError(t, rollupRepo.RefreshDay(ctx, shop, day, day, day.AddDate(0, 0, 1)))
+		}
+		days, err := rollupRepo.ListDaily(ctx, shop.GetID(), day, day)
+		require.NoError(t, err)
+		require.Len(t, days, 1)
+		require.EqualValues(t, 3, days[0].OrderCount)
+		require.EqualValues(t, 1, days[0].CancelledCount)
+		require.EqualValues(t, 7, days[0].GrossUnits)
+		require.EqualValues(t, 200000000, days[0].GrossNanos)
+
+		name := "test-" + util.RandomAlphaNumericString(6)
+		watermark, err := rollupRepo.GetWatermark(ctx, name)
+		require.NoError(t, err)
+		require.True(t, watermark.IsZero())
+
+		require.NoError(t, rollupRepo.AdvanceWatermark(ctx, name, now))
+		require.NoError(t, rollupRepo.AdvanceWatermark(ctx, name, now.Add(-time.Hour)))
+		watermark, err = rollupRepo.GetWatermark(ctx, name)
+		require.NoError(t, err)
+		require.WithinDuration(t, now, watermark, time.Millisecond)
+	})
+}
+
+func (rts *RepositoryTestSuite) TestOrderRepository_ListByProfile() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		shopRepo, _, _, _, _, orderRepo, _, _, _ := rts.getRepos(ctx, svc)
+
+		shop := rts.createTestShop(ctx, shopRepo)
+		profileID := "profile-" + util.RandomAlphaNumericString(8)
+		for _, status := range []int32{1, 2, 1} {
+			order := &models.Order{
+				ShopID:      shop.GetID(),
+				OrderNumber: "ORD-" + util.RandomAlphaNumericString(10),
+				Status:      status,
+				ProfileID:   profileID,
+			}
+			require.NoError(t, orderRepo.Create(ctx, order))
+		}
+
+		all, err := orderRepo.ListByProfile(ctx, repository.OrderHistoryFilter{ProfileID: profileID}, 10, 0)
+		require.NoError(t, err)
+		require.Len(t, all, 3)
+		require.False(t, all[0].CreatedAt.Before(all[2].CreatedAt))
+
+		confirmed, err := orderRepo.ListByProfile(ctx, repository.OrderHistoryFilter{
+			ProfileID: profileID, ShopID: shop.GetID(), Statuses: []int32{1},
+		}, 10, 0)
+		require.NoError(t, err)
+		require.Len(t, confirmed, 2)
+
+		other, err := orderRepo.ListByProfile(ctx, repository.OrderHistoryFilter{ProfileID: "nobody"}, 10, 0)
+		require.NoError(t, err)
+		require.Empty(t, other)
+	})
+}
+
+func (rts *RepositoryTestSuite) TestOrderRepository_ListForExport() {
+	t := rts.T()
+
+	rts.WithTestDependancies(t, func(t *testing.T, dep *definition.DependencyOption) {
+		ctx, svc := rts.CreateService(t, dep)
+		shopRepo, _, _, _, _, orderRepo, _, _, _ := rts.getRepos(ctx, svc)
+
+		shop := rts.createTestShop(ctx, shopRepo)
+		var ids []string
+		for _, status := range []int32{1, 1, 2} {
+			order := &models.Order{
+				ShopID:      shop.GetID(),
+				OrderNumber: "ORD-" + util.RandomAlphaNumericString(10),
+				Status:      status,
+			}
+			require.NoError(t, orderRepo.Create(ctx, order))
+			ids = append(ids, order.GetID())
+		}
+
+		filter := repository.OrderExportFilter{ShopID: shop.GetID(), Statuses: []int32{1}}
+		page, err := orderRepo.ListForExport(ctx, filter, "", 1)
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		rest, err := orderRepo.ListForExport(ctx, filter, page[0].GetID(), 10)
+		require.NoError(t, err)
+		require.Len(t, rest, 1)
+		require.ElementsMatch(t, ids[:2], []string{page[0].GetID(), rest[0].GetID()})
+
+		filter = repository.OrderExportFilter{ShopID: shop.GetID(), From: time.Now().Add(time.Minute)}
+		none, err := orderRepo.ListForExport(ctx, filter, "", 10)
+		require.NoError(t, err)
+		require.Empty(t, none)
+	})
+}
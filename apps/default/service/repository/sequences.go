@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/pitabwire/frame/datastore"
+	"github.com/pitabwire/frame/datastore/pool"
+	"github.com/pitabwire/frame/workerpool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+type shopSequenceRepository struct {
+	datastore.BaseRepository[*models.ShopSequence]
+}
+
+func NewShopSequenceRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) ShopSequenceRepository {
+	return &shopSequenceRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.ShopSequence](
+			ctx, dbPool, workMan, func() *models.ShopSequence { return &models.ShopSequence{} },
+		),
+	}
+}
+
+// NextValue atomically increments and returns the named counter for a shop.
+// The counter row is created on first use, and concurrent callers are
+// serialised by the row lock taken by the upsert.
+func (r *shopSequenceRepository) NextValue(ctx context.Context, shopID, name string) (int64, error) {
+	return nextSequenceValue(r.Pool().DB(ctx, false), shopID, name)
+}
+
+// nextSequenceValue increments the counter through db. Called inside a
+// transaction, the increment is undone if the transaction rolls back, so
+// numbers drawn there leave no gaps.
+func nextSequenceValue(db *gorm.DB, shopID, name string) (int64, error) {
+	seq := &models.ShopSequence{
+		ShopID: shopID,
+		Name:   name,
+		Value:  1,
+	}
+
+	err := db.
+		Clauses(
+			clause.OnConflict{
+				Columns: []clause.Column{{Name: "shop_id"}, {Name: "name"}},
+				DoUpdates: clause.Assignments(map[string]any{
+					"value":       gorm.Expr("shop_sequences.value + 1"),
+					"modified_at": time.Now(),
+				}),
+			},
+			clause.Returning{Columns: []clause.Column{{Name: "value"}}},
+		).
+		Create(seq).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return seq.Value, nil
+}
@@ -6,6 +6,8 @@ import (
 	"github.com/pitabwire/frame/datastore"
 	"github.com/pitabwire/frame/datastore/pool"
 	"github.com/pitabwire/frame/workerpool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/antinvestor/service-commerce/apps/default/service/models"
 )
@@ -22,8 +24,34 @@ func NewShopRepository(ctx context.Context, dbPool pool.Pool, workMan workerpool
 	}
 }
 
+// GetBySlug finds a shop within the caller's tenant; slugs are only unique per tenant.
 func (r *shopRepository) GetBySlug(ctx context.Context, slug string) (*models.Shop, error) {
 	shop := &models.Shop{}
-	err := r.Pool().DB(ctx, true).First(shop, "slug = ?", slug).Error
+	err := r.Pool().DB(ctx, true).Scopes(tenantScope(ctx)).First(shop, "slug = ?", slug).Error
 	return shop, err
 }
+
+// TryCreate inserts the shop, with owner as its first member when given, in
+// one transaction and reports whether the shop was written. It returns false
+// without an error when the slug is already taken in the tenant.
+func (r *shopRepository) TryCreate(ctx context.Context, shop *models.Shop, owner *models.ShopMember) (bool, error) {
+	inserted := false
+	err := r.Pool().DB(ctx, false).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(shop)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		inserted = true
+
+		if owner == nil {
+			return nil
+		}
+		owner.ShopID = shop.GetID()
+		owner.CopyPartitionInfo(&shop.BaseModel)
+		return tx.Create(owner).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	return inserted, nil
+}
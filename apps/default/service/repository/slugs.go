@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/pitabwire/frame/datastore"
+	"github.com/pitabwire/frame/datastore/pool"
+	"github.com/pitabwire/frame/workerpool"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+type slugRedirectRepository struct {
+	datastore.BaseRepository[*models.SlugRedirect]
+}
+
+func NewSlugRedirectRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) SlugRedirectRepository {
+	return &slugRedirectRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.SlugRedirect](
+			ctx, dbPool, workMan, func() *models.SlugRedirect { return &models.SlugRedirect{} },
+		),
+	}
+}
+
+// Find looks up a retired slug within the caller's tenant.
+func (r *slugRedirectRepository) Find(
+	ctx context.Context,
+	entityType, scopeID, slug string,
+) (*models.SlugRedirect, error) {
+	redirect := &models.SlugRedirect{}
+	err := r.Pool().DB(ctx, true).Scopes(tenantScope(ctx)).
+		First(redirect, "entity_type = ? AND scope_id = ? AND slug = ?", entityType, scopeID, slug).Error
+	return redirect, err
+}
+
+// Remove hard deletes the redirect so its slug can be reclaimed.
+func (r *slugRedirectRepository) Remove(ctx context.Context, redirect *models.SlugRedirect) error {
+	return r.Pool().DB(ctx, false).Unscoped().Delete(redirect).Error
+}
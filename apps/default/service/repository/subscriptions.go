@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/pitabwire/frame/datastore"
+	"github.com/pitabwire/frame/datastore/pool"
+	"github.com/pitabwire/frame/workerpool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+type subscriptionRepository struct {
+	datastore.BaseRepository[*models.Subscription]
+}
+
+func NewSubscriptionRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) SubscriptionRepository {
+	return &subscriptionRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.Subscription](
+			ctx, dbPool, workMan, func() *models.Subscription { return &models.Subscription{} },
+		),
+	}
+}
+
+func (r *subscriptionRepository) GetWithLines(ctx context.Context, id string) (*models.Subscription, error) {
+	subscription := &models.Subscription{}
+	err := r.Pool().DB(ctx, false).
+		Preload(clause.Associations).
+		First(subscription, "id = ?", id).Error
+	return subscription, err
+}
+
+// CreateWithLines persists the subscription and its lines in one transaction.
+func (r *subscriptionRepository) CreateWithLines(
+	ctx context.Context,
+	subscription *models.Subscription,
+	lines []*models.SubscriptionLine,
+) error {
+	return r.Pool().DB(ctx, false).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(subscription).Error; err != nil {
+			return err
+		}
+		for _, line := range lines {
+			line.SubscriptionID = subscription.GetID()
+			line.CopyPartitionInfo(&subscription.BaseModel)
+		}
+		if err := tx.Create(lines).Error; err != nil {
+			return err
+		}
+		subscription.Lines = lines
+		return nil
+	})
+}
+
+func (r *subscriptionRepository) ListByProfileID(
+	ctx context.Context,
+	profileID string,
+	limit, offset int,
+) ([]*models.Subscription, error) {
+	var subscriptions []*models.Subscription
+	query := r.Pool().DB(ctx, true).
+		Preload(clause.Associations).
+		Where("profile_id = ?", profileID).
+		Order("created_at DESC")
+	err := paginate(query, limit, offset).Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+// ListDue lists active subscriptions whose next run is at or before now,
+// oldest first.
+func (r *subscriptionRepository) ListDue(
+	ctx context.Context,
+	now time.Time,
+	limit int,
+) ([]*models.Subscription, error) {
+	var subscriptions []*models.Subscription
+	err := r.Pool().DB(ctx, false).
+		Preload(clause.Associations).
+		Where("status = ? AND next_run_at <= ?", models.SubscriptionStatusActive, now).
+		Order("next_run_at ASC").
+		Limit(limit).
+		Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+// AdvanceCycle moves the subscription's next run from "from" to "to",
+// reporting false when another run already moved it. A non-empty orderID
+// records the order the finished cycle produced.
+func (r *subscriptionRepository) AdvanceCycle(
+	ctx context.Context,
+	id string,
+	from, to time.Time,
+	orderID string,
+) (bool, error) {
+	columns := map[string]any{
+		"next_run_at": to,
+		"modified_at": time.Now(),
+		"version":     gorm.Expr("version + 1"),
+	}
+	if orderID != "" {
+		columns["last_order_id"] = orderID
+		columns["cycle_count"] = gorm.Expr("cycle_count + 1")
+	}
+
+	result := r.Pool().DB(ctx, false).
+		Model(&models.Subscription{}).
+		Where("id = ? AND next_run_at = ?", id, from).
+		UpdateColumns(columns)
+	return result.RowsAffected > 0, result.Error
+}
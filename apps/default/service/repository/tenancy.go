@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/pitabwire/frame/security"
+	"gorm.io/gorm"
+)
+
+// tenantScope restricts a lookup on a tenant-unique key, such as a shop slug or
+// a SKU, to the caller's tenant. Frame's partition scope is skipped for
+// internal callers, who still act on behalf of a single tenant, so these keys
+// are matched against the tenant explicitly.
+func tenantScope(ctx context.Context) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		claims := security.ClaimsFromContext(ctx)
+		if claims == nil {
+			return db
+		}
+		return db.Where("tenant_id = ?", claims.GetTenantID())
+	}
+}
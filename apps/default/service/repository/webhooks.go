@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/pitabwire/frame/datastore"
+	"github.com/pitabwire/frame/datastore/pool"
+	"github.com/pitabwire/frame/workerpool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/antinvestor/service-commerce/apps/default/service/models"
+)
+
+type webhookEndpointRepository struct {
+	datastore.BaseRepository[*models.WebhookEndpoint]
+}
+
+func NewWebhookEndpointRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) WebhookEndpointRepository {
+	return &webhookEndpointRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.WebhookEndpoint](
+			ctx, dbPool, workMan, func() *models.WebhookEndpoint { return &models.WebhookEndpoint{} },
+		),
+	}
+}
+
+func (r *webhookEndpointRepository) ListByShopID(ctx context.Context, shopID string) ([]*models.WebhookEndpoint, error) {
+	var endpoints []*models.WebhookEndpoint
+	err := r.Pool().DB(ctx, false).
+		Where("shop_id = ?", shopID).
+		Order("created_at ASC").
+		Find(&endpoints).Error
+	return endpoints, err
+}
+
+type webhookDeliveryRepository struct {
+	datastore.BaseRepository[*models.WebhookDelivery]
+}
+
+func NewWebhookDeliveryRepository(
+	ctx context.Context,
+	dbPool pool.Pool,
+	workMan workerpool.Manager,
+) WebhookDeliveryRepository {
+	return &webhookDeliveryRepository{
+		BaseRepository: datastore.NewBaseRepository[*models.WebhookDelivery](
+			ctx, dbPool, workMan, func() *models.WebhookDelivery { return &models.WebhookDelivery{} },
+		),
+	}
+}
+
+// TryCreate inserts the delivery and reports whether a row was written. It
+// returns false without an error when the event is already queued for the
+// endpoint.
+func (r *webhookDeliveryRepository) TryCreate(ctx context.Context, delivery *models.WebhookDelivery) (bool, error) {
+	result := r.Pool().DB(ctx, false).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(delivery)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Claim reserves a due pending delivery for one attempt by pushing its next
+// attempt out by lease. It returns false when the delivery is not due, not
+// pending or already claimed by another worker. A worker that dies mid
+// attempt leaves the delivery due again once the lease runs out.
+func (r *webhookDeliveryRepository) Claim(
+	ctx context.Context,
+	id string,
+	now time.Time,
+	lease time.Duration,
+) (bool, error) {
+	result := r.Pool().DB(ctx, false).
+		Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", id, models.WebhookDeliveryPending, now).
+		Update("next_attempt_at", now.Add(lease))
+	return result.RowsAffected > 0, result.Error
+}
+
+// RecordAttempt logs the attempt and stores the delivery's resulting state
+// together.
+func (r *webhookDeliveryRepository) RecordAttempt(
+	ctx context.Context,
+	delivery *models.WebhookDelivery,
+	attempt *models.WebhookAttempt,
+) error {
+	return r.Pool().DB(ctx, false).Transaction(func(tx *gorm.DB) error {
+		attempt.DeliveryID = delivery.GetID()
+		attempt.CopyPartitionInfo(&delivery.BaseModel)
+		if err := tx.Create(attempt).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.WebhookDelivery{}).
+			Where("id = ?", delivery.GetID()).
+			Updates(map[string]any{
+				"status":           delivery.Status,
+				"attempts":         delivery.Attempts,
+				"next_attempt_at":  delivery.NextAttemptAt,
+				"last_attempt_at":  delivery.LastAttemptAt,
+				"last_status_code": delivery.LastStatusCode,
+				"last_error":       delivery.LastError,
+				"delivered_at":     delivery.DeliveredAt,
+			}).Error
+	})
+}
+
+// Requeue makes the delivery pending and due at now with a fresh set of
+// attempts, whatever state it was in. Its attempt log is kept.
+func (r *webhookDeliveryRepository) Requeue(ctx context.Context, id string, now time.Time) error {
+	return r.Pool().DB(ctx, false).
+		Model(&models.WebhookDelivery{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"status":          models.WebhookDeliveryPending,
+			"attempts":        0,
+			"next_attempt_at": now,
+		}).Error
+}
+
+// ListDue lists pending deliveries whose next attempt is due, oldest first.
+func (r *webhookDeliveryRepository) ListDue(
+	ctx context.Context,
+	now time.Time,
+	limit int,
+) ([]*models.WebhookDelivery, error) {
+	var deliveries []*models.WebhookDelivery
+	err := r.Pool().DB(ctx, true).
+		Where("status = ? AND next_attempt_at <= ?", models.WebhookDeliveryPending, now).
+		Order("next_attempt_at ASC").
+		Limit(limit).
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+// ListByEndpointID lists the endpoint's most recent deliveries, optionally
+// only those in one status.
+func (r *webhookDeliveryRepository) ListByEndpointID(
+	ctx context.Context,
+	endpointID string,
+	status int32,
+	limit int,
+) ([]*models.WebhookDelivery, error) {
+	var deliveries []*models.WebhookDelivery
+	db := r.Pool().DB(ctx, true).Where("endpoint_id = ?", endpointID)
+	if status != 0 {
+		db = db.Where("status = ?", status)
+	}
+	err := db.Order("created_at DESC").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// ListAttempts lists the delivery's attempts in the order they were made.
+func (r *webhookDeliveryRepository) ListAttempts(ctx context.Context, deliveryID string) ([]*models.WebhookAttempt, error) {
+	var attempts []*models.WebhookAttempt
+	err := r.Pool().DB(ctx, true).
+		Where("delivery_id = ?", deliveryID).
+		Order("attempted_at ASC").
+		Find(&attempts).Error
+	return attempts, err
+}
@@ -7,6 +7,10 @@ import (
 	"github.com/pitabwire/frame/data"
 )
 
+// CleanErr returns err as a connect error for the API. When a connect error
+// carrying details was wrapped in another, as data.ErrorConvertToAPI does,
+// the one with the details is returned so clients still receive them with
+// the code they were raised with.
 func CleanErr(err error) *connect.Error {
 	if err == nil {
 		return nil
@@ -14,9 +18,16 @@ func CleanErr(err error) *connect.Error {
 
 	var cerr *connect.Error
 	ok := errors.As(err, &cerr)
-	if ok {
-		return cerr
+	if !ok {
+		return data.ErrorConvertToAPI(err)
 	}
 
-	return data.ErrorConvertToAPI(err)
+	for inner := cerr; ; {
+		if len(inner.Details()) > 0 {
+			return inner
+		}
+		if !errors.As(inner.Unwrap(), &inner) {
+			return cerr
+		}
+	}
 }
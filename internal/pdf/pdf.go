@@ -0,0 +1,144 @@
+// Package pdf writes simple text-only PDF documents: rows of text set in the
+// standard Helvetica fonts, flowing over A4 pages. The standard fonts need no
+// embedding, and the output carries no timestamps, so identical input always
+// produces identical bytes.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Font selects one of the standard fonts.
+type Font string
+
+const (
+	Helvetica     Font = "F1"
+	HelveticaBold Font = "F2"
+)
+
+// A4 page size and the margin kept clear on every side, in points.
+const (
+	PageWidth  = 595.0
+	PageHeight = 842.0
+	Margin     = 50.0
+)
+
+// lineSpacing is the row height as a multiple of the font size.
+const lineSpacing = 1.4
+
+// Cell is text placed at X points from the left edge of the page.
+type Cell struct {
+	X    float64
+	Text string
+}
+
+// Document accumulates rows and writes them out as a PDF.
+type Document struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+func New() *Document {
+	d := &Document{}
+	d.newPage()
+	return d
+}
+
+func (d *Document) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = PageHeight - Margin
+}
+
+// Row writes one line of cells, starting a new page when the current one is
+// full.
+func (d *Document) Row(font Font, size float64, cells ...Cell) {
+	height := size * lineSpacing
+	if d.y-height < Margin {
+		d.newPage()
+	}
+	d.y -= height
+
+	page := d.pages[len(d.pages)-1]
+	for _, cell := range cells {
+		if cell.Text == "" {
+			continue
+		}
+		fmt.Fprintf(page, "BT /%s %s Tf %s %s Td (%s) Tj ET\n",
+			font, number(size), number(cell.X), number(d.y), escape(cell.Text))
+	}
+}
+
+// Space leaves a vertical gap, in points.
+func (d *Document) Space(points float64) {
+	d.y -= points
+}
+
+// WriteTo writes the document.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var out bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Objects 1 to 4 are the catalog, the page tree and the two fonts; each
+	// page then takes two objects, the page and its content stream.
+	const firstPage = 5
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range d.pages {
+		object(fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] "+
+				"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			number(PageWidth), number(PageHeight), firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.WriteTo(w)
+}
+
+// number formats a coordinate or size without trailing zeros.
+func number(v float64) string {
+	s := strings.TrimRight(fmt.Sprintf("%.2f", v), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// escape makes text safe inside a PDF string. Characters outside Latin-1,
+// which the standard fonts cannot show, become question marks.
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= ' ' && r <= '~':
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -41,8 +41,12 @@
       getProduct: function (id) {
         return commerce("GetProduct", { id: id });
       },
-      listProducts: function (shopId) {
-        return commerce("ListProducts", { shopId: shopId });
+      listProducts: function (shopId, collectionId) {
+        var body = { shopId: shopId };
+        if (collectionId) {
+          body.search = { extras: { collection_id: collectionId } };
+        }
+        return commerce("ListProducts", body);
       },
       listProductVariants: function (productId) {
         return commerce("ListProductVariants", { productId: productId });
@@ -353,7 +357,7 @@
           } else if (shopId) {
             store.setState({ screen: "loading" });
             api
-              .listProducts(shopId)
+              .listProducts(shopId, config.collectionId)
               .then(function (r) {
                 var products = {};
                 (r.products || []).forEach(function (p) {